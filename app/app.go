@@ -6,6 +6,7 @@ import (
 
 	"log/slog"
 
+	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/jekabolt/grbpwr-manager/config"
 	httpapi "github.com/jekabolt/grbpwr-manager/internal/api/http"
 	"github.com/jekabolt/grbpwr-manager/internal/apisrv/admin"
@@ -15,7 +16,14 @@ import (
 	"github.com/jekabolt/grbpwr-manager/internal/cache"
 	"github.com/jekabolt/grbpwr-manager/internal/dependency"
 	"github.com/jekabolt/grbpwr-manager/internal/entity"
+	"github.com/jekabolt/grbpwr-manager/internal/invoice"
 	"github.com/jekabolt/grbpwr-manager/internal/mail"
+	"github.com/jekabolt/grbpwr-manager/internal/payment/erc20"
+	"github.com/jekabolt/grbpwr-manager/internal/payment/eth"
+	"github.com/jekabolt/grbpwr-manager/internal/payment/expiration"
+	"github.com/jekabolt/grbpwr-manager/internal/payment/reconcile"
+	"github.com/jekabolt/grbpwr-manager/internal/payment/registry"
+	"github.com/jekabolt/grbpwr-manager/internal/payment/reminder"
 	"github.com/jekabolt/grbpwr-manager/internal/payment/stripe"
 	"github.com/jekabolt/grbpwr-manager/internal/payment/tron"
 	"github.com/jekabolt/grbpwr-manager/internal/payment/trongrid"
@@ -96,49 +104,37 @@ func (a *App) Start(ctx context.Context) error {
 		return err
 	}
 
-	tg := trongrid.New(&a.c.Trongrid)
-
-	usdtTron, err := tron.New(ctx, &a.c.USDTTronPayment, a.db, a.ma, tg, a.r, entity.USDT_TRON)
-	if err != nil {
-		slog.Default().ErrorContext(ctx, "failed create new usdt tron processor",
-			slog.String("err", err.Error()),
-		)
-		return err
-	}
-
-	tgShasta := trongrid.New(&a.c.TrongridShasta)
-
-	usdtTronTestnet, err := tron.New(ctx, &a.c.USDTTronShastaTestnetPayment, a.db, a.ma, tgShasta, a.r, entity.USDT_TRON_TEST)
+	processors := registry.New()
+	reconciler := reconcile.New(&a.c.Reconciliation, a.db)
+	pool := expiration.New(&a.c.PaymentExpiration, a.db, a.ma, processors)
+	stripeProcessors, err := a.registerProcessors(ctx, processors, reconciler, pool)
 	if err != nil {
-		slog.Default().ErrorContext(ctx, "failed create new usdt tron processor",
+		slog.Default().ErrorContext(ctx, "failed to register payment processors",
 			slog.String("err", err.Error()),
 		)
 		return err
 	}
-
-	stripeMain, err := stripe.New(ctx, &a.c.StripePayment, a.db, a.r, a.ma, entity.CARD)
+	reconciler.Start(ctx)
+	err = pool.Start(ctx)
 	if err != nil {
-		slog.Default().ErrorContext(ctx, "failed create new stripe processor",
+		slog.Default().ErrorContext(ctx, "couldn't start payment expiration worker",
 			slog.String("err", err.Error()),
 		)
 		return err
 	}
+	reminder.New(&a.c.CheckoutReminder, a.db, a.ma).Start(ctx)
 
-	stripeTest, err := stripe.New(ctx, &a.c.StripePaymentTest, a.db, a.r, a.ma, entity.CARD_TEST)
-	if err != nil {
-		slog.Default().ErrorContext(ctx, "failed create new stripe processor",
-			slog.String("err", err.Error()),
-		)
-		return err
-	}
+	invoices := invoice.New(&a.c.Invoice)
 
-	adminS := admin.New(a.db, a.b, a.ma, a.r)
+	adminS := admin.New(a.db, a.b, a.ma, a.r, processors, reconciler, invoices)
 
-	frontendS := frontend.New(a.db, a.ma, a.r, usdtTron, usdtTronTestnet, stripeMain, stripeTest)
+	frontendS := frontend.New(&a.c.Frontend, a.db, a.ma, a.r, processors, invoices)
 
 	// start API server
 	a.hs = httpapi.New(&a.c.HTTP)
-	if err = a.hs.Start(ctx, adminS, frontendS, authS); err != nil {
+	stripeWebhook := stripe.NewWebhook(stripeProcessors...)
+
+	if err = a.hs.Start(ctx, adminS, frontendS, authS, stripeWebhook); err != nil {
 		slog.Default().ErrorContext(ctx, "cannot start http server")
 		return err
 	}
@@ -146,6 +142,159 @@ func (a *App) Start(ctx context.Context) error {
 	return nil
 }
 
+// registerProcessors creates the payment processors of the configured payment methods,
+// a payment method is not available until its processor is registered.
+// The processors schedule the expiration of their invoices in the pool.
+// It returns the stripe processors to receive the webhook events.
+func (a *App) registerProcessors(ctx context.Context, processors *registry.Registry, reconciler *reconcile.Reconciler, pool dependency.PaymentPool) ([]*stripe.Processor, error) {
+	stripeProcessors := []*stripe.Processor{}
+
+	newStripe := func(c *stripe.Config, pmn entity.PaymentMethodName) (dependency.Invoicer, error) {
+		p, err := stripe.New(ctx, c, a.db, a.r, a.ma, pool, pmn)
+		if err != nil {
+			return nil, fmt.Errorf("failed create new stripe processor: %w", err)
+		}
+		stripeProcessors = append(stripeProcessors, p)
+		reconciler.AddStripe(pmn, p)
+		return p, nil
+	}
+
+	newTron := func(c *tron.Config, tgc *trongrid.Config, pmn entity.PaymentMethodName) (dependency.Invoicer, error) {
+		tg := trongrid.New(tgc)
+		p, err := tron.New(ctx, c, a.db, a.ma, tg, a.r, pool, pmn)
+		if err != nil {
+			return nil, fmt.Errorf("failed create new usdt tron processor: %w", err)
+		}
+		reconciler.AddTron(pmn, tg, c.ContractAddress)
+		return p, nil
+	}
+
+	newEth := func(c *eth.Config, pmn entity.PaymentMethodName) (dependency.Invoicer, error) {
+		ec, err := ethclient.DialContext(ctx, c.Node)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to eth node: %w", err)
+		}
+		p, err := eth.New(ctx, c, a.db, a.ma, ec, a.r, pool, pmn)
+		if err != nil {
+			return nil, fmt.Errorf("failed create new eth processor: %w", err)
+		}
+		return p, nil
+	}
+
+	newERC20 := func(c *erc20.Config, pmn entity.PaymentMethodName) (dependency.Invoicer, error) {
+		ec, err := ethclient.DialContext(ctx, c.Node)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to eth node: %w", err)
+		}
+		p, err := erc20.New(ctx, c, a.db, a.ma, ec, a.r, pool, pmn)
+		if err != nil {
+			return nil, fmt.Errorf("failed create new erc20 processor: %w", err)
+		}
+		return p, nil
+	}
+	erc20Configured := func(c *erc20.Config) bool {
+		return c.Node != "" && (c.XPub != "" || c.Mnemonic != "")
+	}
+	ethConfigured := func(c *eth.Config) bool {
+		return c.Node != "" && (c.XPub != "" || c.Mnemonic != "")
+	}
+
+	inits := []struct {
+		pmn        entity.PaymentMethodName
+		configured bool
+		new        func() (dependency.Invoicer, error)
+	}{
+		{
+			pmn:        entity.USDT_TRON,
+			configured: a.c.USDTTronPayment.XPub != "" || a.c.USDTTronPayment.Mnemonic != "",
+			new: func() (dependency.Invoicer, error) {
+				return newTron(&a.c.USDTTronPayment, &a.c.Trongrid, entity.USDT_TRON)
+			},
+		},
+		{
+			pmn:        entity.USDT_TRON_TEST,
+			configured: a.c.USDTTronShastaTestnetPayment.XPub != "" || a.c.USDTTronShastaTestnetPayment.Mnemonic != "",
+			new: func() (dependency.Invoicer, error) {
+				return newTron(&a.c.USDTTronShastaTestnetPayment, &a.c.TrongridShasta, entity.USDT_TRON_TEST)
+			},
+		},
+		{
+			pmn:        entity.CARD,
+			configured: a.c.StripePayment.SecretKey != "",
+			new: func() (dependency.Invoicer, error) {
+				return newStripe(&a.c.StripePayment, entity.CARD)
+			},
+		},
+		{
+			pmn:        entity.CARD_TEST,
+			configured: a.c.StripePaymentTest.SecretKey != "",
+			new: func() (dependency.Invoicer, error) {
+				return newStripe(&a.c.StripePaymentTest, entity.CARD_TEST)
+			},
+		},
+		{
+			pmn:        entity.ETH,
+			configured: ethConfigured(&a.c.ETHPayment),
+			new: func() (dependency.Invoicer, error) {
+				return newEth(&a.c.ETHPayment, entity.ETH)
+			},
+		},
+		{
+			pmn:        entity.ETH_TEST,
+			configured: ethConfigured(&a.c.ETHTestnetPayment),
+			new: func() (dependency.Invoicer, error) {
+				return newEth(&a.c.ETHTestnetPayment, entity.ETH_TEST)
+			},
+		},
+		{
+			pmn:        entity.USDT_ETH,
+			configured: erc20Configured(&a.c.USDTETHPayment),
+			new: func() (dependency.Invoicer, error) {
+				return newERC20(&a.c.USDTETHPayment, entity.USDT_ETH)
+			},
+		},
+		{
+			pmn:        entity.USDT_ETH_TEST,
+			configured: erc20Configured(&a.c.USDTETHTestnetPayment),
+			new: func() (dependency.Invoicer, error) {
+				return newERC20(&a.c.USDTETHTestnetPayment, entity.USDT_ETH_TEST)
+			},
+		},
+		{
+			pmn:        entity.USDC_ETH,
+			configured: erc20Configured(&a.c.USDCETHPayment),
+			new: func() (dependency.Invoicer, error) {
+				return newERC20(&a.c.USDCETHPayment, entity.USDC_ETH)
+			},
+		},
+		{
+			pmn:        entity.USDC_ETH_TEST,
+			configured: erc20Configured(&a.c.USDCETHTestnetPayment),
+			new: func() (dependency.Invoicer, error) {
+				return newERC20(&a.c.USDCETHTestnetPayment, entity.USDC_ETH_TEST)
+			},
+		},
+	}
+
+	for _, i := range inits {
+		if !i.configured {
+			slog.Default().InfoContext(ctx, "payment processor is not configured",
+				slog.String("paymentMethod", string(i.pmn)),
+			)
+			continue
+		}
+		p, err := i.new()
+		if err != nil {
+			return nil, err
+		}
+		if err := processors.Register(i.pmn, p); err != nil {
+			return nil, fmt.Errorf("can't register payment processor: %w", err)
+		}
+	}
+
+	return stripeProcessors, nil
+}
+
 // Stop stops the application and waits for all services to exit
 func (a *App) Stop(ctx context.Context) {
 	a.db.Close()
@@ -5,8 +5,15 @@ import (
 
 	httpapi "github.com/jekabolt/grbpwr-manager/internal/api/http"
 	"github.com/jekabolt/grbpwr-manager/internal/apisrv/auth"
+	"github.com/jekabolt/grbpwr-manager/internal/apisrv/frontend"
 	"github.com/jekabolt/grbpwr-manager/internal/bucket"
+	"github.com/jekabolt/grbpwr-manager/internal/invoice"
 	"github.com/jekabolt/grbpwr-manager/internal/mail"
+	"github.com/jekabolt/grbpwr-manager/internal/payment/erc20"
+	"github.com/jekabolt/grbpwr-manager/internal/payment/eth"
+	"github.com/jekabolt/grbpwr-manager/internal/payment/expiration"
+	"github.com/jekabolt/grbpwr-manager/internal/payment/reconcile"
+	"github.com/jekabolt/grbpwr-manager/internal/payment/reminder"
 	"github.com/jekabolt/grbpwr-manager/internal/payment/stripe"
 	"github.com/jekabolt/grbpwr-manager/internal/payment/tron"
 	"github.com/jekabolt/grbpwr-manager/internal/payment/trongrid"
@@ -18,19 +25,30 @@ import (
 
 // Config represents the global configuration for the service.
 type Config struct {
-	DB                           store.Config    `mapstructure:"mysql"`
-	Logger                       log.Config      `mapstructure:"logger"`
-	HTTP                         httpapi.Config  `mapstructure:"http"`
-	Auth                         auth.Config     `mapstructure:"auth"`
-	Bucket                       bucket.Config   `mapstructure:"bucket"`
-	Mailer                       mail.Config     `mapstructure:"mailer"`
-	Rates                        rates.Config    `mapstructure:"rates"`
-	Trongrid                     trongrid.Config `mapstructure:"trongrid"`
-	TrongridShasta               trongrid.Config `mapstructure:"trongrid_shasta_testnet"`
-	USDTTronPayment              tron.Config     `mapstructure:"usdt_tron_payment"`
-	USDTTronShastaTestnetPayment tron.Config     `mapstructure:"usdt_tron_shasta_testnet_payment"`
-	StripePayment                stripe.Config   `mapstructure:"stripe_payment"`
-	StripePaymentTest            stripe.Config   `mapstructure:"stripe_payment_test"`
+	DB                           store.Config      `mapstructure:"mysql"`
+	Logger                       log.Config        `mapstructure:"logger"`
+	HTTP                         httpapi.Config    `mapstructure:"http"`
+	Auth                         auth.Config       `mapstructure:"auth"`
+	Frontend                     frontend.Config   `mapstructure:"frontend"`
+	Bucket                       bucket.Config     `mapstructure:"bucket"`
+	Mailer                       mail.Config       `mapstructure:"mailer"`
+	Rates                        rates.Config      `mapstructure:"rates"`
+	Trongrid                     trongrid.Config   `mapstructure:"trongrid"`
+	TrongridShasta               trongrid.Config   `mapstructure:"trongrid_shasta_testnet"`
+	USDTTronPayment              tron.Config       `mapstructure:"usdt_tron_payment"`
+	USDTTronShastaTestnetPayment tron.Config       `mapstructure:"usdt_tron_shasta_testnet_payment"`
+	StripePayment                stripe.Config     `mapstructure:"stripe_payment"`
+	StripePaymentTest            stripe.Config     `mapstructure:"stripe_payment_test"`
+	ETHPayment                   eth.Config        `mapstructure:"eth_payment"`
+	ETHTestnetPayment            eth.Config        `mapstructure:"eth_testnet_payment"`
+	USDTETHPayment               erc20.Config      `mapstructure:"usdt_eth_payment"`
+	USDTETHTestnetPayment        erc20.Config      `mapstructure:"usdt_eth_testnet_payment"`
+	USDCETHPayment               erc20.Config      `mapstructure:"usdc_eth_payment"`
+	USDCETHTestnetPayment        erc20.Config      `mapstructure:"usdc_eth_testnet_payment"`
+	Reconciliation               reconcile.Config  `mapstructure:"reconciliation"`
+	PaymentExpiration            expiration.Config `mapstructure:"payment_expiration"`
+	CheckoutReminder             reminder.Config   `mapstructure:"checkout_reminder"`
+	Invoice                      invoice.Config    `mapstructure:"invoice"`
 }
 
 // LoadConfig loads the configuration from a file.
@@ -0,0 +1,102 @@
+package httpapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"github.com/grpc-ecosystem/grpc-gateway/runtime"
+	"github.com/grpc-ecosystem/grpc-gateway/utilities"
+	pb_admin "github.com/jekabolt/grbpwr-manager/proto/gen/admin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// orderExportHandler serves the admin order export stream as a file download, the json gateway
+// wraps every streamed message into a json object so the raw chunks are written here instead.
+// The query parameters are the fields of the export request, e.g. ?from=2024-01-01T00:00:00Z&format=ORDER_EXPORT_FORMAT_ENUM_NDJSON
+func (s *Server) orderExportHandler(ctx context.Context) (http.Handler, error) {
+	apiEndpoint := fmt.Sprintf("%s:%s", s.c.Address, s.c.Port)
+
+	conn, err := grpc.Dial(apiEndpoint, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, fmt.Errorf("can't dial grpc endpoint: %w", err)
+	}
+	go func() {
+		<-ctx.Done()
+		if err := conn.Close(); err != nil {
+			slog.Default().ErrorContext(ctx, "can't close order export grpc connection",
+				slog.String("err", err.Error()),
+			)
+		}
+	}()
+
+	return newOrderExportHandler(pb_admin.NewAdminServiceClient(conn)), nil
+}
+
+// newOrderExportHandler streams the order export of the client to the response.
+func newOrderExportHandler(client pb_admin.AdminServiceClient) http.Handler {
+	mux := runtime.NewServeMux()
+	marshaler := &runtime.JSONPb{EmitDefaults: true}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := &pb_admin.ExportOrdersRequest{}
+		if err := runtime.PopulateQueryParameters(req, r.URL.Query(), &utilities.DoubleArray{}); err != nil {
+			runtime.HTTPError(r.Context(), mux, marshaler, w, r, status.Errorf(codes.InvalidArgument, "%v", err))
+			return
+		}
+
+		rctx, err := runtime.AnnotateContext(r.Context(), mux, r)
+		if err != nil {
+			runtime.HTTPError(r.Context(), mux, marshaler, w, r, err)
+			return
+		}
+
+		stream, err := client.ExportOrders(rctx, req)
+		if err != nil {
+			runtime.HTTPError(r.Context(), mux, marshaler, w, r, err)
+			return
+		}
+
+		wroteHeader := false
+		for {
+			chunk, err := stream.Recv()
+			if errors.Is(err, io.EOF) {
+				return
+			}
+			if err != nil {
+				if !wroteHeader {
+					runtime.HTTPError(r.Context(), mux, marshaler, w, r, err)
+					return
+				}
+				// the status is already sent, the download is cut short
+				slog.Default().ErrorContext(r.Context(), "can't receive order export chunk",
+					slog.String("err", err.Error()),
+				)
+				return
+			}
+
+			if !wroteHeader {
+				ext := "csv"
+				if chunk.ContentType != "text/csv" {
+					ext = "ndjson"
+				}
+				w.Header().Set("Content-Type", chunk.ContentType)
+				w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"orders.%s\"", ext))
+				w.WriteHeader(http.StatusOK)
+				wroteHeader = true
+			}
+			if _, err := w.Write(chunk.Data); err != nil {
+				return
+			}
+			if f, ok := w.(http.Flusher); ok {
+				f.Flush()
+			}
+		}
+	})
+}
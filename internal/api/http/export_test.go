@@ -0,0 +1,117 @@
+package httpapi
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	pb_admin "github.com/jekabolt/grbpwr-manager/proto/gen/admin"
+	pb_common "github.com/jekabolt/grbpwr-manager/proto/gen/common"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/api/httpbody"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// exportStream returns the chunks and then err, io.EOF if err is nil.
+type exportStream struct {
+	grpc.ClientStream
+	chunks []*httpbody.HttpBody
+	err    error
+}
+
+func (s *exportStream) Recv() (*httpbody.HttpBody, error) {
+	if len(s.chunks) == 0 {
+		if s.err != nil {
+			return nil, s.err
+		}
+		return nil, io.EOF
+	}
+	chunk := s.chunks[0]
+	s.chunks = s.chunks[1:]
+	return chunk, nil
+}
+
+// exportClient records the export request and returns the stream.
+type exportClient struct {
+	pb_admin.AdminServiceClient
+	req    *pb_admin.ExportOrdersRequest
+	stream *exportStream
+	err    error
+}
+
+func (c *exportClient) ExportOrders(ctx context.Context, in *pb_admin.ExportOrdersRequest, opts ...grpc.CallOption) (pb_admin.AdminService_ExportOrdersClient, error) {
+	c.req = in
+	if c.err != nil {
+		return nil, c.err
+	}
+	return c.stream, nil
+}
+
+func serveExport(h http.Handler, query string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/orders/export"+query, nil))
+	return w
+}
+
+func TestOrderExportHandler(t *testing.T) {
+	t.Run("CSV", func(t *testing.T) {
+		client := &exportClient{stream: &exportStream{chunks: []*httpbody.HttpBody{
+			{ContentType: "text/csv", Data: []byte("order_id\n")},
+			{ContentType: "text/csv", Data: []byte("1\n")},
+			{ContentType: "text/csv", Data: []byte("2\n")},
+		}}}
+
+		w := serveExport(newOrderExportHandler(client), "?from=2024-01-01T00:00:00Z&format=ORDER_EXPORT_FORMAT_ENUM_CSV")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "text/csv", w.Header().Get("Content-Type"))
+		assert.Equal(t, `attachment; filename="orders.csv"`, w.Header().Get("Content-Disposition"))
+		// the chunks are written raw, not wrapped into the json objects
+		assert.Equal(t, "order_id\n1\n2\n", w.Body.String())
+
+		if assert.NotNil(t, client.req) && assert.NotNil(t, client.req.From) {
+			assert.Equal(t, int64(1704067200), client.req.From.Seconds)
+			assert.Equal(t, pb_common.OrderExportFormatEnum_ORDER_EXPORT_FORMAT_ENUM_CSV, client.req.Format)
+		}
+	})
+
+	t.Run("NDJSON", func(t *testing.T) {
+		client := &exportClient{stream: &exportStream{chunks: []*httpbody.HttpBody{
+			{ContentType: "application/x-ndjson", Data: []byte("{\"order_id\":1}\n")},
+		}}}
+
+		w := serveExport(newOrderExportHandler(client), "?format=ORDER_EXPORT_FORMAT_ENUM_NDJSON")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+		assert.Equal(t, `attachment; filename="orders.ndjson"`, w.Header().Get("Content-Disposition"))
+		assert.Equal(t, "{\"order_id\":1}\n", w.Body.String())
+	})
+
+	t.Run("InvalidQuery", func(t *testing.T) {
+		client := &exportClient{}
+		w := serveExport(newOrderExportHandler(client), "?from=yesterday")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Nil(t, client.req)
+	})
+
+	t.Run("ErrorBeforeFirstChunk", func(t *testing.T) {
+		client := &exportClient{stream: &exportStream{err: status.Error(codes.InvalidArgument, "from must be before to")}}
+		w := serveExport(newOrderExportHandler(client), "")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "from must be before to")
+	})
+
+	t.Run("ErrorAfterFirstChunk", func(t *testing.T) {
+		client := &exportClient{stream: &exportStream{
+			chunks: []*httpbody.HttpBody{{ContentType: "text/csv", Data: []byte("order_id\n")}},
+			err:    status.Error(codes.Internal, "can't get orders to export"),
+		}}
+		// the status is already sent, the download is cut short
+		w := serveExport(newOrderExportHandler(client), "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "order_id\n", w.Body.String())
+	})
+}
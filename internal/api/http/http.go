@@ -67,7 +67,7 @@ func (s *Server) Done() <-chan struct{} {
 	return s.done
 }
 
-func (s *Server) setupHTTPAPI(ctx context.Context, auth *auth.Server) (http.Handler, error) {
+func (s *Server) setupHTTPAPI(ctx context.Context, auth *auth.Server, stripeWebhook http.Handler) (http.Handler, error) {
 
 	r := chi.NewRouter()
 
@@ -83,6 +83,10 @@ func (s *Server) setupHTTPAPI(ctx context.Context, auth *auth.Server) (http.Hand
 	if err != nil {
 		return nil, err
 	}
+	orderExportHandler, err := s.orderExportHandler(ctx)
+	if err != nil {
+		return nil, err
+	}
 
 	// handle static swagger
 	r.HandleFunc("/products-manager/api", func(w http.ResponseWriter, r *http.Request) {
@@ -106,8 +110,13 @@ func (s *Server) setupHTTPAPI(ctx context.Context, auth *auth.Server) (http.Hand
 		}
 	})
 
-	r.Mount("/api/admin", auth.WithAuth(adminHandler))
+	adminRouter := chi.NewRouter()
+	adminRouter.Get("/orders/export", orderExportHandler.ServeHTTP)
+	adminRouter.Mount("/", adminHandler)
+
+	r.Mount("/api/admin", auth.WithAuth(adminRouter))
 	r.Mount("/api/frontend", frontendHandler)
+	r.Mount("/api/webhooks/stripe", stripeWebhook)
 	r.Mount("/api/auth", authHandler)
 
 	r.Mount("/", http.FileServer(http.FS(fs)))
@@ -115,6 +124,15 @@ func (s *Server) setupHTTPAPI(ctx context.Context, auth *auth.Server) (http.Hand
 	return r, nil
 }
 
+// outgoingHeaderMatcher passes the file name of the downloads served as google.api.HttpBody,
+// the other headers are prefixed as the gateway does by default.
+func outgoingHeaderMatcher(key string) (string, bool) {
+	if key == "content-disposition" {
+		return "Content-Disposition", true
+	}
+	return fmt.Sprintf("%s%s", runtime.MetadataHeaderPrefix, key), true
+}
+
 func (s *Server) adminJSONGateway(ctx context.Context) (http.Handler, error) {
 	// dial options for the grpc-gateway
 	grpcDialOpts := []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
@@ -123,11 +141,13 @@ func (s *Server) adminJSONGateway(ctx context.Context) (http.Handler, error) {
 
 	mux := runtime.NewServeMux(runtime.WithMarshalerOption(
 		runtime.MIMEWildcard,
-		&runtime.JSONPb{
-			EnumsAsInts:  false,
-			EmitDefaults: true,
+		&runtime.HTTPBodyMarshaler{
+			Marshaler: &runtime.JSONPb{
+				EnumsAsInts:  false,
+				EmitDefaults: true,
+			},
 		},
-	))
+	), runtime.WithOutgoingHeaderMatcher(outgoingHeaderMatcher))
 
 	err := pb_admin.RegisterAdminServiceHandlerFromEndpoint(ctx, mux, apiEndpoint, grpcDialOpts)
 	if err != nil {
@@ -144,11 +164,13 @@ func (s *Server) frontendJSONGateway(ctx context.Context) (http.Handler, error)
 
 	mux := runtime.NewServeMux(runtime.WithMarshalerOption(
 		runtime.MIMEWildcard,
-		&runtime.JSONPb{
-			EnumsAsInts:  false,
-			EmitDefaults: true,
+		&runtime.HTTPBodyMarshaler{
+			Marshaler: &runtime.JSONPb{
+				EnumsAsInts:  false,
+				EmitDefaults: true,
+			},
 		},
-	))
+	), runtime.WithOutgoingHeaderMatcher(outgoingHeaderMatcher))
 	err := pb_frontend.RegisterFrontendServiceHandlerFromEndpoint(ctx, mux, apiEndpoint, grpcDialOpts)
 	if err != nil {
 		return nil, err
@@ -183,6 +205,7 @@ func (s *Server) Start(ctx context.Context,
 	adminServer *admin.Server,
 	frontendServer *frontend.Server,
 	authServer *auth.Server,
+	stripeWebhook http.Handler,
 ) error {
 
 	opts := []grpcSlog.Option{
@@ -246,7 +269,7 @@ func (s *Server) Start(ctx context.Context,
 		close(hsDone)
 	}()
 
-	clientHTTPHandler, err := s.setupHTTPAPI(ctx, authServer)
+	clientHTTPHandler, err := s.setupHTTPAPI(ctx, authServer, stripeWebhook)
 	if err != nil {
 		cancel()
 		return err
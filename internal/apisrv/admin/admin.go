@@ -2,12 +2,16 @@ package admin
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"log/slog"
 
 	v "github.com/asaskevich/govalidator"
+	"github.com/jekabolt/grbpwr-manager/internal/apisrv/auth"
 	"github.com/jekabolt/grbpwr-manager/internal/bucket"
 	"github.com/jekabolt/grbpwr-manager/internal/cache"
 	"github.com/jekabolt/grbpwr-manager/internal/dependency"
@@ -28,6 +32,10 @@ type Server struct {
 	bucket dependency.FileStore
 	mailer dependency.Mailer
 	rates  dependency.RatesService
+	// refunds are issued through the processors which can refund, others are transferred manually
+	processors dependency.Processors
+	reconciler dependency.Reconciler
+	invoices   dependency.InvoiceGenerator
 }
 
 // New creates a new server with admin handlers.
@@ -36,12 +44,18 @@ func New(
 	b dependency.FileStore,
 	m dependency.Mailer,
 	rates dependency.RatesService,
+	processors dependency.Processors,
+	reconciler dependency.Reconciler,
+	invoices dependency.InvoiceGenerator,
 ) *Server {
 	return &Server{
-		repo:   r,
-		bucket: b,
-		mailer: m,
-		rates:  rates,
+		repo:       r,
+		bucket:     b,
+		mailer:     m,
+		rates:      rates,
+		processors: processors,
+		reconciler: reconciler,
+		invoices:   invoices,
 	}
 }
 
@@ -331,6 +345,79 @@ func (s *Server) ListPromos(ctx context.Context, req *pb_admin.ListPromosRequest
 	}, nil
 }
 
+func (s *Server) AddGiftCard(ctx context.Context, req *pb_admin.AddGiftCardRequest) (*pb_admin.AddGiftCardResponse, error) {
+	gci, err := dto.ConvertPbGiftCardInsertToEntity(req.GiftCard)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "can't convert pb gift card to entity gift card: %v", err)
+	}
+
+	gc, err := s.repo.GiftCards().AddGiftCard(ctx, gci)
+	if err != nil {
+		if s.repo.IsErrUniqueViolation(err) {
+			return nil, status.Errorf(codes.AlreadyExists, "gift card code already exists")
+		}
+		slog.Default().ErrorContext(ctx, "can't add gift card",
+			slog.String("err", err.Error()),
+		)
+		return nil, status.Errorf(codes.Internal, "can't add gift card")
+	}
+
+	return &pb_admin.AddGiftCardResponse{
+		GiftCard: dto.ConvertEntityGiftCardToPb(*gc),
+	}, nil
+}
+
+func (s *Server) ListGiftCards(ctx context.Context, req *pb_admin.ListGiftCardsRequest) (*pb_admin.ListGiftCardsResponse, error) {
+	gcs, err := s.repo.GiftCards().ListGiftCards(ctx, int(req.Limit), int(req.Offset), dto.ConvertPBCommonOrderFactorToEntity(req.OrderFactor))
+	if err != nil {
+		slog.Default().ErrorContext(ctx, "can't list gift cards",
+			slog.String("err", err.Error()),
+		)
+		return nil, status.Errorf(codes.Internal, "can't list gift cards")
+	}
+
+	pbGcs := make([]*pb_common.GiftCard, 0, len(gcs))
+	for _, gc := range gcs {
+		pbGcs = append(pbGcs, dto.ConvertEntityGiftCardToPb(gc))
+	}
+
+	return &pb_admin.ListGiftCardsResponse{
+		GiftCards: pbGcs,
+	}, nil
+}
+
+func (s *Server) GetGiftCard(ctx context.Context, req *pb_admin.GetGiftCardRequest) (*pb_admin.GetGiftCardResponse, error) {
+	gcf, err := s.repo.GiftCards().GetGiftCardByCode(ctx, req.Code)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, status.Errorf(codes.NotFound, "gift card not found")
+		}
+		slog.Default().ErrorContext(ctx, "can't get gift card",
+			slog.String("err", err.Error()),
+		)
+		return nil, status.Errorf(codes.Internal, "can't get gift card")
+	}
+
+	return &pb_admin.GetGiftCardResponse{
+		GiftCard: dto.ConvertEntityGiftCardFullToPb(gcf),
+	}, nil
+}
+
+func (s *Server) DisableGiftCard(ctx context.Context, req *pb_admin.DisableGiftCardRequest) (*pb_admin.DisableGiftCardResponse, error) {
+	if req.Code == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "code is empty")
+	}
+
+	err := s.repo.GiftCards().DisableGiftCard(ctx, req.Code)
+	if err != nil {
+		slog.Default().ErrorContext(ctx, "can't disable gift card",
+			slog.String("err", err.Error()),
+		)
+		return nil, status.Errorf(codes.Internal, "can't disable gift card")
+	}
+	return &pb_admin.DisableGiftCardResponse{}, nil
+}
+
 func (s *Server) GetDictionary(context.Context, *pb_admin.GetDictionaryRequest) (*pb_admin.GetDictionaryResponse, error) {
 	return &pb_admin.GetDictionaryResponse{
 		Dictionary: dto.ConvertToCommonDictionary(dto.Dict{
@@ -351,33 +438,228 @@ func (s *Server) GetDictionary(context.Context, *pb_admin.GetDictionaryRequest)
 	}, nil
 }
 
+// actor returns the username of the admin making the request for the order status history.
+func actor(ctx context.Context) string {
+	if username := auth.AdminUsername(ctx); username != "" {
+		return username
+	}
+	return "admin"
+}
+
+// orderStatusError maps the illegal order status change to FailedPrecondition.
+func orderStatusError(err error, msg string) error {
+	var te *entity.OrderStatusTransitionError
+	if errors.As(err, &te) {
+		return status.Errorf(codes.FailedPrecondition, "%s: %s", msg, te.Error())
+	}
+	var ue *entity.UnshippedOrderItemsError
+	if errors.As(err, &ue) {
+		return status.Errorf(codes.FailedPrecondition, "%s: %s", msg, ue.Error())
+	}
+	return status.Error(codes.Internal, msg)
+}
+
+func (s *Server) GetOrderByUUID(ctx context.Context, req *pb_admin.GetOrderByUUIDRequest) (*pb_admin.GetOrderByUUIDResponse, error) {
+	o, err := s.repo.Order().GetOrderFullByUUID(ctx, req.OrderUuid)
+	if err != nil {
+		slog.Default().ErrorContext(ctx, "can't get order by uuid",
+			slog.String("err", err.Error()),
+		)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, status.Errorf(codes.NotFound, "order not found")
+		}
+		return nil, status.Errorf(codes.Internal, "can't get order by uuid")
+	}
+
+	oPb, err := dto.ConvertEntityOrderFullToPbOrderFull(o)
+	if err != nil {
+		slog.Default().ErrorContext(ctx, "can't convert entity order full to pb order full",
+			slog.String("err", err.Error()),
+		)
+		return nil, status.Errorf(codes.Internal, "can't convert entity order full to pb order full")
+	}
+
+	return &pb_admin.GetOrderByUUIDResponse{
+		Order:       oPb,
+		Notes:       dto.ConvertEntityOrderNotesToPb(o.Notes),
+		Adjustments: dto.ConvertEntityOrderAdjustmentsToPb(o.Adjustments),
+	}, nil
+}
+
+// orderEditError maps the edit of the order which is not confirmed or already shipped to FailedPrecondition.
+func orderEditError(err error, msg string) error {
+	var ne *entity.OrderNotEditableError
+	if errors.As(err, &ne) {
+		return status.Errorf(codes.FailedPrecondition, "%s: %s", msg, ne.Error())
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return status.Errorf(codes.NotFound, "order not found")
+	}
+	return status.Error(codes.Internal, msg)
+}
+
+func (s *Server) UpdateOrderAddresses(ctx context.Context, req *pb_admin.UpdateOrderAddressesRequest) (*pb_admin.UpdateOrderAddressesResponse, error) {
+	if req.OrderUuid == "" || req.ShippingAddress == nil || req.BillingAddress == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "order uuid, shipping or billing address is empty")
+	}
+
+	shipping := dto.ConvertPbAddressInsertToEntity(req.ShippingAddress)
+	billing := dto.ConvertPbAddressInsertToEntity(req.BillingAddress)
+	for _, adr := range []*entity.AddressInsert{shipping, billing} {
+		if _, err := v.ValidateStruct(adr); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "validation address error: %v", err)
+		}
+	}
+
+	adj, err := s.repo.Order().UpdateOrderAddresses(ctx, req.OrderUuid, shipping, billing, actor(ctx))
+	if err != nil {
+		slog.Default().ErrorContext(ctx, "can't update order addresses",
+			slog.String("err", err.Error()),
+		)
+		return nil, orderEditError(err, "can't update order addresses")
+	}
+
+	resp := &pb_admin.UpdateOrderAddressesResponse{}
+	if adj != nil {
+		resp.Adjustment = dto.ConvertEntityOrderAdjustmentToPb(adj)
+	}
+	return resp, nil
+}
+
+func (s *Server) UpdateOrderBuyer(ctx context.Context, req *pb_admin.UpdateOrderBuyerRequest) (*pb_admin.UpdateOrderBuyerResponse, error) {
+	if req.OrderUuid == "" || req.Buyer == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "order uuid or buyer is empty")
+	}
+
+	buyer := dto.ConvertPbBuyerInsertToEntity(req.Buyer)
+	if _, err := v.ValidateStruct(buyer); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "validation buyer error: %v", err)
+	}
+
+	err := s.repo.Order().UpdateOrderBuyer(ctx, req.OrderUuid, buyer)
+	if err != nil {
+		slog.Default().ErrorContext(ctx, "can't update order buyer",
+			slog.String("err", err.Error()),
+		)
+		return nil, orderEditError(err, "can't update order buyer")
+	}
+	return &pb_admin.UpdateOrderBuyerResponse{}, nil
+}
+
+func (s *Server) UpdateOrderItems(ctx context.Context, req *pb_admin.UpdateOrderItemsRequest) (*pb_admin.UpdateOrderItemsResponse, error) {
+	if req.OrderUuid == "" || len(req.Items) == 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "order uuid or items are empty")
+	}
+
+	items := make([]entity.OrderItemInsert, 0, len(req.Items))
+	for _, i := range req.Items {
+		oi, err := dto.ConvertPbOrderItemInsertToEntity(i)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "can't convert order item: %v", err)
+		}
+		items = append(items, *oi)
+	}
+
+	adj, err := s.repo.Order().UpdateOrderItemsByAdmin(ctx, req.OrderUuid, items, actor(ctx))
+	if err != nil {
+		slog.Default().ErrorContext(ctx, "can't update order items",
+			slog.String("err", err.Error()),
+		)
+		return nil, orderEditError(err, "can't update order items")
+	}
+
+	resp := &pb_admin.UpdateOrderItemsResponse{}
+	if adj != nil {
+		resp.Adjustment = dto.ConvertEntityOrderAdjustmentToPb(adj)
+	}
+	return resp, nil
+}
+
 func (s *Server) SetTrackingNumber(ctx context.Context, req *pb_admin.SetTrackingNumberRequest) (*pb_admin.SetTrackingNumberResponse, error) {
 	if req.TrackingCode == "" {
 		slog.Default().ErrorContext(ctx, "tracking code is empty")
 		return nil, status.Errorf(codes.InvalidArgument, "tracking code is empty")
 	}
 
-	obs, err := s.repo.Order().SetTrackingNumber(ctx, req.OrderUuid, req.TrackingCode)
+	obs, err := s.repo.Order().SetTrackingNumber(ctx, req.OrderUuid, req.TrackingCode, actor(ctx))
 	if err != nil {
 		slog.Default().ErrorContext(ctx, "can't update tracking number info",
 			slog.String("err", err.Error()),
 		)
-		return nil, status.Errorf(codes.Internal, "can't update shipping info")
+		return nil, orderStatusError(err, "can't update shipping info")
+	}
+
+	if err := s.sendOrderShipped(ctx, obs); err != nil {
+		return nil, err
 	}
 
-	err = s.mailer.SendOrderShipped(ctx, s.repo, obs.Buyer.Email, &dto.OrderShipment{
+	return &pb_admin.SetTrackingNumberResponse{}, nil
+}
+
+func (s *Server) AddOrderShipment(ctx context.Context, req *pb_admin.AddOrderShipmentRequest) (*pb_admin.AddOrderShipmentResponse, error) {
+	if req.OrderUuid == "" || req.TrackingCode == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "order uuid or tracking code is empty")
+	}
+
+	shippingDate := time.Now()
+	if req.ShippingDate != nil {
+		shippingDate = req.ShippingDate.AsTime()
+	}
+
+	obs, err := s.repo.Order().AddOrderShipment(ctx, req.OrderUuid, &entity.OrderShipmentNew{
+		CarrierId:    int(req.CarrierId),
+		TrackingCode: req.TrackingCode,
+		ShippingDate: shippingDate,
+		Items:        dto.ConvertPbShipmentItemsToEntity(req.Items),
+	}, actor(ctx))
+	if err != nil {
+		slog.Default().ErrorContext(ctx, "can't add order shipment",
+			slog.String("err", err.Error()),
+		)
+		return nil, orderStatusError(err, "can't add order shipment")
+	}
+
+	if err := s.sendOrderShipped(ctx, obs); err != nil {
+		return nil, err
+	}
+
+	sf, err := dto.ConvertEntityShipmentFullToPb(obs.Shipment, obs.Items)
+	if err != nil {
+		slog.Default().ErrorContext(ctx, "can't convert shipment",
+			slog.String("err", err.Error()),
+		)
+		return nil, status.Errorf(codes.Internal, "can't convert shipment")
+	}
+
+	return &pb_admin.AddOrderShipmentResponse{
+		Shipment: sf,
+	}, nil
+}
+
+// sendOrderShipped notifies the buyer about the shipment, every shipment of the order is sent separately.
+func (s *Server) sendOrderShipped(ctx context.Context, obs *entity.OrderBuyerShipment) error {
+	details := &dto.OrderShipment{
 		Name:         fmt.Sprintf("%s %s", obs.Buyer.FirstName, obs.Buyer.LastName),
 		OrderUUID:    obs.Order.UUID,
 		ShippingDate: time.Now().Format("2006-01-02"),
-	})
+		TrackingCode: obs.Shipment.TrackingCode.String,
+		Partial:      obs.Order.OrderStatusId != cache.OrderStatusShipped.Status.Id,
+	}
+	if obs.Shipment.ShippingDate.Valid {
+		details.ShippingDate = obs.Shipment.ShippingDate.Time.Format("2006-01-02")
+	}
+	if sc, ok := cache.GetShipmentCarrierById(obs.Shipment.CarrierId); ok {
+		details.Carrier = sc.Carrier
+	}
+
+	err := s.mailer.SendOrderShipped(ctx, s.repo, obs.Buyer.Email, details)
 	if err != nil {
 		slog.Default().ErrorContext(ctx, "can't send order shipped email",
 			slog.String("err", err.Error()),
 		)
-		return nil, status.Errorf(codes.Internal, "can't send order shipped email")
+		return status.Errorf(codes.Internal, "can't send order shipped email")
 	}
-
-	return &pb_admin.SetTrackingNumberResponse{}, nil
+	return nil
 }
 
 func (s *Server) ListOrders(ctx context.Context, req *pb_admin.ListOrdersRequest) (*pb_admin.ListOrdersResponse, error) {
@@ -425,38 +707,373 @@ func (s *Server) ListOrders(ctx context.Context, req *pb_admin.ListOrdersRequest
 }
 
 func (s *Server) RefundOrder(ctx context.Context, req *pb_admin.RefundOrderRequest) (*pb_admin.RefundOrderResponse, error) {
-	err := s.repo.Order().RefundOrder(ctx, req.OrderUuid)
+	amount := decimal.Zero
+	if req.Amount.GetValue() != "" {
+		var err error
+		amount, err = decimal.NewFromString(req.Amount.GetValue())
+		if err != nil || !amount.IsPositive() {
+			slog.Default().ErrorContext(ctx, "refund amount is invalid",
+				slog.String("amount", req.Amount.GetValue()),
+			)
+			return nil, status.Errorf(codes.InvalidArgument, "refund amount is invalid")
+		}
+	}
+
+	var refund *entity.Refund
+	err := s.repo.Tx(ctx, func(ctx context.Context, rep dependency.Repository) error {
+		var err error
+		refund, err = rep.Order().RefundOrder(ctx, req.OrderUuid, amount, dto.ConvertPbRefundItemsToEntity(req.RestockItems), req.Reason, actor(ctx))
+		return err
+	})
 	if err != nil {
 		slog.Default().ErrorContext(ctx, "can't refund order",
 			slog.String("err", err.Error()),
 		)
-		return nil, status.Errorf(codes.Internal, "can't refund order")
+		return nil, orderStatusError(err, "can't refund order")
+	}
+
+	refund, err = s.issueRefund(ctx, req.OrderUuid, refund)
+	if err != nil {
+		slog.Default().ErrorContext(ctx, "can't issue refund",
+			slog.String("err", err.Error()),
+		)
+		return nil, orderStatusError(err, "can't issue refund")
 	}
-	return &pb_admin.RefundOrderResponse{}, nil
+
+	return &pb_admin.RefundOrderResponse{
+		Refund: dto.ConvertEntityRefundToPbRefund(refund),
+	}, nil
+}
+
+// issueRefund issues the recorded refund through the payment provider of the order.
+// The refund is committed pending before the provider is called, the idempotency key
+// of the refund makes the provider return the same refund on a retry.
+// The refund credited to the gift card in full is issued already.
+func (s *Server) issueRefund(ctx context.Context, orderUUID string, refund *entity.Refund) (*entity.Refund, error) {
+	if refund.IssuedAt.Valid {
+		return refund, nil
+	}
+
+	payment, err := s.repo.Order().GetPaymentByOrderUUID(ctx, orderUUID)
+	if err != nil {
+		return nil, fmt.Errorf("can't get payment by order uuid: %w", err)
+	}
+
+	pm, ok := cache.GetPaymentMethodById(payment.PaymentMethodID)
+	if !ok {
+		return nil, fmt.Errorf("payment method not found: %d", payment.PaymentMethodID)
+	}
+
+	// crypto refunds stay pending until transferred manually to the payer
+	st, providerRefundId := entity.RefundPending, ""
+	refunder, ok := s.processors.Refunder(pm.Method.Name)
+	if ok {
+		providerRefundId, st, err = refunder.Refund(ctx, *payment, refund.Amount, fmt.Sprintf("refund_%s_%d", orderUUID, refund.Id))
+		if err != nil {
+			// the provider may have refunded the payment anyway, the refund is left pending for the review
+			return nil, fmt.Errorf("can't refund payment: %w", err)
+		}
+	}
+
+	refundId := refund.Id
+	err = s.repo.Tx(ctx, func(ctx context.Context, rep dependency.Repository) error {
+		var err error
+		refund, err = rep.Order().UpdateRefund(ctx, refundId, st, providerRefundId, actor(ctx))
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't update refund: %w", err)
+	}
+	return refund, nil
 }
 
 func (s *Server) DeliveredOrder(ctx context.Context, req *pb_admin.DeliveredOrderRequest) (*pb_admin.DeliveredOrderResponse, error) {
-	err := s.repo.Order().DeliveredOrder(ctx, req.OrderUuid)
+	err := s.repo.Order().DeliveredOrder(ctx, req.OrderUuid, actor(ctx))
 	if err != nil {
 		slog.Default().ErrorContext(ctx, "can't mark order as delivered",
 			slog.String("err", err.Error()),
 		)
-		return nil, status.Errorf(codes.Internal, "can't mark order as delivered")
+		return nil, orderStatusError(err, "can't mark order as delivered")
 	}
 	return &pb_admin.DeliveredOrderResponse{}, nil
 }
 
 func (s *Server) CancelOrder(ctx context.Context, req *pb_admin.CancelOrderRequest) (*pb_admin.CancelOrderResponse, error) {
-	err := s.repo.Order().CancelOrder(ctx, req.OrderUuid)
+	err := s.repo.Order().CancelOrder(ctx, req.OrderUuid, actor(ctx), req.Reason)
 	if err != nil {
 		slog.Default().ErrorContext(ctx, "can't cancel order",
 			slog.String("err", err.Error()),
 		)
-		return nil, status.Errorf(codes.Internal, "can't cancel order")
+		return nil, orderStatusError(err, "can't cancel order")
 	}
 	return &pb_admin.CancelOrderResponse{}, nil
 }
 
+func (s *Server) AddOrderNote(ctx context.Context, req *pb_admin.AddOrderNoteRequest) (*pb_admin.AddOrderNoteResponse, error) {
+	if req.OrderUuid == "" || strings.TrimSpace(req.Text) == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "order uuid or note text is empty")
+	}
+
+	note, err := s.repo.Order().AddOrderNote(ctx, req.OrderUuid, &entity.OrderNoteInsert{
+		Author: actor(ctx),
+		Text:   req.Text,
+	})
+	if err != nil {
+		slog.Default().ErrorContext(ctx, "can't add order note",
+			slog.String("err", err.Error()),
+		)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, status.Errorf(codes.NotFound, "order not found")
+		}
+		return nil, status.Errorf(codes.Internal, "can't add order note")
+	}
+
+	return &pb_admin.AddOrderNoteResponse{
+		Note: dto.ConvertEntityOrderNoteToPb(note),
+	}, nil
+}
+
+func (s *Server) ListOrderNotes(ctx context.Context, req *pb_admin.ListOrderNotesRequest) (*pb_admin.ListOrderNotesResponse, error) {
+	if req.OrderUuid == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "order uuid is empty")
+	}
+
+	notes, err := s.repo.Order().GetOrderNotes(ctx, req.OrderUuid)
+	if err != nil {
+		slog.Default().ErrorContext(ctx, "can't get order notes",
+			slog.String("err", err.Error()),
+		)
+		return nil, status.Errorf(codes.Internal, "can't get order notes")
+	}
+
+	return &pb_admin.ListOrderNotesResponse{
+		Notes: dto.ConvertEntityOrderNotesToPb(notes),
+	}, nil
+}
+
+func (s *Server) DeleteOrderNote(ctx context.Context, req *pb_admin.DeleteOrderNoteRequest) (*pb_admin.DeleteOrderNoteResponse, error) {
+	err := s.repo.Order().DeleteOrderNote(ctx, req.OrderUuid, int(req.NoteId))
+	if err != nil {
+		slog.Default().ErrorContext(ctx, "can't delete order note",
+			slog.String("err", err.Error()),
+		)
+		return nil, status.Errorf(codes.Internal, "can't delete order note")
+	}
+	return &pb_admin.DeleteOrderNoteResponse{}, nil
+}
+
+func (s *Server) ListPaymentEvents(ctx context.Context, req *pb_admin.ListPaymentEventsRequest) (*pb_admin.ListPaymentEventsResponse, error) {
+	if req.OrderUuid == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "order uuid is empty")
+	}
+
+	events, err := s.repo.Order().GetPaymentEventsByOrderUUID(ctx, req.OrderUuid)
+	if err != nil {
+		slog.Default().ErrorContext(ctx, "can't get payment events",
+			slog.String("err", err.Error()),
+		)
+		return nil, status.Errorf(codes.Internal, "can't get payment events")
+	}
+
+	return &pb_admin.ListPaymentEventsResponse{
+		PaymentEvents: dto.ConvertEntityPaymentEventsToPb(events),
+	}, nil
+}
+
+func (s *Server) ReconcilePayments(ctx context.Context, req *pb_admin.ReconcilePaymentsRequest) (*pb_admin.ReconcilePaymentsResponse, error) {
+	var from time.Time
+	if req.From != nil {
+		from = req.From.AsTime()
+	}
+
+	report, err := s.reconciler.Reconcile(ctx, from)
+	if err != nil {
+		slog.Default().ErrorContext(ctx, "can't reconcile payments",
+			slog.String("err", err.Error()),
+		)
+		return nil, status.Errorf(codes.Internal, "can't reconcile payments")
+	}
+
+	return &pb_admin.ReconcilePaymentsResponse{
+		Report: dto.ConvertEntityReconciliationReportToPb(report),
+	}, nil
+}
+
+const defaultCheckoutReminderReportPeriod = 30 * 24 * time.Hour
+
+func (s *Server) GetCheckoutReminderReport(ctx context.Context, req *pb_admin.GetCheckoutReminderReportRequest) (*pb_admin.GetCheckoutReminderReportResponse, error) {
+	to := time.Now()
+	if req.To != nil {
+		to = req.To.AsTime()
+	}
+	from := to.Add(-defaultCheckoutReminderReportPeriod)
+	if req.From != nil {
+		from = req.From.AsTime()
+	}
+	if !from.Before(to) {
+		return nil, status.Errorf(codes.InvalidArgument, "from must be before to")
+	}
+
+	report, err := s.repo.Order().GetCheckoutReminderReport(ctx, from, to)
+	if err != nil {
+		slog.Default().ErrorContext(ctx, "can't get checkout reminder report",
+			slog.String("err", err.Error()),
+		)
+		return nil, status.Errorf(codes.Internal, "can't get checkout reminder report")
+	}
+
+	return &pb_admin.GetCheckoutReminderReportResponse{
+		Report: dto.ConvertEntityCheckoutReminderReportToPb(report),
+	}, nil
+}
+
+// RETURN MANAGER
+
+func (s *Server) ListReturnRequests(ctx context.Context, req *pb_admin.ListReturnRequestsRequest) (*pb_admin.ListReturnRequestsResponse, error) {
+	rrs, err := s.repo.Returns().ListReturnRequests(ctx,
+		dto.ConvertPbReturnRequestStatusToEntity(req.Status),
+		int(req.Limit),
+		int(req.Offset),
+		dto.ConvertPBCommonOrderFactorToEntity(req.OrderFactor),
+	)
+	if err != nil {
+		slog.Default().ErrorContext(ctx, "can't list return requests",
+			slog.String("err", err.Error()),
+		)
+		return nil, status.Errorf(codes.Internal, "can't list return requests")
+	}
+
+	pbRrs := make([]*pb_common.ReturnRequest, 0, len(rrs))
+	for _, rr := range rrs {
+		pbRrs = append(pbRrs, dto.ConvertEntityReturnRequestToPb(rr))
+	}
+
+	return &pb_admin.ListReturnRequestsResponse{
+		ReturnRequests: pbRrs,
+	}, nil
+}
+
+func (s *Server) GetReturnRequest(ctx context.Context, req *pb_admin.GetReturnRequestRequest) (*pb_admin.GetReturnRequestResponse, error) {
+	rrf, err := s.repo.Returns().GetReturnRequestByUUID(ctx, req.Uuid)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, status.Errorf(codes.NotFound, "return request not found")
+		}
+		slog.Default().ErrorContext(ctx, "can't get return request",
+			slog.String("err", err.Error()),
+		)
+		return nil, status.Errorf(codes.Internal, "can't get return request")
+	}
+
+	pbRrf, err := dto.ConvertEntityReturnRequestFullToPb(rrf)
+	if err != nil {
+		slog.Default().ErrorContext(ctx, "can't convert return request",
+			slog.String("err", err.Error()),
+		)
+		return nil, status.Errorf(codes.Internal, "can't convert return request")
+	}
+
+	return &pb_admin.GetReturnRequestResponse{
+		ReturnRequest: pbRrf,
+	}, nil
+}
+
+func (s *Server) ApproveReturnRequest(ctx context.Context, req *pb_admin.ApproveReturnRequestRequest) (*pb_admin.ApproveReturnRequestResponse, error) {
+	err := s.repo.Returns().ApproveReturnRequest(ctx, req.Uuid)
+	if err != nil {
+		slog.Default().ErrorContext(ctx, "can't approve return request",
+			slog.String("err", err.Error()),
+		)
+		return nil, status.Errorf(codes.Internal, "can't approve return request")
+	}
+	return &pb_admin.ApproveReturnRequestResponse{}, nil
+}
+
+func (s *Server) RejectReturnRequest(ctx context.Context, req *pb_admin.RejectReturnRequestRequest) (*pb_admin.RejectReturnRequestResponse, error) {
+	err := s.repo.Returns().RejectReturnRequest(ctx, req.Uuid)
+	if err != nil {
+		slog.Default().ErrorContext(ctx, "can't reject return request",
+			slog.String("err", err.Error()),
+		)
+		return nil, status.Errorf(codes.Internal, "can't reject return request")
+	}
+	return &pb_admin.RejectReturnRequestResponse{}, nil
+}
+
+func (s *Server) ReceiveReturnRequest(ctx context.Context, req *pb_admin.ReceiveReturnRequestRequest) (*pb_admin.ReceiveReturnRequestResponse, error) {
+	err := s.repo.Returns().ReceiveReturnRequest(ctx, req.Uuid)
+	if err != nil {
+		slog.Default().ErrorContext(ctx, "can't receive return request",
+			slog.String("err", err.Error()),
+		)
+		return nil, status.Errorf(codes.Internal, "can't receive return request")
+	}
+	return &pb_admin.ReceiveReturnRequestResponse{}, nil
+}
+
+func (s *Server) CancelReturnRequest(ctx context.Context, req *pb_admin.CancelReturnRequestRequest) (*pb_admin.CancelReturnRequestResponse, error) {
+	err := s.repo.Returns().CancelReturnRequest(ctx, req.Uuid)
+	if err != nil {
+		slog.Default().ErrorContext(ctx, "can't cancel return request",
+			slog.String("err", err.Error()),
+		)
+		return nil, status.Errorf(codes.Internal, "can't cancel return request")
+	}
+	return &pb_admin.CancelReturnRequestResponse{}, nil
+}
+
+// CompleteReturnRequest refunds the returned items or closes the exchange once the items are received.
+func (s *Server) CompleteReturnRequest(ctx context.Context, req *pb_admin.CompleteReturnRequestRequest) (*pb_admin.CompleteReturnRequestResponse, error) {
+	var (
+		refund    *entity.Refund
+		orderUUID string
+	)
+	err := s.repo.Tx(ctx, func(ctx context.Context, rep dependency.Repository) error {
+		rrf, err := rep.Returns().GetReturnRequestByUUID(ctx, req.Uuid)
+		if err != nil {
+			return fmt.Errorf("can't get return request: %w", err)
+		}
+		if rrf.ReturnRequest.Status != entity.ReturnReceived {
+			return fmt.Errorf("return request is not received: %s", rrf.ReturnRequest.Status)
+		}
+
+		if rrf.ReturnRequest.Type == entity.ReturnTypeExchange {
+			return rep.Returns().CompleteReturnRequest(ctx, req.Uuid, 0)
+		}
+
+		// returned items are restocked on receive
+		orderUUID = rrf.ReturnRequest.OrderUUID
+		refund, err = rep.Order().RefundOrder(ctx, orderUUID, rrf.RefundAmount(), nil,
+			fmt.Sprintf("return %s", req.Uuid), actor(ctx))
+		if err != nil {
+			return fmt.Errorf("can't refund order: %w", err)
+		}
+
+		return rep.Returns().CompleteReturnRequest(ctx, req.Uuid, refund.Id)
+	})
+	if err != nil {
+		slog.Default().ErrorContext(ctx, "can't complete return request",
+			slog.String("err", err.Error()),
+		)
+		return nil, status.Errorf(codes.Internal, "can't complete return request")
+	}
+
+	resp := &pb_admin.CompleteReturnRequestResponse{}
+	if refund == nil {
+		return resp, nil
+	}
+
+	refund, err = s.issueRefund(ctx, orderUUID, refund)
+	if err != nil {
+		slog.Default().ErrorContext(ctx, "can't issue refund",
+			slog.String("err", err.Error()),
+		)
+		return nil, status.Errorf(codes.Internal, "can't issue refund")
+	}
+	resp.Refund = dto.ConvertEntityRefundToPbRefund(refund)
+	return resp, nil
+}
+
 // HERO MANAGER
 
 func (s *Server) AddHero(ctx context.Context, req *pb_admin.AddHeroRequest) (*pb_admin.AddHeroResponse, error) {
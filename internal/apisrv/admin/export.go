@@ -0,0 +1,241 @@
+package admin
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/jekabolt/grbpwr-manager/internal/cache"
+	"github.com/jekabolt/grbpwr-manager/internal/entity"
+	pb_admin "github.com/jekabolt/grbpwr-manager/proto/gen/admin"
+	pb_common "github.com/jekabolt/grbpwr-manager/proto/gen/common"
+	"github.com/shopspring/decimal"
+	"google.golang.org/genproto/googleapis/api/httpbody"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// orderExportBatchSize is the number of the orders fetched and sent per chunk
+	orderExportBatchSize     = 100
+	defaultOrderExportPeriod = 30 * 24 * time.Hour
+
+	orderExportCSVContentType    = "text/csv"
+	orderExportNDJSONContentType = "application/x-ndjson"
+)
+
+var orderExportCSVHeader = []string{
+	"order_id", "order_uuid", "placed", "status", "buyer_name", "buyer_email", "country",
+	"sku", "product", "size", "quantity", "price", "sale_percentage", "price_with_sale",
+	"promo_code", "shipping_cost", "payment_method", "transaction_id", "total", "currency",
+}
+
+type orderExportItem struct {
+	SKU            string `json:"sku"`
+	Product        string `json:"product"`
+	Size           string `json:"size"`
+	Quantity       string `json:"quantity"`
+	Price          string `json:"price"`
+	SalePercentage string `json:"sale_percentage"`
+	PriceWithSale  string `json:"price_with_sale"`
+}
+
+// orderExportRow is the order as seen by the accounting, the amounts are in the base currency
+type orderExportRow struct {
+	OrderId       int               `json:"order_id"`
+	OrderUUID     string            `json:"order_uuid"`
+	Placed        time.Time         `json:"placed"`
+	Status        string            `json:"status"`
+	BuyerName     string            `json:"buyer_name"`
+	BuyerEmail    string            `json:"buyer_email"`
+	Country       string            `json:"country"`
+	Items         []orderExportItem `json:"items"`
+	PromoCode     string            `json:"promo_code"`
+	ShippingCost  string            `json:"shipping_cost"`
+	PaymentMethod string            `json:"payment_method"`
+	TransactionId string            `json:"transaction_id"`
+	Total         string            `json:"total"`
+	Currency      string            `json:"currency"`
+}
+
+func newOrderExportRow(of *entity.OrderFull) orderExportRow {
+	row := orderExportRow{
+		OrderId:      of.Order.Id,
+		OrderUUID:    of.Order.UUID,
+		Placed:       of.Order.Placed.UTC(),
+		BuyerName:    fmt.Sprintf("%s %s", of.Buyer.FirstName, of.Buyer.LastName),
+		BuyerEmail:   of.Buyer.Email,
+		Country:      of.Shipping.Country,
+		Items:        make([]orderExportItem, 0, len(of.OrderItems)),
+		PromoCode:    of.PromoCode.Code,
+		ShippingCost: of.Shipment.Cost.Round(2).String(),
+		Total:        of.Order.TotalPriceDecimal().String(),
+		Currency:     cache.GetBaseCurrency(),
+	}
+	if os, ok := cache.GetOrderStatusById(of.Order.OrderStatusId); ok {
+		row.Status = string(os.Status.Name)
+	}
+	if pm, ok := cache.GetPaymentMethodById(of.Payment.PaymentMethodID); ok {
+		row.PaymentMethod = string(pm.Method.Name)
+	}
+	if of.Payment.TransactionID.Valid {
+		row.TransactionId = of.Payment.TransactionID.String
+	}
+
+	for _, oi := range of.OrderItems {
+		item := orderExportItem{
+			SKU:            oi.SKU,
+			Product:        fmt.Sprintf("%s %s", oi.ProductBrand, oi.ProductName),
+			Quantity:       oi.Quantity.String(),
+			Price:          oi.ProductPrice.Round(2).String(),
+			SalePercentage: oi.ProductSalePercentage.Round(2).String(),
+			PriceWithSale:  oi.ProductPriceWithSaleDecimal().String(),
+		}
+		if size, ok := cache.GetSizeById(oi.SizeId); ok {
+			item.Size = string(size.Size.Name)
+		}
+		row.Items = append(row.Items, item)
+	}
+	return row
+}
+
+// csvFormulaPrefixes are the first characters the spreadsheets read the cell as a formula by
+const csvFormulaPrefixes = "=+-@\t\r"
+
+// csvCell keeps the spreadsheet from running the value as a formula, the values starting
+// with a formula character are prefixed with a quote. The numbers like the negative amounts are kept as is.
+func csvCell(v string) string {
+	if v == "" || !strings.ContainsRune(csvFormulaPrefixes, rune(v[0])) {
+		return v
+	}
+	if _, err := decimal.NewFromString(v); err == nil {
+		return v
+	}
+	return "'" + v
+}
+
+// csvRecords returns a record per order item, the order without items has a record with the empty item columns.
+func (r *orderExportRow) csvRecords() [][]string {
+	items := r.Items
+	if len(items) == 0 {
+		items = []orderExportItem{{}}
+	}
+	records := make([][]string, 0, len(items))
+	for _, item := range items {
+		record := []string{
+			fmt.Sprint(r.OrderId), r.OrderUUID, r.Placed.Format(time.RFC3339), r.Status, r.BuyerName, r.BuyerEmail, r.Country,
+			item.SKU, item.Product, item.Size, item.Quantity, item.Price, item.SalePercentage, item.PriceWithSale,
+			r.PromoCode, r.ShippingCost, r.PaymentMethod, r.TransactionId, r.Total, r.Currency,
+		}
+		for i := range record {
+			record[i] = csvCell(record[i])
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+// orderExportEncoder writes the rows of the export format into the buffer sent as the next chunk.
+type orderExportEncoder struct {
+	contentType string
+	buf         bytes.Buffer
+	csv         *csv.Writer
+	json        *json.Encoder
+}
+
+func newOrderExportEncoder(format pb_common.OrderExportFormatEnum) (*orderExportEncoder, error) {
+	e := &orderExportEncoder{}
+	switch format {
+	case pb_common.OrderExportFormatEnum_ORDER_EXPORT_FORMAT_ENUM_NDJSON:
+		e.contentType = orderExportNDJSONContentType
+		e.json = json.NewEncoder(&e.buf)
+	default:
+		e.contentType = orderExportCSVContentType
+		e.csv = csv.NewWriter(&e.buf)
+		if err := e.csv.Write(orderExportCSVHeader); err != nil {
+			return nil, fmt.Errorf("can't write csv header: %w", err)
+		}
+		// the header is sent with the first chunk even if there are no orders
+		e.csv.Flush()
+		if err := e.csv.Error(); err != nil {
+			return nil, fmt.Errorf("can't flush csv header: %w", err)
+		}
+	}
+	return e, nil
+}
+
+func (e *orderExportEncoder) encode(row *orderExportRow) error {
+	if e.json != nil {
+		return e.json.Encode(row)
+	}
+	return e.csv.WriteAll(row.csvRecords())
+}
+
+// chunk returns the rows encoded since the previous chunk.
+func (e *orderExportEncoder) chunk() *httpbody.HttpBody {
+	data := bytes.Clone(e.buf.Bytes())
+	e.buf.Reset()
+	return &httpbody.HttpBody{
+		ContentType: e.contentType,
+		Data:        data,
+	}
+}
+
+func (s *Server) ExportOrders(req *pb_admin.ExportOrdersRequest, stream pb_admin.AdminService_ExportOrdersServer) error {
+	ctx := stream.Context()
+
+	to := time.Now()
+	if req.To != nil {
+		to = req.To.AsTime()
+	}
+	from := to.Add(-defaultOrderExportPeriod)
+	if req.From != nil {
+		from = req.From.AsTime()
+	}
+	if !from.Before(to) {
+		return status.Errorf(codes.InvalidArgument, "from must be before to")
+	}
+
+	enc, err := newOrderExportEncoder(req.Format)
+	if err != nil {
+		slog.Default().ErrorContext(ctx, "can't create order export encoder",
+			slog.String("err", err.Error()),
+		)
+		return status.Errorf(codes.Internal, "can't create order export encoder")
+	}
+	afterId := 0
+	for {
+		ofs, err := s.repo.Order().GetOrdersFullPlacedBetween(ctx, from, to, afterId, orderExportBatchSize)
+		if err != nil {
+			slog.Default().ErrorContext(ctx, "can't get orders to export",
+				slog.String("err", err.Error()),
+			)
+			return status.Errorf(codes.Internal, "can't get orders to export")
+		}
+
+		for i := range ofs {
+			row := newOrderExportRow(&ofs[i])
+			if err := enc.encode(&row); err != nil {
+				slog.Default().ErrorContext(ctx, "can't encode order export row",
+					slog.String("err", err.Error()),
+				)
+				return status.Errorf(codes.Internal, "can't encode order export row")
+			}
+		}
+
+		// the first chunk is sent even if empty, it carries the content type
+		if afterId == 0 || len(ofs) > 0 {
+			if err := stream.Send(enc.chunk()); err != nil {
+				return err
+			}
+		}
+		if len(ofs) < orderExportBatchSize {
+			return nil
+		}
+		afterId = ofs[len(ofs)-1].Order.Id
+	}
+}
@@ -0,0 +1,119 @@
+package admin
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"testing"
+	"time"
+
+	pb_common "github.com/jekabolt/grbpwr-manager/proto/gen/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func testOrderExportRow() orderExportRow {
+	return orderExportRow{
+		OrderId:    1,
+		OrderUUID:  "uuid",
+		Placed:     time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Status:     "confirmed",
+		BuyerName:  "=HYPERLINK(\"http://evil\") Doe",
+		BuyerEmail: "@buyer@example.com",
+		Country:    "LV",
+		Items: []orderExportItem{
+			{SKU: "sku-1", Product: "+brand name", Size: "XL", Quantity: "1", Price: "100", SalePercentage: "0", PriceWithSale: "100"},
+			{SKU: "sku-2", Product: "brand -name", Size: "L", Quantity: "2", Price: "50", SalePercentage: "10", PriceWithSale: "45"},
+		},
+		PromoCode:     "-promo",
+		ShippingCost:  "-5",
+		PaymentMethod: "card",
+		TransactionId: "tx",
+		Total:         "185",
+		Currency:      "EUR",
+	}
+}
+
+func TestCSVCell(t *testing.T) {
+	assert.Equal(t, "", csvCell(""))
+	assert.Equal(t, "name", csvCell("name"))
+	assert.Equal(t, "'=1+1", csvCell("=1+1"))
+	assert.Equal(t, "'+1 name", csvCell("+1 name"))
+	assert.Equal(t, "'@SUM(A1)", csvCell("@SUM(A1)"))
+	assert.Equal(t, "'\tname", csvCell("\tname"))
+	// the negative amounts are kept numeric
+	assert.Equal(t, "-10.5", csvCell("-10.5"))
+	assert.Equal(t, "'-A1", csvCell("-A1"))
+}
+
+func TestOrderExportEncoderCSV(t *testing.T) {
+	enc, err := newOrderExportEncoder(pb_common.OrderExportFormatEnum_ORDER_EXPORT_FORMAT_ENUM_CSV)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// the header is sent in the first chunk
+	header := enc.chunk()
+	assert.Equal(t, orderExportCSVContentType, header.ContentType)
+	records, err := csv.NewReader(bytes.NewReader(header.Data)).ReadAll()
+	if !assert.NoError(t, err) || !assert.Len(t, records, 1) {
+		return
+	}
+	assert.Equal(t, orderExportCSVHeader, records[0])
+
+	row := testOrderExportRow()
+	if !assert.NoError(t, enc.encode(&row)) {
+		return
+	}
+	records, err = csv.NewReader(bytes.NewReader(enc.chunk().Data)).ReadAll()
+	if !assert.NoError(t, err) || !assert.Len(t, records, 2) {
+		return
+	}
+	assert.Equal(t, []string{
+		"1", "uuid", "2024-01-02T03:04:05Z", "confirmed", "'=HYPERLINK(\"http://evil\") Doe", "'@buyer@example.com", "LV",
+		"sku-1", "'+brand name", "XL", "1", "100", "0", "100",
+		"'-promo", "-5", "card", "tx", "185", "EUR",
+	}, records[0])
+	assert.Equal(t, "sku-2", records[1][7])
+	assert.Equal(t, "brand -name", records[1][8])
+	assert.Equal(t, "'=HYPERLINK(\"http://evil\") Doe", records[1][4])
+
+	// the chunks don't repeat the rows already sent
+	assert.Empty(t, enc.chunk().Data)
+}
+
+func TestOrderExportEncoderNDJSON(t *testing.T) {
+	enc, err := newOrderExportEncoder(pb_common.OrderExportFormatEnum_ORDER_EXPORT_FORMAT_ENUM_NDJSON)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Empty(t, enc.chunk().Data)
+
+	row := testOrderExportRow()
+	other := testOrderExportRow()
+	other.OrderId = 2
+	other.Items = nil
+	if !assert.NoError(t, enc.encode(&row)) || !assert.NoError(t, enc.encode(&other)) {
+		return
+	}
+
+	chunk := enc.chunk()
+	assert.Equal(t, orderExportNDJSONContentType, chunk.ContentType)
+	lines := bytes.Split(bytes.TrimSuffix(chunk.Data, []byte("\n")), []byte("\n"))
+	if !assert.Len(t, lines, 2) {
+		return
+	}
+
+	// the json values are not escaped, they are not read as formulas
+	var got orderExportRow
+	if !assert.NoError(t, json.Unmarshal(lines[0], &got)) {
+		return
+	}
+	assert.Equal(t, row, got)
+
+	got = orderExportRow{}
+	if !assert.NoError(t, json.Unmarshal(lines[1], &got)) {
+		return
+	}
+	assert.Equal(t, 2, got.OrderId)
+	assert.Empty(t, got.Items)
+}
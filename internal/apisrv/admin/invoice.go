@@ -0,0 +1,68 @@
+package admin
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	pb_admin "github.com/jekabolt/grbpwr-manager/proto/gen/admin"
+	"google.golang.org/genproto/googleapis/api/httpbody"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const invoiceContentType = "application/pdf"
+
+// GetOrderInvoicePDF renders the invoice of the confirmed order or its correction from the content stored when it was issued,
+// the orders which are not confirmed yet have no invoice.
+func (s *Server) GetOrderInvoicePDF(ctx context.Context, req *pb_admin.GetOrderInvoicePDFRequest) (*httpbody.HttpBody, error) {
+	of, err := s.repo.Order().GetOrderFullByUUID(ctx, req.OrderUuid)
+	if err != nil {
+		slog.Default().ErrorContext(ctx, "can't get order by uuid",
+			slog.String("err", err.Error()),
+		)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, status.Errorf(codes.NotFound, "order not found")
+		}
+		return nil, status.Errorf(codes.Internal, "can't get order by uuid")
+	}
+	if of.Invoice == nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "order is not invoiced")
+	}
+
+	doc, err := s.repo.Order().GetOrderInvoiceDocument(ctx, req.OrderUuid, int(req.InvoiceNumber))
+	if err != nil {
+		slog.Default().ErrorContext(ctx, "can't get order invoice document",
+			slog.String("err", err.Error()),
+		)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, status.Errorf(codes.NotFound, "invoice not found")
+		}
+		return nil, status.Errorf(codes.Internal, "can't get order invoice document")
+	}
+
+	pdf, err := s.invoices.Generate(doc)
+	if err != nil {
+		slog.Default().ErrorContext(ctx, "can't generate order invoice",
+			slog.String("err", err.Error()),
+		)
+		return nil, status.Errorf(codes.Internal, "can't generate order invoice")
+	}
+
+	// the gateway passes the header to the download as is
+	err = grpc.SetHeader(ctx, metadata.Pairs("content-disposition", fmt.Sprintf("attachment; filename=\"%s\"", s.invoices.FileName(doc))))
+	if err != nil {
+		slog.Default().ErrorContext(ctx, "can't set invoice file name",
+			slog.String("err", err.Error()),
+		)
+	}
+
+	return &httpbody.HttpBody{
+		ContentType: invoiceContentType,
+		Data:        pdf,
+	}, nil
+}
@@ -0,0 +1,59 @@
+package admin
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/jekabolt/grbpwr-manager/internal/dto"
+	pb_admin "github.com/jekabolt/grbpwr-manager/proto/gen/admin"
+	pb_common "github.com/jekabolt/grbpwr-manager/proto/gen/common"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (s *Server) GetTaxRules(ctx context.Context, req *pb_admin.GetTaxRulesRequest) (*pb_admin.GetTaxRulesResponse, error) {
+	rules, err := s.repo.Settings().GetTaxRules(ctx)
+	if err != nil {
+		slog.Default().ErrorContext(ctx, "can't get tax rules",
+			slog.String("err", err.Error()),
+		)
+		return nil, status.Errorf(codes.Internal, "can't get tax rules")
+	}
+
+	pbRules := make([]*pb_common.TaxRule, 0, len(rules))
+	for _, tr := range rules {
+		pbRules = append(pbRules, dto.ConvertEntityTaxRuleToPb(&tr))
+	}
+	return &pb_admin.GetTaxRulesResponse{
+		TaxRules: pbRules,
+	}, nil
+}
+
+// SetTaxRule replaces the tax rule of the country, the orders already placed keep their tax.
+func (s *Server) SetTaxRule(ctx context.Context, req *pb_admin.SetTaxRuleRequest) (*pb_admin.SetTaxRuleResponse, error) {
+	tr, err := dto.ConvertPbTaxRuleToEntity(req.TaxRule)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "can't convert tax rule: %v", err)
+	}
+	if err := tr.Validate(); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "%v", err)
+	}
+
+	if err := s.repo.Settings().SetTaxRule(ctx, tr); err != nil {
+		slog.Default().ErrorContext(ctx, "can't set tax rule",
+			slog.String("err", err.Error()),
+		)
+		return nil, status.Errorf(codes.Internal, "can't set tax rule")
+	}
+	return &pb_admin.SetTaxRuleResponse{}, nil
+}
+
+func (s *Server) DeleteTaxRule(ctx context.Context, req *pb_admin.DeleteTaxRuleRequest) (*pb_admin.DeleteTaxRuleResponse, error) {
+	if err := s.repo.Settings().DeleteTaxRule(ctx, req.Country); err != nil {
+		slog.Default().ErrorContext(ctx, "can't delete tax rule",
+			slog.String("err", err.Error()),
+		)
+		return nil, status.Errorf(codes.Internal, "can't delete tax rule")
+	}
+	return &pb_admin.DeleteTaxRuleResponse{}, nil
+}
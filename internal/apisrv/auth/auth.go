@@ -23,6 +23,10 @@ import (
 const (
 	// AuthMetadataKey is header key to match auth token
 	AuthMetadataKey = "Grpc-Metadata-Authorization"
+	// AdminUsernameMetadataKey is header key the username of the authenticated admin is passed in,
+	// the gateway forwards it to the admin handlers as adminUsernameMetadata
+	AdminUsernameMetadataKey = "Grpc-Metadata-Admin-Username"
+	adminUsernameMetadata    = "admin-username"
 )
 
 // Server implements the heartbeat service.
@@ -97,7 +101,7 @@ func (s *Server) Login(ctx context.Context, req *auth.LoginRequest) (*auth.Login
 		return nil, status.Errorf(codes.Unauthenticated, "not authenticated")
 	}
 
-	token, err := jwt.NewToken(s.JwtAuth, s.jwtTTL)
+	token, err := jwt.NewToken(s.JwtAuth, s.jwtTTL, username)
 	if err != nil {
 		slog.Default().ErrorContext(ctx, "failed to create jwt token",
 			slog.String("err", err.Error()),
@@ -131,7 +135,7 @@ func (s *Server) Create(ctx context.Context, req *auth.CreateRequest) (*auth.Cre
 		return nil, status.Errorf(codes.Unauthenticated, "not authenticated")
 	}
 
-	token, err := jwt.NewToken(s.JwtAuth, s.jwtTTL)
+	token, err := jwt.NewToken(s.JwtAuth, s.jwtTTL, username)
 	if err != nil {
 		slog.Default().ErrorContext(ctx, "failed to create jwt token",
 			slog.String("err", err.Error()),
@@ -200,7 +204,7 @@ func (s *Server) ChangePassword(ctx context.Context, req *auth.ChangePasswordReq
 		return nil, status.Errorf(codes.Unauthenticated, "not authenticated")
 	}
 
-	token, err := jwt.NewToken(s.JwtAuth, s.jwtTTL)
+	token, err := jwt.NewToken(s.JwtAuth, s.jwtTTL, username)
 	if err != nil {
 		slog.Default().ErrorContext(ctx, "failed to create jwt token",
 			slog.String("err", err.Error()),
@@ -230,7 +234,7 @@ type errorMessage struct {
 func (s *Server) WithAuth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		token := strings.TrimPrefix(r.Header.Get(AuthMetadataKey), "Bearer ")
-		_, err := jwt.VerifyToken(s.JwtAuth, token)
+		username, err := jwt.VerifyToken(s.JwtAuth, token)
 		if err != nil {
 			// Create a new error message
 			errMsg := errorMessage{Error: err.Error()}
@@ -244,6 +248,12 @@ func (s *Server) WithAuth(next http.Handler) http.Handler {
 			return
 		}
 
+		// the username is taken from the verified token only
+		r.Header.Del(AdminUsernameMetadataKey)
+		if username != "" {
+			r.Header.Set(AdminUsernameMetadataKey, username)
+		}
+
 		next.ServeHTTP(w, r)
 	})
 }
@@ -262,3 +272,17 @@ func GetTokenMetadata(ctx context.Context) (string, error) {
 
 	return token, nil
 }
+
+// AdminUsername returns the username of the admin making the request from grpc metadata context,
+// empty if the token was issued without it.
+func AdminUsername(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	usernames := md.Get(adminUsernameMetadata)
+	if len(usernames) == 0 {
+		return ""
+	}
+	return usernames[0]
+}
@@ -24,36 +24,50 @@ import (
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
+// Config contains the configuration for the frontend server.
+type Config struct {
+	// OrderLookupSecret signs the order lookup links sent to the buyers, the lookup is disabled if empty
+	OrderLookupSecret string `mapstructure:"order_lookup_secret"`
+	// OrderLookupTTL is how long the order lookup link is valid for
+	OrderLookupTTL time.Duration `mapstructure:"order_lookup_ttl"`
+	// OrderLookupEmailLimit is the max number of the order lookup links sent to the email per OrderLookupLimitWindow
+	OrderLookupEmailLimit int `mapstructure:"order_lookup_email_limit"`
+	// OrderLookupIPLimit is the max number of the order lookup requests from the IP per OrderLookupLimitWindow
+	OrderLookupIPLimit int `mapstructure:"order_lookup_ip_limit"`
+	// OrderLookupLimitWindow is the window the order lookup requests are limited within
+	OrderLookupLimitWindow time.Duration `mapstructure:"order_lookup_limit_window"`
+	// TrustedProxies is the number of the proxies in front of the gateway, like the load balancer,
+	// the client IP is the x-forwarded-for entry added by the first of them
+	TrustedProxies int `mapstructure:"trusted_proxies"`
+}
+
 // Server implements handlers for frontend requests.
 type Server struct {
 	pb_frontend.UnimplementedFrontendServiceServer
-	repo              dependency.Repository
-	rates             dependency.RatesService
-	mailer            dependency.Mailer
-	usdtTron          dependency.Invoicer
-	usdtTronTestnet   dependency.Invoicer
-	stripePayment     dependency.Invoicer
-	stripePaymentTest dependency.Invoicer
+	repo       dependency.Repository
+	rates      dependency.RatesService
+	mailer     dependency.Mailer
+	processors dependency.Processors
+	lookup     *orderLookup
+	invoices   dependency.InvoiceGenerator
 }
 
 // New creates a new server with frontend handlers.
 func New(
+	c *Config,
 	r dependency.Repository,
 	m dependency.Mailer,
 	ra dependency.RatesService,
-	usdtTron dependency.Invoicer,
-	usdtTronTestnet dependency.Invoicer,
-	stripePayment dependency.Invoicer,
-	stripePaymentTest dependency.Invoicer,
+	processors dependency.Processors,
+	invoices dependency.InvoiceGenerator,
 ) *Server {
 	return &Server{
-		repo:              r,
-		mailer:            m,
-		rates:             ra,
-		usdtTron:          usdtTron,
-		usdtTronTestnet:   usdtTronTestnet,
-		stripePayment:     stripePayment,
-		stripePaymentTest: stripePaymentTest,
+		repo:       r,
+		mailer:     m,
+		rates:      ra,
+		processors: processors,
+		lookup:     newOrderLookup(c),
+		invoices:   invoices,
 	}
 }
 
@@ -160,6 +174,19 @@ func (s *Server) SubmitOrder(ctx context.Context, req *pb_frontend.SubmitOrderRe
 		return nil, status.Errorf(codes.InvalidArgument, fmt.Errorf("validation order create request failed: %v", err).Error())
 	}
 
+	// reject the payment method before the order is inserted
+	pm := dto.ConvertPbPaymentMethodToEntity(req.Order.PaymentMethod)
+	if _, err := s.allowedInvoicer(ctx, pm); err != nil {
+		return nil, err
+	}
+
+	if err := s.validatePaymentCurrency(orderNew); err != nil {
+		slog.Default().ErrorContext(ctx, "invalid payment currency",
+			slog.String("err", err.Error()),
+		)
+		return nil, status.Errorf(codes.InvalidArgument, err.Error())
+	}
+
 	order, sendEmail, err := s.repo.Order().CreateOrder(ctx, orderNew, receivePromo)
 	if err != nil {
 		slog.Default().ErrorContext(ctx, "can't create order",
@@ -177,22 +204,20 @@ func (s *Server) SubmitOrder(ctx context.Context, req *pb_frontend.SubmitOrderRe
 		}
 	}
 
-	pm := dto.ConvertPbPaymentMethodToEntity(req.Order.PaymentMethod)
-
-	pme, ok := cache.GetPaymentMethodByName(pm)
-	if !ok {
-		slog.Default().ErrorContext(ctx, "failed to retrieve payment method")
-		return nil, status.Errorf(codes.Internal, "internal error")
-	}
-	if !pme.Method.Allowed {
-		slog.Default().ErrorContext(ctx, "payment method not allowed")
-		return nil, status.Errorf(codes.PermissionDenied, "payment method not allowed")
-	}
-
-	invoice, err := s.getInvoiceByPaymentMethod(ctx, pm, order.UUID)
-	if err != nil {
-		slog.Default().ErrorContext(ctx, "can't get order invoice", slog.String("err", err.Error()))
-		return nil, err
+	var invoice *InvoiceDetails
+	if order.AmountDue().IsZero() {
+		// the gift card covers the whole total, there is nothing to invoice
+		invoice, err = s.confirmGiftCardOrder(ctx, order.UUID)
+		if err != nil {
+			slog.Default().ErrorContext(ctx, "can't confirm gift card order", slog.String("err", err.Error()))
+			return nil, status.Errorf(codes.Internal, "can't confirm gift card order")
+		}
+	} else {
+		invoice, err = s.getInvoiceByPaymentMethod(ctx, pm, order.UUID)
+		if err != nil {
+			slog.Default().ErrorContext(ctx, "can't get order invoice", slog.String("err", err.Error()))
+			return nil, err
+		}
 	}
 
 	eos, ok := cache.GetOrderStatusById(order.OrderStatusId)
@@ -222,6 +247,30 @@ func (s *Server) SubmitOrder(ctx context.Context, req *pb_frontend.SubmitOrderRe
 	}, nil
 }
 
+// validatePaymentCurrency checks the presentment currency has a rate,
+// only card payments are charged in the presentment currency.
+func (s *Server) validatePaymentCurrency(orderNew *entity.OrderNew) error {
+	if orderNew.PaymentMethod != entity.CARD && orderNew.PaymentMethod != entity.CARD_TEST {
+		orderNew.Currency = ""
+		return nil
+	}
+	if orderNew.Currency == "" {
+		return nil
+	}
+
+	ticker, ok := dto.VerifyCurrencyTicker(orderNew.Currency)
+	if !ok || !dto.IsFiatCurrency(ticker) {
+		return fmt.Errorf("currency is not supported: %s", orderNew.Currency)
+	}
+	if ticker == s.rates.GetBaseCurrency() {
+		return nil
+	}
+	if _, ok := s.rates.GetRates()[ticker]; !ok {
+		return fmt.Errorf("currency rate is not available: %s", orderNew.Currency)
+	}
+	return nil
+}
+
 func (s *Server) GetOrderByUUID(ctx context.Context, req *pb_frontend.GetOrderByUUIDRequest) (*pb_frontend.GetOrderByUUIDResponse, error) {
 	o, err := s.repo.Order().GetOrderFullByUUID(ctx, req.OrderUuid)
 	if err != nil {
@@ -239,7 +288,7 @@ func (s *Server) GetOrderByUUID(ctx context.Context, req *pb_frontend.GetOrderBy
 		return nil, status.Errorf(codes.Internal, "can't get order status by id")
 	}
 
-	if os.Status.Name == entity.AwaitingPayment {
+	if os.Status.Name == entity.AwaitingPayment || os.Status.Name == entity.PartiallyPaid {
 		pm, ok := cache.GetPaymentMethodById(o.Payment.PaymentMethodID)
 		if !ok {
 			slog.Default().ErrorContext(ctx, "can't get payment method by id",
@@ -248,17 +297,8 @@ func (s *Server) GetOrderByUUID(ctx context.Context, req *pb_frontend.GetOrderBy
 			return nil, status.Errorf(codes.Internal, "can't get payment method by id")
 		}
 
-		var checker dependency.Invoicer
-		switch pm.Method.Name {
-		case entity.USDT_TRON:
-			checker = s.usdtTron
-		case entity.USDT_TRON_TEST:
-			checker = s.usdtTronTestnet
-		case entity.CARD:
-			checker = s.stripePayment
-		case entity.CARD_TEST:
-			checker = s.stripePaymentTest
-		default:
+		checker, ok := s.processors.Invoicer(pm.Method.Name)
+		if !ok {
 			slog.Default().ErrorContext(ctx, "payment method is not allowed",
 				slog.Any("paymentMethod", pm),
 			)
@@ -284,6 +324,11 @@ func (s *Server) GetOrderByUUID(ctx context.Context, req *pb_frontend.GetOrderBy
 		return nil, status.Errorf(codes.Internal, "can't convert entity order full to pb order full")
 	}
 
+	hideAdminActors(oPb)
+	if !s.lookup.owns(req.Token, o) {
+		hideBuyerDetails(oPb)
+	}
+
 	return &pb_frontend.GetOrderByUUIDResponse{
 		Order: oPb,
 	}, nil
@@ -310,11 +355,6 @@ func (s *Server) ValidateOrderItemsInsert(ctx context.Context, req *pb_frontend.
 		return nil, status.Errorf(codes.Internal, "can't validate order items insert")
 	}
 
-	pbOii := make([]*pb_common.OrderItem, 0, len(oiv.ValidItems))
-	for _, i := range oiv.ValidItems {
-		pbOii = append(pbOii, dto.ConvertEntityOrderItemToPb(&i))
-	}
-
 	shipmentCarrier, scOk := cache.GetShipmentCarrierById(int(req.ShipmentCarrierId))
 	if scOk && !shipmentCarrier.Allowed {
 		slog.Default().ErrorContext(ctx, "shipment carrier not allowed",
@@ -322,6 +362,21 @@ func (s *Server) ValidateOrderItemsInsert(ctx context.Context, req *pb_frontend.
 		)
 		return nil, status.Errorf(codes.PermissionDenied, "shipment carrier not allowed")
 	}
+
+	tax, taxKnown, err := s.orderItemsTax(ctx, req, oiv.ValidItems)
+	if err != nil {
+		slog.Default().ErrorContext(ctx, "can't calculate tax",
+			slog.String("err", err.Error()),
+		)
+		return nil, status.Errorf(codes.Internal, "can't calculate tax")
+	}
+
+	pbOii := make([]*pb_common.OrderItem, 0, len(oiv.ValidItems))
+	for n, i := range oiv.ValidItems {
+		i.TaxRate = tax.Items[n].Rate
+		i.TaxAmount = tax.Items[n].Amount
+		pbOii = append(pbOii, dto.ConvertEntityOrderItemToPb(&i))
+	}
 	if scOk && shipmentCarrier.Allowed {
 		oiv.Subtotal = oiv.SubtotalDecimal().Add(shipmentCarrier.PriceDecimal()).Round(2)
 	}
@@ -337,16 +392,56 @@ func (s *Server) ValidateOrderItemsInsert(ctx context.Context, req *pb_frontend.
 			totalSale = totalSale.Mul(decimal.NewFromInt(100).Sub(promo.Discount).Div(decimal.NewFromInt(100)))
 		}
 	}
+	// the exclusive tax is added on top of the prices
+	totalSale = totalSale.Add(tax.Surcharge())
 
-	return &pb_frontend.ValidateOrderItemsInsertResponse{
+	resp := &pb_frontend.ValidateOrderItemsInsertResponse{
 		ValidItems: pbOii,
 		HasChanged: oiv.HasChanged,
 		Subtotal:   &pb_decimal.Decimal{Value: oiv.SubtotalDecimal().String()},
 		TotalSale:  &pb_decimal.Decimal{Value: totalSale.Round(2).String()},
 		Promo:      dto.ConvertEntityPromoInsertToPb(promo.PromoCodeInsert),
-	}, nil
+		TaxUnknown: !taxKnown,
+	}
+	if taxKnown {
+		resp.Tax = &pb_decimal.Decimal{Value: tax.Total.Round(2).String()}
+		resp.TaxInclusive = tax.Inclusive
+	}
+	return resp, nil
 
 }
+
+// orderItemsTax computes the tax of the valid items shipped to the requested country. The tax is unknown
+// if the country is not requested while some countries are taxed, it's returned as zero and not known then.
+func (s *Server) orderItemsTax(ctx context.Context, req *pb_frontend.ValidateOrderItemsInsertRequest, items []entity.OrderItem) (*entity.OrderTax, bool, error) {
+	var rule *entity.TaxRule
+	if req.Country != "" {
+		var err error
+		rule, err = s.repo.Settings().GetTaxRule(ctx, req.Country)
+		if err != nil {
+			return nil, false, err
+		}
+	} else {
+		rules, err := s.repo.Settings().GetTaxRules(ctx)
+		if err != nil {
+			return nil, false, err
+		}
+		if len(rules) > 0 {
+			return entity.CalculateTax(nil, items, entity.PromoCode{}, decimal.Zero), false, nil
+		}
+	}
+
+	promo, ok := cache.GetPromoByCode(req.PromoCode)
+	if !ok || !promo.IsAllowed() {
+		promo = entity.PromoCode{}
+	}
+	shippingPrice := decimal.Zero
+	if sc, ok := cache.GetShipmentCarrierById(int(req.ShipmentCarrierId)); ok {
+		shippingPrice = sc.PriceDecimal()
+	}
+	return entity.CalculateTax(rule, items, promo, shippingPrice), true, nil
+}
+
 func (s *Server) ValidateOrderByUUID(ctx context.Context, req *pb_frontend.ValidateOrderByUUIDRequest) (*pb_frontend.ValidateOrderByUUIDResponse, error) {
 	orderFull, err := s.repo.Order().ValidateOrderByUUID(ctx, req.OrderUuid)
 	if err != nil {
@@ -363,6 +458,10 @@ func (s *Server) ValidateOrderByUUID(ctx context.Context, req *pb_frontend.Valid
 		)
 		return nil, status.Errorf(codes.Internal, "can't convert entity order to pb common order")
 	}
+	hideAdminActors(of)
+	if !s.lookup.owns(req.Token, orderFull) {
+		hideBuyerDetails(of)
+	}
 	return &pb_frontend.ValidateOrderByUUIDResponse{
 		Order: of,
 	}, nil
@@ -371,16 +470,6 @@ func (s *Server) ValidateOrderByUUID(ctx context.Context, req *pb_frontend.Valid
 func (s *Server) GetOrderInvoice(ctx context.Context, req *pb_frontend.GetOrderInvoiceRequest) (*pb_frontend.GetOrderInvoiceResponse, error) {
 	pm := dto.ConvertPbPaymentMethodToEntity(req.PaymentMethod)
 
-	pme, ok := cache.GetPaymentMethodByName(pm)
-	if !ok {
-		slog.Default().ErrorContext(ctx, "failed to retrieve payment method")
-		return nil, status.Errorf(codes.Internal, "internal error")
-	}
-	if !pme.Method.Allowed {
-		slog.Default().ErrorContext(ctx, "payment method not allowed")
-		return nil, status.Errorf(codes.PermissionDenied, "payment method not allowed")
-	}
-
 	invoice, err := s.getInvoiceByPaymentMethod(ctx, pm, req.OrderUuid)
 	if err != nil {
 		return nil, err
@@ -404,21 +493,35 @@ type InvoiceDetails struct {
 	ExpiredAt time.Time
 }
 
-func (s *Server) getInvoiceByPaymentMethod(ctx context.Context, pm entity.PaymentMethodName, orderUuid string) (*InvoiceDetails, error) {
-	var handler dependency.Invoicer
-	switch pm {
-	case entity.USDT_TRON:
-		handler = s.usdtTron
-	case entity.USDT_TRON_TEST:
-		handler = s.usdtTronTestnet
-	case entity.CARD:
-		handler = s.stripePayment
-	case entity.CARD_TEST:
-		handler = s.stripePaymentTest
-	default:
-		slog.Default().ErrorContext(ctx, "payment method unimplemented")
+// allowedInvoicer returns the processor of the payment method if the method is allowed and has a registered processor.
+func (s *Server) allowedInvoicer(ctx context.Context, pm entity.PaymentMethodName) (dependency.Invoicer, error) {
+	pme, ok := cache.GetPaymentMethodByName(pm)
+	if !ok {
+		slog.Default().ErrorContext(ctx, "failed to retrieve payment method",
+			slog.Any("paymentMethod", pm),
+		)
+		return nil, status.Errorf(codes.InvalidArgument, "payment method not found")
+	}
+	if !pme.Method.Allowed {
+		slog.Default().ErrorContext(ctx, "payment method not allowed")
+		return nil, status.Errorf(codes.PermissionDenied, "payment method not allowed")
+	}
+
+	handler, ok := s.processors.Invoicer(pm)
+	if !ok {
+		slog.Default().ErrorContext(ctx, "payment method unimplemented",
+			slog.Any("paymentMethod", pm),
+		)
 		return nil, status.Errorf(codes.Unimplemented, "payment method unimplemented")
 	}
+	return handler, nil
+}
+
+func (s *Server) getInvoiceByPaymentMethod(ctx context.Context, pm entity.PaymentMethodName, orderUuid string) (*InvoiceDetails, error) {
+	handler, err := s.allowedInvoicer(ctx, pm)
+	if err != nil {
+		return nil, err
+	}
 
 	pi, expire, err := handler.GetOrderInvoice(ctx, orderUuid)
 	if err != nil {
@@ -432,29 +535,54 @@ func (s *Server) getInvoiceByPaymentMethod(ctx context.Context, pm entity.Paymen
 	}, nil
 }
 
+// confirmGiftCardOrder sends the confirmation of the order paid with the gift card,
+// the payment is done once the order is created.
+func (s *Server) confirmGiftCardOrder(ctx context.Context, orderUuid string) (*InvoiceDetails, error) {
+	of, err := s.repo.Order().GetOrderFullByUUID(ctx, orderUuid)
+	if err != nil {
+		return nil, fmt.Errorf("can't get order by uuid: %w", err)
+	}
+
+	err = s.mailer.SendOrderConfirmation(ctx, s.repo, of.Buyer.Email, dto.OrderFullToOrderConfirmed(of))
+	if err != nil {
+		slog.Default().ErrorContext(ctx, "can't send order confirmation",
+			slog.String("err", err.Error()),
+		)
+	}
+
+	return &InvoiceDetails{
+		Payment: &of.Payment.PaymentInsert,
+	}, nil
+}
+
 func (s *Server) CancelOrderInvoice(ctx context.Context, req *pb_frontend.CancelOrderInvoiceRequest) (*pb_frontend.CancelOrderInvoiceResponse, error) {
-	payment, err := s.repo.Order().ExpireOrderPayment(ctx, req.OrderUuid)
+	var payment *entity.Payment
+	err := s.repo.Tx(ctx, func(ctx context.Context, rep dependency.Repository) error {
+		var err error
+		payment, err = rep.Order().ExpireOrderPayment(ctx, req.OrderUuid)
+		return err
+	})
 	if err != nil {
 		slog.Default().ErrorContext(ctx, "can't expire order payment",
 			slog.String("err", err.Error()),
 		)
 		return nil, status.Errorf(codes.Internal, "can't expire order payment")
 	}
+	if payment == nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "order is not awaiting payment")
+	}
 	pme, _ := cache.GetPaymentMethodById(payment.PaymentMethodID)
 
 	slog.Default().DebugContext(ctx, "cancel order invoice",
 		slog.Any("paymentMethod", pme),
 	)
 
-	switch pme.Method.Name {
-	case entity.USDT_TRON:
-		err = s.usdtTron.CancelMonitorPayment(req.OrderUuid)
-	case entity.USDT_TRON_TEST:
-		err = s.usdtTronTestnet.CancelMonitorPayment(req.OrderUuid)
-	default:
+	handler, ok := s.processors.Invoicer(pme.Method.Name)
+	if !ok {
 		slog.Default().ErrorContext(ctx, "payment method unimplemented")
 		return nil, status.Errorf(codes.Unimplemented, "payment method unimplemented")
 	}
+	err = handler.CancelMonitorPayment(req.OrderUuid, *payment)
 	if err != nil {
 		slog.Default().ErrorContext(ctx, "can't cancel monitor payment",
 			slog.String("err", err.Error()),
@@ -466,6 +594,68 @@ func (s *Server) CancelOrderInvoice(ctx context.Context, req *pb_frontend.Cancel
 	return &pb_frontend.CancelOrderInvoiceResponse{}, nil
 }
 
+func (s *Server) CreateReturnRequest(ctx context.Context, req *pb_frontend.CreateReturnRequestRequest) (*pb_frontend.CreateReturnRequestResponse, error) {
+	rri, err := dto.ConvertPbCreateReturnRequestToEntity(req.OrderUuid, req.Email, req.Type, req.Comment, req.Items)
+	if err != nil {
+		slog.Default().ErrorContext(ctx, "can't convert return request",
+			slog.String("err", err.Error()),
+		)
+		return nil, status.Errorf(codes.InvalidArgument, "can't convert return request: %v", err)
+	}
+
+	rrf, err := s.repo.Returns().AddReturnRequest(ctx, rri)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, status.Errorf(codes.NotFound, "order not found")
+		}
+		slog.Default().ErrorContext(ctx, "can't add return request",
+			slog.String("err", err.Error()),
+		)
+		return nil, status.Errorf(codes.Internal, "can't add return request")
+	}
+
+	pbRrf, err := dto.ConvertEntityReturnRequestFullToPb(rrf)
+	if err != nil {
+		slog.Default().ErrorContext(ctx, "can't convert return request",
+			slog.String("err", err.Error()),
+		)
+		return nil, status.Errorf(codes.Internal, "can't convert return request")
+	}
+
+	return &pb_frontend.CreateReturnRequestResponse{
+		ReturnRequest: pbRrf,
+	}, nil
+}
+
+// GetGiftCardBalance returns the balance available at checkout, disabled cards are not found.
+func (s *Server) GetGiftCardBalance(ctx context.Context, req *pb_frontend.GetGiftCardBalanceRequest) (*pb_frontend.GetGiftCardBalanceResponse, error) {
+	if req.Code == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "code is empty")
+	}
+
+	gcf, err := s.repo.GiftCards().GetGiftCardByCode(ctx, req.Code)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, status.Errorf(codes.NotFound, "gift card not found")
+		}
+		slog.Default().ErrorContext(ctx, "can't get gift card",
+			slog.String("err", err.Error()),
+		)
+		return nil, status.Errorf(codes.Internal, "can't get gift card")
+	}
+	if !gcf.GiftCard.Allowed {
+		return nil, status.Errorf(codes.NotFound, "gift card not found")
+	}
+
+	resp := &pb_frontend.GetGiftCardBalanceResponse{
+		Balance: &pb_decimal.Decimal{Value: gcf.GiftCard.AvailableBalance(time.Now()).String()},
+	}
+	if gcf.GiftCard.Expiration.Valid {
+		resp.Expiration = timestamppb.New(gcf.GiftCard.Expiration.Time)
+	}
+	return resp, nil
+}
+
 func (s *Server) SubscribeNewsletter(ctx context.Context, req *pb_frontend.SubscribeNewsletterRequest) (*pb_frontend.SubscribeNewsletterResponse, error) {
 	// Subscribe the user.
 	err := s.repo.Subscribers().UpsertSubscription(ctx, req.Email, true)
@@ -0,0 +1,84 @@
+package frontend
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/jekabolt/grbpwr-manager/internal/entity"
+	pb_frontend "github.com/jekabolt/grbpwr-manager/proto/gen/frontend"
+	"google.golang.org/genproto/googleapis/api/httpbody"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const invoiceContentType = "application/pdf"
+
+// canDownloadInvoice reports whether the key is the access key of the order invoice
+// or the token is the lookup token of the order buyer email.
+func (s *Server) canDownloadInvoice(of *entity.OrderFull, key, token string) bool {
+	if of.Invoice == nil {
+		return false
+	}
+	if key != "" {
+		return subtle.ConstantTimeCompare([]byte(key), []byte(of.Invoice.AccessKey)) == 1
+	}
+	return s.lookup.owns(token, of)
+}
+
+// GetOrderInvoicePDF renders the invoice of the confirmed order or its correction for its buyer as it was issued.
+func (s *Server) GetOrderInvoicePDF(ctx context.Context, req *pb_frontend.GetOrderInvoicePDFRequest) (*httpbody.HttpBody, error) {
+	of, err := s.repo.Order().GetOrderFullByUUID(ctx, req.OrderUuid)
+	if err != nil {
+		slog.Default().ErrorContext(ctx, "can't get order by uuid",
+			slog.String("err", err.Error()),
+		)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, status.Errorf(codes.NotFound, "order not found")
+		}
+		return nil, status.Errorf(codes.Internal, "can't get order by uuid")
+	}
+	if of.Invoice == nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "order is not invoiced")
+	}
+	if !s.canDownloadInvoice(of, req.Key, req.Token) {
+		return nil, status.Errorf(codes.PermissionDenied, "invalid invoice key")
+	}
+
+	doc, err := s.repo.Order().GetOrderInvoiceDocument(ctx, req.OrderUuid, int(req.InvoiceNumber))
+	if err != nil {
+		slog.Default().ErrorContext(ctx, "can't get order invoice document",
+			slog.String("err", err.Error()),
+		)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, status.Errorf(codes.NotFound, "invoice not found")
+		}
+		return nil, status.Errorf(codes.Internal, "can't get order invoice document")
+	}
+
+	pdf, err := s.invoices.Generate(doc)
+	if err != nil {
+		slog.Default().ErrorContext(ctx, "can't generate order invoice",
+			slog.String("err", err.Error()),
+		)
+		return nil, status.Errorf(codes.Internal, "can't generate order invoice")
+	}
+
+	// the gateway passes the header to the download as is
+	err = grpc.SetHeader(ctx, metadata.Pairs("content-disposition", fmt.Sprintf("attachment; filename=\"%s\"", s.invoices.FileName(doc))))
+	if err != nil {
+		slog.Default().ErrorContext(ctx, "can't set invoice file name",
+			slog.String("err", err.Error()),
+		)
+	}
+
+	return &httpbody.HttpBody{
+		ContentType: invoiceContentType,
+		Data:        pdf,
+	}, nil
+}
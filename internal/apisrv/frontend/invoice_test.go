@@ -0,0 +1,33 @@
+package frontend
+
+import (
+	"testing"
+
+	"github.com/jekabolt/grbpwr-manager/internal/entity"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCanDownloadInvoice(t *testing.T) {
+	s := &Server{lookup: newOrderLookup(&Config{OrderLookupSecret: "secret"})}
+
+	of := &entity.OrderFull{}
+	of.Buyer.Email = "buyer@example.com"
+
+	token, err := s.lookup.newToken("buyer@example.com")
+	assert.NoError(t, err)
+
+	// the order which is not invoiced can't be downloaded
+	assert.False(t, s.canDownloadInvoice(of, "key", token))
+
+	of.Invoice = &entity.OrderInvoice{AccessKey: "key"}
+	assert.True(t, s.canDownloadInvoice(of, "key", ""))
+	assert.False(t, s.canDownloadInvoice(of, "other", ""))
+	assert.False(t, s.canDownloadInvoice(of, "", ""))
+
+	// the lookup token of the buyer email is accepted instead of the key
+	assert.True(t, s.canDownloadInvoice(of, "", token))
+	other, err := s.lookup.newToken("other@example.com")
+	assert.NoError(t, err)
+	assert.False(t, s.canDownloadInvoice(of, "", other))
+	assert.False(t, s.canDownloadInvoice(of, "other", token))
+}
@@ -0,0 +1,87 @@
+package frontend
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// limiter allows up to limit requests per key within the fixed window.
+type limiter struct {
+	mu      sync.Mutex
+	limit   int
+	window  time.Duration
+	windows map[string]*limitWindow
+	now     func() time.Time
+}
+
+type limitWindow struct {
+	start time.Time
+	count int
+}
+
+func newLimiter(limit int, window time.Duration) *limiter {
+	return &limiter{
+		limit:   limit,
+		window:  window,
+		windows: map[string]*limitWindow{},
+		now:     time.Now,
+	}
+}
+
+// allow counts the request of the key and reports whether it's within the limit.
+func (l *limiter) allow(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	// the expired windows are dropped so the keys seen once don't pile up
+	for k, w := range l.windows {
+		if now.Sub(w.start) >= l.window {
+			delete(l.windows, k)
+		}
+	}
+
+	w, ok := l.windows[key]
+	if !ok {
+		w = &limitWindow{start: now}
+		l.windows[key] = w
+	}
+	if w.count >= l.limit {
+		return false
+	}
+	w.count++
+	return true
+}
+
+// clientIP returns the IP of the client the request came from. Every proxy appends the address it got
+// the request from to x-forwarded-for, the gateway included, so only the right-most entries are trusted.
+// The entry added by the first of the trusted proxies in front of the gateway is the client,
+// the entries left of it are sent by the client and can't be trusted. The peer address is used
+// if the header is missing or is shorter than the trusted proxies.
+func clientIP(ctx context.Context, trustedProxies int) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		var fwd []string
+		for _, v := range md.Get("x-forwarded-for") {
+			fwd = append(fwd, strings.Split(v, ",")...)
+		}
+		if i := len(fwd) - 1 - trustedProxies; i >= 0 {
+			if ip := strings.TrimSpace(fwd[i]); ip != "" {
+				return ip
+			}
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		host, _, err := net.SplitHostPort(p.Addr.String())
+		if err != nil {
+			return p.Addr.String()
+		}
+		return host
+	}
+	return ""
+}
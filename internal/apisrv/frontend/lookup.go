@@ -0,0 +1,236 @@
+package frontend
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	v "github.com/asaskevich/govalidator"
+	"github.com/go-chi/jwtauth/v5"
+	"github.com/jekabolt/grbpwr-manager/internal/dto"
+	"github.com/jekabolt/grbpwr-manager/internal/entity"
+	pb_common "github.com/jekabolt/grbpwr-manager/proto/gen/common"
+	pb_frontend "github.com/jekabolt/grbpwr-manager/proto/gen/frontend"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	defaultOrderLookupTTL         = 24 * time.Hour
+	defaultOrderLookupEmailLimit  = 3
+	defaultOrderLookupIPLimit     = 20
+	defaultOrderLookupLimitWindow = time.Hour
+	// orderLookupSendTimeout bounds the lookup and the mail sent after the request has returned
+	orderLookupSendTimeout = time.Minute
+	// orderLookupAudience keeps the order lookup tokens apart from the other tokens signed with the same secret
+	orderLookupAudience = "order_lookup"
+	// orderLookupLimit is the max number of the latest orders listed by the lookup
+	orderLookupLimit = 50
+)
+
+// orderLookup signs the tokens granting access to the orders of the buyer email
+// and limits the links sent per email and per client IP.
+type orderLookup struct {
+	auth    *jwtauth.JWTAuth
+	ttl     time.Duration
+	byEmail *limiter
+	byIP    *limiter
+	// trustedProxies is the number of the proxies in front of the gateway
+	trustedProxies int
+}
+
+// newOrderLookup returns nil if the lookup secret is not configured, the nil lookup grants no access.
+func newOrderLookup(c *Config) *orderLookup {
+	if c.OrderLookupSecret == "" {
+		return nil
+	}
+	ttl := c.OrderLookupTTL
+	if ttl <= 0 {
+		ttl = defaultOrderLookupTTL
+	}
+	emailLimit := c.OrderLookupEmailLimit
+	if emailLimit <= 0 {
+		emailLimit = defaultOrderLookupEmailLimit
+	}
+	ipLimit := c.OrderLookupIPLimit
+	if ipLimit <= 0 {
+		ipLimit = defaultOrderLookupIPLimit
+	}
+	window := c.OrderLookupLimitWindow
+	if window <= 0 {
+		window = defaultOrderLookupLimitWindow
+	}
+	return &orderLookup{
+		auth:           jwtauth.New("HS256", []byte(c.OrderLookupSecret), nil),
+		ttl:            ttl,
+		byEmail:        newLimiter(emailLimit, window),
+		byIP:           newLimiter(ipLimit, window),
+		trustedProxies: max(c.TrustedProxies, 0),
+	}
+}
+
+func (ol *orderLookup) newToken(email string) (string, error) {
+	_, token, err := ol.auth.Encode(map[string]any{
+		"exp": time.Now().Add(ol.ttl).Unix(),
+		"sub": normalizeEmail(email),
+		"aud": orderLookupAudience,
+	})
+	if err != nil {
+		return "", fmt.Errorf("can't encode order lookup token: %w", err)
+	}
+	return token, nil
+}
+
+// email returns the buyer email of the valid unexpired token.
+func (ol *orderLookup) email(token string) (string, error) {
+	if ol == nil {
+		return "", fmt.Errorf("order lookup is not configured")
+	}
+	t, err := jwtauth.VerifyToken(ol.auth, token)
+	if err != nil {
+		return "", fmt.Errorf("can't verify order lookup token: %w", err)
+	}
+	for _, aud := range t.Audience() {
+		if aud == orderLookupAudience && t.Subject() != "" {
+			return t.Subject(), nil
+		}
+	}
+	return "", fmt.Errorf("token is not an order lookup token")
+}
+
+// owns reports whether the token was issued for the buyer email of the order.
+func (ol *orderLookup) owns(token string, of *entity.OrderFull) bool {
+	if token == "" || of.Buyer.Email == "" {
+		return false
+	}
+	email, err := ol.email(token)
+	if err != nil {
+		return false
+	}
+	return email == normalizeEmail(of.Buyer.Email)
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// formatValidFor formats the token ttl for the buyer, e.g. "24 hours".
+func formatValidFor(d time.Duration) string {
+	if d >= time.Hour && d%time.Hour == 0 {
+		if d == time.Hour {
+			return "1 hour"
+		}
+		return fmt.Sprintf("%d hours", int(d.Hours()))
+	}
+	return fmt.Sprintf("%d minutes", int(d.Minutes()))
+}
+
+// hideBuyerDetails removes the personal data of the buyer from the order.
+func hideBuyerDetails(of *pb_common.OrderFull) {
+	of.Buyer = nil
+	of.Billing = nil
+	of.Shipping = nil
+}
+
+// hideAdminActors hides the usernames of the admins who changed the order status from the buyer.
+func hideAdminActors(of *pb_common.OrderFull) {
+	for _, sc := range of.StatusHistory {
+		if sc.Actor != entity.OrderStatusActorSystem {
+			sc.Actor = "admin"
+		}
+	}
+}
+
+// RequestOrderLookup sends the signed link to the orders of the buyer email. The orders are looked up
+// and the link is sent after the request returns, so neither the response nor its timing tells
+// whether the email has orders. The requests are limited per client IP, the links over the email limit are dropped.
+func (s *Server) RequestOrderLookup(ctx context.Context, req *pb_frontend.RequestOrderLookupRequest) (*pb_frontend.RequestOrderLookupResponse, error) {
+	if s.lookup == nil {
+		return nil, status.Errorf(codes.Unimplemented, "order lookup is not configured")
+	}
+	email := normalizeEmail(req.Email)
+	if !v.IsEmail(email) {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid email")
+	}
+
+	if !s.lookup.byIP.allow(clientIP(ctx, s.lookup.trustedProxies)) {
+		return nil, status.Errorf(codes.ResourceExhausted, "too many order lookup requests")
+	}
+	if !s.lookup.byEmail.allow(email) {
+		return &pb_frontend.RequestOrderLookupResponse{}, nil
+	}
+
+	go s.sendOrderLookup(context.WithoutCancel(ctx), email)
+
+	return &pb_frontend.RequestOrderLookupResponse{}, nil
+}
+
+// sendOrderLookup sends the order lookup link to the email if it has orders.
+func (s *Server) sendOrderLookup(ctx context.Context, email string) {
+	ctx, cancel := context.WithTimeout(ctx, orderLookupSendTimeout)
+	defer cancel()
+
+	orders, err := s.repo.Order().GetOrdersByStatusAndPaymentTypePaged(ctx, email, 0, 0, 0, 1, 0, entity.Descending)
+	if err != nil {
+		slog.Default().ErrorContext(ctx, "can't get orders by email",
+			slog.String("err", err.Error()),
+		)
+		return
+	}
+	if len(orders) == 0 {
+		return
+	}
+
+	token, err := s.lookup.newToken(email)
+	if err != nil {
+		slog.Default().ErrorContext(ctx, "can't create order lookup token",
+			slog.String("err", err.Error()),
+		)
+		return
+	}
+
+	err = s.mailer.SendOrderLookup(ctx, s.repo, email, &dto.OrderLookup{
+		Token:    token,
+		ValidFor: formatValidFor(s.lookup.ttl),
+	})
+	if err != nil {
+		slog.Default().ErrorContext(ctx, "can't send order lookup mail",
+			slog.String("err", err.Error()),
+		)
+	}
+}
+
+// GetOrdersByLookupToken returns the latest orders of the buyer email the token was issued for.
+func (s *Server) GetOrdersByLookupToken(ctx context.Context, req *pb_frontend.GetOrdersByLookupTokenRequest) (*pb_frontend.GetOrdersByLookupTokenResponse, error) {
+	email, err := s.lookup.email(req.Token)
+	if err != nil {
+		return nil, status.Errorf(codes.Unauthenticated, "invalid or expired order lookup token")
+	}
+
+	ofs, err := s.repo.Order().GetOrdersFullByBuyerEmail(ctx, email, orderLookupLimit)
+	if err != nil {
+		slog.Default().ErrorContext(ctx, "can't get orders by buyer email",
+			slog.String("err", err.Error()),
+		)
+		return nil, status.Errorf(codes.Internal, "can't get orders")
+	}
+
+	orders := make([]*pb_common.OrderFull, 0, len(ofs))
+	for _, of := range ofs {
+		oPb, err := dto.ConvertEntityOrderFullToPbOrderFull(&of)
+		if err != nil {
+			slog.Default().ErrorContext(ctx, "can't convert entity order full to pb order full",
+				slog.String("err", err.Error()),
+			)
+			return nil, status.Errorf(codes.Internal, "can't convert entity order full to pb order full")
+		}
+		hideAdminActors(oPb)
+		orders = append(orders, oPb)
+	}
+
+	return &pb_frontend.GetOrdersByLookupTokenResponse{
+		Orders: orders,
+	}, nil
+}
@@ -0,0 +1,173 @@
+package frontend
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jekabolt/grbpwr-manager/internal/auth/jwt"
+	"github.com/jekabolt/grbpwr-manager/internal/dependency"
+	"github.com/jekabolt/grbpwr-manager/internal/dto"
+	"github.com/jekabolt/grbpwr-manager/internal/entity"
+	pb_frontend "github.com/jekabolt/grbpwr-manager/proto/gen/frontend"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func TestOrderLookupToken(t *testing.T) {
+	ol := newOrderLookup(&Config{OrderLookupSecret: "secret"})
+	assert.Equal(t, defaultOrderLookupTTL, ol.ttl)
+
+	token, err := ol.newToken(" Buyer@Example.com")
+	assert.NoError(t, err)
+	email, err := ol.email(token)
+	assert.NoError(t, err)
+	assert.Equal(t, "buyer@example.com", email)
+
+	of := &entity.OrderFull{}
+	of.Buyer.Email = "BUYER@example.com"
+	assert.True(t, ol.owns(token, of))
+	of.Buyer.Email = "other@example.com"
+	assert.False(t, ol.owns(token, of))
+	assert.False(t, ol.owns("", of))
+
+	// the token signed with another secret or for another audience is not accepted
+	other, err := newOrderLookup(&Config{OrderLookupSecret: "other"}).newToken("buyer@example.com")
+	assert.NoError(t, err)
+	_, err = ol.email(other)
+	assert.Error(t, err)
+
+	adminToken, err := jwt.NewToken(ol.auth, time.Hour, "buyer@example.com")
+	assert.NoError(t, err)
+	_, err = ol.email(adminToken)
+	assert.Error(t, err)
+
+	// the expired token is not accepted
+	ol.ttl = -time.Minute
+	expired, err := ol.newToken("buyer@example.com")
+	assert.NoError(t, err)
+	_, err = ol.email(expired)
+	assert.Error(t, err)
+
+	// the lookup is disabled without the secret
+	disabled := newOrderLookup(&Config{})
+	assert.Nil(t, disabled)
+	_, err = disabled.email(token)
+	assert.Error(t, err)
+}
+
+func TestFormatValidFor(t *testing.T) {
+	assert.Equal(t, "24 hours", formatValidFor(24*time.Hour))
+	assert.Equal(t, "1 hour", formatValidFor(time.Hour))
+	assert.Equal(t, "30 minutes", formatValidFor(30*time.Minute))
+}
+
+func TestLimiter(t *testing.T) {
+	now := time.Now()
+	l := newLimiter(2, time.Hour)
+	l.now = func() time.Time { return now }
+
+	assert.True(t, l.allow("a"))
+	assert.True(t, l.allow("a"))
+	assert.False(t, l.allow("a"))
+	assert.True(t, l.allow("b"))
+
+	// the window is reset once it's over
+	now = now.Add(time.Hour)
+	assert.True(t, l.allow("a"))
+	assert.Len(t, l.windows, 1)
+}
+
+func TestClientIP(t *testing.T) {
+	// the client sent 10.0.0.1 itself, the load balancer added 10.0.0.2 and the gateway added 127.0.0.1
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-forwarded-for", "10.0.0.1, 10.0.0.2, 127.0.0.1"))
+	assert.Equal(t, "127.0.0.1", clientIP(ctx, 0))
+	assert.Equal(t, "10.0.0.2", clientIP(ctx, 1))
+
+	// the entries of the repeated header are taken in order
+	ctx = metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-forwarded-for", "10.0.0.1", "x-forwarded-for", "10.0.0.2, 127.0.0.1"))
+	assert.Equal(t, "10.0.0.2", clientIP(ctx, 1))
+
+	// the header shorter than the trusted proxies falls back to the peer
+	peerCtx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("192.168.0.1"), Port: 443}})
+	ctx = metadata.NewIncomingContext(peerCtx, metadata.Pairs("x-forwarded-for", "127.0.0.1"))
+	assert.Equal(t, "192.168.0.1", clientIP(ctx, 1))
+	assert.Equal(t, "192.168.0.1", clientIP(peerCtx, 0))
+
+	assert.Equal(t, "", clientIP(context.Background(), 0))
+}
+
+// lookupOrder returns an order for the emails in emails.
+type lookupOrder struct {
+	dependency.Order
+	emails map[string]bool
+}
+
+func (o *lookupOrder) GetOrdersByStatusAndPaymentTypePaged(ctx context.Context, email string, statusId, paymentMethodId, orderId, lim int, off int, of entity.OrderFactor) ([]entity.Order, error) {
+	if o.emails[email] {
+		return []entity.Order{{Id: 1}}, nil
+	}
+	return nil, nil
+}
+
+type lookupRepository struct {
+	dependency.Repository
+	order *lookupOrder
+}
+
+func (r *lookupRepository) Order() dependency.Order {
+	return r.order
+}
+
+// lookupMailer passes the recipients of the lookup links to sent.
+type lookupMailer struct {
+	dependency.Mailer
+	sent chan string
+}
+
+func (m *lookupMailer) SendOrderLookup(ctx context.Context, rep dependency.Repository, to string, lookupDetails *dto.OrderLookup) error {
+	m.sent <- to
+	return nil
+}
+
+func TestRequestOrderLookup(t *testing.T) {
+	mailer := &lookupMailer{sent: make(chan string, 10)}
+	s := New(&Config{OrderLookupSecret: "secret", OrderLookupEmailLimit: 1, OrderLookupIPLimit: 3}, &lookupRepository{
+		order: &lookupOrder{emails: map[string]bool{"buyer@example.com": true}},
+	}, mailer, nil, nil, nil)
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-forwarded-for", "10.0.0.1"))
+
+	received := func() string {
+		select {
+		case to := <-mailer.sent:
+			return to
+		case <-time.After(100 * time.Millisecond):
+			return ""
+		}
+	}
+
+	// the response is the same whether the email has orders or not
+	resp, err := s.RequestOrderLookup(ctx, &pb_frontend.RequestOrderLookupRequest{Email: "Buyer@example.com"})
+	assert.NoError(t, err)
+	assert.Equal(t, &pb_frontend.RequestOrderLookupResponse{}, resp)
+	assert.Equal(t, "buyer@example.com", received())
+
+	resp, err = s.RequestOrderLookup(ctx, &pb_frontend.RequestOrderLookupRequest{Email: "nobody@example.com"})
+	assert.NoError(t, err)
+	assert.Equal(t, &pb_frontend.RequestOrderLookupResponse{}, resp)
+	assert.Equal(t, "", received())
+
+	// the link over the email limit is dropped silently
+	resp, err = s.RequestOrderLookup(ctx, &pb_frontend.RequestOrderLookupRequest{Email: "buyer@example.com"})
+	assert.NoError(t, err)
+	assert.Equal(t, &pb_frontend.RequestOrderLookupResponse{}, resp)
+	assert.Equal(t, "", received())
+
+	// the client over the IP limit is rejected
+	_, err = s.RequestOrderLookup(ctx, &pb_frontend.RequestOrderLookupRequest{Email: "other@example.com"})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
@@ -0,0 +1,77 @@
+package frontend
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jekabolt/grbpwr-manager/internal/dependency"
+	"github.com/jekabolt/grbpwr-manager/internal/entity"
+	pb_frontend "github.com/jekabolt/grbpwr-manager/proto/gen/frontend"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+// taxSettings returns the tax rules by country.
+type taxSettings struct {
+	dependency.Settings
+	rules map[string]entity.TaxRule
+}
+
+func (s *taxSettings) GetTaxRule(ctx context.Context, country string) (*entity.TaxRule, error) {
+	r, ok := s.rules[entity.NormalizeTaxCountry(country)]
+	if !ok {
+		return nil, nil
+	}
+	return &r, nil
+}
+
+func (s *taxSettings) GetTaxRules(ctx context.Context) ([]entity.TaxRule, error) {
+	rules := make([]entity.TaxRule, 0, len(s.rules))
+	for _, r := range s.rules {
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+type taxRepository struct {
+	dependency.Repository
+	settings *taxSettings
+}
+
+func (r *taxRepository) Settings() dependency.Settings {
+	return r.settings
+}
+
+func TestOrderItemsTax(t *testing.T) {
+	settings := &taxSettings{rules: map[string]entity.TaxRule{}}
+	s := &Server{repo: &taxRepository{settings: settings}}
+	items := []entity.OrderItem{{
+		OrderItemInsert: entity.OrderItemInsert{ProductPriceWithSale: decimal.NewFromInt(100), Quantity: decimal.NewFromInt(1)},
+	}}
+
+	// nothing is taxed without the rules
+	tax, known, err := s.orderItemsTax(context.Background(), &pb_frontend.ValidateOrderItemsInsertRequest{}, items)
+	assert.NoError(t, err)
+	assert.True(t, known)
+	assert.True(t, tax.Total.IsZero())
+
+	settings.rules["LV"] = entity.TaxRule{Country: "LV", DefaultRate: decimal.NewFromInt(21)}
+
+	// the tax is unknown until the country is known
+	tax, known, err = s.orderItemsTax(context.Background(), &pb_frontend.ValidateOrderItemsInsertRequest{}, items)
+	assert.NoError(t, err)
+	assert.False(t, known)
+	assert.True(t, tax.Surcharge().IsZero())
+	assert.Len(t, tax.Items, 1)
+
+	tax, known, err = s.orderItemsTax(context.Background(), &pb_frontend.ValidateOrderItemsInsertRequest{Country: "lv"}, items)
+	assert.NoError(t, err)
+	assert.True(t, known)
+	assert.True(t, tax.Total.Equal(decimal.NewFromInt(21)), tax.Total.String())
+
+	// the country without the rule is not taxed
+	tax, known, err = s.orderItemsTax(context.Background(), &pb_frontend.ValidateOrderItemsInsertRequest{Country: "US"}, items)
+	assert.NoError(t, err)
+	assert.True(t, known)
+	assert.True(t, tax.Total.IsZero())
+}
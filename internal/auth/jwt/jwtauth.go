@@ -14,9 +14,10 @@ func VerifyToken(jwtAuth *jwtauth.JWTAuth, token string) (string, error) {
 	return t.Subject(), nil
 }
 
-func NewToken(jwtAuth *jwtauth.JWTAuth, ttl time.Duration) (string, error) {
+func NewToken(jwtAuth *jwtauth.JWTAuth, ttl time.Duration, subject string) (string, error) {
 	_, ts, err := jwtAuth.Encode(map[string]interface{}{
 		"exp": time.Now().Add(ttl).Unix(),
+		"sub": subject,
 	})
 	if err != nil {
 		return ts, err
@@ -91,6 +91,7 @@ var (
 	OrderStatusDelivered       = Status{Status: entity.OrderStatus{Name: entity.Delivered}, PB: pb_common.OrderStatusEnum_ORDER_STATUS_ENUM_DELIVERED}
 	OrderStatusCancelled       = Status{Status: entity.OrderStatus{Name: entity.Cancelled}, PB: pb_common.OrderStatusEnum_ORDER_STATUS_ENUM_CANCELLED}
 	OrderStatusRefunded        = Status{Status: entity.OrderStatus{Name: entity.Refunded}, PB: pb_common.OrderStatusEnum_ORDER_STATUS_ENUM_REFUNDED}
+	OrderStatusPartiallyPaid   = Status{Status: entity.OrderStatus{Name: entity.PartiallyPaid}, PB: pb_common.OrderStatusEnum_ORDER_STATUS_ENUM_PARTIALLY_PAID}
 
 	orderStatuses = []*Status{
 		&OrderStatusPlaced,
@@ -100,6 +101,7 @@ var (
 		&OrderStatusDelivered,
 		&OrderStatusCancelled,
 		&OrderStatusRefunded,
+		&OrderStatusPartiallyPaid,
 	}
 
 	entityOrderStatuses = []entity.OrderStatus{}
@@ -152,6 +154,18 @@ var (
 	PaymentMethodUsdtTronTest = PaymentMethod{Method: entity.PaymentMethod{
 		Name: entity.USDT_TRON_TEST,
 	}, PB: pb_common.PaymentMethodNameEnum_PAYMENT_METHOD_NAME_ENUM_USDT_SHASTA}
+	PaymentMethodUsdtEth = PaymentMethod{Method: entity.PaymentMethod{
+		Name: entity.USDT_ETH,
+	}, PB: pb_common.PaymentMethodNameEnum_PAYMENT_METHOD_NAME_ENUM_USDT_ETH}
+	PaymentMethodUsdtEthTest = PaymentMethod{Method: entity.PaymentMethod{
+		Name: entity.USDT_ETH_TEST,
+	}, PB: pb_common.PaymentMethodNameEnum_PAYMENT_METHOD_NAME_ENUM_USDT_SEPOLIA}
+	PaymentMethodUsdcEth = PaymentMethod{Method: entity.PaymentMethod{
+		Name: entity.USDC_ETH,
+	}, PB: pb_common.PaymentMethodNameEnum_PAYMENT_METHOD_NAME_ENUM_USDC_ETH}
+	PaymentMethodUsdcEthTest = PaymentMethod{Method: entity.PaymentMethod{
+		Name: entity.USDC_ETH_TEST,
+	}, PB: pb_common.PaymentMethodNameEnum_PAYMENT_METHOD_NAME_ENUM_USDC_SEPOLIA}
 
 	paymentMethods = []*PaymentMethod{
 		&PaymentMethodCard,
@@ -160,6 +174,10 @@ var (
 		&PaymentMethodEthTest,
 		&PaymentMethodUsdtTron,
 		&PaymentMethodUsdtTronTest,
+		&PaymentMethodUsdtEth,
+		&PaymentMethodUsdtEthTest,
+		&PaymentMethodUsdcEth,
+		&PaymentMethodUsdcEthTest,
 	}
 
 	entityPaymentMethods = []entity.PaymentMethod{}
@@ -173,15 +191,23 @@ var (
 		entity.ETH_TEST:       &PaymentMethodEthTest,
 		entity.USDT_TRON:      &PaymentMethodUsdtTron,
 		entity.USDT_TRON_TEST: &PaymentMethodUsdtTronTest,
+		entity.USDT_ETH:       &PaymentMethodUsdtEth,
+		entity.USDT_ETH_TEST:  &PaymentMethodUsdtEthTest,
+		entity.USDC_ETH:       &PaymentMethodUsdcEth,
+		entity.USDC_ETH_TEST:  &PaymentMethodUsdcEthTest,
 	}
 
 	paymentMethodIdByPbId = map[pb_common.PaymentMethodNameEnum]int{
-		pb_common.PaymentMethodNameEnum_PAYMENT_METHOD_NAME_ENUM_CARD:        PaymentMethodCard.Method.Id,
-		pb_common.PaymentMethodNameEnum_PAYMENT_METHOD_NAME_ENUM_CARD_TEST:   PaymentMethodCardTest.Method.Id,
-		pb_common.PaymentMethodNameEnum_PAYMENT_METHOD_NAME_ENUM_ETH:         PaymentMethodEth.Method.Id,
-		pb_common.PaymentMethodNameEnum_PAYMENT_METHOD_NAME_ENUM_ETH_TEST:    PaymentMethodEthTest.Method.Id,
-		pb_common.PaymentMethodNameEnum_PAYMENT_METHOD_NAME_ENUM_USDT_TRON:   PaymentMethodUsdtTron.Method.Id,
-		pb_common.PaymentMethodNameEnum_PAYMENT_METHOD_NAME_ENUM_USDT_SHASTA: PaymentMethodUsdtTronTest.Method.Id,
+		pb_common.PaymentMethodNameEnum_PAYMENT_METHOD_NAME_ENUM_CARD:         PaymentMethodCard.Method.Id,
+		pb_common.PaymentMethodNameEnum_PAYMENT_METHOD_NAME_ENUM_CARD_TEST:    PaymentMethodCardTest.Method.Id,
+		pb_common.PaymentMethodNameEnum_PAYMENT_METHOD_NAME_ENUM_ETH:          PaymentMethodEth.Method.Id,
+		pb_common.PaymentMethodNameEnum_PAYMENT_METHOD_NAME_ENUM_ETH_TEST:     PaymentMethodEthTest.Method.Id,
+		pb_common.PaymentMethodNameEnum_PAYMENT_METHOD_NAME_ENUM_USDT_TRON:    PaymentMethodUsdtTron.Method.Id,
+		pb_common.PaymentMethodNameEnum_PAYMENT_METHOD_NAME_ENUM_USDT_SHASTA:  PaymentMethodUsdtTronTest.Method.Id,
+		pb_common.PaymentMethodNameEnum_PAYMENT_METHOD_NAME_ENUM_USDT_ETH:     PaymentMethodUsdtEth.Method.Id,
+		pb_common.PaymentMethodNameEnum_PAYMENT_METHOD_NAME_ENUM_USDT_SEPOLIA: PaymentMethodUsdtEthTest.Method.Id,
+		pb_common.PaymentMethodNameEnum_PAYMENT_METHOD_NAME_ENUM_USDC_ETH:     PaymentMethodUsdcEth.Method.Id,
+		pb_common.PaymentMethodNameEnum_PAYMENT_METHOD_NAME_ENUM_USDC_SEPOLIA: PaymentMethodUsdcEthTest.Method.Id,
 	}
 
 	// Sizes
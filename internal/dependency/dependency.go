@@ -3,9 +3,12 @@ package dependency
 import (
 	"context"
 	"database/sql"
+	"math/big"
 	"net/http"
 	"time"
 
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/jekabolt/grbpwr-manager/internal/dto"
 	"github.com/jekabolt/grbpwr-manager/internal/entity"
 	"github.com/jekabolt/grbpwr-manager/openapi/gen/resend"
@@ -51,6 +54,17 @@ type (
 		GetHero(ctx context.Context) (*entity.HeroFull, error)
 	}
 
+	Returns interface {
+		AddReturnRequest(ctx context.Context, rri *entity.ReturnRequestInsert) (*entity.ReturnRequestFull, error)
+		GetReturnRequestByUUID(ctx context.Context, uuid string) (*entity.ReturnRequestFull, error)
+		ListReturnRequests(ctx context.Context, st entity.ReturnRequestStatus, limit int, offset int, of entity.OrderFactor) ([]entity.ReturnRequest, error)
+		ApproveReturnRequest(ctx context.Context, uuid string) error
+		RejectReturnRequest(ctx context.Context, uuid string) error
+		ReceiveReturnRequest(ctx context.Context, uuid string) error
+		CompleteReturnRequest(ctx context.Context, uuid string, refundId int) error
+		CancelReturnRequest(ctx context.Context, uuid string) error
+	}
+
 	Mail interface {
 		AddMail(ctx context.Context, ser *entity.SendEmailRequest) (int, error)
 		GetAllUnsent(ctx context.Context, withError bool) ([]entity.SendEmailRequest, error)
@@ -65,30 +79,97 @@ type (
 		InsertCryptoInvoice(ctx context.Context, orderUUID string, payeeAddress string, pm entity.PaymentMethod) (*entity.OrderFull, error)
 		InsertFiatInvoice(ctx context.Context, orderUUID string, clientSecret string, pm entity.PaymentMethod) (*entity.OrderFull, error)
 		UpdateTotalPaymentCurrency(ctx context.Context, orderUUID string, tapc decimal.Decimal) error
-		SetTrackingNumber(ctx context.Context, orderUUID string, trackingCode string) (*entity.OrderBuyerShipment, error)
+		UpdatePaymentCurrencyRate(ctx context.Context, orderUUID string, tapc decimal.Decimal, rate decimal.Decimal) error
+		UpdatePaymentQuote(ctx context.Context, orderUUID string, q *entity.PaymentQuote) error
+		UpdatePaymentStartBlock(ctx context.Context, orderUUID string, startBlock uint64) error
+		SetTrackingNumber(ctx context.Context, orderUUID string, trackingCode string, actor string) (*entity.OrderBuyerShipment, error)
+		AddOrderShipment(ctx context.Context, orderUUID string, osn *entity.OrderShipmentNew, actor string) (*entity.OrderBuyerShipment, error)
 		GetOrderById(ctx context.Context, orderID int) (*entity.OrderFull, error)
 		GetPaymentByOrderUUID(ctx context.Context, orderUUID string) (*entity.Payment, error)
+		MarkWebhookEventProcessed(ctx context.Context, eventId string, eventType string) (bool, error)
+		GetPaymentAddressByOrderUUID(ctx context.Context, orderUUID string, paymentMethodId int) (*entity.PaymentAddress, error)
+		NextDerivationIndex(ctx context.Context, paymentMethodId int) (int, error)
+		AddPaymentAddress(ctx context.Context, orderUUID string, pa *entity.PaymentAddressInsert) error
+		AddPaymentExpiration(ctx context.Context, orderUUID string, paymentMethodId int, expireAt time.Time) error
+		AddMissingPaymentExpiration(ctx context.Context, orderUUID string, paymentMethodId int, expireAt time.Time) error
+		RemovePaymentExpiration(ctx context.Context, orderUUID string) error
+		ClaimDuePaymentExpiration(ctx context.Context) (*entity.PaymentExpiration, error)
+		DeletePaymentExpiration(ctx context.Context, id int) error
+		DelayPaymentExpiration(ctx context.Context, id int, delay time.Duration) error
 		GetOrderFullByUUID(ctx context.Context, orderUUID string) (*entity.OrderFull, error)
 		GetOrderByUUID(ctx context.Context, orderUUID string) (*entity.Order, error)
+		GetOrdersFullByBuyerEmail(ctx context.Context, email string, limit int) ([]entity.OrderFull, error)
+		GetOrdersFullPlacedBetween(ctx context.Context, from, to time.Time, afterId, limit int) ([]entity.OrderFull, error)
 		// CheckPaymentPendingByUUID(ctx context.Context, orderUUID string) (*entity.Payment, *entity.Order, error)
 		GetOrdersByStatusAndPaymentTypePaged(ctx context.Context, email string, statusId, paymentMethodId, orderId, lim int, off int, of entity.OrderFactor) ([]entity.Order, error)
 		GetAwaitingPaymentsByPaymentType(ctx context.Context, pmn ...entity.PaymentMethodName) ([]entity.PaymentOrderUUID, error)
+		GetPaymentsCreatedAfter(ctx context.Context, from time.Time, pmn ...entity.PaymentMethodName) ([]entity.PaymentOrderStatus, error)
 		ExpireOrderPayment(ctx context.Context, orderUUID string) (*entity.Payment, error)
 		OrderPaymentDone(ctx context.Context, orderUUID string, p *entity.Payment) (*entity.Payment, error)
-		RefundOrder(ctx context.Context, orderUUID string) error
-		DeliveredOrder(ctx context.Context, orderUUID string) error
-		CancelOrder(ctx context.Context, orderUUID string) error
+		OrderPaymentPartiallyPaid(ctx context.Context, orderUUID string) error
+		AddPaymentOverpayment(ctx context.Context, orderUUID string, op *entity.PaymentOverpaymentInsert) error
+		AddPaymentEvent(ctx context.Context, orderUUID string, pe *entity.PaymentEventInsert) error
+		GetPaymentEventsByOrderUUID(ctx context.Context, orderUUID string) ([]entity.PaymentEvent, error)
+		RefundOrder(ctx context.Context, orderUUID string, amount decimal.Decimal, restock []entity.RefundItemInsert, reason string, actor string) (*entity.Refund, error)
+		UpdateRefund(ctx context.Context, refundId int, st entity.RefundStatus, providerRefundId string, actor string) (*entity.Refund, error)
+		GetRefundsByOrderUUID(ctx context.Context, orderUUID string) ([]entity.Refund, error)
+		DeliveredOrder(ctx context.Context, orderUUID string, actor string) error
+		CancelOrder(ctx context.Context, orderUUID string, actor, reason string) error
+		AddOrderNote(ctx context.Context, orderUUID string, oni *entity.OrderNoteInsert) (*entity.OrderNote, error)
+		GetOrderNotes(ctx context.Context, orderUUID string) ([]entity.OrderNote, error)
+		DeleteOrderNote(ctx context.Context, orderUUID string, noteId int) error
+		UpdateOrderAddresses(ctx context.Context, orderUUID string, shipping, billing *entity.AddressInsert, actor string) (*entity.OrderAdjustment, error)
+		UpdateOrderBuyer(ctx context.Context, orderUUID string, b *entity.BuyerInsert) error
+		UpdateOrderItemsByAdmin(ctx context.Context, orderUUID string, items []entity.OrderItemInsert, actor string) (*entity.OrderAdjustment, error)
+		// GetOrderInvoiceDocument returns the invoice of the order or its correction by the number as it was issued, the invoice if the number is zero
+		GetOrderInvoiceDocument(ctx context.Context, orderUUID string, number int) (*entity.InvoiceDocument, error)
+		GetCheckoutReminderCandidates(ctx context.Context, expiresWithin, expiredWithin time.Duration, limit int) ([]entity.CheckoutReminderCandidate, error)
+		AddCheckoutReminder(ctx context.Context, orderId int, expired bool) (bool, error)
+		DeleteCheckoutReminder(ctx context.Context, orderId int) error
+		GetCheckoutReminderReport(ctx context.Context, from, to time.Time) (*entity.CheckoutReminderReport, error)
+	}
+
+	// Reconciler compares the payments with the payment providers data.
+	Reconciler interface {
+		Reconcile(ctx context.Context, from time.Time) (*entity.ReconciliationReport, error)
+	}
+
+	// InvoiceGenerator renders the PDF invoices of the confirmed orders and their corrections
+	InvoiceGenerator interface {
+		Generate(doc *entity.InvoiceDocument) ([]byte, error)
+		FileName(doc *entity.InvoiceDocument) string
 	}
 
 	// TODO: invoice to separate interface
 	Invoicer interface {
 		GetOrderInvoice(ctx context.Context, orderUUID string) (*entity.PaymentInsert, time.Time, error)
-		CancelMonitorPayment(orderUUID string) error
+		// CancelMonitorPayment stops watching the invoice of the order, payment is the invoice as it was before its cancellation
+		CancelMonitorPayment(orderUUID string, payment entity.Payment) error
 		CheckForTransactions(ctx context.Context, orderUUID string, payment entity.Payment) (*entity.Payment, error)
 	}
 
+	// Processors resolves the payment processors by their payment method.
+	Processors interface {
+		Invoicer(pmn entity.PaymentMethodName) (Invoicer, bool)
+		Refunder(pmn entity.PaymentMethodName) (Refunder, bool)
+		Expirer(pmn entity.PaymentMethodName) (PaymentExpirer, bool)
+	}
+
+	// PaymentExpirer expires the unpaid invoice of the order within the transaction of the scheduled expiration.
+	// It returns true if the invoice was found paid and the order was confirmed instead,
+	// the confirmation is sent once the transaction is committed.
+	PaymentExpirer interface {
+		ExpirePayment(ctx context.Context, rep Repository, orderUUID string) (bool, error)
+	}
+
+	// Refunder returns the money of the order payment through the payment provider.
+	Refunder interface {
+		Refund(ctx context.Context, payment entity.Payment, amount decimal.Decimal, idempotencyKey string) (string, entity.RefundStatus, error)
+	}
+
 	StripePayment interface {
 		CreatePaymentIntent(order entity.OrderFull) (*stripe.PaymentIntent, error)
+		GetPaymentIntent(paymentSecret string) (*stripe.PaymentIntent, error)
 	}
 
 	// Invoice interface {
@@ -96,7 +177,15 @@ type (
 	// }
 
 	Trongrid interface {
-		GetAddressTransactions(address string) (*dto.TronTransactionsResponse, error)
+		GetAddressTransactions(address string, contractAddress string, minTimestamp time.Time) (*dto.TronTransactionsResponse, error)
+		GetNowBlock() (*dto.TronBlock, error)
+		GetTransactionInfoById(txId string) (*dto.TronTransactionInfo, error)
+	}
+
+	EthClient interface {
+		HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+		BlockByNumber(ctx context.Context, number *big.Int) (*types.Block, error)
+		FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error)
 	}
 
 	Subscribers interface {
@@ -113,6 +202,13 @@ type (
 		DisableVoucher(ctx context.Context, promoID sql.NullInt32) error
 	}
 
+	GiftCards interface {
+		AddGiftCard(ctx context.Context, gci *entity.GiftCardInsert) (*entity.GiftCard, error)
+		ListGiftCards(ctx context.Context, limit, offset int, orderFactor entity.OrderFactor) ([]entity.GiftCard, error)
+		GetGiftCardByCode(ctx context.Context, code string) (*entity.GiftCardFull, error)
+		DisableGiftCard(ctx context.Context, code string) error
+	}
+
 	Rates interface {
 		GetLatestRates(ctx context.Context) ([]entity.CurrencyRate, error)
 		BulkUpdateRates(ctx context.Context, rates []entity.CurrencyRate) error
@@ -146,13 +242,19 @@ type (
 		SetPaymentMethodAllowance(ctx context.Context, paymentMethod entity.PaymentMethodName, allowance bool) error
 		SetSiteAvailability(ctx context.Context, allowance bool) error
 		SetMaxOrderItems(ctx context.Context, count int) error
+		GetTaxRules(ctx context.Context) ([]entity.TaxRule, error)
+		GetTaxRule(ctx context.Context, country string) (*entity.TaxRule, error)
+		SetTaxRule(ctx context.Context, tr *entity.TaxRule) error
+		DeleteTaxRule(ctx context.Context, country string) error
 	}
 
 	Repository interface {
 		Products() Products
 		Hero() Hero
 		Order() Order
+		Returns() Returns
 		Promo() Promo
+		GiftCards() GiftCards
 		Rates() Rates
 		Admin() Admin
 		Cache() Cache
@@ -216,6 +318,8 @@ type (
 		SendOrderCancellation(ctx context.Context, rep Repository, to string, orderDetails *dto.OrderCancelled) error
 		SendOrderShipped(ctx context.Context, rep Repository, to string, shipmentDetails *dto.OrderShipment) error
 		SendPromoCode(ctx context.Context, rep Repository, to string, promoDetails *dto.PromoCodeDetails) error
+		SendCheckoutReminder(ctx context.Context, rep Repository, to string, reminderDetails *dto.CheckoutReminder) error
+		SendOrderLookup(ctx context.Context, rep Repository, to string, lookupDetails *dto.OrderLookup) error
 		Start(ctx context.Context) error
 		Stop() error
 	}
@@ -224,9 +328,11 @@ type (
 		PostEmails(ctx context.Context, body resend.SendEmailRequest, reqEditors ...resend.RequestEditorFn) (*http.Response, error)
 	}
 
+	// PaymentPool expires the unpaid invoices at their deadlines persisted in the database.
 	PaymentPool interface {
-		AddPaymentExpiration(ctx context.Context, poid entity.PaymentOrderUUID) error
-		RemovePaymentExpiration(orderId int) error
+		AddPaymentExpiration(ctx context.Context, orderUUID string, pmn entity.PaymentMethodName, expireAt time.Time) error
+		ScheduleAwaitingPayments(ctx context.Context, pmn entity.PaymentMethodName, invoiceExpiration time.Duration) error
+		RemovePaymentExpiration(ctx context.Context, orderUUID string) error
 		Start(ctx context.Context) error
 	}
 )
@@ -102,6 +102,7 @@ var (
 		entity.Delivered:       pb_common.OrderStatusEnum_ORDER_STATUS_ENUM_DELIVERED,
 		entity.Cancelled:       pb_common.OrderStatusEnum_ORDER_STATUS_ENUM_CANCELLED,
 		entity.Refunded:        pb_common.OrderStatusEnum_ORDER_STATUS_ENUM_REFUNDED,
+		entity.PartiallyPaid:   pb_common.OrderStatusEnum_ORDER_STATUS_ENUM_PARTIALLY_PAID,
 	}
 
 	orderStatusPbEntityMap = map[pb_common.OrderStatusEnum]entity.OrderStatusName{
@@ -112,6 +113,7 @@ var (
 		pb_common.OrderStatusEnum_ORDER_STATUS_ENUM_DELIVERED:        entity.Delivered,
 		pb_common.OrderStatusEnum_ORDER_STATUS_ENUM_CANCELLED:        entity.Cancelled,
 		pb_common.OrderStatusEnum_ORDER_STATUS_ENUM_REFUNDED:         entity.Refunded,
+		pb_common.OrderStatusEnum_ORDER_STATUS_ENUM_PARTIALLY_PAID:   entity.PartiallyPaid,
 	}
 
 	paymentMethodEntityPbMap = map[entity.PaymentMethodName]pb_common.PaymentMethodNameEnum{
@@ -121,6 +123,10 @@ var (
 		entity.ETH_TEST:       pb_common.PaymentMethodNameEnum_PAYMENT_METHOD_NAME_ENUM_ETH_TEST,
 		entity.USDT_TRON:      pb_common.PaymentMethodNameEnum_PAYMENT_METHOD_NAME_ENUM_USDT_TRON,
 		entity.USDT_TRON_TEST: pb_common.PaymentMethodNameEnum_PAYMENT_METHOD_NAME_ENUM_USDT_SHASTA,
+		entity.USDT_ETH:       pb_common.PaymentMethodNameEnum_PAYMENT_METHOD_NAME_ENUM_USDT_ETH,
+		entity.USDT_ETH_TEST:  pb_common.PaymentMethodNameEnum_PAYMENT_METHOD_NAME_ENUM_USDT_SEPOLIA,
+		entity.USDC_ETH:       pb_common.PaymentMethodNameEnum_PAYMENT_METHOD_NAME_ENUM_USDC_ETH,
+		entity.USDC_ETH_TEST:  pb_common.PaymentMethodNameEnum_PAYMENT_METHOD_NAME_ENUM_USDC_SEPOLIA,
 	}
 
 	paymentMethodPbEntityMap = map[pb_common.PaymentMethodNameEnum]entity.PaymentMethodName{
@@ -128,6 +134,8 @@ var (
 		pb_common.PaymentMethodNameEnum_PAYMENT_METHOD_NAME_ENUM_ETH:         entity.ETH,
 		pb_common.PaymentMethodNameEnum_PAYMENT_METHOD_NAME_ENUM_USDT_TRON:   entity.USDT_TRON,
 		pb_common.PaymentMethodNameEnum_PAYMENT_METHOD_NAME_ENUM_USDT_SHASTA: entity.USDT_TRON_TEST,
+		pb_common.PaymentMethodNameEnum_PAYMENT_METHOD_NAME_ENUM_USDT_ETH:    entity.USDT_ETH,
+		pb_common.PaymentMethodNameEnum_PAYMENT_METHOD_NAME_ENUM_USDC_ETH:    entity.USDC_ETH,
 	}
 
 	sizeEntityPbMap = map[entity.SizeEnum]pb_common.SizeEnum{
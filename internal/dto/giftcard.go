@@ -0,0 +1,82 @@
+package dto
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/jekabolt/grbpwr-manager/internal/entity"
+	pb_common "github.com/jekabolt/grbpwr-manager/proto/gen/common"
+	"github.com/shopspring/decimal"
+	pb_decimal "google.golang.org/genproto/googleapis/type/decimal"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var giftCardTransactionTypeEntityPbMap = map[entity.GiftCardTransactionType]pb_common.GiftCardTransactionTypeEnum{
+	entity.GiftCardIssued:   pb_common.GiftCardTransactionTypeEnum_GIFT_CARD_TRANSACTION_TYPE_ENUM_ISSUED,
+	entity.GiftCardRedeemed: pb_common.GiftCardTransactionTypeEnum_GIFT_CARD_TRANSACTION_TYPE_ENUM_REDEEMED,
+	entity.GiftCardReturned: pb_common.GiftCardTransactionTypeEnum_GIFT_CARD_TRANSACTION_TYPE_ENUM_RETURNED,
+}
+
+// ConvertPbGiftCardInsertToEntity converts the gift card issued by the admin, the card never expires if the expiration is not set.
+func ConvertPbGiftCardInsertToEntity(pbGc *pb_common.GiftCardInsert) (*entity.GiftCardInsert, error) {
+	if pbGc == nil || pbGc.InitialBalance == nil {
+		return nil, fmt.Errorf("gift card balance is required")
+	}
+
+	balance, err := decimal.NewFromString(pbGc.InitialBalance.Value)
+	if err != nil {
+		return nil, fmt.Errorf("error converting balance to decimal: %v", err)
+	}
+
+	gci := &entity.GiftCardInsert{
+		Code:           pbGc.Code,
+		InitialBalance: balance,
+		Allowed:        pbGc.Allowed,
+	}
+	if pbGc.Expiration != nil {
+		gci.Expiration = sql.NullTime{Time: pbGc.Expiration.AsTime(), Valid: true}
+	}
+
+	return gci, nil
+}
+
+func ConvertEntityGiftCardInsertToPb(gci entity.GiftCardInsert) *pb_common.GiftCardInsert {
+	pbGc := &pb_common.GiftCardInsert{
+		Code:           gci.Code,
+		InitialBalance: &pb_decimal.Decimal{Value: gci.InitialBalance.Round(2).String()},
+		Allowed:        gci.Allowed,
+	}
+	if gci.Expiration.Valid {
+		pbGc.Expiration = timestamppb.New(gci.Expiration.Time)
+	}
+	return pbGc
+}
+
+func ConvertEntityGiftCardToPb(gc entity.GiftCard) *pb_common.GiftCard {
+	return &pb_common.GiftCard{
+		Id:             int32(gc.Id),
+		Balance:        &pb_decimal.Decimal{Value: gc.Balance.Round(2).String()},
+		CreatedAt:      timestamppb.New(gc.CreatedAt),
+		ModifiedAt:     timestamppb.New(gc.ModifiedAt),
+		GiftCardInsert: ConvertEntityGiftCardInsertToPb(gc.GiftCardInsert),
+	}
+}
+
+func ConvertEntityGiftCardFullToPb(gcf *entity.GiftCardFull) *pb_common.GiftCardFull {
+	txs := make([]*pb_common.GiftCardTransaction, 0, len(gcf.Transactions))
+	for _, t := range gcf.Transactions {
+		txs = append(txs, &pb_common.GiftCardTransaction{
+			Id:           int32(t.Id),
+			OrderId:      t.OrderId.Int32,
+			Type:         giftCardTransactionTypeEntityPbMap[t.Type],
+			Amount:       &pb_decimal.Decimal{Value: t.Amount.Round(2).String()},
+			BalanceAfter: &pb_decimal.Decimal{Value: t.BalanceAfter.Round(2).String()},
+			CreatedAt:    timestamppb.New(t.CreatedAt),
+		})
+	}
+
+	return &pb_common.GiftCardFull{
+		GiftCard:     ConvertEntityGiftCardToPb(gcf.GiftCard),
+		Transactions: txs,
+	}
+}
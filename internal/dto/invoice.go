@@ -0,0 +1,82 @@
+package dto
+
+import (
+	"strings"
+
+	"github.com/jekabolt/grbpwr-manager/internal/cache"
+	"github.com/jekabolt/grbpwr-manager/internal/entity"
+	"github.com/shopspring/decimal"
+)
+
+// OrderFullToInvoiceContent is the content of the invoice of the order as it is now,
+// the total is the one the order is placed with.
+func OrderFullToInvoiceContent(of *entity.OrderFull) entity.InvoiceContent {
+	sizes := make(map[int]string)
+	for _, s := range cache.GetSizes() {
+		sizes[s.Id] = strings.ToUpper(string(s.Name))
+	}
+
+	lines := make([]entity.InvoiceLine, 0, len(of.OrderItems))
+	subtotal := decimal.Zero
+	for _, oi := range of.OrderItems {
+		price := oi.ProductPriceWithSaleDecimal()
+		lines = append(lines, entity.InvoiceLine{
+			Name:      strings.TrimSpace(oi.ProductBrand + " " + oi.ProductName),
+			Size:      sizes[oi.SizeId],
+			SKU:       oi.SKU,
+			Quantity:  oi.Quantity,
+			UnitPrice: price,
+			Amount:    price.Mul(oi.Quantity).Round(2),
+		})
+		subtotal = subtotal.Add(price.Mul(oi.Quantity))
+	}
+	subtotal = subtotal.Round(2)
+
+	ic := entity.InvoiceContent{
+		OrderUUID:    of.Order.UUID,
+		OrderPlaced:  of.Order.Placed,
+		BuyerName:    strings.TrimSpace(of.Buyer.FirstName + " " + of.Buyer.LastName),
+		BuyerEmail:   of.Buyer.Email,
+		Billing:      addressLines(&of.Billing),
+		Lines:        lines,
+		Currency:     cache.GetBaseCurrency(),
+		Subtotal:     subtotal,
+		Discount:     decimal.Zero,
+		Shipping:     of.Shipment.Cost.Round(2),
+		Tax:          of.Order.TaxAmount.Round(2),
+		TaxInclusive: of.Order.TaxInclusive,
+		Total:        of.Order.TotalPriceDecimal(),
+		GiftCard:     of.Order.GiftCardAmount.Round(2),
+	}
+
+	if of.PromoCode.Discount.IsPositive() {
+		ic.PromoCode = of.PromoCode.Code
+		ic.DiscountPercent = of.PromoCode.DiscountDecimal()
+		ic.Discount = subtotal.Sub(of.PromoCode.SubtotalWithPromo(subtotal, decimal.Zero)).Round(2)
+	}
+	if of.PromoCode.FreeShipping {
+		ic.Shipping = decimal.Zero
+	}
+	if sc, ok := cache.GetShipmentCarrierById(of.Shipment.CarrierId); ok {
+		ic.Carrier = sc.Carrier
+	}
+	return ic
+}
+
+// addressLines are the lines of the address as it is written on the envelope
+func addressLines(a *entity.Address) []string {
+	lines := make([]string, 0, 6)
+	if a.Company.Valid && a.Company.String != "" {
+		lines = append(lines, a.Company.String)
+	}
+	lines = append(lines, a.AddressLineOne)
+	if a.AddressLineTwo.Valid && a.AddressLineTwo.String != "" {
+		lines = append(lines, a.AddressLineTwo.String)
+	}
+	lines = append(lines, strings.TrimSpace(a.PostalCode+" "+a.City))
+	if a.State.Valid && a.State.String != "" {
+		lines = append(lines, a.State.String)
+	}
+	lines = append(lines, a.Country)
+	return lines
+}
@@ -29,9 +29,20 @@ func OrderFullToOrderConfirmed(of *entity.OrderFull) *OrderConfirmed {
 		HasFreeShipping:     of.PromoCode.FreeShipping,
 		ShippingPrice:       sc.PriceDecimal().String(),
 		ShipmentCarrier:     sc.Carrier,
+		InvoiceKey:          invoiceKey(of.Invoice),
+		TaxAmount:           of.Order.TaxAmount.Round(2).String(),
+		TaxIncluded:         of.Order.TaxInclusive,
+		HasTax:              of.Order.TaxAmount.IsPositive(),
 	}
 }
 
+func invoiceKey(inv *entity.OrderInvoice) string {
+	if inv == nil {
+		return ""
+	}
+	return inv.AccessKey
+}
+
 func EntityOrderItemsToDto(items []entity.OrderItem) []OrderItem {
 	oi := make([]OrderItem, len(items))
 	for i, item := range items {
@@ -66,6 +77,12 @@ type OrderConfirmed struct {
 	HasFreeShipping     bool
 	ShippingPrice       string
 	ShipmentCarrier     string
+	// InvoiceKey grants the buyer the invoice download, empty if the order is not invoiced
+	InvoiceKey string
+	// TaxAmount is included in the prices if TaxIncluded is set, otherwise it is added to the total
+	TaxAmount   string
+	TaxIncluded bool
+	HasTax      bool
 }
 
 type OrderItem struct {
@@ -90,6 +107,10 @@ type OrderShipment struct {
 	Name         string
 	OrderUUID    string
 	ShippingDate string
+	TrackingCode string
+	Carrier      string
+	// Partial is set when some of the order items are still to be shipped
+	Partial bool
 }
 type PromoCodeDetails struct {
 	PromoCode       string
@@ -98,6 +119,20 @@ type PromoCodeDetails struct {
 	ExpirationDate  string
 }
 
+type CheckoutReminder struct {
+	Name       string
+	OrderUUID  string
+	TotalPrice string
+	// Expired is set if the invoice has already expired
+	Expired bool
+}
+
+type OrderLookup struct {
+	Token string
+	// ValidFor is how long the link can be used for
+	ValidFor string
+}
+
 func ResendSendEmailRequestToEntity(mr *resend.SendEmailRequest) (*entity.SendEmailRequest, error) {
 	if len(mr.To) == 0 {
 		return nil, fmt.Errorf("mail req 'to' is empty")
@@ -4,7 +4,9 @@ package dto
 import (
 	"database/sql"
 	"fmt"
+	"strings"
 
+	"github.com/jekabolt/grbpwr-manager/internal/cache"
 	"github.com/jekabolt/grbpwr-manager/internal/entity"
 	pb_common "github.com/jekabolt/grbpwr-manager/proto/gen/common"
 	"github.com/shopspring/decimal"
@@ -65,19 +67,11 @@ func ConvertCommonOrderNewToEntity(commonOrder *pb_common.OrderNew) (*entity.Ord
 	}
 
 	// Convert addresses
-	shippingAddress := convertAddress(commonOrder.ShippingAddress)
-	billingAddress := convertAddress(commonOrder.BillingAddress)
+	shippingAddress := ConvertPbAddressInsertToEntity(commonOrder.ShippingAddress)
+	billingAddress := ConvertPbAddressInsertToEntity(commonOrder.BillingAddress)
 
 	// Convert buyer
-	var buyer *entity.BuyerInsert
-	if commonOrder.Buyer != nil {
-		buyer = &entity.BuyerInsert{
-			FirstName: commonOrder.Buyer.FirstName,
-			LastName:  commonOrder.Buyer.LastName,
-			Email:     commonOrder.Buyer.Email,
-			Phone:     commonOrder.Buyer.Phone,
-		}
-	}
+	buyer := ConvertPbBuyerInsertToEntity(commonOrder.Buyer)
 
 	return &entity.OrderNew{
 		Items:             items,
@@ -87,11 +81,26 @@ func ConvertCommonOrderNewToEntity(commonOrder *pb_common.OrderNew) (*entity.Ord
 		PaymentMethod:     ConvertPbPaymentMethodToEntity(commonOrder.PaymentMethod),
 		ShipmentCarrierId: int(commonOrder.ShipmentCarrierId),
 		PromoCode:         commonOrder.PromoCode,
+		GiftCardCode:      strings.TrimSpace(commonOrder.GiftCardCode),
+		Currency:          strings.ToUpper(commonOrder.Currency),
 	}, commonOrder.Buyer.ReceivePromoEmails
 }
 
-// convertAddress converts a common.AddressInsert to an entity.AddressInsert.
-func convertAddress(commonAddress *pb_common.AddressInsert) *entity.AddressInsert {
+// ConvertPbBuyerInsertToEntity converts the buyer contact details, the promo subscription is handled separately.
+func ConvertPbBuyerInsertToEntity(b *pb_common.BuyerInsert) *entity.BuyerInsert {
+	if b == nil {
+		return nil
+	}
+	return &entity.BuyerInsert{
+		FirstName: b.FirstName,
+		LastName:  b.LastName,
+		Email:     b.Email,
+		Phone:     b.Phone,
+	}
+}
+
+// ConvertPbAddressInsertToEntity converts a common.AddressInsert to an entity.AddressInsert.
+func ConvertPbAddressInsertToEntity(commonAddress *pb_common.AddressInsert) *entity.AddressInsert {
 	if commonAddress == nil {
 		return nil
 	}
@@ -118,12 +127,15 @@ func convertAddress(commonAddress *pb_common.AddressInsert) *entity.AddressInser
 
 func ConvertEntityOrderToPbCommonOrder(eOrder entity.Order) (*pb_common.Order, error) {
 	pbOrder := &pb_common.Order{
-		Id:            int32(eOrder.Id),
-		Uuid:          eOrder.UUID,
-		Placed:        timestamppb.New(eOrder.Placed),
-		Modified:      timestamppb.New(eOrder.Modified),
-		TotalPrice:    &pb_decimal.Decimal{Value: eOrder.TotalPriceDecimal().String()},
-		OrderStatusId: int32(eOrder.OrderStatusId),
+		Id:             int32(eOrder.Id),
+		Uuid:           eOrder.UUID,
+		Placed:         timestamppb.New(eOrder.Placed),
+		Modified:       timestamppb.New(eOrder.Modified),
+		TotalPrice:     &pb_decimal.Decimal{Value: eOrder.TotalPriceDecimal().String()},
+		OrderStatusId:  int32(eOrder.OrderStatusId),
+		GiftCardAmount: &pb_decimal.Decimal{Value: eOrder.GiftCardAmount.Round(2).String()},
+		TaxAmount:      &pb_decimal.Decimal{Value: eOrder.TaxAmount.Round(2).String()},
+		TaxInclusive:   eOrder.TaxInclusive,
 	}
 
 	if eOrder.PromoId.Valid {
@@ -174,6 +186,8 @@ func ConvertEntityOrderItemToPb(orderItem *entity.OrderItem) *pb_common.OrderIte
 		ProductBrand:          orderItem.ProductBrand,
 		Sku:                   orderItem.SKU,
 		OrderItem:             ConvertEntityOrderItemInsertToPb(&orderItem.OrderItemInsert),
+		TaxRate:               orderItem.TaxRate.String(),
+		TaxAmount:             orderItem.TaxAmount.String(),
 	}
 }
 
@@ -202,6 +216,11 @@ func ConvertEntityOrderFullToPbOrderFull(e *entity.OrderFull) (*pb_common.OrderF
 		return nil, fmt.Errorf("error converting shipment: %w", err)
 	}
 
+	pbShipments, err := ConvertEntityShipmentsFullToPb(e.Shipments)
+	if err != nil {
+		return nil, fmt.Errorf("error converting shipments: %w", err)
+	}
+
 	pbPromoCode := ConvertEntityPromoToPb(e.PromoCode)
 
 	pbBuyer, err := ConvertEntityBuyerToPbBuyer(e.Buyer)
@@ -219,17 +238,116 @@ func ConvertEntityOrderFullToPbOrderFull(e *entity.OrderFull) (*pb_common.OrderF
 	}
 
 	return &pb_common.OrderFull{
-		Order:      pbOrder,
-		OrderItems: pbOrderItems,
-		Payment:    pbPayment,
-		Shipment:   pbShipment,
-		PromoCode:  pbPromoCode,
-		Buyer:      pbBuyer,
-		Billing:    pbBilling,
-		Shipping:   pbShipping,
+		Order:            pbOrder,
+		OrderItems:       pbOrderItems,
+		Payment:          pbPayment,
+		Shipment:         pbShipment,
+		PromoCode:        pbPromoCode,
+		Buyer:            pbBuyer,
+		Billing:          pbBilling,
+		Shipping:         pbShipping,
+		StatusHistory:    ConvertEntityOrderStatusHistoryToPb(e.StatusHistory),
+		Shipments:        pbShipments,
+		InvoiceDocuments: ConvertEntityOrderInvoiceToPbDocuments(e.Invoice),
 	}, nil
 }
 
+// ConvertEntityOrderInvoiceToPbDocuments converts the invoice of the order and its corrections to the documents
+// the buyer and the admins download, the access key of the invoice is not included
+func ConvertEntityOrderInvoiceToPbDocuments(inv *entity.OrderInvoice) []*pb_common.InvoiceDocument {
+	if inv == nil {
+		return nil
+	}
+	docs := make([]*pb_common.InvoiceDocument, 0, len(inv.Corrections)+1)
+	docs = append(docs, &pb_common.InvoiceDocument{
+		InvoiceNumber: int32(inv.InvoiceNumber),
+		IssuedAt:      timestamppb.New(inv.IssuedAt),
+	})
+	for _, c := range inv.Corrections {
+		docs = append(docs, &pb_common.InvoiceDocument{
+			InvoiceNumber: int32(c.InvoiceNumber),
+			IssuedAt:      timestamppb.New(c.IssuedAt),
+			Correction:    true,
+		})
+	}
+	return docs
+}
+
+// ConvertEntityOrderAdjustmentsToPb converts the price differences of the edited order
+func ConvertEntityOrderAdjustmentsToPb(adjustments []entity.OrderAdjustment) []*pb_common.OrderAdjustment {
+	pbAdjustments := make([]*pb_common.OrderAdjustment, 0, len(adjustments))
+	for _, a := range adjustments {
+		pbAdjustments = append(pbAdjustments, ConvertEntityOrderAdjustmentToPb(&a))
+	}
+	return pbAdjustments
+}
+
+func ConvertEntityOrderAdjustmentToPb(a *entity.OrderAdjustment) *pb_common.OrderAdjustment {
+	return &pb_common.OrderAdjustment{
+		Id:          int32(a.Id),
+		Amount:      &pb_decimal.Decimal{Value: a.Amount.Round(2).String()},
+		TotalBefore: &pb_decimal.Decimal{Value: a.TotalBefore.Round(2).String()},
+		TotalAfter:  &pb_decimal.Decimal{Value: a.TotalAfter.Round(2).String()},
+		Actor:       a.Actor,
+		CreatedAt:   timestamppb.New(a.CreatedAt),
+	}
+}
+
+// ConvertEntityCheckoutReminderReportToPb converts the conversion of the checkout reminders
+func ConvertEntityCheckoutReminderReportToPb(r *entity.CheckoutReminderReport) *pb_common.CheckoutReminderReport {
+	return &pb_common.CheckoutReminderReport{
+		From:             timestamppb.New(r.From),
+		To:               timestamppb.New(r.To),
+		Sent:             int32(r.Sent),
+		SentExpired:      int32(r.SentExpired),
+		Recovered:        int32(r.Recovered),
+		RecoveredExpired: int32(r.RecoveredExpired),
+		RecoveredRevenue: &pb_decimal.Decimal{Value: r.RecoveredRevenue.Round(2).String()},
+		ConversionRate:   &pb_decimal.Decimal{Value: r.ConversionRate().String()},
+	}
+}
+
+// ConvertEntityOrderNotesToPb converts the internal notes of the order
+func ConvertEntityOrderNotesToPb(notes []entity.OrderNote) []*pb_common.OrderNote {
+	pbNotes := make([]*pb_common.OrderNote, 0, len(notes))
+	for _, n := range notes {
+		pbNotes = append(pbNotes, ConvertEntityOrderNoteToPb(&n))
+	}
+	return pbNotes
+}
+
+func ConvertEntityOrderNoteToPb(n *entity.OrderNote) *pb_common.OrderNote {
+	return &pb_common.OrderNote{
+		Id:        int32(n.Id),
+		Author:    n.Author,
+		Text:      n.Text,
+		CreatedAt: timestamppb.New(n.CreatedAt),
+	}
+}
+
+// ConvertEntityOrderStatusHistoryToPb converts the order status history to the status changes
+func ConvertEntityOrderStatusHistoryToPb(history []entity.OrderStatusHistory) []*pb_common.OrderStatusChange {
+	pbHistory := make([]*pb_common.OrderStatusChange, 0, len(history))
+	for _, h := range history {
+		sc := &pb_common.OrderStatusChange{
+			Id:        int32(h.Id),
+			Actor:     h.Actor,
+			Reason:    h.Reason.String,
+			CreatedAt: timestamppb.New(h.CreatedAt),
+		}
+		if h.FromStatusId.Valid {
+			if os, ok := cache.GetOrderStatusById(int(h.FromStatusId.Int32)); ok {
+				sc.FromStatus = os.PB
+			}
+		}
+		if os, ok := cache.GetOrderStatusById(h.ToStatusId); ok {
+			sc.ToStatus = os.PB
+		}
+		pbHistory = append(pbHistory, sc)
+	}
+	return pbHistory
+}
+
 // ConvertEntityOrderItemsToPbOrderItems converts a slice of entity.OrderItem to a slice of pb_common.OrderItem
 func ConvertEntityOrderItemsToPbOrderItems(items []entity.OrderItem) ([]*pb_common.OrderItem, error) {
 
@@ -282,6 +400,55 @@ func ConvertEntityShipmentToPbShipment(s entity.Shipment) (*pb_common.Shipment,
 	}, nil
 }
 
+// ConvertEntityShipmentsFullToPb converts the order shipments with their items
+func ConvertEntityShipmentsFullToPb(sfs []entity.ShipmentFull) ([]*pb_common.ShipmentFull, error) {
+	pbShipments := make([]*pb_common.ShipmentFull, 0, len(sfs))
+	for _, sf := range sfs {
+		pbSf, err := ConvertEntityShipmentFullToPb(&sf.Shipment, sf.Items)
+		if err != nil {
+			return nil, err
+		}
+		pbShipments = append(pbShipments, pbSf)
+	}
+	return pbShipments, nil
+}
+
+func ConvertEntityShipmentFullToPb(s *entity.Shipment, items []entity.ShipmentItem) (*pb_common.ShipmentFull, error) {
+	if s == nil {
+		return nil, fmt.Errorf("empty entity.Shipment")
+	}
+	pbShipment, err := ConvertEntityShipmentToPbShipment(*s)
+	if err != nil {
+		return nil, err
+	}
+
+	pbItems := make([]*pb_common.ShipmentItem, 0, len(items))
+	for _, si := range items {
+		pbItems = append(pbItems, &pb_common.ShipmentItem{
+			OrderItemId: int32(si.OrderItemId),
+			Quantity:    int32(si.Quantity),
+		})
+	}
+
+	return &pb_common.ShipmentFull{
+		Id:       int32(s.Id),
+		Shipment: pbShipment,
+		Items:    pbItems,
+	}, nil
+}
+
+// ConvertPbShipmentItemsToEntity converts the order items to ship
+func ConvertPbShipmentItemsToEntity(items []*pb_common.ShipmentItem) []entity.ShipmentItemInsert {
+	sis := make([]entity.ShipmentItemInsert, 0, len(items))
+	for _, si := range items {
+		sis = append(sis, entity.ShipmentItemInsert{
+			OrderItemId: int(si.OrderItemId),
+			Quantity:    int(si.Quantity),
+		})
+	}
+	return sis
+}
+
 func ConvertEntityShipmentCarrierToPbShipmentCarrier(s *entity.ShipmentCarrier) (*pb_common.ShipmentCarrier, error) {
 	if s == nil {
 		return nil, fmt.Errorf("empty entity.ShipmentCarrier")
@@ -51,7 +51,7 @@ func ConvertEntityToPbPaymentInsert(p *entity.PaymentInsert) (*pb_common.Payment
 		return nil, fmt.Errorf("payment method not found")
 	}
 
-	return &pb_common.PaymentInsert{
+	pbPi := &pb_common.PaymentInsert{
 		PaymentMethod:                    pm.PB,
 		TransactionId:                    p.TransactionID.String,
 		TransactionAmount:                &pb_decimal.Decimal{Value: p.TransactionAmount.String()},
@@ -60,24 +60,134 @@ func ConvertEntityToPbPaymentInsert(p *entity.PaymentInsert) (*pb_common.Payment
 		Payee:                            p.Payee.String,
 		ClientSecret:                     p.ClientSecret.String,
 		IsTransactionDone:                p.IsTransactionDone,
-	}, nil
+		PaymentCurrency:                  p.PaymentCurrency.String,
+	}
+	if p.PaymentCurrencyRate.Valid {
+		pbPi.PaymentCurrencyRate = &pb_decimal.Decimal{Value: p.PaymentCurrencyRate.Decimal.String()}
+	}
+	if p.QuotedAt.Valid {
+		pbPi.QuoteSourceCurrency = p.QuoteSourceCurrency.String
+		pbPi.QuotedAt = timestamppb.New(p.QuotedAt.Time)
+		pbPi.QuoteExpiresAt = timestamppb.New(p.QuoteExpiresAt.Time)
+	}
+	return pbPi, nil
+}
+
+var refundStatusEntityPbMap = map[entity.RefundStatus]pb_common.RefundStatusEnum{
+	entity.RefundPending:   pb_common.RefundStatusEnum_REFUND_STATUS_ENUM_PENDING,
+	entity.RefundSucceeded: pb_common.RefundStatusEnum_REFUND_STATUS_ENUM_SUCCEEDED,
+	entity.RefundFailed:    pb_common.RefundStatusEnum_REFUND_STATUS_ENUM_FAILED,
+}
+
+func ConvertEntityRefundToPbRefund(r *entity.Refund) *pb_common.Refund {
+	return &pb_common.Refund{
+		Id:         int32(r.Id),
+		CreatedAt:  timestamppb.New(r.CreatedAt),
+		ModifiedAt: timestamppb.New(r.ModifiedAt),
+		RefundInsert: &pb_common.RefundInsert{
+			PaymentId:        int32(r.PaymentId),
+			Amount:           &pb_decimal.Decimal{Value: r.Amount.String()},
+			Status:           refundStatusEntityPbMap[r.Status],
+			ProviderRefundId: r.ProviderRefundId.String,
+			Payee:            r.Payee.String,
+			Reason:           r.Reason.String,
+			Restocked:        r.Restocked,
+			GiftCardAmount:   &pb_decimal.Decimal{Value: r.GiftCardAmount.String()},
+		},
+	}
+}
+
+func ConvertPbRefundItemsToEntity(items []*pb_common.RefundItem) []entity.RefundItemInsert {
+	ris := make([]entity.RefundItemInsert, 0, len(items))
+	for _, i := range items {
+		ris = append(ris, entity.RefundItemInsert{
+			OrderItemId: int(i.OrderItemId),
+			Quantity:    int(i.Quantity),
+		})
+	}
+	return ris
+}
+
+var paymentEventTypeEntityPbMap = map[entity.PaymentEventType]pb_common.PaymentEventTypeEnum{
+	entity.PaymentEventInvoiceIssued:       pb_common.PaymentEventTypeEnum_PAYMENT_EVENT_TYPE_ENUM_INVOICE_ISSUED,
+	entity.PaymentEventAddressAssigned:     pb_common.PaymentEventTypeEnum_PAYMENT_EVENT_TYPE_ENUM_ADDRESS_ASSIGNED,
+	entity.PaymentEventClientSecretCreated: pb_common.PaymentEventTypeEnum_PAYMENT_EVENT_TYPE_ENUM_CLIENT_SECRET_CREATED,
+	entity.PaymentEventTransactionSeen:     pb_common.PaymentEventTypeEnum_PAYMENT_EVENT_TYPE_ENUM_TRANSACTION_SEEN,
+	entity.PaymentEventConfirmed:           pb_common.PaymentEventTypeEnum_PAYMENT_EVENT_TYPE_ENUM_CONFIRMED,
+	entity.PaymentEventExpired:             pb_common.PaymentEventTypeEnum_PAYMENT_EVENT_TYPE_ENUM_EXPIRED,
+	entity.PaymentEventRefunded:            pb_common.PaymentEventTypeEnum_PAYMENT_EVENT_TYPE_ENUM_REFUNDED,
+}
+
+func ConvertEntityPaymentEventsToPb(events []entity.PaymentEvent) []*pb_common.PaymentEvent {
+	pbEvents := make([]*pb_common.PaymentEvent, 0, len(events))
+	for _, e := range events {
+		pbEvent := &pb_common.PaymentEvent{
+			Id:            int32(e.Id),
+			PaymentId:     int32(e.PaymentId),
+			Type:          paymentEventTypeEntityPbMap[e.Type],
+			TransactionId: e.TransactionId.String,
+			Payload:       e.Payload.String,
+			CreatedAt:     timestamppb.New(e.CreatedAt),
+		}
+		if pm, ok := cache.GetPaymentMethodById(e.PaymentMethodId); ok {
+			pbEvent.PaymentMethod = paymentMethodEntityPbMap[pm.Method.Name]
+		}
+		pbEvents = append(pbEvents, pbEvent)
+	}
+	return pbEvents
+}
+
+var reconciliationMismatchTypeEntityPbMap = map[entity.ReconciliationMismatchType]pb_common.ReconciliationMismatchTypeEnum{
+	entity.MismatchPaidNotConfirmed:            pb_common.ReconciliationMismatchTypeEnum_RECONCILIATION_MISMATCH_TYPE_ENUM_PAID_NOT_CONFIRMED,
+	entity.MismatchConfirmedWithoutTransaction: pb_common.ReconciliationMismatchTypeEnum_RECONCILIATION_MISMATCH_TYPE_ENUM_CONFIRMED_WITHOUT_TRANSACTION,
+	entity.MismatchAmount:                      pb_common.ReconciliationMismatchTypeEnum_RECONCILIATION_MISMATCH_TYPE_ENUM_AMOUNT_MISMATCH,
+	entity.MismatchOrphanTransfer:              pb_common.ReconciliationMismatchTypeEnum_RECONCILIATION_MISMATCH_TYPE_ENUM_ORPHAN_TRANSFER,
+}
+
+func ConvertEntityReconciliationReportToPb(r *entity.ReconciliationReport) *pb_common.ReconciliationReport {
+	mms := make([]*pb_common.ReconciliationMismatch, 0, len(r.Mismatches))
+	for _, m := range r.Mismatches {
+		mms = append(mms, &pb_common.ReconciliationMismatch{
+			Type:          reconciliationMismatchTypeEntityPbMap[m.Type],
+			OrderUuid:     m.OrderUUID,
+			PaymentMethod: paymentMethodEntityPbMap[m.PaymentMethodName],
+			TransactionId: m.TransactionId,
+			Expected:      &pb_decimal.Decimal{Value: m.Expected.String()},
+			Actual:        &pb_decimal.Decimal{Value: m.Actual.String()},
+			Details:       m.Details,
+		})
+	}
+	return &pb_common.ReconciliationReport{
+		From:            timestamppb.New(r.From),
+		CreatedAt:       timestamppb.New(r.CreatedAt),
+		PaymentsChecked: int32(r.PaymentsChecked),
+		Mismatches:      mms,
+	}
 }
 
 // TODO:
 var paymentMethodToCurrency = map[pb_common.PaymentMethodNameEnum]string{
-	pb_common.PaymentMethodNameEnum_PAYMENT_METHOD_NAME_ENUM_CARD:        "EUR",
-	pb_common.PaymentMethodNameEnum_PAYMENT_METHOD_NAME_ENUM_ETH:         "ETH",
-	pb_common.PaymentMethodNameEnum_PAYMENT_METHOD_NAME_ENUM_USDT_TRON:   "USDT",
-	pb_common.PaymentMethodNameEnum_PAYMENT_METHOD_NAME_ENUM_USDT_SHASTA: "USDT_TESTNET",
+	pb_common.PaymentMethodNameEnum_PAYMENT_METHOD_NAME_ENUM_CARD:         "EUR",
+	pb_common.PaymentMethodNameEnum_PAYMENT_METHOD_NAME_ENUM_ETH:          "ETH",
+	pb_common.PaymentMethodNameEnum_PAYMENT_METHOD_NAME_ENUM_USDT_TRON:    "USDT",
+	pb_common.PaymentMethodNameEnum_PAYMENT_METHOD_NAME_ENUM_USDT_SHASTA:  "USDT_TESTNET",
+	pb_common.PaymentMethodNameEnum_PAYMENT_METHOD_NAME_ENUM_USDT_ETH:     "USDT",
+	pb_common.PaymentMethodNameEnum_PAYMENT_METHOD_NAME_ENUM_USDT_SEPOLIA: "USDT_TESTNET",
+	pb_common.PaymentMethodNameEnum_PAYMENT_METHOD_NAME_ENUM_USDC_ETH:     "USDC",
+	pb_common.PaymentMethodNameEnum_PAYMENT_METHOD_NAME_ENUM_USDC_SEPOLIA: "USDC_TESTNET",
 }
 
 var pbPaymentMethodToEntity = map[pb_common.PaymentMethodNameEnum]entity.PaymentMethodName{
-	pb_common.PaymentMethodNameEnum_PAYMENT_METHOD_NAME_ENUM_CARD:        entity.CARD,
-	pb_common.PaymentMethodNameEnum_PAYMENT_METHOD_NAME_ENUM_CARD_TEST:   entity.CARD_TEST,
-	pb_common.PaymentMethodNameEnum_PAYMENT_METHOD_NAME_ENUM_ETH:         entity.ETH,
-	pb_common.PaymentMethodNameEnum_PAYMENT_METHOD_NAME_ENUM_ETH_TEST:    entity.ETH_TEST,
-	pb_common.PaymentMethodNameEnum_PAYMENT_METHOD_NAME_ENUM_USDT_TRON:   entity.USDT_TRON,
-	pb_common.PaymentMethodNameEnum_PAYMENT_METHOD_NAME_ENUM_USDT_SHASTA: entity.USDT_TRON_TEST,
+	pb_common.PaymentMethodNameEnum_PAYMENT_METHOD_NAME_ENUM_CARD:         entity.CARD,
+	pb_common.PaymentMethodNameEnum_PAYMENT_METHOD_NAME_ENUM_CARD_TEST:    entity.CARD_TEST,
+	pb_common.PaymentMethodNameEnum_PAYMENT_METHOD_NAME_ENUM_ETH:          entity.ETH,
+	pb_common.PaymentMethodNameEnum_PAYMENT_METHOD_NAME_ENUM_ETH_TEST:     entity.ETH_TEST,
+	pb_common.PaymentMethodNameEnum_PAYMENT_METHOD_NAME_ENUM_USDT_TRON:    entity.USDT_TRON,
+	pb_common.PaymentMethodNameEnum_PAYMENT_METHOD_NAME_ENUM_USDT_SHASTA:  entity.USDT_TRON_TEST,
+	pb_common.PaymentMethodNameEnum_PAYMENT_METHOD_NAME_ENUM_USDT_ETH:     entity.USDT_ETH,
+	pb_common.PaymentMethodNameEnum_PAYMENT_METHOD_NAME_ENUM_USDT_SEPOLIA: entity.USDT_ETH_TEST,
+	pb_common.PaymentMethodNameEnum_PAYMENT_METHOD_NAME_ENUM_USDC_ETH:     entity.USDC_ETH,
+	pb_common.PaymentMethodNameEnum_PAYMENT_METHOD_NAME_ENUM_USDC_SEPOLIA: entity.USDC_ETH_TEST,
 }
 
 func ConvertPaymentMethodToCurrency(pbPaymentMethod pb_common.PaymentMethodNameEnum) string {
@@ -283,6 +283,9 @@ func convertEntitySizesToPbSizes(sizes []entity.ProductSize) []*pb_common.Produc
 			},
 			ProductId: int32(size.ProductId),
 			SizeId:    int32(size.SizeId),
+			Available: &pb_decimal.Decimal{
+				Value: size.AvailableDecimal().String(),
+			},
 		})
 	}
 	return pbSizes
@@ -62,3 +62,18 @@ func VerifyCurrencyTicker(ticker string) (CurrencyTicker, bool) {
 		return "", false
 	}
 }
+
+// IsFiatCurrency reports whether the card payments can be charged in the currency.
+func IsFiatCurrency(ct CurrencyTicker) bool {
+	return ct != BTC && ct != ETH
+}
+
+// CurrencyMinorUnits is the number of decimal places of the smallest currency unit the card is charged in.
+func CurrencyMinorUnits(ct CurrencyTicker) int32 {
+	switch ct {
+	case JPY:
+		return 0
+	default:
+		return 2
+	}
+}
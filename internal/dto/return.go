@@ -0,0 +1,144 @@
+package dto
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/jekabolt/grbpwr-manager/internal/entity"
+	pb_common "github.com/jekabolt/grbpwr-manager/proto/gen/common"
+	pb_decimal "google.golang.org/genproto/googleapis/type/decimal"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var (
+	returnRequestStatusEntityPbMap = map[entity.ReturnRequestStatus]pb_common.ReturnRequestStatusEnum{
+		entity.ReturnRequested: pb_common.ReturnRequestStatusEnum_RETURN_REQUEST_STATUS_ENUM_REQUESTED,
+		entity.ReturnApproved:  pb_common.ReturnRequestStatusEnum_RETURN_REQUEST_STATUS_ENUM_APPROVED,
+		entity.ReturnRejected:  pb_common.ReturnRequestStatusEnum_RETURN_REQUEST_STATUS_ENUM_REJECTED,
+		entity.ReturnReceived:  pb_common.ReturnRequestStatusEnum_RETURN_REQUEST_STATUS_ENUM_RECEIVED,
+		entity.ReturnRefunded:  pb_common.ReturnRequestStatusEnum_RETURN_REQUEST_STATUS_ENUM_REFUNDED,
+		entity.ReturnExchanged: pb_common.ReturnRequestStatusEnum_RETURN_REQUEST_STATUS_ENUM_EXCHANGED,
+		entity.ReturnCancelled: pb_common.ReturnRequestStatusEnum_RETURN_REQUEST_STATUS_ENUM_CANCELLED,
+	}
+
+	returnRequestStatusPbEntityMap = map[pb_common.ReturnRequestStatusEnum]entity.ReturnRequestStatus{
+		pb_common.ReturnRequestStatusEnum_RETURN_REQUEST_STATUS_ENUM_REQUESTED: entity.ReturnRequested,
+		pb_common.ReturnRequestStatusEnum_RETURN_REQUEST_STATUS_ENUM_APPROVED:  entity.ReturnApproved,
+		pb_common.ReturnRequestStatusEnum_RETURN_REQUEST_STATUS_ENUM_REJECTED:  entity.ReturnRejected,
+		pb_common.ReturnRequestStatusEnum_RETURN_REQUEST_STATUS_ENUM_RECEIVED:  entity.ReturnReceived,
+		pb_common.ReturnRequestStatusEnum_RETURN_REQUEST_STATUS_ENUM_REFUNDED:  entity.ReturnRefunded,
+		pb_common.ReturnRequestStatusEnum_RETURN_REQUEST_STATUS_ENUM_EXCHANGED: entity.ReturnExchanged,
+		pb_common.ReturnRequestStatusEnum_RETURN_REQUEST_STATUS_ENUM_CANCELLED: entity.ReturnCancelled,
+	}
+
+	returnRequestTypeEntityPbMap = map[entity.ReturnRequestType]pb_common.ReturnRequestTypeEnum{
+		entity.ReturnTypeReturn:   pb_common.ReturnRequestTypeEnum_RETURN_REQUEST_TYPE_ENUM_RETURN,
+		entity.ReturnTypeExchange: pb_common.ReturnRequestTypeEnum_RETURN_REQUEST_TYPE_ENUM_EXCHANGE,
+	}
+
+	returnRequestTypePbEntityMap = map[pb_common.ReturnRequestTypeEnum]entity.ReturnRequestType{
+		pb_common.ReturnRequestTypeEnum_RETURN_REQUEST_TYPE_ENUM_RETURN:   entity.ReturnTypeReturn,
+		pb_common.ReturnRequestTypeEnum_RETURN_REQUEST_TYPE_ENUM_EXCHANGE: entity.ReturnTypeExchange,
+	}
+
+	returnReasonEntityPbMap = map[entity.ReturnReason]pb_common.ReturnReasonEnum{
+		entity.ReturnReasonWrongSize:      pb_common.ReturnReasonEnum_RETURN_REASON_ENUM_WRONG_SIZE,
+		entity.ReturnReasonDamaged:        pb_common.ReturnReasonEnum_RETURN_REASON_ENUM_DAMAGED,
+		entity.ReturnReasonNotAsDescribed: pb_common.ReturnReasonEnum_RETURN_REASON_ENUM_NOT_AS_DESCRIBED,
+		entity.ReturnReasonChangedMind:    pb_common.ReturnReasonEnum_RETURN_REASON_ENUM_CHANGED_MIND,
+		entity.ReturnReasonOther:          pb_common.ReturnReasonEnum_RETURN_REASON_ENUM_OTHER,
+	}
+
+	returnReasonPbEntityMap = map[pb_common.ReturnReasonEnum]entity.ReturnReason{
+		pb_common.ReturnReasonEnum_RETURN_REASON_ENUM_WRONG_SIZE:       entity.ReturnReasonWrongSize,
+		pb_common.ReturnReasonEnum_RETURN_REASON_ENUM_DAMAGED:          entity.ReturnReasonDamaged,
+		pb_common.ReturnReasonEnum_RETURN_REASON_ENUM_NOT_AS_DESCRIBED: entity.ReturnReasonNotAsDescribed,
+		pb_common.ReturnReasonEnum_RETURN_REASON_ENUM_CHANGED_MIND:     entity.ReturnReasonChangedMind,
+		pb_common.ReturnReasonEnum_RETURN_REASON_ENUM_OTHER:            entity.ReturnReasonOther,
+	}
+)
+
+// ConvertPbReturnRequestStatusToEntity converts the status filter, empty status matches all requests.
+func ConvertPbReturnRequestStatusToEntity(st pb_common.ReturnRequestStatusEnum) entity.ReturnRequestStatus {
+	return returnRequestStatusPbEntityMap[st]
+}
+
+func ConvertPbCreateReturnRequestToEntity(orderUUID string, email string, t pb_common.ReturnRequestTypeEnum, comment string, items []*pb_common.ReturnItemInsert) (*entity.ReturnRequestInsert, error) {
+	rt, ok := returnRequestTypePbEntityMap[t]
+	if !ok {
+		return nil, fmt.Errorf("invalid return request type: %s", t.String())
+	}
+
+	rii := make([]entity.ReturnItemInsert, 0, len(items))
+	for _, i := range items {
+		reason, ok := returnReasonPbEntityMap[i.Reason]
+		if !ok {
+			return nil, fmt.Errorf("invalid return reason: %s", i.Reason.String())
+		}
+		rii = append(rii, entity.ReturnItemInsert{
+			OrderItemId:    int(i.OrderItemId),
+			Quantity:       int(i.Quantity),
+			Reason:         reason,
+			ExchangeSizeId: sql.NullInt32{Int32: i.ExchangeSizeId, Valid: i.ExchangeSizeId != 0},
+		})
+	}
+
+	return &entity.ReturnRequestInsert{
+		OrderUUID: orderUUID,
+		Email:     email,
+		Type:      rt,
+		Comment:   comment,
+		Items:     rii,
+	}, nil
+}
+
+func ConvertEntityReturnRequestToPb(rr entity.ReturnRequest) *pb_common.ReturnRequest {
+	return &pb_common.ReturnRequest{
+		Id:         int32(rr.Id),
+		Uuid:       rr.UUID,
+		OrderUuid:  rr.OrderUUID,
+		Type:       returnRequestTypeEntityPbMap[rr.Type],
+		Status:     returnRequestStatusEntityPbMap[rr.Status],
+		Comment:    rr.Comment.String,
+		RefundId:   rr.RefundId.Int32,
+		CreatedAt:  timestamppb.New(rr.CreatedAt),
+		ModifiedAt: timestamppb.New(rr.ModifiedAt),
+	}
+}
+
+func ConvertEntityReturnRequestFullToPb(rrf *entity.ReturnRequestFull) (*pb_common.ReturnRequestFull, error) {
+	if rrf == nil {
+		return nil, fmt.Errorf("empty entity.ReturnRequestFull")
+	}
+
+	items := make([]*pb_common.ReturnItem, 0, len(rrf.Items))
+	for _, i := range rrf.Items {
+		items = append(items, &pb_common.ReturnItem{
+			Id:                   int32(i.Id),
+			ProductId:            int32(i.ProductId),
+			SizeId:               int32(i.SizeId),
+			ProductPriceWithSale: &pb_decimal.Decimal{Value: i.ProductPriceWithSale.Round(2).String()},
+			ReturnItem: &pb_common.ReturnItemInsert{
+				OrderItemId:    int32(i.OrderItemId),
+				Quantity:       int32(i.Quantity),
+				Reason:         returnReasonEntityPbMap[i.Reason],
+				ExchangeSizeId: i.ExchangeSizeId.Int32,
+			},
+		})
+	}
+
+	pbRrf := &pb_common.ReturnRequestFull{
+		ReturnRequest: ConvertEntityReturnRequestToPb(rrf.ReturnRequest),
+		Items:         items,
+	}
+
+	if rrf.ExchangeShipment != nil {
+		s, err := ConvertEntityShipmentToPbShipment(*rrf.ExchangeShipment)
+		if err != nil {
+			return nil, fmt.Errorf("can't convert exchange shipment: %w", err)
+		}
+		pbRrf.ExchangeShipment = s
+	}
+
+	return pbRrf, nil
+}
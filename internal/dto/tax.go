@@ -0,0 +1,57 @@
+package dto
+
+import (
+	"fmt"
+
+	"github.com/jekabolt/grbpwr-manager/internal/entity"
+	pb_common "github.com/jekabolt/grbpwr-manager/proto/gen/common"
+	"github.com/shopspring/decimal"
+	pb_decimal "google.golang.org/genproto/googleapis/type/decimal"
+)
+
+// ConvertPbTaxRuleToEntity converts the tax rule set by the admin, the rates are percentages.
+func ConvertPbTaxRuleToEntity(pbTr *pb_common.TaxRule) (*entity.TaxRule, error) {
+	if pbTr == nil {
+		return nil, fmt.Errorf("tax rule is nil")
+	}
+
+	defaultRate, err := decimal.NewFromString(pbTr.DefaultRate.GetValue())
+	if err != nil {
+		return nil, fmt.Errorf("can't convert default rate to decimal: %w", err)
+	}
+
+	tr := &entity.TaxRule{
+		Country:       entity.NormalizeTaxCountry(pbTr.Country),
+		Inclusive:     pbTr.Inclusive,
+		DefaultRate:   defaultRate,
+		CategoryRates: make([]entity.TaxCategoryRate, 0, len(pbTr.CategoryRates)),
+	}
+	for _, cr := range pbTr.CategoryRates {
+		rate, err := decimal.NewFromString(cr.Rate.GetValue())
+		if err != nil {
+			return nil, fmt.Errorf("can't convert category rate to decimal: %w", err)
+		}
+		tr.CategoryRates = append(tr.CategoryRates, entity.TaxCategoryRate{
+			Country:    tr.Country,
+			CategoryId: int(cr.CategoryId),
+			Rate:       rate,
+		})
+	}
+	return tr, nil
+}
+
+func ConvertEntityTaxRuleToPb(tr *entity.TaxRule) *pb_common.TaxRule {
+	pbTr := &pb_common.TaxRule{
+		Country:       tr.Country,
+		Inclusive:     tr.Inclusive,
+		DefaultRate:   &pb_decimal.Decimal{Value: tr.DefaultRate.String()},
+		CategoryRates: make([]*pb_common.TaxCategoryRate, 0, len(tr.CategoryRates)),
+	}
+	for _, cr := range tr.CategoryRates {
+		pbTr.CategoryRates = append(pbTr.CategoryRates, &pb_common.TaxCategoryRate{
+			CategoryId: int32(cr.CategoryId),
+			Rate:       &pb_decimal.Decimal{Value: cr.Rate.String()},
+		})
+	}
+	return pbTr
+}
@@ -38,3 +38,27 @@ type MetaData struct {
 type Links struct {
 	Next string `json:"next"`
 }
+
+// TronBlock defines the structure for block response.
+type TronBlock struct {
+	BlockID     string          `json:"blockID"`
+	BlockHeader TronBlockHeader `json:"block_header"`
+}
+
+// TronBlockHeader defines the structure for block header.
+type TronBlockHeader struct {
+	RawData TronBlockRawData `json:"raw_data"`
+}
+
+// TronBlockRawData defines the structure for block header raw data.
+type TronBlockRawData struct {
+	Number    int64 `json:"number"`
+	Timestamp int64 `json:"timestamp"`
+}
+
+// TronTransactionInfo defines the structure for transaction info response.
+type TronTransactionInfo struct {
+	ID             string `json:"id"`
+	BlockNumber    int64  `json:"blockNumber"`
+	BlockTimeStamp int64  `json:"blockTimeStamp"`
+}
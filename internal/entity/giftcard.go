@@ -0,0 +1,72 @@
+package entity
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// GiftCardTransactionType is the kind of the gift card balance change
+type GiftCardTransactionType string
+
+const (
+	// GiftCardIssued is the initial balance of the issued card
+	GiftCardIssued GiftCardTransactionType = "issued"
+	// GiftCardRedeemed is the balance spent on the order
+	GiftCardRedeemed GiftCardTransactionType = "redeemed"
+	// GiftCardReturned is the balance returned from the cancelled or expired order
+	GiftCardReturned GiftCardTransactionType = "returned"
+)
+
+// GiftCard represents the gift_card table
+type GiftCard struct {
+	Id         int             `db:"id"`
+	Balance    decimal.Decimal `db:"balance"`
+	CreatedAt  time.Time       `db:"created_at"`
+	ModifiedAt time.Time       `db:"modified_at"`
+	GiftCardInsert
+}
+
+// GiftCardInsert is the gift card issued by the admin, the code is generated if empty
+// and the card never expires if the expiration is not set.
+type GiftCardInsert struct {
+	Code           string          `db:"code"`
+	InitialBalance decimal.Decimal `db:"initial_balance"`
+	Expiration     sql.NullTime    `db:"expiration"`
+	Allowed        bool            `db:"allowed"`
+}
+
+// IsExpired reports whether the card expired at the given time.
+func (gc *GiftCard) IsExpired(now time.Time) bool {
+	return gc.Expiration.Valid && !gc.Expiration.Time.After(now)
+}
+
+// IsRedeemable reports whether the card can be spent at the given time.
+func (gc *GiftCard) IsRedeemable(now time.Time) bool {
+	return gc.Allowed && !gc.IsExpired(now) && gc.Balance.IsPositive()
+}
+
+// AvailableBalance is the balance which can be spent at the given time.
+func (gc *GiftCard) AvailableBalance(now time.Time) decimal.Decimal {
+	if !gc.Allowed || gc.IsExpired(now) {
+		return decimal.Zero
+	}
+	return gc.Balance.Round(2)
+}
+
+// GiftCardTransaction represents the gift_card_transaction table
+type GiftCardTransaction struct {
+	Id           int                     `db:"id"`
+	GiftCardId   int                     `db:"gift_card_id"`
+	OrderId      sql.NullInt32           `db:"order_id"`
+	Type         GiftCardTransactionType `db:"type"`
+	Amount       decimal.Decimal         `db:"amount"`
+	BalanceAfter decimal.Decimal         `db:"balance_after"`
+	CreatedAt    time.Time               `db:"created_at"`
+}
+
+type GiftCardFull struct {
+	GiftCard     GiftCard
+	Transactions []GiftCardTransaction
+}
@@ -2,6 +2,9 @@ package entity
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
 	"time"
 
 	"github.com/shopspring/decimal"
@@ -15,6 +18,10 @@ type OrderNew struct {
 	PaymentMethod     PaymentMethodName `valid:"required"`
 	ShipmentCarrierId int               `valid:"required"`
 	PromoCode         string            `valid:"-"`
+	// GiftCardCode is the gift card covering the order total or its part
+	GiftCardCode string `valid:"-"`
+	// Currency is the presentment currency of the card payment, the base currency if empty
+	Currency string `valid:"-"`
 }
 
 type OrderFull struct {
@@ -26,6 +33,16 @@ type OrderFull struct {
 	Buyer      Buyer
 	Billing    Address
 	Shipping   Address
+	// StatusHistory is the status changes of the order from the oldest one
+	StatusHistory []OrderStatusHistory
+	// Notes are the internal notes of the admins, they are not shown to the buyer
+	Notes []OrderNote
+	// Adjustments are the price differences of the order items edited after the payment
+	Adjustments []OrderAdjustment
+	// Shipments are the shipments of the order items from the oldest one, the exchange shipments are not included
+	Shipments []ShipmentFull
+	// Invoice is nil until the order is confirmed
+	Invoice *OrderInvoice
 }
 
 // Orders represents the orders table
@@ -37,12 +54,27 @@ type Order struct {
 	TotalPrice    decimal.Decimal `db:"total_price"`
 	OrderStatusId int             `db:"order_status_id"`
 	PromoId       sql.NullInt32   `db:"promo_id"`
+	// GiftCardAmount is the part of the total paid with the gift card
+	GiftCardId     sql.NullInt32   `db:"gift_card_id"`
+	GiftCardAmount decimal.Decimal `db:"gift_card_amount"`
+	// TaxAmount is the tax of the order, it is part of the total whether it is inclusive or not
+	TaxAmount    decimal.Decimal `db:"tax_amount"`
+	TaxInclusive bool            `db:"tax_inclusive"`
 }
 
 func (o *Order) TotalPriceDecimal() decimal.Decimal {
 	return o.TotalPrice.Round(2)
 }
 
+// AmountDue is the part of the total which is not covered by the gift card and has to be invoiced.
+func (o *Order) AmountDue() decimal.Decimal {
+	due := o.TotalPrice.Sub(o.GiftCardAmount).Round(2)
+	if due.IsNegative() {
+		return decimal.Zero
+	}
+	return due
+}
+
 type ProductInfoProvider interface {
 	GetProductId() int
 	GetProductPrice() decimal.Decimal
@@ -74,6 +106,9 @@ type OrderItemInsert struct {
 	ProductPriceWithSale  decimal.Decimal `db:"product_price_with_sale"`
 	Quantity              decimal.Decimal `db:"quantity" valid:"required"`
 	SizeId                int             `db:"size_id" valid:"required"`
+	// TaxRate and TaxAmount are computed by the destination country of the order
+	TaxRate   decimal.Decimal `db:"tax_rate" valid:"-"`
+	TaxAmount decimal.Decimal `db:"tax_amount" valid:"-"`
 }
 
 func (oii *OrderItemInsert) ProductPriceWithSaleDecimal() decimal.Decimal {
@@ -164,6 +199,7 @@ const (
 	Delivered       OrderStatusName = "delivered"
 	Cancelled       OrderStatusName = "cancelled"
 	Refunded        OrderStatusName = "refunded"
+	PartiallyPaid   OrderStatusName = "partially_paid"
 )
 
 // ValidOrderStatusNames is a set of valid order status names
@@ -175,6 +211,7 @@ var ValidOrderStatusNames = map[OrderStatusName]bool{
 	Delivered:       true,
 	Cancelled:       true,
 	Refunded:        true,
+	PartiallyPaid:   true,
 }
 
 // OrderStatus represents the order_status table
@@ -183,8 +220,166 @@ type OrderStatus struct {
 	Name OrderStatusName `db:"name"`
 }
 
+// orderStatusTransitions lists the statuses the order can move to from each status.
+// Cancelled orders can be invoiced again, refunded orders are final.
+var orderStatusTransitions = map[OrderStatusName][]OrderStatusName{
+	Placed:          {AwaitingPayment, Confirmed, Cancelled},
+	AwaitingPayment: {PartiallyPaid, Confirmed, Cancelled},
+	PartiallyPaid:   {Confirmed, Cancelled},
+	Confirmed:       {Shipped, Delivered, Refunded},
+	Shipped:         {Delivered, Refunded},
+	Delivered:       {Refunded},
+	Cancelled:       {AwaitingPayment, Confirmed},
+	Refunded:        {},
+}
+
+// OrderStatusTransitionError is returned when the order can't move from its status to the requested one.
+type OrderStatusTransitionError struct {
+	From OrderStatusName
+	To   OrderStatusName
+}
+
+func (e *OrderStatusTransitionError) Error() string {
+	return fmt.Sprintf("order status can't be changed from %s to %s", e.From, e.To)
+}
+
+// OrderNotEditableError is returned when the admin edits the order which is not confirmed or already shipped.
+type OrderNotEditableError struct {
+	Status OrderStatusName
+}
+
+func (e *OrderNotEditableError) Error() string {
+	return fmt.Sprintf("order can be edited only when confirmed and not shipped: order status %s", e.Status)
+}
+
+// ValidateTransition returns OrderStatusTransitionError if the order can't move from osn to the given status.
+func (osn OrderStatusName) ValidateTransition(to OrderStatusName) error {
+	if !slices.Contains(orderStatusTransitions[osn], to) {
+		return &OrderStatusTransitionError{From: osn, To: to}
+	}
+	return nil
+}
+
+// OrderStatusActorSystem is the actor of the status changes made by the payment processors and the schedulers
+const OrderStatusActorSystem = "system"
+
+// ErrOrderNotInvoiced is returned instead of the invoice when the order was updated, cancelled
+// or confirmed on the invoice request, the changes of the order are to be kept.
+var ErrOrderNotInvoiced = errors.New("order is not invoiced")
+
+// OrderStatusHistory represents the order_status_history table
+type OrderStatusHistory struct {
+	Id           int            `db:"id"`
+	OrderId      int            `db:"order_id"`
+	FromStatusId sql.NullInt32  `db:"from_status_id"`
+	ToStatusId   int            `db:"to_status_id"`
+	Actor        string         `db:"actor"`
+	Reason       sql.NullString `db:"reason"`
+	CreatedAt    time.Time      `db:"created_at"`
+}
+
 type OrderBuyerShipment struct {
 	Order    *Order
 	Buyer    *Buyer
 	Shipment *Shipment
+	// Items are the order items sent with the shipment
+	Items []ShipmentItem
+}
+
+// OrderInvoice represents the order_invoice table, the content of the invoice is loaded with the document only
+type OrderInvoice struct {
+	Id            int       `db:"id"`
+	OrderId       int       `db:"order_id"`
+	InvoiceNumber int       `db:"invoice_number"`
+	AccessKey     string    `db:"access_key"`
+	IssuedAt      time.Time `db:"issued_at"`
+	// Corrections are the corrections issued for the edits of the invoiced order from the oldest one
+	Corrections []OrderInvoiceCorrection `db:"-"`
+}
+
+// OrderInvoiceCorrection represents the order_invoice_correction table
+type OrderInvoiceCorrection struct {
+	Id             int       `db:"id"`
+	OrderInvoiceId int       `db:"order_invoice_id"`
+	InvoiceNumber  int       `db:"invoice_number"`
+	IssuedAt       time.Time `db:"issued_at"`
+}
+
+// InvoiceContent is what the invoice document states. It is stored when the document is issued
+// so the later edits of the order don't change the documents already issued.
+type InvoiceContent struct {
+	OrderUUID   string    `json:"orderUuid"`
+	OrderPlaced time.Time `json:"orderPlaced"`
+	BuyerName   string    `json:"buyerName"`
+	BuyerEmail  string    `json:"buyerEmail"`
+	// Billing is the billing address line by line
+	Billing  []string        `json:"billing"`
+	Lines    []InvoiceLine   `json:"lines"`
+	Currency string          `json:"currency"`
+	Subtotal decimal.Decimal `json:"subtotal"`
+	// PromoCode and DiscountPercent are set if the promo discounts the subtotal
+	PromoCode       string          `json:"promoCode"`
+	DiscountPercent decimal.Decimal `json:"discountPercent"`
+	Discount        decimal.Decimal `json:"discount"`
+	Carrier         string          `json:"carrier"`
+	Shipping        decimal.Decimal `json:"shipping"`
+	Tax             decimal.Decimal `json:"tax"`
+	TaxInclusive    bool            `json:"taxInclusive"`
+	Total           decimal.Decimal `json:"total"`
+	GiftCard        decimal.Decimal `json:"giftCard"`
+}
+
+// InvoiceLine is the order item line of the invoice
+type InvoiceLine struct {
+	Name      string          `json:"name"`
+	Size      string          `json:"size"`
+	SKU       string          `json:"sku"`
+	Quantity  decimal.Decimal `json:"quantity"`
+	UnitPrice decimal.Decimal `json:"unitPrice"`
+	Amount    decimal.Decimal `json:"amount"`
+}
+
+// InvoiceDocument is the invoice of the order or its correction as it was issued
+type InvoiceDocument struct {
+	Number   int
+	IssuedAt time.Time
+	Content  InvoiceContent
+	// Corrected is the document the correction supersedes, the invoice or the previous correction, nil for the invoice
+	Corrected *InvoiceDocument
+}
+
+// OrderNote represents the order_note table
+type OrderNote struct {
+	Id        int       `db:"id"`
+	OrderId   int       `db:"order_id"`
+	CreatedAt time.Time `db:"created_at"`
+	OrderNoteInsert
+}
+
+// OrderNoteInsert is the internal note on the order authored by the admin
+type OrderNoteInsert struct {
+	Author string `db:"author"`
+	Text   string `db:"text"`
+}
+
+// OrderAdjustment represents the order_adjustment table
+type OrderAdjustment struct {
+	Id        int       `db:"id"`
+	OrderId   int       `db:"order_id"`
+	CreatedAt time.Time `db:"created_at"`
+	OrderAdjustmentInsert
+}
+
+// OrderAdjustmentInsert is the price difference of the edited order, the amount is positive
+// for the balance due from the buyer and negative for the refund owed to the buyer.
+type OrderAdjustmentInsert struct {
+	Amount      decimal.Decimal `db:"amount"`
+	TotalBefore decimal.Decimal `db:"total_before"`
+	TotalAfter  decimal.Decimal `db:"total_after"`
+	Actor       string          `db:"actor"`
+}
+
+// IsBalanceDue reports whether the buyer has to pay the difference.
+func (oa *OrderAdjustmentInsert) IsBalanceDue() bool {
+	return oa.Amount.IsPositive()
 }
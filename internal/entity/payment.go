@@ -2,6 +2,7 @@ package entity
 
 import (
 	"database/sql"
+	"encoding/json"
 	"time"
 
 	"github.com/shopspring/decimal"
@@ -30,6 +31,154 @@ type PaymentInsert struct {
 	Payee                            sql.NullString  `db:"payee"`
 	ClientSecret                     sql.NullString  `db:"client_secret"`
 	IsTransactionDone                bool            `db:"is_transaction_done"`
+	// PaymentCurrency is the currency the card is charged in, the base currency if empty
+	PaymentCurrency sql.NullString `db:"payment_currency"`
+	// PaymentCurrencyRate is the amount of the payment currency per base currency unit
+	PaymentCurrencyRate decimal.NullDecimal `db:"payment_currency_rate"`
+	// QuoteSourceCurrency is the currency the order total was quoted from
+	QuoteSourceCurrency sql.NullString `db:"quote_source_currency"`
+	// QuotedAt is the time the amount in the payment currency was quoted at
+	QuotedAt sql.NullTime `db:"quoted_at"`
+	// QuoteExpiresAt is the time the unpaid invoice is re-quoted after
+	QuoteExpiresAt sql.NullTime `db:"quote_expires_at"`
+	// StartBlock is the block the transfers to the crypto invoice address are looked up from
+	StartBlock sql.NullInt64 `db:"start_block"`
+}
+
+// PaymentQuote is the invoice amount in the payment currency locked until the quote expires
+type PaymentQuote struct {
+	AmountPaymentCurrency decimal.Decimal
+	PaymentCurrency       string
+	SourceCurrency        string
+	Rate                  decimal.Decimal
+	QuotedAt              time.Time
+	ExpiresAt             time.Time
+}
+
+// PaymentAddress represents the payment_address table,
+// the deposit address derived for the order from the HD wallet
+type PaymentAddress struct {
+	Id        int       `db:"id"`
+	CreatedAt time.Time `db:"created_at"`
+	PaymentAddressInsert
+}
+
+type PaymentAddressInsert struct {
+	PaymentMethodId int    `db:"payment_method_id"`
+	DerivationIndex int    `db:"derivation_index"`
+	Address         string `db:"address"`
+	OrderId         int    `db:"order_id"`
+}
+
+// PaymentOverpayment represents the payment_overpayment table,
+// the amount received above the invoice total which should be refunded manually
+type PaymentOverpayment struct {
+	Id        int       `db:"id"`
+	CreatedAt time.Time `db:"created_at"`
+	PaymentOverpaymentInsert
+}
+
+type PaymentOverpaymentInsert struct {
+	OrderId               int             `db:"order_id"`
+	PaymentMethodId       int             `db:"payment_method_id"`
+	AmountPaymentCurrency decimal.Decimal `db:"amount_payment_currency"`
+	Payer                 sql.NullString  `db:"payer"`
+	TransactionId         sql.NullString  `db:"transaction_id"`
+}
+
+// PaymentExpiration represents the payment_expiration table,
+// the time the unpaid invoice of the order expires at
+type PaymentExpiration struct {
+	Id                int               `db:"id"`
+	OrderId           int               `db:"order_id"`
+	OrderUUID         string            `db:"order_uuid"`
+	PaymentMethodId   int               `db:"payment_method_id"`
+	PaymentMethodName PaymentMethodName `db:"payment_method_name"`
+	ExpireAt          time.Time         `db:"expire_at"`
+	CreatedAt         time.Time         `db:"created_at"`
+}
+
+// PaymentEventType is the kind of the payment change recorded in the payment event log
+type PaymentEventType string
+
+const (
+	PaymentEventInvoiceIssued       PaymentEventType = "invoice_issued"
+	PaymentEventAddressAssigned     PaymentEventType = "address_assigned"
+	PaymentEventClientSecretCreated PaymentEventType = "client_secret_created"
+	PaymentEventTransactionSeen     PaymentEventType = "transaction_seen"
+	PaymentEventConfirmed           PaymentEventType = "confirmed"
+	PaymentEventExpired             PaymentEventType = "expired"
+	PaymentEventRefunded            PaymentEventType = "refunded"
+)
+
+// PaymentEvent represents the payment_event table
+type PaymentEvent struct {
+	Id              int       `db:"id"`
+	PaymentId       int       `db:"payment_id"`
+	OrderId         int       `db:"order_id"`
+	PaymentMethodId int       `db:"payment_method_id"`
+	CreatedAt       time.Time `db:"created_at"`
+	PaymentEventInsert
+}
+
+// PaymentEventInsert is the payment change with the provider payload,
+// the event with the transaction id is recorded once per transaction.
+type PaymentEventInsert struct {
+	Type          PaymentEventType `db:"type"`
+	TransactionId sql.NullString   `db:"transaction_id"`
+	// Payload is the json of the provider data the event is based on
+	Payload sql.NullString `db:"payload"`
+}
+
+// PaymentEventPayload returns the json of the provider data for the payment event, no payload if it can't be marshalled.
+func PaymentEventPayload(v any) sql.NullString {
+	if v == nil {
+		return sql.NullString{}
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: string(b), Valid: true}
+}
+
+// RefundStatus is the status of the refund
+type RefundStatus string
+
+const (
+	// RefundPending is the refund waiting for the provider or the manual transfer
+	RefundPending   RefundStatus = "pending"
+	RefundSucceeded RefundStatus = "succeeded"
+	RefundFailed    RefundStatus = "failed"
+)
+
+// Refund represents the refund table
+type Refund struct {
+	Id         int       `db:"id"`
+	CreatedAt  time.Time `db:"created_at"`
+	ModifiedAt time.Time `db:"modified_at"`
+	RefundInsert
+}
+
+type RefundInsert struct {
+	PaymentId int `db:"payment_id"`
+	// Amount is the part refunded through the payment provider
+	Amount           decimal.Decimal `db:"amount"`
+	Status           RefundStatus    `db:"status"`
+	ProviderRefundId sql.NullString  `db:"provider_refund_id"`
+	Payee            sql.NullString  `db:"payee"`
+	Reason           sql.NullString  `db:"reason"`
+	Restocked        bool            `db:"restocked"`
+	// GiftCardAmount is the part credited back to the gift card the order was paid with
+	GiftCardAmount decimal.Decimal `db:"gift_card_amount"`
+	// IssuedAt is set once the refund is sent to the payment provider or left to the manual transfer
+	IssuedAt sql.NullTime `db:"issued_at"`
+}
+
+// RefundItemInsert is the quantity of the order item returned to stock with the refund
+type RefundItemInsert struct {
+	OrderItemId int `db:"order_item_id" json:"orderItemId"`
+	Quantity    int `db:"quantity" json:"quantity"`
 }
 
 type PaymentMethodName string
@@ -41,6 +190,10 @@ const (
 	ETH_TEST       PaymentMethodName = "eth-test"
 	USDT_TRON      PaymentMethodName = "usdt-tron"
 	USDT_TRON_TEST PaymentMethodName = "usdt-shasta"
+	USDT_ETH       PaymentMethodName = "usdt-eth"
+	USDT_ETH_TEST  PaymentMethodName = "usdt-sepolia"
+	USDC_ETH       PaymentMethodName = "usdc-eth"
+	USDC_ETH_TEST  PaymentMethodName = "usdc-sepolia"
 )
 
 // ValidPaymentMethodNames is a set of valid payment method names
@@ -51,6 +204,10 @@ var ValidPaymentMethodNames = map[PaymentMethodName]bool{
 	ETH_TEST:       true,
 	USDT_TRON:      true,
 	USDT_TRON_TEST: true,
+	USDT_ETH:       true,
+	USDT_ETH_TEST:  true,
+	USDC_ETH:       true,
+	USDC_ETH_TEST:  true,
 }
 
 // PaymentMethod represents the payment_method table
@@ -242,16 +242,49 @@ type SizeWithMeasurement struct {
 
 // ProductSizes represents the product_size table
 type ProductSize struct {
-	Id        int             `db:"id"`
+	Id int `db:"id"`
+	// Quantity is the stock on hand
 	Quantity  decimal.Decimal `db:"quantity"`
 	ProductId int             `db:"product_id"`
 	SizeId    int             `db:"size_id"`
+	// Reserved is the quantity held by the active stock reservations, it's set only where the available quantity is needed
+	Reserved decimal.Decimal `db:"reserved"`
 }
 
 func (ps *ProductSize) QuantityDecimal() decimal.Decimal {
 	return ps.Quantity.Round(0)
 }
 
+// AvailableDecimal returns the quantity available to sell, the stock on hand without the reserved quantity.
+func (ps *ProductSize) AvailableDecimal() decimal.Decimal {
+	return decimal.Max(ps.QuantityDecimal().Sub(ps.Reserved.Round(0)), decimal.Zero)
+}
+
+// StockReservationStatus is the state of the product size quantity held for the order
+type StockReservationStatus string
+
+const (
+	// StockReservationActive holds the stock until it expires
+	StockReservationActive StockReservationStatus = "active"
+	// StockReservationReleased returns the stock after the order is cancelled or its items are changed
+	StockReservationReleased StockReservationStatus = "released"
+	// StockReservationConverted is taken from the stock on hand once the order invoice is issued
+	StockReservationConverted StockReservationStatus = "converted"
+)
+
+// StockReservation represents the stock_reservation table
+type StockReservation struct {
+	Id        int                    `db:"id"`
+	OrderId   int                    `db:"order_id"`
+	ProductId int                    `db:"product_id"`
+	SizeId    int                    `db:"size_id"`
+	Quantity  int                    `db:"quantity"`
+	Status    StockReservationStatus `db:"status"`
+	ExpiresAt time.Time              `db:"expires_at"`
+	CreatedAt time.Time              `db:"created_at"`
+	UpdatedAt time.Time              `db:"updated_at"`
+}
+
 // ProductSizes for insert represents the product_size table
 type ProductSizeInsert struct {
 	Quantity decimal.Decimal `db:"quantity"`
@@ -0,0 +1,48 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// PaymentOrderStatus is the payment joined with the uuid and the status of its order
+type PaymentOrderStatus struct {
+	OrderUUID     string `db:"order_uuid"`
+	OrderStatusId int    `db:"order_status_id"`
+	Payment
+}
+
+// ReconciliationMismatchType is the kind of difference between the payment and the provider data
+type ReconciliationMismatchType string
+
+const (
+	// MismatchPaidNotConfirmed is the payment received by the provider but not marked as done
+	MismatchPaidNotConfirmed ReconciliationMismatchType = "paid_not_confirmed"
+	// MismatchConfirmedWithoutTransaction is the payment marked as done without the provider transaction
+	MismatchConfirmedWithoutTransaction ReconciliationMismatchType = "confirmed_without_transaction"
+	// MismatchAmount is the payment amount different from the amount received by the provider
+	MismatchAmount ReconciliationMismatchType = "amount_mismatch"
+	// MismatchOrphanTransfer is the transfer to our address not counted for any payment
+	MismatchOrphanTransfer ReconciliationMismatchType = "orphan_transfer"
+)
+
+// ReconciliationMismatch is the payment which does not match the provider data,
+// amounts are in the payment currency
+type ReconciliationMismatch struct {
+	Type              ReconciliationMismatchType
+	OrderUUID         string
+	PaymentMethodName PaymentMethodName
+	TransactionId     string
+	Expected          decimal.Decimal
+	Actual            decimal.Decimal
+	Details           string
+}
+
+// ReconciliationReport is the result of the payments reconciliation since From
+type ReconciliationReport struct {
+	From            time.Time
+	CreatedAt       time.Time
+	PaymentsChecked int
+	Mismatches      []ReconciliationMismatch
+}
@@ -0,0 +1,47 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// CheckoutReminderCandidate is the unpaid order whose buyer is to be reminded about the invoice
+type CheckoutReminderCandidate struct {
+	OrderId    int             `db:"order_id"`
+	OrderUUID  string          `db:"order_uuid"`
+	TotalPrice decimal.Decimal `db:"total_price"`
+	Email      string          `db:"email"`
+	FirstName  string          `db:"first_name"`
+	LastName   string          `db:"last_name"`
+	// Expired is set if the invoice has already expired and the order is cancelled
+	Expired bool `db:"expired"`
+}
+
+// CheckoutReminder is the reminder sent for the unpaid order
+type CheckoutReminder struct {
+	Id      int       `db:"id"`
+	OrderId int       `db:"order_id"`
+	Expired bool      `db:"expired"`
+	SentAt  time.Time `db:"sent_at"`
+}
+
+// CheckoutReminderReport is the conversion of the reminders sent within the period, the reminder is
+// recovered if the reminded buyer paid the reminded order or placed and paid a new one after the reminder
+type CheckoutReminderReport struct {
+	From             time.Time
+	To               time.Time
+	Sent             int             `db:"sent"`
+	SentExpired      int             `db:"sent_expired"`
+	Recovered        int             `db:"recovered"`
+	RecoveredExpired int             `db:"recovered_expired"`
+	RecoveredRevenue decimal.Decimal `db:"recovered_revenue"`
+}
+
+// ConversionRate is the percentage of the sent reminders which were recovered
+func (r *CheckoutReminderReport) ConversionRate() decimal.Decimal {
+	if r.Sent == 0 {
+		return decimal.Zero
+	}
+	return decimal.NewFromInt(int64(r.Recovered)).Mul(decimal.NewFromInt(100)).Div(decimal.NewFromInt(int64(r.Sent))).Round(2)
+}
@@ -0,0 +1,121 @@
+package entity
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// ReturnRequestStatus is the state of the return request:
+// requested -> approved -> received -> refunded/exchanged, requested -> rejected, approved -> cancelled
+type ReturnRequestStatus string
+
+const (
+	ReturnRequested ReturnRequestStatus = "requested"
+	ReturnApproved  ReturnRequestStatus = "approved"
+	ReturnRejected  ReturnRequestStatus = "rejected"
+	ReturnReceived  ReturnRequestStatus = "received"
+	ReturnRefunded  ReturnRequestStatus = "refunded"
+	ReturnExchanged ReturnRequestStatus = "exchanged"
+	ReturnCancelled ReturnRequestStatus = "cancelled"
+)
+
+// ReturnRequestType is either a return for the refund or an exchange for another size
+type ReturnRequestType string
+
+const (
+	ReturnTypeReturn   ReturnRequestType = "return"
+	ReturnTypeExchange ReturnRequestType = "exchange"
+)
+
+// ValidReturnRequestTypes is a set of valid return request types
+var ValidReturnRequestTypes = map[ReturnRequestType]bool{
+	ReturnTypeReturn:   true,
+	ReturnTypeExchange: true,
+}
+
+type ReturnReason string
+
+const (
+	ReturnReasonWrongSize      ReturnReason = "wrong_size"
+	ReturnReasonDamaged        ReturnReason = "damaged"
+	ReturnReasonNotAsDescribed ReturnReason = "not_as_described"
+	ReturnReasonChangedMind    ReturnReason = "changed_mind"
+	ReturnReasonOther          ReturnReason = "other"
+)
+
+// ValidReturnReasons is a set of valid return reasons
+var ValidReturnReasons = map[ReturnReason]bool{
+	ReturnReasonWrongSize:      true,
+	ReturnReasonDamaged:        true,
+	ReturnReasonNotAsDescribed: true,
+	ReturnReasonChangedMind:    true,
+	ReturnReasonOther:          true,
+}
+
+// ReturnRequest represents the return_request table
+type ReturnRequest struct {
+	Id         int                 `db:"id"`
+	UUID       string              `db:"uuid"`
+	OrderId    int                 `db:"order_id"`
+	OrderUUID  string              `db:"order_uuid"`
+	Type       ReturnRequestType   `db:"type"`
+	Status     ReturnRequestStatus `db:"status"`
+	Comment    sql.NullString      `db:"comment"`
+	RefundId   sql.NullInt32       `db:"refund_id"`
+	CreatedAt  time.Time           `db:"created_at"`
+	ModifiedAt time.Time           `db:"modified_at"`
+}
+
+// ReturnRequestInsert is the return request opened by the buyer
+type ReturnRequestInsert struct {
+	OrderUUID string
+	Email     string
+	Type      ReturnRequestType
+	Comment   string
+	Items     []ReturnItemInsert
+}
+
+// ReturnItem represents the return_item table joined with the returned order item
+type ReturnItem struct {
+	Id                   int             `db:"id"`
+	ReturnRequestId      int             `db:"return_request_id"`
+	ProductId            int             `db:"product_id"`
+	SizeId               int             `db:"size_id"`
+	ProductPriceWithSale decimal.Decimal `db:"product_price_with_sale"`
+	// PromoDiscount is the discount percent of the order promo code
+	PromoDiscount decimal.Decimal `db:"promo_discount"`
+	// UnitTaxSurcharge is the exclusive tax paid on top of the price per unit, zero for the inclusive tax
+	UnitTaxSurcharge decimal.Decimal `db:"unit_tax_surcharge"`
+	ReturnItemInsert
+}
+
+type ReturnItemInsert struct {
+	OrderItemId    int           `db:"order_item_id"`
+	Quantity       int           `db:"quantity"`
+	Reason         ReturnReason  `db:"reason"`
+	ExchangeSizeId sql.NullInt32 `db:"exchange_size_id"`
+}
+
+type ReturnRequestFull struct {
+	ReturnRequest    ReturnRequest
+	Items            []ReturnItem
+	ExchangeShipment *Shipment
+}
+
+// RefundAmount is the price paid for the returned items: the sale price with the order promo discount
+// and the exclusive tax paid on top of it.
+func (rrf *ReturnRequestFull) RefundAmount() decimal.Decimal {
+	hundred := decimal.NewFromInt(100)
+	amount := decimal.Zero
+	for _, i := range rrf.Items {
+		q := decimal.NewFromInt(int64(i.Quantity))
+		price := i.ProductPriceWithSale.Round(2).Mul(q)
+		if i.PromoDiscount.IsPositive() {
+			price = price.Mul(hundred.Sub(i.PromoDiscount)).Div(hundred)
+		}
+		amount = amount.Add(price).Add(i.UnitTaxSurcharge.Mul(q))
+	}
+	return amount.Round(2)
+}
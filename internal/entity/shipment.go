@@ -2,6 +2,7 @@ package entity
 
 import (
 	"database/sql"
+	"fmt"
 	"time"
 
 	"github.com/shopspring/decimal"
@@ -36,8 +37,46 @@ type Shipment struct {
 	TrackingCode         sql.NullString  `db:"tracking_code"`
 	ShippingDate         sql.NullTime    `db:"shipping_date"`
 	EstimatedArrivalDate sql.NullTime    `db:"estimated_arrival_date"`
+	// ReturnRequestId is set for the exchange shipments
+	ReturnRequestId sql.NullInt32 `db:"return_request_id"`
 }
 
 func (s *Shipment) CostDecimal() decimal.Decimal {
 	return s.Cost.Round(2)
 }
+
+// ShipmentItem represents the shipment_item table
+type ShipmentItem struct {
+	Id         int `db:"id"`
+	ShipmentId int `db:"shipment_id"`
+	ShipmentItemInsert
+}
+
+// ShipmentItemInsert is the quantity of the order item sent with the shipment
+type ShipmentItemInsert struct {
+	OrderItemId int `db:"order_item_id" valid:"required"`
+	Quantity    int `db:"quantity" valid:"required"`
+}
+
+// ShipmentFull is the order shipment with the order items it contains
+type ShipmentFull struct {
+	Shipment Shipment
+	Items    []ShipmentItem
+}
+
+// OrderShipmentNew is the shipment of the order items, the remaining items are shipped if Items is empty
+type OrderShipmentNew struct {
+	CarrierId    int
+	TrackingCode string
+	ShippingDate time.Time
+	Items        []ShipmentItemInsert
+}
+
+// UnshippedOrderItemsError is returned when the order is marked shipped or delivered while some of its items are not shipped.
+type UnshippedOrderItemsError struct {
+	OrderItemIds []int
+}
+
+func (e *UnshippedOrderItemsError) Error() string {
+	return fmt.Sprintf("order items are not shipped: order item ids %v", e.OrderItemIds)
+}
@@ -0,0 +1,137 @@
+package entity
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/shopspring/decimal"
+)
+
+// TaxRule is the tax of the orders shipped to the country, the countries without the rule are not taxed
+type TaxRule struct {
+	Country string `db:"country"`
+	// Inclusive is set if the product prices include the tax, otherwise the tax is added to the total
+	Inclusive bool `db:"inclusive"`
+	// DefaultRate is the percentage of the categories without their own rate and of the shipping
+	DefaultRate   decimal.Decimal `db:"default_rate"`
+	CategoryRates []TaxCategoryRate
+}
+
+// TaxCategoryRate represents the tax_category_rate table
+type TaxCategoryRate struct {
+	Country    string          `db:"country"`
+	CategoryId int             `db:"category_id"`
+	Rate       decimal.Decimal `db:"rate"`
+}
+
+// NormalizeTaxCountry is the country code the tax rules are looked up by.
+func NormalizeTaxCountry(country string) string {
+	return strings.ToUpper(strings.TrimSpace(country))
+}
+
+func validateTaxRate(rate decimal.Decimal) error {
+	if rate.IsNegative() || rate.GreaterThan(decimal.NewFromInt(100)) {
+		return fmt.Errorf("tax rate must be between 0 and 100: %s", rate.String())
+	}
+	return nil
+}
+
+// Validate checks the rule has the country and the rates are percentages.
+func (tr *TaxRule) Validate() error {
+	if NormalizeTaxCountry(tr.Country) == "" {
+		return fmt.Errorf("tax rule country is empty")
+	}
+	if err := validateTaxRate(tr.DefaultRate); err != nil {
+		return err
+	}
+	for _, cr := range tr.CategoryRates {
+		if err := validateTaxRate(cr.Rate); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rate is the tax percentage of the category.
+func (tr *TaxRule) Rate(categoryId int) decimal.Decimal {
+	for _, cr := range tr.CategoryRates {
+		if cr.CategoryId == categoryId {
+			return cr.Rate
+		}
+	}
+	return tr.DefaultRate
+}
+
+// ItemTax is the tax of the order item
+type ItemTax struct {
+	Rate   decimal.Decimal
+	Amount decimal.Decimal
+}
+
+// OrderTax is the tax of the order items and the shipping
+type OrderTax struct {
+	Inclusive bool
+	// Items are in the order of the taxed items
+	Items    []ItemTax
+	Shipping decimal.Decimal
+	Total    decimal.Decimal
+}
+
+// Surcharge is the part of the tax added to the total, the inclusive tax is already in the prices.
+func (ot *OrderTax) Surcharge() decimal.Decimal {
+	if ot.Inclusive {
+		return decimal.Zero
+	}
+	return ot.Total
+}
+
+// taxOf is the tax of the amount, the inclusive tax is the part of the amount.
+func taxOf(amount, rate decimal.Decimal, inclusive bool) decimal.Decimal {
+	if !rate.IsPositive() {
+		return decimal.Zero
+	}
+	hundred := decimal.NewFromInt(100)
+	if inclusive {
+		return amount.Mul(rate).Div(hundred.Add(rate)).Round(2)
+	}
+	return amount.Mul(rate).Div(hundred).Round(2)
+}
+
+// CalculateTax computes the tax of the items discounted by the promo and of the shipping which is taxed
+// at the default rate, the items are not taxed if the rule is nil.
+func CalculateTax(rule *TaxRule, items []OrderItem, promo PromoCode, shippingPrice decimal.Decimal) *OrderTax {
+	ot := &OrderTax{
+		Items:    make([]ItemTax, len(items)),
+		Shipping: decimal.Zero,
+		Total:    decimal.Zero,
+	}
+	for i := range ot.Items {
+		ot.Items[i] = ItemTax{Rate: decimal.Zero, Amount: decimal.Zero}
+	}
+	if rule == nil {
+		return ot
+	}
+	ot.Inclusive = rule.Inclusive
+
+	hundred := decimal.NewFromInt(100)
+	discount := decimal.NewFromInt(1)
+	if promo.Discount.IsPositive() {
+		discount = hundred.Sub(promo.Discount).Div(hundred)
+	}
+
+	for i, item := range items {
+		rate := rule.Rate(item.CategoryId)
+		amount := item.ProductPriceWithSale.Mul(item.Quantity).Mul(discount)
+		ot.Items[i] = ItemTax{
+			Rate:   rate.Round(2),
+			Amount: taxOf(amount, rate, rule.Inclusive),
+		}
+		ot.Total = ot.Total.Add(ot.Items[i].Amount)
+	}
+
+	if !promo.FreeShipping {
+		ot.Shipping = taxOf(shippingPrice, rule.DefaultRate, rule.Inclusive)
+		ot.Total = ot.Total.Add(ot.Shipping)
+	}
+	return ot
+}
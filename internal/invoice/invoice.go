@@ -0,0 +1,208 @@
+// Package invoice renders the PDF invoices of the confirmed orders and their corrections from the content stored with them.
+// The invoice numbers are allocated by the store when the order is confirmed,
+// the package only formats them with the configured prefix.
+package invoice
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jekabolt/grbpwr-manager/internal/entity"
+	"github.com/shopspring/decimal"
+)
+
+type Config struct {
+	// SellerName is the legal name of the seller
+	SellerName string `mapstructure:"seller_name"`
+	// SellerAddress is the address of the seller line by line
+	SellerAddress []string `mapstructure:"seller_address"`
+	// SellerVATId is the VAT identification number of the seller, omitted if empty
+	SellerVATId string `mapstructure:"seller_vat_id"`
+	// SellerEmail is the contact email of the seller, omitted if empty
+	SellerEmail string `mapstructure:"seller_email"`
+	// NumberPrefix is prepended to the invoice numbers, e.g. GRB- gives GRB-000001
+	NumberPrefix string `mapstructure:"number_prefix"`
+}
+
+const dateFormat = "2006-01-02"
+
+// page layout in points
+const (
+	marginLeft   = 50.0
+	marginRight  = pageWidth - 50.0
+	marginTop    = pageHeight - 60.0
+	marginBottom = 60.0
+
+	colSize      = 330.0
+	colQuantity  = 400.0
+	colUnitPrice = 480.0
+)
+
+type Generator struct {
+	c *Config
+}
+
+// New creates a new generator with the seller details from the config.
+func New(c *Config) *Generator {
+	return &Generator{c: c}
+}
+
+// Number formats the invoice number with the configured prefix.
+func (g *Generator) Number(n int) string {
+	return fmt.Sprintf("%s%06d", g.c.NumberPrefix, n)
+}
+
+// FileName is the file name the invoice document is downloaded with.
+func (g *Generator) FileName(doc *entity.InvoiceDocument) string {
+	if doc.Corrected != nil {
+		return fmt.Sprintf("correction-%s.pdf", g.Number(doc.Number))
+	}
+	return fmt.Sprintf("invoice-%s.pdf", g.Number(doc.Number))
+}
+
+func formatAmount(d decimal.Decimal, currency string) string {
+	return strings.TrimSpace(fmt.Sprintf("%s %s", d.StringFixed(2), currency))
+}
+
+// Generate renders the invoice or its correction from the content stored when the document was issued.
+func (g *Generator) Generate(doc *entity.InvoiceDocument) ([]byte, error) {
+	if doc == nil {
+		return nil, fmt.Errorf("order is not invoiced")
+	}
+	ic := &doc.Content
+
+	p, err := newPDF()
+	if err != nil {
+		return nil, fmt.Errorf("can't create pdf: %w", err)
+	}
+	p.addPage()
+
+	// seller
+	y := marginTop
+	p.text(marginLeft, y, fontBold, 16, g.c.SellerName)
+	y -= 16
+	seller := append([]string{}, g.c.SellerAddress...)
+	if g.c.SellerVATId != "" {
+		seller = append(seller, "VAT ID: "+g.c.SellerVATId)
+	}
+	if g.c.SellerEmail != "" {
+		seller = append(seller, g.c.SellerEmail)
+	}
+	for _, l := range seller {
+		p.text(marginLeft, y, fontRegular, 9, l)
+		y -= 12
+	}
+
+	// invoice details
+	title, details := "INVOICE", []string{"Invoice no. " + g.Number(doc.Number)}
+	if doc.Corrected != nil {
+		// the correction supersedes the document issued before it, the invoice or the previous correction
+		title = "CORRECTION"
+		details = []string{
+			"Correction no. " + g.Number(doc.Number),
+			fmt.Sprintf("Corrects no. %s of %s", g.Number(doc.Corrected.Number), doc.Corrected.IssuedAt.Format(dateFormat)),
+		}
+	}
+	dy := marginTop
+	p.textRight(marginRight, dy, fontBold, 20, title)
+	dy -= 20
+	for _, l := range append(details,
+		"Issue date "+doc.IssuedAt.Format(dateFormat),
+		"Order date "+ic.OrderPlaced.Format(dateFormat),
+		"Order "+ic.OrderUUID,
+	) {
+		p.textRight(marginRight, dy, fontRegular, 9, l)
+		dy -= 12
+	}
+	y = min(y, dy) - 20
+
+	// buyer
+	p.text(marginLeft, y, fontBold, 10, "Bill to")
+	y -= 14
+	buyer := append([]string{ic.BuyerName}, ic.Billing...)
+	buyer = append(buyer, ic.BuyerEmail)
+	for _, l := range buyer {
+		p.text(marginLeft, y, fontRegular, 9, l)
+		y -= 12
+	}
+	y -= 20
+
+	// line items
+	header := func() {
+		p.text(marginLeft, y, fontBold, 9, "Item")
+		p.text(colSize, y, fontBold, 9, "Size")
+		p.textRight(colQuantity, y, fontBold, 9, "Qty")
+		p.textRight(colUnitPrice, y, fontBold, 9, "Unit price")
+		p.textRight(marginRight, y, fontBold, 9, "Amount")
+		y -= 6
+		p.line(marginLeft, y, marginRight, y)
+		y -= 14
+	}
+	header()
+
+	for _, l := range ic.Lines {
+		if y < marginBottom+24 {
+			p.addPage()
+			y = marginTop
+			header()
+		}
+		p.text(marginLeft, y, fontRegular, 9, l.Name)
+		p.text(colSize, y, fontRegular, 9, l.Size)
+		p.textRight(colQuantity, y, fontRegular, 9, l.Quantity.String())
+		p.textRight(colUnitPrice, y, fontRegular, 9, formatAmount(l.UnitPrice, ic.Currency))
+		p.textRight(marginRight, y, fontRegular, 9, formatAmount(l.Amount, ic.Currency))
+		y -= 11
+		if l.SKU != "" {
+			p.text(marginLeft, y, fontRegular, 7, "SKU "+l.SKU)
+			y -= 11
+		}
+		y -= 4
+	}
+
+	// totals
+	if y < marginBottom+80 {
+		p.addPage()
+		y = marginTop
+	}
+	p.line(marginLeft, y+6, marginRight, y+6)
+	y -= 8
+
+	row := func(label string, amount decimal.Decimal, f font) {
+		p.textRight(colUnitPrice, y, f, 9, label)
+		p.textRight(marginRight, y, f, 9, formatAmount(amount, ic.Currency))
+		y -= 14
+	}
+	row("Subtotal", ic.Subtotal, fontRegular)
+	if ic.Discount.IsPositive() {
+		row(fmt.Sprintf("Discount %s (-%s%%)", ic.PromoCode, ic.DiscountPercent.String()), ic.Discount.Neg(), fontRegular)
+	}
+	shippingLabel := "Shipping"
+	if ic.Carrier != "" {
+		shippingLabel = fmt.Sprintf("Shipping %s", ic.Carrier)
+	}
+	row(shippingLabel, ic.Shipping, fontRegular)
+	// the inclusive tax is already in the amounts above, the exclusive one is added to the total
+	if ic.Tax.IsPositive() && ic.TaxInclusive {
+		row("Total", ic.Total, fontBold)
+		row("incl. VAT", ic.Tax, fontRegular)
+	} else {
+		if ic.Tax.IsPositive() {
+			row("VAT", ic.Tax, fontRegular)
+		}
+		row("Total", ic.Total, fontBold)
+	}
+	if ic.GiftCard.IsPositive() {
+		row("Paid with gift card", ic.GiftCard, fontRegular)
+	}
+	if doc.Corrected != nil {
+		y -= 6
+		row("Total before correction", doc.Corrected.Content.Total, fontRegular)
+		row("Difference", ic.Total.Sub(doc.Corrected.Content.Total), fontBold)
+	}
+
+	b, err := p.bytes()
+	if err != nil {
+		return nil, fmt.Errorf("can't render pdf: %w", err)
+	}
+	return b, nil
+}
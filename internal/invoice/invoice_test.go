@@ -0,0 +1,251 @@
+package invoice
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jekabolt/grbpwr-manager/internal/dto"
+	"github.com/jekabolt/grbpwr-manager/internal/entity"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/image/font/sfnt"
+)
+
+func testOrder() *entity.OrderFull {
+	item := func(name string, price, qty int64) entity.OrderItem {
+		return entity.OrderItem{
+			ProductName: name,
+			SKU:         "SKU-" + name,
+			OrderItemInsert: entity.OrderItemInsert{
+				ProductPriceWithSale: decimal.NewFromInt(price),
+				Quantity:             decimal.NewFromInt(qty),
+			},
+		}
+	}
+	return &entity.OrderFull{
+		Order: entity.Order{
+			UUID:       "0b7e2a4e-5d2c-4a57-9f5e-2f0f6c0c4b11",
+			Placed:     time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC),
+			TotalPrice: decimal.NewFromInt(190),
+		},
+		OrderItems: []entity.OrderItem{item("coat (black)", 100, 1), item("tee", 50, 2)},
+		Shipment:   entity.Shipment{Cost: decimal.NewFromInt(30)},
+		PromoCode: entity.PromoCode{PromoCodeInsert: entity.PromoCodeInsert{
+			Code:     "SPRING",
+			Discount: decimal.NewFromInt(20),
+		}},
+		Buyer: entity.Buyer{BuyerInsert: entity.BuyerInsert{FirstName: "Jānis", LastName: "Bērziņš", Email: "janis@example.com"}},
+		Billing: entity.Address{AddressInsert: entity.AddressInsert{
+			Country:        "LV",
+			City:           "Riga",
+			AddressLineOne: "Brivibas iela 1",
+			Company:        sql.NullString{String: "SIA Example", Valid: true},
+			PostalCode:     "LV-1010",
+		}},
+	}
+}
+
+// testDocument is the invoice of the order issued with its content.
+func testDocument(of *entity.OrderFull) *entity.InvoiceDocument {
+	return &entity.InvoiceDocument{
+		Number:   42,
+		IssuedAt: time.Date(2026, 3, 1, 10, 5, 0, 0, time.UTC),
+		Content:  dto.OrderFullToInvoiceContent(of),
+	}
+}
+
+// pdfText is the text as it's drawn in the font, the glyphs of the embedded font in hex.
+func pdfText(t *testing.T, f font, s string) string {
+	p, err := newPDF()
+	if !assert.NoError(t, err) {
+		return ""
+	}
+	var b strings.Builder
+	for _, g := range p.glyphs(f, s) {
+		fmt.Fprintf(&b, "%04X", uint16(g))
+	}
+	return "<" + b.String() + ">"
+}
+
+func TestPDFGlyphs(t *testing.T) {
+	p, err := newPDF()
+	if !assert.NoError(t, err) {
+		return
+	}
+	missing := p.glyphs(fontRegular, "?")[0]
+
+	// the Latvian and Cyrillic names are drawn with their own glyphs
+	for _, s := range []string{"Jānis Bērziņš", "Ļoti Ģirts Ķīsis Žūžo Čakste", "Дмитрий Иванович Ёжиков"} {
+		for _, f := range []font{fontRegular, fontBold} {
+			for i, g := range p.glyphs(f, s) {
+				assert.NotEqual(t, missing, g, "%q rune %d", s, i)
+			}
+		}
+	}
+
+	// the characters out of the font are drawn as the question mark
+	assert.Equal(t, []sfnt.GlyphIndex{missing}, p.glyphs(fontRegular, "\U0001F600"))
+	assert.Equal(t, p.glyphs(fontRegular, " "), p.glyphs(fontRegular, "\n"))
+
+	// the wider text is measured wider
+	assert.Greater(t, p.textWidth(fontRegular, "WWW", 10), p.textWidth(fontRegular, "iii", 10))
+	assert.Greater(t, p.textWidth(fontBold, "Total", 10), p.textWidth(fontRegular, "Total", 10))
+}
+
+func TestInvoiceContent(t *testing.T) {
+	t.Run("discount applies to the subtotal", func(t *testing.T) {
+		ic := dto.OrderFullToInvoiceContent(testOrder())
+		assert.True(t, ic.Subtotal.Equal(decimal.NewFromInt(200)))
+		assert.True(t, ic.Discount.Equal(decimal.NewFromInt(40)))
+		assert.True(t, ic.Shipping.Equal(decimal.NewFromInt(30)))
+		assert.True(t, ic.Total.Equal(decimal.NewFromInt(190)))
+		if assert.Len(t, ic.Lines, 2) {
+			assert.True(t, ic.Lines[1].Amount.Equal(decimal.NewFromInt(100)))
+		}
+		assert.Equal(t, []string{"SIA Example", "Brivibas iela 1", "LV-1010 Riga", "LV"}, ic.Billing)
+	})
+
+	t.Run("free shipping", func(t *testing.T) {
+		of := testOrder()
+		of.PromoCode.FreeShipping = true
+		of.PromoCode.Discount = decimal.Zero
+		ic := dto.OrderFullToInvoiceContent(of)
+		assert.True(t, ic.Discount.IsZero())
+		assert.True(t, ic.Shipping.IsZero())
+	})
+}
+
+func TestGenerate(t *testing.T) {
+	g := New(&Config{
+		SellerName:    "GRBPWR",
+		SellerAddress: []string{"Street 1", "Riga"},
+		SellerVATId:   "LV40000000000",
+		NumberPrefix:  "GRB-",
+	})
+
+	t.Run("not invoiced", func(t *testing.T) {
+		_, err := g.Generate(nil)
+		assert.Error(t, err)
+	})
+
+	t.Run("document", func(t *testing.T) {
+		doc := testDocument(testOrder())
+		b, err := g.Generate(doc)
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, "invoice-GRB-000042.pdf", g.FileName(doc))
+
+		assert.True(t, bytes.HasPrefix(b, []byte("%PDF-1.4\n")))
+		assert.True(t, bytes.HasSuffix(b, []byte("%%EOF\n")))
+		assert.Contains(t, string(b), pdfText(t, fontRegular, "Invoice no. GRB-000042"))
+		assert.Contains(t, string(b), pdfText(t, fontRegular, "coat (black)"))
+		assert.Contains(t, string(b), pdfText(t, fontRegular, "Discount SPRING (-20%)"))
+		assert.Contains(t, string(b), pdfText(t, fontBold, "INVOICE"))
+		// the buyer name is drawn with the embedded font and maps back to the text
+		assert.Contains(t, string(b), pdfText(t, fontRegular, "Jānis Bērziņš"))
+		assert.Contains(t, string(b), "/FontFile2")
+		assert.Contains(t, string(b), "> <0101>\n")
+		assert.Contains(t, string(b), "> <0146>\n")
+
+		// the cross-reference table points at the objects
+		m := regexp.MustCompile(`startxref\n(\d+)\n`).FindSubmatch(b)
+		if !assert.NotNil(t, m) {
+			return
+		}
+		xref, err := strconv.Atoi(string(m[1]))
+		assert.NoError(t, err)
+		assert.True(t, bytes.HasPrefix(b[xref:], []byte("xref\n0 15\n")))
+		offsets := regexp.MustCompile(`(\d{10}) 00000 n `).FindAllSubmatch(b[xref:], -1)
+		assert.Len(t, offsets, 14)
+		for n, off := range offsets {
+			o, err := strconv.Atoi(string(off[1]))
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.True(t, bytes.HasPrefix(b[o:], []byte(fmt.Sprintf("%d 0 obj\n", n+1))))
+		}
+	})
+
+	t.Run("vat", func(t *testing.T) {
+		of := testOrder()
+		of.Order.TaxAmount = decimal.NewFromFloat(31.67)
+		of.Order.TaxInclusive = true
+		b, err := g.Generate(testDocument(of))
+		assert.NoError(t, err)
+		assert.Contains(t, string(b), pdfText(t, fontRegular, "incl. VAT"))
+
+		of.Order.TaxInclusive = false
+		b, err = g.Generate(testDocument(of))
+		assert.NoError(t, err)
+		assert.Contains(t, string(b), pdfText(t, fontRegular, "VAT"))
+		assert.NotContains(t, string(b), pdfText(t, fontRegular, "incl. VAT"))
+	})
+
+	t.Run("cyrillic", func(t *testing.T) {
+		of := testOrder()
+		of.Buyer.FirstName = "Дмитрий"
+		of.Buyer.LastName = "Иванов"
+		of.Billing.City = "Рига"
+		b, err := g.Generate(testDocument(of))
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Contains(t, string(b), pdfText(t, fontRegular, "Дмитрий Иванов"))
+		assert.Contains(t, string(b), pdfText(t, fontRegular, "LV-1010 Рига"))
+		assert.Contains(t, string(b), "> <0414>\n")
+		assert.Contains(t, string(b), "> <0420>\n")
+	})
+
+	t.Run("page break", func(t *testing.T) {
+		of := testOrder()
+		for len(of.OrderItems) < 30 {
+			of.OrderItems = append(of.OrderItems, of.OrderItems[0])
+		}
+		b, err := g.Generate(testDocument(of))
+		assert.NoError(t, err)
+		assert.Contains(t, string(b), "/Count 2")
+	})
+
+	t.Run("correction", func(t *testing.T) {
+		// the edit of the invoiced order is stated by the correction, the invoice states the order as it was issued
+		invoice := testDocument(testOrder())
+		edited := testOrder()
+		edited.OrderItems = edited.OrderItems[:1]
+		edited.Order.TotalPrice = decimal.NewFromInt(110)
+		edited.Billing.City = "Jurmala"
+
+		correction := &entity.InvoiceDocument{
+			Number:    57,
+			IssuedAt:  time.Date(2026, 3, 4, 9, 0, 0, 0, time.UTC),
+			Content:   dto.OrderFullToInvoiceContent(edited),
+			Corrected: invoice,
+		}
+		b, err := g.Generate(correction)
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, "correction-GRB-000057.pdf", g.FileName(correction))
+		assert.Contains(t, string(b), pdfText(t, fontBold, "CORRECTION"))
+		assert.Contains(t, string(b), pdfText(t, fontRegular, "Correction no. GRB-000057"))
+		assert.Contains(t, string(b), pdfText(t, fontRegular, "Corrects no. GRB-000042 of 2026-03-01"))
+		assert.Contains(t, string(b), pdfText(t, fontRegular, "LV-1010 Jurmala"))
+		assert.Contains(t, string(b), pdfText(t, fontRegular, "Total before correction"))
+		assert.Contains(t, string(b), pdfText(t, fontRegular, "190.00"))
+		assert.Contains(t, string(b), pdfText(t, fontBold, "-80.00"))
+		assert.NotContains(t, string(b), pdfText(t, fontRegular, "tee"))
+
+		b, err = g.Generate(invoice)
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Contains(t, string(b), pdfText(t, fontRegular, "LV-1010 Riga"))
+		assert.NotContains(t, string(b), pdfText(t, fontBold, "CORRECTION"))
+	})
+}
@@ -0,0 +1,312 @@
+package invoice
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf16"
+
+	imgfont "golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+// A4 page size in points
+const (
+	pageWidth  = 595.0
+	pageHeight = 842.0
+)
+
+// font is one of the fonts embedded in the document
+type font int
+
+const (
+	fontRegular font = iota
+	fontBold
+)
+
+// ttf is the TrueType font embedded in the documents, the Go fonts cover
+// the Latin, Greek and Cyrillic scripts the buyer names and addresses are written in.
+type ttf struct {
+	name string
+	data []byte
+	sfnt *sfnt.Font
+}
+
+// loadFonts parses the embedded fonts once, the parsed fonts are shared by the documents.
+var loadFonts = sync.OnceValues(func() ([]*ttf, error) {
+	fonts := make([]*ttf, 0, 2)
+	for _, f := range []struct {
+		name string
+		data []byte
+	}{
+		{name: "GoRegular", data: goregular.TTF},
+		{name: "GoBold", data: gobold.TTF},
+	} {
+		sf, err := sfnt.Parse(f.data)
+		if err != nil {
+			return nil, fmt.Errorf("can't parse font %s: %w", f.name, err)
+		}
+		fonts = append(fonts, &ttf{name: f.name, data: f.data, sfnt: sf})
+	}
+	return fonts, nil
+})
+
+// fontUsage is the glyphs of the font the document uses, their widths are
+// in thousandths of the font size and their runes map the text back on copying.
+type fontUsage struct {
+	*ttf
+	widths map[sfnt.GlyphIndex]int
+	runes  map[sfnt.GlyphIndex]rune
+}
+
+// pdf is a minimal PDF writer of the text and the lines on the A4 pages,
+// the coordinates are from the bottom left corner of the page.
+type pdf struct {
+	pages []*bytes.Buffer
+	fonts []*fontUsage
+	buf   sfnt.Buffer
+}
+
+func newPDF() (*pdf, error) {
+	fonts, err := loadFonts()
+	if err != nil {
+		return nil, err
+	}
+	p := &pdf{}
+	for _, f := range fonts {
+		p.fonts = append(p.fonts, &fontUsage{
+			ttf:    f,
+			widths: map[sfnt.GlyphIndex]int{},
+			runes:  map[sfnt.GlyphIndex]rune{},
+		})
+	}
+	return p, nil
+}
+
+// addPage starts the new page, the following text and lines are drawn on it.
+func (p *pdf) addPage() {
+	p.pages = append(p.pages, &bytes.Buffer{})
+}
+
+func (p *pdf) page() *bytes.Buffer {
+	if len(p.pages) == 0 {
+		p.addPage()
+	}
+	return p.pages[len(p.pages)-1]
+}
+
+// glyphs maps the text to the glyphs of the font, the whitespace is drawn as the space
+// and the characters the font has no glyph for are drawn as the question mark.
+func (p *pdf) glyphs(f font, s string) []sfnt.GlyphIndex {
+	fu := p.fonts[f]
+	upem := fixed.Int26_6(fu.sfnt.UnitsPerEm()) << 6
+
+	gs := make([]sfnt.GlyphIndex, 0, len(s))
+	for _, r := range s {
+		if r == '\n' || r == '\r' || r == '\t' {
+			r = ' '
+		}
+		g, err := fu.sfnt.GlyphIndex(&p.buf, r)
+		if err != nil || g == 0 {
+			r = '?'
+			g, _ = fu.sfnt.GlyphIndex(&p.buf, r)
+		}
+		if _, ok := fu.widths[g]; !ok {
+			adv, err := fu.sfnt.GlyphAdvance(&p.buf, g, upem, imgfont.HintingNone)
+			if err != nil {
+				adv = upem / 2
+			}
+			fu.widths[g] = int(adv) * 1000 / int(upem)
+			fu.runes[g] = r
+		}
+		gs = append(gs, g)
+	}
+	return gs
+}
+
+// textWidth is the width of the text in points.
+func (p *pdf) textWidth(f font, s string, size float64) float64 {
+	w := 0
+	for _, g := range p.glyphs(f, s) {
+		w += p.fonts[f].widths[g]
+	}
+	return float64(w) * size / 1000
+}
+
+// text draws the text with its baseline starting at x, y.
+func (p *pdf) text(x, y float64, f font, size float64, s string) {
+	var hex strings.Builder
+	for _, g := range p.glyphs(f, s) {
+		fmt.Fprintf(&hex, "%04X", uint16(g))
+	}
+	fmt.Fprintf(p.page(), "BT /F%d %.1f Tf %.2f %.2f Td <%s> Tj ET\n", f+1, size, x, y, hex.String())
+}
+
+// textRight draws the text ending at x.
+func (p *pdf) textRight(x, y float64, f font, size float64, s string) {
+	p.text(x-p.textWidth(f, s, size), y, f, size, s)
+}
+
+// line draws the thin line from x1, y1 to x2, y2.
+func (p *pdf) line(x1, y1, x2, y2 float64) {
+	fmt.Fprintf(p.page(), "0.5 w %.2f %.2f m %.2f %.2f l S\n", x1, y1, x2, y2)
+}
+
+// usedGlyphs returns the glyphs of the font the document uses in order.
+func (fu *fontUsage) usedGlyphs() []sfnt.GlyphIndex {
+	gs := make([]sfnt.GlyphIndex, 0, len(fu.widths))
+	for g := range fu.widths {
+		gs = append(gs, g)
+	}
+	sort.Slice(gs, func(i, j int) bool { return gs[i] < gs[j] })
+	return gs
+}
+
+// widthsArray is the /W array of the widths of the used glyphs.
+func (fu *fontUsage) widthsArray() string {
+	var b strings.Builder
+	for _, g := range fu.usedGlyphs() {
+		fmt.Fprintf(&b, "%d [%d] ", g, fu.widths[g])
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// toUnicode is the CMap mapping the used glyphs back to the text they were drawn for.
+func (fu *fontUsage) toUnicode() string {
+	var b strings.Builder
+	b.WriteString("/CIDInit /ProcSet findresource begin\n12 dict begin\nbegincmap\n" +
+		"/CIDSystemInfo << /Registry (Adobe) /Ordering (UCS) /Supplement 0 >> def\n" +
+		"/CMapName /Adobe-Identity-UCS def\n/CMapType 2 def\n" +
+		"1 begincodespacerange\n<0000> <FFFF>\nendcodespacerange\n")
+	gs := fu.usedGlyphs()
+	// the bfchar blocks are limited to 100 entries each
+	for len(gs) > 0 {
+		n := min(len(gs), 100)
+		fmt.Fprintf(&b, "%d beginbfchar\n", n)
+		for _, g := range gs[:n] {
+			fmt.Fprintf(&b, "<%04X> <", uint16(g))
+			for _, u := range utf16.Encode([]rune{fu.runes[g]}) {
+				fmt.Fprintf(&b, "%04X", u)
+			}
+			b.WriteString(">\n")
+		}
+		b.WriteString("endbfchar\n")
+		gs = gs[n:]
+	}
+	b.WriteString("endcmap\nCMapName currentdict /CMap defineresource pop\nend\nend\n")
+	return b.String()
+}
+
+// descriptor is the font descriptor of the font with its metrics in thousandths of the font size.
+func (fu *fontUsage) descriptor(fontFile int) (string, error) {
+	var buf sfnt.Buffer
+	upem := fixed.Int26_6(fu.sfnt.UnitsPerEm()) << 6
+	scale := func(v fixed.Int26_6) int {
+		return int(v) * 1000 / int(upem)
+	}
+
+	m, err := fu.sfnt.Metrics(&buf, upem, imgfont.HintingNone)
+	if err != nil {
+		return "", fmt.Errorf("can't get font metrics: %w", err)
+	}
+	// the y axis of the font bounds points down
+	bounds, err := fu.sfnt.Bounds(&buf, upem, imgfont.HintingNone)
+	if err != nil {
+		return "", fmt.Errorf("can't get font bounds: %w", err)
+	}
+	return fmt.Sprintf("<< /Type /FontDescriptor /FontName /%s /Flags 32 /FontBBox [%d %d %d %d] /ItalicAngle 0 "+
+		"/Ascent %d /Descent %d /CapHeight %d /StemV 80 /FontFile2 %d 0 R >>",
+		fu.name, scale(bounds.Min.X), -scale(bounds.Max.Y), scale(bounds.Max.X), -scale(bounds.Min.Y),
+		scale(m.Ascent), -scale(m.Descent), scale(m.CapHeight), fontFile), nil
+}
+
+// deflate compresses the stream data with the FlateDecode filter.
+func deflate(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, fmt.Errorf("can't compress stream: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("can't compress stream: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// fontObjects is the number of the objects of each font: the Type0 font, its CID font,
+// the font descriptor, the font file and the ToUnicode CMap
+const fontObjects = 5
+
+// bytes assembles the document with the cross-reference table pointing at every object.
+func (p *pdf) bytes() ([]byte, error) {
+	if len(p.pages) == 0 {
+		p.addPage()
+	}
+
+	var (
+		buf     bytes.Buffer
+		offsets []int
+	)
+	object := func(body string) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+	stream := func(dict string, data []byte) {
+		dict = strings.TrimSpace(fmt.Sprintf("%s /Length %d", dict, len(data)))
+		object(fmt.Sprintf("<< %s >>\nstream\n%s\nendstream", dict, data))
+	}
+
+	buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	// the catalog, the page tree and the fonts go first, each page is followed by its content
+	const firstFont = 3
+	firstPage := firstFont + len(p.fonts)*fontObjects
+	kids := make([]string, 0, len(p.pages))
+	for i := range p.pages {
+		kids = append(kids, fmt.Sprintf("%d 0 R", firstPage+i*2))
+	}
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(p.pages)))
+
+	fontRefs := make([]string, 0, len(p.fonts))
+	for i, fu := range p.fonts {
+		id := firstFont + i*fontObjects
+		fontRefs = append(fontRefs, fmt.Sprintf("/F%d %d 0 R", i+1, id))
+
+		object(fmt.Sprintf("<< /Type /Font /Subtype /Type0 /BaseFont /%s /Encoding /Identity-H /DescendantFonts [%d 0 R] /ToUnicode %d 0 R >>",
+			fu.name, id+1, id+4))
+		object(fmt.Sprintf("<< /Type /Font /Subtype /CIDFontType2 /BaseFont /%s /CIDSystemInfo << /Registry (Adobe) /Ordering (Identity) /Supplement 0 >> "+
+			"/FontDescriptor %d 0 R /CIDToGIDMap /Identity /DW 1000 /W [%s] >>", fu.name, id+2, fu.widthsArray()))
+		descriptor, err := fu.descriptor(id + 3)
+		if err != nil {
+			return nil, err
+		}
+		object(descriptor)
+		data, err := deflate(fu.data)
+		if err != nil {
+			return nil, err
+		}
+		stream(fmt.Sprintf("/Filter /FlateDecode /Length1 %d", len(fu.data)), data)
+		stream("", []byte(fu.toUnicode()))
+	}
+
+	for i, content := range p.pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] /Resources << /Font << %s >> >> /Contents %d 0 R >>",
+			pageWidth, pageHeight, strings.Join(fontRefs, " "), firstPage+i*2+1))
+		stream("", content.Bytes())
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	return buf.Bytes(), nil
+}
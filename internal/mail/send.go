@@ -11,20 +11,24 @@ import (
 type templateName string
 
 const (
-	NewSubscriber  templateName = "new_subscriber.gohtml"
-	OrderCancelled templateName = "order_cancelled.gohtml"
-	OrderConfirmed templateName = "order_confirmed.gohtml"
-	OrderShipped   templateName = "order_shipped.gohtml"
-	PromoCode      templateName = "promo_code.gohtml"
+	NewSubscriber    templateName = "new_subscriber.gohtml"
+	OrderCancelled   templateName = "order_cancelled.gohtml"
+	OrderConfirmed   templateName = "order_confirmed.gohtml"
+	OrderShipped     templateName = "order_shipped.gohtml"
+	PromoCode        templateName = "promo_code.gohtml"
+	CheckoutReminder templateName = "checkout_reminder.gohtml"
+	OrderLookup      templateName = "order_lookup.gohtml"
 )
 
 // Define a map for template names to subjects
 var templateSubjects = map[templateName]string{
-	NewSubscriber:  "Welcome to GRBPWR",
-	OrderCancelled: "Your order has been cancelled",
-	OrderConfirmed: "Your order has been confirmed",
-	OrderShipped:   "Your order has been shipped",
-	PromoCode:      "Your promo code",
+	NewSubscriber:    "Welcome to GRBPWR",
+	OrderCancelled:   "Your order has been cancelled",
+	OrderConfirmed:   "Your order has been confirmed",
+	OrderShipped:     "Your order has been shipped",
+	PromoCode:        "Your promo code",
+	CheckoutReminder: "Your order is waiting for payment",
+	OrderLookup:      "Your GRBPWR orders",
 }
 
 // SendNewSubscriber sends a welcome email to a new subscriber.
@@ -86,3 +90,31 @@ func (m *Mailer) SendPromoCode(ctx context.Context, rep dependency.Repository, t
 
 	return m.sendWithInsert(ctx, rep, ser)
 }
+
+// SendCheckoutReminder sends a reminder about the unpaid order invoice.
+func (m *Mailer) SendCheckoutReminder(ctx context.Context, rep dependency.Repository, to string, reminderDetails *dto.CheckoutReminder) error {
+	if reminderDetails.OrderUUID == "" {
+		return fmt.Errorf("incomplete checkout reminder details: %+v", reminderDetails)
+	}
+
+	ser, err := m.buildSendMailRequest(to, CheckoutReminder, reminderDetails)
+	if err != nil {
+		return fmt.Errorf("can't build send mail request for checkout reminder: %w", err)
+	}
+
+	return m.sendWithInsert(ctx, rep, ser)
+}
+
+// SendOrderLookup sends the signed link to the orders of the buyer email.
+func (m *Mailer) SendOrderLookup(ctx context.Context, rep dependency.Repository, to string, lookupDetails *dto.OrderLookup) error {
+	if lookupDetails.Token == "" {
+		return fmt.Errorf("incomplete order lookup details: %+v", lookupDetails)
+	}
+
+	ser, err := m.buildSendMailRequest(to, OrderLookup, lookupDetails)
+	if err != nil {
+		return fmt.Errorf("can't build send mail request for order lookup: %w", err)
+	}
+
+	return m.sendWithInsert(ctx, rep, ser)
+}
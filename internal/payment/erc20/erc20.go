@@ -0,0 +1,143 @@
+// Package erc20 accepts payments in ERC-20 tokens like the USDT and USDC stablecoins on ethereum.
+// Every order is invoiced to its own deposit address derived from the extended public key,
+// the transfers to it are found in the Transfer event logs of the token contract.
+package erc20
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/jekabolt/grbpwr-manager/internal/cache"
+	"github.com/jekabolt/grbpwr-manager/internal/dependency"
+	"github.com/jekabolt/grbpwr-manager/internal/entity"
+	"github.com/jekabolt/grbpwr-manager/internal/payment/hdwallet"
+	"github.com/jekabolt/grbpwr-manager/internal/payment/monitor"
+	"github.com/shopspring/decimal"
+)
+
+type Config struct {
+	// XPub is the extended public key of the external chain deposit addresses are derived from,
+	// if empty they are derived from Mnemonic and DerivationPath.
+	// The deposit addresses are unique across payment methods so every token needs its own account.
+	XPub           string `mapstructure:"xpub"`
+	Mnemonic       string `mapstructure:"mnemonic"`
+	DerivationPath string `mapstructure:"derivation_path"`
+	// Node is the JSON-RPC endpoint of the ethereum node
+	Node string `mapstructure:"node"`
+	// ContractAddress is the address of the token contract
+	ContractAddress string `mapstructure:"contract_address"`
+	// Decimals of the token, 6 for USDT and USDC
+	Decimals          int           `mapstructure:"decimals"`
+	InvoiceExpiration time.Duration `mapstructure:"invoice_expiration"`
+	// QuoteExpiration is how long the invoice amount is locked at the quoted rate, the invoice expiration if zero
+	QuoteExpiration         time.Duration `mapstructure:"quote_expiration"`
+	CheckIncomingTxInterval time.Duration `mapstructure:"check_incoming_tx_interval"`
+	// Confirmations is the number of blocks required to settle a transfer, the block including it counts as the first
+	Confirmations uint64 `mapstructure:"confirmations"`
+	// FilterLogsPageSize is the number of blocks the transfer logs are filtered in at once,
+	// the nodes limit the block range of a single query
+	FilterLogsPageSize uint64 `mapstructure:"filter_logs_page_size"`
+}
+
+const (
+	// defaultDerivationPath is the BIP44 path of the ethereum external chain for the first account
+	defaultDerivationPath     = "m/44'/60'/0'/0"
+	defaultFilterLogsPageSize = 2000
+)
+
+// source finds the transfers of the token to the deposit addresses in the event logs.
+type source struct {
+	contract common.Address
+	wallet   *hdwallet.Wallet
+	ec       dependency.EthClient
+	pageSize uint64
+}
+
+func New(ctx context.Context, c *Config, rep dependency.Repository, m dependency.Mailer, ec dependency.EthClient, r dependency.RatesService, pool dependency.PaymentPool, pmn entity.PaymentMethodName) (dependency.Invoicer, error) {
+	pm, ok := cache.GetPaymentMethodByName(pmn)
+	if !ok {
+		return nil, fmt.Errorf("payment method not found")
+	}
+	if !isPaymentMethodERC20(pm.Method) {
+		return nil, fmt.Errorf("payment method is not valid for erc20")
+	}
+	if !common.IsHexAddress(c.ContractAddress) {
+		return nil, fmt.Errorf("invalid token contract address: %s", c.ContractAddress)
+	}
+
+	path := c.DerivationPath
+	if path == "" {
+		path = defaultDerivationPath
+	}
+	wallet, err := hdwallet.New(c.XPub, c.Mnemonic, path)
+	if err != nil {
+		return nil, fmt.Errorf("can't create hd wallet: %w", err)
+	}
+
+	if c.FilterLogsPageSize == 0 {
+		c.FilterLogsPageSize = defaultFilterLogsPageSize
+	}
+	src := &source{
+		contract: common.HexToAddress(c.ContractAddress),
+		wallet:   wallet,
+		ec:       ec,
+		pageSize: c.FilterLogsPageSize,
+	}
+
+	return monitor.New(ctx, &monitor.Config{
+		InvoiceExpiration:       c.InvoiceExpiration,
+		QuoteExpiration:         c.QuoteExpiration,
+		CheckIncomingTxInterval: c.CheckIncomingTxInterval,
+		Confirmations:           c.Confirmations,
+		Decimals:                c.Decimals,
+	}, pm.Method, src, rep, m, r, pool)
+}
+
+func isPaymentMethodERC20(pm entity.PaymentMethod) bool {
+	switch pm.Name {
+	case entity.USDT_ETH, entity.USDT_ETH_TEST, entity.USDC_ETH, entity.USDC_ETH_TEST:
+		return true
+	}
+	return false
+}
+
+// Address derives the ethereum deposit address at the index.
+func (s *source) Address(index uint32) (string, error) {
+	pub, err := s.wallet.PublicKey(index)
+	if err != nil {
+		return "", err
+	}
+	return crypto.PubkeyToAddress(*pub).Hex(), nil
+}
+
+// StartBlock returns the current head, the transfers to the invoice issued now are looked up from it.
+func (s *source) StartBlock(ctx context.Context) (uint64, bool, error) {
+	head, err := s.ec.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return 0, false, fmt.Errorf("can't get latest block: %w", err)
+	}
+	return head.Number.Uint64(), true, nil
+}
+
+// Received sums the transfers to the payee logged since the start block of the invoice,
+// for the invoices issued without one it is looked up by the time the invoice was issued at.
+func (s *source) Received(ctx context.Context, payee string, issuedAt time.Time, startBlock sql.NullInt64, confirmations uint64) (*monitor.Received, error) {
+	if !common.IsHexAddress(payee) {
+		return &monitor.Received{Confirmed: decimal.Zero, Pending: decimal.Zero}, nil
+	}
+
+	from := uint64(startBlock.Int64)
+	if !startBlock.Valid {
+		var err error
+		from, err = blockByTime(ctx, s.ec, issuedAt.Add(-1*time.Minute))
+		if err != nil {
+			return nil, fmt.Errorf("can't get start block: %w", err)
+		}
+	}
+
+	return receivedTransfers(ctx, s.ec, s.contract, common.HexToAddress(payee), from, confirmations, s.pageSize)
+}
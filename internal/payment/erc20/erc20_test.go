@@ -0,0 +1,393 @@
+package erc20
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/jekabolt/grbpwr-manager/internal/dependency"
+	"github.com/jekabolt/grbpwr-manager/internal/dto"
+	"github.com/jekabolt/grbpwr-manager/internal/entity"
+	"github.com/jekabolt/grbpwr-manager/internal/payment/monitor"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+const testMnemonic = "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"
+
+// memOrder keeps the payments of the orders in memory.
+type memOrder struct {
+	dependency.Order
+	totals       map[string]decimal.Decimal
+	payments     map[string]*entity.Payment
+	addresses    map[string]*entity.PaymentAddress
+	overpayments map[string]decimal.Decimal
+	partial      map[string]bool
+	expired      map[string]bool
+	events       map[string][]entity.PaymentEventInsert
+	expirations  map[string]time.Time
+}
+
+func newMemOrder(totals map[string]decimal.Decimal) *memOrder {
+	o := &memOrder{
+		totals:       totals,
+		payments:     map[string]*entity.Payment{},
+		addresses:    map[string]*entity.PaymentAddress{},
+		overpayments: map[string]decimal.Decimal{},
+		partial:      map[string]bool{},
+		expired:      map[string]bool{},
+		events:       map[string][]entity.PaymentEventInsert{},
+		expirations:  map[string]time.Time{},
+	}
+	for uuid := range totals {
+		o.payments[uuid] = &entity.Payment{}
+	}
+	return o
+}
+
+func (o *memOrder) orderFull(uuid string) *entity.OrderFull {
+	return &entity.OrderFull{
+		Order:   entity.Order{UUID: uuid, TotalPrice: o.totals[uuid]},
+		Payment: *o.payments[uuid],
+	}
+}
+
+func (o *memOrder) GetAwaitingPaymentsByPaymentType(ctx context.Context, pmn ...entity.PaymentMethodName) ([]entity.PaymentOrderUUID, error) {
+	poids := []entity.PaymentOrderUUID{}
+	for uuid, p := range o.payments {
+		if !p.IsTransactionDone && p.Payee.Valid {
+			poids = append(poids, entity.PaymentOrderUUID{OrderUUID: uuid, Payment: *p})
+		}
+	}
+	return poids, nil
+}
+
+func (o *memOrder) GetPaymentByOrderUUID(ctx context.Context, orderUUID string) (*entity.Payment, error) {
+	p, ok := o.payments[orderUUID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	cp := *p
+	return &cp, nil
+}
+
+func (o *memOrder) GetPaymentAddressByOrderUUID(ctx context.Context, orderUUID string, paymentMethodId int) (*entity.PaymentAddress, error) {
+	pa, ok := o.addresses[orderUUID]
+	if !ok {
+		return nil, fmt.Errorf("can't get payment address: %w", sql.ErrNoRows)
+	}
+	return pa, nil
+}
+
+func (o *memOrder) NextDerivationIndex(ctx context.Context, paymentMethodId int) (int, error) {
+	return len(o.addresses), nil
+}
+
+func (o *memOrder) AddPaymentAddress(ctx context.Context, orderUUID string, pa *entity.PaymentAddressInsert) error {
+	o.addresses[orderUUID] = &entity.PaymentAddress{PaymentAddressInsert: *pa}
+	return nil
+}
+
+func (o *memOrder) InsertCryptoInvoice(ctx context.Context, orderUUID string, payeeAddress string, pm entity.PaymentMethod) (*entity.OrderFull, error) {
+	p := o.payments[orderUUID]
+	p.PaymentMethodID = pm.Id
+	p.Payee = sql.NullString{String: payeeAddress, Valid: true}
+	p.ModifiedAt = time.Now().Truncate(time.Second)
+	o.expired[orderUUID] = false
+	o.partial[orderUUID] = false
+	return o.orderFull(orderUUID), nil
+}
+
+func (o *memOrder) UpdatePaymentQuote(ctx context.Context, orderUUID string, q *entity.PaymentQuote) error {
+	p := o.payments[orderUUID]
+	p.TransactionAmountPaymentCurrency = q.AmountPaymentCurrency
+	p.PaymentCurrency = sql.NullString{String: q.PaymentCurrency, Valid: true}
+	p.PaymentCurrencyRate = decimal.NullDecimal{Decimal: q.Rate, Valid: true}
+	p.QuoteSourceCurrency = sql.NullString{String: q.SourceCurrency, Valid: true}
+	p.QuotedAt = sql.NullTime{Time: q.QuotedAt, Valid: true}
+	p.QuoteExpiresAt = sql.NullTime{Time: q.ExpiresAt, Valid: true}
+	return nil
+}
+
+func (o *memOrder) UpdatePaymentStartBlock(ctx context.Context, orderUUID string, startBlock uint64) error {
+	o.payments[orderUUID].StartBlock = sql.NullInt64{Int64: int64(startBlock), Valid: true}
+	return nil
+}
+
+func (o *memOrder) GetOrderByUUID(ctx context.Context, orderUUID string) (*entity.Order, error) {
+	return &o.orderFull(orderUUID).Order, nil
+}
+
+func (o *memOrder) AddPaymentEvent(ctx context.Context, orderUUID string, pe *entity.PaymentEventInsert) error {
+	o.events[orderUUID] = append(o.events[orderUUID], *pe)
+	return nil
+}
+
+func (o *memOrder) OrderPaymentPartiallyPaid(ctx context.Context, orderUUID string) error {
+	o.partial[orderUUID] = true
+	return nil
+}
+
+func (o *memOrder) OrderPaymentDone(ctx context.Context, orderUUID string, p *entity.Payment) (*entity.Payment, error) {
+	cp := *p
+	o.payments[orderUUID] = &cp
+	return &cp, nil
+}
+
+func (o *memOrder) AddPaymentOverpayment(ctx context.Context, orderUUID string, op *entity.PaymentOverpaymentInsert) error {
+	o.overpayments[orderUUID] = op.AmountPaymentCurrency
+	return nil
+}
+
+func (o *memOrder) GetOrderFullByUUID(ctx context.Context, orderUUID string) (*entity.OrderFull, error) {
+	return o.orderFull(orderUUID), nil
+}
+
+func (o *memOrder) ExpireOrderPayment(ctx context.Context, orderUUID string) (*entity.Payment, error) {
+	p := o.payments[orderUUID]
+	if p.IsTransactionDone {
+		return p, nil
+	}
+	o.payments[orderUUID] = &entity.Payment{PaymentInsert: entity.PaymentInsert{PaymentMethodID: p.PaymentMethodID}}
+	o.expired[orderUUID] = true
+	return p, nil
+}
+
+func (o *memOrder) AddPaymentExpiration(ctx context.Context, orderUUID string, paymentMethodId int, expireAt time.Time) error {
+	o.expirations[orderUUID] = expireAt
+	return nil
+}
+
+type memRepository struct {
+	dependency.Repository
+	order *memOrder
+}
+
+func (r *memRepository) Order() dependency.Order {
+	return r.order
+}
+
+func (r *memRepository) Tx(ctx context.Context, f func(context.Context, dependency.Repository) error) error {
+	return f(ctx, r)
+}
+
+// eurRates converts the euro base currency at the given USD rate.
+type eurRates struct {
+	dependency.RatesService
+	usd decimal.Decimal
+}
+
+func (r *eurRates) GetBaseCurrency() dto.CurrencyTicker {
+	return dto.EUR
+}
+
+func (r *eurRates) ConvertFromBaseCurrency(currencyTo dto.CurrencyTicker, amount decimal.Decimal) (decimal.Decimal, error) {
+	if currencyTo != dto.USD {
+		return decimal.Zero, fmt.Errorf("unsupported currency: %s", currencyTo)
+	}
+	return amount.Mul(r.usd), nil
+}
+
+type memMailer struct {
+	dependency.Mailer
+	confirmed []string
+}
+
+func (m *memMailer) SendOrderConfirmation(ctx context.Context, rep dependency.Repository, to string, orderDetails *dto.OrderConfirmed) error {
+	m.confirmed = append(m.confirmed, orderDetails.OrderUUID)
+	return nil
+}
+
+// memPool keeps the expirations with the payments of the orders.
+type memPool struct {
+	order *memOrder
+}
+
+func (p *memPool) AddPaymentExpiration(ctx context.Context, orderUUID string, pmn entity.PaymentMethodName, expireAt time.Time) error {
+	p.order.expirations[orderUUID] = expireAt
+	return nil
+}
+
+// ScheduleAwaitingPayments keeps the expirations, the tests schedule them with the invoices.
+func (p *memPool) ScheduleAwaitingPayments(ctx context.Context, pmn entity.PaymentMethodName, invoiceExpiration time.Duration) error {
+	return nil
+}
+
+func (p *memPool) RemovePaymentExpiration(ctx context.Context, orderUUID string) error {
+	delete(p.order.expirations, orderUUID)
+	return nil
+}
+
+func (p *memPool) Start(ctx context.Context) error {
+	return nil
+}
+
+func TestProcessor(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sim, key := newSimulatedBackend(t)
+	token := deployToken(t, sim, key)
+	otherToken := deployToken(t, sim, key)
+
+	order := newMemOrder(map[string]decimal.Decimal{
+		"paid":     decimal.RequireFromString("10.5"),
+		"expired":  decimal.RequireFromString("20"),
+		"requoted": decimal.RequireFromString("10"),
+	})
+	rep := &memRepository{order: order}
+	rates := &eurRates{usd: decimal.RequireFromString("1.1")}
+	mailer := &memMailer{}
+	pool := &memPool{order: order}
+
+	c := &Config{
+		Mnemonic:                testMnemonic,
+		ContractAddress:         token.Hex(),
+		Decimals:                6,
+		InvoiceExpiration:       time.Hour,
+		CheckIncomingTxInterval: time.Hour,
+		Confirmations:           2,
+	}
+	i, err := New(ctx, c, rep, mailer, sim, rates, pool, entity.USDC_ETH)
+	assert.NoError(t, err)
+	p := i.(*monitor.Monitor)
+
+	_, err = New(ctx, &Config{Mnemonic: testMnemonic, ContractAddress: token.Hex()}, rep, mailer, sim, rates, pool, entity.USDC_ETH)
+	assert.Error(t, err)
+	_, err = New(ctx, c, rep, mailer, sim, rates, pool, entity.USDT_TRON)
+	assert.Error(t, err)
+
+	// invoice is issued to the derived address at the quoted rate, its start block is recorded
+	// and its expiration is scheduled
+	head, err := sim.HeaderByNumber(ctx, nil)
+	assert.NoError(t, err)
+	pi, expiration, err := p.GetOrderInvoice(ctx, "paid")
+	assert.NoError(t, err)
+	// m/44'/60'/0'/0/0
+	assert.Equal(t, "0x9858EfFD232B4033E47d90003D41EC34EcaEda94", pi.Payee.String)
+	assert.True(t, pi.TransactionAmountPaymentCurrency.Equal(decimal.NewFromInt(11550000)), pi.TransactionAmountPaymentCurrency.String())
+	assert.True(t, pi.PaymentCurrencyRate.Decimal.Equal(decimal.RequireFromString("1.1")))
+	assert.Equal(t, "USD", pi.PaymentCurrency.String)
+	assert.Equal(t, "EUR", pi.QuoteSourceCurrency.String)
+	assert.Equal(t, expiration, order.expirations["paid"])
+	assert.Equal(t, sql.NullInt64{Int64: head.Number.Int64(), Valid: true}, order.payments["paid"].StartBlock)
+	payee := common.HexToAddress(pi.Payee.String)
+
+	check := func(orderUUID string) *entity.Payment {
+		payment, err := order.GetPaymentByOrderUUID(ctx, orderUUID)
+		assert.NoError(t, err)
+		updated, err := p.CheckForTransactions(ctx, orderUUID, *payment)
+		assert.NoError(t, err)
+		return updated
+	}
+
+	// transfer of another token is ignored
+	transferToken(t, sim, key, otherToken, payee, 11550000)
+	sim.Commit()
+	sim.Commit()
+	assert.False(t, check("paid").IsTransactionDone)
+	assert.False(t, order.partial["paid"])
+
+	// first transfer is confirmed, the second one has one confirmation of two
+	transferToken(t, sim, key, token, payee, 5000000)
+	sim.Commit()
+	last := transferToken(t, sim, key, token, payee, 7050000)
+	sim.Commit()
+	assert.False(t, check("paid").IsTransactionDone)
+	assert.True(t, order.partial["paid"])
+
+	sim.Commit()
+	paid := check("paid")
+	assert.True(t, paid.IsTransactionDone)
+	assert.Equal(t, last.Hex(), paid.TransactionID.String)
+	if events := order.events["paid"]; assert.NotEmpty(t, events) {
+		seen := events[len(events)-1]
+		assert.Equal(t, entity.PaymentEventTransactionSeen, seen.Type)
+		assert.Equal(t, last.Hex(), seen.TransactionId.String)
+	}
+	assert.True(t, order.overpayments["paid"].Equal(decimal.NewFromInt(500000)), order.overpayments["paid"].String())
+	assert.Equal(t, []string{"paid"}, mailer.confirmed)
+
+	// paid order is not expired
+	done, err := p.ExpirePayment(ctx, rep, "paid")
+	assert.NoError(t, err)
+	assert.False(t, done)
+	assert.False(t, order.expired["paid"])
+
+	// unpaid invoice is expired
+	ei, _, err := p.GetOrderInvoice(ctx, "expired")
+	assert.NoError(t, err)
+	assert.NotEqual(t, payee.Hex(), ei.Payee.String)
+	transferToken(t, sim, key, token, common.HexToAddress(ei.Payee.String), 1000000)
+	sim.Commit()
+	sim.Commit()
+	assert.False(t, check("expired").IsTransactionDone)
+
+	done, err = p.ExpirePayment(ctx, rep, "expired")
+	assert.NoError(t, err)
+	assert.False(t, done)
+	assert.True(t, order.expired["expired"])
+	awaiting, err := order.GetAwaitingPaymentsByPaymentType(ctx, entity.USDC_ETH)
+	assert.NoError(t, err)
+	assert.Empty(t, awaiting)
+
+	// cancelled invoice is not expired by the pool
+	assert.NoError(t, p.CancelMonitorPayment("expired", entity.Payment{}))
+	_, ok := order.expirations["expired"]
+	assert.False(t, ok)
+
+	// new invoice of the order reuses its address, the transfers to the expired invoice are not counted
+	reissued, _, err := p.GetOrderInvoice(ctx, "expired")
+	assert.NoError(t, err)
+	assert.Equal(t, ei.Payee.String, reissued.Payee.String)
+	assert.Contains(t, order.expirations, "expired")
+	sim.Commit()
+	sim.Commit()
+	assert.False(t, check("expired").IsTransactionDone)
+	assert.False(t, order.partial["expired"])
+
+	// the restarted processor looks the transfers up from the start block recorded with the invoice
+	i, err = New(ctx, c, rep, mailer, sim, rates, pool, entity.USDC_ETH)
+	assert.NoError(t, err)
+	restarted := i.(*monitor.Monitor)
+	payment, err := order.GetPaymentByOrderUUID(ctx, "expired")
+	assert.NoError(t, err)
+	assert.True(t, payment.StartBlock.Valid)
+	updated, err := restarted.CheckForTransactions(ctx, "expired", *payment)
+	assert.NoError(t, err)
+	assert.False(t, updated.IsTransactionDone)
+	assert.False(t, order.partial["expired"])
+
+	// expired quote of the unpaid invoice is refreshed at the current rate
+	rq, _, err := p.GetOrderInvoice(ctx, "requoted")
+	assert.NoError(t, err)
+	assert.True(t, rq.TransactionAmountPaymentCurrency.Equal(decimal.NewFromInt(11000000)), rq.TransactionAmountPaymentCurrency.String())
+
+	order.payments["requoted"].QuoteExpiresAt.Time = time.Now().Add(-time.Minute)
+	rates.usd = decimal.RequireFromString("1.25")
+	rq, _, err = p.GetOrderInvoice(ctx, "requoted")
+	assert.NoError(t, err)
+	assert.True(t, rq.TransactionAmountPaymentCurrency.Equal(decimal.NewFromInt(12500000)), rq.TransactionAmountPaymentCurrency.String())
+
+	// expired quote is kept once a transfer is seen
+	order.payments["requoted"].QuoteExpiresAt.Time = time.Now().Add(-time.Minute)
+	rates.usd = decimal.RequireFromString("1.3")
+	transferToken(t, sim, key, token, common.HexToAddress(rq.Payee.String), 12500000)
+	sim.Commit()
+	rq, _, err = p.GetOrderInvoice(ctx, "requoted")
+	assert.NoError(t, err)
+	assert.True(t, rq.TransactionAmountPaymentCurrency.Equal(decimal.NewFromInt(12500000)), rq.TransactionAmountPaymentCurrency.String())
+
+	// invoice paid before its expiration fires is marked as paid instead
+	sim.Commit()
+	done, err = p.ExpirePayment(ctx, rep, "requoted")
+	assert.NoError(t, err)
+	assert.True(t, done)
+	assert.False(t, order.expired["requoted"])
+	assert.True(t, order.payments["requoted"].IsTransactionDone)
+	// the confirmation is left to the expiration pool, it's sent after the expiration commits
+	assert.Equal(t, []string{"paid"}, mailer.confirmed)
+}
@@ -0,0 +1,150 @@
+package erc20
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/jekabolt/grbpwr-manager/internal/dependency"
+	"github.com/jekabolt/grbpwr-manager/internal/payment/monitor"
+	"github.com/shopspring/decimal"
+)
+
+// transferTopic is the topic of the ERC-20 Transfer(address indexed from, address indexed to, uint256 value) event.
+var transferTopic = crypto.Keccak256Hash([]byte("Transfer(address,address,uint256)"))
+
+// transfer is an incoming token transfer found in the event logs.
+type transfer struct {
+	TxHash      string
+	From        string
+	To          string
+	Value       decimal.Decimal // in blockchain format
+	BlockNumber uint64
+}
+
+// receivedTransfers sums the token transfers to the payee logged since the given block.
+// Transfers of other tokens or to other addresses are ignored,
+// transfers with less than the given number of confirmations are counted as pending.
+// The logs are filtered in pages of the given number of blocks.
+func receivedTransfers(ctx context.Context, ec dependency.EthClient, contract common.Address, payee common.Address, fromBlock uint64, confirmations uint64, pageSize uint64) (*monitor.Received, error) {
+	r := &monitor.Received{
+		Confirmed: decimal.Zero,
+		Pending:   decimal.Zero,
+	}
+
+	header, err := ec.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("can't get latest block: %w", err)
+	}
+	head := header.Number.Uint64()
+	if fromBlock > head {
+		return r, nil
+	}
+
+	if confirmations == 0 {
+		confirmations = 1
+	}
+	if pageSize == 0 {
+		pageSize = defaultFilterLogsPageSize
+	}
+
+	for from := fromBlock; from <= head; from += pageSize {
+		to := min(from+pageSize-1, head)
+		logs, err := ec.FilterLogs(ctx, ethereum.FilterQuery{
+			FromBlock: new(big.Int).SetUint64(from),
+			ToBlock:   new(big.Int).SetUint64(to),
+			Addresses: []common.Address{contract},
+			Topics:    [][]common.Hash{{transferTopic}, nil, {common.BytesToHash(payee.Bytes())}},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("can't filter transfer logs from block %d to %d: %w", from, to, err)
+		}
+
+		sort.SliceStable(logs, func(i, j int) bool {
+			if logs[i].BlockNumber != logs[j].BlockNumber {
+				return logs[i].BlockNumber < logs[j].BlockNumber
+			}
+			return logs[i].Index < logs[j].Index
+		})
+
+		for _, l := range logs {
+			tr, ok := parseTransfer(l)
+			if !ok || tr.To != payee.Hex() {
+				continue
+			}
+
+			// The block including the transfer is its first confirmation.
+			if head-tr.BlockNumber+1 < confirmations {
+				r.Pending = r.Pending.Add(tr.Value)
+				continue
+			}
+
+			r.Confirmed = r.Confirmed.Add(tr.Value)
+			r.Last = &monitor.Transfer{
+				TxId:  tr.TxHash,
+				From:  tr.From,
+				To:    tr.To,
+				Value: tr.Value,
+				Data:  tr,
+			}
+		}
+	}
+
+	return r, nil
+}
+
+// parseTransfer parses the Transfer event log, the logs of the reorged blocks
+// and of the events with the same signature but a different layout like ERC-721 Transfer are skipped.
+func parseTransfer(l types.Log) (*transfer, bool) {
+	if l.Removed || len(l.Topics) != 3 || l.Topics[0] != transferTopic || len(l.Data) != 32 {
+		return nil, false
+	}
+
+	value := new(big.Int).SetBytes(l.Data)
+	if value.Sign() <= 0 {
+		slog.Default().Error("can't parse transfer amount",
+			slog.String("txHash", l.TxHash.Hex()),
+			slog.String("value", value.String()),
+		)
+		return nil, false
+	}
+
+	return &transfer{
+		TxHash:      l.TxHash.Hex(),
+		From:        common.BytesToAddress(l.Topics[1].Bytes()).Hex(),
+		To:          common.BytesToAddress(l.Topics[2].Bytes()).Hex(),
+		Value:       decimal.NewFromBigInt(value, 0),
+		BlockNumber: l.BlockNumber,
+	}, true
+}
+
+// blockByTime returns the number of the first block mined at or after the given time,
+// the block following the latest one if there is none yet.
+func blockByTime(ctx context.Context, ec dependency.EthClient, t time.Time) (uint64, error) {
+	head, err := ec.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("can't get latest block: %w", err)
+	}
+
+	lo, hi := uint64(0), head.Number.Uint64()+1
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		h, err := ec.HeaderByNumber(ctx, new(big.Int).SetUint64(mid))
+		if err != nil {
+			return 0, fmt.Errorf("can't get block %d: %w", mid, err)
+		}
+		if time.Unix(int64(h.Time), 0).Before(t) {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	return lo, nil
+}
@@ -0,0 +1,214 @@
+package erc20
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"database/sql"
+	"encoding/hex"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/jekabolt/grbpwr-manager/internal/dependency"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+// tokenCode deploys a token which logs Transfer(msg.sender, to, value) for the calldata of
+// abi encoded (address to, uint256 value) without keeping the balances.
+// Runtime code:
+//
+//	PUSH1 0x20 CALLDATALOAD PUSH1 0x00 MSTORE          // memory[0:32] = value
+//	PUSH1 0x00 CALLDATALOAD CALLER PUSH32 transferTopic // topics
+//	PUSH1 0x20 PUSH1 0x00 LOG3 STOP
+var tokenCode = "60318060" + "0b" + "6000396000f3" +
+	"60203560005260003533" + "7f" + transferTopic.Hex()[2:] + "60206000a300"
+
+func newSimulatedBackend(t *testing.T) (*backends.SimulatedBackend, *ecdsa.PrivateKey) {
+	key, err := crypto.GenerateKey()
+	assert.NoError(t, err)
+
+	alloc := core.GenesisAlloc{
+		crypto.PubkeyToAddress(key.PublicKey): {Balance: new(big.Int).Mul(big.NewInt(100), big.NewInt(params.Ether))},
+	}
+	sim := backends.NewSimulatedBackend(alloc, 8000000)
+	t.Cleanup(func() { sim.Close() })
+	return sim, key
+}
+
+func sendTx(t *testing.T, sim *backends.SimulatedBackend, key *ecdsa.PrivateKey, to *common.Address, data []byte) common.Hash {
+	ctx := context.Background()
+	from := crypto.PubkeyToAddress(key.PublicKey)
+
+	nonce, err := sim.PendingNonceAt(ctx, from)
+	assert.NoError(t, err)
+	gasPrice, err := sim.SuggestGasPrice(ctx)
+	assert.NoError(t, err)
+
+	tx := types.NewTx(&types.LegacyTx{
+		Nonce:    nonce,
+		To:       to,
+		Gas:      200000,
+		GasPrice: gasPrice,
+		Data:     data,
+	})
+	signed, err := types.SignTx(tx, types.LatestSignerForChainID(params.AllDevChainProtocolChanges.ChainID), key)
+	assert.NoError(t, err)
+	assert.NoError(t, sim.SendTransaction(ctx, signed))
+	return signed.Hash()
+}
+
+// deployToken deploys the token and returns its address.
+func deployToken(t *testing.T, sim *backends.SimulatedBackend, key *ecdsa.PrivateKey) common.Address {
+	code, err := hex.DecodeString(tokenCode)
+	assert.NoError(t, err)
+
+	txHash := sendTx(t, sim, key, nil, code)
+	sim.Commit()
+
+	receipt, err := sim.TransactionReceipt(context.Background(), txHash)
+	assert.NoError(t, err)
+	assert.Equal(t, types.ReceiptStatusSuccessful, receipt.Status)
+	return receipt.ContractAddress
+}
+
+// transferToken sends the token transfer, it is included in the block on the next commit.
+func transferToken(t *testing.T, sim *backends.SimulatedBackend, key *ecdsa.PrivateKey, token common.Address, to common.Address, value int64) common.Hash {
+	data := append(common.LeftPadBytes(to.Bytes(), 32), common.LeftPadBytes(big.NewInt(value).Bytes(), 32)...)
+	return sendTx(t, sim, key, &token, data)
+}
+
+func TestReceivedTransfers(t *testing.T) {
+	ctx := context.Background()
+	sim, key := newSimulatedBackend(t)
+
+	token := deployToken(t, sim, key)
+	otherToken := deployToken(t, sim, key)
+	payee := common.HexToAddress("0x00000000000000000000000000000000000000aa")
+	other := common.HexToAddress("0x00000000000000000000000000000000000000bb")
+
+	// transfer made before the invoice is ignored
+	transferToken(t, sim, key, token, payee, 100)
+	sim.Commit()
+
+	head, err := sim.HeaderByNumber(ctx, nil)
+	assert.NoError(t, err)
+	from := head.Number.Uint64() + 1
+
+	transferToken(t, sim, key, otherToken, payee, 1000)
+	transferToken(t, sim, key, token, other, 1000)
+	first := transferToken(t, sim, key, token, payee, 1000)
+	sim.Commit()
+	last := transferToken(t, sim, key, token, payee, 2000)
+	sim.Commit()
+
+	r, err := receivedTransfers(ctx, sim, token, payee, from, 1, defaultFilterLogsPageSize)
+	assert.NoError(t, err)
+	assert.True(t, r.Confirmed.Equal(decimal.NewFromInt(3000)), r.Confirmed.String())
+	assert.True(t, r.Pending.IsZero())
+	if assert.NotNil(t, r.Last) {
+		assert.Equal(t, last.Hex(), r.Last.TxId)
+		assert.Equal(t, crypto.PubkeyToAddress(key.PublicKey).Hex(), r.Last.From)
+		assert.Equal(t, payee.Hex(), r.Last.To)
+	}
+
+	// the latest transfer has one confirmation of two
+	r, err = receivedTransfers(ctx, sim, token, payee, from, 2, defaultFilterLogsPageSize)
+	assert.NoError(t, err)
+	assert.True(t, r.Confirmed.Equal(decimal.NewFromInt(1000)), r.Confirmed.String())
+	assert.True(t, r.Pending.Equal(decimal.NewFromInt(2000)), r.Pending.String())
+	if assert.NotNil(t, r.Last) {
+		assert.Equal(t, first.Hex(), r.Last.TxId)
+	}
+
+	// nothing is logged after the head
+	r, err = receivedTransfers(ctx, sim, token, payee, from+10, 1, defaultFilterLogsPageSize)
+	assert.NoError(t, err)
+	assert.True(t, r.Confirmed.IsZero())
+	assert.Nil(t, r.Last)
+}
+
+// rangeClient records the block ranges the logs are filtered in.
+type rangeClient struct {
+	dependency.EthClient
+	ranges [][2]uint64
+}
+
+func (c *rangeClient) FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error) {
+	c.ranges = append(c.ranges, [2]uint64{q.FromBlock.Uint64(), q.ToBlock.Uint64()})
+	return c.EthClient.FilterLogs(ctx, q)
+}
+
+func TestReceivedTransfersPages(t *testing.T) {
+	ctx := context.Background()
+	sim, key := newSimulatedBackend(t)
+
+	token := deployToken(t, sim, key)
+	payee := common.HexToAddress("0x00000000000000000000000000000000000000aa")
+
+	head, err := sim.HeaderByNumber(ctx, nil)
+	assert.NoError(t, err)
+	from := head.Number.Uint64() + 1
+
+	for i := 0; i < 5; i++ {
+		transferToken(t, sim, key, token, payee, 1000)
+		sim.Commit()
+	}
+	head, err = sim.HeaderByNumber(ctx, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, from+4, head.Number.Uint64())
+
+	ec := &rangeClient{EthClient: sim}
+	r, err := receivedTransfers(ctx, ec, token, payee, from, 1, 2)
+	assert.NoError(t, err)
+	assert.True(t, r.Confirmed.Equal(decimal.NewFromInt(5000)), r.Confirmed.String())
+	assert.Equal(t, [][2]uint64{{from, from + 1}, {from + 2, from + 3}, {from + 4, from + 4}}, ec.ranges)
+	if assert.NotNil(t, r.Last) {
+		assert.Equal(t, payee.Hex(), r.Last.To)
+	}
+
+	// the transfers to the invoice issued without the start block are looked up from the time it was issued at
+	h, err := sim.HeaderByNumber(ctx, new(big.Int).SetUint64(from+3))
+	assert.NoError(t, err)
+	src := &source{contract: token, ec: sim, pageSize: 2}
+	r, err = src.Received(ctx, payee.Hex(), time.Unix(int64(h.Time), 0).Add(time.Minute), sql.NullInt64{}, 1)
+	assert.NoError(t, err)
+	assert.True(t, r.Confirmed.Equal(decimal.NewFromInt(2000)), r.Confirmed.String())
+
+	r, err = src.Received(ctx, payee.Hex(), time.Now(), sql.NullInt64{Int64: int64(from + 2), Valid: true}, 1)
+	assert.NoError(t, err)
+	assert.True(t, r.Confirmed.Equal(decimal.NewFromInt(3000)), r.Confirmed.String())
+}
+
+func TestBlockByTime(t *testing.T) {
+	ctx := context.Background()
+	sim, _ := newSimulatedBackend(t)
+
+	for i := 0; i < 5; i++ {
+		sim.Commit()
+	}
+
+	h, err := sim.HeaderByNumber(ctx, big.NewInt(3))
+	assert.NoError(t, err)
+
+	n, err := blockByTime(ctx, sim, time.Unix(int64(h.Time), 0))
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(3), n)
+
+	n, err = blockByTime(ctx, sim, time.Unix(int64(h.Time)-1, 0))
+	assert.NoError(t, err)
+	assert.LessOrEqual(t, n, uint64(3))
+
+	head, err := sim.HeaderByNumber(ctx, nil)
+	assert.NoError(t, err)
+	n, err = blockByTime(ctx, sim, time.Unix(int64(head.Time)+1, 0))
+	assert.NoError(t, err)
+	assert.Equal(t, head.Number.Uint64()+1, n)
+}
@@ -0,0 +1,186 @@
+package eth
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/jekabolt/grbpwr-manager/internal/dependency"
+	"github.com/jekabolt/grbpwr-manager/internal/payment/monitor"
+	"github.com/shopspring/decimal"
+)
+
+// transfer is an incoming ether transfer found on chain.
+type transfer struct {
+	TxHash      string
+	From        string
+	To          string
+	Value       decimal.Decimal // in wei
+	BlockNumber uint64
+}
+
+// blockByTime returns the number of the first block mined at or after the given time,
+// the block following the latest one if there is none yet.
+func blockByTime(ctx context.Context, ec dependency.EthClient, t time.Time) (uint64, error) {
+	head, err := ec.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("can't get latest block: %w", err)
+	}
+
+	lo, hi := uint64(0), head.Number.Uint64()+1
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		h, err := ec.HeaderByNumber(ctx, new(big.Int).SetUint64(mid))
+		if err != nil {
+			return 0, fmt.Errorf("can't get block %d: %w", mid, err)
+		}
+		if time.Unix(int64(h.Time), 0).Before(t) {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	return lo, nil
+}
+
+// scanKey identifies the scan of the transfers to the payee of the invoice issued at the block.
+type scanKey struct {
+	payee common.Address
+	from  uint64
+}
+
+// scan is the sum of the confirmed transfers to the payee in the blocks scanned so far,
+// the confirmed blocks are final so they are not fetched again.
+type scan struct {
+	next      uint64 // next block to scan
+	confirmed decimal.Decimal
+	last      *monitor.Transfer
+	usedAt    time.Time
+}
+
+// scanTTL is how long the scan of the invoice is kept since it was last used,
+// the scan of an expired or paid invoice is dropped after it.
+const scanTTL = time.Hour
+
+// received sums the transfers to the payee mined since the given block,
+// transfers with less than the given number of confirmations are counted as pending.
+func (s *source) received(ctx context.Context, payee common.Address, from uint64, confirmations uint64) (*monitor.Received, error) {
+	header, err := s.ec.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("can't get latest block: %w", err)
+	}
+	head := header.Number.Uint64()
+
+	// The block including the transfer is its first confirmation.
+	if confirmations == 0 {
+		confirmations = 1
+	}
+	confirmedTo := int64(head) + 1 - int64(confirmations)
+
+	sc := s.getScan(scanKey{payee: payee, from: from})
+
+	for n := sc.next; int64(n) <= confirmedTo; n++ {
+		trs, err := blockTransfers(ctx, s.ec, payee, n)
+		if err != nil {
+			return nil, err
+		}
+		for _, tr := range trs {
+			sc.confirmed = sc.confirmed.Add(tr.Value)
+			sc.last = &monitor.Transfer{
+				TxId:  tr.TxHash,
+				From:  tr.From,
+				To:    tr.To,
+				Value: tr.Value,
+				Data:  tr,
+			}
+		}
+		sc.next = n + 1
+	}
+	s.putScan(scanKey{payee: payee, from: from}, sc)
+
+	r := &monitor.Received{
+		Confirmed: sc.confirmed,
+		Pending:   decimal.Zero,
+		Last:      sc.last,
+	}
+	for n := sc.next; n <= head; n++ {
+		trs, err := blockTransfers(ctx, s.ec, payee, n)
+		if err != nil {
+			return nil, err
+		}
+		for _, tr := range trs {
+			r.Pending = r.Pending.Add(tr.Value)
+		}
+	}
+
+	return r, nil
+}
+
+// getScan returns a copy of the scan of the invoice, a new one starting at the block of the invoice if there is none.
+func (s *source) getScan(key scanKey) scan {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if sc, ok := s.scans[key]; ok {
+		return *sc
+	}
+	return scan{next: key.from, confirmed: decimal.Zero}
+}
+
+// putScan keeps the scan of the invoice unless a concurrent check got further, the unused scans are dropped.
+func (s *source) putScan(key scanKey, sc scan) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for k, old := range s.scans {
+		if now.Sub(old.usedAt) > scanTTL {
+			delete(s.scans, k)
+		}
+	}
+
+	if old, ok := s.scans[key]; ok && old.next > sc.next {
+		old.usedAt = now
+		return
+	}
+	sc.usedAt = now
+	s.scans[key] = &sc
+}
+
+// blockTransfers returns the ether transfers to the payee in the block.
+func blockTransfers(ctx context.Context, ec dependency.EthClient, payee common.Address, n uint64) ([]transfer, error) {
+	block, err := ec.BlockByNumber(ctx, new(big.Int).SetUint64(n))
+	if err != nil {
+		return nil, fmt.Errorf("can't get block %d: %w", n, err)
+	}
+
+	var trs []transfer
+	for _, tx := range block.Transactions() {
+		if tx.To() == nil || *tx.To() != payee || tx.Value().Sign() <= 0 {
+			continue
+		}
+
+		// a transaction with unrecoverable sender is skipped so it doesn't stall the scan of the block
+		sender, err := types.Sender(types.LatestSignerForChainID(tx.ChainId()), tx)
+		if err != nil {
+			slog.Default().WarnContext(ctx, "can't get transaction sender",
+				slog.String("err", err.Error()),
+				slog.String("txHash", tx.Hash().Hex()),
+			)
+			continue
+		}
+
+		trs = append(trs, transfer{
+			TxHash:      tx.Hash().Hex(),
+			From:        sender.Hex(),
+			To:          payee.Hex(),
+			Value:       decimal.NewFromBigInt(tx.Value(), 0),
+			BlockNumber: n,
+		})
+	}
+	return trs, nil
+}
@@ -0,0 +1,133 @@
+package eth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func newSimulatedBackend(t *testing.T) (*backends.SimulatedBackend, *ecdsa.PrivateKey) {
+	key, err := crypto.GenerateKey()
+	assert.NoError(t, err)
+
+	alloc := core.GenesisAlloc{
+		crypto.PubkeyToAddress(key.PublicKey): {Balance: new(big.Int).Mul(big.NewInt(100), big.NewInt(params.Ether))},
+	}
+	sim := backends.NewSimulatedBackend(alloc, 8000000)
+	t.Cleanup(func() { sim.Close() })
+	return sim, key
+}
+
+func sendEther(t *testing.T, sim *backends.SimulatedBackend, key *ecdsa.PrivateKey, to common.Address, value *big.Int) common.Hash {
+	ctx := context.Background()
+	from := crypto.PubkeyToAddress(key.PublicKey)
+
+	nonce, err := sim.PendingNonceAt(ctx, from)
+	assert.NoError(t, err)
+	gasPrice, err := sim.SuggestGasPrice(ctx)
+	assert.NoError(t, err)
+
+	tx := types.NewTx(&types.LegacyTx{
+		Nonce:    nonce,
+		To:       &to,
+		Value:    value,
+		Gas:      21000,
+		GasPrice: gasPrice,
+	})
+	signed, err := types.SignTx(tx, types.LatestSignerForChainID(params.AllDevChainProtocolChanges.ChainID), key)
+	assert.NoError(t, err)
+	assert.NoError(t, sim.SendTransaction(ctx, signed))
+	sim.Commit()
+	return signed.Hash()
+}
+
+func TestReceived(t *testing.T) {
+	ctx := context.Background()
+	sim, key := newSimulatedBackend(t)
+	s := &source{ec: sim, scans: make(map[scanKey]*scan)}
+
+	payee := common.HexToAddress("0x00000000000000000000000000000000000000aa")
+	other := common.HexToAddress("0x00000000000000000000000000000000000000bb")
+	amount := big.NewInt(params.Ether)
+
+	head, err := sim.HeaderByNumber(ctx, nil)
+	assert.NoError(t, err)
+	from := head.Number.Uint64() + 1
+
+	// transfers to other addresses are ignored
+	sendEther(t, sim, key, other, amount)
+	first := sendEther(t, sim, key, payee, big.NewInt(params.GWei))
+
+	r, err := s.received(ctx, payee, from, 3)
+	assert.NoError(t, err)
+	assert.True(t, r.Confirmed.IsZero(), r.Confirmed.String())
+	assert.True(t, r.Pending.Equal(decimal.NewFromInt(params.GWei)), r.Pending.String())
+	assert.Nil(t, r.Last)
+
+	// transfers are summed once confirmed
+	last := sendEther(t, sim, key, payee, amount)
+	sim.Commit()
+
+	r, err = s.received(ctx, payee, from, 3)
+	assert.NoError(t, err)
+	assert.True(t, r.Confirmed.Equal(decimal.NewFromInt(params.GWei)), r.Confirmed.String())
+	assert.True(t, r.Pending.Equal(decimal.NewFromBigInt(amount, 0)), r.Pending.String())
+	if assert.NotNil(t, r.Last) {
+		assert.Equal(t, first.Hex(), r.Last.TxId)
+	}
+
+	sim.Commit()
+	r, err = s.received(ctx, payee, from, 3)
+	assert.NoError(t, err)
+	assert.True(t, r.Confirmed.Equal(decimal.NewFromBigInt(new(big.Int).Add(amount, big.NewInt(params.GWei)), 0)), r.Confirmed.String())
+	assert.True(t, r.Pending.IsZero(), r.Pending.String())
+	if assert.NotNil(t, r.Last) {
+		assert.Equal(t, last.Hex(), r.Last.TxId)
+		assert.Equal(t, crypto.PubkeyToAddress(key.PublicKey).Hex(), r.Last.From)
+		assert.Equal(t, payee.Hex(), r.Last.To)
+	}
+
+	// the confirmed blocks are scanned once
+	head, err = sim.HeaderByNumber(ctx, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, head.Number.Uint64()-1, s.scans[scanKey{payee: payee, from: from}].next)
+
+	// the invoice issued later doesn't count the earlier transfers
+	r, err = s.received(ctx, payee, head.Number.Uint64()+1, 3)
+	assert.NoError(t, err)
+	assert.True(t, r.Confirmed.IsZero(), r.Confirmed.String())
+	assert.True(t, r.Pending.IsZero(), r.Pending.String())
+}
+
+func TestBlockByTime(t *testing.T) {
+	ctx := context.Background()
+	sim, _ := newSimulatedBackend(t)
+
+	for i := 0; i < 5; i++ {
+		sim.Commit()
+	}
+
+	h, err := sim.HeaderByNumber(ctx, big.NewInt(3))
+	assert.NoError(t, err)
+
+	n, err := blockByTime(ctx, sim, time.Unix(int64(h.Time), 0))
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(3), n)
+
+	head, err := sim.HeaderByNumber(ctx, nil)
+	assert.NoError(t, err)
+	n, err = blockByTime(ctx, sim, time.Unix(int64(head.Time)+1, 0))
+	assert.NoError(t, err)
+	assert.Equal(t, head.Number.Uint64()+1, n)
+}
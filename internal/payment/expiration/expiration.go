@@ -0,0 +1,230 @@
+// Package expiration expires the unpaid invoices at their deadlines persisted in the database.
+// An expiration is deleted in the same transaction the invoice is expired in,
+// so it fires once across restarts and app replicas.
+package expiration
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jekabolt/grbpwr-manager/internal/cache"
+	"github.com/jekabolt/grbpwr-manager/internal/dependency"
+	"github.com/jekabolt/grbpwr-manager/internal/dto"
+	"github.com/jekabolt/grbpwr-manager/internal/entity"
+)
+
+type Config struct {
+	// CheckInterval is how often the due expirations are fired
+	CheckInterval time.Duration `mapstructure:"check_interval"`
+	// RetryDelay postpones the expiration which failed to fire
+	RetryDelay time.Duration `mapstructure:"retry_delay"`
+}
+
+const (
+	defaultCheckInterval = 10 * time.Second
+	defaultRetryDelay    = time.Minute
+)
+
+type Pool struct {
+	c          *Config
+	rep        dependency.Repository
+	mailer     dependency.Mailer
+	processors dependency.Processors
+}
+
+// New creates a new pool firing the expirations with the expirers of the processors.
+func New(c *Config, rep dependency.Repository, m dependency.Mailer, processors dependency.Processors) *Pool {
+	if c.CheckInterval <= 0 {
+		c.CheckInterval = defaultCheckInterval
+	}
+	if c.RetryDelay <= 0 {
+		c.RetryDelay = defaultRetryDelay
+	}
+	return &Pool{
+		c:          c,
+		rep:        rep,
+		mailer:     m,
+		processors: processors,
+	}
+}
+
+// AddPaymentExpiration schedules the expiration of the order invoice, an already scheduled expiration is rescheduled.
+func (p *Pool) AddPaymentExpiration(ctx context.Context, orderUUID string, pmn entity.PaymentMethodName, expireAt time.Time) error {
+	pm, ok := cache.GetPaymentMethodByName(pmn)
+	if !ok {
+		return fmt.Errorf("payment method not found: %s", pmn)
+	}
+	err := p.rep.Order().AddPaymentExpiration(ctx, orderUUID, pm.Method.Id, expireAt)
+	if err != nil {
+		return fmt.Errorf("can't add payment expiration: %w", err)
+	}
+	return nil
+}
+
+// ScheduleAwaitingPayments schedules the expiration of the unpaid invoices of the payment method which have none,
+// such an invoice expires the invoice expiration after it was issued. The scheduled deadlines are kept.
+func (p *Pool) ScheduleAwaitingPayments(ctx context.Context, pmn entity.PaymentMethodName, invoiceExpiration time.Duration) error {
+	pm, ok := cache.GetPaymentMethodByName(pmn)
+	if !ok {
+		return fmt.Errorf("payment method not found: %s", pmn)
+	}
+	poids, err := p.rep.Order().GetAwaitingPaymentsByPaymentType(ctx, pmn)
+	if err != nil {
+		return fmt.Errorf("can't get unpaid orders: %w", err)
+	}
+	for _, poid := range poids {
+		err := p.rep.Order().AddMissingPaymentExpiration(ctx, poid.OrderUUID, pm.Method.Id, poid.Payment.ModifiedAt.Add(invoiceExpiration))
+		if err != nil {
+			return fmt.Errorf("can't add payment expiration: %w", err)
+		}
+	}
+	return nil
+}
+
+// RemovePaymentExpiration cancels the scheduled expiration of the order invoice.
+func (p *Pool) RemovePaymentExpiration(ctx context.Context, orderUUID string) error {
+	err := p.rep.Order().RemovePaymentExpiration(ctx, orderUUID)
+	if err != nil {
+		return fmt.Errorf("can't remove payment expiration: %w", err)
+	}
+	return nil
+}
+
+// Start starts the worker firing the due expirations.
+func (p *Pool) Start(ctx context.Context) error {
+	go p.worker(ctx)
+	return nil
+}
+
+func (p *Pool) worker(ctx context.Context) {
+	ticker := time.NewTicker(p.c.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := p.expireDue(ctx); err != nil {
+				slog.Default().ErrorContext(ctx, "can't expire due payments",
+					slog.String("err", err.Error()),
+				)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// expireDue fires the due expirations until none is left.
+func (p *Pool) expireDue(ctx context.Context) error {
+	for {
+		pe, err := p.expireNext(ctx)
+		if err != nil {
+			return err
+		}
+		if pe == nil {
+			return nil
+		}
+	}
+}
+
+// expireNext fires the earliest due expiration, it returns nil if there is none.
+// The expiration which failed to fire is postponed by the retry delay.
+func (p *Pool) expireNext(ctx context.Context) (*entity.PaymentExpiration, error) {
+	var pe *entity.PaymentExpiration
+	var paid bool
+	var expireErr error
+
+	err := p.rep.Tx(ctx, func(ctx context.Context, rep dependency.Repository) error {
+		var err error
+		pe, err = rep.Order().ClaimDuePaymentExpiration(ctx)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				pe = nil
+				return nil
+			}
+			return fmt.Errorf("can't claim due payment expiration: %w", err)
+		}
+
+		paid, expireErr = p.expire(ctx, rep, pe)
+		if expireErr != nil {
+			// roll back the changes made by the expirer
+			return expireErr
+		}
+
+		err = rep.Order().DeletePaymentExpiration(ctx, pe.Id)
+		if err != nil {
+			return fmt.Errorf("can't delete payment expiration: %w", err)
+		}
+		return nil
+	})
+	if expireErr != nil {
+		slog.Default().ErrorContext(ctx, "can't expire order payment",
+			slog.String("err", expireErr.Error()),
+			slog.String("orderUUID", pe.OrderUUID),
+			slog.String("paymentMethod", string(pe.PaymentMethodName)),
+		)
+		err = p.rep.Order().DelayPaymentExpiration(ctx, pe.Id, p.c.RetryDelay)
+		if err != nil {
+			return nil, fmt.Errorf("can't delay payment expiration: %w", err)
+		}
+		return pe, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if paid {
+		// the order is confirmed, the expiration is done even if the confirmation can't be sent
+		if err := p.sendOrderConfirmation(ctx, pe.OrderUUID); err != nil {
+			slog.Default().ErrorContext(ctx, "can't send order confirmation",
+				slog.String("err", err.Error()),
+				slog.String("orderUUID", pe.OrderUUID),
+			)
+		}
+	}
+	return pe, nil
+}
+
+// expire expires the invoice with the expirer of its payment method,
+// it returns true if the invoice was found paid instead.
+func (p *Pool) expire(ctx context.Context, rep dependency.Repository, pe *entity.PaymentExpiration) (bool, error) {
+	e, ok := p.processors.Expirer(pe.PaymentMethodName)
+	if !ok {
+		return false, fmt.Errorf("payment method has no expirer: %s", pe.PaymentMethodName)
+	}
+	paid, err := e.ExpirePayment(ctx, rep, pe.OrderUUID)
+	if err != nil {
+		return false, err
+	}
+
+	if paid {
+		slog.Default().InfoContext(ctx, "order paid before payment expired",
+			slog.String("orderUUID", pe.OrderUUID),
+			slog.String("paymentMethod", string(pe.PaymentMethodName)),
+		)
+		return true, nil
+	}
+	slog.Default().InfoContext(ctx, "order payment expired",
+		slog.String("orderUUID", pe.OrderUUID),
+		slog.String("paymentMethod", string(pe.PaymentMethodName)),
+	)
+	return false, nil
+}
+
+// sendOrderConfirmation sends the confirmation of the order paid before its invoice expired.
+func (p *Pool) sendOrderConfirmation(ctx context.Context, orderUUID string) error {
+	of, err := p.rep.Order().GetOrderFullByUUID(ctx, orderUUID)
+	if err != nil {
+		return fmt.Errorf("can't get order by uuid: %w", err)
+	}
+
+	err = p.mailer.SendOrderConfirmation(ctx, p.rep, of.Buyer.Email, dto.OrderFullToOrderConfirmed(of))
+	if err != nil {
+		return fmt.Errorf("can't send order confirmation: %w", err)
+	}
+	return nil
+}
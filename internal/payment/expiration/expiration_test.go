@@ -0,0 +1,146 @@
+package expiration
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jekabolt/grbpwr-manager/internal/dependency"
+	"github.com/jekabolt/grbpwr-manager/internal/dto"
+	"github.com/jekabolt/grbpwr-manager/internal/entity"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeOrder keeps the scheduled expirations in memory, every due expiration is claimed once per call.
+type fakeOrder struct {
+	dependency.Order
+	expirations map[int]*entity.PaymentExpiration
+	claimed     map[int]bool
+}
+
+func (f *fakeOrder) ClaimDuePaymentExpiration(ctx context.Context) (*entity.PaymentExpiration, error) {
+	var due *entity.PaymentExpiration
+	for _, pe := range f.expirations {
+		if f.claimed[pe.Id] || pe.ExpireAt.After(time.Now()) {
+			continue
+		}
+		if due == nil || pe.ExpireAt.Before(due.ExpireAt) {
+			due = pe
+		}
+	}
+	if due == nil {
+		return nil, fmt.Errorf("no due expiration: %w", sql.ErrNoRows)
+	}
+	f.claimed[due.Id] = true
+	return due, nil
+}
+
+func (f *fakeOrder) DeletePaymentExpiration(ctx context.Context, id int) error {
+	delete(f.expirations, id)
+	return nil
+}
+
+func (f *fakeOrder) DelayPaymentExpiration(ctx context.Context, id int, delay time.Duration) error {
+	if pe, ok := f.expirations[id]; ok {
+		pe.ExpireAt = time.Now().Add(delay)
+	}
+	return nil
+}
+
+func (f *fakeOrder) GetOrderFullByUUID(ctx context.Context, orderUUID string) (*entity.OrderFull, error) {
+	return &entity.OrderFull{
+		Order: entity.Order{UUID: orderUUID},
+		Buyer: entity.Buyer{BuyerInsert: entity.BuyerInsert{Email: orderUUID + "@example.com"}},
+	}, nil
+}
+
+type fakeRepository struct {
+	dependency.Repository
+	order *fakeOrder
+}
+
+func (r *fakeRepository) Order() dependency.Order {
+	return r.order
+}
+
+func (r *fakeRepository) Tx(ctx context.Context, f func(context.Context, dependency.Repository) error) error {
+	return f(ctx, r)
+}
+
+// fakeExpirer records the expired orders, fails the orders in fail and reports the orders in paid as paid.
+type fakeExpirer struct {
+	expired []string
+	fail    map[string]bool
+	paid    map[string]bool
+}
+
+func (e *fakeExpirer) ExpirePayment(ctx context.Context, rep dependency.Repository, orderUUID string) (bool, error) {
+	if e.fail[orderUUID] {
+		return false, fmt.Errorf("can't expire %s", orderUUID)
+	}
+	e.expired = append(e.expired, orderUUID)
+	return e.paid[orderUUID], nil
+}
+
+// fakeMailer records the confirmed orders.
+type fakeMailer struct {
+	dependency.Mailer
+	confirmed []string
+}
+
+func (m *fakeMailer) SendOrderConfirmation(ctx context.Context, rep dependency.Repository, to string, orderDetails *dto.OrderConfirmed) error {
+	m.confirmed = append(m.confirmed, orderDetails.OrderUUID)
+	return nil
+}
+
+type fakeProcessors struct {
+	dependency.Processors
+	expirers map[entity.PaymentMethodName]dependency.PaymentExpirer
+}
+
+func (p *fakeProcessors) Expirer(pmn entity.PaymentMethodName) (dependency.PaymentExpirer, bool) {
+	e, ok := p.expirers[pmn]
+	return e, ok
+}
+
+func TestExpireDue(t *testing.T) {
+	now := time.Now()
+	order := &fakeOrder{
+		expirations: map[int]*entity.PaymentExpiration{
+			1: {Id: 1, OrderUUID: "second", PaymentMethodName: entity.USDT_TRON, ExpireAt: now.Add(-time.Minute)},
+			2: {Id: 2, OrderUUID: "first", PaymentMethodName: entity.CARD, ExpireAt: now.Add(-time.Hour)},
+			3: {Id: 3, OrderUUID: "not_due", PaymentMethodName: entity.CARD, ExpireAt: now.Add(time.Hour)},
+			4: {Id: 4, OrderUUID: "failing", PaymentMethodName: entity.CARD, ExpireAt: now.Add(-time.Minute)},
+			5: {Id: 5, OrderUUID: "no_expirer", PaymentMethodName: entity.ETH, ExpireAt: now.Add(-time.Minute)},
+			6: {Id: 6, OrderUUID: "paid", PaymentMethodName: entity.USDT_TRON, ExpireAt: now.Add(-time.Second)},
+		},
+		claimed: map[int]bool{},
+	}
+	expirer := &fakeExpirer{fail: map[string]bool{"failing": true}, paid: map[string]bool{"paid": true}}
+	mailer := &fakeMailer{}
+	p := New(&Config{RetryDelay: time.Hour}, &fakeRepository{order: order}, mailer, &fakeProcessors{
+		expirers: map[entity.PaymentMethodName]dependency.PaymentExpirer{
+			entity.CARD:      expirer,
+			entity.USDT_TRON: expirer,
+		},
+	})
+
+	assert.NoError(t, p.expireDue(context.Background()))
+	assert.Equal(t, []string{"first", "second", "paid"}, expirer.expired)
+
+	// the order found paid is confirmed once its expiration is done
+	assert.Equal(t, []string{"paid"}, mailer.confirmed)
+
+	// the failed expirations are kept and postponed
+	assert.Len(t, order.expirations, 3)
+	assert.True(t, order.expirations[4].ExpireAt.After(now.Add(time.Minute)))
+	assert.True(t, order.expirations[5].ExpireAt.After(now.Add(time.Minute)))
+
+	// fired expirations are gone, nothing is due anymore
+	order.claimed = map[int]bool{}
+	assert.NoError(t, p.expireDue(context.Background()))
+	assert.Equal(t, []string{"first", "second", "paid"}, expirer.expired)
+	assert.Equal(t, []string{"paid"}, mailer.confirmed)
+}
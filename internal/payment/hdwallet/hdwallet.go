@@ -0,0 +1,230 @@
+// Package hdwallet derives the public keys of the deposit addresses from a BIP32 extended public key,
+// the private keys never leave the wallet holding the funds.
+package hdwallet
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/mr-tron/base58"
+	"github.com/tyler-smith/go-bip39"
+)
+
+const (
+	// hardenedKeyStart is the index of the first hardened child key
+	hardenedKeyStart = uint32(0x80000000)
+
+	serializedKeyLen = 78
+)
+
+// extendedKey is a BIP32 extended public key.
+type extendedKey struct {
+	pubKey    []byte // compressed
+	chainCode []byte
+}
+
+// Wallet derives the keys from the extended public key of the external chain,
+// the key for the derivation index i is the key at <path>/i.
+type Wallet struct {
+	key *extendedKey
+}
+
+// New creates a wallet either from the extended public key of the
+// external chain or from the mnemonic and the derivation path of the external chain.
+func New(xpub, mnemonic, path string) (*Wallet, error) {
+	switch {
+	case xpub != "":
+		key, err := parseExtendedPubKey(xpub)
+		if err != nil {
+			return nil, fmt.Errorf("can't parse xpub: %w", err)
+		}
+		return &Wallet{key: key}, nil
+	case mnemonic != "":
+		seed, err := bip39.NewSeedWithErrorChecking(mnemonic, "")
+		if err != nil {
+			return nil, fmt.Errorf("can't create seed from mnemonic: %w", err)
+		}
+		key, err := deriveExtendedPubKey(seed, path)
+		if err != nil {
+			return nil, fmt.Errorf("can't derive key for path %s: %w", path, err)
+		}
+		return &Wallet{key: key}, nil
+	default:
+		return nil, fmt.Errorf("either xpub or mnemonic must be set")
+	}
+}
+
+// PublicKey returns the public key for the given derivation index.
+func (w *Wallet) PublicKey(index uint32) (*ecdsa.PublicKey, error) {
+	child, err := w.key.child(index)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := crypto.DecompressPubkey(child.pubKey)
+	if err != nil {
+		return nil, fmt.Errorf("can't decompress public key: %w", err)
+	}
+	return pub, nil
+}
+
+// child derives the non-hardened child public key (BIP32 CKDpub).
+func (k *extendedKey) child(index uint32) (*extendedKey, error) {
+	if index >= hardenedKeyStart {
+		return nil, fmt.Errorf("can't derive hardened key from public key")
+	}
+
+	data := make([]byte, 0, len(k.pubKey)+4)
+	data = append(data, k.pubKey...)
+	data = binary.BigEndian.AppendUint32(data, index)
+	il, ir := hmacSHA512(k.chainCode, data)
+
+	curve := crypto.S256()
+	ilNum := new(big.Int).SetBytes(il)
+	if ilNum.Cmp(curve.Params().N) >= 0 {
+		return nil, fmt.Errorf("invalid child key for index %d", index)
+	}
+
+	pub, err := crypto.DecompressPubkey(k.pubKey)
+	if err != nil {
+		return nil, fmt.Errorf("can't decompress public key: %w", err)
+	}
+	ilx, ily := curve.ScalarBaseMult(il)
+	x, y := curve.Add(ilx, ily, pub.X, pub.Y)
+	if x.Sign() == 0 && y.Sign() == 0 {
+		return nil, fmt.Errorf("invalid child key for index %d", index)
+	}
+	pub.X, pub.Y = x, y
+
+	return &extendedKey{
+		pubKey:    crypto.CompressPubkey(pub),
+		chainCode: ir,
+	}, nil
+}
+
+// deriveExtendedPubKey derives the extended public key for the path from the seed (BIP32 CKDpriv).
+func deriveExtendedPubKey(seed []byte, path string) (*extendedKey, error) {
+	indexes, err := parseDerivationPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	n := crypto.S256().Params().N
+	key, chainCode := hmacSHA512([]byte("Bitcoin seed"), seed)
+
+	for _, index := range indexes {
+		data := make([]byte, 0, 37)
+		if index >= hardenedKeyStart {
+			data = append(data, 0x00)
+			data = append(data, key...)
+		} else {
+			priv, err := crypto.ToECDSA(key)
+			if err != nil {
+				return nil, err
+			}
+			data = append(data, crypto.CompressPubkey(&priv.PublicKey)...)
+		}
+		data = binary.BigEndian.AppendUint32(data, index)
+
+		il, ir := hmacSHA512(chainCode, data)
+		ilNum := new(big.Int).SetBytes(il)
+		if ilNum.Cmp(n) >= 0 {
+			return nil, fmt.Errorf("invalid child key for index %d", index)
+		}
+		childKey := ilNum.Add(ilNum, new(big.Int).SetBytes(key))
+		childKey.Mod(childKey, n)
+		if childKey.Sign() == 0 {
+			return nil, fmt.Errorf("invalid child key for index %d", index)
+		}
+
+		key = make([]byte, 32)
+		childKey.FillBytes(key)
+		chainCode = ir
+	}
+
+	priv, err := crypto.ToECDSA(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &extendedKey{
+		pubKey:    crypto.CompressPubkey(&priv.PublicKey),
+		chainCode: chainCode,
+	}, nil
+}
+
+// parseDerivationPath parses path like m/44'/195'/0'/0 into child indexes.
+func parseDerivationPath(path string) ([]uint32, error) {
+	parts := strings.Split(strings.TrimSpace(path), "/")
+	if len(parts) == 0 || parts[0] != "m" {
+		return nil, fmt.Errorf("invalid derivation path: %s", path)
+	}
+
+	indexes := make([]uint32, 0, len(parts)-1)
+	for _, part := range parts[1:] {
+		hardened := strings.HasSuffix(part, "'") || strings.HasSuffix(part, "h")
+		part = strings.TrimRight(part, "'h")
+		i, err := strconv.ParseUint(part, 10, 32)
+		if err != nil || uint32(i) >= hardenedKeyStart {
+			return nil, fmt.Errorf("invalid derivation path component: %s", part)
+		}
+		index := uint32(i)
+		if hardened {
+			index += hardenedKeyStart
+		}
+		indexes = append(indexes, index)
+	}
+	return indexes, nil
+}
+
+// parseExtendedPubKey parses base58 serialized extended public key.
+func parseExtendedPubKey(xpub string) (*extendedKey, error) {
+	raw, err := base58.Decode(xpub)
+	if err != nil {
+		return nil, fmt.Errorf("can't decode base58: %w", err)
+	}
+	if len(raw) != serializedKeyLen+4 {
+		return nil, fmt.Errorf("invalid extended key length: %d", len(raw))
+	}
+
+	payload, checksum := raw[:serializedKeyLen], raw[serializedKeyLen:]
+	if !bytes.Equal(doubleSHA256(payload)[:4], checksum) {
+		return nil, fmt.Errorf("invalid extended key checksum")
+	}
+
+	// version(4) | depth(1) | parent fingerprint(4) | child number(4) | chain code(32) | key(33)
+	chainCode := payload[13:45]
+	pubKey := payload[45:78]
+	if pubKey[0] != 0x02 && pubKey[0] != 0x03 {
+		return nil, fmt.Errorf("extended key is not a public key")
+	}
+	if _, err := crypto.DecompressPubkey(pubKey); err != nil {
+		return nil, fmt.Errorf("invalid public key: %w", err)
+	}
+
+	return &extendedKey{
+		pubKey:    bytes.Clone(pubKey),
+		chainCode: bytes.Clone(chainCode),
+	}, nil
+}
+
+func hmacSHA512(key, data []byte) ([]byte, []byte) {
+	mac := hmac.New(sha512.New, key)
+	mac.Write(data)
+	sum := mac.Sum(nil)
+	return sum[:32], sum[32:]
+}
+
+func doubleSHA256(b []byte) []byte {
+	first := sha256.Sum256(b)
+	second := sha256.Sum256(first[:])
+	return second[:]
+}
@@ -0,0 +1,86 @@
+package hdwallet
+
+import (
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+// BIP32 test vector 1
+const (
+	testSeed   = "000102030405060708090a0b0c0d0e0f"
+	testXpub0H = "xpub68Gmy5EdvgibQVfPdqkBBCHxA5htiqg55crXYuXoQRKfDBFA1WEjWgP6LHhwBZeNK1VTsfTFUHCdrfp1bgwQ9xv5ski8PX9rL2dZXvgGDnw"
+)
+
+func TestDeriveExtendedPubKey(t *testing.T) {
+	seed, err := hex.DecodeString(testSeed)
+	assert.NoError(t, err)
+
+	derived, err := deriveExtendedPubKey(seed, "m/0'")
+	assert.NoError(t, err)
+
+	parsed, err := parseExtendedPubKey(testXpub0H)
+	assert.NoError(t, err)
+
+	assert.Equal(t, parsed.pubKey, derived.pubKey)
+	assert.Equal(t, parsed.chainCode, derived.chainCode)
+}
+
+func TestExtendedKeyChild(t *testing.T) {
+	parent, err := parseExtendedPubKey(testXpub0H)
+	assert.NoError(t, err)
+
+	child, err := parent.child(1)
+	assert.NoError(t, err)
+
+	// public derivation matches the private one
+	seed, err := hex.DecodeString(testSeed)
+	assert.NoError(t, err)
+	expected, err := deriveExtendedPubKey(seed, "m/0'/1")
+	assert.NoError(t, err)
+
+	assert.Equal(t, expected.pubKey, child.pubKey)
+	assert.Equal(t, expected.chainCode, child.chainCode)
+
+	_, err = parent.child(hardenedKeyStart)
+	assert.Error(t, err)
+}
+
+func TestWalletPublicKey(t *testing.T) {
+	seed, err := hex.DecodeString(testSeed)
+	assert.NoError(t, err)
+
+	fromXpub, err := New(testXpub0H, "", "")
+	assert.NoError(t, err)
+
+	// the keys derived from the xpub match the private derivation
+	for i := uint32(0); i < 3; i++ {
+		pub, err := fromXpub.PublicKey(i)
+		assert.NoError(t, err)
+
+		expected, err := deriveExtendedPubKey(seed, fmt.Sprintf("m/0'/%d", i))
+		assert.NoError(t, err)
+		assert.Equal(t, expected.pubKey, crypto.CompressPubkey(pub))
+	}
+
+	mnemonic := "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"
+	fromMnemonic, err := New("", mnemonic, "m/44'/60'/0'/0")
+	assert.NoError(t, err)
+
+	// m/44'/60'/0'/0/0
+	pub, err := fromMnemonic.PublicKey(0)
+	assert.NoError(t, err)
+	assert.Equal(t, "0x9858EfFD232B4033E47d90003D41EC34EcaEda94", crypto.PubkeyToAddress(*pub).Hex())
+
+	_, err = New("", "", "")
+	assert.Error(t, err)
+
+	_, err = New("", mnemonic, "")
+	assert.Error(t, err)
+
+	_, err = parseDerivationPath("44'/195'")
+	assert.Error(t, err)
+}
@@ -0,0 +1,544 @@
+// Package monitor issues the crypto invoices and watches the transfers to them.
+// Every order is invoiced to its own deposit address derived from the HD wallet of the payment method,
+// the chain specific part, deriving the addresses and finding the transfers to them, is the TransferSource.
+package monitor
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jekabolt/grbpwr-manager/internal/dependency"
+	"github.com/jekabolt/grbpwr-manager/internal/dto"
+	"github.com/jekabolt/grbpwr-manager/internal/entity"
+	"github.com/shopspring/decimal"
+)
+
+type Config struct {
+	InvoiceExpiration time.Duration
+	// QuoteExpiration is how long the invoice amount is locked at the quoted rate, the invoice expiration if zero
+	QuoteExpiration         time.Duration
+	CheckIncomingTxInterval time.Duration
+	// Confirmations is the number of blocks required to settle a transfer, the block including it counts as the first
+	Confirmations uint64
+	// Decimals of the token
+	Decimals int
+	// Currency the invoice is quoted in, USD if empty as the stablecoins are pegged to it
+	Currency dto.CurrencyTicker
+	// QuoteDecimals is the precision of the quoted amount, 2 if zero
+	QuoteDecimals int32
+}
+
+const (
+	defaultCheckIncomingTxInterval = time.Minute
+	defaultQuoteDecimals           = 2
+)
+
+// Transfer is an incoming token transfer to the deposit address.
+type Transfer struct {
+	TxId  string
+	From  string
+	To    string
+	Value decimal.Decimal // in blockchain format
+	// Data is the chain data of the transfer recorded with the transaction seen event
+	Data any
+}
+
+// Received is the sum of the incoming transfers to the invoice address.
+type Received struct {
+	// Confirmed is the sum of the transfers with enough confirmations in blockchain format
+	Confirmed decimal.Decimal
+	// Pending is the sum of the transfers waiting for confirmations in blockchain format
+	Pending decimal.Decimal
+	// Last is the latest confirmed transfer
+	Last *Transfer
+}
+
+// TransferSource derives the deposit addresses on the chain and finds the token transfers to them.
+type TransferSource interface {
+	// Address derives the deposit address at the index of the wallet.
+	Address(index uint32) (string, error)
+	// StartBlock returns the block the transfers to the invoice issued now are looked up from,
+	// false if the transfers are looked up by the time the invoice was issued at.
+	StartBlock(ctx context.Context) (uint64, bool, error)
+	// Received sums the transfers to the payee made since the invoice was issued,
+	// the start block is not valid if none was recorded with the invoice.
+	Received(ctx context.Context, payee string, issuedAt time.Time, startBlock sql.NullInt64, confirmations uint64) (*Received, error)
+}
+
+type Monitor struct {
+	c      *Config
+	pm     entity.PaymentMethod
+	src    TransferSource
+	rep    dependency.Repository
+	mailer dependency.Mailer
+	rates  dependency.RatesService
+	pool   dependency.PaymentPool
+}
+
+// New creates the monitor of the invoices of the payment method and starts checking the transfers to them.
+func New(ctx context.Context, c *Config, pm entity.PaymentMethod, src TransferSource, rep dependency.Repository, m dependency.Mailer, r dependency.RatesService, pool dependency.PaymentPool) (*Monitor, error) {
+	if c.Decimals <= 0 {
+		return nil, fmt.Errorf("token decimals must be set")
+	}
+
+	p := &Monitor{
+		c:      c,
+		pm:     pm,
+		src:    src,
+		rep:    rep,
+		mailer: m,
+		rates:  r,
+		pool:   pool,
+	}
+
+	if p.c.CheckIncomingTxInterval <= 0 {
+		p.c.CheckIncomingTxInterval = defaultCheckIncomingTxInterval
+	}
+	if p.c.QuoteExpiration <= 0 {
+		p.c.QuoteExpiration = p.c.InvoiceExpiration
+	}
+	if p.c.Currency == "" {
+		p.c.Currency = dto.USD
+	}
+	if p.c.QuoteDecimals <= 0 {
+		p.c.QuoteDecimals = defaultQuoteDecimals
+	}
+
+	err := p.pool.ScheduleAwaitingPayments(ctx, p.pm.Name, p.c.InvoiceExpiration)
+	if err != nil {
+		return nil, fmt.Errorf("can't schedule unpaid orders: %w", err)
+	}
+
+	go p.transactionsWorker(ctx)
+
+	return p, nil
+}
+
+// transactionsWorker periodically checks the transfers to the addresses of the unpaid invoices.
+func (p *Monitor) transactionsWorker(ctx context.Context) {
+	ticker := time.NewTicker(p.c.CheckIncomingTxInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := p.checkUnpaidOrders(ctx); err != nil {
+				slog.Default().ErrorContext(ctx, "can't check unpaid orders",
+					slog.String("err", err.Error()),
+				)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (p *Monitor) checkUnpaidOrders(ctx context.Context) error {
+	poids, err := p.rep.Order().GetAwaitingPaymentsByPaymentType(ctx, p.pm.Name)
+	if err != nil {
+		return fmt.Errorf("can't get unpaid orders: %w", err)
+	}
+
+	for _, poid := range poids {
+		if !poid.Payment.Payee.Valid {
+			continue
+		}
+		_, err := p.CheckForTransactions(ctx, poid.OrderUUID, poid.Payment)
+		if err != nil {
+			slog.Default().ErrorContext(ctx, "error during transaction check",
+				slog.String("err", err.Error()),
+				slog.String("orderUUID", poid.OrderUUID),
+			)
+		}
+	}
+
+	return nil
+}
+
+// ExpirePayment expires the unpaid invoice of the order, the transfers are checked one last time before
+// and the order is marked as paid instead if it was paid in the meantime. The expiration is retried if the check fails.
+func (p *Monitor) ExpirePayment(ctx context.Context, rep dependency.Repository, orderUUID string) (bool, error) {
+	payment, err := rep.Order().GetPaymentByOrderUUID(ctx, orderUUID)
+	if err != nil {
+		return false, fmt.Errorf("can't get payment by order id: %w", err)
+	}
+
+	updated, paid, err := p.checkForTransactions(ctx, rep, orderUUID, *payment)
+	if err != nil {
+		return false, fmt.Errorf("can't check for transactions: %w", err)
+	}
+	if updated.IsTransactionDone {
+		return paid, nil
+	}
+
+	_, err = rep.Order().ExpireOrderPayment(ctx, orderUUID)
+	if err != nil {
+		return false, fmt.Errorf("can't expire order payment: %w", err)
+	}
+	return false, nil
+}
+
+// getOrderAddress returns the deposit address of the order,
+// the address is derived on the first invoice and reused afterwards.
+func (p *Monitor) getOrderAddress(ctx context.Context, orderUUID string) (string, error) {
+	var addr string
+	err := p.rep.Tx(ctx, func(ctx context.Context, rep dependency.Repository) error {
+		pa, err := rep.Order().GetPaymentAddressByOrderUUID(ctx, orderUUID, p.pm.Id)
+		if err == nil {
+			addr = pa.Address
+			return nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("can't get payment address: %w", err)
+		}
+
+		index, err := rep.Order().NextDerivationIndex(ctx, p.pm.Id)
+		if err != nil {
+			return fmt.Errorf("can't get next derivation index: %w", err)
+		}
+
+		addr, err = p.src.Address(uint32(index))
+		if err != nil {
+			return fmt.Errorf("can't derive address: %w", err)
+		}
+
+		err = rep.Order().AddPaymentAddress(ctx, orderUUID, &entity.PaymentAddressInsert{
+			PaymentMethodId: p.pm.Id,
+			DerivationIndex: index,
+			Address:         addr,
+		})
+		if err != nil {
+			return fmt.Errorf("can't add payment address: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return addr, nil
+}
+
+// GetOrderInvoice returns the payment details for the given order and expiration date.
+func (p *Monitor) GetOrderInvoice(ctx context.Context, orderUUID string) (*entity.PaymentInsert, time.Time, error) {
+	expiration := time.Now()
+
+	payment, err := p.rep.Order().GetPaymentByOrderUUID(ctx, orderUUID)
+	if err != nil {
+		return nil, expiration, fmt.Errorf("can't get payment by order id: %w", err)
+	}
+
+	// If the payment is already done, return it immediately.
+	if payment.IsTransactionDone {
+		expiration = payment.ModifiedAt
+		return &payment.PaymentInsert, expiration, nil
+	}
+
+	// Order has unexpired invoice, return it.
+	if payment.Payee.Valid && payment.Payee.String != "" {
+		expiration = payment.ModifiedAt.Add(p.c.InvoiceExpiration)
+		if quoteExpired(payment, time.Now()) {
+			payment, err = p.requote(ctx, orderUUID, payment)
+			if err != nil {
+				return nil, expiration, fmt.Errorf("can't re-quote invoice: %w", err)
+			}
+		}
+		return &payment.PaymentInsert, expiration, nil
+	}
+
+	startBlock, byBlock, err := p.src.StartBlock(ctx)
+	if err != nil {
+		return nil, expiration, fmt.Errorf("can't get start block: %w", err)
+	}
+
+	// If the payment is not done and the address is not set, generate a new invoice.
+	pAddr, err := p.getOrderAddress(ctx, orderUUID)
+	if err != nil {
+		return nil, expiration, fmt.Errorf("can't get order address: %w", err)
+	}
+
+	// the invoice, its quote, start block and expiration are issued together
+	var invoiceErr error
+	err = p.rep.Tx(ctx, func(ctx context.Context, rep dependency.Repository) error {
+		var of *entity.OrderFull
+		of, invoiceErr = rep.Order().InsertCryptoInvoice(ctx, orderUUID, pAddr, p.pm)
+		if errors.Is(invoiceErr, entity.ErrOrderNotInvoiced) {
+			// keep the changes made to the order instead of the invoice
+			return nil
+		}
+		if invoiceErr != nil {
+			return fmt.Errorf("can't insert order invoice: %w", invoiceErr)
+		}
+		q, err := p.quote(of.Order.AmountDue())
+		if err != nil {
+			return fmt.Errorf("can't quote order total: %w", err)
+		}
+
+		err = rep.Order().UpdatePaymentQuote(ctx, orderUUID, q)
+		if err != nil {
+			return fmt.Errorf("can't update payment quote: %w", err)
+		}
+
+		if byBlock {
+			err = rep.Order().UpdatePaymentStartBlock(ctx, orderUUID, startBlock)
+			if err != nil {
+				return fmt.Errorf("can't update payment start block: %w", err)
+			}
+		}
+
+		// Reload the payment to get the time the invoice was issued at.
+		payment, err = rep.Order().GetPaymentByOrderUUID(ctx, orderUUID)
+		if err != nil {
+			return fmt.Errorf("can't get payment by order id: %w", err)
+		}
+		expiration = payment.ModifiedAt.Add(p.c.InvoiceExpiration)
+
+		err = rep.Order().AddPaymentExpiration(ctx, orderUUID, p.pm.Id, expiration)
+		if err != nil {
+			return fmt.Errorf("can't add payment expiration: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, expiration, err
+	}
+	if invoiceErr != nil {
+		return nil, expiration, fmt.Errorf("can't insert order invoice: %w", invoiceErr)
+	}
+
+	return &payment.PaymentInsert, expiration, nil
+}
+
+// quote converts the order total to the token amount at the current rate,
+// the amount is locked until the quote expires.
+func (p *Monitor) quote(total decimal.Decimal) (*entity.PaymentQuote, error) {
+	rate, err := p.rates.ConvertFromBaseCurrency(p.c.Currency, decimal.NewFromInt(1))
+	if err != nil {
+		return nil, fmt.Errorf("can't convert from base currency: %w", err)
+	}
+	totalQuoted := total.Mul(rate).Round(p.c.QuoteDecimals)
+	totalBlockchainValue := convertToBlockchainFormat(totalQuoted, p.c.Decimals)
+
+	slog.Default().Info("total quoted",
+		slog.String("currency", p.c.Currency.String()),
+		slog.String("total", totalQuoted.String()),
+		slog.String("totalBlockchain", totalBlockchainValue.String()),
+		slog.String("rate", rate.String()),
+	)
+
+	quotedAt := time.Now().Truncate(time.Second)
+	return &entity.PaymentQuote{
+		AmountPaymentCurrency: totalBlockchainValue,
+		PaymentCurrency:       p.c.Currency.String(),
+		SourceCurrency:        p.rates.GetBaseCurrency().String(),
+		Rate:                  rate,
+		QuotedAt:              quotedAt,
+		ExpiresAt:             quotedAt.Add(p.c.QuoteExpiration),
+	}, nil
+}
+
+// quoteExpired reports whether the invoice amount was quoted and the quote is expired.
+func quoteExpired(payment *entity.Payment, now time.Time) bool {
+	return payment.QuoteExpiresAt.Valid && !now.Before(payment.QuoteExpiresAt.Time)
+}
+
+// requote quotes the unpaid invoice at the current rate, the expired quote is kept
+// once a transfer to the invoice address is seen so the customer isn't asked for a different amount.
+func (p *Monitor) requote(ctx context.Context, orderUUID string, payment *entity.Payment) (*entity.Payment, error) {
+	r, err := p.received(ctx, payment)
+	if err != nil {
+		return nil, err
+	}
+	if r.Confirmed.IsPositive() || r.Pending.IsPositive() {
+		return payment, nil
+	}
+
+	order, err := p.rep.Order().GetOrderByUUID(ctx, orderUUID)
+	if err != nil {
+		return nil, fmt.Errorf("can't get order by uuid: %w", err)
+	}
+
+	q, err := p.quote(order.AmountDue())
+	if err != nil {
+		return nil, fmt.Errorf("can't quote order total: %w", err)
+	}
+
+	err = p.rep.Order().UpdatePaymentQuote(ctx, orderUUID, q)
+	if err != nil {
+		return nil, fmt.Errorf("can't update payment quote: %w", err)
+	}
+
+	slog.Default().InfoContext(ctx, "invoice re-quoted",
+		slog.String("orderUUID", orderUUID),
+		slog.String("previous", payment.TransactionAmountPaymentCurrency.String()),
+		slog.String("current", q.AmountPaymentCurrency.String()),
+	)
+
+	payment.TransactionAmountPaymentCurrency = q.AmountPaymentCurrency
+	payment.PaymentCurrency = sql.NullString{String: q.PaymentCurrency, Valid: true}
+	payment.PaymentCurrencyRate = decimal.NullDecimal{Decimal: q.Rate, Valid: true}
+	payment.QuoteSourceCurrency = sql.NullString{String: q.SourceCurrency, Valid: true}
+	payment.QuotedAt = sql.NullTime{Time: q.QuotedAt, Valid: true}
+	payment.QuoteExpiresAt = sql.NullTime{Time: q.ExpiresAt, Valid: true}
+	return payment, nil
+}
+
+// CancelMonitorPayment cancels the scheduled expiration of the order invoice.
+func (p *Monitor) CancelMonitorPayment(orderUUID string, payment entity.Payment) error {
+	err := p.pool.RemovePaymentExpiration(context.Background(), orderUUID)
+	if err != nil {
+		return fmt.Errorf("can't remove payment expiration: %w", err)
+	}
+	return nil
+}
+
+// CheckForTransactions checks the transfers to the invoice address, the order is marked as paid
+// once the confirmed transfers add up to the invoice amount and as partially paid before that.
+// The amount received above the invoice amount is recorded as overpayment for the manual refund.
+func (p *Monitor) CheckForTransactions(ctx context.Context, orderUUID string, payment entity.Payment) (*entity.Payment, error) {
+	var updated *entity.Payment
+	var paid bool
+	err := p.rep.Tx(ctx, func(ctx context.Context, rep dependency.Repository) error {
+		var err error
+		updated, paid, err = p.checkForTransactions(ctx, rep, orderUUID, payment)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if paid {
+		err = p.sendOrderConfirmation(ctx, orderUUID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return updated, nil
+}
+
+// checkForTransactions checks the transfers to the invoice address and updates the order within the transaction of rep,
+// it returns true if the order was marked as paid by the check.
+func (p *Monitor) checkForTransactions(ctx context.Context, rep dependency.Repository, orderUUID string, payment entity.Payment) (*entity.Payment, bool, error) {
+	if payment.IsTransactionDone || !payment.Payee.Valid {
+		return &payment, false, nil
+	}
+
+	r, err := p.received(ctx, &payment)
+	if err != nil {
+		return nil, false, err
+	}
+
+	slog.Default().DebugContext(ctx, "checking for transactions",
+		slog.String("orderUUID", orderUUID),
+		slog.String("address", payment.Payee.String),
+		slog.String("expected", payment.TransactionAmountPaymentCurrency.String()),
+		slog.String("confirmed", r.Confirmed.String()),
+		slog.String("pending", r.Pending.String()),
+	)
+
+	if r.Last == nil {
+		return &payment, false, nil
+	}
+
+	if err := addTransactionSeen(ctx, rep, orderUUID, r.Last); err != nil {
+		return nil, false, err
+	}
+
+	if r.Confirmed.LessThan(payment.TransactionAmountPaymentCurrency) {
+		err := rep.Order().OrderPaymentPartiallyPaid(ctx, orderUUID)
+		if err != nil {
+			return nil, false, fmt.Errorf("can't update order payment partially paid: %w", err)
+		}
+		return &payment, false, nil
+	}
+
+	slog.Default().InfoContext(ctx, "transaction found",
+		slog.String("orderUUID", orderUUID),
+		slog.String("txId", r.Last.TxId),
+		slog.String("from", r.Last.From),
+		slog.String("to", r.Last.To),
+		slog.String("received", r.Confirmed.String()),
+	)
+
+	payment.TransactionID = sql.NullString{
+		String: r.Last.TxId,
+		Valid:  true,
+	}
+	payment.Payer = sql.NullString{
+		String: r.Last.From,
+		Valid:  true,
+	}
+	payment.IsTransactionDone = true
+
+	updated, err := rep.Order().OrderPaymentDone(ctx, orderUUID, &payment)
+	if err != nil {
+		return nil, false, fmt.Errorf("can't update order payment done: %w", err)
+	}
+
+	overpaid := r.Confirmed.Sub(payment.TransactionAmountPaymentCurrency)
+	if overpaid.IsPositive() {
+		slog.Default().WarnContext(ctx, "order overpaid",
+			slog.String("orderUUID", orderUUID),
+			slog.String("overpaid", overpaid.String()),
+		)
+		err = rep.Order().AddPaymentOverpayment(ctx, orderUUID, &entity.PaymentOverpaymentInsert{
+			PaymentMethodId:       p.pm.Id,
+			AmountPaymentCurrency: overpaid,
+			Payer:                 payment.Payer,
+			TransactionId:         payment.TransactionID,
+		})
+		if err != nil {
+			return nil, false, fmt.Errorf("can't add payment overpayment: %w", err)
+		}
+	}
+
+	slog.Default().InfoContext(ctx, "Order marked as paid", slog.String("orderUUID", orderUUID))
+	return updated, true, nil
+}
+
+// sendOrderConfirmation sends the confirmation of the paid order, it's sent once the payment is committed.
+func (p *Monitor) sendOrderConfirmation(ctx context.Context, orderUUID string) error {
+	of, err := p.rep.Order().GetOrderFullByUUID(ctx, orderUUID)
+	if err != nil {
+		return fmt.Errorf("can't get order by id: %w", err)
+	}
+
+	err = p.mailer.SendOrderConfirmation(ctx, p.rep, of.Buyer.Email, dto.OrderFullToOrderConfirmed(of))
+	if err != nil {
+		return fmt.Errorf("can't send order confirmation: %w", err)
+	}
+	return nil
+}
+
+// received sums the transfers to the invoice address since the invoice was issued.
+func (p *Monitor) received(ctx context.Context, payment *entity.Payment) (*Received, error) {
+	r, err := p.src.Received(ctx, payment.Payee.String, payment.ModifiedAt, payment.StartBlock, p.c.Confirmations)
+	if err != nil {
+		return nil, fmt.Errorf("can't get received transfers: %w", err)
+	}
+	return r, nil
+}
+
+// addTransactionSeen records the transfer to the invoice address with its chain data.
+func addTransactionSeen(ctx context.Context, rep dependency.Repository, orderUUID string, tr *Transfer) error {
+	err := rep.Order().AddPaymentEvent(ctx, orderUUID, &entity.PaymentEventInsert{
+		Type:          entity.PaymentEventTransactionSeen,
+		TransactionId: sql.NullString{String: tr.TxId, Valid: true},
+		Payload:       entity.PaymentEventPayload(tr.Data),
+	})
+	if err != nil {
+		return fmt.Errorf("can't add payment event: %w", err)
+	}
+	return nil
+}
+
+func convertToBlockchainFormat(amount decimal.Decimal, decimals int) decimal.Decimal {
+	// Create a new Decimal representing the scale factor (10^decimals).
+	scaleFactor := decimal.NewFromInt(10).Pow(decimal.NewFromInt(int64(decimals)))
+
+	// Multiply the transaction amount by the scale factor to get the amount in blockchain format.
+	return amount.Mul(scaleFactor)
+}
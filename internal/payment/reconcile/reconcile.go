@@ -0,0 +1,242 @@
+// Package reconcile compares the payments with the data of the payment providers.
+package reconcile
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jekabolt/grbpwr-manager/internal/cache"
+	"github.com/jekabolt/grbpwr-manager/internal/dependency"
+	"github.com/jekabolt/grbpwr-manager/internal/entity"
+	"github.com/shopspring/decimal"
+)
+
+type Config struct {
+	// Interval is how often the worker reconciles the payments, the worker is disabled if zero
+	Interval time.Duration `mapstructure:"interval"`
+	// Lookback is the age of the oldest payment reconciled by the worker
+	Lookback time.Duration `mapstructure:"lookback"`
+}
+
+const defaultLookback = 7 * 24 * time.Hour
+
+// tronSource is the trongrid client and the token contract of the tron payment method
+type tronSource struct {
+	tg              dependency.Trongrid
+	contractAddress string
+}
+
+type Reconciler struct {
+	c      *Config
+	rep    dependency.Repository
+	stripe map[entity.PaymentMethodName]dependency.StripePayment
+	tron   map[entity.PaymentMethodName]tronSource
+}
+
+// New creates a new reconciler without payment methods, add them with AddStripe and AddTron.
+func New(c *Config, rep dependency.Repository) *Reconciler {
+	if c.Lookback <= 0 {
+		c.Lookback = defaultLookback
+	}
+	return &Reconciler{
+		c:      c,
+		rep:    rep,
+		stripe: map[entity.PaymentMethodName]dependency.StripePayment{},
+		tron:   map[entity.PaymentMethodName]tronSource{},
+	}
+}
+
+// AddStripe reconciles the payments of the card payment method with the stripe payment intents.
+func (r *Reconciler) AddStripe(pmn entity.PaymentMethodName, sp dependency.StripePayment) {
+	r.stripe[pmn] = sp
+}
+
+// AddTron reconciles the payments of the tron payment method with the token transfers to the payee.
+func (r *Reconciler) AddTron(pmn entity.PaymentMethodName, tg dependency.Trongrid, contractAddress string) {
+	r.tron[pmn] = tronSource{
+		tg:              tg,
+		contractAddress: contractAddress,
+	}
+}
+
+// Start starts the worker which logs the mismatches of the payments made within the lookback.
+func (r *Reconciler) Start(ctx context.Context) {
+	if r.c.Interval <= 0 {
+		slog.Default().InfoContext(ctx, "payment reconciliation worker is disabled")
+		return
+	}
+	go r.worker(ctx)
+}
+
+func (r *Reconciler) worker(ctx context.Context) {
+	ticker := time.NewTicker(r.c.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			report, err := r.Reconcile(ctx, time.Time{})
+			if err != nil {
+				slog.Default().ErrorContext(ctx, "can't reconcile payments",
+					slog.String("err", err.Error()),
+				)
+				continue
+			}
+			for _, m := range report.Mismatches {
+				slog.Default().WarnContext(ctx, "payment mismatch",
+					slog.String("type", string(m.Type)),
+					slog.String("orderUUID", m.OrderUUID),
+					slog.String("paymentMethod", string(m.PaymentMethodName)),
+					slog.String("transactionId", m.TransactionId),
+					slog.String("expected", m.Expected.String()),
+					slog.String("actual", m.Actual.String()),
+					slog.String("details", m.Details),
+				)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Reconcile compares the payments issued after from with the provider data, zero from means the configured lookback.
+// A payment which can't be checked against the provider fails the whole report.
+func (r *Reconciler) Reconcile(ctx context.Context, from time.Time) (*entity.ReconciliationReport, error) {
+	if from.IsZero() {
+		from = time.Now().Add(-r.c.Lookback)
+	}
+
+	pmns := make([]entity.PaymentMethodName, 0, len(r.stripe)+len(r.tron))
+	for pmn := range r.stripe {
+		pmns = append(pmns, pmn)
+	}
+	for pmn := range r.tron {
+		pmns = append(pmns, pmn)
+	}
+
+	payments, err := r.rep.Order().GetPaymentsCreatedAfter(ctx, from, pmns...)
+	if err != nil {
+		return nil, fmt.Errorf("can't get payments: %w", err)
+	}
+
+	report := &entity.ReconciliationReport{
+		From:            from,
+		CreatedAt:       time.Now(),
+		PaymentsChecked: len(payments),
+		Mismatches:      []entity.ReconciliationMismatch{},
+	}
+
+	for _, p := range payments {
+		pm, ok := cache.GetPaymentMethodById(p.PaymentMethodID)
+		if !ok {
+			return nil, fmt.Errorf("payment method not found: %d", p.PaymentMethodID)
+		}
+
+		sp, isStripe := r.stripe[pm.Method.Name]
+		ts, isTron := r.tron[pm.Method.Name]
+
+		inv, err := r.getInvoice(ctx, p, isTron)
+		if err != nil {
+			return nil, fmt.Errorf("can't get invoice of order %s: %w", p.OrderUUID, err)
+		}
+
+		var mms []entity.ReconciliationMismatch
+		if isStripe {
+			mms, err = reconcileStripe(sp, pm.Method.Name, p, inv, isAwaitingPayment(p.OrderStatusId))
+		}
+		if isTron {
+			mms, err = reconcileTron(ts.tg, ts.contractAddress, pm.Method.Name, p, inv, isAwaitingPayment(p.OrderStatusId))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("can't reconcile payment of order %s: %w", p.OrderUUID, err)
+		}
+		report.Mismatches = append(report.Mismatches, mms...)
+	}
+
+	return report, nil
+}
+
+// invoice is the last invoice issued for the payment, the payment row is reset when its invoice expires
+// so the invoice is read from the deposit address of the order and the payment event log.
+type invoice struct {
+	// amount is the invoice amount in the payment currency
+	amount decimal.Decimal
+	// payee is the deposit address of the crypto invoice
+	payee string
+	// paymentIntentIds are the stripe payment intents of the card invoices in the order they were created
+	paymentIntentIds []string
+}
+
+// getInvoice reads the invoice of the payment, the deposit address is read only for the crypto payments.
+func (r *Reconciler) getInvoice(ctx context.Context, p entity.PaymentOrderStatus, crypto bool) (*invoice, error) {
+	events, err := r.rep.Order().GetPaymentEventsByOrderUUID(ctx, p.OrderUUID)
+	if err != nil {
+		return nil, fmt.Errorf("can't get payment events: %w", err)
+	}
+
+	inv := &invoice{
+		amount: p.TransactionAmountPaymentCurrency,
+		payee:  p.Payee.String,
+	}
+	expiredAmount := decimal.Zero
+	for _, e := range events {
+		if e.PaymentMethodId != p.PaymentMethodID || !e.Payload.Valid {
+			continue
+		}
+		switch e.Type {
+		case entity.PaymentEventClientSecretCreated:
+			var payload struct {
+				PaymentIntentId string `json:"paymentIntentId"`
+			}
+			if err := json.Unmarshal([]byte(e.Payload.String), &payload); err != nil {
+				return nil, fmt.Errorf("can't unmarshal client secret event: %w", err)
+			}
+			if payload.PaymentIntentId != "" {
+				inv.paymentIntentIds = append(inv.paymentIntentIds, payload.PaymentIntentId)
+			}
+		case entity.PaymentEventExpired:
+			var payload struct {
+				TransactionAmountPaymentCurrency decimal.Decimal `json:"transactionAmountPaymentCurrency"`
+			}
+			if err := json.Unmarshal([]byte(e.Payload.String), &payload); err != nil {
+				return nil, fmt.Errorf("can't unmarshal expired event: %w", err)
+			}
+			expiredAmount = payload.TransactionAmountPaymentCurrency
+		}
+	}
+	// the amount of the expired invoice is reset on the payment
+	if inv.amount.IsZero() && !p.IsTransactionDone {
+		inv.amount = expiredAmount
+	}
+
+	if !crypto {
+		return inv, nil
+	}
+	pa, err := r.rep.Order().GetPaymentAddressByOrderUUID(ctx, p.OrderUUID, p.PaymentMethodID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return inv, nil
+		}
+		return nil, fmt.Errorf("can't get payment address: %w", err)
+	}
+	inv.payee = pa.Address
+	return inv, nil
+}
+
+// isAwaitingPayment reports whether the invoice of the order can still be paid.
+func isAwaitingPayment(orderStatusId int) bool {
+	os, ok := cache.GetOrderStatusById(orderStatusId)
+	if !ok {
+		return false
+	}
+	switch os.Status.Name {
+	case entity.Placed, entity.AwaitingPayment, entity.PartiallyPaid:
+		return true
+	}
+	return false
+}
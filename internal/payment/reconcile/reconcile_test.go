@@ -0,0 +1,355 @@
+package reconcile
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jekabolt/grbpwr-manager/internal/dependency"
+	"github.com/jekabolt/grbpwr-manager/internal/dto"
+	"github.com/jekabolt/grbpwr-manager/internal/entity"
+	"github.com/jekabolt/grbpwr-manager/internal/payment/trongrid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stripe/stripe-go/v79"
+)
+
+const (
+	testPayee    = "TUEZSdKsoDHQMeZwihtdoBiN46zxhGWYdH"
+	testContract = "TR7NHqjeKQxGTCi8q8ZY4pPg7c8vJsBmUq"
+)
+
+// fakeOrder serves the payment event log and the deposit addresses of the orders.
+type fakeOrder struct {
+	dependency.Order
+	events    map[string][]entity.PaymentEvent
+	addresses map[string]entity.PaymentAddress
+}
+
+func (f *fakeOrder) GetPaymentEventsByOrderUUID(ctx context.Context, orderUUID string) ([]entity.PaymentEvent, error) {
+	return f.events[orderUUID], nil
+}
+
+func (f *fakeOrder) GetPaymentAddressByOrderUUID(ctx context.Context, orderUUID string, paymentMethodId int) (*entity.PaymentAddress, error) {
+	pa, ok := f.addresses[orderUUID]
+	if !ok || pa.PaymentMethodId != paymentMethodId {
+		return nil, fmt.Errorf("can't get payment address by order uuid: %w", sql.ErrNoRows)
+	}
+	return &pa, nil
+}
+
+type fakeRepository struct {
+	dependency.Repository
+	order *fakeOrder
+}
+
+func (r *fakeRepository) Order() dependency.Order {
+	return r.order
+}
+
+func newTestReconciler() (*Reconciler, *fakeOrder) {
+	order := &fakeOrder{
+		events:    map[string][]entity.PaymentEvent{},
+		addresses: map[string]entity.PaymentAddress{},
+	}
+	return New(&Config{}, &fakeRepository{order: order}), order
+}
+
+// addEvent appends the event with the payload to the payment event log of the order.
+func (f *fakeOrder) addEvent(orderUUID string, pmId int, t entity.PaymentEventType, payload any) {
+	b, _ := json.Marshal(payload)
+	f.events[orderUUID] = append(f.events[orderUUID], entity.PaymentEvent{
+		PaymentMethodId: pmId,
+		PaymentEventInsert: entity.PaymentEventInsert{
+			Type:    t,
+			Payload: sql.NullString{String: string(b), Valid: true},
+		},
+	})
+}
+
+// fakeStripe serves payment intents by their id.
+type fakeStripe struct {
+	intents map[string]*stripe.PaymentIntent
+}
+
+func (f *fakeStripe) CreatePaymentIntent(order entity.OrderFull) (*stripe.PaymentIntent, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func (f *fakeStripe) GetPaymentIntent(paymentIntentId string) (*stripe.PaymentIntent, error) {
+	pi, ok := f.intents[paymentIntentId]
+	if !ok {
+		return nil, fmt.Errorf("no such payment intent: %s", paymentIntentId)
+	}
+	return pi, nil
+}
+
+// fakeTrongrid serves the given transactions for any address.
+type fakeTrongrid struct {
+	txs []dto.TransactionData
+}
+
+func (f *fakeTrongrid) GetAddressTransactions(address string, contractAddress string, minTimestamp time.Time) (*dto.TronTransactionsResponse, error) {
+	return &dto.TronTransactionsResponse{Data: f.txs, Success: true}, nil
+}
+
+func (f *fakeTrongrid) GetNowBlock() (*dto.TronBlock, error) {
+	return &dto.TronBlock{}, nil
+}
+
+func (f *fakeTrongrid) GetTransactionInfoById(txId string) (*dto.TronTransactionInfo, error) {
+	return &dto.TronTransactionInfo{ID: txId}, nil
+}
+
+func testTransfer(id string, value string, at time.Time) dto.TransactionData {
+	return dto.TransactionData{
+		TransactionID:  id,
+		TokenInfo:      dto.TokenInfo{Address: testContract, Decimals: 6},
+		BlockTimestamp: at.UnixMilli(),
+		From:           "TMknEhd2ASboQ1LCFZfvJTufSLHEL9S5xW",
+		To:             testPayee,
+		Type:           trongrid.TypeTransfer,
+		Value:          value,
+	}
+}
+
+const (
+	testCardPm = 1
+	testTronPm = 2
+)
+
+// cardPayment returns the card payment of the invoice paid with the payment intent,
+// the log records the intent when the invoice is issued.
+func cardPayment(order *fakeOrder, orderUUID string, paymentIntentId string, amount string, done bool) entity.PaymentOrderStatus {
+	order.addEvent(orderUUID, testCardPm, entity.PaymentEventClientSecretCreated, map[string]string{"paymentIntentId": paymentIntentId})
+	return entity.PaymentOrderStatus{
+		OrderUUID: orderUUID,
+		Payment: entity.Payment{
+			PaymentInsert: entity.PaymentInsert{
+				PaymentMethodID:                  testCardPm,
+				ClientSecret:                     sql.NullString{String: paymentIntentId + "_secret_test", Valid: true},
+				TransactionAmountPaymentCurrency: decimal.RequireFromString(amount),
+				IsTransactionDone:                done,
+			},
+		},
+	}
+}
+
+// expireCardPayment resets the payment of the expired invoice the way the store does and records the expiration.
+func expireCardPayment(order *fakeOrder, p entity.PaymentOrderStatus) entity.PaymentOrderStatus {
+	order.addEvent(p.OrderUUID, testCardPm, entity.PaymentEventExpired, map[string]any{
+		"transactionAmountPaymentCurrency": p.TransactionAmountPaymentCurrency,
+	})
+	p.ClientSecret = sql.NullString{}
+	p.TransactionAmountPaymentCurrency = decimal.Zero
+	return p
+}
+
+func reconcileStripePayment(t *testing.T, r *Reconciler, sp *fakeStripe, p entity.PaymentOrderStatus, awaiting bool) ([]entity.ReconciliationMismatch, error) {
+	inv, err := r.getInvoice(context.Background(), p, false)
+	if !assert.NoError(t, err) {
+		return nil, err
+	}
+	return reconcileStripe(sp, entity.CARD, p, inv, awaiting)
+}
+
+func TestReconcileStripe(t *testing.T) {
+	r, order := newTestReconciler()
+	sp := &fakeStripe{intents: map[string]*stripe.PaymentIntent{
+		"pi_succeeded": {ID: "pi_succeeded", Status: stripe.PaymentIntentStatusSucceeded, AmountReceived: 1050},
+		"pi_canceled":  {ID: "pi_canceled", Status: stripe.PaymentIntentStatusCanceled},
+		"pi_yen":       {ID: "pi_yen", Status: stripe.PaymentIntentStatusSucceeded, AmountReceived: 1650, Currency: "jpy"},
+	}}
+
+	mms, err := reconcileStripePayment(t, r, sp, cardPayment(order, "done", "pi_succeeded", "10.5", true), false)
+	assert.NoError(t, err)
+	assert.Empty(t, mms)
+
+	mms, err = reconcileStripePayment(t, r, sp, cardPayment(order, "not_confirmed", "pi_succeeded", "10.5", false), true)
+	assert.NoError(t, err)
+	if assert.Len(t, mms, 1) {
+		assert.Equal(t, entity.MismatchPaidNotConfirmed, mms[0].Type)
+		assert.Equal(t, "pi_succeeded", mms[0].TransactionId)
+	}
+
+	mms, err = reconcileStripePayment(t, r, sp, cardPayment(order, "amount", "pi_succeeded", "12", true), false)
+	assert.NoError(t, err)
+	if assert.Len(t, mms, 1) {
+		assert.Equal(t, entity.MismatchAmount, mms[0].Type)
+		assert.True(t, mms[0].Actual.Equal(decimal.RequireFromString("10.5")), mms[0].Actual.String())
+	}
+
+	mms, err = reconcileStripePayment(t, r, sp, cardPayment(order, "canceled", "pi_canceled", "10.5", true), false)
+	assert.NoError(t, err)
+	if assert.Len(t, mms, 1) {
+		assert.Equal(t, entity.MismatchConfirmedWithoutTransaction, mms[0].Type)
+	}
+
+	// zero-decimal currency
+	mms, err = reconcileStripePayment(t, r, sp, cardPayment(order, "yen", "pi_yen", "1650", true), false)
+	assert.NoError(t, err)
+	assert.Empty(t, mms)
+
+	// confirmed without any invoice issued
+	mms, err = reconcileStripePayment(t, r, sp, entity.PaymentOrderStatus{
+		OrderUUID: "no_invoice",
+		Payment: entity.Payment{PaymentInsert: entity.PaymentInsert{
+			PaymentMethodID:                  testCardPm,
+			TransactionAmountPaymentCurrency: decimal.RequireFromString("10.5"),
+			IsTransactionDone:                true,
+		}},
+	}, false)
+	assert.NoError(t, err)
+	if assert.Len(t, mms, 1) {
+		assert.Equal(t, entity.MismatchConfirmedWithoutTransaction, mms[0].Type)
+	}
+
+	// the canceled intent of the expired invoice
+	mms, err = reconcileStripePayment(t, r, sp, expireCardPayment(order, cardPayment(order, "expired_canceled", "pi_canceled", "10.5", false)), false)
+	assert.NoError(t, err)
+	assert.Empty(t, mms)
+
+	// the intent of the expired invoice succeeded after the payment was reset
+	mms, err = reconcileStripePayment(t, r, sp, expireCardPayment(order, cardPayment(order, "expired_paid", "pi_succeeded", "10.5", false)), false)
+	assert.NoError(t, err)
+	if assert.Len(t, mms, 1) {
+		assert.Equal(t, entity.MismatchPaidNotConfirmed, mms[0].Type)
+		assert.Equal(t, "pi_succeeded", mms[0].TransactionId)
+		assert.Equal(t, "payment intent succeeded for the expired invoice", mms[0].Details)
+		assert.True(t, mms[0].Expected.Equal(decimal.RequireFromString("10.5")), mms[0].Expected.String())
+	}
+
+	// the previous intent succeeded while the order awaits the reissued invoice
+	expired := expireCardPayment(order, cardPayment(order, "reissued", "pi_succeeded", "10.5", false))
+	reissued := cardPayment(order, expired.OrderUUID, "pi_canceled", "10.5", false)
+	mms, err = reconcileStripePayment(t, r, sp, reissued, true)
+	assert.NoError(t, err)
+	if assert.Len(t, mms, 1) {
+		assert.Equal(t, entity.MismatchPaidNotConfirmed, mms[0].Type)
+		assert.Equal(t, "pi_succeeded", mms[0].TransactionId)
+	}
+
+	_, err = reconcileStripe(sp, entity.CARD, cardPayment(order, "missing", "pi_missing", "10.5", false), &invoice{}, false)
+	assert.Error(t, err)
+}
+
+func TestReconcileTron(t *testing.T) {
+	createdAt := time.Now().Add(-time.Hour).Truncate(time.Second)
+	doneAt := createdAt.Add(10 * time.Minute)
+
+	r, order := newTestReconciler()
+	order.addresses["order"] = entity.PaymentAddress{PaymentAddressInsert: entity.PaymentAddressInsert{
+		PaymentMethodId: testTronPm,
+		Address:         testPayee,
+	}}
+
+	payment := func(amount string, done bool) entity.PaymentOrderStatus {
+		return entity.PaymentOrderStatus{
+			OrderUUID: "order",
+			Payment: entity.Payment{
+				CreatedAt:  createdAt,
+				ModifiedAt: doneAt,
+				PaymentInsert: entity.PaymentInsert{
+					PaymentMethodID:                  testTronPm,
+					Payee:                            sql.NullString{String: testPayee, Valid: true},
+					TransactionAmountPaymentCurrency: decimal.RequireFromString(amount),
+					IsTransactionDone:                done,
+				},
+			},
+		}
+	}
+	// expired resets the payment of the expired invoice the way the store does and records the expiration
+	expired := func(amount string) entity.PaymentOrderStatus {
+		p := payment(amount, false)
+		order.events["order"] = nil
+		order.addEvent(p.OrderUUID, testTronPm, entity.PaymentEventExpired, map[string]any{
+			"transactionAmountPaymentCurrency": p.TransactionAmountPaymentCurrency,
+			"payee":                            p.Payee.String,
+		})
+		p.Payee = sql.NullString{}
+		p.TransactionAmountPaymentCurrency = decimal.Zero
+		return p
+	}
+
+	otherToken := testTransfer("other_token", "1000000", createdAt.Add(time.Minute))
+	otherToken.TokenInfo.Address = "TXLAQ63Xg1NAzckPwKHvzw7CSEmLMEqcdj"
+
+	tg := &fakeTrongrid{txs: []dto.TransactionData{
+		testTransfer("late", "500000", doneAt.Add(time.Minute)),
+		testTransfer("second", "2000000", createdAt.Add(2*time.Minute)),
+		testTransfer("first", "1000000", createdAt.Add(time.Minute)),
+		testTransfer("before_invoice", "1000000", createdAt.Add(-time.Minute)),
+		otherToken,
+	}}
+
+	reconcile := func(tg *fakeTrongrid, p entity.PaymentOrderStatus, awaiting bool) ([]entity.ReconciliationMismatch, error) {
+		inv, err := r.getInvoice(context.Background(), p, true)
+		if !assert.NoError(t, err) {
+			return nil, err
+		}
+		return reconcileTron(tg, testContract, entity.USDT_TRON, p, inv, awaiting)
+	}
+
+	// paid in two transfers, one more arrived after the payment was done
+	mms, err := reconcile(tg, payment("3000000", true), false)
+	assert.NoError(t, err)
+	if assert.Len(t, mms, 1) {
+		assert.Equal(t, entity.MismatchOrphanTransfer, mms[0].Type)
+		assert.Equal(t, "late", mms[0].TransactionId)
+	}
+
+	mms, err = reconcile(tg, payment("4000000", true), false)
+	assert.NoError(t, err)
+	if assert.Len(t, mms, 2) {
+		assert.Equal(t, entity.MismatchAmount, mms[1].Type)
+		assert.True(t, mms[1].Actual.Equal(decimal.NewFromInt(3000000)), mms[1].Actual.String())
+	}
+
+	mms, err = reconcile(tg, payment("3000000", false), true)
+	assert.NoError(t, err)
+	if assert.Len(t, mms, 1) {
+		assert.Equal(t, entity.MismatchPaidNotConfirmed, mms[0].Type)
+	}
+
+	// partial payment in progress
+	mms, err = reconcile(tg, payment("5000000", false), true)
+	assert.NoError(t, err)
+	assert.Empty(t, mms)
+
+	// the expired invoice paid in full after the payment was reset
+	mms, err = reconcile(tg, expired("3000000"), false)
+	assert.NoError(t, err)
+	if assert.Len(t, mms, 1) {
+		assert.Equal(t, entity.MismatchPaidNotConfirmed, mms[0].Type)
+		assert.Equal(t, "transfers cover the payment amount of the expired invoice", mms[0].Details)
+		assert.True(t, mms[0].Expected.Equal(decimal.NewFromInt(3000000)), mms[0].Expected.String())
+	}
+
+	// partial payment of the expired invoice
+	mms, err = reconcile(tg, expired("5000000"), false)
+	assert.NoError(t, err)
+	assert.Len(t, mms, 3)
+	for _, m := range mms {
+		assert.Equal(t, entity.MismatchOrphanTransfer, m.Type)
+	}
+
+	// the invoice was never issued
+	delete(order.addresses, "order")
+	mms, err = reconcile(tg, expired("5000000"), false)
+	assert.NoError(t, err)
+	assert.Empty(t, mms)
+
+	order.addresses["order"] = entity.PaymentAddress{PaymentAddressInsert: entity.PaymentAddressInsert{
+		PaymentMethodId: testTronPm,
+		Address:         testPayee,
+	}}
+	empty := &fakeTrongrid{}
+	mms, err = reconcile(empty, payment("3000000", true), false)
+	assert.NoError(t, err)
+	if assert.Len(t, mms, 1) {
+		assert.Equal(t, entity.MismatchConfirmedWithoutTransaction, mms[0].Type)
+	}
+}
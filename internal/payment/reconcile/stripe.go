@@ -0,0 +1,101 @@
+package reconcile
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jekabolt/grbpwr-manager/internal/dependency"
+	"github.com/jekabolt/grbpwr-manager/internal/dto"
+	"github.com/jekabolt/grbpwr-manager/internal/entity"
+	"github.com/shopspring/decimal"
+	"github.com/stripe/stripe-go/v79"
+)
+
+// reconcileStripe compares the card payment with its stripe payment intents,
+// the amounts are compared in the smallest unit of the currency the card was charged in.
+// The payment intent of the current invoice is compared with the payment, the intents
+// of the expired invoices are reported only if they succeeded.
+func reconcileStripe(sp dependency.StripePayment, pmn entity.PaymentMethodName, p entity.PaymentOrderStatus, inv *invoice, awaiting bool) ([]entity.ReconciliationMismatch, error) {
+	mismatch := func(t entity.ReconciliationMismatchType, expected decimal.Decimal, actual decimal.Decimal, txId string, details string) entity.ReconciliationMismatch {
+		return entity.ReconciliationMismatch{
+			Type:              t,
+			OrderUUID:         p.OrderUUID,
+			PaymentMethodName: pmn,
+			TransactionId:     txId,
+			Expected:          expected,
+			Actual:            actual,
+			Details:           details,
+		}
+	}
+
+	current := ""
+	if p.ClientSecret.Valid {
+		current, _, _ = strings.Cut(p.ClientSecret.String, "_secret_")
+	}
+
+	// invoice was never issued
+	if current == "" && len(inv.paymentIntentIds) == 0 {
+		if p.IsTransactionDone {
+			return []entity.ReconciliationMismatch{
+				mismatch(entity.MismatchConfirmedWithoutTransaction, p.TransactionAmountPaymentCurrency, decimal.Zero, "", "payment has no payment intent"),
+			}, nil
+		}
+		return nil, nil
+	}
+
+	mms := []entity.ReconciliationMismatch{}
+
+	for _, id := range inv.paymentIntentIds {
+		if id == current {
+			continue
+		}
+		pi, err := sp.GetPaymentIntent(id)
+		if err != nil {
+			return nil, fmt.Errorf("can't get payment intent: %w", err)
+		}
+		if pi.Status != stripe.PaymentIntentStatusSucceeded {
+			continue
+		}
+		received := decimal.New(pi.AmountReceived, -currencyMinorUnits(pi.Currency))
+		mms = append(mms, mismatch(entity.MismatchPaidNotConfirmed, inv.amount, received, pi.ID,
+			"payment intent succeeded for the expired invoice"))
+	}
+
+	if current == "" {
+		if p.IsTransactionDone {
+			mms = append(mms, mismatch(entity.MismatchConfirmedWithoutTransaction, p.TransactionAmountPaymentCurrency, decimal.Zero, "",
+				"payment has no payment intent"))
+		}
+		return mms, nil
+	}
+
+	pi, err := sp.GetPaymentIntent(current)
+	if err != nil {
+		return nil, fmt.Errorf("can't get payment intent: %w", err)
+	}
+
+	minorUnits := currencyMinorUnits(pi.Currency)
+	received := decimal.New(pi.AmountReceived, -minorUnits)
+	succeeded := pi.Status == stripe.PaymentIntentStatusSucceeded
+
+	switch {
+	case p.IsTransactionDone && !succeeded:
+		mms = append(mms, mismatch(entity.MismatchConfirmedWithoutTransaction, p.TransactionAmountPaymentCurrency, received, pi.ID,
+			fmt.Sprintf("payment intent status is %s", pi.Status)))
+	case !p.IsTransactionDone && succeeded:
+		details := "payment intent succeeded"
+		if !awaiting {
+			details = "payment intent succeeded for the expired invoice"
+		}
+		mms = append(mms, mismatch(entity.MismatchPaidNotConfirmed, inv.amount, received, pi.ID, details))
+	case p.IsTransactionDone && !received.Equal(p.TransactionAmountPaymentCurrency.Round(minorUnits)):
+		mms = append(mms, mismatch(entity.MismatchAmount, p.TransactionAmountPaymentCurrency, received, pi.ID,
+			"payment intent received amount differs"))
+	}
+
+	return mms, nil
+}
+
+func currencyMinorUnits(currency stripe.Currency) int32 {
+	return dto.CurrencyMinorUnits(dto.CurrencyTicker(strings.ToUpper(string(currency))))
+}
@@ -0,0 +1,119 @@
+package reconcile
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jekabolt/grbpwr-manager/internal/dependency"
+	"github.com/jekabolt/grbpwr-manager/internal/dto"
+	"github.com/jekabolt/grbpwr-manager/internal/entity"
+	"github.com/jekabolt/grbpwr-manager/internal/payment/trongrid"
+	"github.com/shopspring/decimal"
+)
+
+// reconcileTron compares the tron payment with the token transfers to the deposit address of its invoice,
+// the amounts are compared in blockchain format.
+// Transfers are counted since the payment was created, the transfers made after the payment
+// is done or to the address of the expired invoice are orphans. Overpayments are recorded
+// separately by the processor and are not reported here.
+func reconcileTron(tg dependency.Trongrid, contractAddress string, pmn entity.PaymentMethodName, p entity.PaymentOrderStatus, inv *invoice, awaiting bool) ([]entity.ReconciliationMismatch, error) {
+	mismatch := func(t entity.ReconciliationMismatchType, expected decimal.Decimal, actual decimal.Decimal, txId string, details string) entity.ReconciliationMismatch {
+		return entity.ReconciliationMismatch{
+			Type:              t,
+			OrderUUID:         p.OrderUUID,
+			PaymentMethodName: pmn,
+			TransactionId:     txId,
+			Expected:          expected,
+			Actual:            actual,
+			Details:           details,
+		}
+	}
+
+	// invoice was never issued
+	if inv.payee == "" {
+		if p.IsTransactionDone {
+			return []entity.ReconciliationMismatch{
+				mismatch(entity.MismatchConfirmedWithoutTransaction, inv.amount, decimal.Zero,
+					p.TransactionID.String, "payment has no deposit address"),
+			}, nil
+		}
+		return nil, nil
+	}
+
+	txs, err := tg.GetAddressTransactions(inv.payee, contractAddress, p.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("can't get address transactions: %w", err)
+	}
+
+	transfers := []transfer{}
+	for _, tx := range txs.Data {
+		if tx.Type != trongrid.TypeTransfer ||
+			tx.To != inv.payee ||
+			tx.TokenInfo.Address != contractAddress ||
+			time.UnixMilli(tx.BlockTimestamp).Before(p.CreatedAt) {
+			continue
+		}
+		value, err := decimal.NewFromString(tx.Value)
+		if err != nil || !value.IsPositive() {
+			continue
+		}
+		transfers = append(transfers, transfer{tx: tx, value: value})
+	}
+
+	mms := []entity.ReconciliationMismatch{}
+
+	if p.IsTransactionDone {
+		received := decimal.Zero
+		for _, t := range transfers {
+			if time.UnixMilli(t.tx.BlockTimestamp).After(p.ModifiedAt) {
+				mms = append(mms, mismatch(entity.MismatchOrphanTransfer, decimal.Zero, t.value,
+					t.tx.TransactionID, "transfer received after the payment was done"))
+				continue
+			}
+			received = received.Add(t.value)
+		}
+
+		switch {
+		case received.IsZero():
+			mms = append(mms, mismatch(entity.MismatchConfirmedWithoutTransaction, inv.amount, received,
+				p.TransactionID.String, "no transfers to the deposit address"))
+		case received.LessThan(inv.amount):
+			mms = append(mms, mismatch(entity.MismatchAmount, inv.amount, received,
+				p.TransactionID.String, "transfers sum is less than the payment amount"))
+		}
+		return mms, nil
+	}
+
+	received := decimal.Zero
+	for _, t := range transfers {
+		received = received.Add(t.value)
+	}
+	if received.IsZero() {
+		return mms, nil
+	}
+
+	if received.GreaterThanOrEqual(inv.amount) {
+		details := "transfers cover the payment amount"
+		if !awaiting {
+			details = "transfers cover the payment amount of the expired invoice"
+		}
+		return append(mms, mismatch(entity.MismatchPaidNotConfirmed, inv.amount, received,
+			transfers[0].tx.TransactionID, details)), nil
+	}
+
+	// partial payment of the invoice which can still be paid
+	if awaiting {
+		return mms, nil
+	}
+
+	for _, t := range transfers {
+		mms = append(mms, mismatch(entity.MismatchOrphanTransfer, decimal.Zero, t.value,
+			t.tx.TransactionID, "partial transfer to the deposit address of the expired invoice"))
+	}
+	return mms, nil
+}
+
+type transfer struct {
+	tx    dto.TransactionData
+	value decimal.Decimal
+}
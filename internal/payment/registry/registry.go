@@ -0,0 +1,84 @@
+// Package registry holds the payment processors by their payment method.
+package registry
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/jekabolt/grbpwr-manager/internal/dependency"
+	"github.com/jekabolt/grbpwr-manager/internal/entity"
+)
+
+type Registry struct {
+	mu         sync.RWMutex
+	processors map[entity.PaymentMethodName]dependency.Invoicer
+}
+
+// New creates an empty registry, processors are added with Register.
+func New() *Registry {
+	return &Registry{
+		processors: map[entity.PaymentMethodName]dependency.Invoicer{},
+	}
+}
+
+// Register adds the processor of the payment method, each payment method has at most one processor.
+func (r *Registry) Register(pmn entity.PaymentMethodName, i dependency.Invoicer) error {
+	if !entity.ValidPaymentMethodNames[pmn] {
+		return fmt.Errorf("invalid payment method: %s", pmn)
+	}
+	if i == nil {
+		return fmt.Errorf("processor is nil: %s", pmn)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.processors[pmn]; ok {
+		return fmt.Errorf("processor already registered: %s", pmn)
+	}
+	r.processors[pmn] = i
+	return nil
+}
+
+// Invoicer returns the processor of the payment method.
+func (r *Registry) Invoicer(pmn entity.PaymentMethodName) (dependency.Invoicer, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	i, ok := r.processors[pmn]
+	return i, ok
+}
+
+// Refunder returns the processor of the payment method if it can refund payments.
+func (r *Registry) Refunder(pmn entity.PaymentMethodName) (dependency.Refunder, bool) {
+	i, ok := r.Invoicer(pmn)
+	if !ok {
+		return nil, false
+	}
+	rf, ok := i.(dependency.Refunder)
+	return rf, ok
+}
+
+// Expirer returns the processor of the payment method if it can expire unpaid invoices.
+func (r *Registry) Expirer(pmn entity.PaymentMethodName) (dependency.PaymentExpirer, bool) {
+	i, ok := r.Invoicer(pmn)
+	if !ok {
+		return nil, false
+	}
+	e, ok := i.(dependency.PaymentExpirer)
+	return e, ok
+}
+
+// PaymentMethods returns the payment methods with a registered processor.
+func (r *Registry) PaymentMethods() []entity.PaymentMethodName {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	pmns := make([]entity.PaymentMethodName, 0, len(r.processors))
+	for pmn := range r.processors {
+		pmns = append(pmns, pmn)
+	}
+	sort.Slice(pmns, func(i, j int) bool { return pmns[i] < pmns[j] })
+	return pmns
+}
@@ -0,0 +1,73 @@
+package registry
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jekabolt/grbpwr-manager/internal/dependency"
+	"github.com/jekabolt/grbpwr-manager/internal/entity"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+type invoicer struct{}
+
+func (invoicer) GetOrderInvoice(ctx context.Context, orderUUID string) (*entity.PaymentInsert, time.Time, error) {
+	return &entity.PaymentInsert{}, time.Now(), nil
+}
+
+func (invoicer) CancelMonitorPayment(orderUUID string, payment entity.Payment) error {
+	return nil
+}
+
+func (invoicer) CheckForTransactions(ctx context.Context, orderUUID string, payment entity.Payment) (*entity.Payment, error) {
+	return &payment, nil
+}
+
+type refundingInvoicer struct {
+	invoicer
+}
+
+func (refundingInvoicer) Refund(ctx context.Context, payment entity.Payment, amount decimal.Decimal, idempotencyKey string) (string, entity.RefundStatus, error) {
+	return "re_1", entity.RefundSucceeded, nil
+}
+
+type expiringInvoicer struct {
+	invoicer
+}
+
+func (expiringInvoicer) ExpirePayment(ctx context.Context, rep dependency.Repository, orderUUID string) (bool, error) {
+	return false, nil
+}
+
+func TestRegistry(t *testing.T) {
+	r := New()
+
+	assert.NoError(t, r.Register(entity.USDT_TRON, invoicer{}))
+	assert.NoError(t, r.Register(entity.CARD, refundingInvoicer{}))
+	assert.NoError(t, r.Register(entity.ETH_TEST, expiringInvoicer{}))
+
+	assert.Error(t, r.Register(entity.CARD, invoicer{}))
+	assert.Error(t, r.Register("unknown", invoicer{}))
+	assert.Error(t, r.Register(entity.ETH, nil))
+
+	_, ok := r.Invoicer(entity.USDT_TRON)
+	assert.True(t, ok)
+	_, ok = r.Invoicer(entity.ETH)
+	assert.False(t, ok)
+
+	_, ok = r.Refunder(entity.CARD)
+	assert.True(t, ok)
+	_, ok = r.Refunder(entity.USDT_TRON)
+	assert.False(t, ok)
+	_, ok = r.Refunder(entity.ETH)
+	assert.False(t, ok)
+
+	_, ok = r.Expirer(entity.ETH_TEST)
+	assert.True(t, ok)
+	_, ok = r.Expirer(entity.CARD)
+	assert.False(t, ok)
+
+	assert.Equal(t, []entity.PaymentMethodName{entity.CARD, entity.ETH_TEST, entity.USDT_TRON}, r.PaymentMethods())
+}
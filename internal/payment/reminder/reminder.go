@@ -0,0 +1,143 @@
+// Package reminder reminds the buyers about the unpaid invoices which are about to expire or have expired.
+// The reminder is recorded once per order before its email is sent, so each order is reminded once
+// across restarts and app replicas.
+package reminder
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jekabolt/grbpwr-manager/internal/dependency"
+	"github.com/jekabolt/grbpwr-manager/internal/dto"
+	"github.com/jekabolt/grbpwr-manager/internal/entity"
+)
+
+type Config struct {
+	// CheckInterval is how often the worker reminds the buyers, the worker is disabled if zero
+	CheckInterval time.Duration `mapstructure:"check_interval"`
+	// ExpiresWithin is how long before the invoice expiration the buyer is reminded
+	ExpiresWithin time.Duration `mapstructure:"expires_within"`
+	// ExpiredWithin is how long after the invoice expiration the buyer is still reminded
+	ExpiredWithin time.Duration `mapstructure:"expired_within"`
+	// BatchSize is the max number of the orders reminded per check
+	BatchSize int `mapstructure:"batch_size"`
+}
+
+const (
+	defaultExpiresWithin = 10 * time.Minute
+	defaultExpiredWithin = 24 * time.Hour
+	defaultBatchSize     = 100
+)
+
+type Reminder struct {
+	c      *Config
+	rep    dependency.Repository
+	mailer dependency.Mailer
+}
+
+// New creates a new reminder sending the emails with the mailer.
+func New(c *Config, rep dependency.Repository, mailer dependency.Mailer) *Reminder {
+	if c.ExpiresWithin <= 0 {
+		c.ExpiresWithin = defaultExpiresWithin
+	}
+	if c.ExpiredWithin <= 0 {
+		c.ExpiredWithin = defaultExpiredWithin
+	}
+	if c.BatchSize <= 0 {
+		c.BatchSize = defaultBatchSize
+	}
+	return &Reminder{
+		c:      c,
+		rep:    rep,
+		mailer: mailer,
+	}
+}
+
+// Start starts the worker reminding the buyers of the unpaid orders.
+func (r *Reminder) Start(ctx context.Context) {
+	if r.c.CheckInterval <= 0 {
+		slog.Default().InfoContext(ctx, "checkout reminder worker is disabled")
+		return
+	}
+	go r.worker(ctx)
+}
+
+func (r *Reminder) worker(ctx context.Context) {
+	ticker := time.NewTicker(r.c.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := r.RemindDue(ctx); err != nil {
+				slog.Default().ErrorContext(ctx, "can't send checkout reminders",
+					slog.String("err", err.Error()),
+				)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// RemindDue reminds the buyers of the orders whose invoice is about to expire or has recently expired,
+// it returns the number of the reminders sent. A reminder which failed to send is retried on the next check.
+func (r *Reminder) RemindDue(ctx context.Context) (int, error) {
+	cs, err := r.rep.Order().GetCheckoutReminderCandidates(ctx, r.c.ExpiresWithin, r.c.ExpiredWithin, r.c.BatchSize)
+	if err != nil {
+		return 0, fmt.Errorf("can't get checkout reminder candidates: %w", err)
+	}
+
+	sent := 0
+	for _, c := range cs {
+		ok, err := r.remind(ctx, c)
+		if err != nil {
+			slog.Default().ErrorContext(ctx, "can't send checkout reminder",
+				slog.String("err", err.Error()),
+				slog.String("orderUUID", c.OrderUUID),
+			)
+			continue
+		}
+		if ok {
+			sent++
+		}
+	}
+	return sent, nil
+}
+
+// remind sends the reminder for the order, it returns false if the order has already been reminded.
+// The reminder is recorded before its email is sent, so the concurrent checks don't send it twice,
+// and is removed if the email fails so it's retried on the next check.
+func (r *Reminder) remind(ctx context.Context, c entity.CheckoutReminderCandidate) (bool, error) {
+	added, err := r.rep.Order().AddCheckoutReminder(ctx, c.OrderId, c.Expired)
+	if err != nil {
+		return false, fmt.Errorf("can't add checkout reminder: %w", err)
+	}
+	if !added {
+		return false, nil
+	}
+
+	err = r.mailer.SendCheckoutReminder(ctx, r.rep, c.Email, &dto.CheckoutReminder{
+		Name:       c.FirstName,
+		OrderUUID:  c.OrderUUID,
+		TotalPrice: c.TotalPrice.Round(2).String(),
+		Expired:    c.Expired,
+	})
+	if err != nil {
+		if errDel := r.rep.Order().DeleteCheckoutReminder(ctx, c.OrderId); errDel != nil {
+			slog.Default().ErrorContext(ctx, "can't delete checkout reminder",
+				slog.String("err", errDel.Error()),
+				slog.String("orderUUID", c.OrderUUID),
+			)
+		}
+		return false, fmt.Errorf("can't send checkout reminder: %w", err)
+	}
+
+	slog.Default().InfoContext(ctx, "checkout reminder sent",
+		slog.String("orderUUID", c.OrderUUID),
+		slog.Bool("expired", c.Expired),
+	)
+	return true, nil
+}
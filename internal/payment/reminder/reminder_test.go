@@ -0,0 +1,103 @@
+package reminder
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jekabolt/grbpwr-manager/internal/dependency"
+	"github.com/jekabolt/grbpwr-manager/internal/dto"
+	"github.com/jekabolt/grbpwr-manager/internal/entity"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeOrder returns the candidates which are not reminded yet.
+type fakeOrder struct {
+	dependency.Order
+	candidates []entity.CheckoutReminderCandidate
+	reminded   map[int]bool
+}
+
+func (f *fakeOrder) GetCheckoutReminderCandidates(ctx context.Context, expiresWithin, expiredWithin time.Duration, limit int) ([]entity.CheckoutReminderCandidate, error) {
+	cs := []entity.CheckoutReminderCandidate{}
+	for _, c := range f.candidates {
+		if !f.reminded[c.OrderId] && len(cs) < limit {
+			cs = append(cs, c)
+		}
+	}
+	return cs, nil
+}
+
+func (f *fakeOrder) AddCheckoutReminder(ctx context.Context, orderId int, expired bool) (bool, error) {
+	if f.reminded[orderId] {
+		return false, nil
+	}
+	f.reminded[orderId] = true
+	return true, nil
+}
+
+func (f *fakeOrder) DeleteCheckoutReminder(ctx context.Context, orderId int) error {
+	delete(f.reminded, orderId)
+	return nil
+}
+
+type fakeRepository struct {
+	dependency.Repository
+	order *fakeOrder
+}
+
+func (r *fakeRepository) Order() dependency.Order {
+	return r.order
+}
+
+// fakeMailer records the reminders sent and fails the recipients in fail.
+type fakeMailer struct {
+	dependency.Mailer
+	sent map[string]*dto.CheckoutReminder
+	fail map[string]bool
+}
+
+func (m *fakeMailer) SendCheckoutReminder(ctx context.Context, rep dependency.Repository, to string, reminderDetails *dto.CheckoutReminder) error {
+	if m.fail[to] {
+		return fmt.Errorf("can't send to %s", to)
+	}
+	m.sent[to] = reminderDetails
+	return nil
+}
+
+func TestRemindDue(t *testing.T) {
+	order := &fakeOrder{
+		candidates: []entity.CheckoutReminderCandidate{
+			{OrderId: 1, OrderUUID: "expiring", TotalPrice: decimal.RequireFromString("100.5"), Email: "expiring@grbpwr.com", FirstName: "a"},
+			{OrderId: 2, OrderUUID: "expired", TotalPrice: decimal.RequireFromString("50"), Email: "expired@grbpwr.com", FirstName: "b", Expired: true},
+			{OrderId: 3, OrderUUID: "failing", TotalPrice: decimal.RequireFromString("10"), Email: "failing@grbpwr.com", FirstName: "c"},
+		},
+		reminded: map[int]bool{},
+	}
+	mailer := &fakeMailer{
+		sent: map[string]*dto.CheckoutReminder{},
+		fail: map[string]bool{"failing@grbpwr.com": true},
+	}
+	r := New(&Config{}, &fakeRepository{order: order}, mailer)
+
+	sent, err := r.RemindDue(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 2, sent)
+	assert.Equal(t, &dto.CheckoutReminder{Name: "a", OrderUUID: "expiring", TotalPrice: "100.5"}, mailer.sent["expiring@grbpwr.com"])
+	assert.True(t, mailer.sent["expired@grbpwr.com"].Expired)
+
+	// the failed reminder is removed and is retried
+	assert.Equal(t, map[int]bool{1: true, 2: true}, order.reminded)
+	delete(mailer.fail, "failing@grbpwr.com")
+	sent, err = r.RemindDue(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, sent)
+	assert.Len(t, mailer.sent, 3)
+
+	// every order is reminded once
+	sent, err = r.RemindDue(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, sent)
+}
@@ -3,9 +3,9 @@ package stripe
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log/slog"
-	"sync"
 	"time"
 
 	"github.com/go-sql-driver/mysql"
@@ -14,6 +14,7 @@ import (
 	"github.com/jekabolt/grbpwr-manager/internal/dto"
 	"github.com/jekabolt/grbpwr-manager/internal/entity"
 	"github.com/jekabolt/grbpwr-manager/log"
+	"github.com/shopspring/decimal"
 
 	"github.com/stripe/stripe-go/v79"
 	"github.com/stripe/stripe-go/v79/client"
@@ -22,6 +23,7 @@ import (
 type Config struct {
 	SecretKey         string        `mapstructure:"secret_key"`
 	PubKey            string        `mapstructure:"pub_key"`
+	WebhookSecret     string        `mapstructure:"webhook_secret"`
 	InvoiceExpiration time.Duration `mapstructure:"invoice_expiration"`
 }
 
@@ -33,15 +35,10 @@ type Processor struct {
 	rep          dependency.Repository
 	stripeClient *client.API
 	pm           entity.PaymentMethod
-
-	// secrets map[string]string //k:clientSecret v: order uuid
-	// mu      sync.Mutex
-
-	monCtxt map[string]context.CancelFunc // tracks monitoring contexts by order uuid
-	ctxMu   sync.Mutex
+	pool         dependency.PaymentPool
 }
 
-func New(ctx context.Context, c *Config, rep dependency.Repository, rs dependency.RatesService, m dependency.Mailer, pmn entity.PaymentMethodName) (dependency.Invoicer, error) {
+func New(ctx context.Context, c *Config, rep dependency.Repository, rs dependency.RatesService, m dependency.Mailer, pool dependency.PaymentPool, pmn entity.PaymentMethodName) (*Processor, error) {
 	ticker, ok := dto.VerifyCurrencyTicker(cache.GetBaseCurrency())
 	if !ok {
 		return nil, fmt.Errorf("invalid default currency: %s", cache.GetBaseCurrency())
@@ -65,12 +62,14 @@ func New(ctx context.Context, c *Config, rep dependency.Repository, rs dependenc
 		stripeClient: client.New(c.SecretKey, nil),
 		rep:          rep,
 		pm:           pm.Method,
-		monCtxt:      make(map[string]context.CancelFunc),
+		pool:         pool,
 	}
 
-	err := p.initAddressesFromUnpaidOrders(ctx)
+	// payment confirmations are delivered by stripe webhook events,
+	// here we only schedule the expiration of invoices issued before the start
+	err := p.pool.ScheduleAwaitingPayments(ctx, p.pm.Name, p.c.InvoiceExpiration)
 	if err != nil {
-		return nil, fmt.Errorf("can't init addresses from unpaid orders: %w", err)
+		return nil, fmt.Errorf("can't schedule unpaid orders: %w", err)
 	}
 
 	return &p, nil
@@ -81,59 +80,101 @@ func isPaymentMethodCard(pm entity.PaymentMethod) bool {
 	return pm.Name == entity.CARD || pm.Name == entity.CARD_TEST
 }
 
-func (p *Processor) initAddressesFromUnpaidOrders(ctx context.Context) error {
-	poids, err := p.rep.Order().GetAwaitingPaymentsByPaymentType(ctx, p.pm.Name)
+// ExpirePayment expires the unpaid invoice of the order and cancels its payment intent,
+// the order is marked as paid instead if the payment intent succeeded without the webhook event.
+func (p *Processor) ExpirePayment(ctx context.Context, rep dependency.Repository, orderUUID string) (bool, error) {
+
+	payment, err := rep.Order().GetPaymentByOrderUUID(ctx, orderUUID)
 	if err != nil {
-		return fmt.Errorf("can't get unpaid orders: %w", err)
+		return false, fmt.Errorf("can't get payment by order id: %w", err)
 	}
 
-	for _, poid := range poids {
-		slog.Default().Info("monitorPayment", slog.Any("poid", poid))
-		go p.monitorPayment(ctx, poid.OrderUUID, &poid.Payment)
+	if payment.IsTransactionDone {
+		return false, nil
 	}
 
-	return nil
-}
-
-// address is our address on which the payment should be made
-func (p *Processor) expireOrderPayment(ctx context.Context, orderUUID string) error {
+	if !payment.ClientSecret.Valid {
+		_, err = rep.Order().ExpireOrderPayment(ctx, orderUUID)
+		if err != nil {
+			return false, fmt.Errorf("can't expire order payment: %w", err)
+		}
+		return false, nil
+	}
 
-	payment, err := p.rep.Order().GetPaymentByOrderUUID(ctx, orderUUID)
+	pi, err := p.GetPaymentIntent(payment.ClientSecret.String)
 	if err != nil {
-		return fmt.Errorf("can't get payment by order id: %w", err)
+		return false, fmt.Errorf("can't get payment intent: %w", err)
 	}
 
-	if payment.IsTransactionDone {
-		return nil
+	if pi.Status == stripe.PaymentIntentStatusSucceeded {
+		err := p.verifyPaymentIntent(*payment, pi)
+		if err == nil {
+			err = p.updateOrderAsPaid(ctx, rep, orderUUID, *payment, pi)
+			if err != nil {
+				return false, fmt.Errorf("can't update order as paid: %w", err)
+			}
+			return true, nil
+		}
+		// the charge doesn't pay the invoice, the invoice expires and the charge is left for a manual review
+		slog.Default().ErrorContext(ctx, "payment intent does not match order invoice amount",
+			slog.String("err", err.Error()),
+			slog.String("orderUUID", orderUUID),
+			slog.String("paymentIntentId", pi.ID),
+		)
+		err = p.addUnmatchedCharge(ctx, rep, orderUUID, pi)
+		if err != nil {
+			return false, fmt.Errorf("can't add unmatched charge: %w", err)
+		}
 	}
 
-	pi, err := p.getPaymentIntent(payment.ClientSecret.String)
+	_, err = rep.Order().ExpireOrderPayment(ctx, orderUUID)
 	if err != nil {
-		return fmt.Errorf("can't get payment intent: %w", err)
+		return false, fmt.Errorf("can't expire order payment: %w", err)
 	}
 
-	switch pi.Status {
-	case stripe.PaymentIntentStatusSucceeded:
-		err := p.updateOrderAsPaid(ctx, p.rep, orderUUID, *payment)
-		if err != nil {
-			return fmt.Errorf("can't update order as paid: %w", err)
-		}
-		return nil
-	default:
-		_, err = p.rep.Order().ExpireOrderPayment(ctx, orderUUID)
-		if err != nil {
-			return fmt.Errorf("can't expire order payment: %w", err)
-		}
-		p.CancelMonitorPayment(orderUUID)
-		p.cancelPaymentIntent(payment.ClientSecret.String)
+	// succeeded payment intent can't be canceled
+	if pi.Status == stripe.PaymentIntentStatusSucceeded {
+		return false, nil
 	}
 
-	return nil
+	_, err = p.cancelPaymentIntent(payment.ClientSecret.String)
+	if err != nil {
+		return false, fmt.Errorf("can't cancel payment intent: %w", err)
+	}
+
+	return false, nil
+}
+
+// paymentIntentPayload is the payment intent recorded in the payment event log without its client secret.
+func paymentIntentPayload(pi *stripe.PaymentIntent) map[string]any {
+	payload := map[string]any{
+		"id":       pi.ID,
+		"status":   pi.Status,
+		"amount":   pi.Amount,
+		"currency": pi.Currency,
+		"created":  pi.Created,
+	}
+	if pi.LatestCharge != nil {
+		payload["latestCharge"] = pi.LatestCharge.ID
+	}
+	if pi.PaymentMethod != nil {
+		payload["paymentMethod"] = pi.PaymentMethod.ID
+	}
+	return payload
 }
 
-func (p *Processor) updateOrderAsPaid(ctx context.Context, rep dependency.Repository, orderUUID string, payment entity.Payment) error {
+func (p *Processor) updateOrderAsPaid(ctx context.Context, rep dependency.Repository, orderUUID string, payment entity.Payment, pi *stripe.PaymentIntent) error {
 	var err error
 
+	err = rep.Order().AddPaymentEvent(ctx, orderUUID, &entity.PaymentEventInsert{
+		Type:          entity.PaymentEventTransactionSeen,
+		TransactionId: sql.NullString{String: pi.ID, Valid: true},
+		Payload:       entity.PaymentEventPayload(paymentIntentPayload(pi)),
+	})
+	if err != nil {
+		return fmt.Errorf("can't add payment event: %w", err)
+	}
+
 	payment.IsTransactionDone = true
 	_, err = rep.Order().OrderPaymentDone(ctx, orderUUID, &payment)
 	if err != nil {
@@ -149,19 +190,21 @@ func (p *Processor) updateOrderAsPaid(ctx context.Context, rep dependency.Reposi
 		slog.Default().InfoContext(ctx, "Order marked as paid", slog.String("orderUUID", orderUUID))
 	}
 
-	of, err := rep.Order().GetOrderFullByUUID(ctx, orderUUID)
+	return nil
+}
+
+// sendOrderConfirmation sends the confirmation of the paid order, it's sent once the payment is committed.
+func (p *Processor) sendOrderConfirmation(ctx context.Context, orderUUID string) error {
+	of, err := p.rep.Order().GetOrderFullByUUID(ctx, orderUUID)
 	if err != nil {
 		return fmt.Errorf("can't get order by id: %w", err)
 	}
 
-	orderDetails := dto.OrderFullToOrderConfirmed(of)
-	err = p.mailer.SendOrderConfirmation(ctx, rep, of.Buyer.Email, orderDetails)
+	err = p.mailer.SendOrderConfirmation(ctx, p.rep, of.Buyer.Email, dto.OrderFullToOrderConfirmed(of))
 	if err != nil {
 		return fmt.Errorf("can't send order confirmation: %w", err)
 	}
-
 	return nil
-
 }
 
 // GetOrderInvoice returns the payment details for the given order and expiration date.
@@ -193,27 +236,45 @@ func (p *Processor) GetOrderInvoice(ctx context.Context, orderUUID string) (*ent
 		return &payment.PaymentInsert, expiration, nil
 	}
 
-	pi, err := p.createPaymentIntent(*of)
+	currency, err := p.paymentCurrency(*payment)
+	if err != nil {
+		return nil, expiration, fmt.Errorf("can't get payment currency: %w", err)
+	}
+
+	total, rate, err := p.presentmentAmount(currency, of.Order.AmountDue())
+	if err != nil {
+		return nil, expiration, fmt.Errorf("can't convert order total: %w", err)
+	}
+
+	pi, err := p.createPaymentIntent(*of, currency, total)
 	if err != nil {
 		return nil, expiration, fmt.Errorf("can't create payment intent: %w", err)
 	}
 
+	var invoiceErr error
 	err = p.rep.Tx(ctx, func(ctx context.Context, rep dependency.Repository) error {
-		of, err = p.rep.Order().InsertFiatInvoice(ctx, orderUUID, pi.ClientSecret, p.pm)
-		if err != nil {
-			return fmt.Errorf("can't insert fiat invoice: %w", err)
+		of, invoiceErr = rep.Order().InsertFiatInvoice(ctx, orderUUID, pi.ClientSecret, p.pm)
+		if errors.Is(invoiceErr, entity.ErrOrderNotInvoiced) {
+			// keep the changes made to the order instead of the invoice
+			return nil
+		}
+		if invoiceErr != nil {
+			return fmt.Errorf("can't insert fiat invoice: %w", invoiceErr)
 		}
 		payment.PaymentInsert.ClientSecret = sql.NullString{
 			String: pi.ClientSecret,
 			Valid:  true,
 		}
 
-		payment.TransactionAmountPaymentCurrency = of.Order.TotalPriceDecimal()
-		payment.TransactionAmount = of.Order.TotalPriceDecimal()
+		// base currency total is kept for accounting, the card is charged in the payment currency
+		payment.TransactionAmount = of.Order.AmountDue()
+		payment.TransactionAmountPaymentCurrency = total
+		payment.PaymentCurrency = sql.NullString{String: currency.String(), Valid: true}
+		payment.PaymentCurrencyRate = decimal.NullDecimal{Decimal: rate, Valid: true}
 
-		err = p.rep.Order().UpdateTotalPaymentCurrency(ctx, orderUUID, payment.TransactionAmount)
+		err = rep.Order().UpdatePaymentCurrencyRate(ctx, orderUUID, total, rate)
 		if err != nil {
-			return fmt.Errorf("can't update total payment currency: %w", err)
+			return fmt.Errorf("can't update payment currency rate: %w", err)
 		}
 
 		return nil
@@ -222,102 +283,80 @@ func (p *Processor) GetOrderInvoice(ctx context.Context, orderUUID string) (*ent
 	if err != nil {
 		return nil, expiration, fmt.Errorf("can't insert fiat invoice: %w", err)
 	}
+	if invoiceErr != nil {
+		return nil, expiration, fmt.Errorf("can't insert fiat invoice: %w", invoiceErr)
+	}
 
-	go p.monitorPayment(context.TODO(), orderUUID, payment)
+	expiration = time.Now().Add(p.c.InvoiceExpiration)
+	err = p.pool.AddPaymentExpiration(ctx, orderUUID, p.pm.Name, expiration)
+	if err != nil {
+		return nil, expiration, fmt.Errorf("can't add payment expiration: %w", err)
+	}
 
 	return &payment.PaymentInsert, expiration, err
 }
 
-func (p *Processor) monitorPayment(ctx context.Context, orderUUID string, payment *entity.Payment) {
-	ctx, cancel := context.WithCancel(ctx)
-	p.ctxMu.Lock()
-	p.monCtxt[orderUUID] = cancel
-	p.ctxMu.Unlock()
-
-	defer cancel() // Ensure the context is cancelled when the monitoring stops.
-	defer func() {
-		p.ctxMu.Lock()
-		delete(p.monCtxt, orderUUID) // Clean up the map when monitoring ends.
-		p.ctxMu.Unlock()
-	}()
-
-	if payment.IsTransactionDone {
-		return // Exit the loop once the payment is done.
-	}
-
-	// Calculate the expiration time based on the payment.ModifiedAt and p.c.InvoiceExpiration.
-	expirationDuration := time.Until(payment.ModifiedAt.Add(p.c.InvoiceExpiration))
-
-	expirationTimer := time.NewTimer(expirationDuration)
-	defer expirationTimer.Stop()
-
-	for {
-		select {
-		case <-ctx.Done():
-			slog.Default().DebugContext(ctx, "context cancelled, stopping payment monitoring")
-			return
-		case <-expirationTimer.C:
-			slog.Default().InfoContext(ctx, "order payment expired",
-				slog.String("orderUUID", orderUUID))
-			if err := p.expireOrderPayment(ctx, orderUUID); err != nil {
-				slog.Default().ErrorContext(ctx, "can't expire order payment",
-					slog.String("err", err.Error()),
-				)
-			}
-			return // exit the loop once the payment has expired.
-		}
+// Refund refunds the amount in base currency of the paid order payment in the currency it was charged in,
+// it returns the id of the stripe refund and its status.
+func (p *Processor) Refund(ctx context.Context, payment entity.Payment, amount decimal.Decimal, idempotencyKey string) (string, entity.RefundStatus, error) {
+	if !payment.IsTransactionDone || !payment.ClientSecret.Valid {
+		return "", entity.RefundFailed, fmt.Errorf("payment is not done")
 	}
 
-}
-
-func (p *Processor) CancelMonitorPayment(orderUUID string) error {
-	p.ctxMu.Lock()
-	defer p.ctxMu.Unlock()
-
-	if cancel, exists := p.monCtxt[orderUUID]; exists {
-		cancel()                     // Cancel the monitoring context.
-		delete(p.monCtxt, orderUUID) // Clean up the map.
-		return nil
+	currency, err := p.paymentCurrency(payment)
+	if err != nil {
+		return "", entity.RefundFailed, fmt.Errorf("can't get payment currency: %w", err)
 	}
-	return fmt.Errorf("no monitoring process found for order ID: %s", orderUUID)
-}
-
-func (p *Processor) CheckForTransactions(ctx context.Context, orderUUID string, payment entity.Payment) (*entity.Payment, error) {
 
-	if payment.IsTransactionDone {
-		return &payment, nil
+	// refund at the rate the payment was charged at
+	total := amount
+	if payment.PaymentCurrencyRate.Valid {
+		total = amount.Mul(payment.PaymentCurrencyRate.Decimal)
+	}
+	if total.GreaterThan(payment.TransactionAmountPaymentCurrency) {
+		total = payment.TransactionAmountPaymentCurrency
 	}
 
-	o, err := p.rep.Order().GetOrderByUUID(ctx, orderUUID)
+	r, err := p.refundPaymentIntent(payment.ClientSecret.String, minorUnits(currency, total), idempotencyKey)
 	if err != nil {
-		return nil, fmt.Errorf("can't get order by id: %w", err)
+		return "", entity.RefundFailed, fmt.Errorf("can't refund payment intent: %w", err)
 	}
 
-	if o.OrderStatusId != cache.OrderStatusAwaitingPayment.Status.Id {
-		return &payment, nil
+	slog.Default().InfoContext(ctx, "payment refunded",
+		slog.String("refund_id", r.ID),
+		slog.String("status", string(r.Status)),
+		slog.Int64("amount", r.Amount),
+	)
+
+	switch r.Status {
+	case stripe.RefundStatusSucceeded:
+		return r.ID, entity.RefundSucceeded, nil
+	case stripe.RefundStatusFailed, stripe.RefundStatusCanceled:
+		return r.ID, entity.RefundFailed, nil
+	default:
+		return r.ID, entity.RefundPending, nil
 	}
+}
 
-	pi, err := p.getPaymentIntent(payment.ClientSecret.String)
+// CancelMonitorPayment cancels the scheduled expiration and the payment intent of the order invoice
+// so the client secret the customer already has can't pay the cancelled order.
+func (p *Processor) CancelMonitorPayment(orderUUID string, payment entity.Payment) error {
+	err := p.pool.RemovePaymentExpiration(context.Background(), orderUUID)
 	if err != nil {
-		slog.Default().Info("can't get payment intent", "err", err)
-		return &payment, fmt.Errorf("can't get payment intent: %w", err)
+		return fmt.Errorf("can't remove payment expiration: %w", err)
 	}
 
-	if pi != nil {
-		switch pi.Status {
-		case stripe.PaymentIntentStatusSucceeded:
-			slog.Default().Info("payment intent succeeded", "pi", pi)
-			err := p.updateOrderAsPaid(ctx, p.rep, orderUUID, payment)
-			if err != nil {
-				return nil, fmt.Errorf("can't update order as paid: %w", err)
-			}
-			return &payment, nil
-		default:
-			slog.Default().Info("payment intent not succeeded", "pi", pi)
-			return &payment, nil
-		}
+	if !payment.ClientSecret.Valid {
+		return nil
+	}
+	_, err = p.cancelPaymentIntent(payment.ClientSecret.String)
+	if err != nil {
+		return fmt.Errorf("can't cancel payment intent: %w", err)
 	}
+	return nil
+}
 
+// CheckForTransactions returns the payment as is, its state is updated by stripe webhook events.
+func (p *Processor) CheckForTransactions(ctx context.Context, orderUUID string, payment entity.Payment) (*entity.Payment, error) {
 	return &payment, nil
-
 }
@@ -5,29 +5,37 @@ import (
 	"testing"
 
 	"github.com/google/uuid"
-	"github.com/jekabolt/grbpwr-manager/internal/dependency/mocks"
+	"github.com/jekabolt/grbpwr-manager/internal/dependency"
 	"github.com/jekabolt/grbpwr-manager/internal/dto"
 	"github.com/jekabolt/grbpwr-manager/internal/entity"
 	"github.com/shopspring/decimal"
 	"github.com/stretchr/testify/assert"
+	"github.com/stripe/stripe-go/v79/client"
 )
 
-func TestCreatePaymentIntent(t *testing.T) {
-	// Setup mock RatesService
+// fakeRates converts the amounts at the fixed rates to the base currency.
+type fakeRates struct {
+	dependency.RatesService
+	rates map[dto.CurrencyTicker]decimal.Decimal
+}
 
+func (r *fakeRates) ConvertFromBaseCurrency(currencyTo dto.CurrencyTicker, amount decimal.Decimal) (decimal.Decimal, error) {
+	return amount.Mul(r.rates[currencyTo]), nil
+}
+
+func TestCreatePaymentIntent(t *testing.T) {
 	config := &Config{
 		SecretKey: "sk_test_",
 		PubKey:    "pk_test_",
 	}
 
-	mockRatesService := mocks.NewRatesService(t)
-
-	// Create Processor with the mock RatesService
-	processor, err := New(config, mockRatesService)
-	assert.NoError(t, err)
-
-	// Mocking the response of ConvertFromBaseCurrency
-	mockRatesService.EXPECT().ConvertFromBaseCurrency(dto.CurrencyTicker("USD"), decimal.NewFromFloat(20.00)).Return(decimal.NewFromFloat(20.00), nil)
+	processor := &Processor{
+		c:            config,
+		baseCurrency: dto.CurrencyTicker("USD"),
+		rates:        &fakeRates{rates: map[dto.CurrencyTicker]decimal.Decimal{"USD": decimal.NewFromInt(1)}},
+		stripeClient: client.New(config.SecretKey, nil),
+		pm:           entity.PaymentMethod{Id: 1, Name: entity.CARD},
+	}
 
 	// Create a sample order
 	order := entity.OrderFull{
@@ -58,7 +66,9 @@ func TestCreatePaymentIntent(t *testing.T) {
 	pi, err := processor.CreatePaymentIntent(order)
 
 	// Assertions
-	assert.NoError(t, err)
+	if !assert.NoError(t, err) {
+		return
+	}
 
 	t.Logf("PaymentIntent: %+v ", pi.ClientSecret)
 
@@ -4,26 +4,79 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/jekabolt/grbpwr-manager/internal/dto"
 	"github.com/jekabolt/grbpwr-manager/internal/entity"
 	"github.com/shopspring/decimal"
 	"github.com/stripe/stripe-go/v79"
 )
 
-// createPaymentIntent creates a PaymentIntent with the specified amount, currency, and payment method types
-func (p *Processor) createPaymentIntent(order entity.OrderFull) (*stripe.PaymentIntent, error) {
+// paymentCurrency returns the currency the order payment is charged in, the base currency if not set.
+func (p *Processor) paymentCurrency(payment entity.Payment) (dto.CurrencyTicker, error) {
+	if !payment.PaymentCurrency.Valid || payment.PaymentCurrency.String == "" {
+		return p.baseCurrency, nil
+	}
+	ticker, ok := dto.VerifyCurrencyTicker(payment.PaymentCurrency.String)
+	if !ok || !dto.IsFiatCurrency(ticker) {
+		return "", fmt.Errorf("invalid payment currency: %s", payment.PaymentCurrency.String)
+	}
+	return ticker, nil
+}
 
-	// Convert the order total to the stripe payment base currency
-	total, err := p.rates.ConvertFromBaseCurrency(p.baseCurrency, order.Order.TotalPrice)
+// presentmentAmount converts the amount in base currency to the payment currency
+// rounded to its smallest unit, it returns the converted amount and the rate.
+func (p *Processor) presentmentAmount(currency dto.CurrencyTicker, amount decimal.Decimal) (decimal.Decimal, decimal.Decimal, error) {
+	total, err := p.rates.ConvertFromBaseCurrency(currency, amount)
 	if err != nil {
-		return nil, fmt.Errorf("failed to convert order total to base currency: %v", err)
+		return decimal.Zero, decimal.Zero, fmt.Errorf("failed to convert amount to %s: %v", currency, err)
+	}
+	rate := decimal.NewFromInt(1)
+	if currency != p.baseCurrency && !amount.IsZero() {
+		rate = total.Div(amount)
 	}
+	return total.Round(dto.CurrencyMinorUnits(currency)), rate, nil
+}
+
+// minorUnits returns the amount in the smallest currency unit (e.g., cents for USD).
+func minorUnits(currency dto.CurrencyTicker, amount decimal.Decimal) int64 {
+	return amount.Shift(dto.CurrencyMinorUnits(currency)).Round(0).IntPart()
+}
 
-	// Calculate the order amount in cents
-	amountCents := total.Mul(decimal.NewFromInt(100)).IntPart()
+// verifyPaymentIntent checks the payment intent charges the invoice amount in the invoice currency.
+func (p *Processor) verifyPaymentIntent(payment entity.Payment, pi *stripe.PaymentIntent) error {
+	currency, err := p.paymentCurrency(payment)
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(string(pi.Currency), currency.String()) {
+		return fmt.Errorf("payment intent currency %s doesn't match invoice currency %s", pi.Currency, currency)
+	}
+	amount := minorUnits(currency, payment.TransactionAmountPaymentCurrency)
+	if pi.Amount != amount {
+		return fmt.Errorf("payment intent amount %d doesn't match invoice amount %d", pi.Amount, amount)
+	}
+	return nil
+}
 
+// CreatePaymentIntent creates a PaymentIntent with the specified amount, currency, and payment method types
+func (p *Processor) CreatePaymentIntent(order entity.OrderFull) (*stripe.PaymentIntent, error) {
+	currency, err := p.paymentCurrency(order.Payment)
+	if err != nil {
+		return nil, err
+	}
+
+	// Convert the order total to the payment currency
+	total, _, err := p.presentmentAmount(currency, order.Order.AmountDue())
+	if err != nil {
+		return nil, err
+	}
+
+	return p.createPaymentIntent(order, currency, total)
+}
+
+func (p *Processor) createPaymentIntent(order entity.OrderFull, currency dto.CurrencyTicker, total decimal.Decimal) (*stripe.PaymentIntent, error) {
 	params := &stripe.PaymentIntentParams{
-		Amount:             stripe.Int64(amountCents),                                 // Amount to charge in the smallest currency unit (e.g., cents for USD)
-		Currency:           stripe.String(p.baseCurrency.String()),                    // Currency in which to charge the payment
+		Amount:             stripe.Int64(minorUnits(currency, total)),                 // Amount to charge in the smallest currency unit (e.g., cents for USD)
+		Currency:           stripe.String(strings.ToLower(currency.String())),         // Currency in which to charge the payment
 		PaymentMethodTypes: stripe.StringSlice(PaymentMethodTypes),                    // Types of payment methods (e.g., "card")
 		ReceiptEmail:       stripe.String(order.Buyer.Email),                          // Email to send the receipt to
 		Description:        stripe.String(fmt.Sprintf("order #%s", order.Order.UUID)), // Description of the payment
@@ -52,7 +105,7 @@ func (p *Processor) createPaymentIntent(order entity.OrderFull) (*stripe.Payment
 	return pi, nil
 }
 
-func (p *Processor) getPaymentIntent(paymentSecret string) (*stripe.PaymentIntent, error) {
+func (p *Processor) GetPaymentIntent(paymentSecret string) (*stripe.PaymentIntent, error) {
 
 	paymentIntentID := trimSecret(paymentSecret)
 
@@ -75,6 +128,23 @@ func (p *Processor) cancelPaymentIntent(paymentSecret string) (*stripe.PaymentIn
 	return pi, nil
 }
 
+// refundPaymentIntent refunds the amount in cents of the PaymentIntent,
+// the idempotency key makes the retries of the same refund safe.
+func (p *Processor) refundPaymentIntent(paymentSecret string, amountCents int64, idempotencyKey string) (*stripe.Refund, error) {
+	params := &stripe.RefundParams{
+		PaymentIntent: stripe.String(trimSecret(paymentSecret)),
+		Amount:        stripe.Int64(amountCents),
+	}
+	params.SetIdempotencyKey(idempotencyKey)
+
+	r, err := p.stripeClient.Refunds.New(params)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create refund: %v", err)
+	}
+
+	return r, nil
+}
+
 func trimSecret(s string) string {
 	// Find the index of "_secret_"
 	index := strings.Index(s, "_secret_")
@@ -0,0 +1,238 @@
+package stripe
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/jekabolt/grbpwr-manager/internal/dependency"
+	"github.com/jekabolt/grbpwr-manager/internal/dto"
+	"github.com/jekabolt/grbpwr-manager/internal/entity"
+	"github.com/shopspring/decimal"
+	"github.com/stripe/stripe-go/v79"
+	"github.com/stripe/stripe-go/v79/webhook"
+)
+
+const (
+	// maxWebhookBodyBytes is the max size of the webhook payload stripe can send.
+	maxWebhookBodyBytes = int64(65536)
+	signatureHeader     = "Stripe-Signature"
+)
+
+// Webhook is an http handler for stripe webhook events.
+// Each event is verified against the webhook secrets of the given processors
+// and handled by the processor whose secret matches the signature.
+type Webhook struct {
+	processors []*Processor
+}
+
+// NewWebhook creates a new stripe webhook handler.
+func NewWebhook(ps ...*Processor) *Webhook {
+	return &Webhook{
+		processors: ps,
+	}
+}
+
+func (wh *Webhook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	payload, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBodyBytes))
+	if err != nil {
+		slog.Default().ErrorContext(ctx, "can't read stripe webhook body",
+			slog.String("err", err.Error()),
+		)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	signature := r.Header.Get(signatureHeader)
+
+	for _, p := range wh.processors {
+		if p.c.WebhookSecret == "" {
+			continue
+		}
+
+		event, err := webhook.ConstructEventWithOptions(payload, signature, p.c.WebhookSecret, webhook.ConstructEventOptions{
+			IgnoreAPIVersionMismatch: true,
+		})
+		if err != nil {
+			continue
+		}
+
+		if err := p.handleEvent(ctx, event); err != nil {
+			slog.Default().ErrorContext(ctx, "can't handle stripe webhook event",
+				slog.String("err", err.Error()),
+				slog.String("eventId", event.ID),
+				slog.String("eventType", string(event.Type)),
+			)
+			// non 2xx response makes stripe retry the event delivery
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	slog.Default().ErrorContext(ctx, "can't verify stripe webhook signature")
+	w.WriteHeader(http.StatusBadRequest)
+}
+
+// handleEvent updates the order payment according to the payment intent event.
+// Every event is processed at most once.
+func (p *Processor) handleEvent(ctx context.Context, event stripe.Event) error {
+	switch event.Type {
+	case stripe.EventTypePaymentIntentSucceeded,
+		stripe.EventTypePaymentIntentPaymentFailed,
+		stripe.EventTypePaymentIntentCanceled:
+	default:
+		slog.Default().DebugContext(ctx, "skip stripe webhook event",
+			slog.String("eventType", string(event.Type)),
+		)
+		return nil
+	}
+
+	var pi stripe.PaymentIntent
+	if err := json.Unmarshal(event.Data.Raw, &pi); err != nil {
+		return fmt.Errorf("can't unmarshal payment intent: %w", err)
+	}
+
+	orderUUID, ok := pi.Metadata["order_id"]
+	if !ok || orderUUID == "" {
+		slog.Default().WarnContext(ctx, "payment intent has no order id",
+			slog.String("paymentIntentId", pi.ID),
+		)
+		return nil
+	}
+
+	cancelIntent, paid := false, false
+	err := p.rep.Tx(ctx, func(ctx context.Context, rep dependency.Repository) error {
+		processed, err := rep.Order().MarkWebhookEventProcessed(ctx, event.ID, string(event.Type))
+		if err != nil {
+			return fmt.Errorf("can't mark webhook event processed: %w", err)
+		}
+		if !processed {
+			slog.Default().InfoContext(ctx, "stripe webhook event already processed",
+				slog.String("eventId", event.ID),
+			)
+			return nil
+		}
+
+		payment, err := rep.Order().GetPaymentByOrderUUID(ctx, orderUUID)
+		if err != nil {
+			return fmt.Errorf("can't get payment by order id: %w", err)
+		}
+
+		// the event may belong to a payment intent issued for an expired or cancelled invoice,
+		// its charge doesn't pay the order and is left for the manual refund
+		if payment.PaymentMethodID != p.pm.Id || trimSecret(payment.ClientSecret.String) != pi.ID {
+			if event.Type != stripe.EventTypePaymentIntentSucceeded {
+				slog.Default().InfoContext(ctx, "payment intent does not match order invoice",
+					slog.String("orderUUID", orderUUID),
+					slog.String("paymentIntentId", pi.ID),
+				)
+				return nil
+			}
+			slog.Default().ErrorContext(ctx, "succeeded payment intent does not match order invoice",
+				slog.String("orderUUID", orderUUID),
+				slog.String("paymentIntentId", pi.ID),
+			)
+			return p.addUnmatchedCharge(ctx, rep, orderUUID, &pi)
+		}
+
+		if payment.IsTransactionDone {
+			return nil
+		}
+
+		switch event.Type {
+		case stripe.EventTypePaymentIntentSucceeded:
+			// the charge doesn't pay the invoice, leave the order unpaid for a manual review
+			if err := p.verifyPaymentIntent(*payment, &pi); err != nil {
+				slog.Default().ErrorContext(ctx, "payment intent does not match order invoice amount",
+					slog.String("err", err.Error()),
+					slog.String("orderUUID", orderUUID),
+					slog.String("paymentIntentId", pi.ID),
+				)
+				return p.addUnmatchedCharge(ctx, rep, orderUUID, &pi)
+			}
+			payment.TransactionID = sql.NullString{
+				String: pi.ID,
+				Valid:  true,
+			}
+			err = p.updateOrderAsPaid(ctx, rep, orderUUID, *payment, &pi)
+			if err != nil {
+				return fmt.Errorf("can't update order as paid: %w", err)
+			}
+			paid = true
+		default:
+			_, err = rep.Order().ExpireOrderPayment(ctx, orderUUID)
+			if err != nil {
+				return fmt.Errorf("can't expire order payment: %w", err)
+			}
+			cancelIntent = event.Type == stripe.EventTypePaymentIntentPaymentFailed
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// the mail is retried by the mail worker, the paid order isn't rolled back
+	if paid {
+		if err := p.sendOrderConfirmation(ctx, orderUUID); err != nil {
+			slog.Default().ErrorContext(ctx, "can't send order confirmation",
+				slog.String("err", err.Error()),
+				slog.String("orderUUID", orderUUID),
+			)
+		}
+	}
+
+	// failed payment intent can be retried by the customer, cancel it
+	// so the expired order can't be paid anymore
+	if cancelIntent {
+		if _, err := p.cancelPaymentIntent(pi.ID); err != nil {
+			slog.Default().ErrorContext(ctx, "can't cancel payment intent",
+				slog.String("err", err.Error()),
+				slog.String("paymentIntentId", pi.ID),
+			)
+		}
+	}
+
+	return nil
+}
+
+// addUnmatchedCharge records the charge of the succeeded payment intent which doesn't pay the order invoice,
+// the whole charge is recorded as the overpayment of the order for the manual refund.
+func (p *Processor) addUnmatchedCharge(ctx context.Context, rep dependency.Repository, orderUUID string, pi *stripe.PaymentIntent) error {
+	transactionId := sql.NullString{String: pi.ID, Valid: true}
+	err := rep.Order().AddPaymentEvent(ctx, orderUUID, &entity.PaymentEventInsert{
+		Type:          entity.PaymentEventTransactionSeen,
+		TransactionId: transactionId,
+		Payload:       entity.PaymentEventPayload(paymentIntentPayload(pi)),
+	})
+	if err != nil {
+		return fmt.Errorf("can't add payment event: %w", err)
+	}
+
+	currency := dto.CurrencyTicker(strings.ToUpper(string(pi.Currency)))
+	err = rep.Order().AddPaymentOverpayment(ctx, orderUUID, &entity.PaymentOverpaymentInsert{
+		PaymentMethodId:       p.pm.Id,
+		AmountPaymentCurrency: decimal.New(pi.Amount, -dto.CurrencyMinorUnits(currency)),
+		TransactionId:         transactionId,
+	})
+	if err != nil {
+		return fmt.Errorf("can't add payment overpayment: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,221 @@
+package stripe
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jekabolt/grbpwr-manager/internal/dependency"
+	"github.com/jekabolt/grbpwr-manager/internal/dto"
+	"github.com/jekabolt/grbpwr-manager/internal/entity"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stripe/stripe-go/v79/webhook"
+)
+
+const testWebhookSecret = "whsec_test"
+
+// fakeOrder keeps the payment of a single order, the processed webhook events and the recorded overpayment.
+type fakeOrder struct {
+	dependency.Order
+	payment     entity.Payment
+	processed   map[string]bool
+	events      []entity.PaymentEventType
+	overpayment *entity.PaymentOverpaymentInsert
+}
+
+func (f *fakeOrder) MarkWebhookEventProcessed(ctx context.Context, eventId string, eventType string) (bool, error) {
+	if f.processed[eventId] {
+		return false, nil
+	}
+	f.processed[eventId] = true
+	return true, nil
+}
+
+func (f *fakeOrder) GetPaymentByOrderUUID(ctx context.Context, orderUUID string) (*entity.Payment, error) {
+	p := f.payment
+	return &p, nil
+}
+
+func (f *fakeOrder) AddPaymentEvent(ctx context.Context, orderUUID string, pe *entity.PaymentEventInsert) error {
+	f.events = append(f.events, pe.Type)
+	return nil
+}
+
+func (f *fakeOrder) AddPaymentOverpayment(ctx context.Context, orderUUID string, op *entity.PaymentOverpaymentInsert) error {
+	f.overpayment = op
+	return nil
+}
+
+func (f *fakeOrder) OrderPaymentDone(ctx context.Context, orderUUID string, p *entity.Payment) (*entity.Payment, error) {
+	f.payment.IsTransactionDone = true
+	f.payment.TransactionID = p.TransactionID
+	return &f.payment, nil
+}
+
+func (f *fakeOrder) GetOrderFullByUUID(ctx context.Context, orderUUID string) (*entity.OrderFull, error) {
+	return &entity.OrderFull{Order: entity.Order{UUID: orderUUID}}, nil
+}
+
+type fakeRepository struct {
+	dependency.Repository
+	order *fakeOrder
+}
+
+func (r *fakeRepository) Order() dependency.Order {
+	return r.order
+}
+
+func (r *fakeRepository) Tx(ctx context.Context, f func(context.Context, dependency.Repository) error) error {
+	return f(ctx, r)
+}
+
+// fakeMailer counts the order confirmations sent.
+type fakeMailer struct {
+	dependency.Mailer
+	confirmed int
+}
+
+func (m *fakeMailer) SendOrderConfirmation(ctx context.Context, rep dependency.Repository, to string, orderDetails *dto.OrderConfirmed) error {
+	m.confirmed++
+	return nil
+}
+
+// newTestWebhook returns the webhook of the card processor with the unpaid invoice of 100.50 EUR.
+func newTestWebhook() (*Webhook, *fakeOrder, *fakeMailer) {
+	order := &fakeOrder{
+		payment: entity.Payment{
+			PaymentInsert: entity.PaymentInsert{
+				PaymentMethodID:                  1,
+				ClientSecret:                     sql.NullString{String: "pi_test_secret_test", Valid: true},
+				TransactionAmount:                decimal.RequireFromString("100.50"),
+				TransactionAmountPaymentCurrency: decimal.RequireFromString("100.50"),
+				PaymentCurrency:                  sql.NullString{String: "EUR", Valid: true},
+			},
+		},
+		processed: map[string]bool{},
+	}
+	mailer := &fakeMailer{}
+	p := &Processor{
+		c:            &Config{WebhookSecret: testWebhookSecret},
+		baseCurrency: dto.CurrencyTicker("EUR"),
+		mailer:       mailer,
+		rep:          &fakeRepository{order: order},
+		pm:           entity.PaymentMethod{Id: 1, Name: entity.CARD},
+	}
+	return NewWebhook(p), order, mailer
+}
+
+// succeededEvent returns the payment intent succeeded event of the test order.
+func succeededEvent(t *testing.T, eventId string, amount int64, currency string) []byte {
+	payload, err := json.Marshal(map[string]any{
+		"id":          eventId,
+		"object":      "event",
+		"type":        "payment_intent.succeeded",
+		"api_version": "2024-06-20",
+		"data": map[string]any{
+			"object": map[string]any{
+				"id":       "pi_test",
+				"object":   "payment_intent",
+				"amount":   amount,
+				"currency": currency,
+				"status":   "succeeded",
+				"metadata": map[string]string{"order_id": "order-uuid"},
+			},
+		},
+	})
+	assert.NoError(t, err)
+	return payload
+}
+
+func deliver(wh *Webhook, payload []byte, secret string) int {
+	signed := webhook.GenerateTestSignedPayload(&webhook.UnsignedPayload{
+		Payload: payload,
+		Secret:  secret,
+	})
+	req := httptest.NewRequest(http.MethodPost, "/stripe/webhook", bytes.NewReader(payload))
+	req.Header.Set(signatureHeader, signed.Header)
+	rec := httptest.NewRecorder()
+	wh.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestWebhookBadSignature(t *testing.T) {
+	wh, order, mailer := newTestWebhook()
+
+	code := deliver(wh, succeededEvent(t, "evt_1", 10050, "eur"), "whsec_other")
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.False(t, order.payment.IsTransactionDone)
+	assert.Empty(t, order.processed)
+	assert.Equal(t, 0, mailer.confirmed)
+}
+
+func TestWebhookDuplicateEvent(t *testing.T) {
+	wh, order, mailer := newTestWebhook()
+	payload := succeededEvent(t, "evt_1", 10050, "eur")
+
+	code := deliver(wh, payload, testWebhookSecret)
+	assert.Equal(t, http.StatusOK, code)
+	assert.True(t, order.payment.IsTransactionDone)
+	assert.Equal(t, "pi_test", order.payment.TransactionID.String)
+	assert.Equal(t, 1, mailer.confirmed)
+
+	// the redelivered event is acknowledged without processing it again
+	order.payment.IsTransactionDone = false
+	code = deliver(wh, payload, testWebhookSecret)
+	assert.Equal(t, http.StatusOK, code)
+	assert.False(t, order.payment.IsTransactionDone)
+	assert.Equal(t, 1, mailer.confirmed)
+	assert.Len(t, order.events, 1)
+}
+
+func TestWebhookAmountMismatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		amount   int64
+		currency string
+		charged  decimal.Decimal
+	}{
+		{name: "amount", amount: 100, currency: "eur", charged: decimal.RequireFromString("1")},
+		{name: "currency", amount: 10050, currency: "usd", charged: decimal.RequireFromString("100.50")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wh, order, mailer := newTestWebhook()
+
+			code := deliver(wh, succeededEvent(t, "evt_"+tt.name, tt.amount, tt.currency), testWebhookSecret)
+			assert.Equal(t, http.StatusOK, code)
+			assert.False(t, order.payment.IsTransactionDone)
+			assert.Equal(t, 0, mailer.confirmed)
+
+			// the charge is recorded for the manual refund
+			assert.Equal(t, []entity.PaymentEventType{entity.PaymentEventTransactionSeen}, order.events)
+			if assert.NotNil(t, order.overpayment) {
+				assert.True(t, order.overpayment.AmountPaymentCurrency.Equal(tt.charged), order.overpayment.AmountPaymentCurrency.String())
+				assert.Equal(t, "pi_test", order.overpayment.TransactionId.String)
+			}
+		})
+	}
+}
+
+func TestWebhookUnmatchedPaymentIntent(t *testing.T) {
+	wh, order, mailer := newTestWebhook()
+
+	// the invoice of the order was cancelled, the payment intent issued for it succeeds afterwards
+	order.payment.ClientSecret = sql.NullString{}
+
+	code := deliver(wh, succeededEvent(t, "evt_1", 10050, "eur"), testWebhookSecret)
+	assert.Equal(t, http.StatusOK, code)
+	assert.False(t, order.payment.IsTransactionDone)
+	assert.Equal(t, 0, mailer.confirmed)
+
+	assert.Equal(t, []entity.PaymentEventType{entity.PaymentEventTransactionSeen}, order.events)
+	if assert.NotNil(t, order.overpayment) {
+		assert.True(t, order.overpayment.AmountPaymentCurrency.Equal(decimal.RequireFromString("100.50")), order.overpayment.AmountPaymentCurrency.String())
+		assert.Equal(t, "pi_test", order.overpayment.TransactionId.String)
+	}
+}
@@ -0,0 +1,57 @@
+package tron
+
+import (
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/jekabolt/grbpwr-manager/internal/payment/hdwallet"
+	"github.com/mr-tron/base58"
+)
+
+const (
+	// addressPrefix is the prefix of TRON addresses, the same for mainnet and shasta testnet
+	addressPrefix = 0x41
+	// defaultDerivationPath is the BIP44 path of the TRON external chain for the first account
+	defaultDerivationPath = "m/44'/195'/0'/0"
+)
+
+// hdWallet derives TRON deposit addresses from the extended public key of the external chain.
+type hdWallet struct {
+	w *hdwallet.Wallet
+}
+
+// newHDWallet creates a wallet either from the extended public key of the
+// external chain or from the mnemonic and the derivation path of the external chain.
+func newHDWallet(xpub, mnemonic, path string) (*hdWallet, error) {
+	if path == "" {
+		path = defaultDerivationPath
+	}
+	w, err := hdwallet.New(xpub, mnemonic, path)
+	if err != nil {
+		return nil, err
+	}
+	return &hdWallet{w: w}, nil
+}
+
+// address returns the TRON address for the given derivation index.
+func (w *hdWallet) address(index uint32) (string, error) {
+	pub, err := w.w.PublicKey(index)
+	if err != nil {
+		return "", fmt.Errorf("can't derive public key: %w", err)
+	}
+	return addressFromPubKey(crypto.FromECDSAPub(pub)), nil
+}
+
+// addressFromPubKey returns base58 TRON address for the uncompressed public key.
+func addressFromPubKey(uncompressedPubKey []byte) string {
+	pubKeyHash := crypto.Keccak256(uncompressedPubKey[1:]) // Remove the 0x04 prefix
+	address := append([]byte{addressPrefix}, pubKeyHash[len(pubKeyHash)-20:]...)
+	return base58.Encode(append(address, doubleSHA256(address)[:4]...))
+}
+
+func doubleSHA256(b []byte) []byte {
+	first := sha256.Sum256(b)
+	second := sha256.Sum256(first[:])
+	return second[:]
+}
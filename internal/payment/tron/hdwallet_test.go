@@ -0,0 +1,32 @@
+package tron
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHDWalletAddress(t *testing.T) {
+	mnemonic := "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"
+
+	w, err := newHDWallet("", mnemonic, "")
+	assert.NoError(t, err)
+
+	// m/44'/195'/0'/0/0
+	addr, err := w.address(0)
+	assert.NoError(t, err)
+	assert.Equal(t, "TUEZSdKsoDHQMeZwihtdoBiN46zxhGWYdH", addr)
+
+	seen := map[string]bool{}
+	for i := uint32(0); i < 5; i++ {
+		addr, err := w.address(i)
+		assert.NoError(t, err)
+		assert.Len(t, addr, 34)
+		assert.Equal(t, byte('T'), addr[0])
+		assert.False(t, seen[addr])
+		seen[addr] = true
+	}
+
+	_, err = newHDWallet("", "", "")
+	assert.Error(t, err)
+}
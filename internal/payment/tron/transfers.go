@@ -0,0 +1,91 @@
+package tron
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jekabolt/grbpwr-manager/internal/dependency"
+	"github.com/jekabolt/grbpwr-manager/internal/payment/monitor"
+	"github.com/jekabolt/grbpwr-manager/internal/payment/trongrid"
+	"github.com/shopspring/decimal"
+)
+
+// receivedTransfers sums the incoming token transfers to the payee made since the invoice was issued.
+// Transfers of other tokens, to other addresses or made before issuedAt are ignored,
+// transfers with less than the given number of confirmations are counted as pending.
+func receivedTransfers(tg dependency.Trongrid, payee string, contractAddress string, issuedAt time.Time, confirmations uint64) (*monitor.Received, error) {
+	txs, err := tg.GetAddressTransactions(payee, contractAddress, issuedAt)
+	if err != nil {
+		return nil, fmt.Errorf("can't get address transactions: %w", err)
+	}
+
+	if confirmations == 0 {
+		confirmations = 1
+	}
+
+	r := &monitor.Received{
+		Confirmed: decimal.Zero,
+		Pending:   decimal.Zero,
+	}
+
+	var head, lastTimestamp int64
+	for i, tx := range txs.Data {
+		if tx.Type != trongrid.TypeTransfer ||
+			tx.To != payee ||
+			tx.TokenInfo.Address != contractAddress {
+			continue
+		}
+
+		blockTimestamp := time.UnixMilli(tx.BlockTimestamp)
+		if blockTimestamp.Before(issuedAt) {
+			continue
+		}
+
+		value, err := decimal.NewFromString(tx.Value)
+		if err != nil || !value.IsPositive() {
+			slog.Default().Error("can't parse transaction amount",
+				slog.String("tx.TransactionID", tx.TransactionID),
+				slog.String("tx.Value", tx.Value),
+			)
+			continue
+		}
+
+		info, err := tg.GetTransactionInfoById(tx.TransactionID)
+		if err != nil {
+			return nil, fmt.Errorf("can't get transaction info: %w", err)
+		}
+		if info.BlockNumber == 0 {
+			r.Pending = r.Pending.Add(value)
+			continue
+		}
+
+		if head == 0 {
+			block, err := tg.GetNowBlock()
+			if err != nil {
+				return nil, fmt.Errorf("can't get latest block: %w", err)
+			}
+			head = block.BlockHeader.RawData.Number
+		}
+
+		// The block including the transaction is its first confirmation.
+		if head-info.BlockNumber+1 < int64(confirmations) {
+			r.Pending = r.Pending.Add(value)
+			continue
+		}
+
+		r.Confirmed = r.Confirmed.Add(value)
+		if r.Last == nil || tx.BlockTimestamp > lastTimestamp {
+			lastTimestamp = tx.BlockTimestamp
+			r.Last = &monitor.Transfer{
+				TxId:  tx.TransactionID,
+				From:  tx.From,
+				To:    tx.To,
+				Value: value,
+				Data:  &txs.Data[i],
+			}
+		}
+	}
+
+	return r, nil
+}
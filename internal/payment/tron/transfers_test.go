@@ -0,0 +1,98 @@
+package tron
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jekabolt/grbpwr-manager/internal/dto"
+	"github.com/jekabolt/grbpwr-manager/internal/payment/trongrid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testPayee    = "TUEZSdKsoDHQMeZwihtdoBiN46zxhGWYdH"
+	testContract = "TR7NHqjeKQxGTCi8q8ZY4pPg7c8vJsBmUq"
+)
+
+// fakeTrongrid serves the given transactions with their block numbers.
+type fakeTrongrid struct {
+	txs    []dto.TransactionData
+	blocks map[string]int64
+	head   int64
+}
+
+func (f *fakeTrongrid) GetAddressTransactions(address string, contractAddress string, minTimestamp time.Time) (*dto.TronTransactionsResponse, error) {
+	return &dto.TronTransactionsResponse{Data: f.txs, Success: true}, nil
+}
+
+func (f *fakeTrongrid) GetNowBlock() (*dto.TronBlock, error) {
+	return &dto.TronBlock{BlockHeader: dto.TronBlockHeader{RawData: dto.TronBlockRawData{Number: f.head}}}, nil
+}
+
+func (f *fakeTrongrid) GetTransactionInfoById(txId string) (*dto.TronTransactionInfo, error) {
+	return &dto.TronTransactionInfo{ID: txId, BlockNumber: f.blocks[txId]}, nil
+}
+
+func testTransfer(id string, value string, at time.Time) dto.TransactionData {
+	return dto.TransactionData{
+		TransactionID:  id,
+		TokenInfo:      dto.TokenInfo{Address: testContract, Decimals: 6},
+		BlockTimestamp: at.UnixMilli(),
+		From:           "TMknEhd2ASboQ1LCFZfvJTufSLHEL9S5xW",
+		To:             testPayee,
+		Type:           trongrid.TypeTransfer,
+		Value:          value,
+	}
+}
+
+func TestReceivedTransfers(t *testing.T) {
+	issuedAt := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	otherToken := testTransfer("other_token", "1000000", issuedAt.Add(time.Minute))
+	otherToken.TokenInfo.Address = "TXLAQ63Xg1NAzckPwKHvzw7CSEmLMEqcdj"
+	otherPayee := testTransfer("other_payee", "1000000", issuedAt.Add(time.Minute))
+	otherPayee.To = "TULivK5zBnouAaKyt9hxTHJQspPHNpVoKV"
+	approval := testTransfer("approval", "1000000", issuedAt.Add(time.Minute))
+	approval.Type = "Approval"
+
+	tg := &fakeTrongrid{
+		txs: []dto.TransactionData{
+			testTransfer("unconfirmed", "500000", issuedAt.Add(3*time.Minute)),
+			testTransfer("second", "2000000", issuedAt.Add(2*time.Minute)),
+			testTransfer("first", "1000000", issuedAt.Add(time.Minute)),
+			testTransfer("before_invoice", "1000000", issuedAt.Add(-time.Minute)),
+			testTransfer("not_in_block", "700000", issuedAt.Add(4*time.Minute)),
+			otherToken,
+			otherPayee,
+			approval,
+		},
+		blocks: map[string]int64{
+			"first":       100,
+			"second":      105,
+			"unconfirmed": 115,
+			"other_token": 100,
+			"other_payee": 100,
+			"approval":    100,
+		},
+		head: 120,
+	}
+
+	r, err := receivedTransfers(tg, testPayee, testContract, issuedAt, 10)
+	assert.NoError(t, err)
+	assert.True(t, r.Confirmed.Equal(decimal.NewFromInt(3000000)), r.Confirmed.String())
+	assert.True(t, r.Pending.Equal(decimal.NewFromInt(1200000)), r.Pending.String())
+	assert.NotNil(t, r.Last)
+	assert.Equal(t, "second", r.Last.TxId)
+
+	// the block including the transfer is the first confirmation
+	r, err = receivedTransfers(tg, testPayee, testContract, issuedAt, 6)
+	assert.NoError(t, err)
+	assert.True(t, r.Confirmed.Equal(decimal.NewFromInt(3500000)), r.Confirmed.String())
+	assert.Equal(t, "unconfirmed", r.Last.TxId)
+
+	r, err = receivedTransfers(tg, testPayee, testContract, issuedAt, 100)
+	assert.NoError(t, err)
+	assert.True(t, r.Confirmed.IsZero())
+	assert.Nil(t, r.Last)
+}
@@ -12,6 +12,7 @@ import (
 const (
 	addressFrom = "TMknEhd2ASboQ1LCFZfvJTufSLHEL9S5xW"
 	addressTo   = "TULivK5zBnouAaKyt9hxTHJQspPHNpVoKV"
+	usdtShasta  = "TG3XXyExBkPp9nzdajDZsozEu4BkaSJozs"
 )
 
 func TestGetTransactionsShasta(t *testing.T) {
@@ -21,7 +22,7 @@ func TestGetTransactionsShasta(t *testing.T) {
 		Timeout: time.Minute,
 	})
 
-	res, err := tg.GetAddressTransactions(addressTo)
+	res, err := tg.GetAddressTransactions(addressTo, usdtShasta, time.Now().Add(-time.Hour*24*30))
 	assert.NoError(t, err)
 
 	bs, err := json.Marshal(res)
@@ -1,10 +1,13 @@
 package trongrid
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strconv"
 	"time"
 
 	"github.com/jekabolt/grbpwr-manager/internal/dependency"
@@ -18,6 +21,9 @@ const (
 	NileTestnet   = "https://nile.trongrid.io"
 
 	TypeTransfer = "Transfer"
+
+	// pageLimit is the max page size of the trc20 transactions endpoint
+	pageLimit = 200
 )
 
 // APIKeyHeader represents the header name for the API key.
@@ -43,36 +49,94 @@ func New(c *Config) dependency.Trongrid {
 	}
 }
 
-// GetAddressTransactions retrieves TRC-20 token transactions for a given address.
-func (c *Client) GetAddressTransactions(address string) (*dto.TronTransactionsResponse, error) {
-	url := fmt.Sprintf("%s/v1/accounts/%s/transactions/trc20?limit=1", c.c.BaseURL, address)
+// GetAddressTransactions retrieves all incoming TRC-20 token transactions of the contract for a given address
+// made since minTimestamp, following the pagination.
+func (c *Client) GetAddressTransactions(address string, contractAddress string, minTimestamp time.Time) (*dto.TronTransactionsResponse, error) {
+	q := url.Values{}
+	q.Set("only_to", "true")
+	q.Set("limit", strconv.Itoa(pageLimit))
+	q.Set("contract_address", contractAddress)
+	q.Set("min_timestamp", strconv.FormatInt(minTimestamp.UnixMilli(), 10))
+
+	res := &dto.TronTransactionsResponse{}
+	for {
+		var page dto.TronTransactionsResponse
+		err := c.do(http.MethodGet, fmt.Sprintf("%s/v1/accounts/%s/transactions/trc20?%s", c.c.BaseURL, address, q.Encode()), nil, &page)
+		if err != nil {
+			return nil, err
+		}
+
+		res.Data = append(res.Data, page.Data...)
+		res.Success = page.Success
+		res.Meta = page.Meta
+
+		if page.Meta.Fingerprint == "" || len(page.Data) == 0 {
+			return res, nil
+		}
+		q.Set("fingerprint", page.Meta.Fingerprint)
+	}
+}
 
-	req, err := http.NewRequest("GET", url, nil)
+// GetNowBlock retrieves the latest block.
+func (c *Client) GetNowBlock() (*dto.TronBlock, error) {
+	var block dto.TronBlock
+	err := c.do(http.MethodPost, fmt.Sprintf("%s/wallet/getnowblock", c.c.BaseURL), nil, &block)
 	if err != nil {
-		return nil, fmt.Errorf("creating request: %w", err)
+		return nil, err
+	}
+	return &block, nil
+}
+
+// GetTransactionInfoById retrieves the info of the transaction including the block it was included in.
+// Block number is zero if the transaction is not in a block yet.
+func (c *Client) GetTransactionInfoById(txId string) (*dto.TronTransactionInfo, error) {
+	body, err := json.Marshal(map[string]string{"value": txId})
+	if err != nil {
+		return nil, fmt.Errorf("marshaling request: %w", err)
+	}
+
+	var info dto.TronTransactionInfo
+	err = c.do(http.MethodPost, fmt.Sprintf("%s/wallet/gettransactioninfobyid", c.c.BaseURL), body, &info)
+	if err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+func (c *Client) do(method string, reqURL string, reqBody []byte, target any) error {
+	var body io.Reader
+	if reqBody != nil {
+		body = bytes.NewReader(reqBody)
+	}
+
+	req, err := http.NewRequest(method, reqURL, body)
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
 	}
 
 	req.Header.Add(APIKeyHeader, c.c.APIKey)
+	if reqBody != nil {
+		req.Header.Add("Content-Type", "application/json")
+	}
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("sending request: %w", err)
+		return fmt.Errorf("sending request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("non-200 status code: %d", resp.StatusCode)
+		return fmt.Errorf("non-200 status code: %d", resp.StatusCode)
 	}
 
-	body, err := io.ReadAll(resp.Body)
+	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("reading response body: %w", err)
+		return fmt.Errorf("reading response body: %w", err)
 	}
 
-	var transactionsResponse dto.TronTransactionsResponse
-	if err := json.Unmarshal(body, &transactionsResponse); err != nil {
-		return nil, fmt.Errorf("unmarshaling response: %w", err)
+	if err := json.Unmarshal(respBody, target); err != nil {
+		return fmt.Errorf("unmarshaling response: %w", err)
 	}
 
-	return &transactionsResponse, nil
+	return nil
 }
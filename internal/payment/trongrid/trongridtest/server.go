@@ -0,0 +1,262 @@
+// Package trongridtest provides a local TronGrid server for the tests,
+// it serves the TRC-20 transfers added by the test instead of the blockchain ones.
+package trongridtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jekabolt/grbpwr-manager/internal/dto"
+	"github.com/jekabolt/grbpwr-manager/internal/payment/trongrid"
+)
+
+const (
+	defaultDecimals = 6
+	defaultPageSize = 200
+)
+
+// Transfer is the TRC-20 transfer served by the server.
+type Transfer struct {
+	// TxID is generated if empty
+	TxID     string
+	From     string
+	To       string
+	Contract string
+	// Decimals of the token, 6 if zero
+	Decimals int
+	// Value in the blockchain format
+	Value string
+	// Timestamp of the block, now if zero
+	Timestamp time.Time
+	// Block including the transfer, zero means the transfer is not in a block yet
+	Block int64
+	// Type is Transfer if empty
+	Type string
+}
+
+// Server is the local TronGrid server, create it with NewServer and close it with Close.
+type Server struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	transfers []Transfer
+	nowBlock  int64
+	pageSize  int
+	nextId    int
+}
+
+// NewServer starts a server without transfers.
+func NewServer() *Server {
+	s := &Server{
+		pageSize: defaultPageSize,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/accounts/{address}/transactions/trc20", s.handleTransactions)
+	mux.HandleFunc("POST /wallet/getnowblock", s.handleNowBlock)
+	mux.HandleFunc("POST /wallet/gettransactioninfobyid", s.handleTransactionInfo)
+	s.Server = httptest.NewServer(mux)
+
+	return s
+}
+
+// Config returns the trongrid client config pointing to the server.
+func (s *Server) Config() *trongrid.Config {
+	return &trongrid.Config{
+		BaseURL: s.URL,
+		Timeout: 5 * time.Second,
+	}
+}
+
+// AddTransfer adds the transfer to the served ones and returns its transaction id.
+func (s *Server) AddTransfer(t Transfer) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextId++
+	if t.TxID == "" {
+		t.TxID = fmt.Sprintf("%064x", s.nextId)
+	}
+	if t.Decimals == 0 {
+		t.Decimals = defaultDecimals
+	}
+	if t.Timestamp.IsZero() {
+		t.Timestamp = time.Now()
+	}
+	if t.Type == "" {
+		t.Type = trongrid.TypeTransfer
+	}
+	s.transfers = append(s.transfers, t)
+	return t.TxID
+}
+
+// ConfirmTransfer includes the transfer in the block.
+func (s *Server) ConfirmTransfer(txId string, block int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.transfers {
+		if s.transfers[i].TxID == txId {
+			s.transfers[i].Block = block
+		}
+	}
+}
+
+// SetNowBlock sets the number of the latest block.
+func (s *Server) SetNowBlock(n int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nowBlock = n
+}
+
+// SetPageSize sets the max number of the transfers served per page.
+func (s *Server) SetPageSize(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pageSize = n
+}
+
+// handleTransactions serves the transfers to or from the address newest first,
+// the fingerprint of the next page is the offset of its first transfer.
+func (s *Server) handleTransactions(w http.ResponseWriter, r *http.Request) {
+	address := r.PathValue("address")
+	q := r.URL.Query()
+
+	minTimestamp, err := parseInt(q.Get("min_timestamp"))
+	if err != nil {
+		http.Error(w, "invalid min_timestamp", http.StatusBadRequest)
+		return
+	}
+	offset, err := parseInt(q.Get("fingerprint"))
+	if err != nil {
+		http.Error(w, "invalid fingerprint", http.StatusBadRequest)
+		return
+	}
+	limit, err := parseInt(q.Get("limit"))
+	if err != nil {
+		http.Error(w, "invalid limit", http.StatusBadRequest)
+		return
+	}
+	onlyTo := q.Get("only_to") == "true"
+	contract := q.Get("contract_address")
+
+	s.mu.Lock()
+	if limit <= 0 || limit > int64(s.pageSize) {
+		limit = int64(s.pageSize)
+	}
+	txs := []dto.TransactionData{}
+	for _, t := range s.transfers {
+		if t.To != address && (onlyTo || t.From != address) {
+			continue
+		}
+		if contract != "" && t.Contract != contract {
+			continue
+		}
+		if t.Timestamp.UnixMilli() < minTimestamp {
+			continue
+		}
+		txs = append(txs, transactionData(t))
+	}
+	s.mu.Unlock()
+
+	sort.SliceStable(txs, func(i, j int) bool { return txs[i].BlockTimestamp > txs[j].BlockTimestamp })
+
+	res := dto.TronTransactionsResponse{
+		Data:    []dto.TransactionData{},
+		Success: true,
+		Meta: dto.MetaData{
+			At:       time.Now().UnixMilli(),
+			PageSize: int(limit),
+		},
+	}
+	if offset < int64(len(txs)) {
+		end := min(offset+limit, int64(len(txs)))
+		res.Data = txs[offset:end]
+		if end < int64(len(txs)) {
+			res.Meta.Fingerprint = strconv.FormatInt(end, 10)
+		}
+	}
+	res.Meta.PageSize = len(res.Data)
+
+	writeJSON(w, res)
+}
+
+func (s *Server) handleNowBlock(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	n := s.nowBlock
+	s.mu.Unlock()
+
+	writeJSON(w, dto.TronBlock{
+		BlockID: fmt.Sprintf("%064x", n),
+		BlockHeader: dto.TronBlockHeader{
+			RawData: dto.TronBlockRawData{
+				Number:    n,
+				Timestamp: time.Now().UnixMilli(),
+			},
+		},
+	})
+}
+
+// handleTransactionInfo serves the block of the transfer, an unknown transaction is served empty like TronGrid does.
+func (s *Server) handleTransactionInfo(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Value string `json:"value"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid body", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, t := range s.transfers {
+		if t.TxID != req.Value || t.Block == 0 {
+			continue
+		}
+		writeJSON(w, dto.TronTransactionInfo{
+			ID:             t.TxID,
+			BlockNumber:    t.Block,
+			BlockTimeStamp: t.Timestamp.UnixMilli(),
+		})
+		return
+	}
+	writeJSON(w, dto.TronTransactionInfo{})
+}
+
+func transactionData(t Transfer) dto.TransactionData {
+	return dto.TransactionData{
+		TransactionID: t.TxID,
+		TokenInfo: dto.TokenInfo{
+			Symbol:   "USDT",
+			Address:  t.Contract,
+			Decimals: t.Decimals,
+			Name:     "Tether USD",
+		},
+		BlockTimestamp: t.Timestamp.UnixMilli(),
+		From:           t.From,
+		To:             t.To,
+		Type:           t.Type,
+		Value:          t.Value,
+	}
+}
+
+func parseInt(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,64 @@
+package trongridtest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jekabolt/grbpwr-manager/internal/payment/trongrid"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	addressFrom = "TMknEhd2ASboQ1LCFZfvJTufSLHEL9S5xW"
+	addressTo   = "TULivK5zBnouAaKyt9hxTHJQspPHNpVoKV"
+	usdt        = "TR7NHqjeKQxGTCi8q8ZY4pPg7c8vJsBmUq"
+	otherToken  = "TXLAQ63Xg1NAzckPwKHvzw7CSEmLMEqcdj"
+)
+
+func TestServer(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.SetPageSize(2)
+	s.SetNowBlock(100)
+
+	since := time.Now().Add(-time.Hour)
+
+	first := s.AddTransfer(Transfer{From: addressFrom, To: addressTo, Contract: usdt, Value: "1000000", Timestamp: since.Add(time.Minute), Block: 90})
+	second := s.AddTransfer(Transfer{From: addressFrom, To: addressTo, Contract: usdt, Value: "2000000", Timestamp: since.Add(2 * time.Minute)})
+	third := s.AddTransfer(Transfer{From: addressFrom, To: addressTo, Contract: usdt, Value: "3000000", Timestamp: since.Add(3 * time.Minute), Block: 95})
+	s.AddTransfer(Transfer{From: addressFrom, To: addressTo, Contract: usdt, Value: "1000000", Timestamp: since.Add(-time.Minute)})
+	s.AddTransfer(Transfer{From: addressFrom, To: addressTo, Contract: otherToken, Value: "1000000", Timestamp: since.Add(time.Minute)})
+	s.AddTransfer(Transfer{From: addressTo, To: addressFrom, Contract: usdt, Value: "1000000", Timestamp: since.Add(time.Minute)})
+
+	tg := trongrid.New(s.Config())
+
+	// three transfers are served in two pages
+	res, err := tg.GetAddressTransactions(addressTo, usdt, since)
+	assert.NoError(t, err)
+	assert.True(t, res.Success)
+	if assert.Len(t, res.Data, 3) {
+		assert.Equal(t, third, res.Data[0].TransactionID)
+		assert.Equal(t, second, res.Data[1].TransactionID)
+		assert.Equal(t, first, res.Data[2].TransactionID)
+		assert.Equal(t, "1000000", res.Data[2].Value)
+		assert.Equal(t, 6, res.Data[2].TokenInfo.Decimals)
+		assert.Equal(t, trongrid.TypeTransfer, res.Data[2].Type)
+	}
+
+	block, err := tg.GetNowBlock()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(100), block.BlockHeader.RawData.Number)
+
+	info, err := tg.GetTransactionInfoById(first)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(90), info.BlockNumber)
+
+	info, err = tg.GetTransactionInfoById(second)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), info.BlockNumber)
+
+	s.ConfirmTransfer(second, 99)
+	info, err = tg.GetTransactionInfoById(second)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(99), info.BlockNumber)
+}
@@ -2,7 +2,7 @@ package store
 
 import (
 	"context"
-	"database/sql"
+	"fmt"
 	"testing"
 
 	"github.com/jekabolt/grbpwr-manager/internal/entity"
@@ -14,80 +14,69 @@ func TestArchiveCRUD(t *testing.T) {
 	as := db.Archive()
 	ctx := context.Background()
 
-	an := &entity.ArchiveNew{
-		Archive: &entity.ArchiveBody{
-			Title:       "test",
-			Description: "test",
-		},
-		Items: []entity.ArchiveItem{
-			{
-				Media: "1",
-				URL: sql.NullString{
-					String: "test url 1",
-					Valid:  true,
-				},
-				Title: sql.NullString{
-					String: "test title 1",
-					Valid:  true,
-				},
-			},
-			{
-				Media: "2",
-				URL: sql.NullString{
-					String: "test url 2",
-					Valid:  true,
-				},
-				Title: sql.NullString{
-					String: "test title 2",
-					Valid:  true,
-				},
-			},
-		},
+	mediaIds := make([]int, 0, 3)
+	for i := 1; i <= 3; i++ {
+		mid, err := db.Media().AddMedia(ctx, &entity.MediaItem{
+			FullSizeMediaURL:   fmt.Sprintf("https://example.com/fullsize%d.jpg", i),
+			ThumbnailMediaURL:  fmt.Sprintf("https://example.com/thumb%d.jpg", i),
+			CompressedMediaURL: fmt.Sprintf("https://example.com/compressed%d.jpg", i),
+		})
+		if !assert.NoError(t, err) {
+			return
+		}
+		mediaIds = append(mediaIds, mid)
 	}
+
+	an := &entity.ArchiveInsert{
+		Title:       "test",
+		Description: "test",
+		Tag:         "test",
+		MediaIds:    mediaIds[:2],
+	}
+
+	// the archive without media is rejected
+	_, err := as.AddArchive(ctx, &entity.ArchiveInsert{Title: "test"})
+	assert.Error(t, err)
+
 	aid, err := as.AddArchive(ctx, an)
 	assert.NoError(t, err)
 
-	err = as.AddArchiveItems(ctx, aid, []entity.ArchiveItem{
-		{
-			Media: "3",
-			URL: sql.NullString{
-				String: "test url 3",
-				Valid:  true,
-			},
-			Title: sql.NullString{
-				String: "test title 3",
-				Valid:  true,
-			},
-		},
-	})
+	an.MediaIds = mediaIds
+	err = as.UpdateArchive(ctx, aid, an)
 	assert.NoError(t, err)
 
 	archive, err := as.GetArchiveById(ctx, aid)
 	assert.NoError(t, err)
-	assert.Equal(t, "test", archive.Archive.Title)
-	assert.Len(t, archive.Items, 3)
+	if !assert.NotNil(t, archive) {
+		return
+	}
+	assert.Equal(t, "test", archive.Title)
+	assert.Len(t, archive.Media, 3)
 
-	err = as.DeleteArchiveItem(ctx, archive.Items[0].ID)
+	an.MediaIds = mediaIds[1:]
+	err = as.UpdateArchive(ctx, aid, an)
 	assert.NoError(t, err)
 
 	archive, err = as.GetArchiveById(ctx, aid)
 	assert.NoError(t, err)
-	assert.Len(t, archive.Items, 2)
+	assert.Len(t, archive.Media, 2)
 
-	an.Archive.Title = "test2"
+	an.Title = "test2"
 	aidNew, err := as.AddArchive(ctx, an)
 	assert.NoError(t, err)
 
-	archives, err := as.GetArchivesPaged(ctx, 10, 0, entity.Ascending)
+	archives, count, err := as.GetArchivesPaged(ctx, 10, 0, entity.Ascending)
 	assert.NoError(t, err)
 	assert.Len(t, archives, 2)
+	assert.Equal(t, 2, count)
 
 	err = as.DeleteArchiveById(ctx, aidNew)
 	assert.NoError(t, err)
 
-	archives, err = as.GetArchivesPaged(ctx, 10, 0, entity.Ascending)
+	archives, count, err = as.GetArchivesPaged(ctx, 10, 0, entity.Ascending)
 	assert.NoError(t, err)
 	assert.Len(t, archives, 1)
+	assert.Equal(t, 1, count)
 
 	err = as.DeleteArchiveById(ctx, aid)
 	assert.NoError(t, err)
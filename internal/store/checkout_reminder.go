@@ -0,0 +1,129 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jekabolt/grbpwr-manager/internal/cache"
+	"github.com/jekabolt/grbpwr-manager/internal/entity"
+)
+
+// invoiceExpiredReason is the reason the order is cancelled with once its invoice expires.
+const invoiceExpiredReason = "invoice expired"
+
+// GetCheckoutReminderCandidates returns the orders awaiting payment whose invoice expires within expiresWithin and
+// the orders cancelled within expiredWithin because their invoice expired. The orders which were already reminded
+// and the orders of the buyers who opted out of the emails are not returned.
+func (ms *MYSQLStore) GetCheckoutReminderCandidates(ctx context.Context, expiresWithin, expiredWithin time.Duration, limit int) ([]entity.CheckoutReminderCandidate, error) {
+	query := `
+	SELECT co.id AS order_id, co.uuid AS order_uuid, co.total_price, b.email, b.first_name, b.last_name, FALSE AS expired
+	FROM customer_order co
+	JOIN payment_expiration pe ON pe.order_id = co.id
+	JOIN buyer b ON b.order_id = co.id
+	WHERE co.order_status_id = :awaitingPaymentId
+		AND pe.expire_at <= CURRENT_TIMESTAMP + INTERVAL :expiresWithin SECOND
+		AND NOT EXISTS (SELECT 1 FROM checkout_reminder cr WHERE cr.order_id = co.id)
+		AND NOT EXISTS (SELECT 1 FROM subscriber s WHERE s.email = b.email AND s.receive_promo_emails = FALSE)
+	UNION ALL
+	SELECT co.id AS order_id, co.uuid AS order_uuid, co.total_price, b.email, b.first_name, b.last_name, TRUE AS expired
+	FROM customer_order co
+	JOIN order_status_history h ON h.order_id = co.id
+	JOIN buyer b ON b.order_id = co.id
+	WHERE co.order_status_id = :cancelledId
+		AND h.from_status_id = :awaitingPaymentId
+		AND h.to_status_id = :cancelledId
+		AND h.reason = :reason
+		AND h.created_at >= CURRENT_TIMESTAMP - INTERVAL :expiredWithin SECOND
+		AND NOT EXISTS (SELECT 1 FROM checkout_reminder cr WHERE cr.order_id = co.id)
+		AND NOT EXISTS (SELECT 1 FROM subscriber s WHERE s.email = b.email AND s.receive_promo_emails = FALSE)
+	ORDER BY order_id
+	LIMIT :limit`
+
+	cs, err := QueryListNamed[entity.CheckoutReminderCandidate](ctx, ms.DB(), query, map[string]any{
+		"awaitingPaymentId": cache.OrderStatusAwaitingPayment.Status.Id,
+		"cancelledId":       cache.OrderStatusCancelled.Status.Id,
+		"reason":            invoiceExpiredReason,
+		"expiresWithin":     int(expiresWithin.Seconds()),
+		"expiredWithin":     int(expiredWithin.Seconds()),
+		"limit":             limit,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't get checkout reminder candidates: %w", err)
+	}
+	return cs, nil
+}
+
+// AddCheckoutReminder records the reminder sent for the order.
+// It returns false if the order has already been reminded before.
+func (ms *MYSQLStore) AddCheckoutReminder(ctx context.Context, orderId int, expired bool) (bool, error) {
+	// concurrent checks race on the unique order id, only one of them inserts the row
+	query := `INSERT IGNORE INTO checkout_reminder (order_id, expired) VALUES (:orderId, :expired)`
+	res, err := ms.DB().NamedExecContext(ctx, query, map[string]any{
+		"orderId": orderId,
+		"expired": expired,
+	})
+	if err != nil {
+		return false, fmt.Errorf("can't insert checkout reminder: %w", err)
+	}
+
+	ra, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("can't get rows affected: %w", err)
+	}
+	return ra > 0, nil
+}
+
+// DeleteCheckoutReminder removes the reminder of the order whose email failed to send, so it's sent again.
+func (ms *MYSQLStore) DeleteCheckoutReminder(ctx context.Context, orderId int) error {
+	query := `DELETE FROM checkout_reminder WHERE order_id = :orderId`
+	err := ExecNamed(ctx, ms.DB(), query, map[string]any{
+		"orderId": orderId,
+	})
+	if err != nil {
+		return fmt.Errorf("can't delete checkout reminder: %w", err)
+	}
+	return nil
+}
+
+// GetCheckoutReminderReport returns the conversion of the reminders sent within [from, to).
+// The reminder is recovered by the earliest order of the buyer confirmed after the reminder,
+// which is either the reminded order or the one placed after the reminder.
+func (ms *MYSQLStore) GetCheckoutReminderReport(ctx context.Context, from, to time.Time) (*entity.CheckoutReminderReport, error) {
+	query := `
+	SELECT
+		COUNT(*) AS sent,
+		COALESCE(SUM(r.expired), 0) AS sent_expired,
+		COUNT(r.recovered_total) AS recovered,
+		COALESCE(SUM(r.expired AND r.recovered_total IS NOT NULL), 0) AS recovered_expired,
+		COALESCE(SUM(r.recovered_total), 0) AS recovered_revenue
+	FROM (
+		SELECT cr.expired, (
+			SELECT ro.total_price
+			FROM customer_order ro
+			JOIN buyer rb ON rb.order_id = ro.id
+			WHERE (ro.id = cr.order_id OR (rb.email = b.email AND ro.placed >= cr.sent_at))
+				AND EXISTS (
+					SELECT 1 FROM order_status_history h
+					WHERE h.order_id = ro.id AND h.to_status_id = :confirmedId AND h.created_at >= cr.sent_at
+				)
+			ORDER BY ro.id
+			LIMIT 1
+		) AS recovered_total
+		FROM checkout_reminder cr
+		JOIN buyer b ON b.order_id = cr.order_id
+		WHERE cr.sent_at >= :from AND cr.sent_at < :to
+	) r`
+
+	report, err := QueryNamedOne[entity.CheckoutReminderReport](ctx, ms.DB(), query, map[string]any{
+		"confirmedId": cache.OrderStatusConfirmed.Status.Id,
+		"from":        from,
+		"to":          to,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't get checkout reminder report: %w", err)
+	}
+	report.From = from
+	report.To = to
+	return &report, nil
+}
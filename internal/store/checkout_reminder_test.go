@@ -0,0 +1,90 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jekabolt/grbpwr-manager/internal/cache"
+	"github.com/jekabolt/grbpwr-manager/internal/entity"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckoutReminder(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	insertUnpaidOrder := func(email string) *entity.Order {
+		order := insertTestOrder(ctx, t, db, decimal.NewFromInt(100), cache.OrderStatusAwaitingPayment)
+		insertTestBuyer(ctx, t, db, order.Id, email)
+
+		pm, ok := cache.GetPaymentMethodByName(entity.CARD)
+		assert.True(t, ok)
+		assert.NoError(t, db.AddPaymentExpiration(ctx, order.UUID, pm.Method.Id, time.Now().Add(5*time.Minute)))
+		return order
+	}
+
+	reminded := insertUnpaidOrder("reminded@example.com")
+	optedOut := insertUnpaidOrder("opted-out@example.com")
+	assert.NoError(t, db.UpsertSubscription(ctx, "opted-out@example.com", false))
+
+	candidateIds := func() []int {
+		cs, err := db.GetCheckoutReminderCandidates(ctx, 10*time.Minute, time.Hour, 100)
+		assert.NoError(t, err)
+		ids := []int{}
+		for _, c := range cs {
+			ids = append(ids, c.OrderId)
+		}
+		return ids
+	}
+
+	// the invoice expiring later than the lead time is not reminded yet
+	cs, err := db.GetCheckoutReminderCandidates(ctx, time.Minute, time.Hour, 100)
+	assert.NoError(t, err)
+	assert.Empty(t, cs)
+
+	assert.Contains(t, candidateIds(), reminded.Id)
+	assert.NotContains(t, candidateIds(), optedOut.Id)
+
+	added, err := db.AddCheckoutReminder(ctx, reminded.Id, false)
+	assert.NoError(t, err)
+	assert.True(t, added)
+	added, err = db.AddCheckoutReminder(ctx, reminded.Id, false)
+	assert.NoError(t, err)
+	assert.False(t, added)
+	assert.NotContains(t, candidateIds(), reminded.Id)
+
+	// the reminder which failed to send is removed and reminded again
+	assert.NoError(t, db.DeleteCheckoutReminder(ctx, reminded.Id))
+	assert.Contains(t, candidateIds(), reminded.Id)
+	added, err = db.AddCheckoutReminder(ctx, reminded.Id, false)
+	assert.NoError(t, err)
+	assert.True(t, added)
+
+	// the expired invoice is reminded once the order is cancelled
+	expired := insertUnpaidOrder("expired@example.com")
+	assert.NoError(t, cancelOrder(ctx, db, expired, nil, entity.OrderStatusActorSystem, invoiceExpiredReason))
+	cs, err = db.GetCheckoutReminderCandidates(ctx, 10*time.Minute, time.Hour, 100)
+	assert.NoError(t, err)
+	for _, c := range cs {
+		if c.OrderId == expired.Id {
+			assert.True(t, c.Expired)
+		}
+	}
+	assert.Contains(t, candidateIds(), expired.Id)
+
+	// the reminded order paid after the reminder is recovered
+	from := time.Now().Add(-time.Hour)
+	report, err := db.GetCheckoutReminderReport(ctx, from, time.Now().Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Sent)
+	assert.Equal(t, 0, report.Recovered)
+
+	assert.NoError(t, updateOrderStatus(ctx, db, reminded, entity.Confirmed, entity.OrderStatusActorSystem, "payment received"))
+	report, err = db.GetCheckoutReminderReport(ctx, from, time.Now().Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Recovered)
+	assert.True(t, report.RecoveredRevenue.Equal(decimal.NewFromInt(100)))
+	assert.True(t, report.ConversionRate().Equal(decimal.NewFromInt(100)))
+}
@@ -5,10 +5,12 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"math/rand/v2"
 	"strings"
 	"time"
 
 	"github.com/Knetic/go-namedParameterQuery"
+	"github.com/go-sql-driver/mysql"
 	"github.com/jekabolt/grbpwr-manager/internal/dependency"
 	"github.com/jmoiron/sqlx"
 	"github.com/jmoiron/sqlx/reflectx"
@@ -38,12 +40,13 @@ func (ms *MYSQLStore) DB() dependency.DB {
 
 // Tx starts transaction and executes the function passing to it Handler
 // using this transaction. It automatically rolls the transaction back if
-// function returns an error. If the error has been caused by serialization
-// error, it calls the function again. In order for serialization errors
-// handling to work, the function should return Handler errors
-// unchanged, or wrap them using %w.
+// function returns an error. If the transaction has been rolled back on
+// deadlock, it calls the function again up to txAttempts times with a
+// jittered backoff until ctx is done. In order for deadlock handling to
+// work, the function should return Handler errors unchanged, or wrap them
+// using %w.
 func (ms *MYSQLStore) Tx(ctx context.Context, f func(context.Context, dependency.Repository) error) error {
-	for {
+	for attempt := 1; ; attempt++ {
 		pst, err := ms.TxBegin(ctx)
 		if err != nil {
 			return err
@@ -55,13 +58,27 @@ func (ms *MYSQLStore) Tx(ctx context.Context, f func(context.Context, dependency
 			}
 		}
 		_ = pst.TxRollback(ctx)
-		if ms.IsErrorRepeat(err) {
-			continue
+		if !ms.IsErrorRepeat(err) || attempt >= txAttempts {
+			return err
+		}
+
+		t := time.NewTimer(txRetryDelay(attempt))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
 		}
-		return err
 	}
 }
 
+// txRetryDelay returns the delay before the given attempt is retried, the delay doubles
+// with every attempt and is jittered so the deadlocked transactions don't collide again.
+func txRetryDelay(attempt int) time.Duration {
+	d := txRetryBaseDelay << (attempt - 1)
+	return d/2 + rand.N(d)
+}
+
 // InTx returns true if the object is in transaction
 func (ms *MYSQLStore) InTx() bool {
 	return ms.txDB != nil
@@ -76,9 +93,10 @@ func (ms *MYSQLStore) TxBegin(ctx context.Context) (dependency.Repository, error
 	}
 
 	return &MYSQLStore{
-		db:   ltx{Tx: tx},
-		txDB: tx,
-		ts:   ms.Now(),
+		db:             ltx{Tx: tx},
+		txDB:           tx,
+		ts:             ms.Now(),
+		reservationTTL: ms.reservationTTL,
 	}, nil
 }
 
@@ -114,12 +132,21 @@ func (ms *MYSQLStore) TxRollback(ctx context.Context) error {
 	return err
 }
 
+const (
+	// errDeadlock is the MySQL error of the transaction rolled back on deadlock
+	errDeadlock = 1213
+	// txAttempts is the number of times the deadlocked transaction is run
+	txAttempts = 5
+	// txRetryBaseDelay is the delay before the first retry of the deadlocked transaction
+	txRetryBaseDelay = 10 * time.Millisecond
+)
+
+// IsErrorRepeat reports whether the transaction was rolled back on deadlock and can be retried.
+// The lock wait timeout isn't retried, the lock is held by a transaction which isn't about to finish.
 func (ms *MYSQLStore) IsErrorRepeat(err error) bool {
-	var e *pq.Error
+	var e *mysql.MySQLError
 	if errors.As(err, &e) {
-		if e.Code == "40001" {
-			return true
-		}
+		return e.Number == errDeadlock
 	}
 	return false
 }
@@ -0,0 +1,31 @@
+package store
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsErrorRepeat(t *testing.T) {
+	ms := &MYSQLStore{}
+
+	assert.True(t, ms.IsErrorRepeat(&mysql.MySQLError{Number: errDeadlock, Message: "Deadlock found when trying to get lock"}))
+	assert.True(t, ms.IsErrorRepeat(fmt.Errorf("can't reduce stock: %w", &mysql.MySQLError{Number: errDeadlock, Message: "Deadlock found when trying to get lock"})))
+	assert.False(t, ms.IsErrorRepeat(&mysql.MySQLError{Number: 1205, Message: "Lock wait timeout exceeded"}))
+	assert.False(t, ms.IsErrorRepeat(&mysql.MySQLError{Number: 1062, Message: "Duplicate entry"}))
+	assert.False(t, ms.IsErrorRepeat(fmt.Errorf("order items are not valid")))
+	assert.False(t, ms.IsErrorRepeat(nil))
+}
+
+func TestTxRetryDelay(t *testing.T) {
+	for attempt := 1; attempt < txAttempts; attempt++ {
+		d := txRetryBaseDelay << (attempt - 1)
+		for i := 0; i < 100; i++ {
+			delay := txRetryDelay(attempt)
+			assert.GreaterOrEqual(t, delay, d/2)
+			assert.Less(t, delay, d+d/2)
+		}
+	}
+}
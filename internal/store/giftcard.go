@@ -0,0 +1,251 @@
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"fmt"
+	"strings"
+
+	"github.com/jekabolt/grbpwr-manager/internal/dependency"
+	"github.com/jekabolt/grbpwr-manager/internal/entity"
+	"github.com/shopspring/decimal"
+)
+
+type giftCardStore struct {
+	*MYSQLStore
+}
+
+// GiftCards returns an object implementing GiftCards interface
+func (ms *MYSQLStore) GiftCards() dependency.GiftCards {
+	return &giftCardStore{
+		MYSQLStore: ms,
+	}
+}
+
+// giftCardCodeLength is the length of the generated gift card code
+const giftCardCodeLength = 16
+
+func generateGiftCardCode() (string, error) {
+	b := make([]byte, giftCardCodeLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("can't read random bytes: %w", err)
+	}
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b)[:giftCardCodeLength], nil
+}
+
+// AddGiftCard issues the gift card with the initial balance and records it in the ledger.
+func (gs *giftCardStore) AddGiftCard(ctx context.Context, gci *entity.GiftCardInsert) (*entity.GiftCard, error) {
+	gci.Code = strings.TrimSpace(gci.Code)
+	gci.InitialBalance = gci.InitialBalance.Round(2)
+	if !gci.InitialBalance.IsPositive() {
+		return nil, fmt.Errorf("gift card balance must be positive: balance %s", gci.InitialBalance.String())
+	}
+	if gci.Code == "" {
+		code, err := generateGiftCardCode()
+		if err != nil {
+			return nil, fmt.Errorf("can't generate gift card code: %w", err)
+		}
+		gci.Code = code
+	}
+
+	var gc *entity.GiftCard
+	err := gs.Tx(ctx, func(ctx context.Context, rep dependency.Repository) error {
+		id, err := ExecNamedLastId(ctx, rep.DB(), `
+		INSERT INTO gift_card (code, initial_balance, balance, expiration, allowed)
+		VALUES (:code, :initialBalance, :initialBalance, :expiration, :allowed)`, map[string]any{
+			"code":           gci.Code,
+			"initialBalance": gci.InitialBalance,
+			"expiration":     gci.Expiration,
+			"allowed":        gci.Allowed,
+		})
+		if err != nil {
+			return fmt.Errorf("can't insert gift card: %w", err)
+		}
+
+		err = insertGiftCardTransaction(ctx, rep, &entity.GiftCardTransaction{
+			GiftCardId:   id,
+			Type:         entity.GiftCardIssued,
+			Amount:       gci.InitialBalance,
+			BalanceAfter: gci.InitialBalance,
+		})
+		if err != nil {
+			return fmt.Errorf("can't insert gift card transaction: %w", err)
+		}
+
+		gc, err = getGiftCard(ctx, rep, "id = :id", map[string]any{"id": id})
+		if err != nil {
+			return fmt.Errorf("can't get gift card: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return gc, nil
+}
+
+func (gs *giftCardStore) ListGiftCards(ctx context.Context, limit, offset int, orderFactor entity.OrderFactor) ([]entity.GiftCard, error) {
+	query := fmt.Sprintf(`
+	SELECT * FROM gift_card
+	ORDER BY id %s
+	LIMIT :limit OFFSET :offset`, orderFactor.String())
+
+	gcs, err := QueryListNamed[entity.GiftCard](ctx, gs.DB(), query, map[string]any{
+		"limit":  limit,
+		"offset": offset,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't get gift card list: %w", err)
+	}
+	return gcs, nil
+}
+
+// GetGiftCardByCode returns the gift card with its ledger, sql.ErrNoRows if there is no such card.
+func (gs *giftCardStore) GetGiftCardByCode(ctx context.Context, code string) (*entity.GiftCardFull, error) {
+	gc, err := getGiftCard(ctx, gs, "code = :code", map[string]any{"code": strings.TrimSpace(code)})
+	if err != nil {
+		return nil, fmt.Errorf("can't get gift card: %w", err)
+	}
+
+	txs, err := QueryListNamed[entity.GiftCardTransaction](ctx, gs.DB(), `
+	SELECT * FROM gift_card_transaction
+	WHERE gift_card_id = :giftCardId
+	ORDER BY id`, map[string]any{
+		"giftCardId": gc.Id,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't get gift card transactions: %w", err)
+	}
+
+	return &entity.GiftCardFull{
+		GiftCard:     *gc,
+		Transactions: txs,
+	}, nil
+}
+
+// DisableGiftCard makes the card unusable for the new orders, the balance already redeemed stays on the orders.
+func (gs *giftCardStore) DisableGiftCard(ctx context.Context, code string) error {
+	err := ExecNamed(ctx, gs.DB(), `UPDATE gift_card SET allowed = false WHERE code = :code`, map[string]any{
+		"code": strings.TrimSpace(code),
+	})
+	if err != nil {
+		return fmt.Errorf("can't disable gift card: %w", err)
+	}
+	return nil
+}
+
+func getGiftCard(ctx context.Context, rep dependency.Repository, where string, params map[string]any) (*entity.GiftCard, error) {
+	gc, err := QueryNamedOne[entity.GiftCard](ctx, rep.DB(), fmt.Sprintf(`SELECT * FROM gift_card WHERE %s`, where), params)
+	if err != nil {
+		return nil, err
+	}
+	return &gc, nil
+}
+
+// lockGiftCard selects the gift card for update so concurrent orders can't spend the same balance.
+func lockGiftCard(ctx context.Context, rep dependency.Repository, where string, params map[string]any) (*entity.GiftCard, error) {
+	return getGiftCard(ctx, rep, where+" FOR UPDATE", params)
+}
+
+func insertGiftCardTransaction(ctx context.Context, rep dependency.Repository, gct *entity.GiftCardTransaction) error {
+	return ExecNamed(ctx, rep.DB(), `
+	INSERT INTO gift_card_transaction (gift_card_id, order_id, type, amount, balance_after)
+	VALUES (:giftCardId, :orderId, :type, :amount, :balanceAfter)`, map[string]any{
+		"giftCardId":   gct.GiftCardId,
+		"orderId":      gct.OrderId,
+		"type":         gct.Type,
+		"amount":       gct.Amount,
+		"balanceAfter": gct.BalanceAfter,
+	})
+}
+
+// updateGiftCardBalance changes the card balance by the signed amount and records the change in the ledger.
+func updateGiftCardBalance(ctx context.Context, rep dependency.Repository, gc *entity.GiftCard, orderId int, t entity.GiftCardTransactionType, amount decimal.Decimal) error {
+	balance := gc.Balance.Add(amount).Round(2)
+	if balance.IsNegative() {
+		return fmt.Errorf("insufficient gift card balance: balance %s amount %s", gc.Balance.String(), amount.String())
+	}
+
+	err := ExecNamed(ctx, rep.DB(), `UPDATE gift_card SET balance = :balance WHERE id = :id`, map[string]any{
+		"id":      gc.Id,
+		"balance": balance,
+	})
+	if err != nil {
+		return fmt.Errorf("can't update gift card balance: %w", err)
+	}
+
+	err = insertGiftCardTransaction(ctx, rep, &entity.GiftCardTransaction{
+		GiftCardId:   gc.Id,
+		OrderId:      sql.NullInt32{Int32: int32(orderId), Valid: orderId != 0},
+		Type:         t,
+		Amount:       amount,
+		BalanceAfter: balance,
+	})
+	if err != nil {
+		return fmt.Errorf("can't insert gift card transaction: %w", err)
+	}
+
+	gc.Balance = balance
+	return nil
+}
+
+func updateOrderGiftCard(ctx context.Context, rep dependency.Repository, order *entity.Order, giftCardId sql.NullInt32, amount decimal.Decimal) error {
+	err := ExecNamed(ctx, rep.DB(), `
+	UPDATE customer_order
+	SET gift_card_id = :giftCardId,
+		gift_card_amount = :giftCardAmount
+	WHERE id = :orderId`, map[string]any{
+		"orderId":        order.Id,
+		"giftCardId":     giftCardId,
+		"giftCardAmount": amount,
+	})
+	if err != nil {
+		return fmt.Errorf("can't update order gift card: %w", err)
+	}
+	order.GiftCardId = giftCardId
+	order.GiftCardAmount = amount
+	return nil
+}
+
+// redeemGiftCard spends the gift card balance on the order total, the card covers
+// the whole total if its balance is enough and the part of it otherwise.
+func redeemGiftCard(ctx context.Context, rep dependency.Repository, order *entity.Order, code string) error {
+	gc, err := lockGiftCard(ctx, rep, "code = :code", map[string]any{"code": strings.TrimSpace(code)})
+	if err != nil {
+		return fmt.Errorf("can't get gift card: %w", err)
+	}
+	if !gc.IsRedeemable(rep.Now()) {
+		return fmt.Errorf("gift card is disabled, expired or has no balance: code %s", gc.Code)
+	}
+
+	amount := decimal.Min(gc.Balance, order.TotalPriceDecimal()).Round(2)
+	if !amount.IsPositive() {
+		return nil
+	}
+
+	if err := updateGiftCardBalance(ctx, rep, gc, order.Id, entity.GiftCardRedeemed, amount.Neg()); err != nil {
+		return err
+	}
+	return updateOrderGiftCard(ctx, rep, order, sql.NullInt32{Int32: int32(gc.Id), Valid: true}, amount)
+}
+
+// returnGiftCardBalance returns the given part of the balance redeemed on the order to the gift card,
+// the balance is returned even if the card is disabled or expired meanwhile.
+func returnGiftCardBalance(ctx context.Context, rep dependency.Repository, order *entity.Order, amount decimal.Decimal) error {
+	amount = decimal.Min(amount, order.GiftCardAmount).Round(2)
+	if !order.GiftCardId.Valid || !amount.IsPositive() {
+		return nil
+	}
+
+	gc, err := lockGiftCard(ctx, rep, "id = :id", map[string]any{"id": order.GiftCardId.Int32})
+	if err != nil {
+		return fmt.Errorf("can't get gift card: %w", err)
+	}
+
+	if err := updateGiftCardBalance(ctx, rep, gc, order.Id, entity.GiftCardReturned, amount); err != nil {
+		return err
+	}
+	return updateOrderGiftCard(ctx, rep, order, order.GiftCardId, order.GiftCardAmount.Sub(amount))
+}
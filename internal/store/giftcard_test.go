@@ -0,0 +1,96 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/jekabolt/grbpwr-manager/internal/cache"
+	"github.com/jekabolt/grbpwr-manager/internal/dependency"
+	"github.com/jekabolt/grbpwr-manager/internal/entity"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGiftCard(t *testing.T) {
+	db := newTestDB(t)
+	gs := db.GiftCards()
+	ctx := context.Background()
+
+	gc, err := gs.AddGiftCard(ctx, &entity.GiftCardInsert{
+		InitialBalance: decimal.NewFromInt(100),
+		Allowed:        true,
+	})
+	assert.NoError(t, err)
+	assert.Len(t, gc.Code, giftCardCodeLength)
+	assert.True(t, gc.Balance.Equal(decimal.NewFromInt(100)))
+
+	_, err = gs.AddGiftCard(ctx, &entity.GiftCardInsert{
+		Code:           "expired",
+		InitialBalance: decimal.NewFromInt(100),
+		Expiration:     sql.NullTime{Time: time.Now().Add(-time.Hour), Valid: true},
+		Allowed:        true,
+	})
+	assert.NoError(t, err)
+
+	_, err = gs.AddGiftCard(ctx, &entity.GiftCardInsert{
+		Code:           "zero",
+		InitialBalance: decimal.Zero,
+		Allowed:        true,
+	})
+	assert.Error(t, err)
+
+	gcs, err := gs.ListGiftCards(ctx, 10, 0, entity.Ascending)
+	assert.NoError(t, err)
+	assert.Len(t, gcs, 2)
+
+	order := insertTestOrder(ctx, t, db, decimal.NewFromInt(150), cache.OrderStatusPlaced)
+
+	err = db.Tx(ctx, func(ctx context.Context, rep dependency.Repository) error {
+		return redeemGiftCard(ctx, rep, order, "expired")
+	})
+	assert.Error(t, err)
+
+	t.Run("RedeemPart", func(t *testing.T) {
+		err = db.Tx(ctx, func(ctx context.Context, rep dependency.Repository) error {
+			return redeemGiftCard(ctx, rep, order, gc.Code)
+		})
+		assert.NoError(t, err)
+		assert.True(t, order.GiftCardAmount.Equal(decimal.NewFromInt(100)))
+		assert.True(t, order.AmountDue().Equal(decimal.NewFromInt(50)))
+
+		gcf, err := gs.GetGiftCardByCode(ctx, gc.Code)
+		assert.NoError(t, err)
+		assert.True(t, gcf.GiftCard.Balance.IsZero())
+		assert.Len(t, gcf.Transactions, 2)
+		assert.Equal(t, entity.GiftCardRedeemed, gcf.Transactions[1].Type)
+	})
+
+	t.Run("ReturnOnCancel", func(t *testing.T) {
+		err = db.Tx(ctx, func(ctx context.Context, rep dependency.Repository) error {
+			return cancelOrder(ctx, rep, order, nil, entity.OrderStatusActorSystem, "")
+		})
+		assert.NoError(t, err)
+
+		gcf, err := gs.GetGiftCardByCode(ctx, gc.Code)
+		assert.NoError(t, err)
+		assert.True(t, gcf.GiftCard.Balance.Equal(decimal.NewFromInt(100)))
+		assert.Len(t, gcf.Transactions, 3)
+		assert.Equal(t, entity.GiftCardReturned, gcf.Transactions[2].Type)
+
+		o, err := getOrderById(ctx, db, order.Id)
+		assert.NoError(t, err)
+		assert.True(t, o.GiftCardAmount.IsZero())
+	})
+
+	t.Run("DisableGiftCard", func(t *testing.T) {
+		err := gs.DisableGiftCard(ctx, gc.Code)
+		assert.NoError(t, err)
+
+		gcf, err := gs.GetGiftCardByCode(ctx, gc.Code)
+		assert.NoError(t, err)
+		assert.False(t, gcf.GiftCard.IsRedeemable(time.Now()))
+		assert.True(t, gcf.GiftCard.AvailableBalance(time.Now()).IsZero())
+	})
+}
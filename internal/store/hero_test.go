@@ -15,66 +15,88 @@ func TestSetHero(t *testing.T) {
 	hs := db.Hero()
 	ctx := context.Background()
 
-	main := entity.HeroInsert{
-		ContentLink: "example.com/content-left",
-		ContentType: "text/html",
-		ExploreLink: "example.com/explore-left",
-		ExploreText: "Explore more on left",
+	mediaId, err := getRandomMedia(ctx, db, 1)
+	if !assert.NoError(t, err) {
+		return
 	}
 
-	ads := []entity.HeroInsert{
-		{
-			ContentLink: "example.com/content-1",
-			ContentType: "text/html",
-			ExploreLink: "example.com/explore-1",
-			ExploreText: "Explore more on 1",
-		},
-		{
-			ContentLink: "example.com/content-2",
-			ContentType: "text/html",
-			ExploreLink: "example.com/explore-2",
-			ExploreText: "Explore more on 2",
+	main := entity.HeroEntityInsert{
+		Type: entity.HeroTypeMain,
+		Main: entity.HeroMainInsert{
+			Single: entity.HeroSingleInsert{
+				MediaId:     mediaId,
+				ExploreLink: "example.com/explore-main",
+				ExploreText: "Explore more on main",
+			},
+			Tag:         "main",
+			Description: "main description",
 		},
 	}
 
 	np, err := randomProductInsert(db, 1)
+	if !assert.NoError(t, err) {
+		return
+	}
 	np.Product.Name = "first product"
-	assert.NoError(t, err)
 
 	// Insert new product
-	newPrd, err := ps.AddProduct(ctx, np)
+	prdId, err := ps.AddProduct(ctx, np)
 	assert.NoError(t, err)
 
-	err = hs.SetHero(ctx, main, ads, []int{newPrd.Product.ID})
+	featured := func(ids []int) entity.HeroEntityInsert {
+		return entity.HeroEntityInsert{
+			Type: entity.HeroTypeFeaturedProducts,
+			FeaturedProducts: entity.HeroFeaturedProductsInsert{
+				ProductIDs:  ids,
+				Headline:    "featured",
+				ExploreLink: "example.com/explore-featured",
+				ExploreText: "Explore more featured",
+			},
+		}
+	}
+
+	err = hs.SetHero(ctx, []entity.HeroEntityInsert{main, featured([]int{prdId})})
 	assert.NoError(t, err)
 
-	hero, err := db.GetHero(ctx)
+	hero, err := hs.GetHero(ctx)
 	assert.NoError(t, err)
+	if !assert.Len(t, hero.Entities, 2) {
+		return
+	}
 
-	assert.Equal(t, hero.Main.ContentLink, main.ContentLink)
-	assert.Equal(t, hero.Main.ContentType, main.ContentType)
-	assert.Equal(t, hero.Main.ExploreLink, main.ExploreLink)
-	assert.Equal(t, hero.Main.ExploreText, main.ExploreText)
+	assert.Equal(t, entity.HeroTypeMain, hero.Entities[0].Type)
+	if assert.NotNil(t, hero.Entities[0].Main) {
+		assert.Equal(t, main.Main.Single.MediaId, hero.Entities[0].Main.Single.Media.Id)
+		assert.Equal(t, main.Main.Single.ExploreLink, hero.Entities[0].Main.Single.ExploreLink)
+		assert.Equal(t, main.Main.Single.ExploreText, hero.Entities[0].Main.Single.ExploreText)
+		assert.Equal(t, main.Main.Tag, hero.Entities[0].Main.Tag)
+	}
 
-	assert.Len(t, hero.ProductsFeatured, 1)
+	if assert.NotNil(t, hero.Entities[1].FeaturedProducts) {
+		assert.Len(t, hero.Entities[1].FeaturedProducts.Products, 1)
+	}
 
-	prdIds := []int{newPrd.Product.ID}
+	prdIds := []int{prdId}
 	for i := 0; i < 10; i++ {
 		np, err := randomProductInsert(db, i)
-		assert.NoError(t, err)
+		if !assert.NoError(t, err) {
+			return
+		}
 
 		// Insert new product
-		newPrd, err := ps.AddProduct(ctx, np)
+		prdId, err := ps.AddProduct(ctx, np)
 		assert.NoError(t, err)
 
-		prdIds = append(prdIds, newPrd.Product.ID)
+		prdIds = append(prdIds, prdId)
 	}
 
-	err = hs.SetHero(ctx, main, ads, prdIds)
+	err = hs.SetHero(ctx, []entity.HeroEntityInsert{main, featured(prdIds)})
 	assert.NoError(t, err)
 
-	hero, err = db.GetHero(ctx)
+	hero, err = hs.GetHero(ctx)
 	assert.NoError(t, err)
-	assert.Len(t, hero.ProductsFeatured, 11)
+	if assert.Len(t, hero.Entities, 2) && assert.NotNil(t, hero.Entities[1].FeaturedProducts) {
+		assert.Len(t, hero.Entities[1].FeaturedProducts.Products, 11)
+	}
 
 }
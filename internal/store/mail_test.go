@@ -17,7 +17,7 @@ func TestMail(t *testing.T) {
 	ctx := context.Background()
 
 	// add unsent mail
-	err := ms.AddMail(ctx, &entity.SendEmailRequest{
+	_, err := ms.AddMail(ctx, &entity.SendEmailRequest{
 		From:    "from",
 		To:      "to",
 		Html:    "html",
@@ -29,7 +29,7 @@ func TestMail(t *testing.T) {
 	assert.NoError(t, err)
 
 	// add unsent mail
-	err = ms.AddMail(ctx, &entity.SendEmailRequest{
+	_, err = ms.AddMail(ctx, &entity.SendEmailRequest{
 		From:    "from",
 		To:      "to",
 		Html:    "html",
@@ -41,7 +41,7 @@ func TestMail(t *testing.T) {
 	assert.NoError(t, err)
 
 	// add sent mail
-	err = ms.AddMail(ctx, &entity.SendEmailRequest{
+	_, err = ms.AddMail(ctx, &entity.SendEmailRequest{
 		From:    "from",
 		To:      "to",
 		Html:    "html",
@@ -14,21 +14,21 @@ func TestMediaCRUD(t *testing.T) {
 	ctx := context.Background()
 
 	_, err := ms.AddMedia(ctx, &entity.MediaItem{
-		FullSize:   "https://example.com/fullsize.jpg",
-		Thumbnail:  "https://example.com/thumb.jpg",
-		Compressed: "https://example.com/compressed.jpg",
+		FullSizeMediaURL:   "https://example.com/fullsize.jpg",
+		ThumbnailMediaURL:  "https://example.com/thumb.jpg",
+		CompressedMediaURL: "https://example.com/compressed.jpg",
 	})
 	assert.NoError(t, err)
 
 	mediaPage, err := ms.ListMediaPaged(ctx, 10, 0, entity.Ascending)
 	assert.NoError(t, err)
 	assert.Len(t, mediaPage, 1)
-	assert.Equal(t, "https://example.com/fullsize.jpg", mediaPage[0].FullSize)
+	assert.Equal(t, "https://example.com/fullsize.jpg", mediaPage[0].FullSizeMediaURL)
 
 	_, err = ms.AddMedia(ctx, &entity.MediaItem{
-		FullSize:   "https://example2.com/fullsize.jpg",
-		Thumbnail:  "https://example2.com/thumb.jpg",
-		Compressed: "https://example2.com/compressed.jpg",
+		FullSizeMediaURL:   "https://example2.com/fullsize.jpg",
+		ThumbnailMediaURL:  "https://example2.com/thumb.jpg",
+		CompressedMediaURL: "https://example2.com/compressed.jpg",
 	})
 	assert.NoError(t, err)
 
@@ -46,6 +46,6 @@ func TestMediaCRUD(t *testing.T) {
 	mediaPage, err = ms.ListMediaPaged(ctx, 10, 0, entity.Ascending)
 	assert.NoError(t, err)
 	assert.Len(t, mediaPage, 1)
-	assert.Equal(t, "https://example.com/fullsize.jpg", mediaPage[0].FullSize)
+	assert.Equal(t, "https://example.com/fullsize.jpg", mediaPage[0].FullSizeMediaURL)
 
 }
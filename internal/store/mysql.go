@@ -24,6 +24,8 @@ type Config struct {
 	Automigrate        bool   `mapstructure:"automigrate"`
 	MaxOpenConnections int    `mapstructure:"max_open_connections"`
 	MaxIdleConnections int    `mapstructure:"max_idle_connections"`
+	// StockReservationTTL is how long the stock is held for the placed order, 15 minutes if not set
+	StockReservationTTL time.Duration `mapstructure:"stock_reservation_ttl"`
 }
 
 // MYSQLStore implements methods to access MYSQL database
@@ -33,6 +35,8 @@ type MYSQLStore struct {
 	txDB  txDB
 	ts    time.Time
 	close context.CancelFunc
+	// reservationTTL is how long the stock is held for the placed order
+	reservationTTL time.Duration
 }
 
 // New connects to the database, applies migrations and returns a new MYSQLStore object
@@ -51,8 +55,9 @@ func New(ctx context.Context, cfg Config) (*MYSQLStore, error) {
 
 	ctx, c := context.WithCancel(ctx)
 	ss := &MYSQLStore{
-		db:    d,
-		close: c,
+		db:             d,
+		close:          c,
+		reservationTTL: cfg.StockReservationTTL,
 	}
 
 	di, err := ss.GetDictionaryInfo(ctx)
@@ -5,6 +5,9 @@ import (
 	"fmt"
 	"testing"
 
+	"github.com/jekabolt/grbpwr-manager/internal/cache"
+	"github.com/jekabolt/grbpwr-manager/internal/entity"
+	"github.com/shopspring/decimal"
 	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
 )
@@ -58,6 +61,12 @@ func newTestDB(t *testing.T) *MYSQLStore {
 	_, err = db.db.ExecContext(context.Background(), "DELETE FROM promo_code")
 	assert.NoError(t, err)
 
+	_, err = db.db.ExecContext(context.Background(), "DELETE FROM gift_card_transaction")
+	assert.NoError(t, err)
+
+	_, err = db.db.ExecContext(context.Background(), "DELETE FROM gift_card")
+	assert.NoError(t, err)
+
 	_, err = db.db.ExecContext(context.Background(), "DELETE FROM size_measurement")
 	assert.NoError(t, err)
 
@@ -91,9 +100,6 @@ func newTestDB(t *testing.T) *MYSQLStore {
 	_, err = db.db.ExecContext(context.Background(), "DELETE FROM send_email_request")
 	assert.NoError(t, err)
 
-	_, err = db.db.ExecContext(context.Background(), "DELETE FROM hero_product")
-	assert.NoError(t, err)
-
 	_, err = db.db.ExecContext(context.Background(), "DELETE FROM admins")
 	assert.NoError(t, err)
 
@@ -109,8 +115,50 @@ func newTestDB(t *testing.T) *MYSQLStore {
 	_, err = db.db.ExecContext(context.Background(), "DELETE FROM currency_rate")
 	assert.NoError(t, err)
 
+	_, err = db.db.ExecContext(context.Background(), "DELETE FROM tax_category_rate")
+	assert.NoError(t, err)
+
+	_, err = db.db.ExecContext(context.Background(), "DELETE FROM tax_rule")
+	assert.NoError(t, err)
+
 	_, err = db.db.ExecContext(context.Background(), "SET FOREIGN_KEY_CHECKS = 1")
 	assert.NoError(t, err)
 
 	return db
 }
+
+// insertTestOrder inserts the order with the total in the status, it's returned with its id and uuid.
+func insertTestOrder(ctx context.Context, t *testing.T, db *MYSQLStore, total decimal.Decimal, st cache.Status) *entity.Order {
+	order := &entity.Order{
+		TotalPrice:    total,
+		OrderStatusId: st.Status.Id,
+	}
+	var err error
+	order.Id, order.UUID, err = insertOrder(ctx, db, order)
+	assert.NoError(t, err)
+	return order
+}
+
+// testAddress returns the address in Riga the test orders are shipped and billed to.
+func testAddress() *entity.AddressInsert {
+	return &entity.AddressInsert{
+		Country:        "LV",
+		City:           "Riga",
+		AddressLineOne: "Brivibas 1",
+		PostalCode:     "LV-1010",
+	}
+}
+
+// insertTestBuyer inserts the buyer of the order shipped and billed to the test address.
+func insertTestBuyer(ctx context.Context, t *testing.T, db *MYSQLStore, orderId int, email string) {
+	adr := testAddress()
+	sAdr, bAdr, err := insertAddresses(ctx, db, adr, adr)
+	assert.NoError(t, err)
+	assert.NoError(t, insertBuyer(ctx, db, &entity.BuyerInsert{
+		OrderId:   orderId,
+		FirstName: "John",
+		LastName:  "Doe",
+		Email:     email,
+		Phone:     "37120000000",
+	}, sAdr, bAdr))
+}
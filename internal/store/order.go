@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"sort"
 	"strings"
+	"time"
 
 	"log/slog"
 
@@ -30,7 +31,9 @@ func (ms *MYSQLStore) Order() dependency.Order {
 	}
 }
 
-func validateOrderItemsStockAvailability(ctx context.Context, rep dependency.Repository, items []entity.OrderItemInsert) ([]entity.OrderItem, error) {
+// validateOrderItemsStockAvailability limits the items to the quantity available to sell,
+// the stock reserved by the order with orderId is counted as available for it.
+func validateOrderItemsStockAvailability(ctx context.Context, rep dependency.Repository, items []entity.OrderItemInsert, orderId int) ([]entity.OrderItem, error) {
 	// Check if there are no items provided
 	if len(items) == 0 {
 		return nil, errors.New("zero items to validate")
@@ -52,7 +55,7 @@ func validateOrderItemsStockAvailability(ctx context.Context, rep dependency.Rep
 	}
 
 	// Get product sizes (stock) details by item details
-	prdSizes, err := getProductsSizesByIds(ctx, rep, items)
+	prdSizes, err := getProductsSizesByIds(ctx, rep, items, orderId)
 	if err != nil {
 		return nil, fmt.Errorf("can't get products sizes by ids: %w", err)
 	}
@@ -73,13 +76,13 @@ func validateOrderItemsStockAvailability(ctx context.Context, rep dependency.Rep
 		prdSize, exists := prdSizeMap[sizeKey]
 
 		// Check if the size exists and if the quantity is available
-		if !exists || !prdSize.QuantityDecimal().GreaterThan(decimal.Zero) {
+		if !exists || !prdSize.AvailableDecimal().GreaterThan(decimal.Zero) {
 			continue
 		}
 
 		// Adjust quantity if necessary
-		if item.QuantityDecimal().GreaterThan(prdSize.QuantityDecimal()) {
-			item.Quantity = prdSize.QuantityDecimal()
+		if item.QuantityDecimal().GreaterThan(prdSize.AvailableDecimal()) {
+			item.Quantity = prdSize.AvailableDecimal()
 		}
 
 		// Look up the product in the prdMap
@@ -266,16 +269,17 @@ func insertBuyer(ctx context.Context, rep dependency.Repository, b *entity.Buyer
 	return nil
 }
 
-func insertPaymentRecord(ctx context.Context, rep dependency.Repository, paymentMethodId, orderId int) error {
+func insertPaymentRecord(ctx context.Context, rep dependency.Repository, paymentMethodId, orderId int, currency string) error {
 
 	insertQuery := `
-		INSERT INTO payment (order_id, payment_method_id, transaction_amount, transaction_amount_payment_currency, is_transaction_done)
-		VALUES (:orderId, :paymentMethodId, 0, 0, false);
+		INSERT INTO payment (order_id, payment_method_id, transaction_amount, transaction_amount_payment_currency, is_transaction_done, payment_currency)
+		VALUES (:orderId, :paymentMethodId, 0, 0, false, :paymentCurrency);
 	`
 
 	err := ExecNamed(ctx, rep.DB(), insertQuery, map[string]interface{}{
 		"orderId":         orderId,
 		"paymentMethodId": paymentMethodId,
+		"paymentCurrency": sql.NullString{String: currency, Valid: currency != ""},
 	})
 	if err != nil {
 		return fmt.Errorf("can't insert payment record: %w", err)
@@ -297,6 +301,8 @@ func insertOrderItems(ctx context.Context, rep dependency.Repository, items []en
 			"product_sale_percentage": item.ProductSalePercentageDecimal(),
 			"quantity":                item.QuantityDecimal(),
 			"size_id":                 item.SizeId,
+			"tax_rate":                item.TaxRate.Round(2),
+			"tax_amount":              item.TaxAmount.Round(2),
 		}
 		rows = append(rows, row)
 	}
@@ -335,8 +341,8 @@ func insertOrder(ctx context.Context, rep dependency.Repository, order *entity.O
 	var err error
 	query := `
 	INSERT INTO customer_order
-	 (uuid, total_price, order_status_id, promo_id)
-	 VALUES (:uuid, :totalPrice, :orderStatusId, :promoId)
+	 (uuid, total_price, order_status_id, promo_id, tax_amount, tax_inclusive)
+	 VALUES (:uuid, :totalPrice, :orderStatusId, :promoId, :taxAmount, :taxInclusive)
 	 `
 
 	uuid := uuid.New().String()
@@ -345,10 +351,22 @@ func insertOrder(ctx context.Context, rep dependency.Repository, order *entity.O
 		"totalPrice":    order.TotalPriceDecimal(),
 		"orderStatusId": order.OrderStatusId,
 		"promoId":       order.PromoId,
+		"taxAmount":     order.TaxAmount.Round(2),
+		"taxInclusive":  order.TaxInclusive,
 	})
 	if err != nil {
 		return 0, "", fmt.Errorf("can't insert order: %w", err)
 	}
+
+	err = insertOrderStatusHistory(ctx, rep, &entity.OrderStatusHistory{
+		OrderId:    order.Id,
+		ToStatusId: order.OrderStatusId,
+		Actor:      entity.OrderStatusActorSystem,
+		Reason:     sql.NullString{String: "order placed", Valid: true},
+	})
+	if err != nil {
+		return 0, "", err
+	}
 	return order.Id, uuid, nil
 }
 
@@ -399,7 +417,7 @@ func adjustQuantities(maxOrderItemPerSize int, items []entity.OrderItemInsert) [
 	return items
 }
 
-func (ms *MYSQLStore) validateOrderItemsInsert(ctx context.Context, items []entity.OrderItemInsert) ([]entity.OrderItem, error) {
+func validateOrderItemsInsert(ctx context.Context, rep dependency.Repository, items []entity.OrderItemInsert, orderId int) ([]entity.OrderItem, error) {
 
 	// adjust quantities if it exceeds the maxOrderItemPerSize
 	items = adjustQuantities(cache.GetMaxOrderItems(), items)
@@ -407,7 +425,7 @@ func (ms *MYSQLStore) validateOrderItemsInsert(ctx context.Context, items []enti
 	slog.Default().InfoContext(ctx, "items", slog.Any("items", items))
 
 	// validate items stock availability
-	validItems, err := validateOrderItemsStockAvailability(ctx, ms, items)
+	validItems, err := validateOrderItemsStockAvailability(ctx, rep, items, orderId)
 	if err != nil {
 		return nil, fmt.Errorf("error while validating order items: %w", err)
 	}
@@ -419,6 +437,11 @@ func (ms *MYSQLStore) validateOrderItemsInsert(ctx context.Context, items []enti
 
 // ValidateOrderItemsInsert validates the order items and returns the valid items and the total amount
 func (ms *MYSQLStore) ValidateOrderItemsInsert(ctx context.Context, items []entity.OrderItemInsert) (*entity.OrderItemValidation, error) {
+	return validateOrderItems(ctx, ms, items, 0)
+}
+
+// validateOrderItems validates the items of the placed order, the stock reserved by the order is counted as available for it.
+func validateOrderItems(ctx context.Context, rep dependency.Repository, items []entity.OrderItemInsert, orderId int) (*entity.OrderItemValidation, error) {
 	// Return early if there are no items
 	if len(items) == 0 {
 		return nil, fmt.Errorf("no order items to insert")
@@ -432,7 +455,7 @@ func (ms *MYSQLStore) ValidateOrderItemsInsert(ctx context.Context, items []enti
 	mergedItems := mergeOrderItems(copiedItems)
 
 	// Validate the merged order items
-	validItems, err := ms.validateOrderItemsInsert(ctx, mergedItems)
+	validItems, err := validateOrderItemsInsert(ctx, rep, mergedItems, orderId)
 	if err != nil {
 		return nil, fmt.Errorf("error while validating order items: %w", err)
 	}
@@ -447,7 +470,7 @@ func (ms *MYSQLStore) ValidateOrderItemsInsert(ctx context.Context, items []enti
 
 	// Convert to providers and calculate total
 	providers := entity.ConvertOrderItemInsertsToProductInfoProviders(validItemsInsert)
-	total, err := calculateTotalAmount(ctx, ms, providers)
+	total, err := calculateTotalAmount(ctx, rep, providers)
 	if err != nil {
 		return nil, fmt.Errorf("error while calculating total amount: %w", err)
 	}
@@ -489,11 +512,11 @@ func (ms *MYSQLStore) ValidateOrderByUUID(ctx context.Context, uuid string) (*en
 	// Begin a transaction for order validation
 	var customErr error
 	err = ms.Tx(ctx, func(ctx context.Context, rep dependency.Repository) error {
-		// Validate the order items
-		oiv, err := rep.Order().ValidateOrderItemsInsert(ctx, items)
+		// Validate the order items, the stock reserved by the order is available for it
+		oiv, err := validateOrderItems(ctx, rep, items, orderFull.Order.Id)
 		if err != nil {
 			// If validation fails, cancel the order
-			if cancelErr := cancelOrder(ctx, rep, &orderFull.Order, entity.ConvertOrderItemToOrderItemInsert(orderFull.OrderItems)); cancelErr != nil {
+			if cancelErr := cancelOrder(ctx, rep, &orderFull.Order, entity.ConvertOrderItemToOrderItemInsert(orderFull.OrderItems), entity.OrderStatusActorSystem, "order items are not valid"); cancelErr != nil {
 				return fmt.Errorf("cannot cancel order while applying promo code: %w", cancelErr)
 			}
 			return fmt.Errorf("error while validating order items: %w", err)
@@ -510,10 +533,15 @@ func (ms *MYSQLStore) ValidateOrderByUUID(ctx context.Context, uuid string) (*en
 			}
 
 			// Update the total amount based on the new items
-			if _, err := updateTotalAmount(ctx, rep, orderFull.Order.Id, oiv.SubtotalDecimal(), orderFull.PromoCode, orderFull.Shipment); err != nil {
+			if _, err := updateTotalAmount(ctx, rep, orderFull.Order.Id, oiv, orderFull.Shipping.Country, orderFull.PromoCode, orderFull.Shipment); err != nil {
 				return fmt.Errorf("error while updating total amount: %w", err)
 			}
 
+			// Hold the stock of the updated items
+			if err := rereserveStock(ctx, rep, orderFull.Order.Id, validItemsInsert, ms.reservationTTL); err != nil {
+				return fmt.Errorf("error while reserving stock: %w", err)
+			}
+
 			// Set a custom error to indicate the items were updated
 			customErr = fmt.Errorf("order items are not valid and were updated")
 			return nil
@@ -577,14 +605,22 @@ func (ms *MYSQLStore) CreateOrder(ctx context.Context, orderNew *entity.OrderNew
 		if err != nil {
 			return fmt.Errorf("error while validating order items: %w", err)
 		}
-		validItemsInsert := entity.ConvertOrderItemToOrderItemInsert(oiv.ValidItems)
 
-		totalPrice := promo.SubtotalWithPromo(oiv.Subtotal, shipmentCarrier.PriceDecimal())
+		tax, err := calculateOrderTax(ctx, rep, orderNew.ShippingAddress.Country, oiv.ValidItems, promo, shipmentCarrier.PriceDecimal())
+		if err != nil {
+			return fmt.Errorf("error while calculating tax: %w", err)
+		}
+		validItemsInsert := orderItemsWithTax(oiv.ValidItems, tax)
+
+		// the exclusive tax is added on top of the prices
+		totalPrice := promo.SubtotalWithPromo(oiv.Subtotal, shipmentCarrier.PriceDecimal()).Add(tax.Surcharge())
 
 		order = &entity.Order{
 			TotalPrice:    totalPrice,
 			PromoId:       prId,
 			OrderStatusId: cache.OrderStatusPlaced.Status.Id,
+			TaxAmount:     tax.Total,
+			TaxInclusive:  tax.Inclusive,
 		}
 
 		// Insert order and related entities
@@ -593,6 +629,11 @@ func (ms *MYSQLStore) CreateOrder(ctx context.Context, orderNew *entity.OrderNew
 			return fmt.Errorf("error while inserting order details: %w", err)
 		}
 
+		// Hold the stock until the invoice is issued
+		if err := reserveStock(ctx, rep, order.Id, validItemsInsert, ms.reservationTTL); err != nil {
+			return fmt.Errorf("error while reserving stock: %w", err)
+		}
+
 		// Handle promotional email subscription
 		if receivePromo {
 			if err := ms.handlePromoSubscription(ctx, orderNew.Buyer.Email, &sendEmail); err != nil {
@@ -601,17 +642,62 @@ func (ms *MYSQLStore) CreateOrder(ctx context.Context, orderNew *entity.OrderNew
 		}
 
 		// Insert payment record
-		err = insertPaymentRecord(ctx, rep, paymentMethod.Method.Id, order.Id)
+		err = insertPaymentRecord(ctx, rep, paymentMethod.Method.Id, order.Id, orderNew.Currency)
 		if err != nil {
 			return fmt.Errorf("error while inserting payment record: %w", err)
 		}
 
+		// Spend the gift card balance, the rest of the total is invoiced
+		if orderNew.GiftCardCode != "" {
+			if err := redeemGiftCard(ctx, rep, order, orderNew.GiftCardCode); err != nil {
+				return fmt.Errorf("error while redeeming gift card: %w", err)
+			}
+			if order.AmountDue().IsZero() {
+				if err := confirmGiftCardOrder(ctx, rep, order, validItemsInsert); err != nil {
+					return fmt.Errorf("error while confirming gift card order: %w", err)
+				}
+			}
+		}
+
 		return nil
 	})
 
 	return order, sendEmail, err
 }
 
+// confirmGiftCardOrder confirms the order fully paid with the gift card, there is nothing left to invoice.
+func confirmGiftCardOrder(ctx context.Context, rep dependency.Repository, order *entity.Order, items []entity.OrderItemInsert) error {
+	if err := rep.Products().ReduceStockForProductSizes(ctx, items); err != nil {
+		return fmt.Errorf("can't reduce stock for product sizes: %w", err)
+	}
+	if err := releaseStockReservations(ctx, rep, order.Id, entity.StockReservationConverted); err != nil {
+		return err
+	}
+
+	err := ExecNamed(ctx, rep.DB(), `UPDATE payment SET is_transaction_done = true WHERE order_id = :orderId`, map[string]any{
+		"orderId": order.Id,
+	})
+	if err != nil {
+		return fmt.Errorf("can't update order payment: %w", err)
+	}
+
+	err = addPaymentEvent(ctx, rep, order.Id, entity.PaymentEventConfirmed, sql.NullString{}, map[string]string{
+		"giftCardAmount": order.GiftCardAmount.String(),
+	})
+	if err != nil {
+		return fmt.Errorf("can't add payment event: %w", err)
+	}
+
+	if err := rep.Promo().DisableVoucher(ctx, order.PromoId); err != nil {
+		return fmt.Errorf("can't disable voucher: %w", err)
+	}
+
+	if err := updateOrderStatus(ctx, rep, order, entity.Confirmed, entity.OrderStatusActorSystem, "paid with the gift card"); err != nil {
+		return fmt.Errorf("can't update order status: %w", err)
+	}
+	return nil
+}
+
 // Helper function for validating order input
 func validateOrderInput(orderNew *entity.OrderNew) error {
 	if len(orderNew.Items) == 0 {
@@ -684,6 +770,8 @@ func getOrdersItems(ctx context.Context, rep dependency.Repository, orderIds []i
 			oi.product_price,
 			oi.product_sale_percentage,
 			oi.product_price * (1 - COALESCE(oi.product_sale_percentage, 0) / 100) AS product_price_with_sale,
+			oi.tax_rate,
+			oi.tax_amount,
 			m.thumbnail,
 			m.blur_hash,
 			p.name AS product_name,
@@ -729,7 +817,9 @@ func getOrderItemsInsert(ctx context.Context, rep dependency.Repository, orderId
 			product_sale_percentage,
 			product_price * (1 - COALESCE(product_sale_percentage, 0) / 100) AS product_price_with_sale,
 			quantity,
-			size_id
+			size_id,
+			tax_rate,
+			tax_amount
 		FROM order_item 
 		WHERE order_id = :orderId
 	`
@@ -751,7 +841,9 @@ func getOrderShipment(ctx context.Context, rep dependency.Repository, orderId in
 	SELECT 
 		s.* 
 	FROM shipment s 
-	WHERE s.order_id = :orderId`
+	WHERE s.order_id = :orderId AND s.return_request_id IS NULL
+	ORDER BY s.id
+	LIMIT 1`
 
 	s, err := QueryNamedOne[entity.Shipment](ctx, rep.DB(), query, map[string]any{
 		"orderId": orderId,
@@ -771,7 +863,8 @@ func shipmentsByOrderIds(ctx context.Context, rep dependency.Repository, orderId
 	SELECT 
 		s.*
 	FROM shipment s 
-	WHERE s.order_id IN (:orderIds)`
+	WHERE s.order_id IN (:orderIds) AND s.return_request_id IS NULL
+	ORDER BY s.id`
 
 	params := map[string]interface{}{
 		"orderIds": orderIds,
@@ -782,9 +875,12 @@ func shipmentsByOrderIds(ctx context.Context, rep dependency.Repository, orderId
 		return nil, fmt.Errorf("can't get shipments by order ids: %w", err)
 	}
 
+	// the checkout shipment comes first, the split fulfillment ones are returned by fulfillmentShipmentsByOrderIds
 	sm := make(map[int]entity.Shipment)
 	for _, s := range shipments {
-		sm[s.OrderId] = s
+		if _, ok := sm[s.OrderId]; !ok {
+			sm[s.OrderId] = s
+		}
 	}
 
 	return sm, nil
@@ -819,18 +915,6 @@ func (ms *MYSQLStore) GetOrderByUUID(ctx context.Context, uuid string) (*entity.
 	return getOrderByUUID(ctx, ms, uuid)
 }
 
-func updateOrderStatus(ctx context.Context, rep dependency.Repository, orderId int, orderStatusId int) error {
-	query := `UPDATE customer_order SET order_status_id = :orderStatusId WHERE id = :orderId`
-	err := ExecNamed(ctx, rep.DB(), query, map[string]any{
-		"orderId":       orderId,
-		"orderStatusId": orderStatusId,
-	})
-	if err != nil {
-		return fmt.Errorf("can't update order status: %w", err)
-	}
-	return nil
-}
-
 func updateOrderPayment(ctx context.Context, rep dependency.Repository, orderId int, payment entity.PaymentInsert) error {
 	query := `
 	UPDATE payment 
@@ -841,7 +925,8 @@ func updateOrderPayment(ctx context.Context, rep dependency.Repository, orderId
 		payment_method_id = :paymentMethodId,
 		payer = :payer,
 		payee = :payee,
-		client_secret = :clientSecret
+		client_secret = :clientSecret,
+		start_block = :startBlock
 	WHERE order_id = :orderId`
 
 	params := map[string]any{
@@ -853,6 +938,7 @@ func updateOrderPayment(ctx context.Context, rep dependency.Repository, orderId
 		"payer":                            payment.Payer,
 		"payee":                            payment.Payee,
 		"clientSecret":                     payment.ClientSecret,
+		"startBlock":                       payment.StartBlock,
 		"orderId":                          orderId,
 	}
 
@@ -883,6 +969,81 @@ func (ms *MYSQLStore) UpdateTotalPaymentCurrency(ctx context.Context, orderUUID
 	return nil
 }
 
+// UpdatePaymentCurrencyRate sets the amount charged in the payment currency and the rate it was converted at.
+func (ms *MYSQLStore) UpdatePaymentCurrencyRate(ctx context.Context, orderUUID string, tapc decimal.Decimal, rate decimal.Decimal) error {
+	query := `
+	UPDATE payment 
+	SET transaction_amount_payment_currency = :tapc,
+		payment_currency_rate = :rate
+	WHERE order_id = (
+		SELECT id FROM customer_order 
+		WHERE uuid = :orderUUID
+	)`
+
+	err := ExecNamed(ctx, ms.DB(), query, map[string]any{
+		"tapc":      tapc,
+		"rate":      rate,
+		"orderUUID": orderUUID,
+	})
+	if err != nil {
+		return fmt.Errorf("can't update payment currency rate: %w", err)
+	}
+	return nil
+}
+
+// UpdatePaymentQuote sets the quoted invoice amount in the payment currency,
+// modified_at is kept so re-quoting doesn't extend the invoice.
+func (ms *MYSQLStore) UpdatePaymentQuote(ctx context.Context, orderUUID string, q *entity.PaymentQuote) error {
+	query := `
+	UPDATE payment 
+	SET transaction_amount_payment_currency = :tapc,
+		payment_currency = :paymentCurrency,
+		payment_currency_rate = :rate,
+		quote_source_currency = :sourceCurrency,
+		quoted_at = :quotedAt,
+		quote_expires_at = :expiresAt,
+		modified_at = modified_at
+	WHERE order_id = (
+		SELECT id FROM customer_order 
+		WHERE uuid = :orderUUID
+	)`
+
+	err := ExecNamed(ctx, ms.DB(), query, map[string]any{
+		"tapc":            q.AmountPaymentCurrency,
+		"paymentCurrency": q.PaymentCurrency,
+		"rate":            q.Rate,
+		"sourceCurrency":  q.SourceCurrency,
+		"quotedAt":        q.QuotedAt,
+		"expiresAt":       q.ExpiresAt,
+		"orderUUID":       orderUUID,
+	})
+	if err != nil {
+		return fmt.Errorf("can't update payment quote: %w", err)
+	}
+	return nil
+}
+
+// UpdatePaymentStartBlock records the block the transfers to the invoice address are looked up from.
+func (ms *MYSQLStore) UpdatePaymentStartBlock(ctx context.Context, orderUUID string, startBlock uint64) error {
+	query := `
+	UPDATE payment 
+	SET start_block = :startBlock,
+		modified_at = modified_at
+	WHERE order_id = (
+		SELECT id FROM customer_order 
+		WHERE uuid = :orderUUID
+	)`
+
+	err := ExecNamed(ctx, ms.DB(), query, map[string]any{
+		"startBlock": startBlock,
+		"orderUUID":  orderUUID,
+	})
+	if err != nil {
+		return fmt.Errorf("can't update payment start block: %w", err)
+	}
+	return nil
+}
+
 func updateOrderItems(ctx context.Context, rep dependency.Repository, validItems []entity.OrderItemInsert, orderId int) error {
 	err := deleteOrderItems(ctx, rep, orderId)
 	if err != nil {
@@ -899,21 +1060,40 @@ func updateOrderItems(ctx context.Context, rep dependency.Repository, validItems
 // It checks if the promo code is allowed and not expired. If it is, the promo code is reset to an empty value.
 // If the promo code does not offer free shipping, the shipment carrier price is added to the subtotal.
 // If the promo code offers a discount, the subtotal is multiplied by (100 - discount) / 100.
+// The tax of the items shipped to the country is recomputed and the exclusive tax is added to the subtotal.
 // Finally, it updates the order's total promo and returns the calculated subtotal.
 // If any error occurs during the process, it returns an error along with a zero subtotal.
-func updateTotalAmount(ctx context.Context, rep dependency.Repository, orderId int, subtotal decimal.Decimal, promo entity.PromoCode, shipment entity.Shipment) (decimal.Decimal, error) {
+func updateTotalAmount(ctx context.Context, rep dependency.Repository, orderId int, oiv *entity.OrderItemValidation, country string, promo entity.PromoCode, shipment entity.Shipment) (decimal.Decimal, error) {
 	// check if promo is allowed and not expired
 	if !promo.IsAllowed() {
 		promo = entity.PromoCode{}
 	}
 
-	subtotal = promo.SubtotalWithPromo(subtotal, shipment.CostDecimal())
+	tax, err := calculateOrderTax(ctx, rep, country, oiv.ValidItems, promo, shipment.CostDecimal())
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("can't calculate tax: %w", err)
+	}
+	if err := updateOrderTax(ctx, rep, orderId, oiv.ValidItems, tax); err != nil {
+		return decimal.Zero, err
+	}
+
+	subtotal := promo.SubtotalWithPromo(oiv.SubtotalDecimal(), shipment.CostDecimal()).Add(tax.Surcharge())
 
-	err := updateOrderTotalPromo(ctx, rep, orderId, promo.Id, subtotal)
+	err = updateOrderTotalPromo(ctx, rep, orderId, promo.Id, subtotal)
 	if err != nil {
 		return decimal.Zero, fmt.Errorf("can't update order total promo: %w", err)
 	}
 
+	// the gift card can't cover more than the new total
+	order, err := getOrderById(ctx, rep, orderId)
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("can't get order by id: %w", err)
+	}
+	err = returnGiftCardBalance(ctx, rep, order, order.GiftCardAmount.Sub(subtotal))
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("can't return gift card balance: %w", err)
+	}
+
 	return subtotal, nil
 }
 
@@ -940,6 +1120,9 @@ func updateOrderTotalPromo(ctx context.Context, rep dependency.Repository, order
 	return nil
 }
 
+// insertOrderInvoice issues the invoice of the order with the given address or client secret,
+// it must be called within a transaction. The order is updated instead if it can't be invoiced
+// as is and entity.ErrOrderNotInvoiced is returned.
 func (ms *MYSQLStore) insertOrderInvoice(ctx context.Context, orderUUID string, addrOrSecret string, pm entity.PaymentMethod) (*entity.OrderFull, error) {
 	// Retrieve payment method from cache and check validity
 	if !pm.Allowed {
@@ -954,59 +1137,61 @@ func (ms *MYSQLStore) insertOrderInvoice(ctx context.Context, orderUUID string,
 
 	// Check if the order's total price is zero
 	if orderFull.Order.TotalPrice.IsZero() {
-		if err := cancelOrder(ctx, ms, &orderFull.Order, entity.ConvertOrderItemToOrderItemInsert(orderFull.OrderItems)); err != nil {
+		if err := cancelOrder(ctx, ms, &orderFull.Order, entity.ConvertOrderItemToOrderItemInsert(orderFull.OrderItems), entity.OrderStatusActorSystem, "total price is zero"); err != nil {
 			return nil, fmt.Errorf("cannot cancel order: %w", err)
 		}
-		return nil, fmt.Errorf("total price is zero")
+		return nil, fmt.Errorf("total price is zero: %w", entity.ErrOrderNotInvoiced)
 	}
 
-	// Retrieve and validate order status from cache
-	orderStatus, ok := cache.GetOrderStatusById(orderFull.Order.OrderStatusId)
-	if !ok {
-		return nil, fmt.Errorf("order status does not exist: order status id %d", orderFull.Order.OrderStatusId)
-	}
-	if orderStatus.Status.Name != entity.Placed && orderStatus.Status.Name != entity.Cancelled {
-		return nil, fmt.Errorf("order status is not placed or cancelled: current status %s", orderStatus.Status.Name)
+	// Only the placed or cancelled order can be invoiced
+	if err := validateOrderStatusTransition(&orderFull.Order, entity.AwaitingPayment); err != nil {
+		return nil, err
 	}
 
 	// Convert order items to insert format and validate them
 	items := entity.ConvertOrderItemToOrderItemInsert(orderFull.OrderItems)
-	var customErr error
-	err = ms.Tx(ctx, func(ctx context.Context, rep dependency.Repository) error {
-		oiv, err := rep.Order().ValidateOrderItemsInsert(ctx, items)
-		if err != nil {
-			slog.Default().ErrorContext(ctx, "cannot validate order items", slog.String("err", err.Error()))
-			if err := cancelOrder(ctx, rep, &orderFull.Order, entity.ConvertOrderItemToOrderItemInsert(orderFull.OrderItems)); err != nil {
-				return fmt.Errorf("cannot cancel order: %w", err)
-			}
-			return fmt.Errorf("error validating order items: %w", err)
+	oiv, err := validateOrderItems(ctx, ms, items, orderFull.Order.Id)
+	if err != nil {
+		slog.Default().ErrorContext(ctx, "cannot validate order items", slog.String("err", err.Error()))
+		if err := cancelOrder(ctx, ms, &orderFull.Order, items, entity.OrderStatusActorSystem, "order items are not valid"); err != nil {
+			return nil, fmt.Errorf("cannot cancel order: %w", err)
 		}
+		return nil, fmt.Errorf("order items are not valid: %w", entity.ErrOrderNotInvoiced)
+	}
 
-		validItemsInsert := entity.ConvertOrderItemToOrderItemInsert(oiv.ValidItems)
-		if !compareItems(items, validItemsInsert, false) {
-			if err := updateOrderItems(ctx, rep, validItemsInsert, orderFull.Order.Id); err != nil {
-				return fmt.Errorf("error updating order items: %w", err)
-			}
-			if _, err := updateTotalAmount(ctx, rep, orderFull.Order.Id, oiv.SubtotalDecimal(), orderFull.PromoCode, orderFull.Shipment); err != nil {
-				return fmt.Errorf("error updating total amount: %w", err)
-			}
-			customErr = fmt.Errorf("order items are not valid and were updated")
-			return nil
+	validItemsInsert := entity.ConvertOrderItemToOrderItemInsert(oiv.ValidItems)
+	if !compareItems(items, validItemsInsert, false) {
+		if err := updateOrderItems(ctx, ms, validItemsInsert, orderFull.Order.Id); err != nil {
+			return nil, fmt.Errorf("error updating order items: %w", err)
 		}
+		if _, err := updateTotalAmount(ctx, ms, orderFull.Order.Id, oiv, orderFull.Shipping.Country, orderFull.PromoCode, orderFull.Shipment); err != nil {
+			return nil, fmt.Errorf("error updating total amount: %w", err)
+		}
+		if err := rereserveStock(ctx, ms, orderFull.Order.Id, validItemsInsert, ms.reservationTTL); err != nil {
+			return nil, fmt.Errorf("error reserving stock: %w", err)
+		}
+		return nil, fmt.Errorf("order items are not valid and were updated: %w", entity.ErrOrderNotInvoiced)
+	}
 
-		// Reduce stock for valid items
-		if err := rep.Products().ReduceStockForProductSizes(ctx, validItemsInsert); err != nil {
-			return fmt.Errorf("error reducing stock for product sizes: %w", err)
+	// The gift card covers the whole total, there is nothing to invoice
+	if orderFull.Order.AmountDue().IsZero() {
+		if err := confirmGiftCardOrder(ctx, ms, &orderFull.Order, validItemsInsert); err != nil {
+			return nil, fmt.Errorf("error confirming gift card order: %w", err)
 		}
+		return nil, fmt.Errorf("order is paid with the gift card: %w", entity.ErrOrderNotInvoiced)
+	}
 
-		// Update order payment details based on the payment method
-		return ms.processPayment(ctx, rep, orderFull, addrOrSecret, pm)
-	})
-	if err != nil {
+	// Reduce stock for valid items, the reservations are taken from the stock on hand
+	if err := ms.Products().ReduceStockForProductSizes(ctx, validItemsInsert); err != nil {
+		return nil, fmt.Errorf("error reducing stock for product sizes: %w", err)
+	}
+	if err := releaseStockReservations(ctx, ms, orderFull.Order.Id, entity.StockReservationConverted); err != nil {
 		return nil, err
 	}
-	if customErr != nil {
-		return nil, customErr
+
+	// Update order payment details based on the payment method
+	if err := ms.processPayment(ctx, ms, orderFull, addrOrSecret, pm); err != nil {
+		return nil, err
 	}
 
 	return orderFull, nil
@@ -1016,12 +1201,13 @@ func (ms *MYSQLStore) insertOrderInvoice(ctx context.Context, orderUUID string,
 func (ms *MYSQLStore) processPayment(ctx context.Context, rep dependency.Repository, orderFull *entity.OrderFull, addrOrSecret string, pm entity.PaymentMethod) error {
 	orderFull.Payment.PaymentMethodID = pm.Id
 	orderFull.Payment.IsTransactionDone = false
-	orderFull.Payment.TransactionAmount = orderFull.Order.TotalPriceDecimal()
-	orderFull.Payment.TransactionAmountPaymentCurrency = orderFull.Order.TotalPriceDecimal()
+	orderFull.Payment.TransactionAmount = orderFull.Order.AmountDue()
+	orderFull.Payment.TransactionAmountPaymentCurrency = orderFull.Order.AmountDue()
 
 	switch pm.Name {
 
-	case entity.USDT_TRON, entity.USDT_TRON_TEST:
+	case entity.USDT_TRON, entity.USDT_TRON_TEST, entity.ETH, entity.ETH_TEST,
+		entity.USDT_ETH, entity.USDT_ETH_TEST, entity.USDC_ETH, entity.USDC_ETH_TEST:
 		orderFull.Payment.Payee = sql.NullString{String: addrOrSecret, Valid: true}
 	case entity.CARD, entity.CARD_TEST:
 		orderFull.Payment.ClientSecret = sql.NullString{String: addrOrSecret, Valid: true}
@@ -1033,14 +1219,37 @@ func (ms *MYSQLStore) processPayment(ctx context.Context, rep dependency.Reposit
 		return fmt.Errorf("cannot update order payment: %w", err)
 	}
 
+	if err := addInvoicePaymentEvents(ctx, rep, orderFull.Order.Id, &orderFull.Payment.PaymentInsert, pm); err != nil {
+		return fmt.Errorf("cannot add payment events: %w", err)
+	}
+
 	// Set order status to "Awaiting Payment"
-	if err := updateOrderStatus(ctx, rep, orderFull.Order.Id, cache.OrderStatusAwaitingPayment.Status.Id); err != nil {
+	if err := updateOrderStatus(ctx, rep, &orderFull.Order, entity.AwaitingPayment, entity.OrderStatusActorSystem, fmt.Sprintf("invoice issued: %s", pm.Name)); err != nil {
 		return fmt.Errorf("cannot update order status: %w", err)
 	}
 
 	return nil
 }
 
+// addInvoicePaymentEvents records the issued invoice with the address or the client secret it's paid with.
+func addInvoicePaymentEvents(ctx context.Context, rep dependency.Repository, orderId int, p *entity.PaymentInsert, pm entity.PaymentMethod) error {
+	err := addPaymentEvent(ctx, rep, orderId, entity.PaymentEventInvoiceIssued, sql.NullString{}, newPaymentPayload(p))
+	if err != nil {
+		return err
+	}
+
+	switch pm.Name {
+	case entity.CARD, entity.CARD_TEST:
+		return addPaymentEvent(ctx, rep, orderId, entity.PaymentEventClientSecretCreated, sql.NullString{}, map[string]string{
+			"paymentIntentId": clientSecretPaymentIntentId(p.ClientSecret.String),
+		})
+	default:
+		return addPaymentEvent(ctx, rep, orderId, entity.PaymentEventAddressAssigned, sql.NullString{}, map[string]string{
+			"address": p.Payee.String,
+		})
+	}
+}
+
 // InsertCryptoInvoice handles crypto-specific invoice insertion
 func (ms *MYSQLStore) InsertCryptoInvoice(ctx context.Context, orderUUID string, payeeAddress string, pm entity.PaymentMethod) (*entity.OrderFull, error) {
 	return ms.insertOrderInvoice(ctx, orderUUID, payeeAddress, pm)
@@ -1059,10 +1268,10 @@ func updateOrderShipment(ctx context.Context, rep dependency.Repository, shipmen
         carrier_id = :carrierId,
         shipping_date = :shippingDate,
         estimated_arrival_date = :estimatedArrivalDate
-    WHERE order_id = :orderId`
+    WHERE id = :id`
 
 	err := ExecNamed(ctx, rep.DB(), query, map[string]any{
-		"orderId":              shipment.OrderId,
+		"id":                   shipment.Id,
 		"carrierId":            shipment.CarrierId,
 		"trackingCode":         shipment.TrackingCode,
 		"shippingDate":         shipment.ShippingDate,
@@ -1074,20 +1283,17 @@ func updateOrderShipment(ctx context.Context, rep dependency.Repository, shipmen
 	return nil
 }
 
-// SetTrackingNumber sets the tracking number for an order, returns the shipment and the order UUID.
-func (ms *MYSQLStore) SetTrackingNumber(ctx context.Context, orderUUID string, trackingCode string) (*entity.OrderBuyerShipment, error) {
+// SetTrackingNumber ships the remaining items of the order with its carrier, the tracking number
+// of the shipped order is set on its latest shipment.
+func (ms *MYSQLStore) SetTrackingNumber(ctx context.Context, orderUUID string, trackingCode string, actor string) (*entity.OrderBuyerShipment, error) {
 	order, err := getOrderByUUID(ctx, ms, orderUUID)
 	if err != nil {
 		return nil, fmt.Errorf("can't get order by id: %w", err)
 	}
 
-	orderStatus, ok := cache.GetOrderStatusById(order.OrderStatusId)
-	if !ok {
-		return nil, fmt.Errorf("order status is not exists: order status id %d", order.OrderStatusId)
-	}
-
-	if !(orderStatus.Status.Name == entity.Confirmed || orderStatus.Status.Name == entity.Shipped) {
-		return nil, fmt.Errorf("bad order status for setting tracking number must be confirmed got: %s", orderStatus.Status.Name)
+	st, err := getOrderStatusName(order)
+	if err != nil {
+		return nil, err
 	}
 
 	shipment, err := getOrderShipment(ctx, ms, order.Id)
@@ -1095,6 +1301,23 @@ func (ms *MYSQLStore) SetTrackingNumber(ctx context.Context, orderUUID string, t
 		return nil, fmt.Errorf("can't get order shipment: %w", err)
 	}
 
+	if st != entity.Shipped {
+		return ms.AddOrderShipment(ctx, orderUUID, &entity.OrderShipmentNew{
+			CarrierId:    shipment.CarrierId,
+			TrackingCode: trackingCode,
+			ShippingDate: time.Now(),
+		}, actor)
+	}
+
+	// the tracking number of the shipped order can be updated
+	shipments, err := fulfillmentShipmentsByOrderIds(ctx, ms, []int{order.Id})
+	if err != nil {
+		return nil, fmt.Errorf("can't get order shipments: %w", err)
+	}
+	if len(shipments[order.Id]) > 0 {
+		latest := shipments[order.Id][len(shipments[order.Id])-1]
+		shipment = &latest.Shipment
+	}
 	shipment.TrackingCode = sql.NullString{
 		String: trackingCode,
 		Valid:  true,
@@ -1105,19 +1328,7 @@ func (ms *MYSQLStore) SetTrackingNumber(ctx context.Context, orderUUID string, t
 		return nil, fmt.Errorf("can't get buyer by id: %w", err)
 	}
 
-	err = ms.Tx(ctx, func(ctx context.Context, rep dependency.Repository) error {
-		err = updateOrderShipment(ctx, rep, shipment)
-		if err != nil {
-			return fmt.Errorf("can't update order shipment: %w", err)
-		}
-
-		err = updateOrderStatus(ctx, rep, order.Id, cache.OrderStatusShipped.Status.Id)
-		if err != nil {
-			return fmt.Errorf("can't update order status: %w", err)
-		}
-
-		return nil
-	})
+	err = updateOrderShipment(ctx, ms, shipment)
 	if err != nil {
 		return nil, fmt.Errorf("can't set tracking number: %w", err)
 	}
@@ -1146,6 +1357,12 @@ func paymentsByOrderIds(ctx context.Context, rep dependency.Repository, orderIds
 		payment.payee, 
 		payment.client_secret,
 		payment.is_transaction_done,
+		payment.payment_currency,
+		payment.payment_currency_rate,
+		payment.quote_source_currency,
+		payment.quoted_at,
+		payment.quote_expires_at,
+		payment.start_block,
 		payment.created_at,
 		payment.modified_at
 	FROM payment
@@ -1428,12 +1645,17 @@ func fetchOrderInfo(ctx context.Context, rep dependency.Repository, orders []ent
 	ids := getOrderIds(orders)
 
 	var (
-		orderItems map[int][]entity.OrderItem
-		payments   map[string]entity.Payment
-		shipments  map[int]entity.Shipment
-		promos     map[int]entity.PromoCode
-		buyers     map[int]entity.Buyer
-		addresses  map[int]addressFull
+		orderItems  map[int][]entity.OrderItem
+		payments    map[string]entity.Payment
+		shipments   map[int]entity.Shipment
+		promos      map[int]entity.PromoCode
+		buyers      map[int]entity.Buyer
+		addresses   map[int]addressFull
+		history     map[int][]entity.OrderStatusHistory
+		notes       map[int][]entity.OrderNote
+		adjustments map[int][]entity.OrderAdjustment
+		fulfillment map[int][]entity.ShipmentFull
+		invoices    map[int]*entity.OrderInvoice
 	)
 
 	// Use errgroup to handle concurrency and errors more elegantly
@@ -1517,6 +1739,71 @@ func fetchOrderInfo(ctx context.Context, rep dependency.Repository, orders []ent
 		return nil
 	})
 
+	// Fetch status history
+	g.Go(func() error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		var err error
+		history, err = statusHistoryByOrderIds(ctx, rep, ids)
+		if err != nil {
+			return fmt.Errorf("can't get order status history: %w", err)
+		}
+		return nil
+	})
+
+	// Fetch notes
+	g.Go(func() error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		var err error
+		notes, err = notesByOrderIds(ctx, rep, ids)
+		if err != nil {
+			return fmt.Errorf("can't get order notes: %w", err)
+		}
+		return nil
+	})
+
+	// Fetch adjustments
+	g.Go(func() error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		var err error
+		adjustments, err = adjustmentsByOrderIds(ctx, rep, ids)
+		if err != nil {
+			return fmt.Errorf("can't get order adjustments: %w", err)
+		}
+		return nil
+	})
+
+	// Fetch fulfillment shipments
+	g.Go(func() error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		var err error
+		fulfillment, err = fulfillmentShipmentsByOrderIds(ctx, rep, ids)
+		if err != nil {
+			return fmt.Errorf("can't get order fulfillment shipments: %w", err)
+		}
+		return nil
+	})
+
+	// Fetch invoices
+	g.Go(func() error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		var err error
+		invoices, err = invoicesByOrderIds(ctx, rep, ids)
+		if err != nil {
+			return fmt.Errorf("can't get order invoices: %w", err)
+		}
+		return nil
+	})
+
 	// Wait for all goroutines to complete
 	if err := g.Wait(); err != nil {
 		return nil, err
@@ -1537,14 +1824,19 @@ func fetchOrderInfo(ctx context.Context, rep dependency.Repository, orders []ent
 		addrs := addresses[order.Id]
 
 		ofs = append(ofs, entity.OrderFull{
-			Order:      order,
-			OrderItems: orderItemsList,
-			Payment:    payment,
-			Shipment:   shipment,
-			Buyer:      buyer,
-			PromoCode:  promos[order.Id],
-			Billing:    addrs.billing,
-			Shipping:   addrs.shipping,
+			Order:         order,
+			OrderItems:    orderItemsList,
+			Payment:       payment,
+			Shipment:      shipment,
+			Buyer:         buyer,
+			PromoCode:     promos[order.Id],
+			Billing:       addrs.billing,
+			Shipping:      addrs.shipping,
+			StatusHistory: history[order.Id],
+			Notes:         notes[order.Id],
+			Adjustments:   adjustments[order.Id],
+			Shipments:     fulfillment[order.Id],
+			Invoice:       invoices[order.Id],
 		})
 	}
 
@@ -1569,44 +1861,283 @@ func (ms *MYSQLStore) GetPaymentByOrderUUID(ctx context.Context, orderUUID strin
 	return &payment, nil
 }
 
-// GetOrderItems retrieves all order items for a given order.
-func (ms *MYSQLStore) GetOrderById(ctx context.Context, orderId int) (*entity.OrderFull, error) {
-	order, err := getOrderById(ctx, ms, orderId)
+// MarkWebhookEventProcessed records the webhook event as processed.
+// It returns false if the event has already been processed before.
+func (ms *MYSQLStore) MarkWebhookEventProcessed(ctx context.Context, eventId string, eventType string) (bool, error) {
+	// concurrent deliveries of the same event race on the unique event id, only one of them inserts the row
+	query := `INSERT IGNORE INTO processed_webhook_event (event_id, event_type) VALUES (:eventId, :eventType)`
+	res, err := ms.DB().NamedExecContext(ctx, query, map[string]any{
+		"eventId":   eventId,
+		"eventType": eventType,
+	})
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, fmt.Errorf("order is not found")
-		}
-		return nil, fmt.Errorf("can't get order by id: %w", err)
+		return false, fmt.Errorf("can't insert processed webhook event: %w", err)
 	}
-	ofs, err := fetchOrderInfo(ctx, ms, []entity.Order{*order})
+
+	ra, err := res.RowsAffected()
 	if err != nil {
-		return nil, fmt.Errorf("can't fetch order info: %w", err)
+		return false, fmt.Errorf("can't get rows affected: %w", err)
 	}
-	return &ofs[0], nil
+
+	return ra > 0, nil
 }
 
-func (ms *MYSQLStore) GetOrderFullByUUID(ctx context.Context, uuid string) (*entity.OrderFull, error) {
-	order, err := getOrderByUUID(ctx, ms, uuid)
+// GetPaymentAddressByOrderUUID returns the deposit address derived for the order.
+func (ms *MYSQLStore) GetPaymentAddressByOrderUUID(ctx context.Context, orderUUID string, paymentMethodId int) (*entity.PaymentAddress, error) {
+	query := `
+	SELECT pa.*
+	FROM payment_address pa
+	JOIN customer_order co ON pa.order_id = co.id
+	WHERE co.uuid = :orderUUID AND pa.payment_method_id = :paymentMethodId`
+
+	pa, err := QueryNamedOne[entity.PaymentAddress](ctx, ms.DB(), query, map[string]any{
+		"orderUUID":       orderUUID,
+		"paymentMethodId": paymentMethodId,
+	})
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("can't get payment address by order uuid: %w", err)
 	}
-	ofs, err := fetchOrderInfo(ctx, ms, []entity.Order{*order})
+	return &pa, nil
+}
+
+// NextDerivationIndex returns the next unused derivation index for the payment method,
+// the rows are locked so concurrent invoices within transactions get different indexes.
+func (ms *MYSQLStore) NextDerivationIndex(ctx context.Context, paymentMethodId int) (int, error) {
+	query := `SELECT COALESCE(MAX(derivation_index) + 1, 0) FROM payment_address WHERE payment_method_id = :paymentMethodId FOR UPDATE`
+	index, err := QueryCountNamed(ctx, ms.DB(), query, map[string]any{
+		"paymentMethodId": paymentMethodId,
+	})
 	if err != nil {
-		return nil, fmt.Errorf("can't fetch order info: %w", err)
+		return 0, fmt.Errorf("can't get next derivation index: %w", err)
 	}
-	if len(ofs) == 0 {
-		return nil, fmt.Errorf("order is not found")
+	return index, nil
+}
+
+// AddPaymentAddress persists the deposit address derived for the order.
+func (ms *MYSQLStore) AddPaymentAddress(ctx context.Context, orderUUID string, pa *entity.PaymentAddressInsert) error {
+	order, err := getOrderByUUID(ctx, ms, orderUUID)
+	if err != nil {
+		return fmt.Errorf("can't get order by uuid: %w", err)
 	}
+	pa.OrderId = order.Id
 
-	return &ofs[0], nil
+	query := `
+	INSERT INTO payment_address (payment_method_id, derivation_index, address, order_id)
+	VALUES (:paymentMethodId, :derivationIndex, :address, :orderId)`
+
+	err = ExecNamed(ctx, ms.DB(), query, map[string]any{
+		"paymentMethodId": pa.PaymentMethodId,
+		"derivationIndex": pa.DerivationIndex,
+		"address":         pa.Address,
+		"orderId":         pa.OrderId,
+	})
+	if err != nil {
+		return fmt.Errorf("can't insert payment address: %w", err)
+	}
+	return nil
 }
 
-func (ms *MYSQLStore) GetOrdersByStatusAndPaymentTypePaged(
-	ctx context.Context,
-	email string,
-	statusId,
-	paymentMethodId,
-	orderId int,
+// AddPaymentExpiration schedules the expiration of the order invoice,
+// the order has at most one scheduled expiration and adding another one reschedules it.
+func (ms *MYSQLStore) AddPaymentExpiration(ctx context.Context, orderUUID string, paymentMethodId int, expireAt time.Time) error {
+	order, err := getOrderByUUID(ctx, ms, orderUUID)
+	if err != nil {
+		return fmt.Errorf("can't get order by uuid: %w", err)
+	}
+
+	query := `
+	INSERT INTO payment_expiration (order_id, payment_method_id, expire_at)
+	VALUES (:orderId, :paymentMethodId, :expireAt)
+	ON DUPLICATE KEY UPDATE payment_method_id = VALUES(payment_method_id), expire_at = VALUES(expire_at)`
+
+	err = ExecNamed(ctx, ms.DB(), query, map[string]any{
+		"orderId":         order.Id,
+		"paymentMethodId": paymentMethodId,
+		"expireAt":        expireAt,
+	})
+	if err != nil {
+		return fmt.Errorf("can't insert payment expiration: %w", err)
+	}
+	return nil
+}
+
+// AddMissingPaymentExpiration schedules the expiration of the order invoice unless one is already scheduled,
+// the scheduled deadline is kept.
+func (ms *MYSQLStore) AddMissingPaymentExpiration(ctx context.Context, orderUUID string, paymentMethodId int, expireAt time.Time) error {
+	query := `
+	INSERT IGNORE INTO payment_expiration (order_id, payment_method_id, expire_at)
+	SELECT id, :paymentMethodId, :expireAt FROM customer_order WHERE uuid = :orderUUID`
+
+	err := ExecNamed(ctx, ms.DB(), query, map[string]any{
+		"orderUUID":       orderUUID,
+		"paymentMethodId": paymentMethodId,
+		"expireAt":        expireAt,
+	})
+	if err != nil {
+		return fmt.Errorf("can't insert payment expiration: %w", err)
+	}
+	return nil
+}
+
+// RemovePaymentExpiration removes the scheduled expiration of the order invoice if any.
+func (ms *MYSQLStore) RemovePaymentExpiration(ctx context.Context, orderUUID string) error {
+	query := `
+	DELETE pe FROM payment_expiration pe
+	JOIN customer_order co ON pe.order_id = co.id
+	WHERE co.uuid = :orderUUID`
+
+	err := ExecNamed(ctx, ms.DB(), query, map[string]any{
+		"orderUUID": orderUUID,
+	})
+	if err != nil {
+		return fmt.Errorf("can't delete payment expiration: %w", err)
+	}
+	return nil
+}
+
+// ClaimDuePaymentExpiration locks the earliest expiration which is due, the expirations locked by
+// other transactions are skipped so each one is claimed by a single worker at a time.
+// It returns sql.ErrNoRows if there is no unclaimed due expiration, must be called within a transaction.
+func (ms *MYSQLStore) ClaimDuePaymentExpiration(ctx context.Context) (*entity.PaymentExpiration, error) {
+	query := `
+	SELECT pe.*, co.uuid AS order_uuid, pm.name AS payment_method_name
+	FROM payment_expiration pe
+	JOIN customer_order co ON pe.order_id = co.id
+	JOIN payment_method pm ON pe.payment_method_id = pm.id
+	WHERE pe.expire_at <= CURRENT_TIMESTAMP
+	ORDER BY pe.expire_at
+	LIMIT 1
+	FOR UPDATE OF pe SKIP LOCKED`
+
+	pe, err := QueryNamedOne[entity.PaymentExpiration](ctx, ms.DB(), query, map[string]any{})
+	if err != nil {
+		return nil, fmt.Errorf("can't claim due payment expiration: %w", err)
+	}
+	return &pe, nil
+}
+
+// DeletePaymentExpiration deletes the fired expiration.
+func (ms *MYSQLStore) DeletePaymentExpiration(ctx context.Context, id int) error {
+	query := `DELETE FROM payment_expiration WHERE id = :id`
+	err := ExecNamed(ctx, ms.DB(), query, map[string]any{
+		"id": id,
+	})
+	if err != nil {
+		return fmt.Errorf("can't delete payment expiration: %w", err)
+	}
+	return nil
+}
+
+// DelayPaymentExpiration postpones the expiration which failed to fire by the delay from now,
+// an expiration deleted in the meantime stays deleted.
+func (ms *MYSQLStore) DelayPaymentExpiration(ctx context.Context, id int, delay time.Duration) error {
+	query := `UPDATE payment_expiration SET expire_at = CURRENT_TIMESTAMP + INTERVAL :delay SECOND WHERE id = :id`
+	err := ExecNamed(ctx, ms.DB(), query, map[string]any{
+		"id":    id,
+		"delay": int(delay.Seconds()),
+	})
+	if err != nil {
+		return fmt.Errorf("can't delay payment expiration: %w", err)
+	}
+	return nil
+}
+
+// GetOrderItems retrieves all order items for a given order.
+func (ms *MYSQLStore) GetOrderById(ctx context.Context, orderId int) (*entity.OrderFull, error) {
+	order, err := getOrderById(ctx, ms, orderId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("order is not found")
+		}
+		return nil, fmt.Errorf("can't get order by id: %w", err)
+	}
+	ofs, err := fetchOrderInfo(ctx, ms, []entity.Order{*order})
+	if err != nil {
+		return nil, fmt.Errorf("can't fetch order info: %w", err)
+	}
+	return &ofs[0], nil
+}
+
+func (ms *MYSQLStore) GetOrderFullByUUID(ctx context.Context, uuid string) (*entity.OrderFull, error) {
+	order, err := getOrderByUUID(ctx, ms, uuid)
+	if err != nil {
+		return nil, err
+	}
+	ofs, err := fetchOrderInfo(ctx, ms, []entity.Order{*order})
+	if err != nil {
+		return nil, fmt.Errorf("can't fetch order info: %w", err)
+	}
+	if len(ofs) == 0 {
+		return nil, fmt.Errorf("order is not found")
+	}
+
+	return &ofs[0], nil
+}
+
+// GetOrdersFullPlacedBetween returns up to limit orders placed within [from, to) with the id greater than afterId,
+// the orders are ordered by id so the next batch starts after the last id of the previous one.
+func (ms *MYSQLStore) GetOrdersFullPlacedBetween(ctx context.Context, from, to time.Time, afterId, limit int) ([]entity.OrderFull, error) {
+	query := `
+	SELECT co.*
+	FROM customer_order co
+	WHERE co.placed >= :from AND co.placed < :to AND co.id > :afterId
+	ORDER BY co.id
+	LIMIT :limit`
+
+	orders, err := QueryListNamed[entity.Order](ctx, ms.DB(), query, map[string]any{
+		"from":    from,
+		"to":      to,
+		"afterId": afterId,
+		"limit":   limit,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't get orders placed between: %w", err)
+	}
+	if len(orders) == 0 {
+		return []entity.OrderFull{}, nil
+	}
+
+	ofs, err := fetchOrderInfo(ctx, ms, orders)
+	if err != nil {
+		return nil, fmt.Errorf("can't fetch order info: %w", err)
+	}
+	return ofs, nil
+}
+
+// GetOrdersFullByBuyerEmail returns up to limit latest orders placed with the buyer email.
+func (ms *MYSQLStore) GetOrdersFullByBuyerEmail(ctx context.Context, email string, limit int) ([]entity.OrderFull, error) {
+	query := `
+	SELECT co.*
+	FROM customer_order co
+	JOIN buyer b ON co.id = b.order_id
+	WHERE b.email = :email
+	ORDER BY co.placed DESC, co.id DESC
+	LIMIT :limit`
+
+	orders, err := QueryListNamed[entity.Order](ctx, ms.DB(), query, map[string]any{
+		"email": email,
+		"limit": limit,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't get orders by buyer email: %w", err)
+	}
+	if len(orders) == 0 {
+		return []entity.OrderFull{}, nil
+	}
+
+	ofs, err := fetchOrderInfo(ctx, ms, orders)
+	if err != nil {
+		return nil, fmt.Errorf("can't fetch order info: %w", err)
+	}
+	return ofs, nil
+}
+
+func (ms *MYSQLStore) GetOrdersByStatusAndPaymentTypePaged(
+	ctx context.Context,
+	email string,
+	statusId,
+	paymentMethodId,
+	orderId int,
 	lim,
 	off int,
 	of entity.OrderFactor) ([]entity.Order, error) {
@@ -1654,7 +2185,7 @@ func (ms *MYSQLStore) GetOrdersByStatusAndPaymentTypePaged(
 }
 
 // TODO: reuse getOrdersByStatusPaymentAndEmailPaged
-func getOrdersByStatusAndPayment(ctx context.Context, rep dependency.Repository, orderStatusId int, paymentMethodIds ...int) ([]entity.Order, error) {
+func getOrdersByStatusAndPayment(ctx context.Context, rep dependency.Repository, orderStatusIds []int, paymentMethodIds ...int) ([]entity.Order, error) {
 	query := `
     SELECT 
         co.*
@@ -1662,25 +2193,22 @@ func getOrdersByStatusAndPayment(ctx context.Context, rep dependency.Repository,
     `
 
 	var params = map[string]interface{}{
-		"status": orderStatusId,
+		"statuses": orderStatusIds,
 	}
 
 	if len(paymentMethodIds) > 0 {
 		query += `
         JOIN payment p ON co.id = p.order_id
-        WHERE co.order_status_id = :status AND p.payment_method_id IN (:paymentMethodIds)
+        WHERE co.order_status_id IN (:statuses) AND p.payment_method_id IN (:paymentMethodIds)
         `
 		params["paymentMethodIds"] = paymentMethodIds
 	} else {
 		query += `
-        WHERE co.order_status_id = :status
+        WHERE co.order_status_id IN (:statuses)
         `
 	}
 
-	orders, err := QueryListNamed[entity.Order](ctx, rep.DB(), query, map[string]interface{}{
-		"status":           orderStatusId,
-		"paymentMethodIds": paymentMethodIds,
-	})
+	orders, err := QueryListNamed[entity.Order](ctx, rep.DB(), query, params)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return []entity.Order{}, nil
@@ -1691,8 +2219,8 @@ func getOrdersByStatusAndPayment(ctx context.Context, rep dependency.Repository,
 	return orders, nil
 }
 
-// GetAwaitingPaymentsByPaymentType retrieves all orders with the status "awaiting payment"
-// and the given payment method if payment method is not provided it returns all orders with the status "awaiting payment".
+// GetAwaitingPaymentsByPaymentType retrieves all orders with the status "awaiting payment" or "partially paid"
+// and the given payment method if payment method is not provided it returns all such orders.
 func (ms *MYSQLStore) GetAwaitingPaymentsByPaymentType(ctx context.Context, pmn ...entity.PaymentMethodName) ([]entity.PaymentOrderUUID, error) {
 
 	pmIds := []int{}
@@ -1703,7 +2231,10 @@ func (ms *MYSQLStore) GetAwaitingPaymentsByPaymentType(ctx context.Context, pmn
 		}
 	}
 
-	orders, err := getOrdersByStatusAndPayment(ctx, ms, cache.OrderStatusAwaitingPayment.Status.Id, pmIds...)
+	orders, err := getOrdersByStatusAndPayment(ctx, ms, []int{
+		cache.OrderStatusAwaitingPayment.Status.Id,
+		cache.OrderStatusPartiallyPaid.Status.Id,
+	}, pmIds...)
 	if err != nil {
 		return nil, err
 	}
@@ -1729,154 +2260,557 @@ func (ms *MYSQLStore) GetAwaitingPaymentsByPaymentType(ctx context.Context, pmn
 	return poids, nil
 }
 
+// GetPaymentsCreatedAfter retrieves the payments of the given payment methods issued after the given time
+// together with the uuid and the status of their orders.
+func (ms *MYSQLStore) GetPaymentsCreatedAfter(ctx context.Context, from time.Time, pmn ...entity.PaymentMethodName) ([]entity.PaymentOrderStatus, error) {
+	pmIds := []int{}
+	for _, pmn := range pmn {
+		pm, ok := cache.GetPaymentMethodByName(pmn)
+		if ok {
+			pmIds = append(pmIds, pm.Method.Id)
+		}
+	}
+	if len(pmIds) == 0 {
+		return []entity.PaymentOrderStatus{}, nil
+	}
+
+	query := `
+	SELECT 
+		customer_order.uuid as order_uuid,
+		customer_order.order_status_id,
+		payment.*
+	FROM payment
+	JOIN customer_order ON payment.order_id = customer_order.id
+	WHERE payment.created_at >= :from AND payment.payment_method_id IN (:paymentMethodIds)
+	ORDER BY payment.created_at`
+
+	payments, err := QueryListNamed[entity.PaymentOrderStatus](ctx, ms.DB(), query, map[string]any{
+		"from":             from,
+		"paymentMethodIds": pmIds,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't get payments created after: %w", err)
+	}
+
+	return payments, nil
+}
+
+// ExpireOrderPayment expires the invoice of the order awaiting payment and cancels the order,
+// it must be called within a transaction.
 func (ms *MYSQLStore) ExpireOrderPayment(ctx context.Context, orderUUID string) (*entity.Payment, error) {
-	var payment *entity.Payment
+	order, err := getOrderByUUID(ctx, ms, orderUUID)
+	if err != nil {
+		return nil, fmt.Errorf("can't get order by id: %w", err)
+	}
 
-	// Use transaction context
-	err := ms.Tx(ctx, func(ctx context.Context, rep dependency.Repository) error {
-		order, err := getOrderByUUID(ctx, rep, orderUUID)
-		if err != nil {
-			return fmt.Errorf("can't get order by id: %w", err)
-		}
+	// Fetch order status from cache
+	orderStatus, ok := cache.GetOrderStatusById(order.OrderStatusId)
+	if !ok {
+		return nil, fmt.Errorf("order status does not exist: order status id %d", order.OrderStatusId)
+	}
 
-		// Fetch order status from cache
-		orderStatus, ok := cache.GetOrderStatusById(order.OrderStatusId)
-		if !ok {
-			return fmt.Errorf("order status does not exist: order status id %d", order.OrderStatusId)
-		}
+	// Check if the order status is not "awaiting payment"
+	if orderStatus.Status.Name != entity.AwaitingPayment {
+		slog.DebugContext(ctx, "order status is not awaiting payment, no expiration needed",
+			slog.String("order_status", orderStatus.PB.String()),
+		)
+		return nil, nil
+	}
 
-		// Check if the order status is not "awaiting payment"
-		if orderStatus.Status.Name != entity.AwaitingPayment {
-			slog.DebugContext(ctx, "order status is not awaiting payment, no expiration needed",
-				slog.String("order_status", orderStatus.PB.String()),
-			)
-			return nil
-		}
+	// Get payment by order UUID
+	payment, err := ms.GetPaymentByOrderUUID(ctx, order.UUID)
+	if err != nil {
+		return nil, fmt.Errorf("can't get payment by order id: %w", err)
+	}
 
-		// Get payment by order UUID
-		payment, err = ms.GetPaymentByOrderUUID(ctx, order.UUID)
-		if err != nil {
-			return fmt.Errorf("can't get payment by order id: %w", err)
-		}
+	// Get order items
+	orderItems, err := getOrderItemsInsert(ctx, ms, order.Id)
+	if err != nil {
+		return nil, fmt.Errorf("can't get order items: %w", err)
+	}
 
-		// Get order items
-		orderItems, err := getOrderItemsInsert(ctx, rep, order.Id)
-		if err != nil {
-			return fmt.Errorf("can't get order items: %w", err)
-		}
+	// Prepare payment update to initial state
+	paymentUpdate := entity.PaymentInsert{
+		PaymentMethodID:                  payment.PaymentMethodID,
+		TransactionID:                    sql.NullString{Valid: false},
+		TransactionAmount:                decimal.Zero,
+		TransactionAmountPaymentCurrency: decimal.Zero,
+		Payer:                            sql.NullString{Valid: false},
+		Payee:                            sql.NullString{Valid: false},
+		IsTransactionDone:                false,
+	}
 
-		// Prepare payment update to initial state
-		paymentUpdate := entity.PaymentInsert{
-			PaymentMethodID:                  payment.PaymentMethodID,
-			TransactionID:                    sql.NullString{Valid: false},
-			TransactionAmount:                decimal.Zero,
-			TransactionAmountPaymentCurrency: decimal.Zero,
-			Payer:                            sql.NullString{Valid: false},
-			Payee:                            sql.NullString{Valid: false},
-			IsTransactionDone:                false,
-		}
+	// TODO: check if payment is already done
+
+	// Record the expired invoice before it's reset
+	err = addPaymentEvent(ctx, ms, order.Id, entity.PaymentEventExpired, sql.NullString{}, newPaymentPayload(&payment.PaymentInsert))
+	if err != nil {
+		return nil, fmt.Errorf("can't add payment event: %w", err)
+	}
 
-		// TODO: check if payment is already done
+	// Update order payment
+	if err := updateOrderPayment(ctx, ms, order.Id, paymentUpdate); err != nil {
+		return nil, fmt.Errorf("can't update order payment: %w", err)
+	}
 
-		// Update order payment
-		if err := updateOrderPayment(ctx, rep, order.Id, paymentUpdate); err != nil {
-			return fmt.Errorf("can't update order payment: %w", err)
-		}
+	err = cancelOrder(ctx, ms, order, orderItems, entity.OrderStatusActorSystem, invoiceExpiredReason)
+	if err != nil {
+		return nil, fmt.Errorf("can't cancel order: %w", err)
+	}
+
+	return payment, nil
+}
 
-		err = cancelOrder(ctx, rep, order, orderItems)
+// OrderPaymentDone confirms the order awaiting payment as paid with the payment,
+// it must be called within a transaction.
+func (ms *MYSQLStore) OrderPaymentDone(ctx context.Context, orderUUID string, p *entity.Payment) (*entity.Payment, error) {
+	order, err := getOrderByUUID(ctx, ms, orderUUID)
+	if err != nil {
+		return p, fmt.Errorf("can't get order by id: %w", err)
+	}
+
+	if order.PromoId.Int32 != 0 {
+		err := ms.Promo().DisableVoucher(ctx, order.PromoId)
 		if err != nil {
-			return fmt.Errorf("can't cancel order: %w", err)
+			return p, fmt.Errorf("can't disable voucher: %w", err)
 		}
+	}
+
+	os, ok := cache.GetOrderStatusById(order.OrderStatusId)
+	if !ok {
+		return p, fmt.Errorf("order status is not exists: order status id %d", order.OrderStatusId)
+	}
 
+	if os.Status.Name != entity.AwaitingPayment && os.Status.Name != entity.PartiallyPaid {
+		return p, nil
+	}
+
+	err = updateOrderStatus(ctx, ms, order, entity.Confirmed, entity.OrderStatusActorSystem, "payment confirmed")
+	if err != nil {
+		return p, fmt.Errorf("can't update order status: %w", err)
+	}
+
+	p.PaymentInsert.IsTransactionDone = true
+
+	err = updateOrderPayment(ctx, ms, order.Id, p.PaymentInsert)
+	if err != nil {
+		return p, fmt.Errorf("can't update order payment: %w", err)
+	}
+
+	err = addPaymentEvent(ctx, ms, order.Id, entity.PaymentEventConfirmed, p.TransactionID, newPaymentPayload(&p.PaymentInsert))
+	if err != nil {
+		return p, fmt.Errorf("can't add payment event: %w", err)
+	}
+
+	return p, nil
+}
+
+// OrderPaymentPartiallyPaid marks the order awaiting payment as partially paid,
+// the order stays reserved until the rest is paid or it's resolved manually.
+// It must be called within a transaction.
+func (ms *MYSQLStore) OrderPaymentPartiallyPaid(ctx context.Context, orderUUID string) error {
+	order, err := getOrderByUUID(ctx, ms, orderUUID)
+	if err != nil {
+		return fmt.Errorf("can't get order by id: %w", err)
+	}
+
+	os, ok := cache.GetOrderStatusById(order.OrderStatusId)
+	if !ok {
+		return fmt.Errorf("order status is not exists: order status id %d", order.OrderStatusId)
+	}
+
+	if os.Status.Name != entity.AwaitingPayment {
 		return nil
+	}
+
+	err = updateOrderStatus(ctx, ms, order, entity.PartiallyPaid, entity.OrderStatusActorSystem, "payment received in part")
+	if err != nil {
+		return fmt.Errorf("can't update order status: %w", err)
+	}
+	return nil
+}
+
+// AddPaymentOverpayment records the amount received above the invoice total for the manual refund,
+// repeated calls for the same order overwrite the recorded amount.
+func (ms *MYSQLStore) AddPaymentOverpayment(ctx context.Context, orderUUID string, op *entity.PaymentOverpaymentInsert) error {
+	order, err := getOrderByUUID(ctx, ms, orderUUID)
+	if err != nil {
+		return fmt.Errorf("can't get order by uuid: %w", err)
+	}
+	op.OrderId = order.Id
+
+	query := `
+	INSERT INTO payment_overpayment (order_id, payment_method_id, amount_payment_currency, payer, transaction_id)
+	VALUES (:orderId, :paymentMethodId, :amountPaymentCurrency, :payer, :transactionId)
+	ON DUPLICATE KEY UPDATE
+		amount_payment_currency = VALUES(amount_payment_currency),
+		payer = VALUES(payer),
+		transaction_id = VALUES(transaction_id)`
+
+	err = ExecNamed(ctx, ms.DB(), query, map[string]any{
+		"orderId":               op.OrderId,
+		"paymentMethodId":       op.PaymentMethodId,
+		"amountPaymentCurrency": op.AmountPaymentCurrency,
+		"payer":                 op.Payer,
+		"transactionId":         op.TransactionId,
 	})
+	if err != nil {
+		return fmt.Errorf("can't insert payment overpayment: %w", err)
+	}
+	return nil
+}
 
+// RefundOrder records a pending refund of the given amount of the order payment,
+// the whole remaining amount is refunded if amount is zero. The pending refund counts against
+// the paid amount, it's applied to the order once issued with UpdateRefund.
+// The part paid through the payment provider is refunded first, the rest of the amount is credited
+// back to the gift card the order was paid with right away. The refund covered by the gift card
+// alone is issued at once as there is nothing to refund through the provider.
+// The restock items are returned to stock once the refund is issued, the quantities restocked by
+// the earlier refunds and by the received returns of the order can't be restocked again.
+func (ms *MYSQLStore) RefundOrder(ctx context.Context, orderUUID string, amount decimal.Decimal, restock []entity.RefundItemInsert, reason string, actor string) (*entity.Refund, error) {
+	// lock the order so concurrent refunds can't exceed the paid amount
+	order, err := QueryNamedOne[entity.Order](ctx, ms.DB(), `SELECT * FROM customer_order WHERE uuid = :uuid FOR UPDATE`, map[string]any{
+		"uuid": orderUUID,
+	})
 	if err != nil {
+		return nil, fmt.Errorf("can't get order by uuid: %w", err)
+	}
+
+	// the partial refund keeps the order status, the order must be refundable anyway
+	if err := validateOrderStatusTransition(&order, entity.Refunded); err != nil {
 		return nil, err
 	}
 
-	return payment, nil
-}
+	payment, err := ms.GetPaymentByOrderUUID(ctx, orderUUID)
+	if err != nil {
+		return nil, fmt.Errorf("can't get payment by order uuid: %w", err)
+	}
 
-func (ms *MYSQLStore) OrderPaymentDone(ctx context.Context, orderUUID string, p *entity.Payment) (*entity.Payment, error) {
-	err := ms.Tx(ctx, func(ctx context.Context, rep dependency.Repository) error {
+	refunds, err := getRefundsByPaymentId(ctx, ms, payment.Id)
+	if err != nil {
+		return nil, fmt.Errorf("can't get refunds: %w", err)
+	}
 
-		order, err := getOrderByUUID(ctx, rep, orderUUID)
-		if err != nil {
-			return fmt.Errorf("can't get order by id: %w", err)
+	refunded := decimal.Zero
+	for _, r := range refunds {
+		if r.Status == entity.RefundFailed {
+			continue
 		}
+		refunded = refunded.Add(r.Amount)
+	}
 
-		if order.PromoId.Int32 != 0 {
-			err := rep.Promo().DisableVoucher(ctx, order.PromoId)
-			if err != nil {
-				return fmt.Errorf("can't disable voucher: %w", err)
-			}
-		}
+	if err := validateRefundRestock(ctx, ms, &order, payment.Id, restock); err != nil {
+		return nil, err
+	}
 
-		os, ok := cache.GetOrderStatusById(order.OrderStatusId)
-		if !ok {
-			return fmt.Errorf("order status is not exists: order status id %d", order.OrderStatusId)
-		}
+	// the gift card amount of the order is reduced by the credited refunds
+	remainingPaid := decimal.Max(payment.TransactionAmount.Sub(refunded), decimal.Zero)
+	remainingGiftCard := order.GiftCardAmount
+	if !order.GiftCardId.Valid {
+		remainingGiftCard = decimal.Zero
+	}
+	remaining := remainingPaid.Add(remainingGiftCard)
+	if !remaining.IsPositive() {
+		return nil, fmt.Errorf("order is already refunded")
+	}
+	if amount.IsZero() {
+		amount = remaining
+	}
+	if amount.IsNegative() || amount.GreaterThan(remaining) {
+		return nil, fmt.Errorf("refund amount must be in (0, %s]: amount %s", remaining.String(), amount.String())
+	}
 
-		if os.Status.Name != entity.AwaitingPayment {
-			return nil
-		}
+	paidPart := decimal.Min(amount, remainingPaid)
+	giftCardPart := amount.Sub(paidPart)
 
-		err = updateOrderStatus(ctx, rep, order.Id, cache.OrderStatusConfirmed.Status.Id)
+	if err := returnGiftCardBalance(ctx, ms, &order, giftCardPart); err != nil {
+		return nil, fmt.Errorf("can't return gift card balance: %w", err)
+	}
+
+	ri := entity.RefundInsert{
+		PaymentId:      payment.Id,
+		Amount:         paidPart,
+		Status:         entity.RefundPending,
+		Payee:          payment.Payer,
+		Reason:         sql.NullString{String: reason, Valid: reason != ""},
+		Restocked:      len(restock) > 0,
+		GiftCardAmount: giftCardPart,
+	}
+
+	id, err := ExecNamedLastId(ctx, ms.DB(), `
+	INSERT INTO refund (payment_id, amount, status, payee, reason, restocked, gift_card_amount)
+	VALUES (:paymentId, :amount, :status, :payee, :reason, :restocked, :giftCardAmount)`, map[string]any{
+		"paymentId":      ri.PaymentId,
+		"amount":         ri.Amount,
+		"status":         ri.Status,
+		"payee":          ri.Payee,
+		"reason":         ri.Reason,
+		"restocked":      ri.Restocked,
+		"giftCardAmount": ri.GiftCardAmount,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't insert refund: %w", err)
+	}
+
+	for _, item := range restock {
+		err = ExecNamed(ctx, ms.DB(), `
+		INSERT INTO refund_item (refund_id, order_item_id, quantity)
+		VALUES (:refundId, :orderItemId, :quantity)`, map[string]any{
+			"refundId":    id,
+			"orderItemId": item.OrderItemId,
+			"quantity":    item.Quantity,
+		})
 		if err != nil {
-			return fmt.Errorf("can't update order status: %w", err)
+			return nil, fmt.Errorf("can't insert refund item: %w", err)
 		}
+	}
 
-		p.PaymentInsert.IsTransactionDone = true
+	err = insertPaymentEvent(ctx, ms, payment, &entity.PaymentEventInsert{
+		Type: entity.PaymentEventRefunded,
+		Payload: entity.PaymentEventPayload(refundPayload{
+			RefundId:       id,
+			Amount:         ri.Amount,
+			Status:         string(ri.Status),
+			Reason:         ri.Reason.String,
+			Restocked:      ri.Restocked,
+			RestockedItems: restock,
+			GiftCardAmount: ri.GiftCardAmount,
+		}),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't add payment event: %w", err)
+	}
 
-		err = updateOrderPayment(ctx, rep, order.Id, p.PaymentInsert)
+	if paidPart.IsPositive() {
+		refund, err := getRefundById(ctx, ms, id)
 		if err != nil {
-			return fmt.Errorf("can't update order payment: %w", err)
+			return nil, fmt.Errorf("can't get refund: %w", err)
 		}
+		return refund, nil
+	}
+
+	return updateRefund(ctx, ms, id, entity.RefundSucceeded, "", actor)
+}
 
+// validateRefundRestock checks the items to restock belong to the order and their quantities
+// aren't restocked already by the refunds which didn't fail or by the received returns of the order.
+func validateRefundRestock(ctx context.Context, rep dependency.Repository, order *entity.Order, paymentId int, restock []entity.RefundItemInsert) error {
+	if len(restock) == 0 {
 		return nil
+	}
+
+	query := `
+	SELECT id AS order_item_id, quantity
+	FROM order_item
+	WHERE order_id = :orderId`
+	ordered, err := QueryListNamed[entity.RefundItemInsert](ctx, rep.DB(), query, map[string]any{
+		"orderId": order.Id,
+	})
+	if err != nil {
+		return fmt.Errorf("can't get order items: %w", err)
+	}
+	available := make(map[int]int, len(ordered))
+	for _, oi := range ordered {
+		available[oi.OrderItemId] = oi.Quantity
+	}
+
+	query = `
+	SELECT ri.order_item_id, ri.quantity
+	FROM refund_item ri
+	JOIN refund r ON ri.refund_id = r.id
+	WHERE r.payment_id = :paymentId AND r.status <> :failed
+	UNION ALL
+	SELECT ri.order_item_id, ri.quantity
+	FROM return_item ri
+	JOIN return_request rr ON ri.return_request_id = rr.id
+	WHERE rr.order_id = :orderId AND rr.status IN (:received, :refunded, :exchanged)`
+	restocked, err := QueryListNamed[entity.RefundItemInsert](ctx, rep.DB(), query, map[string]any{
+		"paymentId": paymentId,
+		"failed":    entity.RefundFailed,
+		"orderId":   order.Id,
+		"received":  entity.ReturnReceived,
+		"refunded":  entity.ReturnRefunded,
+		"exchanged": entity.ReturnExchanged,
 	})
 	if err != nil {
-		return p, err
+		return fmt.Errorf("can't get restocked order items: %w", err)
+	}
+	for _, ri := range restocked {
+		available[ri.OrderItemId] -= ri.Quantity
 	}
 
-	return p, nil
+	for _, ri := range restock {
+		left, ok := available[ri.OrderItemId]
+		if !ok {
+			return fmt.Errorf("order item %d is not an item of the order", ri.OrderItemId)
+		}
+		if ri.Quantity <= 0 || ri.Quantity > left {
+			return fmt.Errorf("restock quantity of order item %d must be in (0, %d]: quantity %d", ri.OrderItemId, left, ri.Quantity)
+		}
+		available[ri.OrderItemId] = left - ri.Quantity
+	}
+	return nil
 }
 
-// TODO:
-func (ms *MYSQLStore) RefundOrder(ctx context.Context, orderUUID string) error {
-	err := ms.Tx(ctx, func(ctx context.Context, rep dependency.Repository) error {
+// UpdateRefund issues the recorded refund with the status and the id of the refund at the payment provider.
+// The refund which isn't failed is applied to the order: its restock items are returned to stock
+// and the order status is set to refunded once it's refunded in full.
+// The gift card part of the refund stays credited even if the provider refund failed.
+// It must be called within a transaction.
+func (ms *MYSQLStore) UpdateRefund(ctx context.Context, refundId int, st entity.RefundStatus, providerRefundId string, actor string) (*entity.Refund, error) {
+	return updateRefund(ctx, ms, refundId, st, providerRefundId, actor)
+}
 
-		order, err := getOrderByUUID(ctx, rep, orderUUID)
-		if err != nil {
-			return err
-		}
+func updateRefund(ctx context.Context, rep dependency.Repository, refundId int, st entity.RefundStatus, providerRefundId string, actor string) (*entity.Refund, error) {
+	r, err := QueryNamedOne[entity.Refund](ctx, rep.DB(), `SELECT * FROM refund WHERE id = :id FOR UPDATE`, map[string]any{
+		"id": refundId,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't get refund: %w", err)
+	}
+	if r.IssuedAt.Valid {
+		return nil, fmt.Errorf("refund is already issued: status %s", r.Status)
+	}
 
-		orderStatus, ok := cache.GetOrderStatusById(order.OrderStatusId)
-		if !ok {
-			return fmt.Errorf("order status is not exists: order status id %d", order.OrderStatusId)
-		}
+	query := `
+	UPDATE refund
+	SET status = :status,
+		provider_refund_id = :providerRefundId,
+		issued_at = CURRENT_TIMESTAMP
+	WHERE id = :id`
+	err = ExecNamed(ctx, rep.DB(), query, map[string]any{
+		"id":               refundId,
+		"status":           st,
+		"providerRefundId": sql.NullString{String: providerRefundId, Valid: providerRefundId != ""},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't update refund: %w", err)
+	}
 
-		if orderStatus.Status.Name != entity.Delivered {
-			return fmt.Errorf("order status can be only in (Confirmed, Delivered): order status %s", orderStatus.Status.Name)
-		}
+	refund, err := getRefundById(ctx, rep, refundId)
+	if err != nil {
+		return nil, fmt.Errorf("can't get refund: %w", err)
+	}
+	payment, err := QueryNamedOne[entity.Payment](ctx, rep.DB(), `SELECT * FROM payment WHERE id = :id`, map[string]any{
+		"id": refund.PaymentId,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't get payment by id: %w", err)
+	}
+
+	err = insertPaymentEvent(ctx, rep, &payment, &entity.PaymentEventInsert{
+		Type: entity.PaymentEventRefunded,
+		Payload: entity.PaymentEventPayload(refundPayload{
+			RefundId:         refund.Id,
+			Amount:           refund.Amount,
+			Status:           string(refund.Status),
+			ProviderRefundId: refund.ProviderRefundId.String,
+			Reason:           refund.Reason.String,
+			Restocked:        refund.Restocked,
+			GiftCardAmount:   refund.GiftCardAmount,
+		}),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't add payment event: %w", err)
+	}
 
-		err = updateOrderStatus(ctx, rep, order.Id, cache.OrderStatusRefunded.Status.Id)
+	if refund.Status == entity.RefundFailed {
+		return refund, nil
+	}
+	if err := applyRefund(ctx, rep, &payment, refund, actor); err != nil {
+		return nil, err
+	}
+	return refund, nil
+}
+
+// applyRefund restocks the refund items of the issued refund and marks the order refunded
+// once the issued refunds cover the paid amount and the gift card amount is credited back in full.
+func applyRefund(ctx context.Context, rep dependency.Repository, payment *entity.Payment, refund *entity.Refund, actor string) error {
+	order, err := QueryNamedOne[entity.Order](ctx, rep.DB(), `SELECT * FROM customer_order WHERE id = :id FOR UPDATE`, map[string]any{
+		"id": payment.OrderId,
+	})
+	if err != nil {
+		return fmt.Errorf("can't get order by id: %w", err)
+	}
+
+	if refund.Restocked {
+		query := `
+		SELECT oi.product_id, oi.size_id, ri.quantity
+		FROM refund_item ri
+		JOIN order_item oi ON ri.order_item_id = oi.id
+		WHERE ri.refund_id = :refundId`
+		items, err := QueryListNamed[entity.OrderItemInsert](ctx, rep.DB(), query, map[string]any{
+			"refundId": refund.Id,
+		})
 		if err != nil {
-			return fmt.Errorf("can't update order status: %w", err)
+			return fmt.Errorf("can't get refund items: %w", err)
 		}
+		err = rep.Products().RestoreStockForProductSizes(ctx, items)
+		if err != nil {
+			return fmt.Errorf("can't restore stock for product sizes: %w", err)
+		}
+	}
+
+	refunds, err := getRefundsByPaymentId(ctx, rep, payment.Id)
+	if err != nil {
+		return fmt.Errorf("can't get refunds: %w", err)
+	}
+	// the refunds which are not issued yet may still fail
+	refunded := decimal.Zero
+	for _, r := range refunds {
+		if r.IssuedAt.Valid && r.Status != entity.RefundFailed {
+			refunded = refunded.Add(r.Amount)
+		}
+	}
 
+	if refunded.LessThan(payment.TransactionAmount) || (order.GiftCardId.Valid && order.GiftCardAmount.IsPositive()) {
 		return nil
+	}
+	err = updateOrderStatus(ctx, rep, &order, entity.Refunded, actor, refund.Reason.String)
+	if err != nil {
+		return fmt.Errorf("can't update order status: %w", err)
+	}
+	return nil
+}
+
+// GetRefundsByOrderUUID returns all refunds of the order payment.
+func (ms *MYSQLStore) GetRefundsByOrderUUID(ctx context.Context, orderUUID string) ([]entity.Refund, error) {
+	payment, err := ms.GetPaymentByOrderUUID(ctx, orderUUID)
+	if err != nil {
+		return nil, fmt.Errorf("can't get payment by order uuid: %w", err)
+	}
+	refunds, err := getRefundsByPaymentId(ctx, ms, payment.Id)
+	if err != nil {
+		return nil, fmt.Errorf("can't get refunds: %w", err)
+	}
+	return refunds, nil
+}
+
+func getRefundsByPaymentId(ctx context.Context, rep dependency.Repository, paymentId int) ([]entity.Refund, error) {
+	query := `SELECT * FROM refund WHERE payment_id = :paymentId ORDER BY id`
+	refunds, err := QueryListNamed[entity.Refund](ctx, rep.DB(), query, map[string]any{
+		"paymentId": paymentId,
 	})
 	if err != nil {
-		return fmt.Errorf("can't update order payment: %w", err)
+		return nil, err
 	}
+	return refunds, nil
+}
 
-	return nil
+func getRefundById(ctx context.Context, rep dependency.Repository, id int) (*entity.Refund, error) {
+	query := `SELECT * FROM refund WHERE id = :id`
+	refund, err := QueryNamedOne[entity.Refund](ctx, rep.DB(), query, map[string]any{
+		"id": id,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &refund, nil
 }
 
-// TODO:
-func (ms *MYSQLStore) DeliveredOrder(ctx context.Context, orderUUID string) error {
+// DeliveredOrder marks the confirmed or shipped order as delivered.
+func (ms *MYSQLStore) DeliveredOrder(ctx context.Context, orderUUID string, actor string) error {
 	err := ms.Tx(ctx, func(ctx context.Context, rep dependency.Repository) error {
 
 		order, err := getOrderByUUID(ctx, rep, orderUUID)
@@ -1884,16 +2818,14 @@ func (ms *MYSQLStore) DeliveredOrder(ctx context.Context, orderUUID string) erro
 			return fmt.Errorf("can't get order by id: %w", err)
 		}
 
-		orderStatus, ok := cache.GetOrderStatusById(order.OrderStatusId)
-		if !ok {
-			return fmt.Errorf("order status is not exists: order status id %d", order.OrderStatusId)
+		if err := validateOrderStatusTransition(order, entity.Delivered); err != nil {
+			return err
 		}
-
-		if orderStatus.Status.Name != entity.Shipped && orderStatus.Status.Name != entity.Confirmed {
-			return fmt.Errorf("order status can be only in (Confirmed, Shipped): order status %s", orderStatus.Status.Name)
+		if err := validateOrderShipped(ctx, rep, order.Id); err != nil {
+			return err
 		}
 
-		err = updateOrderStatus(ctx, rep, order.Id, cache.OrderStatusDelivered.Status.Id)
+		err = updateOrderStatus(ctx, rep, order, entity.Delivered, actor, "order delivered")
 		if err != nil {
 			return fmt.Errorf("can't update order status: %w", err)
 		}
@@ -1918,29 +2850,28 @@ func removePromo(ctx context.Context, rep dependency.Repository, orderId int) er
 	return nil
 }
 
-func cancelOrder(ctx context.Context, rep dependency.Repository, order *entity.Order, orderItems []entity.OrderItemInsert) error {
-	orderStatus, ok := cache.GetOrderStatusById(order.OrderStatusId)
-	if !ok {
-		return fmt.Errorf("order status is not exists: order status id %d", order.OrderStatusId)
+func cancelOrder(ctx context.Context, rep dependency.Repository, order *entity.Order, orderItems []entity.OrderItemInsert, actor, reason string) error {
+	st, err := getOrderStatusName(order)
+	if err != nil {
+		return err
 	}
-	st := orderStatus.Status.Name
 	if st == entity.Cancelled {
 		return nil
 	}
 
-	if st == entity.Refunded ||
-		st == entity.Delivered ||
-		st == entity.Shipped ||
-		st == entity.Confirmed {
-		return fmt.Errorf("order status can't be canceled: order status %s", st)
+	if err := st.ValidateTransition(entity.Cancelled); err != nil {
+		return err
 	}
 
-	if st == entity.AwaitingPayment {
+	if st == entity.AwaitingPayment || st == entity.PartiallyPaid {
 		err := rep.Products().RestoreStockForProductSizes(ctx, orderItems)
 		if err != nil {
 			return fmt.Errorf("can't restore stock for product sizes: %w", err)
 		}
 	}
+	if err := releaseStockReservations(ctx, rep, order.Id, entity.StockReservationReleased); err != nil {
+		return err
+	}
 
 	// err := deleteOrderItems(ctx, rep, orderFull.Order.ID)
 	// if err != nil {
@@ -1952,6 +2883,10 @@ func cancelOrder(ctx context.Context, rep dependency.Repository, order *entity.O
 	// 	return fmt.Errorf("can't set zero total: %w", err)
 	// }
 
+	if err := returnGiftCardBalance(ctx, rep, order, order.GiftCardAmount); err != nil {
+		return fmt.Errorf("can't return gift card balance: %w", err)
+	}
+
 	if order.PromoId.Int32 != 0 {
 		err := removePromo(ctx, rep, int(order.PromoId.Int32))
 		if err != nil {
@@ -1959,12 +2894,7 @@ func cancelOrder(ctx context.Context, rep dependency.Repository, order *entity.O
 		}
 	}
 
-	statusCancelled, ok := cache.GetOrderStatusByName(entity.Cancelled)
-	if !ok {
-		return fmt.Errorf("can't get order status by name %s", entity.Cancelled)
-	}
-
-	err := updateOrderStatus(ctx, rep, order.Id, statusCancelled.Status.Id)
+	err = updateOrderStatus(ctx, rep, order, entity.Cancelled, actor, reason)
 	if err != nil {
 		return fmt.Errorf("can't update order status: %w", err)
 	}
@@ -1973,14 +2903,14 @@ func cancelOrder(ctx context.Context, rep dependency.Repository, order *entity.O
 
 }
 
-func (ms *MYSQLStore) CancelOrder(ctx context.Context, orderUUID string) error {
+func (ms *MYSQLStore) CancelOrder(ctx context.Context, orderUUID string, actor, reason string) error {
 	orderFull, err := ms.GetOrderFullByUUID(ctx, orderUUID)
 	if err != nil {
 		return fmt.Errorf("can't get order by id: %w", err)
 	}
 
 	err = ms.Tx(ctx, func(ctx context.Context, rep dependency.Repository) error {
-		err = cancelOrder(ctx, rep, &orderFull.Order, entity.ConvertOrderItemToOrderItemInsert(orderFull.OrderItems))
+		err = cancelOrder(ctx, rep, &orderFull.Order, entity.ConvertOrderItemToOrderItemInsert(orderFull.OrderItems), actor, reason)
 		if err != nil {
 			return fmt.Errorf("can't cancel order: %w", err)
 		}
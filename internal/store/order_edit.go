@@ -0,0 +1,335 @@
+package store
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jekabolt/grbpwr-manager/internal/dependency"
+	"github.com/jekabolt/grbpwr-manager/internal/entity"
+	"github.com/shopspring/decimal"
+)
+
+func getOrderByUUIDForUpdate(ctx context.Context, rep dependency.Repository, orderUUID string) (*entity.Order, error) {
+	order, err := QueryNamedOne[entity.Order](ctx, rep.DB(), `SELECT * FROM customer_order WHERE uuid = :uuid FOR UPDATE`, map[string]any{
+		"uuid": orderUUID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't get order by uuid: %w", err)
+	}
+	return &order, nil
+}
+
+// getEditableOrder locks the order for the admin edit, only the confirmed order which is not shipped yet can be edited.
+// The invoice of the order issued without its content stores it before the edit so the edit doesn't change it.
+func getEditableOrder(ctx context.Context, rep dependency.Repository, orderUUID string) (*entity.Order, error) {
+	order, err := getOrderByUUIDForUpdate(ctx, rep, orderUUID)
+	if err != nil {
+		return nil, err
+	}
+
+	st, err := getOrderStatusName(order)
+	if err != nil {
+		return nil, err
+	}
+	if st != entity.Confirmed {
+		return nil, &entity.OrderNotEditableError{Status: st}
+	}
+
+	if err := snapshotOrderInvoice(ctx, rep, order.Id); err != nil {
+		return nil, fmt.Errorf("can't snapshot order invoice: %w", err)
+	}
+	return order, nil
+}
+
+// UpdateOrderAddresses replaces the shipping and billing addresses of the order. The tax is recomputed
+// for the new shipping country with the prices the items were sold at and the original promo,
+// the difference of the amount paid is recorded as the adjustment, nil is returned if the amount is the same.
+// The invoice is not changed, the correction of it is issued instead.
+func (ms *MYSQLStore) UpdateOrderAddresses(ctx context.Context, orderUUID string, shipping, billing *entity.AddressInsert, actor string) (*entity.OrderAdjustment, error) {
+	var adjustment *entity.OrderAdjustment
+	err := ms.Tx(ctx, func(ctx context.Context, rep dependency.Repository) error {
+		order, err := getEditableOrder(ctx, rep, orderUUID)
+		if err != nil {
+			return err
+		}
+
+		buyer, err := getBuyerById(ctx, rep, order.Id)
+		if err != nil {
+			return fmt.Errorf("can't get buyer by id: %w", err)
+		}
+
+		// the addresses may be shared by shipping and billing, so the new ones are inserted
+		sAdr, bAdr, err := insertAddresses(ctx, rep, shipping, billing)
+		if err != nil {
+			return fmt.Errorf("can't insert addresses: %w", err)
+		}
+
+		err = ExecNamed(ctx, rep.DB(), `
+		UPDATE buyer
+		SET shipping_address_id = :shippingAddressId,
+			billing_address_id = :billingAddressId
+		WHERE order_id = :orderId`, map[string]any{
+			"orderId":           order.Id,
+			"shippingAddressId": sAdr,
+			"billingAddressId":  bAdr,
+		})
+		if err != nil {
+			return fmt.Errorf("can't update buyer addresses: %w", err)
+		}
+
+		err = ExecNamed(ctx, rep.DB(), `DELETE FROM address WHERE id IN (:ids)`, map[string]any{
+			"ids": []int{buyer.ShippingAddressID, buyer.BillingAddressID},
+		})
+		if err != nil {
+			return fmt.Errorf("can't delete old addresses: %w", err)
+		}
+
+		ois, err := getOrdersItems(ctx, rep, []int{order.Id})
+		if err != nil {
+			return fmt.Errorf("can't get order items: %w", err)
+		}
+		items := ois[order.Id]
+		subtotal := decimal.Zero
+		for _, item := range items {
+			subtotal = subtotal.Add(item.ProductPriceWithSale.Mul(item.Quantity))
+		}
+
+		shipment, err := getOrderShipment(ctx, rep, order.Id)
+		if err != nil {
+			return fmt.Errorf("can't get order shipment: %w", err)
+		}
+
+		// the voucher is disabled once the order is paid, its discount still applies to the edited order
+		promos, err := promosByOrderIds(ctx, rep, []int{order.Id})
+		if err != nil {
+			return fmt.Errorf("can't get order promo: %w", err)
+		}
+		promo := promos[order.Id]
+
+		tax, err := calculateOrderTax(ctx, rep, shipping.Country, items, promo, shipment.CostDecimal())
+		if err != nil {
+			return fmt.Errorf("can't calculate tax: %w", err)
+		}
+		if err := updateOrderTax(ctx, rep, order.Id, items, tax); err != nil {
+			return err
+		}
+
+		total := promo.SubtotalWithPromo(subtotal.Round(2), shipment.CostDecimal()).Add(tax.Surcharge()).Round(2)
+		adjustment, err = updateEditedOrderTotal(ctx, rep, order, promo, total, actor)
+		if err != nil {
+			return err
+		}
+		return correctOrderInvoice(ctx, rep, order.Id)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return adjustment, nil
+}
+
+// UpdateOrderBuyer updates the name, email and phone of the order buyer, the invoice is corrected if the name or email changes.
+func (ms *MYSQLStore) UpdateOrderBuyer(ctx context.Context, orderUUID string, b *entity.BuyerInsert) error {
+	return ms.Tx(ctx, func(ctx context.Context, rep dependency.Repository) error {
+		order, err := getEditableOrder(ctx, rep, orderUUID)
+		if err != nil {
+			return err
+		}
+
+		err = ExecNamed(ctx, rep.DB(), `
+		UPDATE buyer
+		SET first_name = :firstName,
+			last_name = :lastName,
+			email = :email,
+			phone = :phone
+		WHERE order_id = :orderId`, map[string]any{
+			"orderId":   order.Id,
+			"firstName": b.FirstName,
+			"lastName":  b.LastName,
+			"email":     b.Email,
+			"phone":     b.Phone,
+		})
+		if err != nil {
+			return fmt.Errorf("can't update buyer: %w", err)
+		}
+		return correctOrderInvoice(ctx, rep, order.Id)
+	})
+}
+
+// UpdateOrderItemsByAdmin replaces the items of the paid order, the stock of the old items is restored
+// and the new items are taken from the stock. The products already on the order keep the price they were sold at,
+// only the products added by the edit are priced at the current price. The total is recomputed with the original promo
+// and the difference of the amount paid is recorded as the adjustment, nil is returned if the amount is the same.
+// The invoice is not changed, the correction of it is issued instead.
+func (ms *MYSQLStore) UpdateOrderItemsByAdmin(ctx context.Context, orderUUID string, items []entity.OrderItemInsert, actor string) (*entity.OrderAdjustment, error) {
+	var adjustment *entity.OrderAdjustment
+	err := ms.Tx(ctx, func(ctx context.Context, rep dependency.Repository) error {
+		order, err := getEditableOrder(ctx, rep, orderUUID)
+		if err != nil {
+			return err
+		}
+
+		// the shipment items refer to the order items which are replaced
+		shipped, err := shippedQuantities(ctx, rep, order.Id)
+		if err != nil {
+			return err
+		}
+		if len(shipped) > 0 {
+			return fmt.Errorf("order items can't be edited once some of them are shipped")
+		}
+
+		oldItems, err := getOrderItemsInsert(ctx, rep, order.Id)
+		if err != nil {
+			return fmt.Errorf("can't get order items: %w", err)
+		}
+		if err := rep.Products().RestoreStockForProductSizes(ctx, oldItems); err != nil {
+			return fmt.Errorf("can't restore stock for product sizes: %w", err)
+		}
+
+		oiv, err := rep.Order().ValidateOrderItemsInsert(ctx, items)
+		if err != nil {
+			return fmt.Errorf("can't validate order items: %w", err)
+		}
+		if oiv.HasChanged {
+			return fmt.Errorf("order items are out of stock or exceed the maximum quantity")
+		}
+		subtotal := keepOrderItemPrices(oiv.ValidItems, oldItems)
+
+		shipment, err := getOrderShipment(ctx, rep, order.Id)
+		if err != nil {
+			return fmt.Errorf("can't get order shipment: %w", err)
+		}
+
+		// the voucher is disabled once the order is paid, its discount still applies to the edited order
+		promos, err := promosByOrderIds(ctx, rep, []int{order.Id})
+		if err != nil {
+			return fmt.Errorf("can't get order promo: %w", err)
+		}
+		promo := promos[order.Id]
+
+		addresses, err := addressesByOrderIds(ctx, rep, []int{order.Id})
+		if err != nil {
+			return fmt.Errorf("can't get order addresses: %w", err)
+		}
+		tax, err := calculateOrderTax(ctx, rep, addresses[order.Id].shipping.Country, oiv.ValidItems, promo, shipment.CostDecimal())
+		if err != nil {
+			return fmt.Errorf("can't calculate tax: %w", err)
+		}
+
+		validItems := orderItemsWithTax(oiv.ValidItems, tax)
+		if err := rep.Products().ReduceStockForProductSizes(ctx, validItems); err != nil {
+			return fmt.Errorf("can't reduce stock for product sizes: %w", err)
+		}
+		if err := updateOrderItems(ctx, rep, validItems, order.Id); err != nil {
+			return fmt.Errorf("can't update order items: %w", err)
+		}
+		if err := updateOrderTax(ctx, rep, order.Id, oiv.ValidItems, tax); err != nil {
+			return err
+		}
+
+		total := promo.SubtotalWithPromo(subtotal, shipment.CostDecimal()).Add(tax.Surcharge()).Round(2)
+		adjustment, err = updateEditedOrderTotal(ctx, rep, order, promo, total, actor)
+		if err != nil {
+			return err
+		}
+		return correctOrderInvoice(ctx, rep, order.Id)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return adjustment, nil
+}
+
+// updateEditedOrderTotal sets the new total of the edited order and records the difference of the amount due
+// as the adjustment, nil is returned if the amount due is the same.
+func updateEditedOrderTotal(ctx context.Context, rep dependency.Repository, order *entity.Order, promo entity.PromoCode, total decimal.Decimal, actor string) (*entity.OrderAdjustment, error) {
+	dueBefore := order.AmountDue()
+	totalBefore := order.TotalPriceDecimal()
+	if err := updateOrderTotalPromo(ctx, rep, order.Id, promo.Id, total); err != nil {
+		return nil, fmt.Errorf("can't update order total: %w", err)
+	}
+	order.TotalPrice = total
+
+	// the gift card can't cover more than the new total
+	if err := returnGiftCardBalance(ctx, rep, order, order.GiftCardAmount.Sub(total)); err != nil {
+		return nil, fmt.Errorf("can't return gift card balance: %w", err)
+	}
+
+	amount := order.AmountDue().Sub(dueBefore)
+	if amount.IsZero() {
+		return nil, nil
+	}
+
+	return insertOrderAdjustment(ctx, rep, order.Id, &entity.OrderAdjustmentInsert{
+		Amount:      amount,
+		TotalBefore: totalBefore,
+		TotalAfter:  total,
+		Actor:       actor,
+	})
+}
+
+// keepOrderItemPrices sets the price and the sale of the items of the products already on the order
+// to the ones they were sold at and returns the subtotal of the items.
+func keepOrderItemPrices(items []entity.OrderItem, oldItems []entity.OrderItemInsert) decimal.Decimal {
+	sold := make(map[int]entity.OrderItemInsert, len(oldItems))
+	for _, oi := range oldItems {
+		sold[oi.ProductId] = oi
+	}
+
+	subtotal := decimal.Zero
+	for i := range items {
+		if oi, ok := sold[items[i].ProductId]; ok {
+			items[i].ProductPrice = oi.ProductPrice
+			items[i].ProductSalePercentage = oi.ProductSalePercentage
+			items[i].ProductPriceWithSale = oi.ProductPriceWithSale
+		}
+		subtotal = subtotal.Add(items[i].ProductPriceWithSale.Mul(items[i].Quantity))
+	}
+	return subtotal.Round(2)
+}
+
+func insertOrderAdjustment(ctx context.Context, rep dependency.Repository, orderId int, oai *entity.OrderAdjustmentInsert) (*entity.OrderAdjustment, error) {
+	id, err := ExecNamedLastId(ctx, rep.DB(), `
+	INSERT INTO order_adjustment (order_id, amount, total_before, total_after, actor)
+	VALUES (:orderId, :amount, :totalBefore, :totalAfter, :actor)`, map[string]any{
+		"orderId":     orderId,
+		"amount":      oai.Amount.Round(2),
+		"totalBefore": oai.TotalBefore.Round(2),
+		"totalAfter":  oai.TotalAfter.Round(2),
+		"actor":       oai.Actor,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't insert order adjustment: %w", err)
+	}
+
+	oa, err := QueryNamedOne[entity.OrderAdjustment](ctx, rep.DB(), `SELECT * FROM order_adjustment WHERE id = :id`, map[string]any{
+		"id": id,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't get order adjustment: %w", err)
+	}
+	return &oa, nil
+}
+
+func adjustmentsByOrderIds(ctx context.Context, rep dependency.Repository, orderIds []int) (map[int][]entity.OrderAdjustment, error) {
+	if len(orderIds) == 0 {
+		return map[int][]entity.OrderAdjustment{}, nil
+	}
+
+	query := `
+	SELECT * FROM order_adjustment
+	WHERE order_id IN (:orderIds)
+	ORDER BY id`
+
+	adjustments, err := QueryListNamed[entity.OrderAdjustment](ctx, rep.DB(), query, map[string]any{
+		"orderIds": orderIds,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't get order adjustments by order ids: %w", err)
+	}
+
+	am := make(map[int][]entity.OrderAdjustment)
+	for _, a := range adjustments {
+		am[a.OrderId] = append(am[a.OrderId], a)
+	}
+	return am, nil
+}
@@ -0,0 +1,219 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jekabolt/grbpwr-manager/internal/entity"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOrderEdit(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	p, xlSize, _, ok := addProductWithSizes(ctx, t, db, 2, 2)
+	if !ok {
+		return
+	}
+	no, _, err := newOrder([]entity.OrderItemInsert{
+		{ProductId: p.Product.Id, Quantity: decimal.NewFromInt(1), SizeId: xlSize.Id},
+	}, "", 1)
+	if !assert.NoError(t, err) {
+		return
+	}
+	order, _, err := db.Order().CreateOrder(ctx, no, false)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// placed order is not paid yet and can't be edited
+	err = db.UpdateOrderBuyer(ctx, order.UUID, &entity.BuyerInsert{FirstName: "Jane", LastName: "Doe", Email: "jane@example.com", Phone: "37120000001"})
+	var ne *entity.OrderNotEditableError
+	assert.True(t, errors.As(err, &ne))
+	assert.Equal(t, entity.Placed, ne.Status)
+
+	assert.NoError(t, updateOrderStatus(ctx, db, order, entity.Confirmed, entity.OrderStatusActorSystem, "payment confirmed"))
+
+	assert.NoError(t, db.UpdateOrderBuyer(ctx, order.UUID, &entity.BuyerInsert{FirstName: "Jane", LastName: "Doe", Email: "jane@example.com", Phone: "37120000001"}))
+
+	buyer, err := getBuyerById(ctx, db, order.Id)
+	assert.NoError(t, err)
+	sAdr := buyer.ShippingAddressID
+
+	adr := testAddress()
+	newAdr := *adr
+	newAdr.AddressLineOne = "Brivibas 2"
+	adjustment, err := db.UpdateOrderAddresses(ctx, order.UUID, &newAdr, adr, "admin")
+	assert.NoError(t, err)
+	// the order is shipped to the untaxed country as before
+	assert.Nil(t, adjustment)
+
+	buyer, err = getBuyerById(ctx, db, order.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, "Jane", buyer.FirstName)
+	assert.Equal(t, "jane@example.com", buyer.Email)
+	assert.NotEqual(t, sAdr, buyer.ShippingAddressID)
+
+	addresses, err := addressesByOrderIds(ctx, db, []int{order.Id})
+	assert.NoError(t, err)
+	assert.Equal(t, "Brivibas 2", addresses[order.Id].shipping.AddressLineOne)
+	assert.Equal(t, "Brivibas 1", addresses[order.Id].billing.AddressLineOne)
+
+	_, err = insertOrderAdjustment(ctx, db, order.Id, &entity.OrderAdjustmentInsert{
+		Amount:      decimal.NewFromInt(-20),
+		TotalBefore: decimal.NewFromInt(100),
+		TotalAfter:  decimal.NewFromInt(80),
+		Actor:       "admin",
+	})
+	assert.NoError(t, err)
+
+	am, err := adjustmentsByOrderIds(ctx, db, []int{order.Id})
+	assert.NoError(t, err)
+	if assert.Len(t, am[order.Id], 1) {
+		assert.False(t, am[order.Id][0].IsBalanceDue())
+		assert.True(t, am[order.Id][0].TotalAfter.Equal(decimal.NewFromInt(80)))
+	}
+}
+
+func TestUpdateOrderItemsByAdminKeepsPrices(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	p, xlSize, lSize, ok := addProductWithSizes(ctx, t, db, 4, 4)
+	if !ok {
+		return
+	}
+	order, ok := insertPaidOrder(ctx, t, db, []entity.OrderItemInsert{
+		{ProductId: p.Product.Id, Quantity: decimal.NewFromInt(1), SizeId: xlSize.Id},
+	}, "", 1)
+	if !ok {
+		return
+	}
+	soldItems, err := getOrderItemsInsert(ctx, db, order.Id)
+	if !assert.NoError(t, err) || !assert.Len(t, soldItems, 1) {
+		return
+	}
+	sold := soldItems[0]
+
+	// the price and the sale change after the order is paid
+	err = ExecNamed(ctx, db.DB(), `UPDATE product SET price = price * 2, sale_percentage = 50 WHERE id = :id`, map[string]any{
+		"id": p.Product.Id,
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	added, _, _, ok := addProductWithSizes(ctx, t, db, 4, 4)
+	if !ok {
+		return
+	}
+
+	adjustment, err := db.UpdateOrderItemsByAdmin(ctx, order.UUID, []entity.OrderItemInsert{
+		{ProductId: p.Product.Id, Quantity: decimal.NewFromInt(1), SizeId: xlSize.Id},
+		{ProductId: p.Product.Id, Quantity: decimal.NewFromInt(1), SizeId: lSize.Id},
+		{ProductId: added.Product.Id, Quantity: decimal.NewFromInt(1), SizeId: xlSize.Id},
+	}, "admin")
+	if !assert.NoError(t, err) || !assert.NotNil(t, adjustment) {
+		return
+	}
+
+	items, err := getOrderItemsInsert(ctx, db, order.Id)
+	if !assert.NoError(t, err) || !assert.Len(t, items, 3) {
+		return
+	}
+	want := sold.ProductPriceWithSale
+	for _, item := range items {
+		if item.ProductId == p.Product.Id {
+			// the product on the order keeps the price it was sold at
+			assert.True(t, item.ProductPrice.Equal(sold.ProductPrice), item.ProductPrice.String())
+			assert.True(t, item.ProductSalePercentage.Equal(sold.ProductSalePercentage), item.ProductSalePercentage.String())
+			continue
+		}
+		// the added product is priced at the current price
+		assert.True(t, item.ProductPrice.Equal(added.Product.PriceDecimal()), item.ProductPrice.String())
+		want = want.Add(item.ProductPriceWithSale)
+	}
+
+	// the added items are paid at the prices of the order items
+	want = want.Round(2)
+	assert.True(t, adjustment.Amount.Equal(want), "adjustment %s, want %s", adjustment.Amount.String(), want.String())
+	assert.True(t, adjustment.TotalAfter.Sub(adjustment.TotalBefore).Equal(want))
+}
+
+func TestUpdateOrderAddressesTax(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	p, xlSize, _, ok := addProductWithSizes(ctx, t, db, 4, 4)
+	if !ok {
+		return
+	}
+	// the test orders are shipped to the US which is not taxed
+	order, ok := insertPaidOrder(ctx, t, db, []entity.OrderItemInsert{
+		{ProductId: p.Product.Id, Quantity: decimal.NewFromInt(2), SizeId: xlSize.Id},
+	}, "", 1)
+	if !ok {
+		return
+	}
+	assert.True(t, order.TaxAmount.IsZero())
+
+	err := db.SetTaxRule(ctx, &entity.TaxRule{Country: "DE", DefaultRate: decimal.NewFromInt(20)})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	items, err := getOrderItemsInsert(ctx, db, order.Id)
+	if !assert.NoError(t, err) || !assert.Len(t, items, 1) {
+		return
+	}
+	shipment, err := getOrderShipment(ctx, db, order.Id)
+	if !assert.NoError(t, err) {
+		return
+	}
+	itemTax := items[0].ProductPriceWithSale.Mul(items[0].Quantity).Mul(decimal.NewFromInt(20)).Div(decimal.NewFromInt(100)).Round(2)
+	shippingTax := shipment.CostDecimal().Mul(decimal.NewFromInt(20)).Div(decimal.NewFromInt(100)).Round(2)
+	tax := itemTax.Add(shippingTax)
+
+	// the exclusive tax of the new country is added to the total and is due
+	adr := testAddress()
+	adr.Country = "DE"
+	adjustment, err := db.UpdateOrderAddresses(ctx, order.UUID, adr, adr, "admin")
+	if !assert.NoError(t, err) || !assert.NotNil(t, adjustment) {
+		return
+	}
+	assert.True(t, adjustment.Amount.Equal(tax), "adjustment %s, want %s", adjustment.Amount.String(), tax.String())
+	assert.True(t, adjustment.TotalBefore.Equal(order.TotalPriceDecimal()))
+	assert.True(t, adjustment.TotalAfter.Equal(order.TotalPriceDecimal().Add(tax)))
+	assert.Equal(t, "admin", adjustment.Actor)
+
+	taxed, err := getOrderByUUID(ctx, db, order.UUID)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.True(t, taxed.TaxAmount.Equal(tax), taxed.TaxAmount.String())
+	assert.False(t, taxed.TaxInclusive)
+	assert.True(t, taxed.TotalPriceDecimal().Equal(order.TotalPriceDecimal().Add(tax)))
+
+	items, err = getOrderItemsInsert(ctx, db, order.Id)
+	if !assert.NoError(t, err) || !assert.Len(t, items, 1) {
+		return
+	}
+	assert.True(t, items[0].TaxRate.Equal(decimal.NewFromInt(20)), items[0].TaxRate.String())
+	assert.True(t, items[0].TaxAmount.Equal(itemTax), items[0].TaxAmount.String())
+
+	// moving the order back to the untaxed country takes the tax off the total
+	adjustment, err = db.UpdateOrderAddresses(ctx, order.UUID, testAddress(), testAddress(), "admin")
+	if !assert.NoError(t, err) || !assert.NotNil(t, adjustment) {
+		return
+	}
+	assert.True(t, adjustment.Amount.Equal(tax.Neg()), adjustment.Amount.String())
+
+	untaxed, err := getOrderByUUID(ctx, db, order.UUID)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.True(t, untaxed.TaxAmount.IsZero())
+	assert.True(t, untaxed.TotalPriceDecimal().Equal(order.TotalPriceDecimal()))
+}
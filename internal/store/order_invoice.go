@@ -0,0 +1,337 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/jekabolt/grbpwr-manager/internal/dependency"
+	"github.com/jekabolt/grbpwr-manager/internal/dto"
+	"github.com/jekabolt/grbpwr-manager/internal/entity"
+)
+
+// invoiceAccessKeyLength is the number of random bytes in the invoice access key
+const invoiceAccessKeyLength = 16
+
+func generateInvoiceAccessKey() (string, error) {
+	b := make([]byte, invoiceAccessKeyLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("can't read random bytes: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// nextInvoiceNumber takes the next number of the sequence the invoices and their corrections are numbered from.
+// The sequence row stays locked until the transaction ends so the numbers of the rolled back transactions
+// are reused and no gaps appear.
+func nextInvoiceNumber(ctx context.Context, rep dependency.Repository) (int, error) {
+	err := ExecNamed(ctx, rep.DB(), `UPDATE invoice_sequence SET last_number = last_number + 1 WHERE id = 1`, map[string]any{})
+	if err != nil {
+		return 0, fmt.Errorf("can't increment invoice sequence: %w", err)
+	}
+
+	number, err := QueryCountNamed(ctx, rep.DB(), `SELECT last_number FROM invoice_sequence WHERE id = 1`, map[string]any{})
+	if err != nil {
+		return 0, fmt.Errorf("can't get invoice number: %w", err)
+	}
+	return number, nil
+}
+
+// invoiceContent is the content of the invoice of the order as the order is now.
+func invoiceContent(ctx context.Context, rep dependency.Repository, orderId int) (*entity.InvoiceContent, error) {
+	order, err := getOrderById(ctx, rep, orderId)
+	if err != nil {
+		return nil, fmt.Errorf("can't get order by id: %w", err)
+	}
+	ofs, err := fetchOrderInfo(ctx, rep, []entity.Order{*order})
+	if err != nil {
+		return nil, fmt.Errorf("can't fetch order info: %w", err)
+	}
+	ic := dto.OrderFullToInvoiceContent(&ofs[0])
+	return &ic, nil
+}
+
+// allocateOrderInvoice issues the invoice with the next number of the sequence to the order and stores
+// the content it states, the order already invoiced keeps its invoice.
+func allocateOrderInvoice(ctx context.Context, rep dependency.Repository, orderId int) error {
+	count, err := QueryCountNamed(ctx, rep.DB(), `SELECT COUNT(*) FROM order_invoice WHERE order_id = :orderId`, map[string]any{
+		"orderId": orderId,
+	})
+	if err != nil {
+		return fmt.Errorf("can't check order invoice: %w", err)
+	}
+	if count > 0 {
+		return nil
+	}
+
+	ic, err := invoiceContent(ctx, rep, orderId)
+	if err != nil {
+		return fmt.Errorf("can't get invoice content: %w", err)
+	}
+	content, err := json.Marshal(ic)
+	if err != nil {
+		return fmt.Errorf("can't marshal invoice content: %w", err)
+	}
+
+	number, err := nextInvoiceNumber(ctx, rep)
+	if err != nil {
+		return err
+	}
+
+	key, err := generateInvoiceAccessKey()
+	if err != nil {
+		return fmt.Errorf("can't generate invoice access key: %w", err)
+	}
+
+	err = ExecNamed(ctx, rep.DB(), `
+	INSERT INTO order_invoice (order_id, invoice_number, access_key, content)
+	VALUES (:orderId, :invoiceNumber, :accessKey, :content)`, map[string]any{
+		"orderId":       orderId,
+		"invoiceNumber": number,
+		"accessKey":     key,
+		"content":       content,
+	})
+	if err != nil {
+		return fmt.Errorf("can't insert order invoice: %w", err)
+	}
+	return nil
+}
+
+// snapshotOrderInvoice stores the content of the invoice issued before the content was stored with the invoices.
+// It's called before the invoiced order is edited so the invoice keeps stating the order as it was issued.
+func snapshotOrderInvoice(ctx context.Context, rep dependency.Repository, orderId int) error {
+	count, err := QueryCountNamed(ctx, rep.DB(), `SELECT COUNT(*) FROM order_invoice WHERE order_id = :orderId AND content IS NULL`, map[string]any{
+		"orderId": orderId,
+	})
+	if err != nil {
+		return fmt.Errorf("can't check order invoice content: %w", err)
+	}
+	if count == 0 {
+		return nil
+	}
+
+	ic, err := invoiceContent(ctx, rep, orderId)
+	if err != nil {
+		return fmt.Errorf("can't get invoice content: %w", err)
+	}
+	content, err := json.Marshal(ic)
+	if err != nil {
+		return fmt.Errorf("can't marshal invoice content: %w", err)
+	}
+
+	err = ExecNamed(ctx, rep.DB(), `UPDATE order_invoice SET content = :content WHERE order_id = :orderId`, map[string]any{
+		"orderId": orderId,
+		"content": content,
+	})
+	if err != nil {
+		return fmt.Errorf("can't update order invoice content: %w", err)
+	}
+	return nil
+}
+
+// lastInvoiceDocument is the content of the last document issued for the order, the last correction or the invoice
+type lastInvoiceDocument struct {
+	OrderInvoiceId int    `db:"order_invoice_id"`
+	Content        []byte `db:"content"`
+}
+
+// correctOrderInvoice issues the correction of the invoice after the invoiced order is edited, the issued documents
+// are never changed. The correction is numbered from the same sequence as the invoices and states the order as it is
+// after the edit, no correction is issued if the edit doesn't change what the last document states.
+func correctOrderInvoice(ctx context.Context, rep dependency.Repository, orderId int) error {
+	last, err := QueryNamedOne[lastInvoiceDocument](ctx, rep.DB(), `
+	SELECT oi.id AS order_invoice_id,
+		COALESCE((
+			SELECT c.content FROM order_invoice_correction c
+			WHERE c.order_invoice_id = oi.id
+			ORDER BY c.invoice_number DESC
+			LIMIT 1
+		), oi.content) AS content
+	FROM order_invoice oi
+	WHERE oi.order_id = :orderId`, map[string]any{
+		"orderId": orderId,
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			// the order is not invoiced, there is nothing to correct
+			return nil
+		}
+		return fmt.Errorf("can't get last invoice document: %w", err)
+	}
+
+	ic, err := invoiceContent(ctx, rep, orderId)
+	if err != nil {
+		return fmt.Errorf("can't get invoice content: %w", err)
+	}
+	content, err := json.Marshal(ic)
+	if err != nil {
+		return fmt.Errorf("can't marshal invoice content: %w", err)
+	}
+
+	same, err := sameInvoiceContent(last.Content, content)
+	if err != nil {
+		return err
+	}
+	if same {
+		return nil
+	}
+
+	number, err := nextInvoiceNumber(ctx, rep)
+	if err != nil {
+		return err
+	}
+
+	err = ExecNamed(ctx, rep.DB(), `
+	INSERT INTO order_invoice_correction (order_invoice_id, invoice_number, content)
+	VALUES (:orderInvoiceId, :invoiceNumber, :content)`, map[string]any{
+		"orderInvoiceId": last.OrderInvoiceId,
+		"invoiceNumber":  number,
+		"content":        content,
+	})
+	if err != nil {
+		return fmt.Errorf("can't insert order invoice correction: %w", err)
+	}
+	return nil
+}
+
+// sameInvoiceContent reports whether the documents state the same, the stored JSON is normalized by the database
+// so both are decoded and encoded again before they are compared.
+func sameInvoiceContent(a, b []byte) (bool, error) {
+	normalize := func(b []byte) ([]byte, error) {
+		var ic entity.InvoiceContent
+		if err := json.Unmarshal(b, &ic); err != nil {
+			return nil, fmt.Errorf("can't unmarshal invoice content: %w", err)
+		}
+		return json.Marshal(ic)
+	}
+	na, err := normalize(a)
+	if err != nil {
+		return false, err
+	}
+	nb, err := normalize(b)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(na, nb), nil
+}
+
+// invoiceDocumentRow is the invoice or the correction of the order, the content of the invoices
+// issued before the content was stored with them is null
+type invoiceDocumentRow struct {
+	InvoiceNumber int       `db:"invoice_number"`
+	IssuedAt      time.Time `db:"issued_at"`
+	Content       []byte    `db:"content"`
+}
+
+// GetOrderInvoiceDocument returns the invoice of the order or its correction by the number as it was issued,
+// the invoice if the number is zero. The correction comes with the document it supersedes.
+// The invoice issued before the content was stored with the invoices and not edited since states the order as it is.
+func (ms *MYSQLStore) GetOrderInvoiceDocument(ctx context.Context, orderUUID string, number int) (*entity.InvoiceDocument, error) {
+	rows, err := QueryListNamed[invoiceDocumentRow](ctx, ms.DB(), `
+	SELECT oi.invoice_number, oi.issued_at, oi.content
+	FROM order_invoice oi
+	JOIN customer_order co ON oi.order_id = co.id
+	WHERE co.uuid = :orderUUID
+	UNION ALL
+	SELECT c.invoice_number, c.issued_at, c.content
+	FROM order_invoice_correction c
+	JOIN order_invoice oi ON c.order_invoice_id = oi.id
+	JOIN customer_order co ON oi.order_id = co.id
+	WHERE co.uuid = :orderUUID
+	ORDER BY invoice_number`, map[string]any{
+		"orderUUID": orderUUID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't get order invoice documents: %w", err)
+	}
+
+	i := 0
+	if number != 0 {
+		i = slices.IndexFunc(rows, func(r invoiceDocumentRow) bool { return r.InvoiceNumber == number })
+	}
+	if i < 0 || i >= len(rows) {
+		return nil, fmt.Errorf("can't find order invoice document %d: %w", number, sql.ErrNoRows)
+	}
+
+	document := func(r invoiceDocumentRow) (*entity.InvoiceDocument, error) {
+		doc := &entity.InvoiceDocument{
+			Number:   r.InvoiceNumber,
+			IssuedAt: r.IssuedAt,
+		}
+		if r.Content == nil {
+			of, err := ms.GetOrderFullByUUID(ctx, orderUUID)
+			if err != nil {
+				return nil, fmt.Errorf("can't get order by uuid: %w", err)
+			}
+			doc.Content = dto.OrderFullToInvoiceContent(of)
+			return doc, nil
+		}
+		if err := json.Unmarshal(r.Content, &doc.Content); err != nil {
+			return nil, fmt.Errorf("can't unmarshal invoice content: %w", err)
+		}
+		return doc, nil
+	}
+
+	doc, err := document(rows[i])
+	if err != nil {
+		return nil, err
+	}
+	if i > 0 {
+		doc.Corrected, err = document(rows[i-1])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return doc, nil
+}
+
+func invoicesByOrderIds(ctx context.Context, rep dependency.Repository, orderIds []int) (map[int]*entity.OrderInvoice, error) {
+	if len(orderIds) == 0 {
+		return map[int]*entity.OrderInvoice{}, nil
+	}
+
+	query := `
+	SELECT id, order_id, invoice_number, access_key, issued_at FROM order_invoice
+	WHERE order_id IN (:orderIds)`
+
+	invoices, err := QueryListNamed[entity.OrderInvoice](ctx, rep.DB(), query, map[string]any{
+		"orderIds": orderIds,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't get order invoices by order ids: %w", err)
+	}
+	if len(invoices) == 0 {
+		return map[int]*entity.OrderInvoice{}, nil
+	}
+
+	im := make(map[int]*entity.OrderInvoice, len(invoices))
+	byId := make(map[int]*entity.OrderInvoice, len(invoices))
+	ids := make([]int, 0, len(invoices))
+	for i := range invoices {
+		im[invoices[i].OrderId] = &invoices[i]
+		byId[invoices[i].Id] = &invoices[i]
+		ids = append(ids, invoices[i].Id)
+	}
+
+	corrections, err := QueryListNamed[entity.OrderInvoiceCorrection](ctx, rep.DB(), `
+	SELECT id, order_invoice_id, invoice_number, issued_at FROM order_invoice_correction
+	WHERE order_invoice_id IN (:ids)
+	ORDER BY invoice_number`, map[string]any{
+		"ids": ids,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't get order invoice corrections: %w", err)
+	}
+	for _, c := range corrections {
+		inv := byId[c.OrderInvoiceId]
+		inv.Corrections = append(inv.Corrections, c)
+	}
+	return im, nil
+}
@@ -0,0 +1,157 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"testing"
+
+	"github.com/jekabolt/grbpwr-manager/internal/cache"
+	"github.com/jekabolt/grbpwr-manager/internal/dependency"
+	"github.com/jekabolt/grbpwr-manager/internal/entity"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOrderInvoiceNumbers(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	insertUnpaidOrder := func() *entity.Order {
+		order := insertTestOrder(ctx, t, db, decimal.NewFromInt(100), cache.OrderStatusAwaitingPayment)
+		return order
+	}
+
+	confirm := func(order *entity.Order, fail bool) error {
+		return db.Tx(ctx, func(ctx context.Context, rep dependency.Repository) error {
+			if err := updateOrderStatus(ctx, rep, order, entity.Confirmed, entity.OrderStatusActorSystem, "payment confirmed"); err != nil {
+				return err
+			}
+			if fail {
+				return fmt.Errorf("payment rejected")
+			}
+			return nil
+		})
+	}
+
+	invoice := func(order *entity.Order) *entity.OrderInvoice {
+		of, err := db.GetOrderFullByUUID(ctx, order.UUID)
+		if !assert.NoError(t, err) {
+			return nil
+		}
+		return of.Invoice
+	}
+
+	first := insertUnpaidOrder()
+	rolledBack := insertUnpaidOrder()
+	next := insertUnpaidOrder()
+
+	// the order is not invoiced until it is confirmed
+	assert.Nil(t, invoice(first))
+
+	assert.NoError(t, confirm(first, false))
+	firstInvoice := invoice(first)
+	if !assert.NotNil(t, firstInvoice) {
+		return
+	}
+	assert.Len(t, firstInvoice.AccessKey, 32)
+
+	// the number of the rolled back confirmation is reused
+	assert.Error(t, confirm(rolledBack, true))
+	assert.Nil(t, invoice(rolledBack))
+
+	assert.NoError(t, confirm(next, false))
+	nextInvoice := invoice(next)
+	if !assert.NotNil(t, nextInvoice) {
+		return
+	}
+	assert.Equal(t, firstInvoice.InvoiceNumber+1, nextInvoice.InvoiceNumber)
+	assert.NotEqual(t, firstInvoice.AccessKey, nextInvoice.AccessKey)
+
+	// the invoiced order keeps its number
+	assert.NoError(t, allocateOrderInvoice(ctx, db, first.Id))
+	assert.Equal(t, firstInvoice.InvoiceNumber, invoice(first).InvoiceNumber)
+}
+
+func TestOrderInvoiceCorrection(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	p, xlSize, _, ok := addProductWithSizes(ctx, t, db, 2, 2)
+	if !ok {
+		return
+	}
+	no, _, err := newOrder([]entity.OrderItemInsert{
+		{ProductId: p.Product.Id, Quantity: decimal.NewFromInt(1), SizeId: xlSize.Id},
+	}, "", 1)
+	if !assert.NoError(t, err) {
+		return
+	}
+	order, _, err := db.Order().CreateOrder(ctx, no, false)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, updateOrderStatus(ctx, db, order, entity.Confirmed, entity.OrderStatusActorSystem, "payment confirmed"))
+
+	invoice, err := db.GetOrderInvoiceDocument(ctx, order.UUID, 0)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Nil(t, invoice.Corrected)
+	assert.Contains(t, invoice.Content.Billing, "Brivibas 1")
+
+	corrections := func() []entity.OrderInvoiceCorrection {
+		of, err := db.GetOrderFullByUUID(ctx, order.UUID)
+		if !assert.NoError(t, err) || !assert.NotNil(t, of.Invoice) {
+			return nil
+		}
+		return of.Invoice.Corrections
+	}
+
+	// the phone is not on the invoice, there is nothing to correct
+	buyer, err := getBuyerById(ctx, db, order.Id)
+	if !assert.NoError(t, err) {
+		return
+	}
+	bi := buyer.BuyerInsert
+	bi.Phone = "37120000001"
+	assert.NoError(t, db.UpdateOrderBuyer(ctx, order.UUID, &bi))
+	assert.Empty(t, corrections())
+
+	// the invoice issued before the content was stored keeps the order as it was before the edit
+	assert.NoError(t, ExecNamed(ctx, db.DB(), `UPDATE order_invoice SET content = NULL WHERE order_id = :orderId`, map[string]any{
+		"orderId": order.Id,
+	}))
+
+	adr := testAddress()
+	billing := *adr
+	billing.AddressLineOne = "Brivibas 2"
+	_, err = db.UpdateOrderAddresses(ctx, order.UUID, adr, &billing, "admin")
+	assert.NoError(t, err)
+
+	cs := corrections()
+	if !assert.Len(t, cs, 1) {
+		return
+	}
+	assert.Greater(t, cs[0].InvoiceNumber, invoice.Number)
+
+	issued, err := db.GetOrderInvoiceDocument(ctx, order.UUID, 0)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, invoice.Number, issued.Number)
+	assert.Contains(t, issued.Content.Billing, "Brivibas 1")
+	assert.NotContains(t, issued.Content.Billing, "Brivibas 2")
+
+	correction, err := db.GetOrderInvoiceDocument(ctx, order.UUID, cs[0].InvoiceNumber)
+	if !assert.NoError(t, err) || !assert.NotNil(t, correction.Corrected) {
+		return
+	}
+	assert.Contains(t, correction.Content.Billing, "Brivibas 2")
+	assert.Equal(t, invoice.Number, correction.Corrected.Number)
+	assert.Contains(t, correction.Corrected.Content.Billing, "Brivibas 1")
+
+	// the number which is not a document of the order is not found
+	_, err = db.GetOrderInvoiceDocument(ctx, order.UUID, cs[0].InvoiceNumber+1)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+}
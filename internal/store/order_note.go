@@ -0,0 +1,102 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jekabolt/grbpwr-manager/internal/dependency"
+	"github.com/jekabolt/grbpwr-manager/internal/entity"
+)
+
+// AddOrderNote attaches the internal note to the order.
+func (ms *MYSQLStore) AddOrderNote(ctx context.Context, orderUUID string, oni *entity.OrderNoteInsert) (*entity.OrderNote, error) {
+	oni.Text = strings.TrimSpace(oni.Text)
+	if oni.Text == "" {
+		return nil, fmt.Errorf("order note is empty")
+	}
+
+	order, err := getOrderByUUID(ctx, ms, orderUUID)
+	if err != nil {
+		return nil, fmt.Errorf("can't get order by uuid: %w", err)
+	}
+
+	id, err := ExecNamedLastId(ctx, ms.DB(), `
+	INSERT INTO order_note (order_id, author, text)
+	VALUES (:orderId, :author, :text)`, map[string]any{
+		"orderId": order.Id,
+		"author":  oni.Author,
+		"text":    oni.Text,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't insert order note: %w", err)
+	}
+
+	note, err := QueryNamedOne[entity.OrderNote](ctx, ms.DB(), `SELECT * FROM order_note WHERE id = :id`, map[string]any{
+		"id": id,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't get order note: %w", err)
+	}
+	return &note, nil
+}
+
+// GetOrderNotes returns the internal notes of the order from the oldest one.
+func (ms *MYSQLStore) GetOrderNotes(ctx context.Context, orderUUID string) ([]entity.OrderNote, error) {
+	query := `
+	SELECT n.*
+	FROM order_note n
+	JOIN customer_order co ON n.order_id = co.id
+	WHERE co.uuid = :orderUUID
+	ORDER BY n.id`
+
+	notes, err := QueryListNamed[entity.OrderNote](ctx, ms.DB(), query, map[string]any{
+		"orderUUID": orderUUID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't get order notes: %w", err)
+	}
+	return notes, nil
+}
+
+// DeleteOrderNote deletes the note of the order, the notes of the other orders are left as is.
+func (ms *MYSQLStore) DeleteOrderNote(ctx context.Context, orderUUID string, noteId int) error {
+	query := `
+	DELETE n
+	FROM order_note n
+	JOIN customer_order co ON n.order_id = co.id
+	WHERE n.id = :noteId AND co.uuid = :orderUUID`
+
+	err := ExecNamed(ctx, ms.DB(), query, map[string]any{
+		"noteId":    noteId,
+		"orderUUID": orderUUID,
+	})
+	if err != nil {
+		return fmt.Errorf("can't delete order note: %w", err)
+	}
+	return nil
+}
+
+func notesByOrderIds(ctx context.Context, rep dependency.Repository, orderIds []int) (map[int][]entity.OrderNote, error) {
+	if len(orderIds) == 0 {
+		return map[int][]entity.OrderNote{}, nil
+	}
+
+	query := `
+	SELECT * FROM order_note
+	WHERE order_id IN (:orderIds)
+	ORDER BY id`
+
+	notes, err := QueryListNamed[entity.OrderNote](ctx, rep.DB(), query, map[string]any{
+		"orderIds": orderIds,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't get order notes by order ids: %w", err)
+	}
+
+	nm := make(map[int][]entity.OrderNote)
+	for _, n := range notes {
+		nm[n.OrderId] = append(nm[n.OrderId], n)
+	}
+	return nm, nil
+}
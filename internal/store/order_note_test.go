@@ -0,0 +1,44 @@
+package store
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jekabolt/grbpwr-manager/internal/cache"
+	"github.com/jekabolt/grbpwr-manager/internal/entity"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOrderNote(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	order := insertTestOrder(ctx, t, db, decimal.NewFromInt(100), cache.OrderStatusPlaced)
+
+	_, err := db.AddOrderNote(ctx, order.UUID, &entity.OrderNoteInsert{Author: "admin", Text: " "})
+	assert.Error(t, err)
+
+	first, err := db.AddOrderNote(ctx, order.UUID, &entity.OrderNoteInsert{Author: "admin", Text: "buyer asked to change the address"})
+	assert.NoError(t, err)
+	assert.Equal(t, order.Id, first.OrderId)
+
+	_, err = db.AddOrderNote(ctx, order.UUID, &entity.OrderNoteInsert{Author: "support", Text: "address changed"})
+	assert.NoError(t, err)
+
+	notes, err := db.GetOrderNotes(ctx, order.UUID)
+	assert.NoError(t, err)
+	if assert.Len(t, notes, 2) {
+		assert.Equal(t, "support", notes[1].Author)
+	}
+
+	// the note is deleted only with the order it belongs to
+	assert.NoError(t, db.DeleteOrderNote(ctx, "other", first.Id))
+	assert.NoError(t, db.DeleteOrderNote(ctx, order.UUID, first.Id))
+
+	nm, err := notesByOrderIds(ctx, db, []int{order.Id})
+	assert.NoError(t, err)
+	if assert.Len(t, nm[order.Id], 1) {
+		assert.Equal(t, "address changed", nm[order.Id][0].Text)
+	}
+}
@@ -0,0 +1,117 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/jekabolt/grbpwr-manager/internal/cache"
+	"github.com/jekabolt/grbpwr-manager/internal/dependency"
+	"github.com/jekabolt/grbpwr-manager/internal/entity"
+)
+
+func getOrderStatusName(order *entity.Order) (entity.OrderStatusName, error) {
+	os, ok := cache.GetOrderStatusById(order.OrderStatusId)
+	if !ok {
+		return "", fmt.Errorf("order status is not exists: order status id %d", order.OrderStatusId)
+	}
+	return os.Status.Name, nil
+}
+
+// validateOrderStatusTransition returns entity.OrderStatusTransitionError if the order can't move to the given status.
+func validateOrderStatusTransition(order *entity.Order, to entity.OrderStatusName) error {
+	from, err := getOrderStatusName(order)
+	if err != nil {
+		return err
+	}
+	return from.ValidateTransition(to)
+}
+
+func insertOrderStatusHistory(ctx context.Context, rep dependency.Repository, osh *entity.OrderStatusHistory) error {
+	err := ExecNamed(ctx, rep.DB(), `
+	INSERT INTO order_status_history (order_id, from_status_id, to_status_id, actor, reason)
+	VALUES (:orderId, :fromStatusId, :toStatusId, :actor, :reason)`, map[string]any{
+		"orderId":      osh.OrderId,
+		"fromStatusId": osh.FromStatusId,
+		"toStatusId":   osh.ToStatusId,
+		"actor":        osh.Actor,
+		"reason":       osh.Reason,
+	})
+	if err != nil {
+		return fmt.Errorf("can't insert order status history: %w", err)
+	}
+	return nil
+}
+
+// updateOrderStatus moves the order to the given status if the transition table allows it
+// and records the change in the status history, the order keeping its status is left as is.
+// The confirmed order is issued the invoice with the next invoice number.
+func updateOrderStatus(ctx context.Context, rep dependency.Repository, order *entity.Order, to entity.OrderStatusName, actor, reason string) error {
+	from, err := getOrderStatusName(order)
+	if err != nil {
+		return err
+	}
+	if from == to {
+		return nil
+	}
+	if err := from.ValidateTransition(to); err != nil {
+		return err
+	}
+
+	toStatus, ok := cache.GetOrderStatusByName(to)
+	if !ok {
+		return fmt.Errorf("can't get order status by name %s", to)
+	}
+
+	err = ExecNamed(ctx, rep.DB(), `UPDATE customer_order SET order_status_id = :orderStatusId WHERE id = :orderId`, map[string]any{
+		"orderId":       order.Id,
+		"orderStatusId": toStatus.Status.Id,
+	})
+	if err != nil {
+		return fmt.Errorf("can't update order status: %w", err)
+	}
+
+	err = insertOrderStatusHistory(ctx, rep, &entity.OrderStatusHistory{
+		OrderId:      order.Id,
+		FromStatusId: sql.NullInt32{Int32: int32(order.OrderStatusId), Valid: true},
+		ToStatusId:   toStatus.Status.Id,
+		Actor:        actor,
+		Reason:       sql.NullString{String: reason, Valid: reason != ""},
+	})
+	if err != nil {
+		return err
+	}
+
+	if to == entity.Confirmed {
+		if err := allocateOrderInvoice(ctx, rep, order.Id); err != nil {
+			return fmt.Errorf("can't allocate order invoice: %w", err)
+		}
+	}
+
+	order.OrderStatusId = toStatus.Status.Id
+	return nil
+}
+
+func statusHistoryByOrderIds(ctx context.Context, rep dependency.Repository, orderIds []int) (map[int][]entity.OrderStatusHistory, error) {
+	if len(orderIds) == 0 {
+		return map[int][]entity.OrderStatusHistory{}, nil
+	}
+
+	query := `
+	SELECT * FROM order_status_history
+	WHERE order_id IN (:orderIds)
+	ORDER BY id`
+
+	history, err := QueryListNamed[entity.OrderStatusHistory](ctx, rep.DB(), query, map[string]any{
+		"orderIds": orderIds,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't get order status history by order ids: %w", err)
+	}
+
+	hm := make(map[int][]entity.OrderStatusHistory)
+	for _, h := range history {
+		hm[h.OrderId] = append(hm[h.OrderId], h)
+	}
+	return hm, nil
+}
@@ -0,0 +1,47 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jekabolt/grbpwr-manager/internal/cache"
+	"github.com/jekabolt/grbpwr-manager/internal/entity"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOrderStatusHistory(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	order := insertTestOrder(ctx, t, db, decimal.NewFromInt(100), cache.OrderStatusPlaced)
+
+	// placed order can't be shipped
+	err := updateOrderStatus(ctx, db, order, entity.Shipped, "admin", "")
+	var te *entity.OrderStatusTransitionError
+	assert.True(t, errors.As(err, &te))
+	assert.Equal(t, entity.Placed, te.From)
+
+	assert.NoError(t, updateOrderStatus(ctx, db, order, entity.AwaitingPayment, entity.OrderStatusActorSystem, "invoice issued"))
+	assert.NoError(t, updateOrderStatus(ctx, db, order, entity.Confirmed, entity.OrderStatusActorSystem, "payment confirmed"))
+
+	// confirmed order can't be cancelled
+	err = cancelOrder(ctx, db, order, nil, "admin", "")
+	assert.True(t, errors.As(err, &te))
+
+	assert.NoError(t, db.DeliveredOrder(ctx, order.UUID, "admin"))
+
+	history, err := statusHistoryByOrderIds(ctx, db, []int{order.Id})
+	assert.NoError(t, err)
+	if assert.Len(t, history[order.Id], 4) {
+		placed := history[order.Id][0]
+		assert.False(t, placed.FromStatusId.Valid)
+		assert.Equal(t, cache.OrderStatusPlaced.Status.Id, placed.ToStatusId)
+
+		delivered := history[order.Id][3]
+		assert.Equal(t, cache.OrderStatusConfirmed.Status.Id, int(delivered.FromStatusId.Int32))
+		assert.Equal(t, cache.OrderStatusDelivered.Status.Id, delivered.ToStatusId)
+		assert.Equal(t, "admin", delivered.Actor)
+	}
+}
@@ -3,47 +3,48 @@ package store
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"math/rand"
 	"testing"
 	"time"
 
+	"github.com/jekabolt/grbpwr-manager/internal/cache"
+	"github.com/jekabolt/grbpwr-manager/internal/dependency"
 	"github.com/jekabolt/grbpwr-manager/internal/entity"
 	"github.com/shopspring/decimal"
 	"github.com/stretchr/testify/assert"
 )
 
-func getRandomPaymentMethod(db *MYSQLStore) (*entity.PaymentMethod, error) {
-	pms := db.cache.GetDict().PaymentMethods
-	if len(pms) == 0 {
-		return nil, fmt.Errorf("no payment methods found")
+func getCryptoPaymentMethod() (*entity.PaymentMethod, error) {
+	for _, pm := range cache.GetPaymentMethods() {
+		switch pm.Name {
+		case entity.USDT_TRON, entity.USDT_TRON_TEST:
+			if pm.Allowed {
+				return &pm, nil
+			}
+		}
 	}
-
-	index := rand.Intn(len(pms))
-	pm := pms[index]
-
-	// Safe return by copying the struct before taking its address
-	copiedPM := pm
-	return &copiedPM, nil
+	return nil, fmt.Errorf("no allowed crypto payment method found")
 }
 
-func getRandomShipmentCarrier(db *MYSQLStore) (*entity.ShipmentCarrier, error) {
-	scs := db.cache.GetDict().ShipmentCarriers
+func getRandomShipmentCarrier() (*entity.ShipmentCarrier, error) {
+	scs := make([]entity.ShipmentCarrier, 0)
+	for _, sc := range cache.GetShipmentCarriers() {
+		if sc.Allowed {
+			scs = append(scs, sc)
+		}
+	}
 	if len(scs) == 0 {
 		return nil, fmt.Errorf("no shipment carriers found")
 	}
 
-	index := rand.Intn(len(scs))
-	sc := scs[index]
-
-	// Safe return by copying the struct before taking its address
-	copiedSC := sc
-	return &copiedSC, nil
+	sc := scs[rand.Intn(len(scs))]
+	return &sc, nil
 }
 
 func getShipmentCarrierPaid(db *MYSQLStore) (*entity.ShipmentCarrier, error) {
-	scs := db.cache.GetDict().ShipmentCarriers
-	for _, sc := range scs {
+	for _, sc := range cache.GetShipmentCarriers() {
 		if sc.Allowed && !sc.Price.Equal(decimal.NewFromInt(0)) {
 			return &sc, nil
 		}
@@ -51,15 +52,14 @@ func getShipmentCarrierPaid(db *MYSQLStore) (*entity.ShipmentCarrier, error) {
 	return nil, fmt.Errorf("no paid shipment carrier found")
 }
 
-func newOrder(ctx context.Context, db *MYSQLStore, items []entity.OrderItemInsert, promoCode string, i int) (*entity.OrderNew, *entity.ShipmentCarrier, error) {
+func newOrder(items []entity.OrderItemInsert, promoCode string, i int) (*entity.OrderNew, *entity.ShipmentCarrier, error) {
 	addr := &entity.AddressInsert{
-		Street:          "123 Billing St",
-		HouseNumber:     "Apt 4B",
-		City:            "New York",
-		State:           "NY",
-		Country:         "USA",
-		PostalCode:      "10001",
-		ApartmentNumber: "",
+		AddressLineOne: "123 Billing St",
+		AddressLineTwo: sql.NullString{String: "Apt 4B", Valid: true},
+		City:           "New York",
+		State:          sql.NullString{String: "NY", Valid: true},
+		Country:        "US",
+		PostalCode:     "10001",
 	}
 
 	buyer := &entity.BuyerInsert{
@@ -67,15 +67,15 @@ func newOrder(ctx context.Context, db *MYSQLStore, items []entity.OrderItemInser
 		LastName:           "Doe",
 		Email:              fmt.Sprintf("%d_test@test.com", i),
 		Phone:              "1234567890",
-		ReceivePromoEmails: true,
+		ReceivePromoEmails: sql.NullBool{Bool: true, Valid: true},
 	}
 
-	pm, err := getRandomPaymentMethod(db)
+	pm, err := getCryptoPaymentMethod()
 	if err != nil {
 		return nil, nil, err
 	}
 
-	sc, err := getRandomShipmentCarrier(db)
+	sc, err := getRandomShipmentCarrier()
 	if err != nil {
 		return nil, nil, err
 	}
@@ -85,49 +85,130 @@ func newOrder(ctx context.Context, db *MYSQLStore, items []entity.OrderItemInser
 		ShippingAddress:   addr,
 		BillingAddress:    addr,
 		Buyer:             buyer,
-		PaymentMethodId:   pm.ID,
-		ShipmentCarrierId: sc.ID,
+		PaymentMethod:     pm.Name,
+		ShipmentCarrierId: sc.Id,
 		PromoCode:         promoCode,
 	}, sc, nil
 
 }
 
-func TestCreateOrder(t *testing.T) {
-	// Initialize the product store
-	db := newTestDB(t)
-	ps := db.Products()
-	ctx := context.Background()
-
+// addProductWithSizes adds the product with the stock of the XL and L sizes.
+func addProductWithSizes(ctx context.Context, t *testing.T, db *MYSQLStore, xlQuantity, lQuantity int64) (*entity.ProductFull, *entity.Size, *entity.Size, bool) {
 	np, err := randomProductInsert(db, 1)
-	assert.NoError(t, err)
+	if !assert.NoError(t, err) {
+		return nil, nil, nil, false
+	}
 
-	xlSize, ok := db.cache.GetSizesByName(entity.XL)
-	assert.True(t, ok)
+	xlSize, ok := getSizeByName(entity.XL)
+	if !assert.True(t, ok) {
+		return nil, nil, nil, false
+	}
 
-	lSize, ok := db.cache.GetSizesByName(entity.L)
-	assert.True(t, ok)
+	lSize, ok := getSizeByName(entity.L)
+	if !assert.True(t, ok) {
+		return nil, nil, nil, false
+	}
 
 	np.SizeMeasurements = []entity.SizeWithMeasurementInsert{
 		{
 			ProductSize: entity.ProductSizeInsert{
-				Quantity: decimal.NewFromInt(10),
-				SizeID:   xlSize.ID,
+				Quantity: decimal.NewFromInt(xlQuantity),
+				SizeId:   xlSize.Id,
 			},
 		},
 		{
 			ProductSize: entity.ProductSizeInsert{
-				Quantity: decimal.NewFromInt(15),
-				SizeID:   lSize.ID,
+				Quantity: decimal.NewFromInt(lQuantity),
+				SizeId:   lSize.Id,
 			},
 		},
 	}
 
 	// Insert new product
-	prd, err := ps.AddProduct(ctx, np)
-	assert.NoError(t, err)
+	prdId, err := db.Products().AddProduct(ctx, np)
+	if !assert.NoError(t, err) {
+		return nil, nil, nil, false
+	}
+
+	p, err := db.Products().GetProductByIdShowHidden(ctx, prdId)
+	if !assert.NoError(t, err) {
+		return nil, nil, nil, false
+	}
+	return p, xlSize, lSize, true
+}
+
+// insertPaidOrder creates the order of the items with the promo code and pays its crypto invoice in full.
+func insertPaidOrder(ctx context.Context, t *testing.T, db *MYSQLStore, items []entity.OrderItemInsert, promoCode string, i int) (*entity.Order, bool) {
+	no, _, err := newOrder(items, promoCode, i)
+	if !assert.NoError(t, err) {
+		return nil, false
+	}
+	order, _, err := db.Order().CreateOrder(ctx, no, false)
+	if !assert.NoError(t, err) {
+		return nil, false
+	}
+
+	pm, err := getCryptoPaymentMethod()
+	if !assert.NoError(t, err) {
+		return nil, false
+	}
+	_, err = db.Order().InsertCryptoInvoice(ctx, order.UUID, fmt.Sprintf("payee-%d", order.Id), *pm)
+	if !assert.NoError(t, err) {
+		return nil, false
+	}
+	_, err = db.Order().OrderPaymentDone(ctx, order.UUID, &entity.Payment{
+		PaymentInsert: entity.PaymentInsert{
+			PaymentMethodID:   pm.Id,
+			TransactionID:     sql.NullString{String: fmt.Sprintf("tx-%d", order.Id), Valid: true},
+			TransactionAmount: order.TotalPrice,
+			Payer:             sql.NullString{String: "payer", Valid: true},
+			Payee:             sql.NullString{String: fmt.Sprintf("payee-%d", order.Id), Valid: true},
+		},
+	})
+	if !assert.NoError(t, err) {
+		return nil, false
+	}
+	return order, true
+}
 
-	p, err := ps.GetProductByIdShowHidden(ctx, prd.Product.ID)
+// insertCryptoInvoice issues the crypto invoice within a transaction the way the payment processors do,
+// the changes made to the order instead of the invoice are committed.
+func insertCryptoInvoice(ctx context.Context, db *MYSQLStore, orderUUID string, payee string, pm entity.PaymentMethod) (*entity.OrderFull, error) {
+	var of *entity.OrderFull
+	var invoiceErr error
+	err := db.Tx(ctx, func(ctx context.Context, rep dependency.Repository) error {
+		of, invoiceErr = rep.Order().InsertCryptoInvoice(ctx, orderUUID, payee, pm)
+		if errors.Is(invoiceErr, entity.ErrOrderNotInvoiced) {
+			return nil
+		}
+		return invoiceErr
+	})
+	if err != nil {
+		return nil, err
+	}
+	return of, invoiceErr
+}
+
+// sizeQuantity returns the stock of the product size.
+func sizeQuantity(ctx context.Context, t *testing.T, db *MYSQLStore, productId, sizeId int) decimal.Decimal {
+	p, err := db.Products().GetProductByIdShowHidden(ctx, productId)
 	assert.NoError(t, err)
+	for _, s := range p.Sizes {
+		if s.SizeId == sizeId {
+			return s.Quantity
+		}
+	}
+	return decimal.Zero
+}
+
+func TestCreateOrder(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	p, xlSize, lSize, ok := addProductWithSizes(ctx, t, db, 10, 15)
+	if !ok {
+		return
+	}
 
 	// order store
 	os := db.Order()
@@ -135,18 +216,23 @@ func TestCreateOrder(t *testing.T) {
 	// creating new order with one product in xl size and quantity 1
 	items := []entity.OrderItemInsert{
 		{
-			ProductID: p.Product.ID,
+			ProductId: p.Product.Id,
 			Quantity:  decimal.NewFromInt32(1),
-			SizeID:    xlSize.ID,
+			SizeId:    xlSize.Id,
 		},
 	}
 	// new order without promo code
-	newOrder, sc, err := newOrder(ctx, db, items, "", 1)
-	assert.NoError(t, err)
+	on, sc, err := newOrder(items, "", 1)
+	if !assert.NoError(t, err) {
+		return
+	}
 
-	order, err := os.CreateOrder(ctx, newOrder)
-	assert.NoError(t, err)
-	assert.True(t, order.TotalPrice.Equal(p.Product.Price.Add(sc.ShipmentCarrierInsert.Price)))
+	order, _, err := os.CreateOrder(ctx, on, false)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.True(t, order.TotalPrice.Equal(p.Product.Price.Add(sc.Price)))
+	assert.Equal(t, cache.OrderStatusPlaced.Status.Id, order.OrderStatusId)
 
 	// promo free shipping
 
@@ -159,9 +245,13 @@ func TestCreateOrder(t *testing.T) {
 	})
 	assert.NoError(t, err)
 
-	newTotal, err := os.ApplyPromoCode(ctx, order.ID, "freeShip")
+	on, _, err = newOrder(items, "freeShip", 2)
 	assert.NoError(t, err)
-	assert.True(t, newTotal.Equal(p.Product.Price), newTotal.String())
+	order, _, err = os.CreateOrder(ctx, on, false)
+	if assert.NoError(t, err) {
+		assert.True(t, order.TotalPrice.Equal(p.Product.Price), order.TotalPrice.String())
+		assert.True(t, order.PromoId.Valid)
+	}
 
 	// promo 10% off + free shipping
 
@@ -174,493 +264,296 @@ func TestCreateOrder(t *testing.T) {
 	})
 	assert.NoError(t, err)
 
-	newTotal, err = os.ApplyPromoCode(ctx, order.ID, "freeShip10off")
-	assert.NoError(t, err)
-	assert.True(t, newTotal.Equal(p.Product.Price.Mul(decimal.NewFromFloat(0.9))))
-
-	// new order items with one product size and quantity 2
-
-	_, err = os.UpdateOrderItems(ctx, order.ID, []entity.OrderItemInsert{
-		{
-			ProductID: p.Product.ID,
-			Quantity:  decimal.NewFromInt32(2),
-			SizeID:    lSize.ID,
-		},
-	})
-	assert.NoError(t, err)
-
-	orderFull, err := os.GetOrderById(ctx, order.ID)
-	assert.NoError(t, err)
-
-	orderFull, err = os.GetOrderByUUID(ctx, orderFull.Order.UUID)
-	assert.NoError(t, err)
-
-	assert.Equal(t, 1, len(orderFull.OrderItems))
-	assert.Equal(t, 2, int(orderFull.OrderItems[0].Quantity.IntPart()))
-	assert.True(t, orderFull.TotalPrice.Equal(p.Product.Price.Mul(decimal.NewFromFloat(0.9)).Mul(decimal.NewFromInt32(2))))
-
-	// new order items with one product size but passed as two separate items
-
-	_, err = os.UpdateOrderItems(ctx, order.ID, []entity.OrderItemInsert{
-		{
-			ProductID: p.Product.ID,
-			Quantity:  decimal.NewFromInt32(2),
-			SizeID:    lSize.ID,
-		},
-		{
-			ProductID: p.Product.ID,
-			Quantity:  decimal.NewFromInt32(2),
-			SizeID:    lSize.ID,
-		},
-	})
-	assert.NoError(t, err)
-
-	orderFull, err = os.GetOrderById(ctx, order.ID)
+	on, _, err = newOrder(items, "freeShip10off", 3)
 	assert.NoError(t, err)
+	order, _, err = os.CreateOrder(ctx, on, false)
+	if assert.NoError(t, err) {
+		assert.True(t, order.TotalPrice.Equal(p.Product.Price.Mul(decimal.NewFromFloat(0.9))), order.TotalPrice.String())
+	}
 
-	assert.Equal(t, 1, len(orderFull.OrderItems))
-	assert.Equal(t, 4, int(orderFull.OrderItems[0].Quantity.IntPart()))
-	assert.True(t, orderFull.TotalPrice.Equal(p.Product.Price.Mul(decimal.NewFromFloat(0.9)).Mul(decimal.NewFromInt32(4))))
-
-	// new order items with two product sizes
-
-	_, err = os.UpdateOrderItems(ctx, order.ID, []entity.OrderItemInsert{
-		{
-			ProductID: p.Product.ID,
-			Quantity:  decimal.NewFromInt32(2),
-			SizeID:    lSize.ID,
-		},
-		{
-			ProductID: p.Product.ID,
-			Quantity:  decimal.NewFromInt32(2),
-			SizeID:    xlSize.ID,
-		},
-	})
-
-	assert.NoError(t, err)
+	// unknown promo code is ignored
 
-	orderFull, err = os.GetOrderById(ctx, order.ID)
+	on, sc, err = newOrder(items, "unknown", 4)
 	assert.NoError(t, err)
+	order, _, err = os.CreateOrder(ctx, on, false)
+	if assert.NoError(t, err) {
+		assert.True(t, order.TotalPrice.Equal(p.Product.Price.Add(sc.Price)))
+		assert.False(t, order.PromoId.Valid)
+	}
 
-	assert.Equal(t, 2, len(orderFull.OrderItems))
-	assert.Equal(t, 2, int(orderFull.OrderItems[0].Quantity.IntPart()))
-	assert.Equal(t, 2, int(orderFull.OrderItems[1].Quantity.IntPart()))
-	assert.True(t, orderFull.TotalPrice.Equal(p.Product.Price.Mul(decimal.NewFromFloat(0.9)).Mul(decimal.NewFromInt32(4))))
-
-	// new order items with two product sizes with one size quantity 0
+	// one product size passed as two separate items is merged
 
-	_, err = os.UpdateOrderItems(ctx, order.ID, []entity.OrderItemInsert{
+	on, sc, err = newOrder([]entity.OrderItemInsert{
 		{
-			ProductID: p.Product.ID,
-			Quantity:  decimal.NewFromInt32(2),
-			SizeID:    lSize.ID,
+			ProductId: p.Product.Id,
+			Quantity:  decimal.NewFromInt32(1),
+			SizeId:    lSize.Id,
 		},
 		{
-			ProductID: p.Product.ID,
-			Quantity:  decimal.NewFromInt32(0),
-			SizeID:    xlSize.ID,
+			ProductId: p.Product.Id,
+			Quantity:  decimal.NewFromInt32(1),
+			SizeId:    lSize.Id,
 		},
-	})
-
-	assert.NoError(t, err)
-
-	orderFull, err = os.GetOrderById(ctx, order.ID)
-	assert.NoError(t, err)
-
-	assert.Equal(t, 1, len(orderFull.OrderItems))
-	assert.Equal(t, 2, int(orderFull.OrderItems[0].Quantity.IntPart()))
-	assert.True(t, orderFull.TotalPrice.Equal(p.Product.Price.Mul(decimal.NewFromFloat(0.9)).Mul(decimal.NewFromInt32(2))))
-
-	// update shipment carrier to paid, disable promo check
-	// if amount calculated correctly
-
-	paidSc, err := getShipmentCarrierPaid(db)
-	assert.NoError(t, err)
-
-	_, err = os.UpdateOrderShippingCarrier(ctx, order.ID, paidSc.ID)
-	assert.NoError(t, err)
-
-	err = db.Promo().AddPromo(ctx, &entity.PromoCodeInsert{
-		Code:         "noPromo",
-		FreeShipping: false,
-		Discount:     decimal.NewFromInt(0),
-		Expiration:   time.Now().Add(time.Hour * 24),
-		Allowed:      true,
-	})
-
+	}, "", 5)
 	assert.NoError(t, err)
+	order, _, err = os.CreateOrder(ctx, on, false)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.True(t, order.TotalPrice.Equal(p.Product.Price.Mul(decimal.NewFromInt32(2)).Add(sc.Price)))
 
-	newTotal, err = os.ApplyPromoCode(ctx, order.ID, "noPromo")
-	assert.NoError(t, err)
-	assert.True(t, newTotal.Equal(p.Product.Price.Mul(decimal.NewFromInt32(2)).Add(paidSc.ShipmentCarrierInsert.Price)))
+	orderFull, err := os.GetOrderById(ctx, order.Id)
+	if !assert.NoError(t, err) {
+		return
+	}
+	if assert.Len(t, orderFull.OrderItems, 1) {
+		assert.Equal(t, 2, int(orderFull.OrderItems[0].Quantity.IntPart()))
+	}
 
-	// new order items with two product sizes with both sizes quantity 0
-	// to trigger cancellation
+	// the valid placed order is returned as is
+	orderFull, err = os.ValidateOrderByUUID(ctx, orderFull.Order.UUID)
+	if assert.NoError(t, err) {
+		assert.Len(t, orderFull.OrderItems, 1)
+		assert.True(t, orderFull.Order.TotalPrice.Equal(order.TotalPrice))
+	}
 
-	_, err = os.UpdateOrderItems(ctx, order.ID, []entity.OrderItemInsert{
+	// zero quantity items are not ordered
+	on, _, err = newOrder([]entity.OrderItemInsert{
 		{
-			ProductID: p.Product.ID,
+			ProductId: p.Product.Id,
 			Quantity:  decimal.NewFromInt32(0),
-			SizeID:    lSize.ID,
+			SizeId:    lSize.Id,
 		},
-		{
-			ProductID: p.Product.ID,
-			Quantity:  decimal.NewFromInt32(0),
-			SizeID:    xlSize.ID,
-		},
-	})
-
-	assert.NoError(t, err)
-
-	orderFull, err = os.GetOrderById(ctx, order.ID)
+	}, "", 6)
 	assert.NoError(t, err)
-
-	assert.True(t, orderFull.OrderStatus.Name == entity.Cancelled)
-
-	// new order items trigger error because of status cancelled
-
-	_, err = os.UpdateOrderItems(ctx, order.ID, []entity.OrderItemInsert{
-		{
-			ProductID: p.Product.ID,
-			Quantity:  decimal.NewFromInt32(0),
-			SizeID:    lSize.ID,
-		},
-	})
+	_, _, err = os.CreateOrder(ctx, on, false)
 	assert.Error(t, err)
 }
 
 func TestPurchase(t *testing.T) {
-	// Initialize the product store
 	db := newTestDB(t)
 	ps := db.Products()
 	ctx := context.Background()
 
-	np, err := randomProductInsert(db, 1)
-	assert.NoError(t, err)
-
-	xlSize, ok := db.cache.GetSizesByName(entity.XL)
-	assert.True(t, ok)
-
-	lSize, ok := db.cache.GetSizesByName(entity.L)
-	assert.True(t, ok)
-
-	np.SizeMeasurements = []entity.SizeWithMeasurementInsert{
-		{
-			ProductSize: entity.ProductSizeInsert{
-				Quantity: decimal.NewFromInt(1),
-				SizeID:   xlSize.ID,
-			},
-		},
-		{
-			ProductSize: entity.ProductSizeInsert{
-				Quantity: decimal.NewFromInt(1),
-				SizeID:   lSize.ID,
-			},
-		},
+	p, xlSize, lSize, ok := addProductWithSizes(ctx, t, db, 1, 1)
+	if !ok {
+		return
 	}
 
-	// Insert new product
-	prd, err := ps.AddProduct(ctx, np)
-	assert.NoError(t, err)
-
-	p, err := ps.GetProductByIdShowHidden(ctx, prd.Product.ID)
-	assert.NoError(t, err)
-
 	// order store
 	os := db.Order()
 
 	// creating new order with one product in xl size and quantity 1
 	itemsXL := []entity.OrderItemInsert{
 		{
-			ProductID: p.Product.ID,
+			ProductId: p.Product.Id,
 			Quantity:  decimal.NewFromInt32(1),
-			SizeID:    xlSize.ID,
+			SizeId:    xlSize.Id,
 		},
 	}
 	itemsL := []entity.OrderItemInsert{
 		{
-			ProductID: p.Product.ID,
+			ProductId: p.Product.Id,
 			Quantity:  decimal.NewFromInt32(1),
-			SizeID:    lSize.ID,
+			SizeId:    lSize.Id,
 		},
 	}
 
-	newOrderXL, scXL, err := newOrder(ctx, db, itemsXL, "", 1)
-	assert.NoError(t, err)
-
-	newOrderL, scL, err := newOrder(ctx, db, itemsL, "", 1)
-	assert.NoError(t, err)
-
-	orderXL, err := os.CreateOrder(ctx, newOrderXL)
-	assert.NoError(t, err)
-	assert.True(t, orderXL.TotalPrice.Equal(p.Product.Price.Add(scXL.ShipmentCarrierInsert.Price)))
-
-	statusPlaced, ok := db.cache.GetOrderStatusByName(entity.Placed)
-	assert.True(t, ok)
-	assert.Equal(t, orderXL.OrderStatusID, statusPlaced.ID)
-
-	orderL, err := os.CreateOrder(ctx, newOrderL)
-	assert.NoError(t, err)
-	assert.True(t, orderL.TotalPrice.Equal(p.Product.Price.Add(scL.ShipmentCarrierInsert.Price)))
-
-	// getting product by id to check if quantity is not updated
-
-	p, err = ps.GetProductByIdShowHidden(ctx, prd.Product.ID)
-	assert.NoError(t, err)
-	assert.True(t, len(p.Sizes) == 2)
-	assert.True(t, p.Sizes[0].Quantity.Equal(decimal.NewFromInt(1)))
-	assert.True(t, p.Sizes[0].Quantity.Equal(decimal.NewFromInt(1)))
-
-	// purchase order with xl size
-
-	pi := &entity.PaymentInsert{
-		TransactionID: sql.NullString{
-			String: "1234567890",
-			Valid:  true,
-		},
-		TransactionAmount: orderXL.TotalPrice,
-		Payer: sql.NullString{
-			String: "payer",
-			Valid:  true,
-		},
-		Payee: sql.NullString{
-			String: "payee",
-			Valid:  true,
-		},
-		IsTransactionDone: true,
+	newOrderXL, scXL, err := newOrder(itemsXL, "", 1)
+	if !assert.NoError(t, err) {
+		return
 	}
 
-	pmXL, err := getRandomPaymentMethod(db)
-	assert.NoError(t, err)
-
-	pi.PaymentMethodID = pmXL.ID
-	pi.TransactionAmount = orderXL.TotalPrice
-
-	err = os.OrderPaymentDone(ctx, orderXL.UUID, pi)
-	assert.NoError(t, err)
+	newOrderL, scL, err := newOrder(itemsL, "", 1)
+	if !assert.NoError(t, err) {
+		return
+	}
 
-	// purchase order with l size
+	orderXL, _, err := os.CreateOrder(ctx, newOrderXL, false)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.True(t, orderXL.TotalPrice.Equal(p.Product.Price.Add(scXL.Price)))
+	assert.Equal(t, cache.OrderStatusPlaced.Status.Id, orderXL.OrderStatusId)
 
-	pmL, err := getRandomPaymentMethod(db)
-	assert.NoError(t, err)
+	orderL, _, err := os.CreateOrder(ctx, newOrderL, false)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.True(t, orderL.TotalPrice.Equal(p.Product.Price.Add(scL.Price)))
 
-	pi.PaymentMethodID = pmL.ID
-	pi.TransactionAmount = orderL.TotalPrice
+	// the stock is reserved but the quantity is not updated
 
-	err = os.OrderPaymentDone(ctx, orderL.UUID, pi)
+	p, err = ps.GetProductByIdShowHidden(ctx, p.Product.Id)
 	assert.NoError(t, err)
+	if assert.Len(t, p.Sizes, 2) {
+		assert.True(t, p.Sizes[0].Quantity.Equal(decimal.NewFromInt(1)))
+		assert.True(t, p.Sizes[1].Quantity.Equal(decimal.NewFromInt(1)))
+	}
 
-	// now make sure that orders has status confirmed
-
-	// for xl
-	statusConfirmed, ok := db.cache.GetOrderStatusByName(entity.Confirmed)
-	assert.True(t, ok)
-
-	orderFullXL, err := os.GetOrderById(ctx, orderXL.ID)
+	// the reserved stock can't be ordered by the other buyers
+	newOrderReserved, _, err := newOrder(itemsL, "", 2)
 	assert.NoError(t, err)
+	_, _, err = os.CreateOrder(ctx, newOrderReserved, false)
+	assert.Error(t, err)
 
-	assert.Equal(t, orderFullXL.OrderStatus.ID, statusConfirmed.ID)
-
-	// for l
-	orderFullL, err := os.GetOrderById(ctx, orderL.ID)
-	assert.NoError(t, err)
+	pm, err := getCryptoPaymentMethod()
+	if !assert.NoError(t, err) {
+		return
+	}
 
-	assert.Equal(t, orderFullL.OrderStatus.ID, statusConfirmed.ID)
+	for _, o := range []*entity.Order{orderXL, orderL} {
+		// issue the invoice
+		of, err := os.InsertCryptoInvoice(ctx, o.UUID, fmt.Sprintf("payee-%d", o.Id), *pm)
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, cache.OrderStatusAwaitingPayment.Status.Id, of.Order.OrderStatusId)
+
+		// purchase the order
+		_, err = os.OrderPaymentDone(ctx, o.UUID, &entity.Payment{
+			PaymentInsert: entity.PaymentInsert{
+				PaymentMethodID:   pm.Id,
+				TransactionID:     sql.NullString{String: fmt.Sprintf("tx-%d", o.Id), Valid: true},
+				TransactionAmount: o.TotalPrice,
+				Payer:             sql.NullString{String: "payer", Valid: true},
+				Payee:             sql.NullString{String: fmt.Sprintf("payee-%d", o.Id), Valid: true},
+			},
+		})
+		assert.NoError(t, err)
+
+		// now make sure that order has status confirmed
+		of, err = os.GetOrderById(ctx, o.Id)
+		if assert.NoError(t, err) {
+			assert.Equal(t, cache.OrderStatusConfirmed.Status.Id, of.Order.OrderStatusId)
+			assert.True(t, of.Payment.IsTransactionDone)
+		}
+	}
 
 	// than make sure that product quantity is updated
 
-	p, err = ps.GetProductByIdShowHidden(ctx, prd.Product.ID)
+	p, err = ps.GetProductByIdShowHidden(ctx, p.Product.Id)
 	assert.NoError(t, err)
-
-	assert.True(t, len(p.Sizes) == 2)
-	assert.True(t, p.Sizes[0].Quantity.Equal(decimal.NewFromInt(0)))
-	assert.True(t, p.Sizes[1].Quantity.Equal(decimal.NewFromInt(0)))
+	if assert.Len(t, p.Sizes, 2) {
+		assert.True(t, p.Sizes[0].Quantity.Equal(decimal.NewFromInt(0)))
+		assert.True(t, p.Sizes[1].Quantity.Equal(decimal.NewFromInt(0)))
+	}
 
 	// try to create order with out of stock item to trigger error
 
-	itemsOutOfStock := []entity.OrderItemInsert{
-		{
-			ProductID: p.Product.ID,
-			Quantity:  decimal.NewFromInt32(1),
-			SizeID:    lSize.ID,
-		},
-	}
-
-	newOrderOutOfStock, _, err := newOrder(ctx, db, itemsOutOfStock, "", 1)
+	newOrderOutOfStock, _, err := newOrder(itemsL, "", 1)
 	assert.NoError(t, err)
 
-	_, err = os.CreateOrder(ctx, newOrderOutOfStock)
+	_, _, err = os.CreateOrder(ctx, newOrderOutOfStock, false)
 	assert.Error(t, err)
 
 }
 
 func TestOrderOutOfStock(t *testing.T) {
-	// Initialize the product store
 	db := newTestDB(t)
 	ps := db.Products()
 	ctx := context.Background()
 
-	np, err := randomProductInsert(db, 1)
-	assert.NoError(t, err)
-
-	xlSize, ok := db.cache.GetSizesByName(entity.XL)
-	assert.True(t, ok)
-
-	lSize, ok := db.cache.GetSizesByName(entity.L)
-	assert.True(t, ok)
-
-	np.SizeMeasurements = []entity.SizeWithMeasurementInsert{
-		{
-			ProductSize: entity.ProductSizeInsert{
-				Quantity: decimal.NewFromInt(1),
-				SizeID:   xlSize.ID,
-			},
-		},
-		{
-			ProductSize: entity.ProductSizeInsert{
-				Quantity: decimal.NewFromInt(1),
-				SizeID:   lSize.ID,
-			},
-		},
+	p, xlSize, lSize, ok := addProductWithSizes(ctx, t, db, 2, 1)
+	if !ok {
+		return
 	}
 
-	// Insert new product
-	prd, err := ps.AddProduct(ctx, np)
-	assert.NoError(t, err)
-
-	p, err := ps.GetProductByIdShowHidden(ctx, prd.Product.ID)
-	assert.NoError(t, err)
-
 	// order store
 	os := db.Order()
 
-	// creating new order with one product in xl size and quantity 1
 	items := []entity.OrderItemInsert{
 		{
-			ProductID: p.Product.ID,
+			ProductId: p.Product.Id,
 			Quantity:  decimal.NewFromInt32(1),
-			SizeID:    xlSize.ID,
+			SizeId:    xlSize.Id,
 		},
 	}
 
 	itemsToClean := []entity.OrderItemInsert{
 		{
-			ProductID: p.Product.ID,
+			ProductId: p.Product.Id,
 			Quantity:  decimal.NewFromInt32(1),
-			SizeID:    xlSize.ID,
+			SizeId:    xlSize.Id,
 		},
 		{
-			ProductID: p.Product.ID,
+			ProductId: p.Product.Id,
 			Quantity:  decimal.NewFromInt32(1),
-			SizeID:    lSize.ID,
+			SizeId:    lSize.Id,
 		},
 	}
 
-	// ok order with would be fulfilled
-
-	newOrderOK, scOK, err := newOrder(ctx, db, items, "", 1)
-	assert.NoError(t, err)
-
-	orderOk, err := os.CreateOrder(ctx, newOrderOK)
-	assert.NoError(t, err)
-	assert.True(t, orderOk.TotalPrice.Equal(p.Product.Price.Add(scOK.ShipmentCarrierInsert.Price)))
-
-	// bad order with would not be fulfilled because of out of stock
-	newOrderBad, scBad, err := newOrder(ctx, db, items, "", 1)
-	assert.NoError(t, err)
+	// bad order would not be fulfilled because of out of stock
+	newOrderBad, scBad, err := newOrder(items, "", 1)
+	if !assert.NoError(t, err) {
+		return
+	}
 
-	orderBad, err := os.CreateOrder(ctx, newOrderBad)
-	assert.NoError(t, err)
-	assert.True(t, orderBad.TotalPrice.Equal(p.Product.Price.Add(scBad.ShipmentCarrierInsert.Price)))
+	orderBad, _, err := os.CreateOrder(ctx, newOrderBad, false)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.True(t, orderBad.TotalPrice.Equal(p.Product.Price.Add(scBad.Price)))
 
 	// order to clean up
-	newOrderToClean, scClean, err := newOrder(ctx, db, itemsToClean, "", 1)
-	assert.NoError(t, err)
-
-	orderToClean, err := os.CreateOrder(ctx, newOrderToClean)
-	assert.NoError(t, err)
-	assert.True(t, orderToClean.TotalPrice.Equal(p.Product.Price.Mul(decimal.NewFromFloat32(2)).Add(scClean.ShipmentCarrierInsert.Price)))
-
-	// check that both orders has status placed
-	statusPlaced, ok := db.cache.GetOrderStatusByName(entity.Placed)
-	assert.True(t, ok)
-	assert.Equal(t, orderOk.OrderStatusID, statusPlaced.ID)
-	assert.Equal(t, orderBad.OrderStatusID, statusPlaced.ID)
-	assert.Equal(t, orderToClean.OrderStatusID, statusPlaced.ID)
-
-	// getting product by id to check if quantity is not updated
-	p, err = ps.GetProductByIdShowHidden(ctx, prd.Product.ID)
-	assert.NoError(t, err)
-	assert.True(t, len(p.Sizes) == 2, len(p.Sizes))
-	assert.True(t, p.Sizes[0].Quantity.Equal(decimal.NewFromInt(1)))
-
-	// purchase first order
-
-	pi := &entity.PaymentInsert{
-		TransactionID: sql.NullString{
-			String: "1234567890",
-			Valid:  true,
-		},
-		TransactionAmount: orderOk.TotalPrice,
-		Payer: sql.NullString{
-			String: "payer",
-			Valid:  true,
-		},
-		Payee: sql.NullString{
-			String: "payee",
-			Valid:  true,
-		},
-		IsTransactionDone: true,
+	newOrderToClean, scClean, err := newOrder(itemsToClean, "", 1)
+	if !assert.NoError(t, err) {
+		return
 	}
 
-	pm, err := getRandomPaymentMethod(db)
-	assert.NoError(t, err)
-
-	pi.PaymentMethodID = pm.ID
-	pi.TransactionAmount = orderOk.TotalPrice
+	orderToClean, _, err := os.CreateOrder(ctx, newOrderToClean, false)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.True(t, orderToClean.TotalPrice.Equal(p.Product.Price.Mul(decimal.NewFromFloat32(2)).Add(scClean.Price)))
 
-	// try to pay for ok order
-	err = os.OrderPaymentDone(ctx, orderOk.UUID, pi)
+	// the xl size is sold out of the reserved stock
+	err = ps.ReduceStockForProductSizes(ctx, []entity.OrderItemInsert{{
+		ProductId: p.Product.Id,
+		Quantity:  decimal.NewFromInt32(2),
+		SizeId:    xlSize.Id,
+	}})
 	assert.NoError(t, err)
 
-	// try to pay for bad order where product is out of stock
-	// must trigger error and change status to cancelled
-	// cause every order item is out of stock
-	pi.TransactionAmount = orderBad.TotalPrice
-
-	err = os.OrderPaymentDone(ctx, orderBad.UUID, pi)
-	assert.Error(t, err)
+	pm, err := getCryptoPaymentMethod()
+	if !assert.NoError(t, err) {
+		return
+	}
 
-	// try to pay for bad order where product is out of stock
+	// invoice for the order where one of the items is out of stock
 	// must trigger error and clean up order items
-	// i.e remove order items with quantity 0
-
-	pi.TransactionAmount = orderToClean.TotalPrice
-	err = os.OrderPaymentDone(ctx, orderToClean.UUID, pi)
-	assert.Error(t, err)
+	_, err = insertCryptoInvoice(ctx, db, orderToClean.UUID, "payee-clean", *pm)
+	assert.ErrorIs(t, err, entity.ErrOrderNotInvoiced)
 
-	// on second try order would be cleaned up and can be paid
+	of, err := os.GetOrderById(ctx, orderToClean.Id)
+	if assert.NoError(t, err) && assert.Len(t, of.OrderItems, 1) {
+		assert.Equal(t, lSize.Id, of.OrderItems[0].SizeId)
+		assert.True(t, of.Order.TotalPrice.Equal(p.Product.Price.Add(scClean.Price)))
+	}
 
-	err = os.OrderPaymentDone(ctx, orderToClean.UUID, pi)
+	// on second try order is cleaned up and can be invoiced
+	_, err = insertCryptoInvoice(ctx, db, orderToClean.UUID, "payee-clean", *pm)
 	assert.NoError(t, err)
 
-	// orders by status
-
-	// one cancelled - bad order
-	orders, err := os.GetOrdersByStatus(ctx, entity.Cancelled)
-	assert.NoError(t, err)
-	assert.Equal(t, 1, len(orders))
+	// invoice for the order where every item is out of stock must trigger error and cancel the order
+	_, err = insertCryptoInvoice(ctx, db, orderBad.UUID, "payee-bad", *pm)
+	assert.ErrorIs(t, err, entity.ErrOrderNotInvoiced)
+	of, err = os.GetOrderById(ctx, orderBad.Id)
+	if assert.NoError(t, err) {
+		assert.Equal(t, cache.OrderStatusCancelled.Status.Id, of.Order.OrderStatusId)
+	}
 
-	email := orders[0].Buyer.Email
+	// orders by status
 
-	// two confirmed - ok order and order to clean up
-	orders, err = os.GetOrdersByStatus(ctx, entity.Confirmed)
+	// one awaiting payment - order to clean up
+	orders, err := os.GetOrdersByStatusAndPaymentTypePaged(ctx, "", cache.OrderStatusAwaitingPayment.Status.Id, 0, 0, 10, 0, entity.Descending)
 	assert.NoError(t, err)
-	assert.Equal(t, 2, len(orders))
-
-	// by email 3 orders - ok order, order to clean up and bad order
+	if assert.Len(t, orders, 1) {
+		assert.Equal(t, orderToClean.Id, orders[0].Id)
+	}
 
-	orders, err = os.GetOrdersByEmail(ctx, email)
+	// by email 2 orders - order to clean up and bad order
+	ofs, err := os.GetOrdersFullByBuyerEmail(ctx, newOrderBad.Buyer.Email, 10)
 	assert.NoError(t, err)
-	assert.Equal(t, 3, len(orders))
+	assert.Len(t, ofs, 2)
 }
@@ -0,0 +1,126 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/jekabolt/grbpwr-manager/internal/dependency"
+	"github.com/jekabolt/grbpwr-manager/internal/entity"
+	"github.com/shopspring/decimal"
+)
+
+// paymentPayload is the payment state recorded with the invoice, confirmed and expired events
+type paymentPayload struct {
+	TransactionId                    string          `json:"transactionId,omitempty"`
+	TransactionAmount                decimal.Decimal `json:"transactionAmount"`
+	TransactionAmountPaymentCurrency decimal.Decimal `json:"transactionAmountPaymentCurrency"`
+	PaymentCurrency                  string          `json:"paymentCurrency,omitempty"`
+	Payer                            string          `json:"payer,omitempty"`
+	Payee                            string          `json:"payee,omitempty"`
+}
+
+func newPaymentPayload(p *entity.PaymentInsert) paymentPayload {
+	return paymentPayload{
+		TransactionId:                    p.TransactionID.String,
+		TransactionAmount:                p.TransactionAmount,
+		TransactionAmountPaymentCurrency: p.TransactionAmountPaymentCurrency,
+		PaymentCurrency:                  p.PaymentCurrency.String,
+		Payer:                            p.Payer.String,
+		Payee:                            p.Payee.String,
+	}
+}
+
+// refundPayload is the refund recorded with the refunded event
+type refundPayload struct {
+	RefundId         int             `json:"refundId"`
+	Amount           decimal.Decimal `json:"amount"`
+	Status           string          `json:"status"`
+	ProviderRefundId string          `json:"providerRefundId,omitempty"`
+	Reason           string          `json:"reason,omitempty"`
+	Restocked        bool            `json:"restocked,omitempty"`
+	// RestockedItems are the order items returned to stock with the refund, recorded when it's requested
+	RestockedItems []entity.RefundItemInsert `json:"restockedItems,omitempty"`
+	GiftCardAmount decimal.Decimal           `json:"giftCardAmount"`
+}
+
+// clientSecretPaymentIntentId returns the id of the payment intent the client secret belongs to,
+// the secret itself is not recorded in the log.
+func clientSecretPaymentIntentId(clientSecret string) string {
+	id, _, _ := strings.Cut(clientSecret, "_secret_")
+	return id
+}
+
+func getPaymentByOrderId(ctx context.Context, rep dependency.Repository, orderId int) (*entity.Payment, error) {
+	payment, err := QueryNamedOne[entity.Payment](ctx, rep.DB(), `SELECT * FROM payment WHERE order_id = :orderId`, map[string]any{
+		"orderId": orderId,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &payment, nil
+}
+
+// insertPaymentEvent appends the event to the payment log,
+// the repeated event of the same transaction is skipped.
+func insertPaymentEvent(ctx context.Context, rep dependency.Repository, payment *entity.Payment, pe *entity.PaymentEventInsert) error {
+	query := `
+	INSERT INTO payment_event (payment_id, order_id, payment_method_id, type, transaction_id, payload)
+	VALUES (:paymentId, :orderId, :paymentMethodId, :type, :transactionId, :payload)
+	ON DUPLICATE KEY UPDATE id = id`
+
+	err := ExecNamed(ctx, rep.DB(), query, map[string]any{
+		"paymentId":       payment.Id,
+		"orderId":         payment.OrderId,
+		"paymentMethodId": payment.PaymentMethodID,
+		"type":            pe.Type,
+		"transactionId":   pe.TransactionId,
+		"payload":         pe.Payload,
+	})
+	if err != nil {
+		return fmt.Errorf("can't insert payment event %s: %w", pe.Type, err)
+	}
+	return nil
+}
+
+// addPaymentEvent appends the event with the payload marshalled to json to the log of the order payment.
+func addPaymentEvent(ctx context.Context, rep dependency.Repository, orderId int, t entity.PaymentEventType, transactionId sql.NullString, payload any) error {
+	payment, err := getPaymentByOrderId(ctx, rep, orderId)
+	if err != nil {
+		return fmt.Errorf("can't get payment by order id: %w", err)
+	}
+	return insertPaymentEvent(ctx, rep, payment, &entity.PaymentEventInsert{
+		Type:          t,
+		TransactionId: transactionId,
+		Payload:       entity.PaymentEventPayload(payload),
+	})
+}
+
+// AddPaymentEvent records the payment change reported by the payment provider,
+// it's written in the transaction of the repository it's called on.
+func (ms *MYSQLStore) AddPaymentEvent(ctx context.Context, orderUUID string, pe *entity.PaymentEventInsert) error {
+	payment, err := ms.GetPaymentByOrderUUID(ctx, orderUUID)
+	if err != nil {
+		return fmt.Errorf("can't get payment by order uuid: %w", err)
+	}
+	return insertPaymentEvent(ctx, ms, payment, pe)
+}
+
+// GetPaymentEventsByOrderUUID returns the payment event log of the order in the order the events happened.
+func (ms *MYSQLStore) GetPaymentEventsByOrderUUID(ctx context.Context, orderUUID string) ([]entity.PaymentEvent, error) {
+	query := `
+	SELECT pe.*
+	FROM payment_event pe
+	JOIN customer_order co ON pe.order_id = co.id
+	WHERE co.uuid = :orderUUID
+	ORDER BY pe.id`
+
+	events, err := QueryListNamed[entity.PaymentEvent](ctx, ms.DB(), query, map[string]any{
+		"orderUUID": orderUUID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't get payment events: %w", err)
+	}
+	return events, nil
+}
@@ -0,0 +1,42 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/jekabolt/grbpwr-manager/internal/cache"
+	"github.com/jekabolt/grbpwr-manager/internal/entity"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPaymentEvent(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	order := insertTestOrder(ctx, t, db, decimal.NewFromInt(100), cache.OrderStatusPlaced)
+	err := insertPaymentRecord(ctx, db, cache.PaymentMethodUsdtTron.Method.Id, order.Id, "")
+	assert.NoError(t, err)
+
+	err = addPaymentEvent(ctx, db, order.Id, entity.PaymentEventInvoiceIssued, sql.NullString{}, nil)
+	assert.NoError(t, err)
+
+	seen := &entity.PaymentEventInsert{
+		Type:          entity.PaymentEventTransactionSeen,
+		TransactionId: sql.NullString{String: "tx", Valid: true},
+		Payload:       entity.PaymentEventPayload(map[string]string{"amount": "100"}),
+	}
+	assert.NoError(t, db.AddPaymentEvent(ctx, order.UUID, seen))
+	// the same transaction is recorded once
+	assert.NoError(t, db.AddPaymentEvent(ctx, order.UUID, seen))
+
+	events, err := db.GetPaymentEventsByOrderUUID(ctx, order.UUID)
+	assert.NoError(t, err)
+	if assert.Len(t, events, 2) {
+		assert.Equal(t, entity.PaymentEventInvoiceIssued, events[0].Type)
+		assert.Equal(t, entity.PaymentEventTransactionSeen, events[1].Type)
+		assert.Equal(t, "tx", events[1].TransactionId.String)
+		assert.Equal(t, order.Id, events[1].OrderId)
+	}
+}
@@ -0,0 +1,47 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jekabolt/grbpwr-manager/internal/cache"
+	"github.com/jekabolt/grbpwr-manager/internal/entity"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddMissingPaymentExpiration(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	pm, ok := cache.GetPaymentMethodByName(entity.CARD)
+	if !assert.True(t, ok) {
+		return
+	}
+	order := insertTestOrder(ctx, t, db, decimal.NewFromInt(100), cache.OrderStatusAwaitingPayment)
+
+	expireAt := func() time.Time {
+		pe, err := QueryNamedOne[entity.PaymentExpiration](ctx, db.DB(), `
+		SELECT id, order_id, payment_method_id, expire_at, created_at
+		FROM payment_expiration WHERE order_id = :orderId`, map[string]any{
+			"orderId": order.Id,
+		})
+		assert.NoError(t, err)
+		return pe.ExpireAt
+	}
+
+	// the order without an expiration gets one
+	first := time.Now().Add(5 * time.Minute)
+	assert.NoError(t, db.AddMissingPaymentExpiration(ctx, order.UUID, pm.Method.Id, first))
+	assert.WithinDuration(t, first, expireAt(), time.Second)
+
+	// the scheduled deadline is kept
+	assert.NoError(t, db.AddMissingPaymentExpiration(ctx, order.UUID, pm.Method.Id, first.Add(time.Hour)))
+	assert.WithinDuration(t, first, expireAt(), time.Second)
+
+	// the invoice reissued later reschedules it
+	second := first.Add(time.Hour)
+	assert.NoError(t, db.AddPaymentExpiration(ctx, order.UUID, pm.Method.Id, second))
+	assert.WithinDuration(t, second, expireAt(), time.Second)
+}
@@ -619,11 +619,12 @@ func (ms *MYSQLStore) getProductDetails(ctx context.Context, filters map[string]
 
 	productInfo.Product = &prd.Product
 
-	// fetch sizes
-	query = `SELECT * FROM product_size WHERE product_id = :id`
+	// fetch sizes with the quantity held by the reservations of all orders
+	query = `SELECT ps.*, ` + reservedQuantityQuery + ` AS reserved FROM product_size ps WHERE ps.product_id = :id`
 
 	sizes, err := QueryListNamed[entity.ProductSize](ctx, ms.db, query, map[string]any{
-		"id": prd.Id,
+		"id":      prd.Id,
+		"orderId": 0,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("can't get sizes: %w", err)
@@ -905,45 +906,29 @@ func getProductsByIds(ctx context.Context, rep dependency.Repository, productIds
 	return products, nil
 }
 
-func getProductsSizesByIds(ctx context.Context, rep dependency.Repository, items []entity.OrderItemInsert) ([]entity.ProductSize, error) {
+// getProductsSizesByIds returns the product sizes of the items with the quantity reserved for the other orders than orderId.
+func getProductsSizesByIds(ctx context.Context, rep dependency.Repository, items []entity.OrderItemInsert, orderId int) ([]entity.ProductSize, error) {
 	if len(items) == 0 {
 		return []entity.ProductSize{}, nil
 	}
 
-	var productSizeParams []interface{}
-	productSizeQuery := "SELECT * FROM product_size WHERE "
-
-	productSizeConditions := []string{}
-	for _, item := range items {
-		productSizeConditions = append(productSizeConditions, "(product_id = ? AND size_id = ?)")
-		productSizeParams = append(productSizeParams, item.ProductId, item.SizeId)
-	}
-
-	productSizeQuery += strings.Join(productSizeConditions, " OR ")
-
-	var productSizes []entity.ProductSize
-
-	rows, err := rep.DB().QueryxContext(ctx, productSizeQuery, productSizeParams...)
-	if err != nil {
-		return nil, err
+	productSizeParams := map[string]any{
+		"orderId": orderId,
 	}
-	defer rows.Close()
+	productSizeQuery := "SELECT ps.*, " + reservedQuantityQuery + " AS reserved FROM product_size ps WHERE "
 
-	for rows.Next() {
-		var ps entity.ProductSize
-		err := rows.StructScan(&ps)
-		if err != nil {
-			return nil, err
-		}
-		productSizes = append(productSizes, ps)
+	productSizeConditions := []string{}
+	for i, item := range items {
+		productSizeConditions = append(productSizeConditions, fmt.Sprintf("(ps.product_id = :productId%d AND ps.size_id = :sizeId%d)", i, i))
+		productSizeParams[fmt.Sprintf("productId%d", i)] = item.ProductId
+		productSizeParams[fmt.Sprintf("sizeId%d", i)] = item.SizeId
 	}
 
-	// Check for errors encountered during iteration over rows.
-	if err := rows.Err(); err != nil {
-		return nil, err
-	}
+	// the sizes are locked until the order reserving them commits,
+	// so the concurrent orders can't reserve the same stock
+	productSizeQuery += strings.Join(productSizeConditions, " OR ") + " FOR UPDATE"
 
-	return productSizes, nil
+	return QueryListNamed[entity.ProductSize](ctx, rep.DB(), productSizeQuery, productSizeParams)
 }
 
 func getProductIdsFromItems(items []entity.OrderItemInsert) []int {
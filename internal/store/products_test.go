@@ -6,30 +6,33 @@ import (
 	"errors"
 	"fmt"
 	"math/rand"
-	"reflect"
 	"testing"
+	"time"
 
+	"github.com/jekabolt/grbpwr-manager/internal/cache"
 	"github.com/jekabolt/grbpwr-manager/internal/entity"
 	"github.com/shopspring/decimal"
 	"github.com/stretchr/testify/assert"
 )
 
-func getRandomProductInsert(c *entity.Category, i int) *entity.ProductInsert {
+func getRandomProductInsert(c *entity.Category, thumbnailId int, i int) *entity.ProductInsert {
 	return &entity.ProductInsert{
-		Preorder:        sql.NullString{String: "", Valid: true},
-		Name:            fmt.Sprintf("RandomName_%d", i),
-		Brand:           "RandomBrand",
-		SKU:             "SKU123",
-		Color:           "Red",
-		ColorHex:        "#FF0000",
-		CountryOfOrigin: "USA",
-		Thumbnail:       "thumbnail.jpg",
-		Price:           decimal.NewFromInt(100),
-		SalePercentage:  decimal.NullDecimal{Decimal: decimal.NewFromInt(0), Valid: false},
-		CategoryID:      c.ID,
-		Description:     "RandomDescription",
-		Hidden:          sql.NullBool{Bool: false, Valid: true},
-		TargetGender:    entity.Male,
+		ProductBody: entity.ProductBody{
+			Preorder:        sql.NullTime{},
+			Name:            fmt.Sprintf("RandomName_%d", i),
+			Brand:           "RandomBrand",
+			SKU:             "SKU123",
+			Color:           "Red",
+			ColorHex:        "#FF0000",
+			CountryOfOrigin: "USA",
+			Price:           decimal.NewFromInt(100),
+			SalePercentage:  decimal.NullDecimal{Decimal: decimal.NewFromInt(0), Valid: false},
+			CategoryId:      c.Id,
+			Description:     "RandomDescription",
+			Hidden:          sql.NullBool{Bool: false, Valid: true},
+			TargetGender:    entity.Male,
+		},
+		ThumbnailMediaID: thumbnailId,
 	}
 }
 
@@ -38,11 +41,11 @@ func getRandomSizeWithMeasurement(s *entity.Size, m *entity.MeasurementName) []e
 		{
 			ProductSize: entity.ProductSizeInsert{
 				Quantity: decimal.NewFromInt(10),
-				SizeID:   s.ID,
+				SizeId:   s.Id,
 			},
 			Measurements: []entity.ProductMeasurementInsert{
 				{
-					MeasurementNameID: m.ID,
+					MeasurementNameId: m.Id,
 					MeasurementValue:  decimal.NewFromFloat(10.5),
 				},
 			},
@@ -50,56 +53,71 @@ func getRandomSizeWithMeasurement(s *entity.Size, m *entity.MeasurementName) []e
 	}
 }
 
-func getRandomEnumKeys(enumMap interface{}) []interface{} {
-	keys := reflect.ValueOf(enumMap).MapKeys()
-	randomKeys := make([]interface{}, len(keys))
-	for i, key := range keys {
-		randomKeys[i] = key.Interface()
+func getCategoryByName(name entity.CategoryEnum) (*entity.Category, bool) {
+	for _, c := range cache.GetCategories() {
+		if c.Name == name {
+			return &c, true
+		}
+	}
+	return nil, false
+}
+
+func getSizeByName(name entity.SizeEnum) (*entity.Size, bool) {
+	for _, s := range cache.GetSizes() {
+		if s.Name == name {
+			return &s, true
+		}
 	}
-	return randomKeys
+	return nil, false
 }
 
-func getRandomCategory(db *MYSQLStore) (*entity.Category, error) {
-	keys := getRandomEnumKeys(entity.ValidCategories)
-	category := keys[rand.Intn(len(keys))].(entity.CategoryEnum)
-	c, ok := db.cache.GetCategoryByName(category)
-	if !ok {
+func getMeasurementByName(name entity.MeasurementNameEnum) (*entity.MeasurementName, bool) {
+	for _, m := range cache.GetMeasurements() {
+		if m.Name == name {
+			return &m, true
+		}
+	}
+	return nil, false
+}
+
+func getRandomCategory() (*entity.Category, error) {
+	categories := cache.GetCategories()
+	if len(categories) == 0 {
 		return nil, fmt.Errorf("category not found")
 	}
+	c := categories[rand.Intn(len(categories))]
 	return &c, nil
 }
 
-func getRandomSize(db *MYSQLStore) (*entity.Size, error) {
-	keys := getRandomEnumKeys(entity.ValidSizes)
-	size := keys[rand.Intn(len(keys))].(entity.SizeEnum)
-	s, ok := db.cache.GetSizesByName(size)
-	if !ok {
+func getRandomSize() (*entity.Size, error) {
+	sizes := cache.GetSizes()
+	if len(sizes) == 0 {
 		return nil, fmt.Errorf("size not found")
 	}
+	s := sizes[rand.Intn(len(sizes))]
 	return &s, nil
 }
 
-func getRandomMeasurement(db *MYSQLStore) (*entity.MeasurementName, error) {
-	keys := getRandomEnumKeys(entity.ValidMeasurementNames)
-	measurementName := keys[rand.Intn(len(keys))].(entity.MeasurementNameEnum)
-	m, ok := db.cache.GetMeasurementsByName(measurementName)
-	if !ok {
-		return nil, fmt.Errorf("measurement not found")
+func getRandomMeasurement() (*entity.MeasurementName, error) {
+	measurements := make([]entity.MeasurementName, 0)
+	for _, m := range cache.GetMeasurements() {
+		if m.Name != entity.Height {
+			measurements = append(measurements, m)
+		}
 	}
-	if m.Name == entity.Height {
-		return getRandomMeasurement(db)
+	if len(measurements) == 0 {
+		return nil, fmt.Errorf("measurement not found")
 	}
+	m := measurements[rand.Intn(len(measurements))]
 	return &m, nil
 }
 
-func getRandomMedia() []entity.ProductMediaItem {
-	return []entity.ProductMediaItem{
-		{
-			FullSize:   "full_size.jpg",
-			Thumbnail:  "thumbnail.jpg",
-			Compressed: "compressed.jpg",
-		},
-	}
+func getRandomMedia(ctx context.Context, db *MYSQLStore, i int) (int, error) {
+	return db.Media().AddMedia(ctx, &entity.MediaItem{
+		FullSizeMediaURL:   fmt.Sprintf("full_size_%d.jpg", i),
+		ThumbnailMediaURL:  fmt.Sprintf("thumbnail_%d.jpg", i),
+		CompressedMediaURL: fmt.Sprintf("compressed_%d.jpg", i),
+	})
 }
 
 func getRandomTags() []entity.ProductTagInsert {
@@ -111,30 +129,28 @@ func getRandomTags() []entity.ProductTagInsert {
 }
 
 func randomProductInsert(db *MYSQLStore, i int) (*entity.ProductNew, error) {
-	c, err := getRandomCategory(db)
+	c, err := getRandomCategory()
 	if err != nil {
 		return nil, err
 	}
-	s, err := getRandomSize(db)
+	s, err := getRandomSize()
 	if err != nil {
 		return nil, err
 	}
-	m, err := getRandomMeasurement(db)
+	m, err := getRandomMeasurement()
+	if err != nil {
+		return nil, err
+	}
+	mediaId, err := getRandomMedia(context.Background(), db, i)
 	if err != nil {
 		return nil, err
 	}
-
-	product := getRandomProductInsert(c, 1)
-
-	sizeWithMeasurement := getRandomSizeWithMeasurement(s, m)
-	media := getRandomMedia()
-	tags := getRandomTags()
 
 	return &entity.ProductNew{
-		Product:          product,
-		SizeMeasurements: sizeWithMeasurement,
-		Media:            media,
-		Tags:             tags,
+		Product:          getRandomProductInsert(c, mediaId, i),
+		SizeMeasurements: getRandomSizeWithMeasurement(s, m),
+		MediaIds:         []int{mediaId},
+		Tags:             getRandomTags(),
 	}, nil
 }
 
@@ -144,271 +160,151 @@ func TestProductStore_AddProduct(t *testing.T) {
 	ctx := context.Background()
 
 	np, err := randomProductInsert(db, 1)
-	assert.NoError(t, err)
+	if !assert.NoError(t, err) {
+		return
+	}
 
 	// Insert new product
-	newPrd, err := ps.AddProduct(ctx, np)
+	prdId, err := ps.AddProduct(ctx, np)
 	assert.NoError(t, err)
 
 	// Fetch the product by ID
-	pf, err := ps.GetProductByIdShowHidden(ctx, newPrd.Product.ID)
-	assert.NoError(t, err)
+	pf, err := ps.GetProductByIdShowHidden(ctx, prdId)
+	if !assert.NoError(t, err) {
+		return
+	}
 
 	// Assertions on Product fields except IDs
-	assert.Equal(t, np.Product.Preorder, pf.Product.Preorder)
+	assert.Equal(t, np.Product.Preorder.Valid, pf.Product.Preorder.Valid)
 	assert.Equal(t, np.Product.Name, pf.Product.Name)
 	assert.Equal(t, np.Product.Brand, pf.Product.Brand)
 	assert.Equal(t, np.Product.SKU, pf.Product.SKU)
 	assert.Equal(t, np.Product.Color, pf.Product.Color)
 	assert.Equal(t, np.Product.ColorHex, pf.Product.ColorHex)
 	assert.Equal(t, np.Product.CountryOfOrigin, pf.Product.CountryOfOrigin)
-	assert.Equal(t, np.Product.Thumbnail, pf.Product.Thumbnail)
+	assert.Equal(t, np.Product.ThumbnailMediaID, pf.Product.ThumbnailMediaID)
 	assert.True(t, np.Product.Price.Equal(pf.Product.Price))
 	assert.True(t, pf.Product.SalePercentage.Valid)
 	assert.True(t, np.Product.SalePercentage.Decimal.Equal(pf.Product.SalePercentage.Decimal))
-	assert.Equal(t, np.Product.CategoryID, pf.Product.CategoryID)
+	assert.Equal(t, np.Product.CategoryId, pf.Product.CategoryId)
 	assert.Equal(t, np.Product.Description, pf.Product.Description)
 	assert.Equal(t, np.Product.Hidden, pf.Product.Hidden)
 	assert.Equal(t, np.Product.TargetGender, pf.Product.TargetGender)
 
 	// Assertions on SizeMeasurements
-	assert.Equal(t, len(np.SizeMeasurements), len(pf.Sizes))
-	for i, sm := range np.SizeMeasurements {
-		assert.True(t, sm.ProductSize.Quantity.Equal(pf.Sizes[i].Quantity))
-		assert.Equal(t, sm.ProductSize.SizeID, pf.Sizes[i].SizeID)
+	if assert.Equal(t, len(np.SizeMeasurements), len(pf.Sizes)) && assert.Equal(t, len(np.SizeMeasurements), len(pf.Measurements)) {
+		for i, sm := range np.SizeMeasurements {
+			assert.True(t, sm.ProductSize.Quantity.Equal(pf.Sizes[i].Quantity))
+			assert.Equal(t, sm.ProductSize.SizeId, pf.Sizes[i].SizeId)
 
-		// Assuming Measurements is a slice with at least one element
-		assert.True(t, sm.Measurements[0].MeasurementValue.Equal(pf.Measurements[i].MeasurementValue))
+			// Assuming Measurements is a slice with at least one element
+			assert.True(t, sm.Measurements[0].MeasurementValue.Equal(pf.Measurements[i].MeasurementValue))
+		}
 	}
 
 	// Assertions on Media
-	assert.Equal(t, len(np.Media), len(pf.Media))
-	for i, m := range np.Media {
-		assert.Equal(t, m.FullSize, pf.Media[i].FullSize)
-		assert.Equal(t, m.Thumbnail, pf.Media[i].Thumbnail)
-		assert.Equal(t, m.Compressed, pf.Media[i].Compressed)
+	if assert.Equal(t, len(np.MediaIds), len(pf.Media)) {
+		for i, mId := range np.MediaIds {
+			assert.Equal(t, mId, pf.Media[i].Id)
+		}
 	}
 
 	// Assertions on Tags
-	assert.Equal(t, len(np.Tags), len(pf.Tags))
-	for i, tag := range np.Tags {
-		assert.Equal(t, tag.Tag, pf.Tags[i].Tag)
+	if assert.Equal(t, len(np.Tags), len(pf.Tags)) {
+		for i, tag := range np.Tags {
+			assert.Equal(t, tag.Tag, pf.Tags[i].Tag)
+		}
 	}
 
-	// Hide the product
-	err = ps.HideProductById(ctx, newPrd.Product.ID, true)
-	assert.NoError(t, err)
-
-	// Fetch the product by ID
-	pf, err = ps.GetProductByIdShowHidden(ctx, newPrd.Product.ID)
-	assert.NoError(t, err)
-
-	assert.Equal(t, sql.NullBool{Valid: true, Bool: true}, pf.Product.Hidden)
-
-	// Set sale percentage
-	err = ps.SetSaleById(ctx, newPrd.Product.ID, decimal.NewFromInt(10))
-	assert.NoError(t, err)
-
-	// Fetch the product by ID
-	pf, err = ps.GetProductByIdShowHidden(ctx, newPrd.Product.ID)
-	assert.NoError(t, err)
-
-	assert.True(t, pf.Product.SalePercentage.Valid)
-	assert.True(t, decimal.NewFromInt(10).Equal(pf.Product.SalePercentage.Decimal))
-
-	// Update product preorder
-	err = ps.UpdateProductPreorder(ctx, newPrd.Product.ID, "preorder")
-	assert.NoError(t, err)
-
-	// Fetch the product by ID
-	pf, err = ps.GetProductByIdShowHidden(ctx, newPrd.Product.ID)
-	assert.NoError(t, err)
-
-	assert.Equal(t, sql.NullString{Valid: true, String: "preorder"}, pf.Product.Preorder)
+	// Update every field of the product
+	c, ok := getCategoryByName(entity.Jacket)
+	assert.True(t, ok)
+	height, ok := getMeasurementByName(entity.Height)
+	assert.True(t, ok)
+	newMediaId, err := getRandomMedia(ctx, db, 2)
+	assert.NoError(t, err)
+
+	preorder := time.Now().Add(time.Hour * 24 * 30).Truncate(time.Second)
+	np.Product.Preorder = sql.NullTime{Time: preorder, Valid: true}
+	np.Product.Name = "new name"
+	np.Product.SKU = "newsku"
+	np.Product.Color = "new color"
+	np.Product.ColorHex = "#000000"
+	np.Product.CountryOfOrigin = "new country of origin"
+	np.Product.Brand = "new brand"
+	np.Product.TargetGender = entity.Female
+	np.Product.ThumbnailMediaID = newMediaId
+	np.Product.Price = decimal.NewFromInt(1000)
+	np.Product.SalePercentage = decimal.NullDecimal{Decimal: decimal.NewFromInt(10), Valid: true}
+	np.Product.CategoryId = c.Id
+	np.Product.Description = "new description"
+	np.Product.Hidden = sql.NullBool{Bool: true, Valid: true}
+	np.SizeMeasurements[0].Measurements = append(np.SizeMeasurements[0].Measurements, entity.ProductMeasurementInsert{
+		MeasurementNameId: height.Id,
+		MeasurementValue:  decimal.NewFromInt(12),
+	})
+	np.MediaIds = append(np.MediaIds, newMediaId)
+	np.Tags = append(np.Tags, entity.ProductTagInsert{Tag: "new tag"})
 
-	ps.UpdateProductName(ctx, newPrd.Product.ID, "new name")
+	err = ps.UpdateProduct(ctx, np, prdId)
 	assert.NoError(t, err)
 
 	// Fetch the product by ID
-	pf, err = ps.GetProductByIdShowHidden(ctx, newPrd.Product.ID)
-	assert.NoError(t, err)
+	pf, err = ps.GetProductByIdShowHidden(ctx, prdId)
+	if !assert.NoError(t, err) {
+		return
+	}
 
+	assert.True(t, pf.Product.Preorder.Valid)
+	assert.True(t, preorder.Equal(pf.Product.Preorder.Time))
 	assert.Equal(t, "new name", pf.Product.Name)
-
-	ps.UpdateProductSKU(ctx, newPrd.Product.ID, "new sku")
-	assert.NoError(t, err)
-
-	// Fetch the product by ID
-	pf, err = ps.GetProductByIdShowHidden(ctx, newPrd.Product.ID)
-	assert.NoError(t, err)
-
-	assert.Equal(t, "new sku", pf.Product.SKU)
-
-	// invalid  color hex
-	err = ps.UpdateProductColorAndColorHex(ctx, newPrd.Product.ID, "new color", "new color hex")
-	assert.Error(t, err)
-
-	// valid color hex
-	err = ps.UpdateProductColorAndColorHex(ctx, newPrd.Product.ID, "new color", "#000000")
-	assert.NoError(t, err)
-
-	// Fetch the product by ID
-	pf, err = ps.GetProductByIdShowHidden(ctx, newPrd.Product.ID)
-	assert.NoError(t, err)
-
+	assert.Equal(t, "newsku", pf.Product.SKU)
 	assert.Equal(t, "new color", pf.Product.Color)
 	assert.Equal(t, "#000000", pf.Product.ColorHex)
-
-	// update country of origin
-	err = ps.UpdateProductCountryOfOrigin(ctx, newPrd.Product.ID, "new country of origin")
-	assert.NoError(t, err)
-
-	// Fetch the product by ID
-	pf, err = ps.GetProductByIdShowHidden(ctx, newPrd.Product.ID)
-	assert.NoError(t, err)
-
 	assert.Equal(t, "new country of origin", pf.Product.CountryOfOrigin)
-
-	// update brand
-	err = ps.UpdateProductBrand(ctx, newPrd.Product.ID, "new brand")
-	assert.NoError(t, err)
-
-	// Fetch the product by ID
-	pf, err = ps.GetProductByIdShowHidden(ctx, newPrd.Product.ID)
-	assert.NoError(t, err)
-
 	assert.Equal(t, "new brand", pf.Product.Brand)
-
-	err = ps.UpdateProductTargetGender(ctx, newPrd.Product.ID, entity.Female)
-	assert.NoError(t, err)
-
-	// Fetch the product by ID
-	pf, err = ps.GetProductByIdShowHidden(ctx, newPrd.Product.ID)
-	assert.NoError(t, err)
-
 	assert.Equal(t, entity.Female, pf.Product.TargetGender)
-
-	// update thumbnail
-	err = ps.UpdateProductThumbnail(ctx, newPrd.Product.ID, "new thumbnail")
-	assert.NoError(t, err)
-
-	// Fetch the product by ID
-	pf, err = ps.GetProductByIdShowHidden(ctx, newPrd.Product.ID)
-	assert.NoError(t, err)
-	assert.Equal(t, "new thumbnail", pf.Product.Thumbnail)
-
-	// update price
-	err = ps.UpdateProductPrice(ctx, newPrd.Product.ID, decimal.NewFromInt(1000))
-	assert.NoError(t, err)
-
-	// Fetch the product by ID
-	pf, err = ps.GetProductByIdShowHidden(ctx, newPrd.Product.ID)
-	assert.NoError(t, err)
+	assert.Equal(t, newMediaId, pf.Product.ThumbnailMediaID)
 	assert.True(t, decimal.NewFromInt(1000).Equal(pf.Product.Price))
-
-	// update sale
-	err = ps.UpdateProductSale(ctx, newPrd.Product.ID, decimal.NewFromInt(1))
-	assert.NoError(t, err)
-
-	// Fetch the product by ID
-	pf, err = ps.GetProductByIdShowHidden(ctx, newPrd.Product.ID)
-	assert.NoError(t, err)
-
 	assert.True(t, pf.Product.SalePercentage.Valid)
-	assert.True(t, decimal.NewFromInt(1).Equal(pf.Product.SalePercentage.Decimal))
-
-	// update category
-	c, err := getRandomCategory(db)
-	assert.NoError(t, err)
-	err = ps.UpdateProductCategory(ctx, newPrd.Product.ID, c.ID)
-	assert.NoError(t, err)
-
-	// Fetch the product by ID
-	pf, err = ps.GetProductByIdShowHidden(ctx, newPrd.Product.ID)
-	assert.NoError(t, err)
-	assert.Equal(t, c.ID, pf.Product.CategoryID)
-
-	// update description
-	err = ps.UpdateProductDescription(ctx, newPrd.Product.ID, "new description")
-	assert.NoError(t, err)
-
-	// Fetch the product by ID
-	pf, err = ps.GetProductByIdShowHidden(ctx, newPrd.Product.ID)
-	assert.NoError(t, err)
+	assert.True(t, decimal.NewFromInt(10).Equal(pf.Product.SalePercentage.Decimal))
+	assert.Equal(t, c.Id, pf.Product.CategoryId)
 	assert.Equal(t, "new description", pf.Product.Description)
-
-	assert.True(t, len(pf.Sizes) > 0)
-
-	ms, ok := db.cache.GetMeasurementsByName(entity.Height)
-	assert.True(t, ok)
-
-	err = ps.AddProductMeasurement(ctx, newPrd.Product.ID, pf.Sizes[0].SizeID, ms.ID, decimal.NewFromInt(12))
-	assert.NoError(t, err)
-
-	// Fetch the product by ID
-	pf, err = ps.GetProductByIdShowHidden(ctx, newPrd.Product.ID)
-	assert.NoError(t, err)
+	assert.Equal(t, sql.NullBool{Valid: true, Bool: true}, pf.Product.Hidden)
 
 	ok = false
 	for _, m := range pf.Measurements {
-		if m.MeasurementValue.Equal(decimal.NewFromInt(12)) {
+		if m.MeasurementNameId == height.Id && m.MeasurementValue.Equal(decimal.NewFromInt(12)) {
 			ok = true
 		}
 	}
 	assert.True(t, ok)
+	assert.Len(t, pf.Media, 2)
+	assert.Len(t, pf.Tags, 2)
 
-	for _, m := range pf.Measurements {
-		err = ps.DeleteProductMeasurement(ctx, m.ID)
-		assert.NoError(t, err)
-	}
+	// Remove the measurements, media and tags
+	np.SizeMeasurements[0].Measurements = nil
+	np.MediaIds = np.MediaIds[:1]
+	np.Tags = nil
 
-	// Fetch the product by ID
-	pf, err = ps.GetProductByIdShowHidden(ctx, newPrd.Product.ID)
+	err = ps.UpdateProduct(ctx, np, prdId)
 	assert.NoError(t, err)
 
-	assert.True(t, len(pf.Measurements) == 0)
-
-	// add new size
-
-	err = ps.AddProductTag(ctx, newPrd.Product.ID, "new tag")
-	assert.NoError(t, err)
-
-	// Fetch the product by ID
-	pf, err = ps.GetProductByIdShowHidden(ctx, newPrd.Product.ID)
-	assert.NoError(t, err)
-	assert.True(t, len(pf.Tags) == 2)
-
-	for _, ta := range pf.Tags {
-		err = ps.DeleteProductTag(ctx, pf.Product.ID, ta.Tag)
-		assert.NoError(t, err)
+	pf, err = ps.GetProductByIdShowHidden(ctx, prdId)
+	if !assert.NoError(t, err) {
+		return
 	}
+	assert.Len(t, pf.Sizes, 1)
+	assert.Len(t, pf.Measurements, 0)
+	assert.Len(t, pf.Media, 1)
+	assert.Len(t, pf.Tags, 0)
 
-	// Fetch the product by ID
-	pf, err = ps.GetProductByIdShowHidden(ctx, newPrd.Product.ID)
-	assert.NoError(t, err)
-	assert.True(t, len(pf.Tags) == 0)
-
-	assert.True(t, len(pf.Media) == 1)
-
-	err = ps.AddProductMedia(ctx, newPrd.Product.ID, "full_size", "thumbnail", "compressed")
-	assert.NoError(t, err)
-
-	pf, err = ps.GetProductByIdShowHidden(ctx, newPrd.Product.ID)
-	assert.NoError(t, err)
-
-	assert.True(t, len(pf.Media) == 2)
-
-	for _, m := range pf.Media {
-		err = ps.DeleteProductMedia(ctx, m.ID)
-		assert.NoError(t, err)
-	}
-
-	// delete size product
-	err = ps.DeleteProductById(ctx, newPrd.Product.ID)
+	// delete product
+	err = ps.DeleteProductById(ctx, prdId)
 	assert.NoError(t, err)
 
 	// Fetch the product by ID
-	_, err = ps.GetProductByIdShowHidden(ctx, newPrd.Product.ID)
+	_, err = ps.GetProductByIdShowHidden(ctx, prdId)
 	assert.Error(t, err)
 
 }
@@ -419,42 +315,52 @@ func TestProductStore_GetProductsPaged(t *testing.T) {
 	ps := db.Products()
 	ctx := context.Background()
 
-	teeCategory, ok := db.cache.GetCategoryByName(entity.TShirt)
-	assert.True(t, ok)
+	teeCategory, ok := getCategoryByName(entity.TShirt)
+	if !assert.True(t, ok) {
+		return
+	}
 
-	dressCategory, ok := db.cache.GetCategoryByName(entity.Dress)
-	assert.True(t, ok)
+	dressCategory, ok := getCategoryByName(entity.Dress)
+	if !assert.True(t, ok) {
+		return
+	}
 
-	xlSize, ok := db.cache.GetSizesByName(entity.XL)
-	assert.True(t, ok)
+	xlSize, ok := getSizeByName(entity.XL)
+	if !assert.True(t, ok) {
+		return
+	}
 
-	lSize, ok := db.cache.GetSizesByName(entity.L)
-	assert.True(t, ok)
+	lSize, ok := getSizeByName(entity.L)
+	if !assert.True(t, ok) {
+		return
+	}
 
 	for i := 0; i < 50; i++ {
 		np, err := randomProductInsert(db, i)
-		assert.NoError(t, err)
+		if !assert.NoError(t, err) {
+			return
+		}
 
 		np.Product.Price = decimal.NewFromInt(int64(i + 1))
 
 		// assuming there is no dresses
-		if np.Product.CategoryID == dressCategory.ID {
-			np.Product.CategoryID = teeCategory.ID
+		if np.Product.CategoryId == dressCategory.Id {
+			np.Product.CategoryId = teeCategory.Id
 		}
 
 		if i%3 == 0 {
 			np.SizeMeasurements[0].ProductSize.Quantity = decimal.NewFromInt(10)
-			np.SizeMeasurements[0].ProductSize.SizeID = lSize.ID
+			np.SizeMeasurements[0].ProductSize.SizeId = lSize.Id
 		}
 
 		if i%2 == 0 {
 			np.Product.Color = "green"
 			np.Product.ColorHex = "#00FF00"
-			np.Product.CategoryID = teeCategory.ID
+			np.Product.CategoryId = teeCategory.Id
 			np.Product.SalePercentage = decimal.NullDecimal{Decimal: decimal.NewFromInt(10), Valid: true}
 			np.SizeMeasurements[0].ProductSize.Quantity = decimal.NewFromInt(10)
-			np.SizeMeasurements[0].ProductSize.SizeID = xlSize.ID
-			np.Product.Preorder = sql.NullString{String: "preorder", Valid: true}
+			np.SizeMeasurements[0].ProductSize.SizeId = xlSize.Id
+			np.Product.Preorder = sql.NullTime{Time: time.Now().Add(time.Hour * 24 * 30), Valid: true}
 			np.Tags = []entity.ProductTagInsert{
 				{
 					Tag: "ss23",
@@ -493,11 +399,6 @@ func TestProductStore_GetProductsPaged(t *testing.T) {
 			offset:        5,
 			expectedCount: 5,
 			checkFunc: func(products []entity.Product) error {
-				// set sale for
-				for _, p := range products {
-					err := ps.SetSaleById(ctx, p.ID, decimal.NewFromInt(10))
-					assert.NoError(t, err)
-				}
 				return nil
 			},
 		},
@@ -523,7 +424,7 @@ func TestProductStore_GetProductsPaged(t *testing.T) {
 			},
 			orderFactor:   entity.Ascending,
 			expectedCount: 5,
-			checkFunc: func(products []entity.Product) error { // Add this block
+			checkFunc: func(products []entity.Product) error {
 				for i := 1; i < len(products); i++ {
 					if !products[i-1].Price.LessThanOrEqual(products[i].Price) {
 						return errors.New("products are not sorted in ascending order")
@@ -572,12 +473,12 @@ func TestProductStore_GetProductsPaged(t *testing.T) {
 			limit:  5,
 			offset: 0,
 			filterConditions: &entity.FilterConditions{
-				CategoryId: teeCategory.ID,
+				CategoryIds: []int{teeCategory.Id},
 			},
 			expectedCount: 5,
 			checkFunc: func(products []entity.Product) error {
 				for _, product := range products {
-					if product.CategoryID != teeCategory.ID {
+					if product.CategoryId != teeCategory.Id {
 						return errors.New("products are not filtered by category correctly")
 					}
 				}
@@ -589,7 +490,7 @@ func TestProductStore_GetProductsPaged(t *testing.T) {
 			limit:  5,
 			offset: 0,
 			filterConditions: &entity.FilterConditions{
-				CategoryId: dressCategory.ID,
+				CategoryIds: []int{dressCategory.Id},
 			},
 			expectedCount: 0,
 			checkFunc: func(products []entity.Product) error {
@@ -618,19 +519,19 @@ func TestProductStore_GetProductsPaged(t *testing.T) {
 			limit:  5,
 			offset: 0,
 			filterConditions: &entity.FilterConditions{
-				SizesIds: []int{xlSize.ID, lSize.ID},
+				SizesIds: []int{xlSize.Id, lSize.Id},
 			},
 			expectedCount: 5,
 			checkFunc: func(products []entity.Product) error {
 				for _, product := range products {
-					prd, err := db.GetProductByIdShowHidden(ctx, product.ID)
+					prd, err := db.GetProductByIdShowHidden(ctx, product.Id)
 					assert.NoError(t, err)
 
 					hasValidSize := false // Flag to check if the product has either "XL" or "L" sizes
 					for _, sz := range prd.Sizes {
-						s, ok := db.cache.GetSizeById(sz.SizeID)
+						s, ok := cache.GetSizeById(sz.SizeId)
 						assert.True(t, ok)
-						if s.Name == entity.XL || s.Name == entity.L {
+						if s.Size.Name == entity.XL || s.Size.Name == entity.L {
 							hasValidSize = true
 							break
 						}
@@ -654,7 +555,7 @@ func TestProductStore_GetProductsPaged(t *testing.T) {
 			expectedCount: 5,
 			checkFunc: func(products []entity.Product) error {
 				for _, product := range products {
-					if product.Preorder.String == "" {
+					if !product.Preorder.Valid {
 						return errors.New("products are not filtered by preorder status correctly")
 					}
 				}
@@ -670,20 +571,20 @@ func TestProductStore_GetProductsPaged(t *testing.T) {
 			},
 			expectedCount: 5,
 			checkFunc: func(products []entity.Product) error {
-				hasValidSize := false
+				hasValidTag := false
 				for _, product := range products {
-					prd, err := db.GetProductByIdShowHidden(ctx, product.ID)
+					prd, err := db.GetProductByIdShowHidden(ctx, product.Id)
 					assert.NoError(t, err)
 
 					for _, t := range prd.Tags {
 						if t.Tag == "ss23" {
-							hasValidSize = true
+							hasValidTag = true
 							break
 						}
 					}
 				}
-				if !hasValidSize {
-					return errors.New("products are not filtered by sizes correctly")
+				if !hasValidTag {
+					return errors.New("products are not filtered by tags correctly")
 				}
 				return nil
 			},
@@ -692,7 +593,7 @@ func TestProductStore_GetProductsPaged(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			fetchedPrds, err := ps.GetProductsPaged(ctx, tc.limit, tc.offset, tc.sortFactors, tc.orderFactor, tc.filterConditions, tc.showHidden)
+			fetchedPrds, _, err := ps.GetProductsPaged(ctx, tc.limit, tc.offset, tc.sortFactors, tc.orderFactor, tc.filterConditions, tc.showHidden)
 			if err != nil {
 				t.Fatalf("GetProductsPaged failed with error: %v", err)
 			}
@@ -715,42 +616,48 @@ func TestProductStore_StockTest(t *testing.T) {
 	ctx := context.Background()
 
 	np, err := randomProductInsert(db, 1)
-	assert.NoError(t, err)
+	if !assert.NoError(t, err) {
+		return
+	}
 
-	xlSize, ok := db.cache.GetSizesByName(entity.XL)
-	assert.True(t, ok)
+	xlSize, ok := getSizeByName(entity.XL)
+	if !assert.True(t, ok) {
+		return
+	}
 
-	lSize, ok := db.cache.GetSizesByName(entity.L)
-	assert.True(t, ok)
+	lSize, ok := getSizeByName(entity.L)
+	if !assert.True(t, ok) {
+		return
+	}
 
 	np.SizeMeasurements = []entity.SizeWithMeasurementInsert{
 		{
 			ProductSize: entity.ProductSizeInsert{
 				Quantity: decimal.NewFromInt(10),
-				SizeID:   xlSize.ID,
+				SizeId:   xlSize.Id,
 			},
 		},
 		{
 			ProductSize: entity.ProductSizeInsert{
 				Quantity: decimal.NewFromInt(15),
-				SizeID:   lSize.ID,
+				SizeId:   lSize.Id,
 			},
 		},
 	}
 
 	// Insert new product
-	prd, err := ps.AddProduct(ctx, np)
+	prdId, err := ps.AddProduct(ctx, np)
 	assert.NoError(t, err)
 
 	err = ps.ReduceStockForProductSizes(ctx, []entity.OrderItemInsert{
 		{
-			ProductID: prd.Product.ID,
-			SizeID:    xlSize.ID,
+			ProductId: prdId,
+			SizeId:    xlSize.Id,
 			Quantity:  decimal.NewFromInt32(1),
 		},
 		{
-			ProductID: prd.Product.ID,
-			SizeID:    lSize.ID,
+			ProductId: prdId,
+			SizeId:    lSize.Id,
 			Quantity:  decimal.NewFromInt32(1),
 		},
 	})
@@ -758,29 +665,30 @@ func TestProductStore_StockTest(t *testing.T) {
 
 	err = ps.RestoreStockForProductSizes(ctx, []entity.OrderItemInsert{
 		{
-			ProductID: prd.Product.ID,
-			SizeID:    xlSize.ID,
+			ProductId: prdId,
+			SizeId:    xlSize.Id,
 			Quantity:  decimal.NewFromInt32(1),
 		},
 		{
-			ProductID: prd.Product.ID,
-			SizeID:    lSize.ID,
+			ProductId: prdId,
+			SizeId:    lSize.Id,
 			Quantity:  decimal.NewFromInt32(1),
 		},
 	})
 	assert.NoError(t, err)
 
-	p, err := ps.GetProductByIdShowHidden(ctx, prd.Product.ID)
-
-	assert.NoError(t, err)
+	p, err := ps.GetProductByIdShowHidden(ctx, prdId)
+	if !assert.NoError(t, err) {
+		return
+	}
 
 	hasLSize := false
 	hasXLSize := false
 	for _, sz := range p.Sizes {
-		if sz.SizeID == xlSize.ID && sz.Quantity.Equal(decimal.NewFromInt(10)) {
+		if sz.SizeId == xlSize.Id && sz.Quantity.Equal(decimal.NewFromInt(10)) {
 			hasXLSize = true
 		}
-		if sz.SizeID == lSize.ID && sz.Quantity.Equal(decimal.NewFromInt(15)) {
+		if sz.SizeId == lSize.Id && sz.Quantity.Equal(decimal.NewFromInt(15)) {
 			hasLSize = true
 		}
 	}
@@ -791,20 +699,20 @@ func TestProductStore_StockTest(t *testing.T) {
 	// must fail because of insufficient stock
 	err = ps.ReduceStockForProductSizes(ctx, []entity.OrderItemInsert{
 		{
-			ProductID: prd.Product.ID,
-			SizeID:    xlSize.ID,
+			ProductId: prdId,
+			SizeId:    xlSize.Id,
 			Quantity:  decimal.NewFromInt32(11),
 		},
 	})
 	assert.Error(t, err)
 
-	err = ps.UpdateProductSizeStock(ctx, prd.Product.ID, xlSize.ID, 20)
+	err = ps.UpdateProductSizeStock(ctx, prdId, xlSize.Id, 20)
 	assert.NoError(t, err)
 
 	err = ps.ReduceStockForProductSizes(ctx, []entity.OrderItemInsert{
 		{
-			ProductID: prd.Product.ID,
-			SizeID:    xlSize.ID,
+			ProductId: prdId,
+			SizeId:    xlSize.Id,
 			Quantity:  decimal.NewFromInt32(11),
 		},
 	})
@@ -6,6 +6,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/jekabolt/grbpwr-manager/internal/cache"
 	"github.com/jekabolt/grbpwr-manager/internal/entity"
 	"github.com/shopspring/decimal"
 	"github.com/stretchr/testify/assert"
@@ -76,7 +77,7 @@ func TestPromo(t *testing.T) {
 	})
 
 	t.Run("GetAllPromoCodes", func(t *testing.T) {
-		promos, err := ps.ListPromos(ctx)
+		promos, err := ps.ListPromos(ctx, 10, 0, entity.Ascending)
 		assert.NoError(t, err)
 		assert.Len(t, promos, 5)
 	})
@@ -85,7 +86,7 @@ func TestPromo(t *testing.T) {
 		err := ps.DeletePromoCode(ctx, promoExpired.Code)
 		assert.NoError(t, err)
 
-		promos, err := ps.ListPromos(ctx)
+		promos, err := ps.ListPromos(ctx, 10, 0, entity.Ascending)
 		assert.NoError(t, err)
 		assert.Len(t, promos, 4)
 	})
@@ -94,8 +95,8 @@ func TestPromo(t *testing.T) {
 		err := ps.DisablePromoCode(ctx, promoFreeShip.Code)
 		assert.NoError(t, err)
 
-		promo, ok := db.cache.GetPromoByName(promoFreeShip.Code)
-		assert.False(t, ok)
+		promo, ok := cache.GetPromoByCode(promoFreeShip.Code)
+		assert.True(t, ok)
 		assert.Equal(t, promo.Code, promoFreeShip.Code)
 		assert.False(t, promo.Allowed)
 
@@ -103,7 +104,7 @@ func TestPromo(t *testing.T) {
 
 	t.Run("DisablePromoVoucher", func(t *testing.T) {
 
-		promos, err := ps.ListPromos(ctx)
+		promos, err := ps.ListPromos(ctx, 10, 0, entity.Ascending)
 		assert.NoError(t, err)
 		pr := promos[0]
 		for _, promo := range promos {
@@ -113,14 +114,14 @@ func TestPromo(t *testing.T) {
 		}
 
 		err = ps.DisableVoucher(ctx, sql.NullInt32{
-			Int32: int32(pr.ID),
+			Int32: int32(pr.Id),
 			Valid: true,
 		})
 		assert.NoError(t, err)
 
-		promo, ok := db.cache.GetPromoByName(promoFreeShip.Code)
-		assert.False(t, ok)
-		assert.Equal(t, promo.Code, promoFreeShip.Code)
+		promo, ok := cache.GetPromoByCode(promoVoucher.Code)
+		assert.True(t, ok)
+		assert.Equal(t, promo.Code, promoVoucher.Code)
 		assert.False(t, promo.Allowed)
 
 	})
@@ -0,0 +1,365 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"testing"
+
+	"github.com/jekabolt/grbpwr-manager/internal/cache"
+	"github.com/jekabolt/grbpwr-manager/internal/entity"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRefundOrder(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+	os := db.Order()
+
+	p, xlSize, _, ok := addProductWithSizes(ctx, t, db, 2, 1)
+	if !ok {
+		return
+	}
+	order, ok := insertPaidOrder(ctx, t, db, []entity.OrderItemInsert{
+		{ProductId: p.Product.Id, Quantity: decimal.NewFromInt(1), SizeId: xlSize.Id},
+	}, "", 1)
+	if !ok {
+		return
+	}
+	assert.True(t, sizeQuantity(ctx, t, db, p.Product.Id, xlSize.Id).Equal(decimal.NewFromInt(1)))
+
+	orderStatusId := func() int {
+		o, err := getOrderByUUID(ctx, db, order.UUID)
+		assert.NoError(t, err)
+		return o.OrderStatusId
+	}
+
+	// partial refund is recorded pending and applied once issued
+	partial, err := os.RefundOrder(ctx, order.UUID, decimal.NewFromInt(10), nil, "partial", "admin")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, entity.RefundPending, partial.Status)
+	assert.False(t, partial.IssuedAt.Valid)
+
+	partial, err = os.UpdateRefund(ctx, partial.Id, entity.RefundSucceeded, "re_partial", "admin")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, entity.RefundSucceeded, partial.Status)
+	assert.True(t, partial.IssuedAt.Valid)
+	assert.Equal(t, cache.OrderStatusConfirmed.Status.Id, orderStatusId())
+
+	// the refund is issued once
+	_, err = os.UpdateRefund(ctx, partial.Id, entity.RefundSucceeded, "re_partial_2", "admin")
+	assert.Error(t, err)
+
+	remaining := order.TotalPrice.Sub(decimal.NewFromInt(10))
+
+	// refund can't exceed the paid amount
+	_, err = os.RefundOrder(ctx, order.UUID, remaining.Add(decimal.NewFromInt(1)), nil, "over", "admin")
+	assert.Error(t, err)
+
+	// failed refund doesn't restock the items and doesn't refund the order
+	failed, err := os.RefundOrder(ctx, order.UUID, decimal.Zero, orderRestockItems(ctx, t, db, order.UUID), "failed", "admin")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.True(t, failed.Amount.Equal(remaining))
+
+	// the pending refund counts against the paid amount
+	_, err = os.RefundOrder(ctx, order.UUID, decimal.NewFromInt(1), nil, "over", "admin")
+	assert.Error(t, err)
+
+	_, err = os.UpdateRefund(ctx, failed.Id, entity.RefundFailed, "", "admin")
+	assert.NoError(t, err)
+	assert.Equal(t, cache.OrderStatusConfirmed.Status.Id, orderStatusId())
+	assert.True(t, sizeQuantity(ctx, t, db, p.Product.Id, xlSize.Id).Equal(decimal.NewFromInt(1)))
+
+	// full refund of the rest restocks the items and refunds the order
+	full, err := os.RefundOrder(ctx, order.UUID, decimal.Zero, orderRestockItems(ctx, t, db, order.UUID), "full", "admin")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.True(t, full.Amount.Equal(remaining))
+	assert.Equal(t, cache.OrderStatusConfirmed.Status.Id, orderStatusId())
+
+	_, err = os.UpdateRefund(ctx, full.Id, entity.RefundSucceeded, "re_full", "admin")
+	assert.NoError(t, err)
+	assert.Equal(t, cache.OrderStatusRefunded.Status.Id, orderStatusId())
+	assert.True(t, sizeQuantity(ctx, t, db, p.Product.Id, xlSize.Id).Equal(decimal.NewFromInt(2)))
+
+	_, err = os.RefundOrder(ctx, order.UUID, decimal.Zero, nil, "again", "admin")
+	assert.Error(t, err)
+
+	refunds, err := os.GetRefundsByOrderUUID(ctx, order.UUID)
+	assert.NoError(t, err)
+	assert.Len(t, refunds, 3)
+}
+
+func TestRefundOrderRestockedOnce(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+	os := db.Order()
+
+	p, xlSize, _, ok := addProductWithSizes(ctx, t, db, 1, 1)
+	if !ok {
+		return
+	}
+	order, ok := insertPaidOrder(ctx, t, db, []entity.OrderItemInsert{
+		{ProductId: p.Product.Id, Quantity: decimal.NewFromInt(1), SizeId: xlSize.Id},
+	}, "", 1)
+	if !ok {
+		return
+	}
+
+	refund, err := os.RefundOrder(ctx, order.UUID, decimal.NewFromInt(1), orderRestockItems(ctx, t, db, order.UUID), "restock", "admin")
+	if !assert.NoError(t, err) {
+		return
+	}
+	// crypto refunds are issued pending the manual transfer
+	refund, err = os.UpdateRefund(ctx, refund.Id, entity.RefundPending, "", "admin")
+	assert.NoError(t, err)
+	assert.True(t, refund.IssuedAt.Valid)
+	assert.True(t, sizeQuantity(ctx, t, db, p.Product.Id, xlSize.Id).Equal(decimal.NewFromInt(1)))
+
+	// the items are already restocked
+	_, err = os.RefundOrder(ctx, order.UUID, decimal.NewFromInt(1), orderRestockItems(ctx, t, db, order.UUID), "restock", "admin")
+	assert.Error(t, err)
+}
+
+// orderRestockItems returns every item of the order with its ordered quantity to restock.
+func orderRestockItems(ctx context.Context, t *testing.T, db *MYSQLStore, orderUUID string) []entity.RefundItemInsert {
+	of, err := db.Order().GetOrderFullByUUID(ctx, orderUUID)
+	if !assert.NoError(t, err) {
+		return nil
+	}
+	items := make([]entity.RefundItemInsert, 0, len(of.OrderItems))
+	for _, oi := range of.OrderItems {
+		items = append(items, entity.RefundItemInsert{OrderItemId: oi.Id, Quantity: int(oi.Quantity.IntPart())})
+	}
+	return items
+}
+
+func TestRefundOrderRestockItems(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+	os := db.Order()
+
+	p, xlSize, lSize, ok := addProductWithSizes(ctx, t, db, 3, 1)
+	if !ok {
+		return
+	}
+	of, ok := insertShippedOrder(ctx, t, db, []entity.OrderItemInsert{
+		{ProductId: p.Product.Id, Quantity: decimal.NewFromInt(3), SizeId: xlSize.Id},
+	}, "", 1)
+	if !ok {
+		return
+	}
+	other, ok := insertPaidOrder(ctx, t, db, []entity.OrderItemInsert{
+		{ProductId: p.Product.Id, Quantity: decimal.NewFromInt(1), SizeId: lSize.Id},
+	}, "", 2)
+	if !ok {
+		return
+	}
+	itemId := of.OrderItems[0].Id
+	assert.True(t, sizeQuantity(ctx, t, db, p.Product.Id, xlSize.Id).IsZero())
+
+	// one item is returned and restocked on receive
+	rrf, err := db.Returns().AddReturnRequest(ctx, &entity.ReturnRequestInsert{
+		OrderUUID: of.Order.UUID,
+		Email:     of.Buyer.Email,
+		Type:      entity.ReturnTypeReturn,
+		Items:     []entity.ReturnItemInsert{{OrderItemId: itemId, Quantity: 1, Reason: entity.ReturnReasonDamaged}},
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, db.Returns().ApproveReturnRequest(ctx, rrf.ReturnRequest.UUID))
+	assert.NoError(t, db.Returns().ReceiveReturnRequest(ctx, rrf.ReturnRequest.UUID))
+	assert.True(t, sizeQuantity(ctx, t, db, p.Product.Id, xlSize.Id).Equal(decimal.NewFromInt(1)))
+
+	restock := func(id, quantity int) []entity.RefundItemInsert {
+		return []entity.RefundItemInsert{{OrderItemId: id, Quantity: quantity}}
+	}
+
+	// the returned item can't be restocked again
+	_, err = os.RefundOrder(ctx, of.Order.UUID, decimal.NewFromInt(1), restock(itemId, 3), "restock", "admin")
+	assert.Error(t, err)
+
+	// the item of the other order can't be restocked
+	otherFull, err := os.GetOrderFullByUUID(ctx, other.UUID)
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, err = os.RefundOrder(ctx, of.Order.UUID, decimal.NewFromInt(1), restock(otherFull.OrderItems[0].Id, 1), "restock", "admin")
+	assert.Error(t, err)
+
+	// the partial refund restocks only its items once issued
+	refund, err := os.RefundOrder(ctx, of.Order.UUID, decimal.NewFromInt(1), restock(itemId, 1), "restock", "admin")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.True(t, refund.Restocked)
+	assert.True(t, sizeQuantity(ctx, t, db, p.Product.Id, xlSize.Id).Equal(decimal.NewFromInt(1)))
+
+	_, err = os.UpdateRefund(ctx, refund.Id, entity.RefundSucceeded, "re_restock", "admin")
+	assert.NoError(t, err)
+	assert.True(t, sizeQuantity(ctx, t, db, p.Product.Id, xlSize.Id).Equal(decimal.NewFromInt(2)))
+
+	// only the last item is left to restock
+	_, err = os.RefundOrder(ctx, of.Order.UUID, decimal.NewFromInt(1), restock(itemId, 2), "restock", "admin")
+	assert.Error(t, err)
+
+	refund, err = os.RefundOrder(ctx, of.Order.UUID, decimal.NewFromInt(1), restock(itemId, 1), "restock", "admin")
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, err = os.UpdateRefund(ctx, refund.Id, entity.RefundSucceeded, "re_restock_last", "admin")
+	assert.NoError(t, err)
+	assert.True(t, sizeQuantity(ctx, t, db, p.Product.Id, xlSize.Id).Equal(decimal.NewFromInt(3)))
+}
+
+// insertGiftCardOrder creates the order of the items paid with the gift card of the given balance,
+// the part of the total the card doesn't cover is paid with the crypto invoice.
+func insertGiftCardOrder(ctx context.Context, t *testing.T, db *MYSQLStore, items []entity.OrderItemInsert, balance decimal.Decimal, i int) (*entity.Order, string, bool) {
+	gc, err := db.GiftCards().AddGiftCard(ctx, &entity.GiftCardInsert{
+		InitialBalance: balance,
+		Allowed:        true,
+	})
+	if !assert.NoError(t, err) {
+		return nil, "", false
+	}
+
+	no, _, err := newOrder(items, "", i)
+	if !assert.NoError(t, err) {
+		return nil, "", false
+	}
+	no.GiftCardCode = gc.Code
+	order, _, err := db.Order().CreateOrder(ctx, no, false)
+	if !assert.NoError(t, err) {
+		return nil, "", false
+	}
+	if order.AmountDue().IsZero() {
+		return order, gc.Code, true
+	}
+
+	pm, err := getCryptoPaymentMethod()
+	if !assert.NoError(t, err) {
+		return nil, "", false
+	}
+	_, err = db.Order().InsertCryptoInvoice(ctx, order.UUID, fmt.Sprintf("payee-%d", order.Id), *pm)
+	if !assert.NoError(t, err) {
+		return nil, "", false
+	}
+	_, err = db.Order().OrderPaymentDone(ctx, order.UUID, &entity.Payment{
+		PaymentInsert: entity.PaymentInsert{
+			PaymentMethodID:   pm.Id,
+			TransactionID:     sql.NullString{String: fmt.Sprintf("tx-%d", order.Id), Valid: true},
+			TransactionAmount: order.AmountDue(),
+			Payer:             sql.NullString{String: "payer", Valid: true},
+			Payee:             sql.NullString{String: fmt.Sprintf("payee-%d", order.Id), Valid: true},
+		},
+	})
+	if !assert.NoError(t, err) {
+		return nil, "", false
+	}
+	return order, gc.Code, true
+}
+
+func TestRefundOrderGiftCard(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+	os := db.Order()
+
+	p, xlSize, _, ok := addProductWithSizes(ctx, t, db, 4, 1)
+	if !ok {
+		return
+	}
+	items := []entity.OrderItemInsert{
+		{ProductId: p.Product.Id, Quantity: decimal.NewFromInt(1), SizeId: xlSize.Id},
+	}
+
+	orderStatusId := func(uuid string) int {
+		o, err := getOrderByUUID(ctx, db, uuid)
+		assert.NoError(t, err)
+		return o.OrderStatusId
+	}
+	balance := func(code string) decimal.Decimal {
+		gcf, err := db.GiftCards().GetGiftCardByCode(ctx, code)
+		assert.NoError(t, err)
+		return gcf.GiftCard.Balance
+	}
+
+	t.Run("GiftCardOnly", func(t *testing.T) {
+		order, code, ok := insertGiftCardOrder(ctx, t, db, items, decimal.NewFromInt(100000), 1)
+		if !ok {
+			return
+		}
+		assert.Equal(t, cache.OrderStatusConfirmed.Status.Id, orderStatusId(order.UUID))
+		total := order.TotalPriceDecimal()
+		assert.True(t, balance(code).Equal(decimal.NewFromInt(100000).Sub(total)))
+
+		// there is nothing to refund through the provider, the card is credited and the refund is issued at once
+		refund, err := os.RefundOrder(ctx, order.UUID, decimal.Zero, orderRestockItems(ctx, t, db, order.UUID), "gift card", "admin")
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.True(t, refund.Amount.IsZero(), refund.Amount.String())
+		assert.True(t, refund.GiftCardAmount.Equal(total), refund.GiftCardAmount.String())
+		assert.Equal(t, entity.RefundSucceeded, refund.Status)
+		assert.True(t, refund.IssuedAt.Valid)
+
+		assert.True(t, balance(code).Equal(decimal.NewFromInt(100000)))
+		assert.Equal(t, cache.OrderStatusRefunded.Status.Id, orderStatusId(order.UUID))
+		assert.True(t, sizeQuantity(ctx, t, db, p.Product.Id, xlSize.Id).Equal(decimal.NewFromInt(4)))
+
+		_, err = os.RefundOrder(ctx, order.UUID, decimal.Zero, nil, "again", "admin")
+		assert.Error(t, err)
+	})
+
+	t.Run("PartialGiftCard", func(t *testing.T) {
+		order, code, ok := insertGiftCardOrder(ctx, t, db, items, decimal.NewFromInt(10), 2)
+		if !ok {
+			return
+		}
+		assert.True(t, order.GiftCardAmount.Equal(decimal.NewFromInt(10)))
+		assert.True(t, balance(code).IsZero())
+		due := order.AmountDue()
+
+		// refund can't exceed the paid amount and the gift card amount
+		_, err := os.RefundOrder(ctx, order.UUID, order.TotalPriceDecimal().Add(decimal.NewFromInt(1)), nil, "over", "admin")
+		assert.Error(t, err)
+
+		// the paid part is refunded through the provider first, the rest is credited to the card at once
+		refund, err := os.RefundOrder(ctx, order.UUID, due.Add(decimal.NewFromInt(4)), nil, "partial", "admin")
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.True(t, refund.Amount.Equal(due), refund.Amount.String())
+		assert.True(t, refund.GiftCardAmount.Equal(decimal.NewFromInt(4)), refund.GiftCardAmount.String())
+		assert.Equal(t, entity.RefundPending, refund.Status)
+		assert.False(t, refund.IssuedAt.Valid)
+		assert.True(t, balance(code).Equal(decimal.NewFromInt(4)))
+
+		_, err = os.UpdateRefund(ctx, refund.Id, entity.RefundSucceeded, "re_gift_card_partial", "admin")
+		assert.NoError(t, err)
+		// the rest of the gift card amount is not credited yet
+		assert.Equal(t, cache.OrderStatusConfirmed.Status.Id, orderStatusId(order.UUID))
+
+		rest, err := os.RefundOrder(ctx, order.UUID, decimal.Zero, orderRestockItems(ctx, t, db, order.UUID), "rest", "admin")
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.True(t, rest.Amount.IsZero(), rest.Amount.String())
+		assert.True(t, rest.GiftCardAmount.Equal(decimal.NewFromInt(6)), rest.GiftCardAmount.String())
+		assert.True(t, rest.IssuedAt.Valid)
+		assert.True(t, balance(code).Equal(decimal.NewFromInt(10)))
+		assert.Equal(t, cache.OrderStatusRefunded.Status.Id, orderStatusId(order.UUID))
+
+		refunds, err := os.GetRefundsByOrderUUID(ctx, order.UUID)
+		assert.NoError(t, err)
+		assert.Len(t, refunds, 2)
+	})
+}
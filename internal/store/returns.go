@@ -0,0 +1,444 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/jekabolt/grbpwr-manager/internal/cache"
+	"github.com/jekabolt/grbpwr-manager/internal/dependency"
+	"github.com/jekabolt/grbpwr-manager/internal/entity"
+	"github.com/shopspring/decimal"
+)
+
+type returnsStore struct {
+	*MYSQLStore
+}
+
+// Returns returns an object implementing returns interface
+func (ms *MYSQLStore) Returns() dependency.Returns {
+	return &returnsStore{
+		MYSQLStore: ms,
+	}
+}
+
+// returnableItem is the order item with the quantity which is not returned yet
+type returnableItem struct {
+	Id        int `db:"id"`
+	ProductId int `db:"product_id"`
+	SizeId    int `db:"size_id"`
+	Quantity  int `db:"quantity"`
+}
+
+func getReturnableItems(ctx context.Context, rep dependency.Repository, orderId int) (map[int]returnableItem, error) {
+	query := `
+	SELECT
+		oi.id,
+		oi.product_id,
+		oi.size_id,
+		oi.quantity - COALESCE((
+			SELECT SUM(ri.quantity)
+			FROM return_item ri
+			JOIN return_request rr ON ri.return_request_id = rr.id
+			WHERE ri.order_item_id = oi.id AND rr.status NOT IN (:rejected, :cancelled)
+		), 0) AS quantity
+	FROM order_item oi
+	WHERE oi.order_id = :orderId`
+
+	items, err := QueryListNamed[returnableItem](ctx, rep.DB(), query, map[string]any{
+		"orderId":   orderId,
+		"rejected":  entity.ReturnRejected,
+		"cancelled": entity.ReturnCancelled,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	im := make(map[int]returnableItem, len(items))
+	for _, i := range items {
+		im[i.Id] = i
+	}
+	return im, nil
+}
+
+// AddReturnRequest opens the return request for the items of the shipped or delivered order,
+// the email must match the email of the order buyer.
+func (rs *returnsStore) AddReturnRequest(ctx context.Context, rri *entity.ReturnRequestInsert) (*entity.ReturnRequestFull, error) {
+	if !entity.ValidReturnRequestTypes[rri.Type] {
+		return nil, fmt.Errorf("invalid return request type: %s", rri.Type)
+	}
+	if len(rri.Items) == 0 {
+		return nil, fmt.Errorf("return request must contain at least one item")
+	}
+
+	var rrf *entity.ReturnRequestFull
+	err := rs.Tx(ctx, func(ctx context.Context, rep dependency.Repository) error {
+		order, err := getOrderByUUID(ctx, rep, rri.OrderUUID)
+		if err != nil {
+			return fmt.Errorf("can't get order by uuid: %w", err)
+		}
+
+		buyer, err := getBuyerById(ctx, rep, order.Id)
+		if err != nil {
+			return fmt.Errorf("can't get buyer by id: %w", err)
+		}
+		if !strings.EqualFold(buyer.Email, strings.TrimSpace(rri.Email)) {
+			return fmt.Errorf("email does not match the order buyer")
+		}
+
+		orderStatus, ok := cache.GetOrderStatusById(order.OrderStatusId)
+		if !ok {
+			return fmt.Errorf("order status is not exists: order status id %d", order.OrderStatusId)
+		}
+		if orderStatus.Status.Name != entity.Shipped && orderStatus.Status.Name != entity.Delivered {
+			return fmt.Errorf("order status can be only in (Shipped, Delivered): order status %s", orderStatus.Status.Name)
+		}
+
+		returnable, err := getReturnableItems(ctx, rep, order.Id)
+		if err != nil {
+			return fmt.Errorf("can't get returnable items: %w", err)
+		}
+
+		requested := map[int]int{}
+		for _, item := range rri.Items {
+			if !entity.ValidReturnReasons[item.Reason] {
+				return fmt.Errorf("invalid return reason: %s", item.Reason)
+			}
+			oi, ok := returnable[item.OrderItemId]
+			if !ok {
+				return fmt.Errorf("order item %d is not in the order", item.OrderItemId)
+			}
+			requested[item.OrderItemId] += item.Quantity
+			if item.Quantity <= 0 || requested[item.OrderItemId] > oi.Quantity {
+				return fmt.Errorf("invalid quantity for order item %d: can return up to %d", item.OrderItemId, oi.Quantity)
+			}
+
+			switch rri.Type {
+			case entity.ReturnTypeExchange:
+				if !item.ExchangeSizeId.Valid || int(item.ExchangeSizeId.Int32) == oi.SizeId {
+					return fmt.Errorf("exchange size is required for order item %d", item.OrderItemId)
+				}
+				if _, ok := cache.GetSizeById(int(item.ExchangeSizeId.Int32)); !ok {
+					return fmt.Errorf("exchange size %d does not exist", item.ExchangeSizeId.Int32)
+				}
+			case entity.ReturnTypeReturn:
+				if item.ExchangeSizeId.Valid {
+					return fmt.Errorf("exchange size is not allowed for return")
+				}
+			}
+		}
+
+		query := `
+		INSERT INTO return_request (uuid, order_id, type, status, comment)
+		VALUES (:uuid, :orderId, :type, :status, :comment)`
+		id, err := ExecNamedLastId(ctx, rep.DB(), query, map[string]any{
+			"uuid":    uuid.New().String(),
+			"orderId": order.Id,
+			"type":    rri.Type,
+			"status":  entity.ReturnRequested,
+			"comment": sql.NullString{String: rri.Comment, Valid: rri.Comment != ""},
+		})
+		if err != nil {
+			return fmt.Errorf("can't insert return request: %w", err)
+		}
+
+		for _, item := range rri.Items {
+			query := `
+			INSERT INTO return_item (return_request_id, order_item_id, quantity, reason, exchange_size_id)
+			VALUES (:returnRequestId, :orderItemId, :quantity, :reason, :exchangeSizeId)`
+			err := ExecNamed(ctx, rep.DB(), query, map[string]any{
+				"returnRequestId": id,
+				"orderItemId":     item.OrderItemId,
+				"quantity":        item.Quantity,
+				"reason":          item.Reason,
+				"exchangeSizeId":  item.ExchangeSizeId,
+			})
+			if err != nil {
+				return fmt.Errorf("can't insert return item: %w", err)
+			}
+		}
+
+		rrf, err = getReturnRequestFull(ctx, rep, "rr.id = :id", map[string]any{"id": id})
+		if err != nil {
+			return fmt.Errorf("can't get return request: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rrf, nil
+}
+
+func getReturnRequestFull(ctx context.Context, rep dependency.Repository, where string, params map[string]any) (*entity.ReturnRequestFull, error) {
+	query := fmt.Sprintf(`
+	SELECT rr.*, co.uuid AS order_uuid
+	FROM return_request rr
+	JOIN customer_order co ON rr.order_id = co.id
+	WHERE %s`, where)
+
+	rr, err := QueryNamedOne[entity.ReturnRequest](ctx, rep.DB(), query, params)
+	if err != nil {
+		return nil, err
+	}
+
+	query = `
+	SELECT
+		ri.*,
+		oi.product_id,
+		oi.size_id,
+		oi.product_price * (1 - COALESCE(oi.product_sale_percentage, 0) / 100) AS product_price_with_sale,
+		COALESCE(pc.discount, 0) AS promo_discount,
+		CASE WHEN co.tax_inclusive THEN 0 ELSE oi.tax_amount / oi.quantity END AS unit_tax_surcharge
+	FROM return_item ri
+	JOIN order_item oi ON ri.order_item_id = oi.id
+	JOIN customer_order co ON oi.order_id = co.id
+	LEFT JOIN promo_code pc ON co.promo_id = pc.id
+	WHERE ri.return_request_id = :returnRequestId`
+
+	items, err := QueryListNamed[entity.ReturnItem](ctx, rep.DB(), query, map[string]any{
+		"returnRequestId": rr.Id,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't get return items: %w", err)
+	}
+
+	rrf := &entity.ReturnRequestFull{
+		ReturnRequest: rr,
+		Items:         items,
+	}
+
+	query = `SELECT * FROM shipment WHERE return_request_id = :returnRequestId`
+	s, err := QueryNamedOne[entity.Shipment](ctx, rep.DB(), query, map[string]any{
+		"returnRequestId": rr.Id,
+	})
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("can't get exchange shipment: %w", err)
+	}
+	if err == nil {
+		rrf.ExchangeShipment = &s
+	}
+
+	return rrf, nil
+}
+
+// GetReturnRequestByUUID returns the return request with its items and the exchange shipment.
+func (rs *returnsStore) GetReturnRequestByUUID(ctx context.Context, uuid string) (*entity.ReturnRequestFull, error) {
+	rrf, err := getReturnRequestFull(ctx, rs, "rr.uuid = :uuid", map[string]any{"uuid": uuid})
+	if err != nil {
+		return nil, fmt.Errorf("can't get return request: %w", err)
+	}
+	return rrf, nil
+}
+
+// ListReturnRequests returns the return requests with the given status, all if status is empty.
+func (rs *returnsStore) ListReturnRequests(ctx context.Context, st entity.ReturnRequestStatus, limit int, offset int, of entity.OrderFactor) ([]entity.ReturnRequest, error) {
+	query := fmt.Sprintf(`
+	SELECT rr.*, co.uuid AS order_uuid
+	FROM return_request rr
+	JOIN customer_order co ON rr.order_id = co.id
+	WHERE (:status = '' OR rr.status = :status)
+	ORDER BY rr.created_at %s
+	LIMIT :limit
+	OFFSET :offset`, of.String())
+
+	rrs, err := QueryListNamed[entity.ReturnRequest](ctx, rs.DB(), query, map[string]any{
+		"status": st,
+		"limit":  limit,
+		"offset": offset,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't list return requests: %w", err)
+	}
+	return rrs, nil
+}
+
+func updateReturnRequestStatus(ctx context.Context, rep dependency.Repository, id int, st entity.ReturnRequestStatus) error {
+	query := `UPDATE return_request SET status = :status WHERE id = :id`
+	err := ExecNamed(ctx, rep.DB(), query, map[string]any{
+		"id":     id,
+		"status": st,
+	})
+	if err != nil {
+		return fmt.Errorf("can't update return request status: %w", err)
+	}
+	return nil
+}
+
+// transitReturnRequest moves the return request from one of the given statuses to the next one,
+// the action is run in the same transaction before the status is updated.
+func (rs *returnsStore) transitReturnRequest(ctx context.Context, uuid string, from entity.ReturnRequestStatus, to entity.ReturnRequestStatus,
+	action func(ctx context.Context, rep dependency.Repository, rrf *entity.ReturnRequestFull) error) error {
+	return rs.Tx(ctx, func(ctx context.Context, rep dependency.Repository) error {
+		return moveReturnRequest(ctx, rep, uuid, from, to, action)
+	})
+}
+
+// moveReturnRequest moves the return request from the status to the next one running the action before,
+// it must be called within a transaction.
+func moveReturnRequest(ctx context.Context, rep dependency.Repository, uuid string, from entity.ReturnRequestStatus, to entity.ReturnRequestStatus,
+	action func(ctx context.Context, rep dependency.Repository, rrf *entity.ReturnRequestFull) error) error {
+	rrf, err := getReturnRequestFull(ctx, rep, "rr.uuid = :uuid FOR UPDATE", map[string]any{"uuid": uuid})
+	if err != nil {
+		return fmt.Errorf("can't get return request: %w", err)
+	}
+
+	if rrf.ReturnRequest.Status != from {
+		return fmt.Errorf("return request status must be %s: status %s", from, rrf.ReturnRequest.Status)
+	}
+
+	if action != nil {
+		if err := action(ctx, rep, rrf); err != nil {
+			return err
+		}
+	}
+
+	return updateReturnRequestStatus(ctx, rep, rrf.ReturnRequest.Id, to)
+}
+
+// ApproveReturnRequest approves the requested return, for the exchange the replacement sizes are
+// reserved and a zero cost shipment with the carrier of the order is created.
+func (rs *returnsStore) ApproveReturnRequest(ctx context.Context, uuid string) error {
+	return rs.transitReturnRequest(ctx, uuid, entity.ReturnRequested, entity.ReturnApproved,
+		func(ctx context.Context, rep dependency.Repository, rrf *entity.ReturnRequestFull) error {
+			if rrf.ReturnRequest.Type != entity.ReturnTypeExchange {
+				return nil
+			}
+
+			replacements := make([]entity.OrderItemInsert, 0, len(rrf.Items))
+			for _, i := range rrf.Items {
+				replacements = append(replacements, entity.OrderItemInsert{
+					ProductId: i.ProductId,
+					SizeId:    int(i.ExchangeSizeId.Int32),
+					Quantity:  decimal.NewFromInt(int64(i.Quantity)),
+				})
+			}
+			err := rep.Products().ReduceStockForProductSizes(ctx, replacements)
+			if err != nil {
+				return fmt.Errorf("can't reserve exchange sizes: %w", err)
+			}
+
+			shipment, err := getOrderShipment(ctx, rep, rrf.ReturnRequest.OrderId)
+			if err != nil {
+				return fmt.Errorf("can't get order shipment: %w", err)
+			}
+
+			query := `
+			INSERT INTO shipment (carrier_id, order_id, cost, return_request_id)
+			VALUES (:carrierId, :orderId, 0, :returnRequestId)`
+			err = ExecNamed(ctx, rep.DB(), query, map[string]any{
+				"carrierId":       shipment.CarrierId,
+				"orderId":         rrf.ReturnRequest.OrderId,
+				"returnRequestId": rrf.ReturnRequest.Id,
+			})
+			if err != nil {
+				return fmt.Errorf("can't insert exchange shipment: %w", err)
+			}
+			return nil
+		})
+}
+
+// RejectReturnRequest rejects the requested return.
+func (rs *returnsStore) RejectReturnRequest(ctx context.Context, uuid string) error {
+	return rs.transitReturnRequest(ctx, uuid, entity.ReturnRequested, entity.ReturnRejected, nil)
+}
+
+// ReceiveReturnRequest marks the returned items as received and returns them to stock.
+func (rs *returnsStore) ReceiveReturnRequest(ctx context.Context, uuid string) error {
+	return rs.transitReturnRequest(ctx, uuid, entity.ReturnApproved, entity.ReturnReceived,
+		func(ctx context.Context, rep dependency.Repository, rrf *entity.ReturnRequestFull) error {
+			returned := make([]entity.OrderItemInsert, 0, len(rrf.Items))
+			for _, i := range rrf.Items {
+				returned = append(returned, entity.OrderItemInsert{
+					ProductId: i.ProductId,
+					SizeId:    i.SizeId,
+					Quantity:  decimal.NewFromInt(int64(i.Quantity)),
+				})
+			}
+			err := rep.Products().RestoreStockForProductSizes(ctx, returned)
+			if err != nil {
+				return fmt.Errorf("can't restore stock for returned items: %w", err)
+			}
+			return nil
+		})
+}
+
+// CompleteReturnRequest completes the received return request, the return is completed as refunded
+// with the given refund and the exchange as exchanged. It must be called within a transaction.
+func (rs *returnsStore) CompleteReturnRequest(ctx context.Context, uuid string, refundId int) error {
+	rrf, err := rs.GetReturnRequestByUUID(ctx, uuid)
+	if err != nil {
+		return err
+	}
+
+	switch rrf.ReturnRequest.Type {
+	case entity.ReturnTypeExchange:
+		return moveReturnRequest(ctx, rs, uuid, entity.ReturnReceived, entity.ReturnExchanged, nil)
+	default:
+		if refundId == 0 {
+			return fmt.Errorf("refund is required to complete the return")
+		}
+		return moveReturnRequest(ctx, rs, uuid, entity.ReturnReceived, entity.ReturnRefunded,
+			func(ctx context.Context, rep dependency.Repository, rrf *entity.ReturnRequestFull) error {
+				query := `
+				SELECT p.*
+				FROM refund r
+				JOIN payment p ON r.payment_id = p.id
+				WHERE r.id = :refundId`
+				payment, err := QueryNamedOne[entity.Payment](ctx, rep.DB(), query, map[string]any{
+					"refundId": refundId,
+				})
+				if err != nil {
+					return fmt.Errorf("can't get refund payment: %w", err)
+				}
+				if payment.OrderId != rrf.ReturnRequest.OrderId {
+					return fmt.Errorf("refund %d is not a refund of the return request order", refundId)
+				}
+
+				query = `UPDATE return_request SET refund_id = :refundId WHERE id = :id`
+				err = ExecNamed(ctx, rep.DB(), query, map[string]any{
+					"id":       rrf.ReturnRequest.Id,
+					"refundId": refundId,
+				})
+				if err != nil {
+					return fmt.Errorf("can't set return request refund: %w", err)
+				}
+				return nil
+			})
+	}
+}
+
+// CancelReturnRequest cancels the approved return request, the sizes reserved for the exchange
+// are returned to stock and its shipment is removed.
+func (rs *returnsStore) CancelReturnRequest(ctx context.Context, uuid string) error {
+	return rs.transitReturnRequest(ctx, uuid, entity.ReturnApproved, entity.ReturnCancelled,
+		func(ctx context.Context, rep dependency.Repository, rrf *entity.ReturnRequestFull) error {
+			if rrf.ReturnRequest.Type != entity.ReturnTypeExchange {
+				return nil
+			}
+
+			reserved := make([]entity.OrderItemInsert, 0, len(rrf.Items))
+			for _, i := range rrf.Items {
+				reserved = append(reserved, entity.OrderItemInsert{
+					ProductId: i.ProductId,
+					SizeId:    int(i.ExchangeSizeId.Int32),
+					Quantity:  decimal.NewFromInt(int64(i.Quantity)),
+				})
+			}
+			err := rep.Products().RestoreStockForProductSizes(ctx, reserved)
+			if err != nil {
+				return fmt.Errorf("can't release exchange sizes: %w", err)
+			}
+
+			err = ExecNamed(ctx, rep.DB(), `DELETE FROM shipment WHERE return_request_id = :returnRequestId`, map[string]any{
+				"returnRequestId": rrf.ReturnRequest.Id,
+			})
+			if err != nil {
+				return fmt.Errorf("can't delete exchange shipment: %w", err)
+			}
+			return nil
+		})
+}
@@ -0,0 +1,245 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/jekabolt/grbpwr-manager/internal/entity"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+// insertShippedOrder creates the paid order of the items and ships it, it returns the order with its items.
+func insertShippedOrder(ctx context.Context, t *testing.T, db *MYSQLStore, items []entity.OrderItemInsert, promoCode string, i int) (*entity.OrderFull, bool) {
+	order, ok := insertPaidOrder(ctx, t, db, items, promoCode, i)
+	if !ok {
+		return nil, false
+	}
+	o, err := getOrderByUUID(ctx, db, order.UUID)
+	if !assert.NoError(t, err) {
+		return nil, false
+	}
+	if !assert.NoError(t, updateOrderStatus(ctx, db, o, entity.Shipped, "admin", "")) {
+		return nil, false
+	}
+	of, err := db.Order().GetOrderFullByUUID(ctx, order.UUID)
+	if !assert.NoError(t, err) {
+		return nil, false
+	}
+	return of, true
+}
+
+func TestReturnRequest(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+	rs := db.Returns()
+
+	err := db.Promo().AddPromo(ctx, &entity.PromoCodeInsert{
+		Code:       "return10off",
+		Discount:   decimal.NewFromInt(10),
+		Expiration: time.Now().Add(time.Hour * 24),
+		Allowed:    true,
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	p, xlSize, lSize, ok := addProductWithSizes(ctx, t, db, 2, 1)
+	if !ok {
+		return
+	}
+	items := []entity.OrderItemInsert{
+		{ProductId: p.Product.Id, Quantity: decimal.NewFromInt(2), SizeId: xlSize.Id},
+	}
+
+	// the order which is not shipped can't be returned
+	paid, ok := insertPaidOrder(ctx, t, db, []entity.OrderItemInsert{
+		{ProductId: p.Product.Id, Quantity: decimal.NewFromInt(1), SizeId: lSize.Id},
+	}, "", 1)
+	if !ok {
+		return
+	}
+	paidFull, err := db.Order().GetOrderFullByUUID(ctx, paid.UUID)
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, err = rs.AddReturnRequest(ctx, &entity.ReturnRequestInsert{
+		OrderUUID: paid.UUID,
+		Email:     paidFull.Buyer.Email,
+		Type:      entity.ReturnTypeReturn,
+		Items:     []entity.ReturnItemInsert{{OrderItemId: paidFull.OrderItems[0].Id, Quantity: 1, Reason: entity.ReturnReasonDamaged}},
+	})
+	assert.Error(t, err)
+
+	of, ok := insertShippedOrder(ctx, t, db, items, "return10off", 2)
+	if !ok {
+		return
+	}
+	assert.True(t, sizeQuantity(ctx, t, db, p.Product.Id, xlSize.Id).IsZero())
+
+	rri := &entity.ReturnRequestInsert{
+		OrderUUID: of.Order.UUID,
+		Email:     of.Buyer.Email,
+		Type:      entity.ReturnTypeReturn,
+		Items:     []entity.ReturnItemInsert{{OrderItemId: of.OrderItems[0].Id, Quantity: 1, Reason: entity.ReturnReasonWrongSize}},
+	}
+
+	// the email must match the buyer
+	_, err = rs.AddReturnRequest(ctx, &entity.ReturnRequestInsert{
+		OrderUUID: rri.OrderUUID,
+		Email:     "other@test.com",
+		Type:      rri.Type,
+		Items:     rri.Items,
+	})
+	assert.Error(t, err)
+
+	// the rejected request doesn't hold the items
+	rejected, err := rs.AddReturnRequest(ctx, &entity.ReturnRequestInsert{
+		OrderUUID: rri.OrderUUID,
+		Email:     rri.Email,
+		Type:      rri.Type,
+		Items:     []entity.ReturnItemInsert{{OrderItemId: of.OrderItems[0].Id, Quantity: 2, Reason: entity.ReturnReasonChangedMind}},
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, rs.RejectReturnRequest(ctx, rejected.ReturnRequest.UUID))
+
+	rrf, err := rs.AddReturnRequest(ctx, rri)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, entity.ReturnRequested, rrf.ReturnRequest.Status)
+	uuid := rrf.ReturnRequest.UUID
+
+	// the request must be approved before the items are received
+	assert.Error(t, rs.ReceiveReturnRequest(ctx, uuid))
+	assert.Error(t, rs.CompleteReturnRequest(ctx, uuid, 0))
+
+	assert.NoError(t, rs.ApproveReturnRequest(ctx, uuid))
+	assert.Error(t, rs.ApproveReturnRequest(ctx, uuid))
+	assert.Error(t, rs.RejectReturnRequest(ctx, uuid))
+
+	// the received items are restocked
+	assert.NoError(t, rs.ReceiveReturnRequest(ctx, uuid))
+	assert.True(t, sizeQuantity(ctx, t, db, p.Product.Id, xlSize.Id).Equal(decimal.NewFromInt(1)))
+
+	rrf, err = rs.GetReturnRequestByUUID(ctx, uuid)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, entity.ReturnReceived, rrf.ReturnRequest.Status)
+
+	// the refund includes the promo discount of the order
+	price := of.OrderItems[0].ProductPriceWithSale.Round(2)
+	assert.True(t, rrf.RefundAmount().Equal(price.Mul(decimal.NewFromFloat(0.9)).Round(2)), rrf.RefundAmount().String())
+
+	// the refund of the other order can't complete the return
+	otherRefund, err := db.Order().RefundOrder(ctx, paid.UUID, decimal.NewFromInt(1), nil, "other", "admin")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Error(t, rs.CompleteReturnRequest(ctx, uuid, otherRefund.Id))
+	assert.Error(t, rs.CompleteReturnRequest(ctx, uuid, 0))
+
+	refund, err := db.Order().RefundOrder(ctx, of.Order.UUID, rrf.RefundAmount(), nil, "return", "admin")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, rs.CompleteReturnRequest(ctx, uuid, refund.Id))
+
+	rrf, err = rs.GetReturnRequestByUUID(ctx, uuid)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, entity.ReturnRefunded, rrf.ReturnRequest.Status)
+	assert.Equal(t, sql.NullInt32{Int32: int32(refund.Id), Valid: true}, rrf.ReturnRequest.RefundId)
+
+	// only the rest of the items can be returned
+	_, err = rs.AddReturnRequest(ctx, &entity.ReturnRequestInsert{
+		OrderUUID: rri.OrderUUID,
+		Email:     rri.Email,
+		Type:      rri.Type,
+		Items:     []entity.ReturnItemInsert{{OrderItemId: of.OrderItems[0].Id, Quantity: 2, Reason: entity.ReturnReasonChangedMind}},
+	})
+	assert.Error(t, err)
+}
+
+func TestExchangeRequest(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+	rs := db.Returns()
+
+	p, xlSize, lSize, ok := addProductWithSizes(ctx, t, db, 1, 1)
+	if !ok {
+		return
+	}
+	of, ok := insertShippedOrder(ctx, t, db, []entity.OrderItemInsert{
+		{ProductId: p.Product.Id, Quantity: decimal.NewFromInt(1), SizeId: xlSize.Id},
+	}, "", 1)
+	if !ok {
+		return
+	}
+
+	rri := &entity.ReturnRequestInsert{
+		OrderUUID: of.Order.UUID,
+		Email:     of.Buyer.Email,
+		Type:      entity.ReturnTypeExchange,
+		Items: []entity.ReturnItemInsert{{
+			OrderItemId:    of.OrderItems[0].Id,
+			Quantity:       1,
+			Reason:         entity.ReturnReasonWrongSize,
+			ExchangeSizeId: sql.NullInt32{Int32: int32(lSize.Id), Valid: true},
+		}},
+	}
+
+	rrf, err := rs.AddReturnRequest(ctx, rri)
+	if !assert.NoError(t, err) {
+		return
+	}
+	uuid := rrf.ReturnRequest.UUID
+
+	// the approved exchange reserves the size and gets the shipment
+	assert.NoError(t, rs.ApproveReturnRequest(ctx, uuid))
+	assert.True(t, sizeQuantity(ctx, t, db, p.Product.Id, lSize.Id).IsZero())
+	rrf, err = rs.GetReturnRequestByUUID(ctx, uuid)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NotNil(t, rrf.ExchangeShipment)
+
+	// the cancelled exchange releases the size and its shipment
+	assert.NoError(t, rs.CancelReturnRequest(ctx, uuid))
+	assert.Error(t, rs.CancelReturnRequest(ctx, uuid))
+	assert.True(t, sizeQuantity(ctx, t, db, p.Product.Id, lSize.Id).Equal(decimal.NewFromInt(1)))
+	rrf, err = rs.GetReturnRequestByUUID(ctx, uuid)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, entity.ReturnCancelled, rrf.ReturnRequest.Status)
+	assert.Nil(t, rrf.ExchangeShipment)
+
+	// the item of the cancelled exchange can be exchanged again
+	rrf, err = rs.AddReturnRequest(ctx, rri)
+	if !assert.NoError(t, err) {
+		return
+	}
+	uuid = rrf.ReturnRequest.UUID
+
+	assert.NoError(t, rs.ApproveReturnRequest(ctx, uuid))
+	assert.NoError(t, rs.ReceiveReturnRequest(ctx, uuid))
+	assert.True(t, sizeQuantity(ctx, t, db, p.Product.Id, xlSize.Id).Equal(decimal.NewFromInt(1)))
+	assert.True(t, sizeQuantity(ctx, t, db, p.Product.Id, lSize.Id).IsZero())
+
+	// the received exchange can't be cancelled
+	assert.Error(t, rs.CancelReturnRequest(ctx, uuid))
+
+	assert.NoError(t, rs.CompleteReturnRequest(ctx, uuid, 0))
+	rrf, err = rs.GetReturnRequestByUUID(ctx, uuid)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, entity.ReturnExchanged, rrf.ReturnRequest.Status)
+}
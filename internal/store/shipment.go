@@ -0,0 +1,270 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+
+	"github.com/jekabolt/grbpwr-manager/internal/cache"
+	"github.com/jekabolt/grbpwr-manager/internal/dependency"
+	"github.com/jekabolt/grbpwr-manager/internal/entity"
+)
+
+// shippedQuantities returns the quantity of the order items covered by the order shipments, the exchange shipments are not counted.
+func shippedQuantities(ctx context.Context, rep dependency.Repository, orderId int) (map[int]int, error) {
+	query := `
+	SELECT si.order_item_id, SUM(si.quantity) AS quantity
+	FROM shipment_item si
+	JOIN shipment s ON si.shipment_id = s.id
+	WHERE s.order_id = :orderId AND s.return_request_id IS NULL
+	GROUP BY si.order_item_id`
+
+	sis, err := QueryListNamed[entity.ShipmentItemInsert](ctx, rep.DB(), query, map[string]any{
+		"orderId": orderId,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't get shipped quantities: %w", err)
+	}
+
+	sq := make(map[int]int, len(sis))
+	for _, si := range sis {
+		sq[si.OrderItemId] = si.Quantity
+	}
+	return sq, nil
+}
+
+// unshippedQuantities returns the quantity of the order items left to ship.
+func unshippedQuantities(ctx context.Context, rep dependency.Repository, orderId int) (map[int]int, error) {
+	ois, err := QueryListNamed[entity.ShipmentItemInsert](ctx, rep.DB(), `
+	SELECT id AS order_item_id, quantity FROM order_item WHERE order_id = :orderId`, map[string]any{
+		"orderId": orderId,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't get order items quantity: %w", err)
+	}
+
+	shipped, err := shippedQuantities(ctx, rep, orderId)
+	if err != nil {
+		return nil, err
+	}
+
+	uq := make(map[int]int)
+	for _, oi := range ois {
+		if left := oi.Quantity - shipped[oi.OrderItemId]; left > 0 {
+			uq[oi.OrderItemId] = left
+		}
+	}
+	return uq, nil
+}
+
+// validateOrderShipped returns entity.UnshippedOrderItemsError if some of the order items are not covered by the shipments.
+func validateOrderShipped(ctx context.Context, rep dependency.Repository, orderId int) error {
+	uq, err := unshippedQuantities(ctx, rep, orderId)
+	if err != nil {
+		return err
+	}
+	if len(uq) == 0 {
+		return nil
+	}
+
+	ids := make([]int, 0, len(uq))
+	for id := range uq {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return &entity.UnshippedOrderItemsError{OrderItemIds: ids}
+}
+
+func insertShipmentItems(ctx context.Context, rep dependency.Repository, shipmentId int, items []entity.ShipmentItemInsert) error {
+	rows := make([]map[string]any, 0, len(items))
+	for _, si := range items {
+		rows = append(rows, map[string]any{
+			"shipment_id":   shipmentId,
+			"order_item_id": si.OrderItemId,
+			"quantity":      si.Quantity,
+		})
+	}
+	if err := BulkInsert(ctx, rep.DB(), "shipment_item", rows); err != nil {
+		return fmt.Errorf("can't insert shipment items: %w", err)
+	}
+	return nil
+}
+
+// AddOrderShipment ships the order items with the given carrier and tracking code, the remaining items are
+// shipped if no items are given. The first shipment fills in the shipment created at the checkout, the next ones
+// are added with zero cost. The order is moved to shipped once all its items are covered by the shipments.
+func (ms *MYSQLStore) AddOrderShipment(ctx context.Context, orderUUID string, osn *entity.OrderShipmentNew, actor string) (*entity.OrderBuyerShipment, error) {
+	if _, ok := cache.GetShipmentCarrierById(osn.CarrierId); !ok {
+		return nil, fmt.Errorf("shipment carrier does not exist")
+	}
+
+	var obs *entity.OrderBuyerShipment
+	err := ms.Tx(ctx, func(ctx context.Context, rep dependency.Repository) error {
+		order, err := getOrderByUUIDForUpdate(ctx, rep, orderUUID)
+		if err != nil {
+			return err
+		}
+		if err := validateOrderStatusTransition(order, entity.Shipped); err != nil {
+			return err
+		}
+
+		shipped, err := shippedQuantities(ctx, rep, order.Id)
+		if err != nil {
+			return err
+		}
+		left, err := unshippedQuantities(ctx, rep, order.Id)
+		if err != nil {
+			return err
+		}
+
+		items := osn.Items
+		if len(items) == 0 {
+			for id, q := range left {
+				items = append(items, entity.ShipmentItemInsert{OrderItemId: id, Quantity: q})
+			}
+			slices.SortFunc(items, func(a, b entity.ShipmentItemInsert) int { return a.OrderItemId - b.OrderItemId })
+		}
+		if len(items) == 0 {
+			return fmt.Errorf("order has no items to ship")
+		}
+		for _, si := range items {
+			if si.Quantity <= 0 || si.Quantity > left[si.OrderItemId] {
+				return fmt.Errorf("order item %d can't be shipped with quantity %d: %d left to ship", si.OrderItemId, si.Quantity, left[si.OrderItemId])
+			}
+			left[si.OrderItemId] -= si.Quantity
+			if left[si.OrderItemId] == 0 {
+				delete(left, si.OrderItemId)
+			}
+		}
+
+		shipment, err := getOrderShipment(ctx, rep, order.Id)
+		if err != nil {
+			return fmt.Errorf("can't get order shipment: %w", err)
+		}
+		shipment.CarrierId = osn.CarrierId
+		shipment.TrackingCode = sql.NullString{String: osn.TrackingCode, Valid: osn.TrackingCode != ""}
+		shipment.ShippingDate = sql.NullTime{Time: osn.ShippingDate, Valid: !osn.ShippingDate.IsZero()}
+
+		if len(shipped) == 0 {
+			if err := updateOrderShipment(ctx, rep, shipment); err != nil {
+				return err
+			}
+		} else {
+			// the shipping cost is charged once with the checkout shipment
+			shipment.Id, err = ExecNamedLastId(ctx, rep.DB(), `
+			INSERT INTO shipment (carrier_id, order_id, cost, tracking_code, shipping_date)
+			VALUES (:carrierId, :orderId, 0, :trackingCode, :shippingDate)`, map[string]any{
+				"carrierId":    shipment.CarrierId,
+				"orderId":      order.Id,
+				"trackingCode": shipment.TrackingCode,
+				"shippingDate": shipment.ShippingDate,
+			})
+			if err != nil {
+				return fmt.Errorf("can't insert shipment: %w", err)
+			}
+		}
+
+		if err := insertShipmentItems(ctx, rep, shipment.Id, items); err != nil {
+			return err
+		}
+
+		if len(left) == 0 {
+			err = updateOrderStatus(ctx, rep, order, entity.Shipped, actor, fmt.Sprintf("tracking number set: %s", osn.TrackingCode))
+			if err != nil {
+				return fmt.Errorf("can't update order status: %w", err)
+			}
+		}
+
+		buyer, err := getBuyerById(ctx, rep, order.Id)
+		if err != nil {
+			return fmt.Errorf("can't get buyer by id: %w", err)
+		}
+
+		sis, err := shipmentItemsByShipmentIds(ctx, rep, []int{shipment.Id})
+		if err != nil {
+			return err
+		}
+
+		s, err := QueryNamedOne[entity.Shipment](ctx, rep.DB(), `SELECT * FROM shipment WHERE id = :id`, map[string]any{
+			"id": shipment.Id,
+		})
+		if err != nil {
+			return fmt.Errorf("can't get shipment: %w", err)
+		}
+
+		obs = &entity.OrderBuyerShipment{
+			Order:    order,
+			Buyer:    buyer,
+			Shipment: &s,
+			Items:    sis[shipment.Id],
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't add order shipment: %w", err)
+	}
+	return obs, nil
+}
+
+func shipmentItemsByShipmentIds(ctx context.Context, rep dependency.Repository, shipmentIds []int) (map[int][]entity.ShipmentItem, error) {
+	if len(shipmentIds) == 0 {
+		return map[int][]entity.ShipmentItem{}, nil
+	}
+
+	sis, err := QueryListNamed[entity.ShipmentItem](ctx, rep.DB(), `
+	SELECT * FROM shipment_item
+	WHERE shipment_id IN (:shipmentIds)
+	ORDER BY id`, map[string]any{
+		"shipmentIds": shipmentIds,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't get shipment items by shipment ids: %w", err)
+	}
+
+	sm := make(map[int][]entity.ShipmentItem)
+	for _, si := range sis {
+		sm[si.ShipmentId] = append(sm[si.ShipmentId], si)
+	}
+	return sm, nil
+}
+
+// fulfillmentShipmentsByOrderIds returns the shipments with the order items, the checkout shipment
+// is not returned until the first items are shipped.
+func fulfillmentShipmentsByOrderIds(ctx context.Context, rep dependency.Repository, orderIds []int) (map[int][]entity.ShipmentFull, error) {
+	if len(orderIds) == 0 {
+		return map[int][]entity.ShipmentFull{}, nil
+	}
+
+	query := `
+	SELECT s.*
+	FROM shipment s
+	WHERE s.order_id IN (:orderIds) AND s.return_request_id IS NULL
+		AND EXISTS (SELECT 1 FROM shipment_item si WHERE si.shipment_id = s.id)
+	ORDER BY s.id`
+
+	shipments, err := QueryListNamed[entity.Shipment](ctx, rep.DB(), query, map[string]any{
+		"orderIds": orderIds,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't get fulfillment shipments by order ids: %w", err)
+	}
+
+	ids := make([]int, 0, len(shipments))
+	for _, s := range shipments {
+		ids = append(ids, s.Id)
+	}
+	items, err := shipmentItemsByShipmentIds(ctx, rep, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	sm := make(map[int][]entity.ShipmentFull)
+	for _, s := range shipments {
+		sm[s.OrderId] = append(sm[s.OrderId], entity.ShipmentFull{
+			Shipment: s,
+			Items:    items[s.Id],
+		})
+	}
+	return sm, nil
+}
@@ -0,0 +1,91 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jekabolt/grbpwr-manager/internal/cache"
+	"github.com/jekabolt/grbpwr-manager/internal/entity"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOrderShipments(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	np, err := randomProductInsert(db, 1)
+	assert.NoError(t, err)
+	productId, err := db.Products().AddProduct(ctx, np)
+	assert.NoError(t, err)
+
+	sc, err := getShipmentCarrierPaid(db)
+	assert.NoError(t, err)
+
+	order := insertTestOrder(ctx, t, db, decimal.NewFromInt(100), cache.OrderStatusConfirmed)
+	assert.NoError(t, insertOrderItems(ctx, db, []entity.OrderItemInsert{{
+		ProductId:    productId,
+		ProductPrice: decimal.NewFromInt(50),
+		Quantity:     decimal.NewFromInt(2),
+		SizeId:       np.SizeMeasurements[0].ProductSize.SizeId,
+	}}, order.Id))
+	assert.NoError(t, insertShipment(ctx, db, sc, order.Id))
+	insertTestBuyer(ctx, t, db, order.Id, "john@example.com")
+
+	left, err := unshippedQuantities(ctx, db, order.Id)
+	assert.NoError(t, err)
+	assert.Len(t, left, 1)
+	var orderItemId int
+	for id := range left {
+		orderItemId = id
+	}
+
+	// the order can't be delivered before all its items are shipped
+	err = db.DeliveredOrder(ctx, order.UUID, "admin")
+	var ue *entity.UnshippedOrderItemsError
+	if assert.True(t, errors.As(err, &ue)) {
+		assert.Equal(t, []int{orderItemId}, ue.OrderItemIds)
+	}
+
+	// more than ordered
+	_, err = db.AddOrderShipment(ctx, order.UUID, &entity.OrderShipmentNew{
+		CarrierId:    sc.Id,
+		TrackingCode: "first",
+		Items:        []entity.ShipmentItemInsert{{OrderItemId: orderItemId, Quantity: 3}},
+	}, "admin")
+	assert.Error(t, err)
+
+	first, err := db.AddOrderShipment(ctx, order.UUID, &entity.OrderShipmentNew{
+		CarrierId:    sc.Id,
+		TrackingCode: "first",
+		Items:        []entity.ShipmentItemInsert{{OrderItemId: orderItemId, Quantity: 1}},
+	}, "admin")
+	assert.NoError(t, err)
+	assert.True(t, first.Shipment.Cost.Equal(sc.PriceDecimal()))
+	assert.Equal(t, cache.OrderStatusConfirmed.Status.Id, first.Order.OrderStatusId)
+
+	// the remaining items are shipped with zero cost
+	second, err := db.AddOrderShipment(ctx, order.UUID, &entity.OrderShipmentNew{
+		CarrierId:    sc.Id,
+		TrackingCode: "second",
+	}, "admin")
+	assert.NoError(t, err)
+	assert.True(t, second.Shipment.Cost.IsZero())
+	if assert.Len(t, second.Items, 1) {
+		assert.Equal(t, 1, second.Items[0].Quantity)
+	}
+	assert.Equal(t, cache.OrderStatusShipped.Status.Id, second.Order.OrderStatusId)
+
+	shipments, err := fulfillmentShipmentsByOrderIds(ctx, db, []int{order.Id})
+	assert.NoError(t, err)
+	if assert.Len(t, shipments[order.Id], 2) {
+		assert.Equal(t, "second", shipments[order.Id][1].Shipment.TrackingCode.String)
+	}
+
+	sm, err := shipmentsByOrderIds(ctx, db, []int{order.Id})
+	assert.NoError(t, err)
+	assert.Equal(t, first.Shipment.Id, sm[order.Id].Id)
+
+	assert.NoError(t, db.DeliveredOrder(ctx, order.UUID, "admin"))
+}
@@ -0,0 +1,7 @@
+-- +migrate Up
+CREATE TABLE processed_webhook_event (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    event_id VARCHAR(255) NOT NULL UNIQUE,
+    event_type VARCHAR(255) NOT NULL,
+    processed_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,3 @@
+-- +migrate Up
+-- amounts in wei do not fit into DECIMAL(20, 2)
+ALTER TABLE payment MODIFY transaction_amount_payment_currency DECIMAL(38, 2) NOT NULL;
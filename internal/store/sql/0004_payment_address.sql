@@ -0,0 +1,13 @@
+-- +migrate Up
+CREATE TABLE payment_address (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    payment_method_id INT NOT NULL,
+    derivation_index INT NOT NULL,
+    address VARCHAR(255) NOT NULL UNIQUE,
+    order_id INT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY(payment_method_id) REFERENCES payment_method(id),
+    FOREIGN KEY(order_id) REFERENCES customer_order(id) ON DELETE CASCADE,
+    UNIQUE(payment_method_id, derivation_index),
+    UNIQUE(payment_method_id, order_id)
+);
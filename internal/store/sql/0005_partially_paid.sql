@@ -0,0 +1,17 @@
+-- +migrate Up
+INSERT INTO
+    order_status (name)
+VALUES
+    ('partially_paid');
+
+CREATE TABLE payment_overpayment (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    order_id INT NOT NULL UNIQUE,
+    payment_method_id INT NOT NULL,
+    amount_payment_currency DECIMAL(38, 2) NOT NULL,
+    payer VARCHAR(255),
+    transaction_id VARCHAR(255),
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY(order_id) REFERENCES customer_order(id) ON DELETE CASCADE,
+    FOREIGN KEY(payment_method_id) REFERENCES payment_method(id)
+);
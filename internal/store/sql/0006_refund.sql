@@ -0,0 +1,16 @@
+-- +migrate Up
+CREATE TABLE refund (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    payment_id INT NOT NULL,
+    amount DECIMAL(10, 2) NOT NULL,
+    status VARCHAR(50) NOT NULL,
+    provider_refund_id VARCHAR(255) UNIQUE,
+    payee VARCHAR(255),
+    reason TEXT,
+    restocked BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    modified_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    FOREIGN KEY(payment_id) REFERENCES payment(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_refund_payment_id ON refund(payment_id);
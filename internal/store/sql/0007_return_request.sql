@@ -0,0 +1,42 @@
+-- +migrate Up
+CREATE TABLE return_request (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    uuid VARCHAR(36) NOT NULL UNIQUE,
+    order_id INT NOT NULL,
+    type VARCHAR(50) NOT NULL,
+    status VARCHAR(50) NOT NULL,
+    comment TEXT,
+    refund_id INT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    modified_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    FOREIGN KEY(order_id) REFERENCES customer_order(id) ON DELETE CASCADE,
+    FOREIGN KEY(refund_id) REFERENCES refund(id)
+);
+
+CREATE INDEX idx_return_request_order_id ON return_request(order_id);
+
+CREATE INDEX idx_return_request_status ON return_request(status);
+
+CREATE TABLE return_item (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    return_request_id INT NOT NULL,
+    order_item_id INT NOT NULL,
+    quantity INT NOT NULL CHECK (quantity > 0),
+    reason VARCHAR(50) NOT NULL,
+    exchange_size_id INT,
+    FOREIGN KEY(return_request_id) REFERENCES return_request(id) ON DELETE CASCADE,
+    FOREIGN KEY(order_item_id) REFERENCES order_item(id) ON DELETE CASCADE,
+    FOREIGN KEY(exchange_size_id) REFERENCES size(id)
+);
+
+-- exchange shipments are linked to the return request,
+-- the order shipment is the one without return request
+ALTER TABLE shipment
+    ADD COLUMN return_request_id INT DEFAULT NULL,
+    ADD FOREIGN KEY (return_request_id) REFERENCES return_request(id) ON DELETE CASCADE;
+
+CREATE INDEX idx_shipment_order_id ON shipment(order_id);
+
+ALTER TABLE shipment DROP INDEX order_id;
+
+CREATE UNIQUE INDEX idx_shipment_return_request_id ON shipment(return_request_id);
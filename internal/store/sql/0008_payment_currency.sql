@@ -0,0 +1,6 @@
+-- +migrate Up
+-- transaction_amount stays in the base currency,
+-- transaction_amount_payment_currency is charged in payment_currency at payment_currency_rate per base unit
+ALTER TABLE payment
+    ADD COLUMN payment_currency VARCHAR(8) NULL,
+    ADD COLUMN payment_currency_rate DECIMAL(20, 8) NULL;
@@ -0,0 +1,13 @@
+-- +migrate Up
+-- the time the unpaid invoice of the order expires at,
+-- the row is deleted in the same transaction the invoice is expired in
+CREATE TABLE payment_expiration (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    order_id INT NOT NULL UNIQUE,
+    payment_method_id INT NOT NULL,
+    expire_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY(order_id) REFERENCES customer_order(id) ON DELETE CASCADE,
+    FOREIGN KEY(payment_method_id) REFERENCES payment_method(id),
+    INDEX(expire_at)
+);
@@ -0,0 +1,7 @@
+-- +migrate Up
+-- the crypto invoice amount is quoted from the order total in quote_source_currency
+-- at payment_currency_rate, the unpaid invoice is re-quoted once the quote expires
+ALTER TABLE payment
+    ADD COLUMN quote_source_currency VARCHAR(8) NULL,
+    ADD COLUMN quoted_at TIMESTAMP NULL,
+    ADD COLUMN quote_expires_at TIMESTAMP NULL;
@@ -0,0 +1,9 @@
+-- +migrate Up
+-- ERC-20 stablecoins on ethereum mainnet and sepolia testnet
+INSERT INTO
+    payment_method (name)
+VALUES
+    ('usdt-eth'),
+    ('usdt-sepolia'),
+    ('usdc-eth'),
+    ('usdc-sepolia');
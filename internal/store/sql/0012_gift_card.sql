@@ -0,0 +1,35 @@
+-- +migrate Up
+CREATE TABLE gift_card (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    code VARCHAR(255) NOT NULL UNIQUE,
+    initial_balance DECIMAL(10, 2) NOT NULL CHECK (initial_balance > 0),
+    balance DECIMAL(10, 2) NOT NULL CHECK (balance >= 0),
+    expiration TIMESTAMP NULL DEFAULT NULL,
+    allowed BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    modified_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+);
+
+-- every balance change is recorded in the ledger,
+-- the amount is positive for the issued and returned balance and negative for the redeemed one
+CREATE TABLE gift_card_transaction (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    gift_card_id INT NOT NULL,
+    order_id INT DEFAULT NULL,
+    type VARCHAR(50) NOT NULL,
+    amount DECIMAL(10, 2) NOT NULL,
+    balance_after DECIMAL(10, 2) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY(gift_card_id) REFERENCES gift_card(id) ON DELETE CASCADE,
+    FOREIGN KEY(order_id) REFERENCES customer_order(id) ON DELETE SET NULL
+);
+
+CREATE INDEX idx_gift_card_transaction_gift_card_id ON gift_card_transaction(gift_card_id);
+
+CREATE INDEX idx_gift_card_transaction_order_id ON gift_card_transaction(order_id);
+
+-- part of the order total covered by the gift card, the rest is invoiced
+ALTER TABLE customer_order
+    ADD COLUMN gift_card_id INT DEFAULT NULL,
+    ADD COLUMN gift_card_amount DECIMAL(10, 2) NOT NULL DEFAULT 0,
+    ADD FOREIGN KEY (gift_card_id) REFERENCES gift_card(id);
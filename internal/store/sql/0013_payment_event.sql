@@ -0,0 +1,19 @@
+-- +migrate Up
+-- append-only log of the payment changes, the payment row keeps only the latest state.
+-- The event of the same transaction is recorded once, the events without transaction are always appended
+CREATE TABLE payment_event (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    payment_id INT NOT NULL,
+    order_id INT NOT NULL,
+    payment_method_id INT NOT NULL,
+    type VARCHAR(50) NOT NULL,
+    transaction_id VARCHAR(255) DEFAULT NULL,
+    payload JSON,
+    created_at TIMESTAMP(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3),
+    FOREIGN KEY(payment_id) REFERENCES payment(id) ON DELETE CASCADE,
+    FOREIGN KEY(order_id) REFERENCES customer_order(id) ON DELETE CASCADE,
+    FOREIGN KEY(payment_method_id) REFERENCES payment_method(id),
+    UNIQUE(payment_id, type, transaction_id)
+);
+
+CREATE INDEX idx_payment_event_order_id ON payment_event(order_id);
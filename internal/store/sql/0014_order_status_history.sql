@@ -0,0 +1,22 @@
+-- +migrate Up
+-- every order status change with the admin username or system as the actor,
+-- from_status_id is null for the status the order is placed with
+CREATE TABLE order_status_history (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    order_id INT NOT NULL,
+    from_status_id INT DEFAULT NULL,
+    to_status_id INT NOT NULL,
+    actor VARCHAR(255) NOT NULL,
+    reason VARCHAR(255) DEFAULT NULL,
+    created_at TIMESTAMP(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3),
+    FOREIGN KEY(order_id) REFERENCES customer_order(id) ON DELETE CASCADE,
+    FOREIGN KEY(from_status_id) REFERENCES order_status(id),
+    FOREIGN KEY(to_status_id) REFERENCES order_status(id)
+);
+
+CREATE INDEX idx_order_status_history_order_id ON order_status_history(order_id);
+
+-- the orders placed before the history is recorded start with their current status
+INSERT INTO order_status_history (order_id, to_status_id, actor, reason, created_at)
+SELECT id, order_status_id, 'system', 'status before the history was recorded', modified
+FROM customer_order;
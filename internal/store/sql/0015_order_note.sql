@@ -0,0 +1,12 @@
+-- +migrate Up
+-- internal notes of the support team on the order, never shown to the buyer
+CREATE TABLE order_note (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    order_id INT NOT NULL,
+    author VARCHAR(255) NOT NULL,
+    text TEXT NOT NULL,
+    created_at TIMESTAMP(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3),
+    FOREIGN KEY(order_id) REFERENCES customer_order(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_order_note_order_id ON order_note(order_id);
@@ -0,0 +1,15 @@
+-- +migrate Up
+-- the price difference of the order edited by the admin after it's paid,
+-- the amount is positive for the balance due from the buyer and negative for the refund owed to the buyer
+CREATE TABLE order_adjustment (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    order_id INT NOT NULL,
+    amount DECIMAL(10, 2) NOT NULL,
+    total_before DECIMAL(10, 2) NOT NULL,
+    total_after DECIMAL(10, 2) NOT NULL,
+    actor VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3),
+    FOREIGN KEY(order_id) REFERENCES customer_order(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_order_adjustment_order_id ON order_adjustment(order_id);
@@ -0,0 +1,21 @@
+-- +migrate Up
+-- the order items and their quantity sent with the shipment,
+-- the order is shipped once all its items are covered by the shipments
+CREATE TABLE shipment_item (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    shipment_id INT NOT NULL,
+    order_item_id INT NOT NULL,
+    quantity INT NOT NULL CHECK (quantity > 0),
+    FOREIGN KEY(shipment_id) REFERENCES shipment(id) ON DELETE CASCADE,
+    FOREIGN KEY(order_item_id) REFERENCES order_item(id) ON DELETE CASCADE,
+    UNIQUE KEY uq_shipment_item (shipment_id, order_item_id)
+);
+
+CREATE INDEX idx_shipment_item_order_item_id ON shipment_item(order_item_id);
+
+-- the orders shipped before the split fulfillment have all their items in the single shipment
+INSERT INTO shipment_item (shipment_id, order_item_id, quantity)
+SELECT s.id, oi.id, oi.quantity
+FROM shipment s
+JOIN order_item oi ON oi.order_id = s.order_id
+WHERE s.return_request_id IS NULL AND s.tracking_code IS NOT NULL;
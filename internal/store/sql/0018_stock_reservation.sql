@@ -0,0 +1,20 @@
+-- +migrate Up
+-- the product size quantity held for the placed order until its invoice is issued,
+-- the active reservation stops holding the stock once it expires
+CREATE TABLE stock_reservation (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    order_id INT NOT NULL,
+    product_id INT NOT NULL,
+    size_id INT NOT NULL,
+    quantity INT NOT NULL CHECK (quantity > 0),
+    status VARCHAR(20) NOT NULL DEFAULT 'active' CHECK (status IN ('active', 'released', 'converted')),
+    expires_at TIMESTAMP(3) NOT NULL,
+    created_at TIMESTAMP(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3),
+    updated_at TIMESTAMP(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3) ON UPDATE CURRENT_TIMESTAMP(3),
+    FOREIGN KEY(order_id) REFERENCES customer_order(id) ON DELETE CASCADE,
+    FOREIGN KEY(product_id) REFERENCES product(id) ON DELETE CASCADE,
+    FOREIGN KEY(size_id) REFERENCES size(id)
+);
+
+CREATE INDEX idx_stock_reservation_product_size ON stock_reservation(product_id, size_id, status, expires_at);
+CREATE INDEX idx_stock_reservation_order_id ON stock_reservation(order_id);
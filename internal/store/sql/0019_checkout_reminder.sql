@@ -0,0 +1,12 @@
+-- +migrate Up
+-- the reminder sent to the buyer of the unpaid order, at most one per order,
+-- expired is set if the reminder was sent after the invoice had expired
+CREATE TABLE checkout_reminder (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    order_id INT NOT NULL UNIQUE,
+    expired BOOLEAN NOT NULL DEFAULT FALSE,
+    sent_at TIMESTAMP(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3),
+    FOREIGN KEY(order_id) REFERENCES customer_order(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_checkout_reminder_sent_at ON checkout_reminder(sent_at);
@@ -0,0 +1,33 @@
+-- +migrate Up
+-- the invoice numbers are gap-free, the next one is taken from the single sequence row
+-- in the transaction the order is confirmed in
+CREATE TABLE invoice_sequence (
+    id INT PRIMARY KEY,
+    last_number INT NOT NULL DEFAULT 0
+);
+
+INSERT INTO invoice_sequence (id, last_number) VALUES (1, 0);
+
+-- the invoice of the confirmed order, the access key grants the buyer the invoice download
+CREATE TABLE order_invoice (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    order_id INT NOT NULL UNIQUE,
+    invoice_number INT NOT NULL UNIQUE,
+    access_key CHAR(32) NOT NULL,
+    issued_at TIMESTAMP(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3),
+    FOREIGN KEY(order_id) REFERENCES customer_order(id) ON DELETE CASCADE
+);
+
+-- the orders confirmed before the invoices are issued are numbered in the order they were placed
+INSERT INTO order_invoice (order_id, invoice_number, access_key, issued_at)
+SELECT co.id, ROW_NUMBER() OVER (ORDER BY co.placed, co.id), LOWER(HEX(RANDOM_BYTES(16))), co.modified
+FROM customer_order co
+JOIN order_status os ON co.order_status_id = os.id
+WHERE os.name IN ('confirmed', 'shipped', 'delivered', 'refunded')
+    OR EXISTS (
+        SELECT 1 FROM order_status_history h
+        JOIN order_status hs ON h.to_status_id = hs.id
+        WHERE h.order_id = co.id AND hs.name = 'confirmed'
+    );
+
+UPDATE invoice_sequence SET last_number = (SELECT COUNT(*) FROM order_invoice) WHERE id = 1;
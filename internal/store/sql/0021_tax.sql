@@ -0,0 +1,33 @@
+-- +migrate Up
+-- the tax of the orders shipped to the country, the country is matched with the shipping address
+-- country case-insensitively and the countries without the rule are not taxed
+CREATE TABLE tax_rule (
+    country VARCHAR(255) PRIMARY KEY,
+    inclusive BOOLEAN NOT NULL DEFAULT TRUE,
+    default_rate DECIMAL(5, 2) NOT NULL DEFAULT 0 CHECK (
+        default_rate >= 0
+        AND default_rate <= 100
+    )
+);
+
+-- the rates of the categories taxed apart from the default rate of the country
+CREATE TABLE tax_category_rate (
+    country VARCHAR(255) NOT NULL,
+    category_id INT NOT NULL,
+    rate DECIMAL(5, 2) NOT NULL CHECK (
+        rate >= 0
+        AND rate <= 100
+    ),
+    PRIMARY KEY (country, category_id),
+    FOREIGN KEY (country) REFERENCES tax_rule(country) ON DELETE CASCADE,
+    FOREIGN KEY (category_id) REFERENCES category(id) ON DELETE CASCADE
+);
+
+ALTER TABLE order_item
+    ADD COLUMN tax_rate DECIMAL(5, 2) NOT NULL DEFAULT 0,
+    ADD COLUMN tax_amount DECIMAL(10, 2) NOT NULL DEFAULT 0;
+
+-- the tax amount is part of the total, the exclusive tax is added to the prices
+ALTER TABLE customer_order
+    ADD COLUMN tax_amount DECIMAL(10, 2) NOT NULL DEFAULT 0,
+    ADD COLUMN tax_inclusive BOOLEAN NOT NULL DEFAULT TRUE;
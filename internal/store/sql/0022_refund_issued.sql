@@ -0,0 +1,7 @@
+-- +migrate Up
+-- the refund is recorded pending before the payment provider is called,
+-- issued_at is set once the provider result or the manual transfer is recorded
+ALTER TABLE refund
+    ADD COLUMN issued_at TIMESTAMP NULL;
+
+UPDATE refund SET issued_at = modified_at;
@@ -0,0 +1,5 @@
+-- +migrate Up
+-- the block the transfers to the crypto invoice address are looked up from,
+-- it is recorded with the invoice on the chains the transfers are found by block
+ALTER TABLE payment
+    ADD COLUMN start_block BIGINT UNSIGNED NULL;
@@ -0,0 +1,5 @@
+-- +migrate Up
+-- the part of the refund credited back to the gift card the order was paid with,
+-- the amount is the part refunded through the payment provider
+ALTER TABLE refund
+    ADD COLUMN gift_card_amount DECIMAL(10, 2) NOT NULL DEFAULT 0;
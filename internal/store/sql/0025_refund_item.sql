@@ -0,0 +1,20 @@
+-- +migrate Up
+-- the order items returned to stock with the refund and their quantities
+CREATE TABLE refund_item (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    refund_id INT NOT NULL,
+    order_item_id INT NOT NULL,
+    quantity INT NOT NULL CHECK (quantity > 0),
+    FOREIGN KEY(refund_id) REFERENCES refund(id) ON DELETE CASCADE,
+    FOREIGN KEY(order_item_id) REFERENCES order_item(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_refund_item_refund_id ON refund_item(refund_id);
+
+-- the refunds restocked before restocked every item of the order
+INSERT INTO refund_item (refund_id, order_item_id, quantity)
+SELECT r.id, oi.id, oi.quantity
+FROM refund r
+JOIN payment p ON r.payment_id = p.id
+JOIN order_item oi ON oi.order_id = p.order_id
+WHERE r.restocked = TRUE;
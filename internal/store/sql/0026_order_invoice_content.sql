@@ -0,0 +1,16 @@
+-- +migrate Up
+-- the content the invoice states when it is issued, the invoice is rendered from it so the later edits
+-- of the order don't change it. The invoices issued before store it when the order is edited the first time.
+ALTER TABLE order_invoice ADD COLUMN content JSON NULL;
+
+-- the correction of the invoice issued for the edit of the invoiced order, numbered from the same sequence
+CREATE TABLE order_invoice_correction (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    order_invoice_id INT NOT NULL,
+    invoice_number INT NOT NULL UNIQUE,
+    content JSON NOT NULL,
+    issued_at TIMESTAMP(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3),
+    FOREIGN KEY(order_invoice_id) REFERENCES order_invoice(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_order_invoice_correction_order_invoice_id ON order_invoice_correction(order_invoice_id);
@@ -0,0 +1,82 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jekabolt/grbpwr-manager/internal/dependency"
+	"github.com/jekabolt/grbpwr-manager/internal/entity"
+)
+
+const defaultStockReservationTTL = 15 * time.Minute
+
+// reservedQuantityQuery sums the quantity held for the other orders than :orderId by the active
+// reservations of the product size selected as ps, the expired reservations don't hold the stock.
+const reservedQuantityQuery = `
+	COALESCE((
+		SELECT SUM(sr.quantity)
+		FROM stock_reservation sr
+		WHERE sr.product_id = ps.product_id
+			AND sr.size_id = ps.size_id
+			AND sr.status = 'active'
+			AND sr.expires_at > NOW(3)
+			AND sr.order_id <> :orderId
+	), 0)`
+
+// reserveStock holds the quantity of the order items for the stock reservation ttl.
+func reserveStock(ctx context.Context, rep dependency.Repository, orderId int, items []entity.OrderItemInsert, ttl time.Duration) error {
+	if ttl <= 0 {
+		ttl = defaultStockReservationTTL
+	}
+	for _, item := range items {
+		err := ExecNamed(ctx, rep.DB(), `
+		INSERT INTO stock_reservation (order_id, product_id, size_id, quantity, expires_at)
+		VALUES (:orderId, :productId, :sizeId, :quantity, TIMESTAMPADD(MICROSECOND, :ttl, NOW(3)))`, map[string]any{
+			"orderId":   orderId,
+			"productId": item.ProductId,
+			"sizeId":    item.SizeId,
+			"quantity":  item.QuantityDecimal(),
+			"ttl":       ttl.Microseconds(),
+		})
+		if err != nil {
+			return fmt.Errorf("can't insert stock reservation: %w", err)
+		}
+	}
+	return nil
+}
+
+// releaseStockReservations moves the active reservations of the order to the given status,
+// the released ones return the stock and the converted ones are taken from the stock on hand.
+func releaseStockReservations(ctx context.Context, rep dependency.Repository, orderId int, status entity.StockReservationStatus) error {
+	err := ExecNamed(ctx, rep.DB(), `
+	UPDATE stock_reservation
+	SET status = :status
+	WHERE order_id = :orderId AND status = 'active'`, map[string]any{
+		"orderId": orderId,
+		"status":  status,
+	})
+	if err != nil {
+		return fmt.Errorf("can't update stock reservations: %w", err)
+	}
+	return nil
+}
+
+// rereserveStock replaces the active reservations of the order with the ones of its updated items.
+func rereserveStock(ctx context.Context, rep dependency.Repository, orderId int, items []entity.OrderItemInsert, ttl time.Duration) error {
+	if err := releaseStockReservations(ctx, rep, orderId, entity.StockReservationReleased); err != nil {
+		return err
+	}
+	return reserveStock(ctx, rep, orderId, items, ttl)
+}
+
+func stockReservationsByOrderId(ctx context.Context, rep dependency.Repository, orderId int) ([]entity.StockReservation, error) {
+	srs, err := QueryListNamed[entity.StockReservation](ctx, rep.DB(), `
+	SELECT * FROM stock_reservation WHERE order_id = :orderId ORDER BY id`, map[string]any{
+		"orderId": orderId,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't get stock reservations: %w", err)
+	}
+	return srs, nil
+}
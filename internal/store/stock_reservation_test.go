@@ -0,0 +1,66 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jekabolt/grbpwr-manager/internal/cache"
+	"github.com/jekabolt/grbpwr-manager/internal/entity"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStockReservation(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	np, err := randomProductInsert(db, 1)
+	assert.NoError(t, err)
+	np.SizeMeasurements = np.SizeMeasurements[:1]
+	np.SizeMeasurements[0].ProductSize.Quantity = decimal.NewFromInt(2)
+	productId, err := db.Products().AddProduct(ctx, np)
+	assert.NoError(t, err)
+
+	items := []entity.OrderItemInsert{{
+		ProductId: productId,
+		Quantity:  decimal.NewFromInt(2),
+		SizeId:    np.SizeMeasurements[0].ProductSize.SizeId,
+	}}
+
+	order := insertTestOrder(ctx, t, db, decimal.NewFromInt(100), cache.OrderStatusPlaced)
+	assert.NoError(t, reserveStock(ctx, db, order.Id, items, time.Minute))
+
+	// the reserved stock is not available to the other buyers
+	_, err = db.ValidateOrderItemsInsert(ctx, items)
+	assert.Error(t, err)
+
+	// but it is for the order holding it
+	oiv, err := validateOrderItems(ctx, db, items, order.Id)
+	assert.NoError(t, err)
+	assert.False(t, oiv.HasChanged)
+
+	prd, err := db.GetProductByIdShowHidden(ctx, productId)
+	assert.NoError(t, err)
+	if assert.Len(t, prd.Sizes, 1) {
+		assert.True(t, prd.Sizes[0].QuantityDecimal().Equal(decimal.NewFromInt(2)))
+		assert.True(t, prd.Sizes[0].AvailableDecimal().IsZero())
+	}
+
+	// cancellation releases the stock
+	assert.NoError(t, cancelOrder(ctx, db, order, items, entity.OrderStatusActorSystem, "cancelled by the buyer"))
+	_, err = db.ValidateOrderItemsInsert(ctx, items)
+	assert.NoError(t, err)
+
+	srs, err := stockReservationsByOrderId(ctx, db, order.Id)
+	assert.NoError(t, err)
+	if assert.Len(t, srs, 1) {
+		assert.Equal(t, entity.StockReservationReleased, srs[0].Status)
+	}
+
+	// the expired reservation doesn't hold the stock
+	assert.NoError(t, reserveStock(ctx, db, order.Id, items, time.Millisecond))
+	time.Sleep(10 * time.Millisecond)
+	_, err = db.ValidateOrderItemsInsert(ctx, items)
+	assert.NoError(t, err)
+}
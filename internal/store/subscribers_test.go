@@ -15,8 +15,7 @@ func TestSubscribers_Subscribe(t *testing.T) {
 	ctx := context.Background()
 
 	email := "test@mail.test"
-	name := "test"
-	err := ss.Subscribe(ctx, email, name)
+	err := ss.UpsertSubscription(ctx, email, true)
 	assert.NoError(t, err)
 
 	subs, err := ss.GetActiveSubscribers(ctx)
@@ -24,7 +23,11 @@ func TestSubscribers_Subscribe(t *testing.T) {
 	assert.Len(t, subs, 1)
 	assert.Equal(t, email, subs[0].Email)
 
-	err = ss.Unsubscribe(ctx, email)
+	subscribed, err := ss.IsSubscribed(ctx, email)
+	assert.NoError(t, err)
+	assert.True(t, subscribed)
+
+	err = ss.UpsertSubscription(ctx, email, false)
 	assert.NoError(t, err)
 
 	subs, err = ss.GetActiveSubscribers(ctx)
@@ -0,0 +1,188 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/jekabolt/grbpwr-manager/internal/dependency"
+	"github.com/jekabolt/grbpwr-manager/internal/entity"
+	"github.com/shopspring/decimal"
+)
+
+func taxCategoryRatesByCountries(ctx context.Context, rep dependency.Repository, countries []string) (map[string][]entity.TaxCategoryRate, error) {
+	if len(countries) == 0 {
+		return map[string][]entity.TaxCategoryRate{}, nil
+	}
+
+	rates, err := QueryListNamed[entity.TaxCategoryRate](ctx, rep.DB(), `
+	SELECT * FROM tax_category_rate
+	WHERE country IN (:countries)
+	ORDER BY category_id`, map[string]any{
+		"countries": countries,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't get tax category rates: %w", err)
+	}
+
+	rm := make(map[string][]entity.TaxCategoryRate)
+	for _, r := range rates {
+		rm[r.Country] = append(rm[r.Country], r)
+	}
+	return rm, nil
+}
+
+// getTaxRule returns nil if the country has no tax rule.
+func getTaxRule(ctx context.Context, rep dependency.Repository, country string) (*entity.TaxRule, error) {
+	rule, err := QueryNamedOne[entity.TaxRule](ctx, rep.DB(), `SELECT * FROM tax_rule WHERE country = :country`, map[string]any{
+		"country": entity.NormalizeTaxCountry(country),
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("can't get tax rule: %w", err)
+	}
+
+	rates, err := taxCategoryRatesByCountries(ctx, rep, []string{rule.Country})
+	if err != nil {
+		return nil, err
+	}
+	rule.CategoryRates = rates[rule.Country]
+	return &rule, nil
+}
+
+// GetTaxRule returns the tax rule of the country, nil if the orders shipped to the country are not taxed.
+func (ms *MYSQLStore) GetTaxRule(ctx context.Context, country string) (*entity.TaxRule, error) {
+	return getTaxRule(ctx, ms, country)
+}
+
+// GetTaxRules returns the tax rules of all the countries.
+func (ms *MYSQLStore) GetTaxRules(ctx context.Context) ([]entity.TaxRule, error) {
+	rules, err := QueryListNamed[entity.TaxRule](ctx, ms.DB(), `SELECT * FROM tax_rule ORDER BY country`, map[string]any{})
+	if err != nil {
+		return nil, fmt.Errorf("can't get tax rules: %w", err)
+	}
+
+	countries := make([]string, 0, len(rules))
+	for _, r := range rules {
+		countries = append(countries, r.Country)
+	}
+	rates, err := taxCategoryRatesByCountries(ctx, ms, countries)
+	if err != nil {
+		return nil, err
+	}
+	for i := range rules {
+		rules[i].CategoryRates = rates[rules[i].Country]
+	}
+	return rules, nil
+}
+
+// SetTaxRule creates or replaces the tax rule of the country with its category rates,
+// it applies to the orders placed after the change.
+func (ms *MYSQLStore) SetTaxRule(ctx context.Context, tr *entity.TaxRule) error {
+	if err := tr.Validate(); err != nil {
+		return err
+	}
+	country := entity.NormalizeTaxCountry(tr.Country)
+
+	return ms.Tx(ctx, func(ctx context.Context, rep dependency.Repository) error {
+		err := ExecNamed(ctx, rep.DB(), `
+		INSERT INTO tax_rule (country, inclusive, default_rate)
+		VALUES (:country, :inclusive, :defaultRate)
+		ON DUPLICATE KEY UPDATE inclusive = VALUES(inclusive), default_rate = VALUES(default_rate)`, map[string]any{
+			"country":     country,
+			"inclusive":   tr.Inclusive,
+			"defaultRate": tr.DefaultRate.Round(2),
+		})
+		if err != nil {
+			return fmt.Errorf("can't upsert tax rule: %w", err)
+		}
+
+		err = ExecNamed(ctx, rep.DB(), `DELETE FROM tax_category_rate WHERE country = :country`, map[string]any{
+			"country": country,
+		})
+		if err != nil {
+			return fmt.Errorf("can't delete tax category rates: %w", err)
+		}
+
+		if len(tr.CategoryRates) == 0 {
+			return nil
+		}
+		rows := make([]map[string]any, 0, len(tr.CategoryRates))
+		for _, cr := range tr.CategoryRates {
+			rows = append(rows, map[string]any{
+				"country":     country,
+				"category_id": cr.CategoryId,
+				"rate":        cr.Rate.Round(2),
+			})
+		}
+		if err := BulkInsert(ctx, rep.DB(), "tax_category_rate", rows); err != nil {
+			return fmt.Errorf("can't insert tax category rates: %w", err)
+		}
+		return nil
+	})
+}
+
+// DeleteTaxRule deletes the tax rule of the country, the orders shipped there are not taxed afterwards.
+func (ms *MYSQLStore) DeleteTaxRule(ctx context.Context, country string) error {
+	err := ExecNamed(ctx, ms.DB(), `DELETE FROM tax_rule WHERE country = :country`, map[string]any{
+		"country": entity.NormalizeTaxCountry(country),
+	})
+	if err != nil {
+		return fmt.Errorf("can't delete tax rule: %w", err)
+	}
+	return nil
+}
+
+// calculateOrderTax computes the tax of the valid items shipped to the country.
+func calculateOrderTax(ctx context.Context, rep dependency.Repository, country string, items []entity.OrderItem, promo entity.PromoCode, shippingPrice decimal.Decimal) (*entity.OrderTax, error) {
+	rule, err := getTaxRule(ctx, rep, country)
+	if err != nil {
+		return nil, err
+	}
+	return entity.CalculateTax(rule, items, promo, shippingPrice), nil
+}
+
+// orderItemsWithTax converts the valid items to the insert form with their tax.
+func orderItemsWithTax(items []entity.OrderItem, tax *entity.OrderTax) []entity.OrderItemInsert {
+	inserts := entity.ConvertOrderItemToOrderItemInsert(items)
+	for i := range inserts {
+		inserts[i].TaxRate = tax.Items[i].Rate
+		inserts[i].TaxAmount = tax.Items[i].Amount
+	}
+	return inserts
+}
+
+// updateOrderTax records the tax on the items of the order and on the order.
+func updateOrderTax(ctx context.Context, rep dependency.Repository, orderId int, items []entity.OrderItem, tax *entity.OrderTax) error {
+	for i, item := range items {
+		err := ExecNamed(ctx, rep.DB(), `
+		UPDATE order_item
+		SET tax_rate = :taxRate, tax_amount = :taxAmount
+		WHERE order_id = :orderId AND product_id = :productId AND size_id = :sizeId`, map[string]any{
+			"orderId":   orderId,
+			"productId": item.ProductId,
+			"sizeId":    item.SizeId,
+			"taxRate":   tax.Items[i].Rate,
+			"taxAmount": tax.Items[i].Amount,
+		})
+		if err != nil {
+			return fmt.Errorf("can't update order item tax: %w", err)
+		}
+	}
+
+	err := ExecNamed(ctx, rep.DB(), `
+	UPDATE customer_order
+	SET tax_amount = :taxAmount, tax_inclusive = :taxInclusive
+	WHERE id = :orderId`, map[string]any{
+		"orderId":      orderId,
+		"taxAmount":    tax.Total.Round(2),
+		"taxInclusive": tax.Inclusive,
+	})
+	if err != nil {
+		return fmt.Errorf("can't update order tax: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package store
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jekabolt/grbpwr-manager/internal/cache"
+	"github.com/jekabolt/grbpwr-manager/internal/entity"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTaxRules(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	categories := cache.GetCategories()
+	if !assert.GreaterOrEqual(t, len(categories), 2) {
+		return
+	}
+	reducedCategory, standardCategory := categories[0], categories[1]
+
+	// the country without the rule is not taxed
+	rule, err := db.GetTaxRule(ctx, "LV")
+	assert.NoError(t, err)
+	assert.Nil(t, rule)
+
+	// the rate out of the percentage range is rejected
+	err = db.SetTaxRule(ctx, &entity.TaxRule{Country: "LV", DefaultRate: decimal.NewFromInt(101)})
+	assert.Error(t, err)
+
+	err = db.SetTaxRule(ctx, &entity.TaxRule{
+		Country:     " lv ",
+		Inclusive:   true,
+		DefaultRate: decimal.NewFromInt(21),
+		CategoryRates: []entity.TaxCategoryRate{
+			{CategoryId: reducedCategory.Id, Rate: decimal.NewFromInt(12)},
+		},
+	})
+	assert.NoError(t, err)
+
+	// the country is matched case-insensitively
+	rule, err = db.GetTaxRule(ctx, "Lv")
+	assert.NoError(t, err)
+	if !assert.NotNil(t, rule) {
+		return
+	}
+	assert.Equal(t, "LV", rule.Country)
+	assert.True(t, rule.Inclusive)
+	assert.True(t, rule.Rate(reducedCategory.Id).Equal(decimal.NewFromInt(12)))
+	assert.True(t, rule.Rate(standardCategory.Id).Equal(decimal.NewFromInt(21)))
+
+	items := []entity.OrderItem{
+		{
+			CategoryId:      reducedCategory.Id,
+			OrderItemInsert: entity.OrderItemInsert{ProductPriceWithSale: decimal.NewFromInt(112), Quantity: decimal.NewFromInt(1)},
+		},
+		{
+			CategoryId:      standardCategory.Id,
+			OrderItemInsert: entity.OrderItemInsert{ProductPriceWithSale: decimal.NewFromInt(121), Quantity: decimal.NewFromInt(2)},
+		},
+	}
+
+	t.Run("inclusive", func(t *testing.T) {
+		tax, err := calculateOrderTax(ctx, db, "lv", items, entity.PromoCode{}, decimal.NewFromInt(121))
+		assert.NoError(t, err)
+		assert.True(t, tax.Items[0].Amount.Equal(decimal.NewFromInt(12)))
+		assert.True(t, tax.Items[1].Amount.Equal(decimal.NewFromInt(42)))
+		assert.True(t, tax.Shipping.Equal(decimal.NewFromInt(21)))
+		assert.True(t, tax.Total.Equal(decimal.NewFromInt(75)))
+		assert.True(t, tax.Surcharge().IsZero())
+	})
+
+	t.Run("exclusive with promo", func(t *testing.T) {
+		err := db.SetTaxRule(ctx, &entity.TaxRule{Country: "LV", DefaultRate: decimal.NewFromInt(10)})
+		assert.NoError(t, err)
+
+		promo := entity.PromoCode{PromoCodeInsert: entity.PromoCodeInsert{
+			Discount:     decimal.NewFromInt(50),
+			FreeShipping: true,
+		}}
+		tax, err := calculateOrderTax(ctx, db, "LV", items, promo, decimal.NewFromInt(100))
+		assert.NoError(t, err)
+		// the category rate is replaced with the default one
+		assert.True(t, tax.Items[0].Rate.Equal(decimal.NewFromInt(10)))
+		assert.True(t, tax.Items[0].Amount.Equal(decimal.NewFromFloat(5.6)))
+		assert.True(t, tax.Items[1].Amount.Equal(decimal.NewFromFloat(12.1)))
+		assert.True(t, tax.Shipping.IsZero())
+		assert.True(t, tax.Surcharge().Equal(decimal.NewFromFloat(17.7)))
+	})
+
+	rules, err := db.GetTaxRules(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, rules, 1)
+	assert.Empty(t, rules[0].CategoryRates)
+
+	assert.NoError(t, db.DeleteTaxRule(ctx, "lv"))
+	tax, err := calculateOrderTax(ctx, db, "LV", items, entity.PromoCode{}, decimal.NewFromInt(100))
+	assert.NoError(t, err)
+	assert.True(t, tax.Total.IsZero())
+	assert.Len(t, tax.Items, 2)
+}
+
+func TestOrderTax(t *testing.T) {
+	db := newTestDB(t)
+	ctx := context.Background()
+
+	p, xlSize, _, ok := addProductWithSizes(ctx, t, db, 4, 4)
+	if !ok {
+		return
+	}
+	// the test orders are shipped to the US
+	err := db.SetTaxRule(ctx, &entity.TaxRule{Country: "US", DefaultRate: decimal.NewFromInt(10)})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	no, sc, err := newOrder([]entity.OrderItemInsert{
+		{ProductId: p.Product.Id, Quantity: decimal.NewFromInt(2), SizeId: xlSize.Id},
+	}, "", 1)
+	if !assert.NoError(t, err) {
+		return
+	}
+	order, _, err := db.Order().CreateOrder(ctx, no, false)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// storedTax checks the tax stored on the item and on the order, the exclusive tax is added to the total,
+	// it returns the price of the item
+	storedTax := func() decimal.Decimal {
+		o, err := getOrderByUUID(ctx, db, order.UUID)
+		if !assert.NoError(t, err) {
+			return decimal.Zero
+		}
+		items, err := getOrderItemsInsert(ctx, db, o.Id)
+		if !assert.NoError(t, err) || !assert.Len(t, items, 1) {
+			return decimal.Zero
+		}
+
+		subtotal := items[0].ProductPriceWithSale.Mul(items[0].Quantity).Round(2)
+		itemTax := subtotal.Mul(decimal.NewFromInt(10)).Div(decimal.NewFromInt(100)).Round(2)
+		shippingTax := sc.PriceDecimal().Mul(decimal.NewFromInt(10)).Div(decimal.NewFromInt(100)).Round(2)
+
+		assert.True(t, items[0].TaxRate.Equal(decimal.NewFromInt(10)), items[0].TaxRate.String())
+		assert.True(t, items[0].TaxAmount.Equal(itemTax), items[0].TaxAmount.String())
+		assert.True(t, o.TaxAmount.Equal(itemTax.Add(shippingTax)), o.TaxAmount.String())
+		assert.False(t, o.TaxInclusive)
+		want := subtotal.Add(sc.PriceDecimal()).Add(itemTax).Add(shippingTax).Round(2)
+		assert.True(t, o.TotalPriceDecimal().Equal(want), "total %s, want %s", o.TotalPriceDecimal().String(), want.String())
+		return items[0].ProductPrice
+	}
+	price := storedTax()
+
+	// the order validated after the price change is repriced with its tax
+	err = ExecNamed(ctx, db.DB(), `UPDATE product SET price = price * 2 WHERE id = :id`, map[string]any{
+		"id": p.Product.Id,
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, err = db.Order().ValidateOrderByUUID(ctx, order.UUID)
+	// the items are updated
+	assert.Error(t, err)
+	assert.True(t, storedTax().Equal(price.Mul(decimal.NewFromInt(2))))
+}